@@ -0,0 +1,63 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocationStatusTransitions(t *testing.T) {
+	legal := []struct{ from, to AllocationStatus }{
+		{"", AllocationStatusCreating},
+		{AllocationStatusCreating, AllocationStatusCreating},
+		{AllocationStatusCreating, AllocationStatusCreated},
+		{AllocationStatusCreating, AllocationStatusFailed},
+		{AllocationStatusCreating, AllocationStatusDeleting},
+		{AllocationStatusCreating, AllocationStatusPreempted},
+		{AllocationStatusCreating, AllocationStatusRetained},
+		{AllocationStatusCreated, AllocationStatusUngated},
+		{AllocationStatusCreated, AllocationStatusDeleting},
+		{AllocationStatusCreated, AllocationStatusPreempted},
+		{AllocationStatusCreated, AllocationStatusRetained},
+		{AllocationStatusUngated, AllocationStatusDeleting},
+		{AllocationStatusUngated, AllocationStatusPreempted},
+		{AllocationStatusUngated, AllocationStatusRetained},
+		{AllocationStatusDeleting, AllocationStatusDeleted},
+		{AllocationStatusDeleted, AllocationStatusCreating},
+		{AllocationStatusFailed, AllocationStatusDeleting},
+		{AllocationStatusPreempted, AllocationStatusDeleting},
+		{AllocationStatusPreempted, AllocationStatusDeleted},
+		{AllocationStatusRetained, AllocationStatusDeleting},
+	}
+	for _, transition := range legal {
+		assert.True(t, transition.from.CanTransitionTo(transition.to), "%q to %q", transition.from, transition.to)
+	}
+
+	illegal := []struct{ from, to AllocationStatus }{
+		{AllocationStatusCreated, AllocationStatusCreating},
+		{AllocationStatusUngated, AllocationStatusCreated},
+		{AllocationStatusDeleting, AllocationStatusCreated},
+		{AllocationStatusDeleting, AllocationStatusCreating},
+		{AllocationStatusFailed, AllocationStatusCreated},
+		{AllocationStatusCreating, AllocationStatusUngated},
+	}
+	for _, transition := range illegal {
+		assert.False(t, transition.from.CanTransitionTo(transition.to), "%q to %q", transition.from, transition.to)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupWebhookWithManager registers the conversion webhook of Instaslice with the manager.
+func (r *Instaslice) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// SetupValidationWithManager registers the webhook validating Instaslices with the manager. A change is admitted
+// when every one of the validators admits it.
+func SetupValidationWithManager(mgr ctrl.Manager, validators ...admission.CustomValidator) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&Instaslice{}).
+		WithValidator(Validators(validators)).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-inference-codeflare-dev-v1alpha1-instaslice,mutating=false,failurePolicy=fail,sideEffects=None,groups=inference.codeflare.dev,resources=instaslices;instaslices/status,verbs=create;update,versions=v1alpha1,name=vinstaslice.kb.io,admissionReviewVersions=v1
+
+// Validators chains validators of Instaslices, all of them share the single validating webhook of the type.
+type Validators []admission.CustomValidator
+
+var _ admission.CustomValidator = Validators{}
+
+// ValidateCreate admits a new Instaslice when every validator admits it.
+func (vs Validators) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	var warnings admission.Warnings
+	for _, v := range vs {
+		w, err := v.ValidateCreate(ctx, obj)
+		warnings = append(warnings, w...)
+		if err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
+
+// ValidateUpdate admits an update when every validator admits it.
+func (vs Validators) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	var warnings admission.Warnings
+	for _, v := range vs {
+		w, err := v.ValidateUpdate(ctx, oldObj, newObj)
+		warnings = append(warnings, w...)
+		if err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
+
+// ValidateDelete admits a deletion when every validator admits it.
+func (vs Validators) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	var warnings admission.Warnings
+	for _, v := range vs {
+		w, err := v.ValidateDelete(ctx, obj)
+		warnings = append(warnings, w...)
+		if err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
+
+// ProcessedValidator rejects updates changing status.processed of an Instaslice unless they are made by one of the
+// Writers, the users of the operator, e.g. system:serviceaccount:<namespace>:<service account>. The daemonset
+// discovers the GPUs of its node while the field is unset, a stray edit would skip or re-trigger discovery.
+type ProcessedValidator struct {
+	Writers []string
+}
+
+var _ admission.CustomValidator = &ProcessedValidator{}
+
+// ValidateCreate admits every new Instaslice, the daemonset creates them on discovery.
+func (v *ProcessedValidator) ValidateCreate(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// ValidateUpdate rejects updates changing status.processed made by anyone but the writers.
+func (v *ProcessedValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldInstaslice, ok := oldObj.(*Instaslice)
+	if !ok {
+		return nil, fmt.Errorf("expected an Instaslice, got %T", oldObj)
+	}
+	newInstaslice, ok := newObj.(*Instaslice)
+	if !ok {
+		return nil, fmt.Errorf("expected an Instaslice, got %T", newObj)
+	}
+	if oldInstaslice.Status.Processed == newInstaslice.Status.Processed {
+		return nil, nil
+	}
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, writer := range v.Writers {
+		if req.UserInfo.Username == writer {
+			return nil, nil
+		}
+	}
+	return nil, apierrors.NewForbidden(GroupVersion.WithResource("instaslices").GroupResource(), newInstaslice.Name,
+		fmt.Errorf("status.processed can only be changed by the operator, not by %s", req.UserInfo.Username))
+}
+
+// ValidateDelete admits every deletion.
+func (v *ProcessedValidator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// SingleAllocationValidator rejects Instaslices holding several allocations for the same pod UUID under different
+// keys, unless all of them are marked MultiSlice. Slices are accounted per allocation, a second allocation of a pod
+// written by a buggy client would count its slice twice. Allocations already in place are left alone, only
+// changes adding an allocation to a pod holding one are rejected, so that the operator can still clean them up.
+type SingleAllocationValidator struct{}
+
+var _ admission.CustomValidator = &SingleAllocationValidator{}
+
+// allocationsPerPod counts the allocations of each pod UUID of the Instaslice, and tells whether all the allocations
+// of the pod UUID are marked MultiSlice.
+func allocationsPerPod(instaslice *Instaslice) (map[string]int, map[string]bool) {
+	counts := make(map[string]int)
+	multiSlice := make(map[string]bool)
+	for _, allocation := range instaslice.Spec.Allocations {
+		if counts[allocation.PodUUID] == 0 {
+			multiSlice[allocation.PodUUID] = allocation.MultiSlice
+		} else {
+			multiSlice[allocation.PodUUID] = multiSlice[allocation.PodUUID] && allocation.MultiSlice
+		}
+		counts[allocation.PodUUID]++
+	}
+	return counts, multiSlice
+}
+
+// validateAllocations rejects the new Instaslice when a pod UUID has more allocations than in the old one, and more
+// than one, without all of them being marked MultiSlice. The old Instaslice is nil on creation.
+func validateAllocations(oldInstaslice, newInstaslice *Instaslice) error {
+	oldCounts := map[string]int{}
+	if oldInstaslice != nil {
+		oldCounts, _ = allocationsPerPod(oldInstaslice)
+	}
+	newCounts, multiSlice := allocationsPerPod(newInstaslice)
+	var duplicated []string
+	for podUUID, count := range newCounts {
+		if count > 1 && count > oldCounts[podUUID] && !multiSlice[podUUID] {
+			duplicated = append(duplicated, podUUID)
+		}
+	}
+	if len(duplicated) == 0 {
+		return nil
+	}
+	sort.Strings(duplicated)
+	return apierrors.NewForbidden(GroupVersion.WithResource("instaslices").GroupResource(), newInstaslice.Name,
+		fmt.Errorf("pods hold a single allocation unless they ask for several slices, pod UUIDs with more than one: %s",
+			strings.Join(duplicated, ", ")))
+}
+
+// ValidateCreate rejects new Instaslices holding several allocations for a pod not asking for several slices.
+func (v *SingleAllocationValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	instaslice, ok := obj.(*Instaslice)
+	if !ok {
+		return nil, fmt.Errorf("expected an Instaslice, got %T", obj)
+	}
+	return nil, validateAllocations(nil, instaslice)
+}
+
+// ValidateUpdate rejects updates adding an allocation to a pod already holding one, unless the pod asks for several
+// slices.
+func (v *SingleAllocationValidator) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldInstaslice, ok := oldObj.(*Instaslice)
+	if !ok {
+		return nil, fmt.Errorf("expected an Instaslice, got %T", oldObj)
+	}
+	newInstaslice, ok := newObj.(*Instaslice)
+	if !ok {
+		return nil, fmt.Errorf("expected an Instaslice, got %T", newObj)
+	}
+	return nil, validateAllocations(oldInstaslice, newInstaslice)
+}
+
+// ValidateDelete admits every deletion.
+func (v *SingleAllocationValidator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
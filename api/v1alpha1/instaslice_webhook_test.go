@@ -0,0 +1,145 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const operatorServiceAccount = "system:serviceaccount:instaslicev2-system:instaslicev2-controller-manager"
+
+// contextOf returns the context of an admission request made by the user.
+func contextOf(username string) context.Context {
+	return admission.NewContextWithRequest(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{UserInfo: authenticationv1.UserInfo{Username: username}},
+	})
+}
+
+func TestProcessedCanOnlyBeChangedByTheOperator(t *testing.T) {
+	validator := &ProcessedValidator{Writers: []string{operatorServiceAccount}}
+	oldInstaslice := &Instaslice{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"}}
+	newInstaslice := oldInstaslice.DeepCopy()
+	newInstaslice.Status.Processed = "true"
+
+	_, err := validator.ValidateUpdate(contextOf("kubernetes-admin"), oldInstaslice, newInstaslice)
+	assert.True(t, apierrors.IsForbidden(err), "%v", err)
+
+	_, err = validator.ValidateUpdate(contextOf(operatorServiceAccount), oldInstaslice, newInstaslice)
+	assert.NoError(t, err)
+}
+
+func TestEditsLeavingProcessedAloneAreAdmitted(t *testing.T) {
+	validator := &ProcessedValidator{Writers: []string{operatorServiceAccount}}
+	oldInstaslice := &Instaslice{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"}}
+	oldInstaslice.Status.Processed = "true"
+	newInstaslice := oldInstaslice.DeepCopy()
+	newInstaslice.Spec.CordonedGPUs = []string{"GPU-1"}
+
+	_, err := validator.ValidateUpdate(contextOf("kubernetes-admin"), oldInstaslice, newInstaslice)
+	assert.NoError(t, err)
+	_, err = validator.ValidateCreate(contextOf("kubernetes-admin"), newInstaslice)
+	assert.NoError(t, err)
+	_, err = validator.ValidateDelete(contextOf("kubernetes-admin"), newInstaslice)
+	assert.NoError(t, err)
+}
+
+// newSingleAllocationTestInstaslice returns an Instaslice holding an allocation of pod-uid-1.
+func newSingleAllocationTestInstaslice() *Instaslice {
+	return &Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"},
+		Spec: InstasliceSpec{Allocations: map[string]AllocationDetails{
+			"pod-uid-1": {Profile: "1g.5gb", PodUUID: "pod-uid-1", GPUUUID: "GPU-1", Start: 0, Size: 1},
+		}},
+	}
+}
+
+func TestSecondAllocationForTheSamePodIsRejected(t *testing.T) {
+	validator := &SingleAllocationValidator{}
+	oldInstaslice := newSingleAllocationTestInstaslice()
+	newInstaslice := oldInstaslice.DeepCopy()
+	newInstaslice.Spec.Allocations["other-key"] = AllocationDetails{Profile: "1g.5gb", PodUUID: "pod-uid-1", GPUUUID: "GPU-1", Start: 1, Size: 1}
+
+	_, err := validator.ValidateUpdate(contextOf("kubernetes-admin"), oldInstaslice, newInstaslice)
+	assert.True(t, apierrors.IsForbidden(err), "%v", err)
+	assert.ErrorContains(t, err, "pod-uid-1")
+	_, err = validator.ValidateCreate(contextOf("kubernetes-admin"), newInstaslice)
+	assert.True(t, apierrors.IsForbidden(err), "%v", err)
+
+	// allocations of other pods are admitted
+	newInstaslice = oldInstaslice.DeepCopy()
+	newInstaslice.Spec.Allocations["pod-uid-2"] = AllocationDetails{Profile: "1g.5gb", PodUUID: "pod-uid-2", GPUUUID: "GPU-1", Start: 1, Size: 1}
+	_, err = validator.ValidateUpdate(contextOf("kubernetes-admin"), oldInstaslice, newInstaslice)
+	assert.NoError(t, err)
+}
+
+func TestSeveralAllocationsAreAdmittedForMultiSlicePods(t *testing.T) {
+	validator := &SingleAllocationValidator{}
+	oldInstaslice := newSingleAllocationTestInstaslice()
+	allocation := oldInstaslice.Spec.Allocations["pod-uid-1"]
+	allocation.MultiSlice = true
+	oldInstaslice.Spec.Allocations["pod-uid-1"] = allocation
+	newInstaslice := oldInstaslice.DeepCopy()
+	newInstaslice.Spec.Allocations["pod-uid-1-1"] = AllocationDetails{Profile: "1g.5gb", PodUUID: "pod-uid-1", GPUUUID: "GPU-1", Start: 1, Size: 1, MultiSlice: true}
+
+	_, err := validator.ValidateUpdate(contextOf("kubernetes-admin"), oldInstaslice, newInstaslice)
+	assert.NoError(t, err)
+
+	// every allocation of the pod has to ask for several slices
+	newInstaslice.Spec.Allocations["pod-uid-1-2"] = AllocationDetails{Profile: "1g.5gb", PodUUID: "pod-uid-1", GPUUUID: "GPU-1", Start: 2, Size: 1}
+	_, err = validator.ValidateUpdate(contextOf("kubernetes-admin"), oldInstaslice, newInstaslice)
+	assert.True(t, apierrors.IsForbidden(err), "%v", err)
+}
+
+func TestExistingDuplicateAllocationsDoNotBlockUpdates(t *testing.T) {
+	validator := &SingleAllocationValidator{}
+	oldInstaslice := newSingleAllocationTestInstaslice()
+	oldInstaslice.Spec.Allocations["other-key"] = AllocationDetails{Profile: "1g.5gb", PodUUID: "pod-uid-1", GPUUUID: "GPU-1", Start: 1, Size: 1}
+
+	newInstaslice := oldInstaslice.DeepCopy()
+	newInstaslice.Spec.CordonedGPUs = []string{"GPU-1"}
+	_, err := validator.ValidateUpdate(contextOf("kubernetes-admin"), oldInstaslice, newInstaslice)
+	assert.NoError(t, err)
+
+	delete(newInstaslice.Spec.Allocations, "other-key")
+	_, err = validator.ValidateUpdate(contextOf("kubernetes-admin"), oldInstaslice, newInstaslice)
+	assert.NoError(t, err)
+}
+
+func TestValidatorsAdmitOnlyWhatEveryValidatorAdmits(t *testing.T) {
+	validators := Validators{&ProcessedValidator{Writers: []string{operatorServiceAccount}}, &SingleAllocationValidator{}}
+	oldInstaslice := newSingleAllocationTestInstaslice()
+	newInstaslice := oldInstaslice.DeepCopy()
+	newInstaslice.Spec.Allocations["other-key"] = AllocationDetails{Profile: "1g.5gb", PodUUID: "pod-uid-1", GPUUUID: "GPU-1", Start: 1, Size: 1}
+
+	_, err := validators.ValidateUpdate(contextOf(operatorServiceAccount), oldInstaslice, newInstaslice)
+	assert.True(t, apierrors.IsForbidden(err), "%v", err)
+
+	newInstaslice = oldInstaslice.DeepCopy()
+	newInstaslice.Status.Processed = "true"
+	_, err = validators.ValidateUpdate(contextOf("kubernetes-admin"), oldInstaslice, newInstaslice)
+	assert.True(t, apierrors.IsForbidden(err), "%v", err)
+	_, err = validators.ValidateUpdate(contextOf(operatorServiceAccount), oldInstaslice, newInstaslice)
+	assert.NoError(t, err)
+}
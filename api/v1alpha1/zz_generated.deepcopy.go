@@ -21,12 +21,40 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AffectedPod) DeepCopyInto(out *AffectedPod) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AffectedPod.
+func (in *AffectedPod) DeepCopy() *AffectedPod {
+	if in == nil {
+		return nil
+	}
+	out := new(AffectedPod)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AllocationDetails) DeepCopyInto(out *AllocationDetails) {
 	*out = *in
+	if in.RetainedAt != nil {
+		in, out := &in.RetainedAt, &out.RetainedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.DeletedAt != nil {
+		in, out := &in.DeletedAt, &out.DeletedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AllocationDetails.
@@ -39,13 +67,39 @@ func (in *AllocationDetails) DeepCopy() *AllocationDetails {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ForceCleanup) DeepCopyInto(out *ForceCleanup) {
+	*out = *in
+	if in.MigUUIDs != nil {
+		in, out := &in.MigUUIDs, &out.MigUUIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Errors != nil {
+		in, out := &in.Errors, &out.Errors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.CleanedAt.DeepCopyInto(&out.CleanedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ForceCleanup.
+func (in *ForceCleanup) DeepCopy() *ForceCleanup {
+	if in == nil {
+		return nil
+	}
+	out := new(ForceCleanup)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Instaslice) DeepCopyInto(out *Instaslice) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Instaslice.
@@ -112,7 +166,7 @@ func (in *InstasliceSpec) DeepCopyInto(out *InstasliceSpec) {
 		in, out := &in.Allocations, &out.Allocations
 		*out = make(map[string]AllocationDetails, len(*in))
 		for key, val := range *in {
-			(*out)[key] = val
+			(*out)[key] = *val.DeepCopy()
 		}
 	}
 	if in.Prepared != nil {
@@ -129,6 +183,65 @@ func (in *InstasliceSpec) DeepCopyInto(out *InstasliceSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.RetainedSliceTTL != nil {
+		in, out := &in.RetainedSliceTTL, &out.RetainedSliceTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.DeletionGracePeriod != nil {
+		in, out := &in.DeletionGracePeriod, &out.DeletionGracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.DesiredLayouts != nil {
+		in, out := &in.DesiredLayouts, &out.DesiredLayouts
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.CordonedGPUs != nil {
+		in, out := &in.CordonedGPUs, &out.CordonedGPUs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedProfiles != nil {
+		in, out := &in.AllowedProfiles, &out.AllowedProfiles
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.SlicePools != nil {
+		in, out := &in.SlicePools, &out.SlicePools
+		*out = make([]SlicePool, len(*in))
+		copy(*out, *in)
+	}
+	if in.PinnedNamespaces != nil {
+		in, out := &in.PinnedNamespaces, &out.PinnedNamespaces
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstasliceSpec.
@@ -144,6 +257,154 @@ func (in *InstasliceSpec) DeepCopy() *InstasliceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *InstasliceStatus) DeepCopyInto(out *InstasliceStatus) {
 	*out = *in
+	if in.LastSliceCreationDuration != nil {
+		in, out := &in.LastSliceCreationDuration, &out.LastSliceCreationDuration
+		*out = make(map[string]v1.Duration, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.LastReconcileTime != nil {
+		in, out := &in.LastReconcileTime, &out.LastReconcileTime
+		*out = (*in).DeepCopy()
+	}
+	if in.AvailableSlices != nil {
+		in, out := &in.AvailableSlices, &out.AvailableSlices
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.MigEnabled != nil {
+		in, out := &in.MigEnabled, &out.MigEnabled
+		*out = make(map[string]bool, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.CarvedSlices != nil {
+		in, out := &in.CarvedSlices, &out.CarvedSlices
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.XidErrors != nil {
+		in, out := &in.XidErrors, &out.XidErrors
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ResetPendingGPUs != nil {
+		in, out := &in.ResetPendingGPUs, &out.ResetPendingGPUs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Prepared != nil {
+		in, out := &in.Prepared, &out.Prepared
+		*out = make(map[string]PreparedDetails, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.QuarantinedPrepared != nil {
+		in, out := &in.QuarantinedPrepared, &out.QuarantinedPrepared
+		*out = make(map[string]PreparedDetails, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.MigUUIDs != nil {
+		in, out := &in.MigUUIDs, &out.MigUUIDs
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.NVLinkPeers != nil {
+		in, out := &in.NVLinkPeers, &out.NVLinkPeers
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.OccupiedRanges != nil {
+		in, out := &in.OccupiedRanges, &out.OccupiedRanges
+		*out = make(map[string][]SliceRange, len(*in))
+		for key, val := range *in {
+			var outVal []SliceRange
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]SliceRange, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.LastForceCleanup != nil {
+		in, out := &in.LastForceCleanup, &out.LastForceCleanup
+		*out = new(ForceCleanup)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UnschedulableOnNode != nil {
+		in, out := &in.UnschedulableOnNode, &out.UnschedulableOnNode
+		*out = make(map[string]UnschedulablePod, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.AffectedPods != nil {
+		in, out := &in.AffectedPods, &out.AffectedPods
+		*out = make(map[string][]AffectedPod, len(*in))
+		for key, val := range *in {
+			var outVal []AffectedPod
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]AffectedPod, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.SliceIntents != nil {
+		in, out := &in.SliceIntents, &out.SliceIntents
+		*out = make(map[string]SliceIntent, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstasliceStatus.
@@ -205,3 +466,65 @@ func (in *PreparedDetails) DeepCopy() *PreparedDetails {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SliceIntent) DeepCopyInto(out *SliceIntent) {
+	*out = *in
+	in.Since.DeepCopyInto(&out.Since)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SliceIntent.
+func (in *SliceIntent) DeepCopy() *SliceIntent {
+	if in == nil {
+		return nil
+	}
+	out := new(SliceIntent)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SlicePool) DeepCopyInto(out *SlicePool) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SlicePool.
+func (in *SlicePool) DeepCopy() *SlicePool {
+	if in == nil {
+		return nil
+	}
+	out := new(SlicePool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SliceRange) DeepCopyInto(out *SliceRange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SliceRange.
+func (in *SliceRange) DeepCopy() *SliceRange {
+	if in == nil {
+		return nil
+	}
+	out := new(SliceRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UnschedulablePod) DeepCopyInto(out *UnschedulablePod) {
+	*out = *in
+	in.Since.DeepCopyInto(&out.Since)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UnschedulablePod.
+func (in *UnschedulablePod) DeepCopy() *UnschedulablePod {
+	if in == nil {
+		return nil
+	}
+	out := new(UnschedulablePod)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// ConvertTo converts this Instaslice to the hub version, v1alpha1, where the discovered GPUs and profiles
+// are still kept in the spec.
+func (src *Instaslice) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.Instaslice)
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec.MigGPUUUID = src.Status.MigGPUUUID
+	dst.Spec.Migplacement = nil
+	if src.Status.Migplacement != nil {
+		dst.Spec.Migplacement = make([]v1alpha1.Mig, 0, len(src.Status.Migplacement))
+	}
+	for _, mig := range src.Status.Migplacement {
+		converted := v1alpha1.Mig{
+			Profile:        mig.Profile,
+			Giprofileid:    mig.Giprofileid,
+			CIProfileID:    mig.CIProfileID,
+			CIEngProfileID: mig.CIEngProfileID,
+		}
+		if mig.Placements != nil {
+			converted.Placements = make([]v1alpha1.Placement, 0, len(mig.Placements))
+		}
+		for _, placement := range mig.Placements {
+			converted.Placements = append(converted.Placements, v1alpha1.Placement(placement))
+		}
+		dst.Spec.Migplacement = append(dst.Spec.Migplacement, converted)
+	}
+	dst.Spec.Allocations = nil
+	if src.Spec.Allocations != nil {
+		dst.Spec.Allocations = make(map[string]v1alpha1.AllocationDetails, len(src.Spec.Allocations))
+		for podUUID, allocation := range src.Spec.Allocations {
+			dst.Spec.Allocations[podUUID] = v1alpha1.AllocationDetails(allocation)
+		}
+	}
+	dst.Spec.Prepared = nil
+	if src.Spec.Prepared != nil {
+		dst.Spec.Prepared = make(map[string]v1alpha1.PreparedDetails, len(src.Spec.Prepared))
+		for migUUID, prepared := range src.Spec.Prepared {
+			dst.Spec.Prepared[migUUID] = v1alpha1.PreparedDetails(prepared)
+		}
+	}
+	dst.Spec.ReservedSlicesPerGPU = src.Spec.ReservedSlicesPerGPU
+	dst.Spec.ReservedMemoryGBPerGPU = src.Spec.ReservedMemoryGBPerGPU
+	dst.Spec.RetainSlices = src.Spec.RetainSlices
+	dst.Spec.RetainedSliceTTL = src.Spec.RetainedSliceTTL
+	dst.Spec.DeletionGracePeriod = src.Spec.DeletionGracePeriod
+	dst.Spec.DesiredLayouts = src.Spec.DesiredLayouts
+	dst.Spec.PreemptForLayout = src.Spec.PreemptForLayout
+	dst.Spec.CordonedGPUs = src.Spec.CordonedGPUs
+	dst.Spec.AllowedProfiles = src.Spec.AllowedProfiles
+	dst.Spec.SlicePools = nil
+	if src.Spec.SlicePools != nil {
+		dst.Spec.SlicePools = make([]v1alpha1.SlicePool, 0, len(src.Spec.SlicePools))
+	}
+	for _, pool := range src.Spec.SlicePools {
+		dst.Spec.SlicePools = append(dst.Spec.SlicePools, v1alpha1.SlicePool(pool))
+	}
+	dst.Spec.PinnedNamespaces = src.Spec.PinnedNamespaces
+
+	dst.Status.Processed = src.Status.Processed
+	dst.Status.LastSliceCreationDuration = src.Status.LastSliceCreationDuration
+	dst.Status.LastReconcileTime = src.Status.LastReconcileTime
+	dst.Status.DriverVersion = src.Status.DriverVersion
+	dst.Status.Summary = src.Status.Summary
+	dst.Status.AvailableSlices = src.Status.AvailableSlices
+	dst.Status.TotalSlices = src.Status.TotalSlices
+	dst.Status.UsedSlices = src.Status.UsedSlices
+	dst.Status.FreeSlices = src.Status.FreeSlices
+	dst.Status.MigEnabled = src.Status.MigEnabled
+	dst.Status.CarvedSlices = src.Status.CarvedSlices
+	dst.Status.XidErrors = src.Status.XidErrors
+	dst.Status.ResetPendingGPUs = src.Status.ResetPendingGPUs
+	dst.Status.Prepared = nil
+	if src.Status.Prepared != nil {
+		dst.Status.Prepared = make(map[string]v1alpha1.PreparedDetails, len(src.Status.Prepared))
+		for migUUID, prepared := range src.Status.Prepared {
+			dst.Status.Prepared[migUUID] = v1alpha1.PreparedDetails(prepared)
+		}
+	}
+	dst.Status.QuarantinedPrepared = nil
+	if src.Status.QuarantinedPrepared != nil {
+		dst.Status.QuarantinedPrepared = make(map[string]v1alpha1.PreparedDetails, len(src.Status.QuarantinedPrepared))
+		for migUUID, prepared := range src.Status.QuarantinedPrepared {
+			dst.Status.QuarantinedPrepared[migUUID] = v1alpha1.PreparedDetails(prepared)
+		}
+	}
+	dst.Status.MigUUIDs = src.Status.MigUUIDs
+	dst.Status.NVLinkPeers = src.Status.NVLinkPeers
+	dst.Status.OccupiedRanges = nil
+	if src.Status.OccupiedRanges != nil {
+		dst.Status.OccupiedRanges = make(map[string][]v1alpha1.SliceRange, len(src.Status.OccupiedRanges))
+		for gpuUUID, ranges := range src.Status.OccupiedRanges {
+			var converted []v1alpha1.SliceRange
+			if ranges != nil {
+				converted = make([]v1alpha1.SliceRange, 0, len(ranges))
+			}
+			for _, sliceRange := range ranges {
+				converted = append(converted, v1alpha1.SliceRange(sliceRange))
+			}
+			dst.Status.OccupiedRanges[gpuUUID] = converted
+		}
+	}
+	dst.Status.LastForceCleanup = (*v1alpha1.ForceCleanup)(src.Status.LastForceCleanup)
+	dst.Status.UnschedulableOnNode = nil
+	if src.Status.UnschedulableOnNode != nil {
+		dst.Status.UnschedulableOnNode = make(map[string]v1alpha1.UnschedulablePod, len(src.Status.UnschedulableOnNode))
+		for podUUID, unschedulable := range src.Status.UnschedulableOnNode {
+			dst.Status.UnschedulableOnNode[podUUID] = v1alpha1.UnschedulablePod(unschedulable)
+		}
+	}
+	dst.Status.SliceIntents = nil
+	if src.Status.SliceIntents != nil {
+		dst.Status.SliceIntents = make(map[string]v1alpha1.SliceIntent, len(src.Status.SliceIntents))
+		for podUUID, intent := range src.Status.SliceIntents {
+			dst.Status.SliceIntents[podUUID] = v1alpha1.SliceIntent(intent)
+		}
+	}
+	dst.Status.AffectedPods = nil
+	if src.Status.AffectedPods != nil {
+		dst.Status.AffectedPods = make(map[string][]v1alpha1.AffectedPod, len(src.Status.AffectedPods))
+		for gpuUUID, pods := range src.Status.AffectedPods {
+			var converted []v1alpha1.AffectedPod
+			if pods != nil {
+				converted = make([]v1alpha1.AffectedPod, 0, len(pods))
+			}
+			for _, pod := range pods {
+				converted = append(converted, v1alpha1.AffectedPod(pod))
+			}
+			dst.Status.AffectedPods[gpuUUID] = converted
+		}
+	}
+	dst.Status.Conditions = src.Status.Conditions
+	return nil
+}
+
+// ConvertFrom converts from the hub version, v1alpha1, to this version, moving the discovered GPUs and profiles
+// from the spec to the status.
+func (dst *Instaslice) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.Instaslice)
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec.Allocations = nil
+	if src.Spec.Allocations != nil {
+		dst.Spec.Allocations = make(map[string]AllocationDetails, len(src.Spec.Allocations))
+		for podUUID, allocation := range src.Spec.Allocations {
+			dst.Spec.Allocations[podUUID] = AllocationDetails(allocation)
+		}
+	}
+	dst.Spec.Prepared = nil
+	if src.Spec.Prepared != nil {
+		dst.Spec.Prepared = make(map[string]PreparedDetails, len(src.Spec.Prepared))
+		for migUUID, prepared := range src.Spec.Prepared {
+			dst.Spec.Prepared[migUUID] = PreparedDetails(prepared)
+		}
+	}
+	dst.Spec.ReservedSlicesPerGPU = src.Spec.ReservedSlicesPerGPU
+	dst.Spec.ReservedMemoryGBPerGPU = src.Spec.ReservedMemoryGBPerGPU
+	dst.Spec.RetainSlices = src.Spec.RetainSlices
+	dst.Spec.RetainedSliceTTL = src.Spec.RetainedSliceTTL
+	dst.Spec.DeletionGracePeriod = src.Spec.DeletionGracePeriod
+	dst.Spec.DesiredLayouts = src.Spec.DesiredLayouts
+	dst.Spec.PreemptForLayout = src.Spec.PreemptForLayout
+	dst.Spec.CordonedGPUs = src.Spec.CordonedGPUs
+	dst.Spec.AllowedProfiles = src.Spec.AllowedProfiles
+	dst.Spec.SlicePools = nil
+	if src.Spec.SlicePools != nil {
+		dst.Spec.SlicePools = make([]SlicePool, 0, len(src.Spec.SlicePools))
+	}
+	for _, pool := range src.Spec.SlicePools {
+		dst.Spec.SlicePools = append(dst.Spec.SlicePools, SlicePool(pool))
+	}
+	dst.Spec.PinnedNamespaces = src.Spec.PinnedNamespaces
+
+	dst.Status.Processed = src.Status.Processed
+	dst.Status.MigGPUUUID = src.Spec.MigGPUUUID
+	dst.Status.Migplacement = nil
+	if src.Spec.Migplacement != nil {
+		dst.Status.Migplacement = make([]Mig, 0, len(src.Spec.Migplacement))
+	}
+	for _, mig := range src.Spec.Migplacement {
+		converted := Mig{
+			Profile:        mig.Profile,
+			Giprofileid:    mig.Giprofileid,
+			CIProfileID:    mig.CIProfileID,
+			CIEngProfileID: mig.CIEngProfileID,
+		}
+		if mig.Placements != nil {
+			converted.Placements = make([]Placement, 0, len(mig.Placements))
+		}
+		for _, placement := range mig.Placements {
+			converted.Placements = append(converted.Placements, Placement(placement))
+		}
+		dst.Status.Migplacement = append(dst.Status.Migplacement, converted)
+	}
+	dst.Status.LastSliceCreationDuration = src.Status.LastSliceCreationDuration
+	dst.Status.LastReconcileTime = src.Status.LastReconcileTime
+	dst.Status.DriverVersion = src.Status.DriverVersion
+	dst.Status.Summary = src.Status.Summary
+	dst.Status.AvailableSlices = src.Status.AvailableSlices
+	dst.Status.TotalSlices = src.Status.TotalSlices
+	dst.Status.UsedSlices = src.Status.UsedSlices
+	dst.Status.FreeSlices = src.Status.FreeSlices
+	dst.Status.MigEnabled = src.Status.MigEnabled
+	dst.Status.CarvedSlices = src.Status.CarvedSlices
+	dst.Status.XidErrors = src.Status.XidErrors
+	dst.Status.ResetPendingGPUs = src.Status.ResetPendingGPUs
+	dst.Status.Prepared = nil
+	if src.Status.Prepared != nil {
+		dst.Status.Prepared = make(map[string]PreparedDetails, len(src.Status.Prepared))
+		for migUUID, prepared := range src.Status.Prepared {
+			dst.Status.Prepared[migUUID] = PreparedDetails(prepared)
+		}
+	}
+	dst.Status.QuarantinedPrepared = nil
+	if src.Status.QuarantinedPrepared != nil {
+		dst.Status.QuarantinedPrepared = make(map[string]PreparedDetails, len(src.Status.QuarantinedPrepared))
+		for migUUID, prepared := range src.Status.QuarantinedPrepared {
+			dst.Status.QuarantinedPrepared[migUUID] = PreparedDetails(prepared)
+		}
+	}
+	dst.Status.MigUUIDs = src.Status.MigUUIDs
+	dst.Status.NVLinkPeers = src.Status.NVLinkPeers
+	dst.Status.OccupiedRanges = nil
+	if src.Status.OccupiedRanges != nil {
+		dst.Status.OccupiedRanges = make(map[string][]SliceRange, len(src.Status.OccupiedRanges))
+		for gpuUUID, ranges := range src.Status.OccupiedRanges {
+			var converted []SliceRange
+			if ranges != nil {
+				converted = make([]SliceRange, 0, len(ranges))
+			}
+			for _, sliceRange := range ranges {
+				converted = append(converted, SliceRange(sliceRange))
+			}
+			dst.Status.OccupiedRanges[gpuUUID] = converted
+		}
+	}
+	dst.Status.LastForceCleanup = (*ForceCleanup)(src.Status.LastForceCleanup)
+	dst.Status.UnschedulableOnNode = nil
+	if src.Status.UnschedulableOnNode != nil {
+		dst.Status.UnschedulableOnNode = make(map[string]UnschedulablePod, len(src.Status.UnschedulableOnNode))
+		for podUUID, unschedulable := range src.Status.UnschedulableOnNode {
+			dst.Status.UnschedulableOnNode[podUUID] = UnschedulablePod(unschedulable)
+		}
+	}
+	dst.Status.SliceIntents = nil
+	if src.Status.SliceIntents != nil {
+		dst.Status.SliceIntents = make(map[string]SliceIntent, len(src.Status.SliceIntents))
+		for podUUID, intent := range src.Status.SliceIntents {
+			dst.Status.SliceIntents[podUUID] = SliceIntent(intent)
+		}
+	}
+	dst.Status.AffectedPods = nil
+	if src.Status.AffectedPods != nil {
+		dst.Status.AffectedPods = make(map[string][]AffectedPod, len(src.Status.AffectedPods))
+		for gpuUUID, pods := range src.Status.AffectedPods {
+			var converted []AffectedPod
+			if pods != nil {
+				converted = make([]AffectedPod, 0, len(pods))
+			}
+			for _, pod := range pods {
+				converted = append(converted, AffectedPod(pod))
+			}
+			dst.Status.AffectedPods[gpuUUID] = converted
+		}
+	}
+	dst.Status.Conditions = src.Status.Conditions
+	return nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"testing"
+	"time"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func newHubInstaslice() *v1alpha1.Instaslice {
+	expiresAt := metav1.NewTime(time.Unix(1700003600, 0))
+	return &v1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default", ResourceVersion: "7"},
+		Spec: v1alpha1.InstasliceSpec{
+			MigGPUUUID: map[string]string{"GPU-1": "NVIDIA A100-SXM4-40GB"},
+			Allocations: map[string]v1alpha1.AllocationDetails{
+				"pod-uid-1": {
+					Profile:          "1g.5gb",
+					Start:            2,
+					Size:             1,
+					PodUUID:          "pod-uid-1",
+					GPUUUID:          "GPU-1",
+					Nodename:         "node-1",
+					Allocationstatus: v1alpha1.AllocationStatusCreated,
+					Giprofileid:      0,
+					CIProfileID:      0,
+					Namespace:        "default",
+					PodName:          "pod-1",
+					Priority:         10,
+					SliceGroup:       "job-1",
+					ExpiresAt:        &expiresAt,
+					TraceParent:      "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				},
+			},
+			Migplacement: []v1alpha1.Mig{
+				{
+					Profile:     "1g.5gb",
+					Giprofileid: 0,
+					Placements:  []v1alpha1.Placement{{Size: 1, Start: 0}, {Size: 1, Start: 1}},
+				},
+				{
+					Profile:     "7g.40gb",
+					Giprofileid: 4,
+					Placements:  []v1alpha1.Placement{{Size: 8, Start: 0}},
+				},
+			},
+			ReservedSlicesPerGPU:   1,
+			ReservedMemoryGBPerGPU: 5,
+			RetainSlices:           true,
+			RetainedSliceTTL:       &metav1.Duration{Duration: 5 * time.Minute},
+			DeletionGracePeriod:    &metav1.Duration{Duration: time.Minute},
+			DesiredLayouts:         map[string][]string{"GPU-1": {"2g.10gb", "2g.10gb", "2g.10gb"}},
+			PreemptForLayout:       true,
+			CordonedGPUs:           []string{"GPU-2"},
+			AllowedProfiles:        map[string][]string{"GPU-1": {"7g.40gb"}},
+			SlicePools:             []v1alpha1.SlicePool{{Profile: "1g.5gb", Replicas: 3}},
+			PinnedNamespaces:       map[string]string{"GPU-1": "team-a"},
+			Prepared:               map[string]v1alpha1.PreparedDetails{"MIG-2": {Profile: "1g.5gb", Parent: "GPU-1", Start: 3, Size: 1}},
+		},
+		Status: v1alpha1.InstasliceStatus{
+			Processed:        "true",
+			AvailableSlices:  map[string]int{"GPU-1": 5},
+			TotalSlices:      7,
+			UsedSlices:       1,
+			FreeSlices:       6,
+			MigEnabled:       map[string]bool{"GPU-1": true},
+			CarvedSlices:     map[string]int{"GPU-1": 1},
+			XidErrors:        map[string]int{"GPU-1": 2},
+			ResetPendingGPUs: []string{"GPU-2"},
+			Prepared: map[string]v1alpha1.PreparedDetails{
+				"MIG-1": {Profile: "1g.5gb", Start: 2, Size: 1, Parent: "GPU-1", PodUUID: "pod-uid-1", Giinfoid: 9, Ciinfoid: 0, GPUModel: "NVIDIA A100-SXM4-40GB"},
+			},
+			QuarantinedPrepared: map[string]v1alpha1.PreparedDetails{"MIG-9": {Profile: "1g.5gb", Parent: "GPU-1", Start: 9, Size: 1}},
+			MigUUIDs:            map[string][]string{"pod-uid-1": {"MIG-1"}},
+			NVLinkPeers:         map[string][]string{"GPU-1": {"GPU-2"}},
+			OccupiedRanges:      map[string][]v1alpha1.SliceRange{"GPU-1": {{Start: 2, Size: 2}}},
+			Conditions: []metav1.Condition{
+				{Type: "Degraded", Status: metav1.ConditionFalse, Reason: "AsExpected"},
+			},
+		},
+	}
+}
+
+func TestConvertFromMovesDiscoveredDataToStatus(t *testing.T) {
+	hub := newHubInstaslice()
+
+	var instaslice Instaslice
+	require.NoError(t, instaslice.ConvertFrom(hub))
+
+	assert.Equal(t, hub.ObjectMeta, instaslice.ObjectMeta)
+	assert.Equal(t, hub.Spec.MigGPUUUID, instaslice.Status.MigGPUUUID)
+	require.Len(t, instaslice.Status.Migplacement, 2)
+	assert.Equal(t, "7g.40gb", instaslice.Status.Migplacement[1].Profile)
+	assert.Equal(t, []Placement{{Size: 8, Start: 0}}, instaslice.Status.Migplacement[1].Placements)
+	assert.Equal(t, v1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-1"].Allocationstatus)
+	assert.Equal(t, "GPU-1", instaslice.Status.Prepared["MIG-1"].Parent)
+	assert.Equal(t, 1, instaslice.Spec.ReservedSlicesPerGPU)
+	assert.Equal(t, 7, instaslice.Status.TotalSlices)
+}
+
+func TestConversionRoundTripFromHub(t *testing.T) {
+	hub := newHubInstaslice()
+
+	var instaslice Instaslice
+	require.NoError(t, instaslice.ConvertFrom(hub))
+	var converted v1alpha1.Instaslice
+	require.NoError(t, instaslice.ConvertTo(&converted))
+
+	assert.Equal(t, *hub, converted)
+}
+
+func TestConversionRoundTripToHub(t *testing.T) {
+	var instaslice Instaslice
+	require.NoError(t, instaslice.ConvertFrom(newHubInstaslice()))
+	instaslice.Status.LastForceCleanup = &ForceCleanup{
+		PodUUID:   "pod-uid-2",
+		MigUUIDs:  []string{"MIG-2"},
+		CleanedAt: metav1.NewTime(time.Unix(1700000000, 0)),
+	}
+	instaslice.Status.UnschedulableOnNode = map[string]UnschedulablePod{
+		"pod-uid-3": {PodName: "pod-3", Namespace: "default", Profile: "7g.40gb", Reason: "NoFreePlacement", Since: metav1.NewTime(time.Unix(1700000000, 0))},
+	}
+	instaslice.Status.SliceIntents = map[string]SliceIntent{
+		"pod-uid-4": {PodName: "pod-4", GPUUUID: "GPU-1", Profile: "1g.5gb", Start: 2, Size: 1, Since: metav1.NewTime(time.Unix(1700000000, 0))},
+	}
+	instaslice.Status.AffectedPods = map[string][]AffectedPod{
+		"GPU-1": {{PodUUID: "pod-uid-5", PodName: "pod-5", Namespace: "default"}},
+	}
+
+	var hub v1alpha1.Instaslice
+	require.NoError(t, instaslice.ConvertTo(&hub))
+	assert.Equal(t, []string{"MIG-2"}, hub.Status.LastForceCleanup.MigUUIDs)
+	assert.Equal(t, "NoFreePlacement", hub.Status.UnschedulableOnNode["pod-uid-3"].Reason)
+	assert.Equal(t, uint32(2), hub.Status.SliceIntents["pod-uid-4"].Start)
+	assert.Equal(t, "pod-5", hub.Status.AffectedPods["GPU-1"][0].PodName)
+	var converted Instaslice
+	require.NoError(t, converted.ConvertFrom(&hub))
+
+	assert.Equal(t, instaslice, converted)
+}
+
+func TestConversionOfEmptyInstaslice(t *testing.T) {
+	hub := &v1alpha1.Instaslice{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+
+	var instaslice Instaslice
+	require.NoError(t, instaslice.ConvertFrom(hub))
+	assert.Nil(t, instaslice.Spec.Allocations)
+	assert.Nil(t, instaslice.Status.Migplacement)
+
+	var converted v1alpha1.Instaslice
+	require.NoError(t, instaslice.ConvertTo(&converted))
+	assert.Equal(t, *hub, converted)
+}
+
+// TestConversionRoundTripFuzz fills every field of either version with random values, so a field that is not
+// mapped by ConvertTo or ConvertFrom is lost on the way back and fails the test.
+func TestConversionRoundTripFuzz(t *testing.T) {
+	f := fuzz.New().NilChance(0.2).NumElements(0, 3)
+	for i := 0; i < 200; i++ {
+		var hub v1alpha1.Instaslice
+		f.Fuzz(&hub)
+		hub.TypeMeta = metav1.TypeMeta{}
+		var instaslice Instaslice
+		require.NoError(t, instaslice.ConvertFrom(&hub))
+		var convertedHub v1alpha1.Instaslice
+		require.NoError(t, instaslice.ConvertTo(&convertedHub))
+		require.Equal(t, hub, convertedHub)
+
+		var spoke Instaslice
+		f.Fuzz(&spoke)
+		spoke.TypeMeta = metav1.TypeMeta{}
+		var converted v1alpha1.Instaslice
+		require.NoError(t, spoke.ConvertTo(&converted))
+		var convertedSpoke Instaslice
+		require.NoError(t, convertedSpoke.ConvertFrom(&converted))
+		require.Equal(t, spoke, convertedSpoke)
+	}
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"codeflare.dev/instaslice/api/v1alpha1"
+)
+
+type Mig struct {
+	Placements     []Placement `json:"placements,omitempty"`
+	Profile        string      `json:"profile,omitempty"`
+	Giprofileid    int         `json:"giprofileid"`
+	CIProfileID    int         `json:"ciProfileid"`
+	CIEngProfileID int         `json:"ciengprofileid"`
+}
+
+type Placement struct {
+	Size  int `json:"size"`
+	Start int `json:"start"`
+}
+
+// AllocationStatus is where an allocation is in its lifecycle, the same as in v1alpha1 so that allocations convert
+// as they are.
+type AllocationStatus = v1alpha1.AllocationStatus
+
+// Define the struct for allocation details
+type AllocationDetails struct {
+	Profile          string           `json:"profile"`
+	Start            uint32           `json:"start"`
+	Size             uint32           `json:"size"`
+	PodUUID          string           `json:"podUUID"`
+	GPUUUID          string           `json:"gpuUUID"`
+	Nodename         string           `json:"nodename"`
+	Allocationstatus AllocationStatus `json:"allocationStatus"`
+	Giprofileid      int              `json:"giprofileid"`
+	CIProfileID      int              `json:"ciProfileid"`
+	CIEngProfileID   int              `json:"ciengprofileid"`
+	// ComputeInstances is the number of compute instances of CIProfileID carved in the GPU instance, each one
+	// being a MIG device of its own. Zero means a single compute instance.
+	ComputeInstances int    `json:"computeInstances,omitempty"`
+	Namespace        string `json:"namespace"`
+	PodName          string `json:"podName"`
+	// RetainedAt is when the pod completed and its slice was retained for reuse.
+	RetainedAt *metav1.Time `json:"retainedAt,omitempty"`
+	// DeletedAt is when the pod was deleted and its slice retained for the deletion grace period, only a pod
+	// re-created with the same name takes it over.
+	DeletedAt *metav1.Time `json:"deletedAt,omitempty"`
+	// ReusedFrom is the pod UUID of the retained allocation whose slice this allocation takes over.
+	ReusedFrom string `json:"reusedFrom,omitempty"`
+	// PreferredGPUUUID is the GPU the pod asked to be placed on, if any.
+	PreferredGPUUUID string `json:"preferredGpuUUID,omitempty"`
+	// PreferredGPUFallback is set when the preferred GPU had no free placement and the slice was placed on another GPU.
+	PreferredGPUFallback bool `json:"preferredGpuFallback,omitempty"`
+	// Creator identifies the scheduler or controller that wrote the allocation.
+	Creator string `json:"creator,omitempty"`
+	// Evictable lets the slice be torn down to make room for an allocation of a pod of higher priority.
+	Evictable bool `json:"evictable,omitempty"`
+	// Priority is the priority of the pod when the allocation was made.
+	Priority int32 `json:"priority,omitempty"`
+	// QOSClass is the QoS class of the pod when the allocation was made, among slices of the same priority the ones
+	// of BestEffort pods are preempted first, then the ones of Burstable pods.
+	QOSClass string `json:"qosClass,omitempty"`
+	// SliceGroup is the group of the pod, whose slices are spread over GPUs linked by NVLink when possible.
+	SliceGroup string `json:"sliceGroup,omitempty"`
+	// MultiSlice is set when the pod asked for several slices, other allocations of the same pod UUID are then
+	// admitted alongside this one.
+	MultiSlice bool `json:"multiSlice,omitempty"`
+	// ExpiresAt is when the slice is torn down and the allocation removed if it is idle by then, never when unset.
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+	// TraceParent is the W3C traceparent of the trace the pod was scheduled in, the spans of the carving and the
+	// destruction of its slice join that trace.
+	TraceParent string `json:"traceParent,omitempty"`
+}
+
+// Define the struct for allocation details
+type PreparedDetails struct {
+	Profile string `json:"profile"`
+	Start   uint32 `json:"start"`
+	Size    uint32 `json:"size"`
+	Parent  string `json:"parent"`
+	//Do we need POD UID here?
+	PodUUID  string `json:"podUUID"`
+	Giinfoid uint32 `json:"giinfo"`
+	Ciinfoid uint32 `json:"ciinfo"`
+	// GPUModel is the model of the GPU the slice is carved on, as recorded in migGPUUUID.
+	GPUModel string `json:"gpuModel,omitempty"`
+	// CarvedBy is the version of the operator that carved the slice, empty for the slices found on
+	// the GPUs. Slices carved by another version are left to it.
+	CarvedBy string `json:"carvedBy,omitempty"`
+}
+
+// ForceCleanup records what a forced cleanup of an allocation removed.
+type ForceCleanup struct {
+	// PodUUID is the pod UUID named by the force-cleanup annotation.
+	PodUUID string `json:"podUUID"`
+	// PodName is the pod of the removed allocation, empty when no allocation was found.
+	PodName string `json:"podName,omitempty"`
+	// AllocationStatus is the status the allocation was stuck in when it was removed.
+	AllocationStatus string `json:"allocationStatus,omitempty"`
+	// MigUUIDs are the prepared entries removed with the allocation.
+	MigUUIDs []string `json:"migUUIDs,omitempty"`
+	// Errors lists what could not be torn down, e.g. slices still held by a process that may linger on the GPU.
+	Errors []string `json:"errors,omitempty"`
+	// CleanedAt is when the allocation was removed.
+	CleanedAt metav1.Time `json:"cleanedAt"`
+}
+
+// UnschedulablePod records why no GPU of the node can host the slice of a pod.
+type UnschedulablePod struct {
+	// PodName is the pod waiting for a slice.
+	PodName string `json:"podName"`
+	// Namespace is the namespace of the pod.
+	Namespace string `json:"namespace,omitempty"`
+	// Profile is the profile the pod requests.
+	Profile string `json:"profile"`
+	// Reason is NoFreePlacement, ProfileUnsupported or GPUsCordoned.
+	Reason string `json:"reason"`
+	// Since is when the pod was first found unschedulable on the node.
+	Since metav1.Time `json:"since"`
+}
+
+// AffectedPod is a pod with a slice on a cordoned or failing GPU, to plan its eviction.
+type AffectedPod struct {
+	// PodUUID is the UID of the pod.
+	PodUUID string `json:"podUUID"`
+	// PodName is the name of the pod, empty for a slice whose allocation is gone.
+	PodName string `json:"podName,omitempty"`
+	// Namespace is the namespace of the pod.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// SliceIntent records a slice the daemonset started to carve, so that a slice carved before a crash is found
+// again rather than left on the GPU without a prepared entry.
+type SliceIntent struct {
+	// PodName is the pod the slice is carved for.
+	PodName string `json:"podName"`
+	// GPUUUID is the GPU the slice is carved on.
+	GPUUUID string `json:"gpuUUID"`
+	// Profile is the profile of the slice.
+	Profile string `json:"profile"`
+	// Start is the placement the slice was asked for, NVML may carve it at another one when it is taken.
+	Start uint32 `json:"start"`
+	// Size is the number of slots of the slice.
+	Size uint32 `json:"size"`
+	// Since is when the daemonset started to carve the slice.
+	Since metav1.Time `json:"since"`
+}
+
+// SliceRange is a contiguous range of memory slices of a GPU.
+type SliceRange struct {
+	// Start is the first memory slice of the range.
+	Start uint32 `json:"start"`
+	// Size is the number of memory slices of the range.
+	Size uint32 `json:"size"`
+}
+
+// SlicePool is a number of idle slices of a profile kept carved on the node.
+type SlicePool struct {
+	// Profile is the profile of the slices of the pool.
+	Profile string `json:"profile"`
+	// Replicas is the number of idle slices of the pool.
+	// +kubebuilder:validation:Minimum=0
+	Replicas int `json:"replicas"`
+}
+
+// InstasliceSpec defines the desired state of Instaslice
+type InstasliceSpec struct {
+	// GPUID, Profile, start, podUUID
+	Allocations map[string]AllocationDetails `json:"allocations,omitempty"`
+	// Prepared is deprecated: the slices carved on the node are recorded under status.prepared. The daemonset moves
+	// the entries an earlier version kept here to the status when it starts. It is removed in the next release.
+	Prepared map[string]PreparedDetails `json:"prepared,omitempty"`
+	// ReservedSlicesPerGPU is the number of slots of the smallest profile kept free on every GPU of the node,
+	// only pods annotated with org.instaslice/priority=burst may be placed on them.
+	ReservedSlicesPerGPU int `json:"reservedSlicesPerGpu,omitempty"`
+	// ReservedMemoryGBPerGPU is the memory, in GB, set aside on every GPU of the node for the OS and the driver.
+	// Slices are only placed on a GPU while the memory of its slices fits in the rest of the memory of the GPU.
+	ReservedMemoryGBPerGPU int `json:"reservedMemoryGbPerGpu,omitempty"`
+	// RetainSlices keeps the slice of a completed pod so that the next pod requesting the same profile
+	// gets it without carving a new one.
+	RetainSlices bool `json:"retainSlices,omitempty"`
+	// RetainedSliceTTL is how long a retained slice may stay unused before it is destroyed, defaults to 10 minutes.
+	RetainedSliceTTL *metav1.Duration `json:"retainedSliceTTL,omitempty"`
+	// DeletionGracePeriod is how long the slice of a deleted pod is retained before it is destroyed, so that a pod
+	// re-created with the same name takes it over. Slices of deleted pods are destroyed right away when unset.
+	DeletionGracePeriod *metav1.Duration `json:"deletionGracePeriod,omitempty"`
+	// DesiredLayouts holds, per GPU UUID, the profiles of the slices the GPU should be carved in, e.g. three
+	// 2g.10gb. Once no pod uses a GPU whose slices differ, the daemonset destroys them and carves the layout.
+	DesiredLayouts map[string][]string `json:"desiredLayouts,omitempty"`
+	// PreemptForLayout preempts the evictable slices of a GPU waiting for its desired layout instead of waiting
+	// for their pods to complete.
+	PreemptForLayout bool `json:"preemptForLayout,omitempty"`
+	// CordonedGPUs lists the UUIDs of the GPUs taking no new slices, e.g. because they report Xid errors. The
+	// slices already carved on them keep running.
+	CordonedGPUs []string `json:"cordonedGpus,omitempty"`
+	// AllowedProfiles holds, per GPU UUID, the only profiles offered on the GPU, e.g. 7g.40gb alone to keep a GPU
+	// for large slices. GPUs without an entry offer every profile of the node.
+	AllowedProfiles map[string][]string `json:"allowedProfiles,omitempty"`
+	// SlicePools declares slices carved ahead of any pod, e.g. three 1g.5gb to pre-warm the node. The daemonset
+	// keeps that many idle slices of each profile, a pod of the profile takes one over without waiting for a
+	// slice to be carved and the pool is refilled.
+	SlicePools []SlicePool `json:"slicePools,omitempty"`
+	// PinnedNamespaces holds, per GPU UUID, the only namespace whose pods get slices on the GPU, e.g. to dedicate a
+	// GPU to a tenant. GPUs without an entry take pods of any namespace.
+	PinnedNamespaces map[string]string `json:"pinnedNamespaces,omitempty"`
+}
+
+// InstasliceStatus defines the observed state of Instaslice
+type InstasliceStatus struct {
+	Processed string `json:"processed,omitempty"`
+	// MigGPUUUID holds, per GPU UUID, the model of the GPUs discovered on the node.
+	MigGPUUUID map[string]string `json:"migGPUUUID,omitempty"`
+	// Migplacement lists the profiles discovered on the GPUs of the node and where their slices can be placed.
+	Migplacement []Mig `json:"migplacement,omitempty"`
+	// LastSliceCreationDuration holds, per profile, how long the most recent slice took to carve.
+	LastSliceCreationDuration map[string]metav1.Duration `json:"lastSliceCreationDuration,omitempty"`
+	// LastReconcileTime is when the node daemonset last completed a reconcile, a stale value means it is stuck.
+	LastReconcileTime *metav1.Time `json:"lastReconcileTime,omitempty"`
+	// AvailableSlices holds, per GPU, the free slots of the smallest profile left to normal allocations once the reserve is set aside.
+	AvailableSlices map[string]int `json:"availableSlices,omitempty"`
+	// TotalSlices is the number of smallest profile slots on all GPUs of the node.
+	// +optional
+	TotalSlices int `json:"totalSlices"`
+	// UsedSlices is the number of smallest profile slots taken by slices and pending allocations.
+	// +optional
+	UsedSlices int `json:"usedSlices"`
+	// FreeSlices is the number of smallest profile slots still free, reserved ones included.
+	// +optional
+	FreeSlices int `json:"freeSlices"`
+	// DriverVersion is the version of the NVIDIA driver of the node, as NVML reported it at discovery.
+	DriverVersion string `json:"driverVersion,omitempty"`
+	// Summary is a one-line account of the node refreshed on every reconcile, e.g. "4/7 slices used, 2 pods
+	// pending, driver 535.104.05, MIG enabled on 2/2 GPUs".
+	Summary string `json:"summary,omitempty"`
+	// MigEnabled holds, per GPU, whether MIG mode was enabled when the daemonset discovered it.
+	MigEnabled map[string]bool `json:"migEnabled,omitempty"`
+	// CarvedSlices holds, per GPU, the number of slices carved on it whatever their profile.
+	CarvedSlices map[string]int `json:"carvedSlices,omitempty"`
+	// XidErrors holds, per GPU, the number of Xid critical errors NVML reported for it since the daemonset started.
+	XidErrors map[string]int `json:"xidErrors,omitempty"`
+	// ResetPendingGPUs lists the UUIDs of the GPUs NVML reports a reset pending for, e.g. to apply a change of MIG
+	// mode. New slices would fail to be carved on them, they take none until they were reset.
+	ResetPendingGPUs []string `json:"resetPendingGpus,omitempty"`
+	// Prepared holds, keyed by MIG UUID, the slices the daemonset carved on the node: GPUID, Profile, start.
+	// It is observed state, written by the daemonset through the status subresource only.
+	Prepared map[string]PreparedDetails `json:"prepared,omitempty"`
+	// QuarantinedPrepared holds, keyed by MIG UUID, the prepared entries the daemonset found corrupt and moved out
+	// of status.prepared so that nothing acts on them.
+	QuarantinedPrepared map[string]PreparedDetails `json:"quarantinedPrepared,omitempty"`
+	// MigUUIDs holds, per pod UUID, the MIG UUIDs of the slice carved for the pod ordered by ci id, as the pod
+	// sees them in NVIDIA_VISIBLE_DEVICES.
+	MigUUIDs map[string][]string `json:"migUUIDs,omitempty"`
+	// NVLinkPeers holds, per GPU, the GPUs of the node it has an active NVLink to.
+	NVLinkPeers map[string][]string `json:"nvlinkPeers,omitempty"`
+	// OccupiedRanges holds, per GPU, the ranges of memory slices taken by slices and pending allocations, for
+	// schedulers to pick exact placements from the free ones.
+	OccupiedRanges map[string][]SliceRange `json:"occupiedRanges,omitempty"`
+	// LastForceCleanup records what the latest forced cleanup of an allocation removed.
+	LastForceCleanup *ForceCleanup `json:"lastForceCleanup,omitempty"`
+	// UnschedulableOnNode holds, per pod UUID, why a pod waiting for a slice cannot be placed on the node, for
+	// schedulers to move it to another node.
+	UnschedulableOnNode map[string]UnschedulablePod `json:"unschedulableOnNode,omitempty"`
+	// AffectedPods holds, per cordoned GPU or GPU that reported at least the allowed number of Xid errors, the
+	// pods with slices on it, for admins to plan their eviction.
+	AffectedPods map[string][]AffectedPod `json:"affectedPods,omitempty"`
+	// SliceIntents holds, per pod UUID, the slices being carved whose prepared entries are not written yet.
+	SliceIntents map[string]SliceIntent `json:"sliceIntents,omitempty"`
+	// Conditions holds the latest observations of the node, e.g. Degraded when a slice is no longer tracked.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+// v1alpha2 is served through the conversion webhook config/crd enables, which converts its objects to and from
+// v1alpha1, the storage version.
+//+kubebuilder:printcolumn:name="Total",type=integer,JSONPath=`.status.totalSlices`
+//+kubebuilder:printcolumn:name="Used",type=integer,JSONPath=`.status.usedSlices`
+//+kubebuilder:printcolumn:name="Free",type=integer,JSONPath=`.status.freeSlices`
+//+kubebuilder:printcolumn:name="Summary",type=string,JSONPath=`.status.summary`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Instaslice is the Schema for the instaslices API
+type Instaslice struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InstasliceSpec   `json:"spec,omitempty"`
+	Status InstasliceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// InstasliceList contains a list of Instaslice
+type InstasliceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Instaslice `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Instaslice{}, &InstasliceList{})
+}
@@ -20,6 +20,8 @@ import (
 	"crypto/tls"
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -34,8 +36,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+	inferencev1alpha2 "codeflare.dev/instaslice/api/v1alpha2"
 	"codeflare.dev/instaslice/internal/controller"
 	//+kubebuilder:scaffold:imports
 )
@@ -49,6 +53,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(inferencev1alpha1.AddToScheme(scheme))
+	utilruntime.Must(inferencev1alpha2.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -58,6 +63,15 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var identity string
+	var enableConversionWebhook bool
+	var defaultAllocationTTL time.Duration
+	var profileOrderName string
+	var enableProcessedValidation bool
+	var processedWriters string
+	var operatorNamespace string
+	var preemptionRespectPDBs bool
+	var enableSingleAllocationValidation bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -67,6 +81,24 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&identity, "identity", controller.DefaultAllocationCreator,
+		"The identity recorded as the creator of the allocations written by this controller")
+	flag.BoolVar(&enableConversionWebhook, "enable-conversion-webhook", false,
+		"If set, the webhook converting Instaslice objects between v1alpha1 and v1alpha2 is served, it needs serving certificates")
+	flag.DurationVar(&defaultAllocationTTL, "default-allocation-ttl", 0,
+		"How long after they are allocated the slices of pods without the org.instaslice/ttl annotation expire and are torn down once idle. Never when 0")
+	flag.StringVar(&profileOrderName, "profile-order", string(controller.ProfileOrderSmallestFirst),
+		"The order in which the profiles satisfying a pod asking for any slice or for GPU memory are tried, smallest-first or largest-first")
+	flag.BoolVar(&enableProcessedValidation, "enable-processed-validation", false,
+		"If set, the webhook rejecting changes to status.processed of Instaslices by anyone but --processed-writers is served, it needs serving certificates")
+	flag.StringVar(&processedWriters, "processed-writers", "system:serviceaccount:instaslicev2-system:instaslicev2-controller-manager",
+		"Comma separated users allowed to change status.processed of Instaslices, the service accounts of the operator")
+	flag.StringVar(&operatorNamespace, "operator-namespace", controller.OperatorNamespaceFromEnvironment(),
+		"The namespace the Instaslices of the nodes are kept in, INSTASLICE_NAMESPACE or the namespace of the pod by default")
+	flag.BoolVar(&preemptionRespectPDBs, "preemption-respect-pdbs", false,
+		"If set, the slices of pods whose PodDisruptionBudgets allow no more disruptions are not preempted for pods of higher priority")
+	flag.BoolVar(&enableSingleAllocationValidation, "enable-single-allocation-validation", false,
+		"If set, the webhook rejecting a second allocation for a pod not annotated org.instaslice/multi-slice=true is served, it needs serving certificates")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -75,6 +107,12 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	profileOrder, err := controller.ParseProfileOrder(profileOrderName)
+	if err != nil {
+		setupLog.Error(err, "invalid profile order")
+		os.Exit(1)
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -123,9 +161,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	var preemptionGuard controller.PreemptionGuard
+	if preemptionRespectPDBs {
+		preemptionGuard = &controller.PodDisruptionBudgetGuard{Client: mgr.GetClient()}
+	}
+
 	if err = (&controller.InstasliceReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:               mgr.GetClient(),
+		Scheme:               mgr.GetScheme(),
+		Identity:             identity,
+		Recorder:             mgr.GetEventRecorderFor("instaslice-controller"),
+		DefaultAllocationTTL: defaultAllocationTTL,
+		ProfileOrder:         profileOrder,
+		OperatorNamespace:    operatorNamespace,
+		PreemptionGuard:      preemptionGuard,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Instaslice")
 		os.Exit(1)
@@ -138,6 +187,25 @@ func main() {
 	// 	setupLog.Error(err, "unable to create controller", "controller", "Instaslice")
 	// 	os.Exit(1)
 	// }
+	if enableConversionWebhook {
+		if err = (&inferencev1alpha1.Instaslice{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Instaslice")
+			os.Exit(1)
+		}
+	}
+	var validators []admission.CustomValidator
+	if enableProcessedValidation {
+		validators = append(validators, &inferencev1alpha1.ProcessedValidator{Writers: strings.Split(processedWriters, ",")})
+	}
+	if enableSingleAllocationValidation {
+		validators = append(validators, &inferencev1alpha1.SingleAllocationValidator{})
+	}
+	if len(validators) > 0 {
+		if err = inferencev1alpha1.SetupValidationWithManager(mgr, validators...); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "InstasliceValidation")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -17,15 +17,21 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -58,6 +64,53 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var exposeSliceDetails bool
+	var expectedECCMode string
+	var protectConfigMaps bool
+	var discoveryConcurrency int
+	var capacityReload string
+	var capacityReloadFile string
+	var capacityAdvertise string
+	var profileResourcePrefix string
+	var profileResourceNames string
+	var sliceOperationRate float64
+	var exportCapabilities bool
+	var publishResourceSlices bool
+	var cacheProfiles bool
+	var capacityFailClosed bool
+	var sliceMetricsInterval time.Duration
+	var nvmlLibraryPaths string
+	var minDriverVersion string
+	var snapshotPath string
+	var operatorVersion string
+	var maintenanceWindow string
+	var maxSliceCreationAttempts int
+	var sliceCreationRetryBase time.Duration
+	var sliceCreationRetryMax time.Duration
+	var sliceCreationRetryFactor float64
+	var correctCapacityDrift bool
+	var bestEffortDiscovery bool
+	var managedGPUs string
+	var idleGPUPolicy string
+	var idleGPUPolicies string
+	var idleCriterion string
+	var configMapNamespacePolicy string
+	var migDeviceFormat string
+	var operatorNamespace string
+	var xidPollInterval time.Duration
+	var xidErrorThreshold int
+	var quarantineInvalidPrepared bool
+	var checkConfigMapReferences bool
+	var fullReconcileInterval time.Duration
+	var configConfigMap string
+	var stuckAllocationThreshold time.Duration
+	var reclaimOrphanSlices bool
+	var orphanSliceGracePeriod time.Duration
+	var logAllocationTransitions bool
+	var stuckPodThreshold time.Duration
+	var creationHistoryLength int
+	var maxParallelGPUs int
+	var enableTracing bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8084", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8085", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -67,6 +120,100 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.BoolVar(&exposeSliceDetails, "expose-slice-details", false,
+		"If set, the ConfigMap of a pod also exposes the profile and memory size of its slice")
+	flag.StringVar(&expectedECCMode, "expected-ecc-mode", "",
+		"If set to enabled or disabled, a warning is logged for every GPU in another ECC mode")
+	flag.BoolVar(&protectConfigMaps, "protect-configmaps", false,
+		"If set, the ConfigMap of a pod cannot be deleted before its slice is torn down")
+	flag.IntVar(&discoveryConcurrency, "discovery-concurrency", 4,
+		"The number of GPUs searched for existing slices at once on startup")
+	flag.StringVar(&capacityReload, "capacity-reload", controller.CapacityReloadLabel,
+		"How the device plugin is made to pick up slice changes, label to toggle the node label or file to write the reload file")
+	flag.StringVar(&capacityReloadFile, "capacity-reload-file", "",
+		"The file shared with the device plugin that is written to request a reload when capacity-reload is file")
+	flag.StringVar(&capacityAdvertise, "capacity-advertise", controller.CapacityAdvertisePod,
+		"How the capacity of the slices is advertised on the node, pod for a resource per pod, profile for a resource per profile or none")
+	flag.StringVar(&profileResourcePrefix, "profile-resource-prefix", controller.ProfileResourcePrefix,
+		"The prefix of the resource advertised for each profile when capacity-advertise is profile, e.g. nvidia.com/mig-")
+	flag.StringVar(&profileResourceNames, "profile-resource-names", "",
+		"A comma separated list of profile=resource pairs, e.g. 1g.5gb=nvidia.com/mig-1g.5gb, naming the resource advertised for a profile when capacity-advertise is profile instead of the prefix")
+	flag.Float64Var(&sliceOperationRate, "slice-operation-rate", 0,
+		"The number of slices created or destroyed per second at most on the node, operations in excess are deferred. Unlimited when 0")
+	flag.BoolVar(&exportCapabilities, "export-capabilities", false,
+		"If set, a ConfigMap summarizing the profiles and free capacity of the node is maintained for external schedulers")
+	flag.BoolVar(&publishResourceSlices, "publish-resource-slices", false,
+		"If set, the slices the GPUs of the node can carve are published as DRA devices in resource.k8s.io/v1beta1 ResourceSlices")
+	flag.BoolVar(&cacheProfiles, "cache-profiles", false,
+		"If set, the discovered profiles are cached in a ConfigMap and reused on startup while the GPUs of the node stay the same")
+	flag.BoolVar(&capacityFailClosed, "capacity-fail-closed", false,
+		"If set, slices are only marked created once the node capacity was updated, failed updates are retried")
+	flag.DurationVar(&sliceMetricsInterval, "slice-metrics-interval", controller.DefaultSliceMetricsInterval,
+		"How often the utilization and memory usage of the slices are sampled and published as metrics, never when 0")
+	flag.StringVar(&nvmlLibraryPaths, "nvml-library-paths", "",
+		"A comma separated list of paths searched in order for the NVML library, common host and driver container locations are searched when empty")
+	flag.StringVar(&snapshotPath, "snapshot-path", "",
+		"If set, the allocations, prepared slices and free capacity of the node are written to this bolt database after every reconcile for offline debugging")
+	flag.StringVar(&operatorVersion, "operator-version", controller.DefaultOperatorVersion,
+		"The version recorded on the slices the daemonset carves, the slices carved by other versions are left to them during a mixed-version rollout")
+	flag.StringVar(&maintenanceWindow, "maintenance-window", "",
+		"A daily UTC time range, e.g. 22:00-06:00, outside which slices are only destroyed for deleted pods. Slices are created and destroyed at any time when empty")
+	flag.StringVar(&minDriverVersion, "min-driver-version", controller.DefaultMinDriverVersion,
+		"The oldest driver version supported, the instaslice of the node is marked degraded on an older one. Any version is accepted when empty")
+	flag.IntVar(&maxSliceCreationAttempts, "max-slice-creation-attempts", controller.DefaultMaxSliceCreationAttempts,
+		"The number of times in a row the slice of a pod may fail to be created before it is given up and the pod told in an event. Retried forever when 0")
+	flag.DurationVar(&sliceCreationRetryBase, "slice-creation-retry-base", controller.DefaultSliceCreationRetryBase,
+		"How long after a first failed attempt the slice of a pod is created again")
+	flag.DurationVar(&sliceCreationRetryMax, "slice-creation-retry-max", controller.DefaultSliceCreationRetryMax,
+		"The longest wait between attempts to create the slice of a pod")
+	flag.Float64Var(&sliceCreationRetryFactor, "slice-creation-retry-factor", controller.DefaultSliceCreationRetryFactor,
+		"The factor the wait before creating the slice of a pod again is multiplied by after every further failed attempt, at least 1")
+	flag.BoolVar(&correctCapacityDrift, "correct-capacity-drift", false,
+		"If set, the per pod resources of pods without a slice are removed from the node capacity along with the missing ones restored")
+	flag.BoolVar(&bestEffortDiscovery, "best-effort-discovery", false,
+		"If set, GPUs failing discovery are skipped and listed in the PartiallyDiscovered condition of the instaslice instead of failing discovery")
+	flag.StringVar(&managedGPUs, "managed-gpus", "",
+		"A comma separated list of the indexes or UUIDs of the GPUs the daemonset manages, the others are left untouched. Every GPU is managed when empty")
+	flag.StringVar(&idleGPUPolicy, "idle-gpu-policy", string(controller.IdleGPUPolicyLeave),
+		"What is done with a GPU once its last slice is torn down: leave to keep it as is, reset-when-idle to destroy every GPU and compute instance left on it")
+	flag.StringVar(&idleGPUPolicies, "idle-gpu-policies", "",
+		"A comma separated list of <GPU UUID>=<policy> entries overriding idle-gpu-policy for the given GPUs")
+	flag.StringVar(&idleCriterion, "idle-criterion", string(controller.IdleCriterionPodCompleted),
+		"When the slice of an allocation past its TTL is idle and torn down: pod-completed once its pod is done, no-utilization once it reports no utilization, any for either")
+	flag.StringVar(&configMapNamespacePolicy, "configmap-namespace-policy", string(controller.ConfigMapNamespacePod),
+		"Where the ConfigMaps of pods are kept: pod-namespace in the namespace of the pod, operator-namespace all in operator-namespace named <pod namespace>.<pod name>")
+	flag.StringVar(&operatorNamespace, "operator-namespace", controller.OperatorNamespaceFromEnvironment(),
+		"The namespace the Instaslice of the node and the ConfigMaps of the operator are kept in, and the ones of pods when configmap-namespace-policy is operator-namespace. INSTASLICE_NAMESPACE or the namespace of the pod by default")
+	flag.StringVar(&migDeviceFormat, "mig-device-format", string(controller.MigDeviceFormatUUID),
+		"How the ConfigMaps of pods reference MIG devices: uuid by their MIG UUID, index as MIG-<GPU UUID>/<gi>/<ci> for container runtimes predating MIG UUIDs")
+	flag.DurationVar(&xidPollInterval, "xid-poll-interval", 0,
+		"How often the Xid errors NVML reports for the GPUs are collected into the instaslice status and metrics. Not collected when 0")
+	flag.IntVar(&xidErrorThreshold, "xid-error-threshold", 0,
+		"The number of Xid errors of a GPU from which the instaslice is marked Degraded. Never when 0")
+	flag.BoolVar(&quarantineInvalidPrepared, "quarantine-invalid-prepared", false,
+		"If set, prepared entries breaking their invariants are moved to status.quarantinedPrepared of the instaslice instead of only being flagged")
+	flag.BoolVar(&checkConfigMapReferences, "check-configmap-references", false,
+		"If set, a warning event is emitted on pods none of whose containers reads the ConfigMap created for their slice")
+	flag.DurationVar(&fullReconcileInterval, "full-reconcile-interval", controller.DefaultFullReconcileInterval,
+		"How often all the allocations and prepared slices of the node are reconciled against the hardware without an event. Never when 0")
+	flag.StringVar(&configConfigMap, "config-configmap", "",
+		"The ConfigMap in the default namespace whose config.yaml key overrides some of the flags, reloaded whenever it changes. None when empty")
+	flag.DurationVar(&stuckAllocationThreshold, "stuck-allocation-threshold", controller.DefaultStuckAllocationThreshold,
+		"How long an allocation stays creating or deleting before it is counted in the instaslice_stuck_allocations gauge. Not counted when 0")
+	flag.BoolVar(&reclaimOrphanSlices, "reclaim-orphan-slices", false,
+		"If set, slices found on the GPUs that no allocation holds are destroyed once orphaned for orphan-slice-grace-period instead of only being reported")
+	flag.DurationVar(&orphanSliceGracePeriod, "orphan-slice-grace-period", controller.DefaultOrphanSliceGracePeriod,
+		"How long a slice held by no allocation is left before reclaim-orphan-slices destroys it")
+	flag.BoolVar(&logAllocationTransitions, "log-allocation-transitions", false,
+		"If set, a JSON line is written to stdout whenever an allocation of the node is created, fails or is deleted")
+	flag.DurationVar(&stuckPodThreshold, "stuck-pod-threshold", 0,
+		"How long the pod of a realized slice may be unable to start, e.g. stuck in ContainerCreating, before its slice is verified and then reclaimed. Never when 0")
+	flag.IntVar(&creationHistoryLength, "creation-history-length", 0,
+		"How many of the latest attempts to carve the slices of the node are kept in the creation history annotation of the instaslice. None when 0")
+	flag.IntVar(&maxParallelGPUs, "max-parallel-gpus", 1,
+		"How many GPUs of the node the slices of a batch are carved on at once, the slices of a GPU are always carved one after the other")
+	flag.BoolVar(&enableTracing, "enable-tracing", false,
+		"If set, spans of the carving and the destruction of slices are exported over OTLP/HTTP, configured by the standard OTEL_EXPORTER_OTLP_* variables")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -75,6 +222,94 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if expectedECCMode != "" && expectedECCMode != controller.ECCModeEnabled && expectedECCMode != controller.ECCModeDisabled {
+		setupLog.Error(nil, "expected-ecc-mode must be enabled or disabled", "value", expectedECCMode)
+		os.Exit(1)
+	}
+	if maxParallelGPUs < 1 {
+		setupLog.Error(nil, "max-parallel-gpus must be at least 1", "value", maxParallelGPUs)
+		os.Exit(1)
+	}
+	var capacityReloader controller.CapacityReloader
+	switch capacityReload {
+	case controller.CapacityReloadLabel:
+	case controller.CapacityReloadFile:
+		if capacityReloadFile == "" {
+			setupLog.Error(nil, "capacity-reload-file must be set when capacity-reload is file")
+			os.Exit(1)
+		}
+		capacityReloader = &controller.FileSignalReloader{Path: capacityReloadFile}
+	default:
+		setupLog.Error(nil, "capacity-reload must be label or file", "value", capacityReload)
+		os.Exit(1)
+	}
+	var capacityAdvertiser controller.CapacityAdvertiser
+	switch capacityAdvertise {
+	case controller.CapacityAdvertisePod:
+	case controller.CapacityAdvertiseProfile:
+	case controller.CapacityAdvertiseNone:
+		capacityAdvertiser = controller.NoopAdvertiser{}
+	default:
+		setupLog.Error(nil, "capacity-advertise must be pod, profile or none", "value", capacityAdvertise)
+		os.Exit(1)
+	}
+	if sliceCreationRetryBase <= 0 || sliceCreationRetryMax < sliceCreationRetryBase || sliceCreationRetryFactor < 1 {
+		setupLog.Error(nil, "slice-creation-retry-base must be positive, slice-creation-retry-max at least the base and slice-creation-retry-factor at least 1",
+			"base", sliceCreationRetryBase, "max", sliceCreationRetryMax, "factor", sliceCreationRetryFactor)
+		os.Exit(1)
+	}
+	resourceNames, err := controller.ParseProfileResourceNames(profileResourceNames)
+	if err != nil {
+		setupLog.Error(err, "invalid profile-resource-names")
+		os.Exit(1)
+	}
+	managedGPUList, err := controller.ParseManagedGPUs(managedGPUs)
+	if err != nil {
+		setupLog.Error(err, "invalid managed-gpus")
+		os.Exit(1)
+	}
+	defaultIdleGPUPolicy, err := controller.ParseIdleGPUPolicy(idleGPUPolicy)
+	if err != nil {
+		setupLog.Error(err, "invalid idle-gpu-policy")
+		os.Exit(1)
+	}
+	perGPUIdleGPUPolicies, err := controller.ParseIdleGPUPolicies(idleGPUPolicies)
+	if err != nil {
+		setupLog.Error(err, "invalid idle-gpu-policies")
+		os.Exit(1)
+	}
+	sliceIdleCriterion, err := controller.ParseIdleCriterion(idleCriterion)
+	if err != nil {
+		setupLog.Error(err, "invalid idle-criterion")
+		os.Exit(1)
+	}
+	configMapNamespace, err := controller.ParseConfigMapNamespacePolicy(configMapNamespacePolicy)
+	if err != nil {
+		setupLog.Error(err, "invalid configmap-namespace-policy")
+		os.Exit(1)
+	}
+	migDevices, err := controller.ParseMigDeviceFormat(migDeviceFormat)
+	if err != nil {
+		setupLog.Error(err, "invalid mig-device-format")
+		os.Exit(1)
+	}
+	var window *controller.MaintenanceWindow
+	if maintenanceWindow != "" {
+		parsed, err := controller.ParseMaintenanceWindow(maintenanceWindow)
+		if err != nil {
+			setupLog.Error(err, "invalid maintenance-window")
+			os.Exit(1)
+		}
+		window = parsed
+	}
+	sliceOperationPolicy := controller.SliceOperationPolicy{Rate: sliceOperationRate, MaintenanceWindow: window}
+	sliceCreationRetryPolicy := controller.SliceCreationRetryPolicy{
+		MaxAttempts: maxSliceCreationAttempts,
+		Base:        sliceCreationRetryBase,
+		Max:         sliceCreationRetryMax,
+		Factor:      sliceCreationRetryFactor,
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -123,6 +358,22 @@ func main() {
 		os.Exit(1)
 	}
 
+	var tracerProvider trace.TracerProvider
+	if enableTracing {
+		exporter, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			setupLog.Error(err, "unable to create the span exporter")
+			os.Exit(1)
+		}
+		provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+		defer func() {
+			if err := provider.Shutdown(context.Background()); err != nil {
+				setupLog.Error(err, "unable to flush the spans")
+			}
+		}()
+		tracerProvider = provider
+	}
+
 	// if err = (&controller.InstasliceReconciler{
 	// 	Client: mgr.GetClient(),
 	// 	Scheme: mgr.GetScheme(),
@@ -131,9 +382,64 @@ func main() {
 	// 	os.Exit(1)
 	// }
 
+	var libraryPaths []string
+	if nvmlLibraryPaths != "" {
+		libraryPaths = strings.Split(nvmlLibraryPaths, ",")
+	}
+	if capacityAdvertise == controller.CapacityAdvertiseProfile {
+		capacityAdvertiser = &controller.ProfileResourceAdvertiser{
+			Client:        mgr.GetClient(),
+			Prefix:        profileResourcePrefix,
+			ResourceNames: resourceNames,
+			Namespace:     operatorNamespace,
+		}
+	}
+
 	if err = (&controller.InstaSliceDaemonsetReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                    mgr.GetClient(),
+		Scheme:                    mgr.GetScheme(),
+		ExposeSliceDetails:        exposeSliceDetails,
+		ExpectedECCMode:           expectedECCMode,
+		ProtectConfigMaps:         protectConfigMaps,
+		DiscoveryConcurrency:      discoveryConcurrency,
+		CapacityReloader:          capacityReloader,
+		CapacityAdvertiser:        capacityAdvertiser,
+		SliceOperationPolicy:      sliceOperationPolicy,
+		ExportCapabilities:        exportCapabilities,
+		PublishResourceSlices:     publishResourceSlices,
+		CacheProfiles:             cacheProfiles,
+		CapacityFailClosed:        capacityFailClosed,
+		SliceMetricsInterval:      sliceMetricsInterval,
+		NvmlLibraryPaths:          libraryPaths,
+		MinDriverVersion:          minDriverVersion,
+		SnapshotPath:              snapshotPath,
+		OperatorVersion:           operatorVersion,
+		SliceCreationRetryPolicy:  sliceCreationRetryPolicy,
+		CorrectCapacityDrift:      correctCapacityDrift,
+		BestEffortDiscovery:       bestEffortDiscovery,
+		ManagedGPUs:               managedGPUList,
+		IdleGPUPolicy:             defaultIdleGPUPolicy,
+		IdleGPUPolicies:           perGPUIdleGPUPolicies,
+		IdleCriterion:             sliceIdleCriterion,
+		ConfigMapNamespacePolicy:  configMapNamespace,
+		OperatorNamespace:         operatorNamespace,
+		MigDeviceFormat:           migDevices,
+		XidPollInterval:           xidPollInterval,
+		XidErrorThreshold:         xidErrorThreshold,
+		QuarantineInvalidPrepared: quarantineInvalidPrepared,
+		CheckConfigMapReferences:  checkConfigMapReferences,
+		FullReconcileInterval:     fullReconcileInterval,
+		ConfigConfigMap:           configConfigMap,
+		StuckAllocationThreshold:  stuckAllocationThreshold,
+		ReclaimOrphanSlices:       reclaimOrphanSlices,
+		OrphanSliceGracePeriod:    orphanSliceGracePeriod,
+		LogAllocationTransitions:  logAllocationTransitions,
+		StuckPodThreshold:         stuckPodThreshold,
+		CreationHistoryLength:     creationHistoryLength,
+		TracerProvider:            tracerProvider,
+		MaxParallelGPUs:           maxParallelGPUs,
+		APIReader:                 mgr.GetAPIReader(),
+		Recorder:                  mgr.GetEventRecorderFor("instaslice-daemonset"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "InstaSliceDaemonsetReconciler")
 		//os.Exit(1)
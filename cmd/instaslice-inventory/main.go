@@ -0,0 +1,67 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+	"codeflare.dev/instaslice/internal/controller"
+)
+
+func main() {
+	var namespace string
+	flag.StringVar(&namespace, "namespace", "",
+		"The namespace of the Instaslice objects to report on. All namespaces when unset.")
+	flag.Parse()
+
+	scheme := runtime.NewScheme()
+	if err := inferencev1alpha1.AddToScheme(scheme); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to build scheme: %v\n", err)
+		os.Exit(1)
+	}
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to get kubeconfig: %v\n", err)
+		os.Exit(1)
+	}
+	k8sClient, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to create client: %v\n", err)
+		os.Exit(1)
+	}
+	var instaslices inferencev1alpha1.InstasliceList
+	if err := k8sClient.List(context.Background(), &instaslices, client.InNamespace(namespace)); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to list Instaslice objects: %v\n", err)
+		os.Exit(1)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(controller.BuildInventory(instaslices.Items)); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to write inventory: %v\n", err)
+		os.Exit(1)
+	}
+}
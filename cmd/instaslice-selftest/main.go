@@ -0,0 +1,46 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+
+	"codeflare.dev/instaslice/internal/controller"
+)
+
+func main() {
+	var opts controller.SelfTestOptions
+	flag.IntVar(&opts.DeviceIndex, "gpu-index", 0, "The index of the GPU to carve the test slice on.")
+	flag.StringVar(&opts.Profile, "profile", "",
+		"The MIG profile to carve, for example 1g.5gb. "+
+			"The smallest profile supported by the GPU is used when unset.")
+	flag.Parse()
+
+	result, err := controller.RunSelfTest(nvml.New(), opts)
+	if result != nil {
+		fmt.Printf("gpu: %s profile: %s\n", result.GPUUUID, result.Profile)
+	}
+	if err != nil {
+		fmt.Printf("FAIL: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("PASS: create %v verify %v destroy %v\n", result.CreateDuration, result.VerifyDuration, result.DestroyDuration)
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// Strategies the daemonset can use to advertise the capacity of the slices of the node.
+	CapacityAdvertisePod     = "pod"
+	CapacityAdvertiseProfile = "profile"
+	CapacityAdvertiseNone    = "none"
+
+	// ProfileResourcePrefix prefixes the extended resource advertised for each profile by the per-profile strategy.
+	ProfileResourcePrefix = "instaslice.codeflare.dev/mig-"
+)
+
+// CapacityAdvertiser publishes on the node the capacity the scheduler needs to place pods on their slices.
+// Advertise is called once the slice of an allocation is set up and Withdraw once it is torn down, both may be
+// called again for the same allocation and have to be idempotent.
+type CapacityAdvertiser interface {
+	Advertise(ctx context.Context, nodeName string, allocation inferencev1alpha1.AllocationDetails) error
+	Withdraw(ctx context.Context, nodeName string, allocation inferencev1alpha1.AllocationDetails) error
+}
+
+// PodResourceAdvertiser adds an org.instaslice/<pod> extended resource to the node for the slice of each pod,
+// the pod requests it to be scheduled on the node its slice was carved on. This is the default advertiser.
+type PodResourceAdvertiser struct {
+	Client client.Client
+	// APIReader reads the node from the API server when the cached one was stale, Client is used when unset.
+	APIReader client.Reader
+}
+
+// Advertise adds the resource of the pod of the allocation to the node capacity.
+func (p *PodResourceAdvertiser) Advertise(ctx context.Context, nodeName string, allocation inferencev1alpha1.AllocationDetails) error {
+	node := &v1.Node{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		log.FromContext(ctx).Error(err, "unable to fetch Node")
+		return err
+	}
+	capacityKey := InstaSliceResourcePrefix + allocation.PodName
+	if _, exists := node.Status.Capacity[v1.ResourceName(capacityKey)]; exists {
+		log.FromContext(ctx).Info("Node already patched with ", "capacity", capacityKey)
+		return nil
+	}
+	patchData, err := createPatchData(capacityKey, "1")
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to create correct json for patching node")
+		return err
+	}
+	if err := p.Client.Status().Patch(ctx, node, client.RawPatch(types.JSONPatchType, patchData)); err != nil {
+		log.FromContext(ctx).Error(err, "unable to patch Node status")
+		return err
+	}
+	return nil
+}
+
+// Withdraw removes the resource of the pod of the allocation from the node capacity.
+func (p *PodResourceAdvertiser) Withdraw(ctx context.Context, nodeName string, allocation inferencev1alpha1.AllocationDetails) error {
+	deletePatch, err := deletePatchData(allocation.PodName)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to create delete json patch data")
+		return err
+	}
+	node := &v1.Node{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		log.FromContext(ctx).Error(err, "unable to fetch Node")
+		return err
+	}
+	resourceName := v1.ResourceName(InstaSliceResourcePrefix + allocation.PodName)
+	if _, ok := node.Status.Capacity[resourceName]; !ok {
+		log.FromContext(ctx).Info("skipping non-existent deletion of instaslice resource for ", "pod", allocation.PodName)
+		return nil
+	}
+	if err := p.Client.Status().Patch(ctx, node, client.RawPatch(types.JSONPatchType, deletePatch)); err != nil {
+		// removing a resource the cache still lists but that is already gone fails, only the API server tells
+		reader := p.APIReader
+		if reader == nil {
+			reader = p.Client
+		}
+		freshNode, errFresh := getFreshNode(ctx, reader, nodeName)
+		if errFresh == nil {
+			if _, ok := freshNode.Status.Capacity[resourceName]; !ok {
+				return nil
+			}
+		}
+		log.FromContext(ctx).Error(err, "unable to patch Node status")
+		return err
+	}
+	return nil
+}
+
+// ProfileResourceAdvertiser advertises one extended resource per profile, e.g. instaslice.codeflare.dev/mig-1g.5gb,
+// counting the slices of the profile held by the pods of the node. Pods request the resource of their profile
+// instead of one named after them.
+type ProfileResourceAdvertiser struct {
+	Client client.Client
+	// Prefix of the resource names, ProfileResourcePrefix when empty.
+	Prefix string
+	// ResourceNames maps profiles to the resource advertised for them, e.g. 1g.5gb to nvidia.com/mig-1g.5gb for
+	// schedulers expecting the naming of the NVIDIA device plugin. Profiles left out are advertised under Prefix.
+	ResourceNames map[string]string
+	// Namespace is the namespace of the instaslices of the nodes, DefaultOperatorNamespace when empty.
+	Namespace string
+}
+
+// Advertise sets the resource of the profile of the allocation to the slices of the profile, the allocation included.
+func (p *ProfileResourceAdvertiser) Advertise(ctx context.Context, nodeName string, allocation inferencev1alpha1.AllocationDetails) error {
+	return p.setProfileCapacity(ctx, nodeName, allocation, true)
+}
+
+// Withdraw sets the resource of the profile of the allocation to the slices of the profile, the allocation excluded.
+// The resource is removed when no slice of the profile is left.
+func (p *ProfileResourceAdvertiser) Withdraw(ctx context.Context, nodeName string, allocation inferencev1alpha1.AllocationDetails) error {
+	return p.setProfileCapacity(ctx, nodeName, allocation, false)
+}
+
+// resourceName returns the extended resource advertised for the profile.
+func (p *ProfileResourceAdvertiser) resourceName(profile string) string {
+	if name, mapped := p.ResourceNames[profile]; mapped {
+		return name
+	}
+	prefix := p.Prefix
+	if prefix == "" {
+		prefix = ProfileResourcePrefix
+	}
+	return prefix + profile
+}
+
+// setProfileCapacity counts the slices of the profile from the allocations of the node rather than adding to or
+// subtracting from the advertised value, calling it twice for the same allocation does not skew the count.
+func (p *ProfileResourceAdvertiser) setProfileCapacity(ctx context.Context, nodeName string, allocation inferencev1alpha1.AllocationDetails, holding bool) error {
+	var instaslice inferencev1alpha1.Instaslice
+	if err := p.Client.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: operatorNamespace(p.Namespace)}, &instaslice); err != nil {
+		return err
+	}
+	count := profileSlices(&instaslice, allocation.Profile, allocation.PodUUID)
+	if holding {
+		count++
+	}
+	node := &v1.Node{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		log.FromContext(ctx).Error(err, "unable to fetch Node")
+		return err
+	}
+	resourceName := p.resourceName(allocation.Profile)
+	current, exists := node.Status.Capacity[v1.ResourceName(resourceName)]
+	if (!exists && count == 0) || (exists && current.Value() == int64(count)) {
+		return nil
+	}
+	path := fmt.Sprintf("/status/capacity/%s", strings.ReplaceAll(resourceName, "/", "~1"))
+	operation := ResPatchOperation{Op: "add", Path: path, Value: strconv.Itoa(count)}
+	if count == 0 {
+		operation = ResPatchOperation{Op: "remove", Path: path}
+	}
+	patchData, err := json.Marshal([]ResPatchOperation{operation})
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("advertising slices of ", "profile", allocation.Profile, "resource", resourceName, "count", count)
+	return p.Client.Status().Patch(ctx, node, client.RawPatch(types.JSONPatchType, patchData))
+}
+
+// ParseProfileResourceNames parses a comma separated list of profile=resource pairs, e.g.
+// 1g.5gb=nvidia.com/mig-1g.5gb,2g.10gb=nvidia.com/mig-2g.10gb, into the ResourceNames of a ProfileResourceAdvertiser.
+func ParseProfileResourceNames(value string) (map[string]string, error) {
+	names := make(map[string]string)
+	if value == "" {
+		return names, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		profile, name, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || profile == "" {
+			return nil, fmt.Errorf("profile resource name %q is not of the form profile=resource", pair)
+		}
+		if errs := validation.IsQualifiedName(name); len(errs) > 0 || !strings.Contains(name, "/") {
+			return nil, fmt.Errorf("resource %q of profile %s is not a domain qualified extended resource name", name, profile)
+		}
+		if _, duplicate := names[profile]; duplicate {
+			return nil, fmt.Errorf("profile %s is mapped to more than one resource", profile)
+		}
+		names[profile] = name
+	}
+	return names, nil
+}
+
+// profileSlices counts the slices of the profile held by pods of the instaslice, leaving out the given pod.
+func profileSlices(instaslice *inferencev1alpha1.Instaslice, profile string, excludedPodUUID string) int {
+	count := 0
+	for key, allocation := range instaslice.Spec.Allocations {
+		if key != allocation.PodUUID || allocation.PodUUID == excludedPodUUID || allocation.Profile != profile {
+			continue
+		}
+		switch allocation.Allocationstatus {
+		case inferencev1alpha1.AllocationStatusDeleting, inferencev1alpha1.AllocationStatusDeleted, inferencev1alpha1.AllocationStatusPreempted, inferencev1alpha1.AllocationStatusRetained:
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// NoopAdvertiser advertises nothing, for clusters where another component, e.g. the device plugin or a DRA
+// driver, publishes the capacity of the slices.
+type NoopAdvertiser struct{}
+
+// Advertise does nothing.
+func (NoopAdvertiser) Advertise(context.Context, string, inferencev1alpha1.AllocationDetails) error {
+	return nil
+}
+
+// Withdraw does nothing.
+func (NoopAdvertiser) Withdraw(context.Context, string, inferencev1alpha1.AllocationDetails) error {
+	return nil
+}
+
+// capacityAdvertiser returns the configured advertiser or the per pod resources when none is set.
+func (r *InstaSliceDaemonsetReconciler) capacityAdvertiser() CapacityAdvertiser {
+	if r.CapacityAdvertiser == nil {
+		return &PodResourceAdvertiser{Client: r.Client, APIReader: r.APIReader}
+	}
+	return r.CapacityAdvertiser
+}
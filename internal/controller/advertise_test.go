@@ -0,0 +1,139 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestProfileResourceAdvertiserPatchesProfileCapacity(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0, 1, 2)
+	reconciler.CapacityAdvertiser = &ProfileResourceAdvertiser{Client: fakeClient}
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.Equal(t, resource.MustParse("3"), node.Status.Capacity["instaslice.codeflare.dev/mig-1g.5gb"])
+	for resourceName := range node.Status.Capacity {
+		assert.NotContains(t, resourceName, InstaSliceResourcePrefix)
+	}
+
+	// the pod of a deleted slice no longer counts
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.Equal(t, resource.MustParse("2"), node.Status.Capacity["instaslice.codeflare.dev/mig-1g.5gb"])
+
+	// withdrawing the same slice twice does not skew the count
+	require.NoError(t, reconciler.cleanUpInstaSliceResource(ctx, allocation))
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.Equal(t, resource.MustParse("2"), node.Status.Capacity["instaslice.codeflare.dev/mig-1g.5gb"])
+}
+
+func TestProfileResourceAdvertiserRemovesUnusedProfile(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	advertiser := &ProfileResourceAdvertiser{Client: fakeClient, Prefix: "example.com/"}
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+
+	require.NoError(t, advertiser.Advertise(ctx, "node-1", allocation))
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.Equal(t, resource.MustParse("1"), node.Status.Capacity["example.com/1g.5gb"])
+
+	require.NoError(t, advertiser.Withdraw(ctx, "node-1", allocation))
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.NotContains(t, node.Status.Capacity, v1.ResourceName("example.com/1g.5gb"))
+	// restoring resources is left to the advertiser
+	reconciler.CapacityAdvertiser = advertiser
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusCreated
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, reconciler.restoreInstaSliceResources(ctx, "node-1", &instaslice))
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.NotContains(t, node.Status.Capacity, v1.ResourceName("org.instaslice/pod-0"))
+}
+
+func TestProfileResourceAdvertiserUsesMappedResourceNames(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0, 1)
+	resourceNames, err := ParseProfileResourceNames("1g.5gb=nvidia.com/mig-1g.5gb")
+	require.NoError(t, err)
+	reconciler.CapacityAdvertiser = &ProfileResourceAdvertiser{Client: fakeClient, ResourceNames: resourceNames}
+	ctx := context.Background()
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.Equal(t, resource.MustParse("2"), node.Status.Capacity["nvidia.com/mig-1g.5gb"])
+	assert.NotContains(t, node.Status.Capacity, v1.ResourceName(ProfileResourcePrefix+"1g.5gb"))
+
+	// profiles left out of the mapping keep the prefix
+	advertiser := &ProfileResourceAdvertiser{ResourceNames: resourceNames}
+	assert.Equal(t, ProfileResourcePrefix+"2g.10gb", advertiser.resourceName("2g.10gb"))
+}
+
+func TestParseProfileResourceNames(t *testing.T) {
+	names, err := ParseProfileResourceNames("1g.5gb=nvidia.com/mig-1g.5gb, 2g.10gb=nvidia.com/mig-2g.10gb")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"1g.5gb": "nvidia.com/mig-1g.5gb", "2g.10gb": "nvidia.com/mig-2g.10gb"}, names)
+
+	names, err = ParseProfileResourceNames("")
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	for _, value := range []string{"1g.5gb", "=nvidia.com/mig-1g.5gb", "1g.5gb=mig-1g.5gb", "1g.5gb=nvidia.com/mig 1g",
+		"1g.5gb=nvidia.com/a,1g.5gb=nvidia.com/b"} {
+		_, err := ParseProfileResourceNames(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestNoopAdvertiserLeavesNodeAlone(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.CapacityAdvertiser = NoopAdvertiser{}
+	ctx := context.Background()
+	var before v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &before))
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var after v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &after))
+	assert.Equal(t, before.Status.Capacity, after.Status.Capacity)
+}
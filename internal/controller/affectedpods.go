@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+	"strings"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// PodsOnGPU returns the pods with slices on the GPU, ordered by pod UUID: the ones of the allocations placed on it
+// and of the prepared entries of the slices carved on it. Pods whose slice is given up, retained or being torn down
+// need no eviction and are left out, and so are the idle slices of the pools.
+func PodsOnGPU(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) []inferencev1alpha1.AffectedPod {
+	pods := make(map[string]inferencev1alpha1.AffectedPod)
+	for podUUID, allocation := range instaslice.Spec.Allocations {
+		if allocation.GPUUUID != gpuUUID || !holdsRunningSlice(allocation) || isPoolAllocation(allocation) {
+			continue
+		}
+		pods[podUUID] = inferencev1alpha1.AffectedPod{PodUUID: podUUID, PodName: allocation.PodName, Namespace: allocation.Namespace}
+	}
+	for _, prepared := range instaslice.Status.Prepared {
+		if prepared.Parent != gpuUUID || prepared.PodUUID == "" || strings.HasPrefix(prepared.PodUUID, SlicePoolPrefix) {
+			continue
+		}
+		// the allocation of the slice is gone, only the UID of its pod is known
+		if _, exists := instaslice.Spec.Allocations[prepared.PodUUID]; !exists {
+			pods[prepared.PodUUID] = inferencev1alpha1.AffectedPod{PodUUID: prepared.PodUUID}
+		}
+	}
+	affected := make([]inferencev1alpha1.AffectedPod, 0, len(pods))
+	for _, pod := range pods {
+		affected = append(affected, pod)
+	}
+	sort.Slice(affected, func(i, j int) bool {
+		return affected[i].PodUUID < affected[j].PodUUID
+	})
+	return affected
+}
+
+// holdsRunningSlice reports whether the allocation holds or waits for the slice of a pod that is still running.
+func holdsRunningSlice(allocation inferencev1alpha1.AllocationDetails) bool {
+	switch allocation.Allocationstatus {
+	case inferencev1alpha1.AllocationStatusCreating, inferencev1alpha1.AllocationStatusCreated, inferencev1alpha1.AllocationStatusUngated:
+		return true
+	}
+	return false
+}
+
+// affectedPods returns, per cordoned or failing GPU of the node, the pods with slices on it. GPUs no pod uses
+// are left out.
+func affectedPods(instaslice *inferencev1alpha1.Instaslice, failing map[string]bool) map[string][]inferencev1alpha1.AffectedPod {
+	affected := make(map[string][]inferencev1alpha1.AffectedPod)
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		if !gpuCordoned(instaslice, gpuUUID) && !failing[gpuUUID] {
+			continue
+		}
+		if pods := PodsOnGPU(instaslice, gpuUUID); len(pods) > 0 {
+			affected[gpuUUID] = pods
+		}
+	}
+	return affected
+}
+
+// failingGPUs returns the GPUs that reported at least threshold Xid errors, none when threshold is unset.
+func failingGPUs(xidErrors map[string]int, threshold int) map[string]bool {
+	failing := make(map[string]bool)
+	if threshold <= 0 {
+		return failing
+	}
+	for gpuUUID, count := range xidErrors {
+		if count >= threshold {
+			failing[gpuUUID] = true
+		}
+	}
+	return failing
+}
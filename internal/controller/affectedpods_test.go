@@ -0,0 +1,99 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newAffectedPodsTestInstaslice returns a node with two GPUs, pod-a and pod-b running slices on GPU-1, pod-c on
+// GPU-2 and pod-d being torn down on GPU-1.
+func newAffectedPodsTestInstaslice() *inferencev1alpha1.Instaslice {
+	instaslice := newCordonTestInstaslice()
+	instaslice.Namespace = "default"
+	instaslice.Spec.CordonedGPUs = nil
+	instaslice.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{
+		"pod-uid-a": {PodUUID: "pod-uid-a", PodName: "pod-a", Namespace: "team-a", GPUUUID: "GPU-1", Profile: "1g.5gb", Start: 0, Size: 1, Allocationstatus: inferencev1alpha1.AllocationStatusUngated},
+		"pod-uid-b": {PodUUID: "pod-uid-b", PodName: "pod-b", Namespace: "team-b", GPUUUID: "GPU-1", Profile: "1g.5gb", Start: 1, Size: 1, Allocationstatus: inferencev1alpha1.AllocationStatusCreated},
+		"pod-uid-c": {PodUUID: "pod-uid-c", PodName: "pod-c", Namespace: "team-a", GPUUUID: "GPU-2", Profile: "1g.5gb", Start: 0, Size: 1, Allocationstatus: inferencev1alpha1.AllocationStatusUngated},
+		"pod-uid-d": {PodUUID: "pod-uid-d", PodName: "pod-d", Namespace: "team-a", GPUUUID: "GPU-1", Profile: "1g.5gb", Start: 2, Size: 1, Allocationstatus: inferencev1alpha1.AllocationStatusDeleting},
+	}
+	instaslice.Status.Prepared = map[string]inferencev1alpha1.PreparedDetails{
+		"MIG-A": {Profile: "1g.5gb", Parent: "GPU-1", Start: 0, Size: 1, PodUUID: "pod-uid-a"},
+		"MIG-B": {Profile: "1g.5gb", Parent: "GPU-1", Start: 1, Size: 1, PodUUID: "pod-uid-b"},
+		"MIG-C": {Profile: "1g.5gb", Parent: "GPU-2", Start: 0, Size: 1, PodUUID: "pod-uid-c"},
+		"MIG-D": {Profile: "1g.5gb", Parent: "GPU-1", Start: 2, Size: 1, PodUUID: "pod-uid-d"},
+	}
+	return instaslice
+}
+
+func TestCordonedGPUListsPodsUsingIt(t *testing.T) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(newAffectedPodsTestInstaslice()).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s}
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	require.NoError(t, reconciler.recordReconcileTime(ctx, nsName))
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Empty(t, instaslice.Status.AffectedPods)
+
+	instaslice.Spec.CordonedGPUs = []string{"GPU-1"}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	require.NoError(t, reconciler.recordReconcileTime(ctx, nsName))
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, map[string][]inferencev1alpha1.AffectedPod{
+		"GPU-1": {
+			{PodUUID: "pod-uid-a", PodName: "pod-a", Namespace: "team-a"},
+			{PodUUID: "pod-uid-b", PodName: "pod-b", Namespace: "team-b"},
+		},
+	}, instaslice.Status.AffectedPods)
+}
+
+func TestFailingGPUListsPodsUsingIt(t *testing.T) {
+	instaslice := newAffectedPodsTestInstaslice()
+
+	assert.Empty(t, affectedPods(instaslice, failingGPUs(map[string]int{"GPU-2": 3}, 0)))
+	assert.Empty(t, affectedPods(instaslice, failingGPUs(map[string]int{"GPU-2": 2}, 3)))
+	assert.Equal(t, map[string][]inferencev1alpha1.AffectedPod{
+		"GPU-2": {{PodUUID: "pod-uid-c", PodName: "pod-c", Namespace: "team-a"}},
+	}, affectedPods(instaslice, failingGPUs(map[string]int{"GPU-2": 3}, 3)))
+}
+
+func TestPodsOnGPUIncludesSlicesWithoutAllocation(t *testing.T) {
+	instaslice := newAffectedPodsTestInstaslice()
+	delete(instaslice.Spec.Allocations, "pod-uid-b")
+	// an idle slice of a pool has no pod to evict
+	instaslice.Status.Prepared["MIG-P"] = inferencev1alpha1.PreparedDetails{Profile: "1g.5gb", Parent: "GPU-1", Start: 3, Size: 1, PodUUID: "pool-1g.5gb-0"}
+
+	assert.Equal(t, []inferencev1alpha1.AffectedPod{
+		{PodUUID: "pod-uid-a", PodName: "pod-a", Namespace: "team-a"},
+		{PodUUID: "pod-uid-b"},
+	}, PodsOnGPU(instaslice, "GPU-1"))
+}
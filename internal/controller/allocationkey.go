@@ -0,0 +1,99 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// ConditionInvalidAllocations is set on the instaslice when some of its allocations are left alone by the daemonset.
+	ConditionInvalidAllocations = "InvalidAllocations"
+	// ReasonPodUUIDMismatch is the reason used when allocations are keyed by another pod UUID than their own.
+	ReasonPodUUIDMismatch = "PodUUIDMismatch"
+)
+
+// mismatchedAllocations returns in order the keys of the allocations whose PodUUID differs from their key.
+// Slices are created under the key but cleaned up under the PodUUID, acting on such an allocation would leak
+// its slice, so the daemonset leaves them alone.
+func mismatchedAllocations(instaslice *inferencev1alpha1.Instaslice) []string {
+	var mismatched []string
+	for key, allocation := range instaslice.Spec.Allocations {
+		if allocation.PodUUID != key {
+			mismatched = append(mismatched, key)
+		}
+	}
+	sort.Strings(mismatched)
+	return mismatched
+}
+
+// setMismatchedAllocationsCondition marks the status for the given mismatched allocation keys, or clears the
+// condition when there are none. It reports whether the condition changed.
+func setMismatchedAllocationsCondition(status *inferencev1alpha1.InstasliceStatus, mismatched []string) bool {
+	if len(mismatched) == 0 {
+		return meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ConditionInvalidAllocations,
+			Status:  metav1.ConditionFalse,
+			Reason:  "AllAllocationsValid",
+			Message: "every allocation is keyed by its pod UUID",
+		})
+	}
+	return meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    ConditionInvalidAllocations,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonPodUUIDMismatch,
+		Message: "allocations keyed by another pod UUID than their own: " + strings.Join(mismatched, ", "),
+	})
+}
+
+// flagMismatchedAllocations records the mismatched allocations on the latest instaslice, and clears them once
+// the allocations were fixed. It reports whether the instaslice was updated.
+func (r *InstaSliceDaemonsetReconciler) flagMismatchedAllocations(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, mismatched []string) bool {
+	if len(mismatched) == 0 && !meta.IsStatusConditionTrue(instaslice.Status.Conditions, ConditionInvalidAllocations) {
+		return false
+	}
+	for _, key := range mismatched {
+		log.FromContext(ctx).Error(fmt.Errorf("allocation keyed by pod UUID %s is for another pod", key), "ignoring allocation")
+	}
+	var updateInstasliceObject inferencev1alpha1.Instaslice
+	typeNamespacedName := types.NamespacedName{
+		Name:      instaslice.Name,
+		Namespace: r.instasliceNamespace(),
+	}
+	if err := r.Get(ctx, typeNamespacedName, &updateInstasliceObject); err != nil {
+		log.FromContext(ctx).Error(err, "unable to get instaslice to flag mismatched allocations")
+		return false
+	}
+	if !setMismatchedAllocationsCondition(&updateInstasliceObject.Status, mismatched) {
+		return false
+	}
+	if err := r.Status().Update(ctx, &updateInstasliceObject); err != nil {
+		log.FromContext(ctx).Error(err, "unable to flag mismatched allocations")
+		return false
+	}
+	return true
+}
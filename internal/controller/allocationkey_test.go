@@ -0,0 +1,74 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestAllocationKeyedByAnotherPodIsFlagged(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	instaslice.Spec.Allocations["pod-uid-other"] = instaslice.Spec.Allocations["pod-uid-1"]
+	delete(instaslice.Spec.Allocations, "pod-uid-1")
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.True(t, result.Requeue)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionInvalidAllocations)
+	require.NotNil(t, condition)
+	assert.Equal(t, ReasonPodUUIDMismatch, condition.Reason)
+	assert.Contains(t, condition.Message, "pod-uid-other")
+
+	// only the valid allocation gets a slice
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	instaslice = inferencev1alpha1.Instaslice{}
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Spec.Allocations["pod-uid-other"].Allocationstatus)
+	assert.Len(t, instaslice.Status.Prepared, 1)
+	assert.Len(t, device.GpuInstances, 1)
+
+	// once filed under its own key the allocation is realized and the condition cleared
+	instaslice.Spec.Allocations["pod-uid-1"] = instaslice.Spec.Allocations["pod-uid-other"]
+	delete(instaslice.Spec.Allocations, "pod-uid-other")
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	for i := 0; i < 2; i++ {
+		_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+		require.NoError(t, err)
+	}
+	instaslice = inferencev1alpha1.Instaslice{}
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.False(t, meta.IsStatusConditionTrue(instaslice.Status.Conditions, ConditionInvalidAllocations))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-1"].Allocationstatus)
+	assert.Len(t, device.GpuInstances, 2)
+}
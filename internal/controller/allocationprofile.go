@@ -0,0 +1,55 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// errProfileNotAvailable is returned when the profile of an allocation is not one its GPU can carve.
+var errProfileNotAvailable = errors.New("MIG profile is not available on the GPU")
+
+// resolveAllocationProfile returns the allocation with the gpu instance, compute instance and engine profile ids
+// of the profile it names, attributes included, as discovered on the node. The ids recorded with the allocation
+// are not trusted on their own, a 1g.5gb+me slice carved with the ids of the plain 1-slice profile would come out
+// as a 1g.5gb one. A profile that does not parse, was not discovered on the node or that the GPU does not support
+// is an errProfileNotAvailable, no other profile is carved in its place.
+func resolveAllocationProfile(device nvml.Device, instaslice inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) (inferencev1alpha1.AllocationDetails, error) {
+	giProfileID, ciProfileID, ciEngProfileID, _, err := ResolveProfileIDs(allocation.Profile, instaslice.Spec.Migplacement)
+	if err != nil {
+		return allocation, fmt.Errorf("%w: %v", errProfileNotAvailable, err)
+	}
+	_, ret := device.GetGpuInstanceProfileInfo(giProfileID)
+	if errLost := checkNVMLHandle("GetGpuInstanceProfileInfo", ret); errLost != nil {
+		return allocation, errLost
+	}
+	if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+		return allocation, fmt.Errorf("%w: MIG profile %q of gpu instance profile %d: %v", errProfileNotAvailable, allocation.Profile, giProfileID, ret)
+	}
+	if ret != nvml.SUCCESS {
+		return allocation, fmt.Errorf("unable to get gpu instance profile %d: %w", giProfileID, ret)
+	}
+	allocation.Giprofileid = giProfileID
+	allocation.CIProfileID = ciProfileID
+	allocation.CIEngProfileID = ciEngProfileID
+	return allocation, nil
+}
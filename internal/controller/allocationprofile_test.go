@@ -0,0 +1,109 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// setAllocationProfile names another profile on the allocation of the pod, leaving its profile ids as they are.
+func setAllocationProfile(t *testing.T, fakeClient client.Client, podUUID string, profile string, size uint32) {
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	allocation := instaslice.Spec.Allocations[podUUID]
+	allocation.Profile = profile
+	allocation.Size = size
+	instaslice.Spec.Allocations[podUUID] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+}
+
+func TestMediaExtensionAllocationIsCarvedWithMediaExtensionProfile(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	// the allocation carries the ids of the plain 1-slice profile
+	setAllocationProfile(t, fakeClient, "pod-uid-0", "1g.5gb+me", 1)
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	require.Len(t, device.GpuInstances, 1)
+	for gi := range device.GpuInstances {
+		assert.Equal(t, uint32(nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1), gi.Info.ProfileId)
+	}
+	require.Len(t, instaslice.Status.Prepared, 1)
+	for _, prepared := range instaslice.Status.Prepared {
+		assert.Equal(t, "1g.5gb+me", prepared.Profile)
+	}
+}
+
+func TestUnavailableProfileMarksAllocationFailed(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	// 2g.10gb+me is a valid name, but the GPUs of the node have no such profile
+	setAllocationProfile(t, fakeClient, "pod-uid-0", "2g.10gb+me", 2)
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusFailed, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, ReasonProfileUnsupported, instaslice.Status.UnschedulableOnNode["pod-uid-0"].Reason)
+	assert.Empty(t, device.GpuInstances, "no other profile is carved in its place")
+	assert.Empty(t, instaslice.Status.Prepared)
+}
+
+func TestResolveAllocationProfileRejectsProfileUnsupportedByGPU(t *testing.T) {
+	_, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Profile = "1g.5gb+me"
+
+	resolved, err := resolveAllocationProfile(device, instaslice, allocation)
+	require.NoError(t, err)
+	assert.Equal(t, nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1, resolved.Giprofileid)
+	assert.Equal(t, nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, resolved.CIProfileID)
+
+	// discovered on the node, but not offered by this GPU
+	getGpuInstanceProfileInfo := device.GetGpuInstanceProfileInfoFunc
+	device.GetGpuInstanceProfileInfoFunc = func(profile int) (nvml.GpuInstanceProfileInfo, nvml.Return) {
+		if profile == nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1 {
+			return nvml.GpuInstanceProfileInfo{}, nvml.ERROR_NOT_SUPPORTED
+		}
+		return getGpuInstanceProfileInfo(profile)
+	}
+	_, err = resolveAllocationProfile(device, instaslice, allocation)
+	assert.ErrorIs(t, err, errProfileNotAvailable)
+	assert.True(t, hardSliceCreationFailure(err))
+}
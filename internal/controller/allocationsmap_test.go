@@ -0,0 +1,63 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// allocationsDroppingClient hands out instaslices without allocations once a
+// slice was carved, as if the allocation was removed while the slice was created.
+type allocationsDroppingClient struct {
+	client.Client
+	device *dgxa100.Device
+}
+
+func (c *allocationsDroppingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if err := c.Client.Get(ctx, key, obj, opts...); err != nil {
+		return err
+	}
+	if instaslice, ok := obj.(*inferencev1alpha1.Instaslice); ok && len(c.device.GpuInstances) > 0 {
+		instaslice.Spec.Allocations = nil
+	}
+	return nil
+}
+
+func TestCreatedSliceIsRecordedWithoutAllocations(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.Client = &allocationsDroppingClient{Client: fakeClient, device: device}
+	ctx := context.Background()
+
+	assert.NotPanics(t, func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+		require.NoError(t, err)
+	})
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	require.Contains(t, instaslice.Spec.Allocations, "pod-uid-0")
+	assert.Len(t, device.GpuInstances, 1)
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// checkAllocationTransitions compares the status of every allocation of the instaslice with the one it was last
+// seen in or set to by the daemonset. Allocations that went through a transition the lifecycle does not allow,
+// e.g. back to creating from a stale read after their slice was realized, are logged and left alone by this
+// reconcile, their last legal status is kept until they reach one that follows from it.
+func (r *InstaSliceDaemonsetReconciler) checkAllocationTransitions(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) {
+	r.allocationStatusesMu.Lock()
+	defer r.allocationStatusesMu.Unlock()
+	seen := make(map[string]inferencev1alpha1.AllocationStatus)
+	illegal := make(map[string]bool)
+	for key, allocation := range instaslice.Spec.Allocations {
+		previous := r.allocationStatuses[key]
+		if !previous.CanTransitionTo(allocation.Allocationstatus) {
+			log.FromContext(ctx).Info("skipping allocation in an illegal status transition for ", "pod", allocation.PodName,
+				"from", previous, "to", allocation.Allocationstatus)
+			seen[key] = previous
+			illegal[key] = true
+			continue
+		}
+		seen[key] = allocation.Allocationstatus
+	}
+	r.allocationStatuses = seen
+	r.illegalTransitions = illegal
+}
+
+// illegalTransition reports whether the last check found the allocation of the pod in an illegal status transition.
+func (r *InstaSliceDaemonsetReconciler) illegalTransition(podUUID string) bool {
+	r.allocationStatusesMu.Lock()
+	defer r.allocationStatusesMu.Unlock()
+	return r.illegalTransitions[podUUID]
+}
+
+// recordAllocationStatus remembers the status the daemonset set the allocation of the pod to, so that a stale
+// read of the previous one is not acted on. An empty status forgets the allocation.
+func (r *InstaSliceDaemonsetReconciler) recordAllocationStatus(podUUID string, status inferencev1alpha1.AllocationStatus) {
+	r.allocationStatusesMu.Lock()
+	defer r.allocationStatusesMu.Unlock()
+	if status == "" {
+		delete(r.allocationStatuses, podUUID)
+		return
+	}
+	if r.allocationStatuses == nil {
+		r.allocationStatuses = make(map[string]inferencev1alpha1.AllocationStatus)
+	}
+	r.allocationStatuses[podUUID] = status
+	delete(r.illegalTransitions, podUUID)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestCheckAllocationTransitions(t *testing.T) {
+	reconciler := &InstaSliceDaemonsetReconciler{}
+	ctx := context.Background()
+	instaslice := &inferencev1alpha1.Instaslice{Spec: inferencev1alpha1.InstasliceSpec{
+		Allocations: map[string]inferencev1alpha1.AllocationDetails{
+			"pod-a": {PodUUID: "pod-a", Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+			"pod-b": {PodUUID: "pod-b", Allocationstatus: inferencev1alpha1.AllocationStatusCreated},
+		},
+	}}
+	reconciler.checkAllocationTransitions(ctx, instaslice)
+	assert.False(t, reconciler.illegalTransition("pod-a"))
+	assert.False(t, reconciler.illegalTransition("pod-b"))
+
+	// a stale read of pod-b back in creating
+	instaslice.Spec.Allocations["pod-a"] = inferencev1alpha1.AllocationDetails{PodUUID: "pod-a", Allocationstatus: inferencev1alpha1.AllocationStatusCreated}
+	instaslice.Spec.Allocations["pod-b"] = inferencev1alpha1.AllocationDetails{PodUUID: "pod-b", Allocationstatus: inferencev1alpha1.AllocationStatusCreating}
+	reconciler.checkAllocationTransitions(ctx, instaslice)
+	assert.False(t, reconciler.illegalTransition("pod-a"))
+	assert.True(t, reconciler.illegalTransition("pod-b"))
+
+	// the last legal status is kept, the allocation is handled again once it follows from it
+	instaslice.Spec.Allocations["pod-b"] = inferencev1alpha1.AllocationDetails{PodUUID: "pod-b", Allocationstatus: inferencev1alpha1.AllocationStatusUngated}
+	reconciler.checkAllocationTransitions(ctx, instaslice)
+	assert.False(t, reconciler.illegalTransition("pod-b"))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, reconciler.allocationStatuses["pod-b"])
+
+	// removed allocations are forgotten
+	delete(instaslice.Spec.Allocations, "pod-a")
+	reconciler.checkAllocationTransitions(ctx, instaslice)
+	assert.NotContains(t, reconciler.allocationStatuses, "pod-a")
+}
+
+func TestStaleCreatingAllocationIsNotCarvedAgain(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	// the daemonset already realized the slice, the instaslice read lags behind
+	reconciler.recordAllocationStatus("pod-uid-0", inferencev1alpha1.AllocationStatusCreated)
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Empty(t, device.GpuInstances)
+	assert.True(t, reconciler.illegalTransition("pod-uid-0"))
+}
+
+func TestCreatedSliceIsRecordedAsCreated(t *testing.T) {
+	reconciler, _, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, reconciler.allocationStatuses["pod-uid-0"])
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// TTLAnnotation set to a duration, e.g. 2h, makes the slice of the pod expire that long after it is allocated.
+	TTLAnnotation = "org.instaslice/ttl"
+	// EventReasonSliceExpired is the reason of the event emitted on a pod whose slice is reaped past its TTL.
+	EventReasonSliceExpired = "SliceExpired"
+)
+
+// IdleCriterion tells when the slice of an expired allocation is idle and can be reaped.
+type IdleCriterion string
+
+const (
+	// IdleCriterionPodCompleted reaps the slice once its pod succeeded, failed or is gone.
+	IdleCriterionPodCompleted IdleCriterion = "pod-completed"
+	// IdleCriterionNoUtilization reaps the slice once every MIG device of it reports no utilization.
+	IdleCriterionNoUtilization IdleCriterion = "no-utilization"
+	// IdleCriterionAny reaps the slice once either of the criteria above is met.
+	IdleCriterionAny IdleCriterion = "any"
+)
+
+// ParseIdleCriterion validates an idle criterion name, an empty name is IdleCriterionPodCompleted.
+func ParseIdleCriterion(value string) (IdleCriterion, error) {
+	switch IdleCriterion(value) {
+	case "", IdleCriterionPodCompleted:
+		return IdleCriterionPodCompleted, nil
+	case IdleCriterionNoUtilization, IdleCriterionAny:
+		return IdleCriterion(value), nil
+	}
+	return "", fmt.Errorf("unknown idle criterion %q, must be %s, %s or %s", value, IdleCriterionPodCompleted, IdleCriterionNoUtilization, IdleCriterionAny)
+}
+
+// recordAllocationTTL sets when the allocation expires from the TTL annotation of the pod, or from the default
+// TTL of the controller when the pod has none. Allocations of pods without any TTL never expire.
+func (r *InstasliceReconciler) recordAllocationTTL(allocDetails *inferencev1alpha1.AllocationDetails, pod *v1.Pod) {
+	ttl := r.DefaultAllocationTTL
+	if value, exists := pod.Annotations[TTLAnnotation]; exists {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Log.Info("ignoring invalid TTL annotation of ", "pod", pod.Name, "value", value)
+		} else {
+			ttl = parsed
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+	expiresAt := metav1.NewTime(now().Add(ttl))
+	allocDetails.ExpiresAt = &expiresAt
+}
+
+// allocationExpired reports whether the slice of the allocation is realized and past its TTL.
+func allocationExpired(allocation inferencev1alpha1.AllocationDetails) bool {
+	if allocation.ExpiresAt == nil || !settledAllocation(allocation) {
+		return false
+	}
+	return !now().Time.Before(allocation.ExpiresAt.Time)
+}
+
+// idleCriterion returns the criterion the expired slices of the node are reaped on.
+func (r *InstaSliceDaemonsetReconciler) idleCriterion() IdleCriterion {
+	if r.IdleCriterion == "" {
+		return IdleCriterionPodCompleted
+	}
+	return r.IdleCriterion
+}
+
+// allocationIdle reports whether the slice of the expired allocation is idle by the criterion of the node.
+func (r *InstaSliceDaemonsetReconciler) allocationIdle(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) (bool, error) {
+	criterion := r.idleCriterion()
+	if criterion == IdleCriterionPodCompleted || criterion == IdleCriterionAny {
+		completed, err := r.podCompleted(ctx, allocation)
+		if err != nil || completed || criterion == IdleCriterionPodCompleted {
+			return completed, err
+		}
+	}
+	return r.sliceUnused(instaslice, allocation)
+}
+
+// podCompleted reports whether the pod of the allocation succeeded, failed or is gone.
+func (r *InstaSliceDaemonsetReconciler) podCompleted(ctx context.Context, allocation inferencev1alpha1.AllocationDetails) (bool, error) {
+	var pod v1.Pod
+	err := r.Get(ctx, types.NamespacedName{Name: allocation.PodName, Namespace: allocation.Namespace}, &pod)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if pod.UID != "" && string(pod.UID) != allocation.PodUUID {
+		return true, nil
+	}
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed, nil
+}
+
+// sliceUnused reports whether every MIG device prepared for the pod of the allocation reports no utilization.
+// A slice whose utilization is unknown is not unused.
+func (r *InstaSliceDaemonsetReconciler) sliceUnused(instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) (bool, error) {
+	nvmllib := r.handler().nvml
+	found := false
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID != allocation.PodUUID {
+			continue
+		}
+		found = true
+		device, ret := nvmllib.DeviceGetHandleByUUID(migUUID)
+		if ret != nvml.SUCCESS {
+			return false, fmt.Errorf("unable to get MIG device %s: %v", migUUID, ret)
+		}
+		utilization, ret := device.GetUtilizationRates()
+		if ret == nvml.ERROR_NOT_SUPPORTED {
+			return false, nil
+		}
+		if ret != nvml.SUCCESS {
+			return false, fmt.Errorf("unable to get the utilization of MIG device %s: %v", migUUID, ret)
+		}
+		if utilization.Gpu > 0 {
+			return false, nil
+		}
+	}
+	return found, nil
+}
+
+// expireIdleAllocations moves the expired allocations of the node whose slice is idle to deleting, for their
+// slices to be torn down and the allocations removed like the ones of deleted pods. It reports whether any was.
+func (r *InstaSliceDaemonsetReconciler) expireIdleAllocations(ctx context.Context, nodeName string, instaslice *inferencev1alpha1.Instaslice) (bool, error) {
+	var expired []string
+	for podUUID, allocation := range instaslice.Spec.Allocations {
+		if !allocationExpired(allocation) {
+			continue
+		}
+		idle, err := r.allocationIdle(ctx, instaslice, allocation)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "unable to tell whether the expired slice is idle, keeping it for now", "pod", allocation.PodName)
+			continue
+		}
+		if idle {
+			expired = append(expired, podUUID)
+		}
+	}
+	if len(expired) == 0 {
+		return false, nil
+	}
+	sort.Strings(expired)
+	var reaped []inferencev1alpha1.AllocationDetails
+	_, err := r.updateInstaslice(ctx, nodeName, func(latest *inferencev1alpha1.Instaslice) error {
+		reaped = reaped[:0]
+		for _, podUUID := range expired {
+			// the allocation may have moved on meanwhile, e.g. the pod was deleted
+			allocation, exists := latest.Spec.Allocations[podUUID]
+			if !exists || !allocationExpired(allocation) {
+				continue
+			}
+			allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+			latest.Spec.Allocations[podUUID] = allocation
+			reaped = append(reaped, allocation)
+		}
+		if len(reaped) == 0 {
+			return errInstasliceUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, allocation := range reaped {
+		log.FromContext(ctx).Info("slice expired while idle, deleting slice of ", "pod", allocation.PodName)
+		r.recordSliceExpired(allocation)
+	}
+	return len(reaped) > 0, nil
+}
+
+// recordSliceExpired emits a warning event on the pod whose slice is reaped past its TTL.
+func (r *InstaSliceDaemonsetReconciler) recordSliceExpired(allocation inferencev1alpha1.AllocationDetails) {
+	if r.Recorder == nil {
+		return
+	}
+	pod := &v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Name:       allocation.PodName,
+		Namespace:  allocation.Namespace,
+		UID:        types.UID(allocation.PodUUID),
+	}
+	r.Recorder.Eventf(pod, v1.EventTypeWarning, EventReasonSliceExpired,
+		"Slice %s on GPU %s expired at %s while idle, tearing it down", allocation.Profile, allocation.GPUUUID, allocation.ExpiresAt.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// expireTestAllocation carves the slice of pod-0 and sets its allocation to expire at the given time.
+func expireTestAllocation(t *testing.T, reconciler *InstaSliceDaemonsetReconciler, fakeClient client.Client, expiresAt time.Time) {
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	instaslice := latestTestInstaslice(t, fakeClient)
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	require.Equal(t, inferencev1alpha1.AllocationStatusCreated, allocation.Allocationstatus)
+	expires := metav1.NewTime(expiresAt)
+	allocation.ExpiresAt = &expires
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, instaslice))
+}
+
+func runningTestPod() *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default", UID: "pod-uid-0"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+func latestTestInstaslice(t *testing.T, fakeClient client.Client) *inferencev1alpha1.Instaslice {
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	return &instaslice
+}
+
+func TestExpiredIdleAllocationIsReaped(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	// the pod is gone, its slice is idle
+	expireTestAllocation(t, reconciler, fakeClient, time.Now().Add(-time.Minute))
+	require.Len(t, device.GpuInstances, 1)
+	recorder := record.NewFakeRecorder(10)
+	reconciler.Recorder = recorder
+
+	for i := 0; i < 2; i++ {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+		require.NoError(t, err)
+	}
+
+	instaslice := latestTestInstaslice(t, fakeClient)
+	assert.Empty(t, instaslice.Spec.Allocations)
+	assert.Empty(t, instaslice.Status.Prepared)
+	assert.Empty(t, device.GpuInstances)
+	require.NotEmpty(t, recorder.Events)
+	assert.Contains(t, <-recorder.Events, EventReasonSliceExpired)
+}
+
+func TestExpiredAllocationOfRunningPodIsKept(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	require.NoError(t, fakeClient.Create(ctx, runningTestPod()))
+	expireTestAllocation(t, reconciler, fakeClient, time.Now().Add(-time.Minute))
+
+	expired, err := reconciler.expireIdleAllocations(ctx, "node-1", latestTestInstaslice(t, fakeClient))
+	require.NoError(t, err)
+	assert.False(t, expired)
+	assert.Len(t, device.GpuInstances, 1)
+
+	// the slices of the fake run nothing, they are idle by their utilization
+	reconciler.IdleCriterion = IdleCriterionAny
+	expired, err = reconciler.expireIdleAllocations(ctx, "node-1", latestTestInstaslice(t, fakeClient))
+	require.NoError(t, err)
+	assert.True(t, expired)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, latestTestInstaslice(t, fakeClient).Spec.Allocations["pod-uid-0"].Allocationstatus)
+}
+
+func TestAllocationBeforeItsTTLIsKept(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	expireTestAllocation(t, reconciler, fakeClient, time.Now().Add(time.Hour))
+
+	expired, err := reconciler.expireIdleAllocations(ctx, "node-1", latestTestInstaslice(t, fakeClient))
+	require.NoError(t, err)
+	assert.False(t, expired)
+}
+
+func TestRecordAllocationTTL(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := runningTestPod()
+	var allocation inferencev1alpha1.AllocationDetails
+	r.recordAllocationTTL(&allocation, pod)
+	assert.Nil(t, allocation.ExpiresAt)
+
+	r.DefaultAllocationTTL = time.Hour
+	r.recordAllocationTTL(&allocation, pod)
+	require.NotNil(t, allocation.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), allocation.ExpiresAt.Time, time.Minute)
+
+	pod.Annotations = map[string]string{TTLAnnotation: "10m"}
+	r.recordAllocationTTL(&allocation, pod)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), allocation.ExpiresAt.Time, time.Minute)
+
+	// an invalid annotation falls back to the default
+	pod.Annotations[TTLAnnotation] = "soon"
+	r.recordAllocationTTL(&allocation, pod)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), allocation.ExpiresAt.Time, time.Minute)
+}
+
+func TestParseIdleCriterion(t *testing.T) {
+	criterion, err := ParseIdleCriterion("")
+	require.NoError(t, err)
+	assert.Equal(t, IdleCriterionPodCompleted, criterion)
+	criterion, err = ParseIdleCriterion("no-utilization")
+	require.NoError(t, err)
+	assert.Equal(t, IdleCriterionNoUtilization, criterion)
+	_, err = ParseIdleCriterion("never")
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// gpuAllowsProfile reports whether slices of the profile may be placed on the GPU, every profile is allowed on
+// GPUs without an entry in spec.allowedProfiles.
+func gpuAllowsProfile(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profile string) bool {
+	allowed, restricted := instaslice.Spec.AllowedProfiles[gpuUUID]
+	if !restricted {
+		return true
+	}
+	for _, allowedProfile := range allowed {
+		if allowedProfile == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// profileOffered reports whether some GPU of the node allows slices of the profile, which every profile is when
+// no GPU is restricted.
+func profileOffered(instaslice *inferencev1alpha1.Instaslice, profile string) bool {
+	if len(instaslice.Spec.AllowedProfiles) == 0 {
+		return true
+	}
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		if gpuAllowsProfile(instaslice, gpuUUID, profile) {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidAllowedProfiles describes, in order, the entries of spec.allowedProfiles naming a GPU the node does not
+// have or a profile its GPUs do not support. They never match and most likely are typos.
+func invalidAllowedProfiles(instaslice *inferencev1alpha1.Instaslice) []string {
+	supported := make(map[string]bool, len(instaslice.Spec.Migplacement))
+	for _, mig := range instaslice.Spec.Migplacement {
+		supported[mig.Profile] = true
+	}
+	var invalid []string
+	for gpuUUID, allowed := range instaslice.Spec.AllowedProfiles {
+		if _, known := instaslice.Spec.MigGPUUUID[gpuUUID]; !known {
+			invalid = append(invalid, fmt.Sprintf("GPU %s is not on the node", gpuUUID))
+			continue
+		}
+		for _, profile := range allowed {
+			if !supported[profile] {
+				invalid = append(invalid, fmt.Sprintf("profile %s allowed on GPU %s is not supported", profile, gpuUUID))
+			}
+		}
+	}
+	sort.Strings(invalid)
+	return invalid
+}
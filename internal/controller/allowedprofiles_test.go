@@ -0,0 +1,95 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newAllowedProfilesTestInstaslice returns a node with two empty A100 GPUs, GPU-1 offering 7g.40gb alone.
+func newAllowedProfilesTestInstaslice() *inferencev1alpha1.Instaslice {
+	return &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"},
+		Spec: inferencev1alpha1.InstasliceSpec{
+			MigGPUUUID: map[string]string{"GPU-1": "NVIDIA A100-SXM4-40GB", "GPU-2": "NVIDIA A100-SXM4-40GB"},
+			Migplacement: []inferencev1alpha1.Mig{
+				{Profile: "1g.5gb", Placements: []inferencev1alpha1.Placement{{Size: 1, Start: 0}, {Size: 1, Start: 1}}},
+				{Profile: "3g.20gb", Placements: []inferencev1alpha1.Placement{{Size: 4, Start: 0}, {Size: 4, Start: 4}}},
+				{Profile: "7g.40gb", Placements: []inferencev1alpha1.Placement{{Size: 8, Start: 0}}},
+			},
+			AllowedProfiles: map[string][]string{"GPU-1": {"7g.40gb"}},
+		},
+	}
+}
+
+func TestResourceSlicesAdvertiseAllowedProfilesOnly(t *testing.T) {
+	slices := desiredResourceSlices(newAllowedProfilesTestInstaslice(), 1)
+	require.Len(t, slices, 2)
+
+	profilesOf := func(slice *unstructured.Unstructured) []string {
+		devices, _, _ := unstructured.NestedSlice(slice.Object, "spec", "devices")
+		var profiles []string
+		for _, device := range devices {
+			profile, _, _ := unstructured.NestedString(device.(map[string]interface{}), "basic", "attributes", "profile", "string")
+			profiles = append(profiles, profile)
+		}
+		return profiles
+	}
+	// GPU 0 is restricted to 7g.40gb while GPU 1 offers every profile of the node
+	assert.Equal(t, []string{"7g.40gb"}, profilesOf(slices[0]))
+	assert.Equal(t, []string{"1g.5gb", "1g.5gb", "3g.20gb", "3g.20gb", "7g.40gb"}, profilesOf(slices[1]))
+}
+
+func TestPlacementHonorsAllowedProfiles(t *testing.T) {
+	r := &InstasliceReconciler{}
+	instaslice := newAllowedProfilesTestInstaslice()
+
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+	allocation, err := r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, "GPU-2", allocation.GPUUUID)
+	assert.False(t, CanPlace(instaslice, "1g.5gb", "GPU-1"))
+	assert.True(t, CanPlace(instaslice, "7g.40gb", "GPU-1"))
+
+	// GPU-1 still counts for 7g.40gb only
+	assert.Equal(t, map[string]int{"1g.5gb": 2, "3g.20gb": 2, "7g.40gb": 2}, freeSlicesPerProfile(instaslice))
+
+	// a profile no GPU allows is reported as such
+	instaslice.Spec.AllowedProfiles["GPU-2"] = []string{"7g.40gb"}
+	assert.Equal(t, ReasonProfileNotAllowed, unschedulableReason(instaslice, "1g.5gb"))
+	assert.Equal(t, map[string]int{"7g.40gb": 2}, freeSlicesPerProfile(instaslice))
+}
+
+func TestInvalidAllowedProfiles(t *testing.T) {
+	instaslice := newAllowedProfilesTestInstaslice()
+	assert.Empty(t, invalidAllowedProfiles(instaslice))
+
+	instaslice.Spec.AllowedProfiles["GPU-2"] = []string{"7g.80gb"}
+	instaslice.Spec.AllowedProfiles["GPU-3"] = []string{"1g.5gb"}
+	assert.Equal(t, []string{
+		"GPU GPU-3 is not on the node",
+		"profile 7g.80gb allowed on GPU GPU-2 is not supported",
+	}, invalidAllowedProfiles(instaslice))
+}
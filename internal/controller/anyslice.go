@@ -0,0 +1,109 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// AnySliceResource is the limit a pod sets to get the smallest slice a node can place instead of a
+	// slice of a given profile.
+	AnySliceResource = "nvidia.com/mig-any"
+	// AnySliceProfile stands for the profile of a pod limiting AnySliceResource until a node resolves it.
+	AnySliceProfile = "any"
+)
+
+// profilesBySize returns the profiles of the node from the smallest to the largest, ordered by the memory
+// slices they span, then their memory and their name.
+func profilesBySize(instaslice *inferencev1alpha1.Instaslice) []string {
+	type profileSize struct {
+		name     string
+		size     int
+		memoryGB int
+	}
+	var profiles []profileSize
+	for _, mig := range instaslice.Spec.Migplacement {
+		if len(mig.Placements) == 0 {
+			continue
+		}
+		profiles = append(profiles, profileSize{name: mig.Profile, size: mig.Placements[0].Size, memoryGB: profileMemoryGB(mig.Profile)})
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].size != profiles[j].size {
+			return profiles[i].size < profiles[j].size
+		}
+		if profiles[i].memoryGB != profiles[j].memoryGB {
+			return profiles[i].memoryGB < profiles[j].memoryGB
+		}
+		return profiles[i].name < profiles[j].name
+	})
+	names := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		names = append(names, profile.name)
+	}
+	return names
+}
+
+// profileMemoryGB returns the memory of a profile named like 1g.5gb, zero when the name carries none.
+func profileMemoryGB(profile string) int {
+	_, memory, found := strings.Cut(profile, ".")
+	if !found {
+		return 0
+	}
+	memory, _, _ = strings.Cut(memory, "gb")
+	gb, err := strconv.Atoi(memory)
+	if err != nil {
+		return 0
+	}
+	return gb
+}
+
+// candidateProfiles returns the profiles of the node a slice of the requested profile may be carved for, from the
+// smallest to the largest: all of them for AnySliceProfile, the ones with enough memory for a memory request.
+// Profiles larger than the memory of a GPU left once the reserved memory is set aside are left out. It returns
+// false for a profile the pod named itself.
+func candidateProfiles(instaslice *inferencev1alpha1.Instaslice, profileName string) ([]string, bool) {
+	var profiles []string
+	if profileName == AnySliceProfile {
+		profiles = profilesBySize(instaslice)
+	} else if gb, ok := requestedMemoryGB(profileName); ok {
+		profiles = profilesWithMemory(instaslice, gb)
+	} else {
+		return nil, false
+	}
+	candidates := profiles[:0]
+	for _, profile := range profiles {
+		if profileFitsReservedMemory(instaslice, profile) {
+			candidates = append(candidates, profile)
+		}
+	}
+	return candidates, true
+}
+
+// nodeProfileFor returns the profile the node stands for the requested one: the smallest profile of the node
+// for AnySliceProfile, the smallest with enough memory for a memory request, the requested profile otherwise.
+func nodeProfileFor(instaslice *inferencev1alpha1.Instaslice, profileName string) string {
+	if profiles, _ := candidateProfiles(instaslice, profileName); len(profiles) > 0 {
+		return profiles[0]
+	}
+	return profileName
+}
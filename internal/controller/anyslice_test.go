@@ -0,0 +1,71 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newAnySliceTestInstaslice returns a node with free 1g.5gb and 2g.10gb placements, listing the larger profile first.
+func newAnySliceTestInstaslice() *inferencev1alpha1.Instaslice {
+	instaslice := newPlacementTestInstaslice()
+	instaslice.Status.Prepared = nil
+	instaslice.Spec.Migplacement = append([]inferencev1alpha1.Mig{
+		{Profile: "2g.10gb", Giprofileid: 5, Placements: []inferencev1alpha1.Placement{{Size: 2, Start: 0}, {Size: 2, Start: 2}, {Size: 2, Start: 4}}},
+	}, instaslice.Spec.Migplacement...)
+	return instaslice
+}
+
+func TestFindDeviceForAnySlicePicksSmallestProfile(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+
+	allocation, err := r.findDeviceForASlice(newAnySliceTestInstaslice(), AnySliceProfile, &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, "1g.5gb", allocation.Profile)
+	assert.Equal(t, uint32(1), allocation.Size)
+}
+
+func TestFindDeviceForAnySliceFallsBackToLargerProfile(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+	instaslice := newAnySliceTestInstaslice()
+	// no GPU allows 1g.5gb slices
+	instaslice.Spec.AllowedProfiles = map[string][]string{"GPU-1": {"2g.10gb"}}
+
+	allocation, err := r.findDeviceForASlice(instaslice, AnySliceProfile, &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, "2g.10gb", allocation.Profile)
+	assert.Equal(t, uint32(2), allocation.Size)
+}
+
+func TestExtractProfileNameOfAnySlice(t *testing.T) {
+	r := &InstasliceReconciler{}
+	limits := v1.ResourceList{AnySliceResource: resource.MustParse("1")}
+
+	assert.Equal(t, AnySliceProfile, r.extractProfileName(limits))
+	assert.Equal(t, "1g.5gb", nodeProfileFor(newAnySliceTestInstaslice(), AnySliceProfile))
+	assert.Equal(t, "2g.10gb", nodeProfileFor(newAnySliceTestInstaslice(), "2g.10gb"))
+}
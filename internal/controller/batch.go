@@ -0,0 +1,234 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// batchCreationThreshold is the number of pending allocations from which slices are created in one batch.
+const batchCreationThreshold = 2
+
+// batchAllocations returns the allocations the batch path can realize, ordered by pod UUID.
+// Nothing is returned while slices are being deleted, they may free the placements the batch targets.
+func batchAllocations(instaslice *inferencev1alpha1.Instaslice) []inferencev1alpha1.AllocationDetails {
+	var pending []inferencev1alpha1.AllocationDetails
+	for key, allocation := range instaslice.Spec.Allocations {
+		if key != allocation.PodUUID {
+			continue
+		}
+		if allocation.Allocationstatus == inferencev1alpha1.AllocationStatusDeleting {
+			return nil
+		}
+		if allocation.Allocationstatus != inferencev1alpha1.AllocationStatusCreating || allocation.ReusedFrom != "" || isPoolAllocation(allocation) {
+			continue
+		}
+		if _, exists := instaslice.Spec.MigGPUUUID[allocation.GPUUUID]; !exists {
+			continue
+		}
+		pending = append(pending, allocation)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].PodUUID < pending[j].PodUUID
+	})
+	return pending
+}
+
+// createSlicesInBatch realizes all pending allocations of the node with a single NVML session, on up to
+// MaxParallelGPUs GPUs at once, then records them with one instaslice update. Slices that fail stay in creating and are retried on the next reconcile, until
+// the retry policy gives up on them, while the others are committed. It returns false when there are too
+// few allocations for a batch.
+func (r *InstaSliceDaemonsetReconciler) createSlicesInBatch(ctx context.Context, nodeName string, instaslice *inferencev1alpha1.Instaslice) (bool, error) {
+	if instaslice.Status.Processed != "true" {
+		return false, nil
+	}
+	var pending []inferencev1alpha1.AllocationDetails
+	for _, allocation := range batchAllocations(instaslice) {
+		if !r.illegalTransition(allocation.PodUUID) {
+			pending = append(pending, allocation)
+		}
+	}
+	if len(pending) < batchCreationThreshold {
+		return false, nil
+	}
+	log.FromContext(ctx).Info("creating slices in batch", "count", len(pending))
+
+	nvmllib := r.handler().nvml
+	created, failed, err := r.realizeBatchSlices(ctx, nvmllib, nodeName, instaslice, pending)
+	if err != nil {
+		return true, err
+	}
+	// slices deferred by the rate limit make the whole batch retry once the rate allows it
+	var deferred error
+	for podName, err := range failed {
+		if _, throttled := sliceOperationRetryAfter(err); throttled {
+			log.FromContext(ctx).Info("slice of batch deferred, retrying for ", "pod", podName)
+			deferred = err
+			continue
+		}
+		log.FromContext(ctx).Error(err, "slice of batch not created, retrying for ", "pod", podName)
+	}
+	if len(created) == 0 {
+		if deferred != nil {
+			return true, deferred
+		}
+		return true, fmt.Errorf("no slice of the batch could be created")
+	}
+
+	var updateInstasliceObject inferencev1alpha1.Instaslice
+	typeNamespacedName := types.NamespacedName{
+		Name:      instaslice.Name,
+		Namespace: r.instasliceNamespace(),
+	}
+	if err := r.Get(ctx, typeNamespacedName, &updateInstasliceObject); err != nil {
+		return true, err
+	}
+	if updateInstasliceObject.Status.Prepared == nil {
+		updateInstasliceObject.Status.Prepared = make(map[string]inferencev1alpha1.PreparedDetails)
+	}
+	durations := make(map[string]metav1.Duration)
+	var duplicates []string
+	var committed []inferencev1alpha1.AllocationDetails
+	for _, allocation := range created {
+		createdSliceDetails, _ := r.slices.cached(allocation.PodName)
+		// the placement of the allocation was taken, the slice is recorded where it was carved instead
+		if createdSliceDetails.relocated {
+			allocation.Start = createdSliceDetails.start
+		}
+		entries := make(map[string]inferencev1alpha1.PreparedDetails)
+		conflict := false
+		for _, ci := range createdSliceDetails.migDevices() {
+			prepared := inferencev1alpha1.PreparedDetails{
+				Profile:  allocation.Profile,
+				Start:    allocation.Start,
+				Size:     allocation.Size,
+				Parent:   allocation.GPUUUID,
+				PodUUID:  allocation.PodUUID,
+				Giinfoid: createdSliceDetails.gid,
+				Ciinfoid: ci.cid,
+				GPUModel: updateInstasliceObject.Spec.MigGPUUUID[allocation.GPUUUID],
+				CarvedBy: r.operatorVersion(),
+			}
+			// the allocation stays in creating rather than untracking the slice already known under the MIG UUID
+			if preparedConflicts(updateInstasliceObject.Status.Prepared, ci.miguuid, prepared) {
+				log.FromContext(ctx).Error(duplicateMigUUIDError(ci.miguuid, updateInstasliceObject.Status.Prepared[ci.miguuid], prepared),
+					"refusing to overwrite prepared entry for ", "pod", allocation.PodName)
+				duplicates = append(duplicates, ci.miguuid)
+				conflict = true
+			}
+			entries[ci.miguuid] = prepared
+		}
+		if conflict {
+			continue
+		}
+		for migUUID, prepared := range entries {
+			updateInstasliceObject.Status.Prepared[migUUID] = prepared
+		}
+		committed = append(committed, allocation)
+		// the pod may have been deleted meanwhile, let the next reconcile handle the new status
+		updatedAllocation, exists := updateInstasliceObject.Spec.Allocations[allocation.PodUUID]
+		if exists && updatedAllocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreating {
+			updatedAllocation.Allocationstatus = inferencev1alpha1.AllocationStatusCreated
+			updatedAllocation.Start = allocation.Start
+			updateInstasliceObject.Spec.Allocations[allocation.PodUUID] = updatedAllocation
+		}
+		if createdSliceDetails.creationDuration != 0 {
+			durations[allocation.Profile] = metav1.Duration{Duration: createdSliceDetails.creationDuration}
+		}
+	}
+	// failing closed, the slices stay in creating and the batch is retried until they are advertised
+	if r.CapacityFailClosed {
+		if err := r.updateNodeCapacity(ctx, nodeName); err != nil {
+			return true, fmt.Errorf("unable to update the capacity of the node for the batch: %w", err)
+		}
+	}
+	if err := r.writeInstaslice(ctx, &updateInstasliceObject); err != nil {
+		return true, err
+	}
+	committedPods := make([]string, 0, len(committed))
+	for _, allocation := range committed {
+		r.recordAllocationStatus(allocation.PodUUID, updateInstasliceObject.Spec.Allocations[allocation.PodUUID].Allocationstatus)
+		createdSlice, _ := r.slices.cached(allocation.PodName)
+		r.recordSliceCreated(ctx, allocation, createdSlice.visibleDevices())
+		committedPods = append(committedPods, allocation.PodUUID)
+	}
+	// the prepared entries now track the slices
+	if err := r.clearCarvedSliceIntents(ctx, instaslice.Name, durations, committedPods...); err != nil {
+		log.FromContext(ctx).Error(err, "unable to clear the intents to carve the slices of batch")
+	}
+	if !r.CapacityFailClosed {
+		if err := r.updateNodeCapacity(ctx, nodeName); err != nil {
+			return true, err
+		}
+	}
+	attempts := make([]CreationAttempt, 0, len(committed))
+	for _, allocation := range committed {
+		attempts = append(attempts, creationAttempt(allocation, nil))
+	}
+	r.recordCreationAttempts(ctx, instaslice.Name, attempts...)
+	if len(duplicates) > 0 {
+		r.markDuplicateMigUUID(ctx, instaslice.Name, duplicates)
+		return true, fmt.Errorf("%d slices of the batch have a MIG UUID already tracked", len(duplicates))
+	}
+	if len(failed) > 0 {
+		if deferred != nil {
+			return true, fmt.Errorf("%d of %d slices of the batch were not created: %w", len(failed), len(pending), deferred)
+		}
+		return true, fmt.Errorf("%d of %d slices of the batch were not created", len(failed), len(pending))
+	}
+	return true, nil
+}
+
+// realizeBatchSlice carves the slice of one allocation of a batch and sets up what its pod consumes.
+// Carved slices are cached so that a failed batch does not carve them twice.
+func (r *InstaSliceDaemonsetReconciler) realizeBatchSlice(ctx context.Context, nvmllib nvml.Interface, nodeName string, instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) error {
+	if _, exists := r.slices.cached(allocation.PodName); !exists {
+		device, ret := nvmllib.DeviceGetHandleByUUID(allocation.GPUUUID)
+		if errLost := checkNVMLHandle("DeviceGetHandleByUUID", ret); errLost != nil {
+			return errLost
+		}
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("unable to get GPU %s: %v", allocation.GPUUUID, ret)
+		}
+		if err := r.reserveDeferrableSliceOperation(); err != nil {
+			return err
+		}
+		placement := nvml.GpuInstancePlacement{Start: allocation.Start, Size: allocation.Size}
+		createdSlice, err := r.carveSliceWithIntent(ctx, device, *instaslice, allocation, placement)
+		if err != nil {
+			return err
+		}
+		r.slices.cache(allocation.PodName, createdSlice)
+	}
+	createdSliceDetails, _ := r.slices.cached(allocation.PodName)
+	if createdSliceDetails.miguuid == "" {
+		return errSliceNotRealized
+	}
+	if err := r.createConfigMap(ctx, createdSliceDetails.migUUIDs(), allocation.Namespace, allocation.PodName, allocation.PodUUID, allocation.Profile, createdSliceDetails.memorySizeMB); err != nil {
+		return err
+	}
+	// the capacity of the pod is only advertised once its slice exists
+	return r.createInstaSliceResource(ctx, nodeName, allocation)
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newFakeGPUTestReconciler returns a daemonset reconciler on a node with one discovered fake GPU holding
+// a creating 1g.5gb allocation per start index, and the counter of instaslice spec updates made after setup.
+func newFakeGPUTestReconciler(t *testing.T, starts ...uint32) (*InstaSliceDaemonsetReconciler, client.Client, *dgxa100.Device, *int) {
+	t.Setenv(FakeGPUEnv, "1")
+	t.Setenv("NODE_NAME", "node-1")
+	nvmllib, err := newNvmlLib(nil)
+	require.NoError(t, err)
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}},
+	}
+	updates := 0
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(node).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}, &v1.Node{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if _, ok := obj.(*inferencev1alpha1.Instaslice); ok {
+					updates++
+				}
+				return c.Update(ctx, obj, opts...)
+			},
+		}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:      fakeClient,
+		Scheme:      s,
+		nvmlHandler: newDeviceHandler(nvmllib),
+	}
+	ctx := context.Background()
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+
+	handle, ret := nvmllib.DeviceGetHandleByIndex(0)
+	require.Equal(t, nvml.SUCCESS, ret)
+	device := handle.(*dgxa100.Device)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	instaslice.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
+	for i, start := range starts {
+		podUUID := fmt.Sprintf("pod-uid-%d", i)
+		instaslice.Spec.Allocations[podUUID] = inferencev1alpha1.AllocationDetails{
+			PodUUID:          podUUID,
+			PodName:          fmt.Sprintf("pod-%d", i),
+			Namespace:        "default",
+			GPUUUID:          device.UUID,
+			Nodename:         "node-1",
+			Profile:          "1g.5gb",
+			Start:            start,
+			Size:             1,
+			Giprofileid:      nvml.GPU_INSTANCE_PROFILE_1_SLICE,
+			CIProfileID:      nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+			CIEngProfileID:   nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED,
+			Allocationstatus: inferencev1alpha1.AllocationStatusCreating,
+		}
+	}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	updates = 0
+	return reconciler, fakeClient, device, &updates
+}
+
+func TestCreateSlicesInBatchWithSingleUpdate(t *testing.T) {
+	reconciler, fakeClient, device, updates := newFakeGPUTestReconciler(t, 0, 1, 2, 3, 4)
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+
+	batched, err := reconciler.createSlicesInBatch(ctx, "node-1", &instaslice)
+	require.NoError(t, err)
+	assert.True(t, batched)
+	assert.Equal(t, 1, *updates)
+
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Len(t, instaslice.Status.Prepared, 5)
+	for _, allocation := range instaslice.Spec.Allocations {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, allocation.Allocationstatus)
+		var configMap v1.ConfigMap
+		assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: allocation.PodName, Namespace: "default"}, &configMap))
+	}
+	assert.Len(t, device.GpuInstances, 5)
+}
+
+func TestCreateSlicesInBatchCommitsSuccessfulSlices(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1, 2)
+	ctx := context.Background()
+	// the driver fails to carve the slice of pod-1, a taken placement would be retried elsewhere instead
+	createGpuInstanceWithPlacement := device.CreateGpuInstanceWithPlacementFunc
+	device.CreateGpuInstanceWithPlacementFunc = func(info *nvml.GpuInstanceProfileInfo, placement *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		if placement.Start == 1 {
+			return nil, nvml.ERROR_UNKNOWN
+		}
+		return createGpuInstanceWithPlacement(info, placement)
+	}
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.NotZero(t, result.RequeueAfter)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Spec.Allocations["pod-uid-1"].Allocationstatus)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-2"].Allocationstatus)
+	assert.Len(t, instaslice.Status.Prepared, 2)
+}
+
+func TestCreateSlicesInBatchFailClosedRetriesCapacityUpdate(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	reloader := &FileSignalReloader{}
+	reconciler.CapacityReloader = reloader
+	reconciler.CapacityFailClosed = true
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	// the device plugin cannot be asked to advertise the slices, they are not marked created
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.NotZero(t, result.RequeueAfter)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	for _, allocation := range instaslice.Spec.Allocations {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, allocation.Allocationstatus)
+	}
+	assert.Empty(t, instaslice.Status.Prepared)
+	assert.Len(t, device.GpuInstances, 2)
+
+	reloader.Path = filepath.Join(t.TempDir(), "reload")
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var latest inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &latest))
+	for _, allocation := range latest.Spec.Allocations {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, allocation.Allocationstatus)
+	}
+	assert.Len(t, latest.Status.Prepared, 2)
+	// the slices carved by the failed attempt are reused
+	assert.Len(t, device.GpuInstances, 2)
+	assert.FileExists(t, reloader.Path)
+}
+
+func TestCreateSlicesInBatchFailOpenMarksSlicesCreated(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0, 1)
+	reconciler.CapacityReloader = &FileSignalReloader{}
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	for _, allocation := range instaslice.Spec.Allocations {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, allocation.Allocationstatus)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// CapabilitiesConfigMapPrefix prefixes the node name to name the ConfigMap summarizing the node capabilities.
+	CapabilitiesConfigMapPrefix = "instaslice-capabilities-"
+	// CapabilitiesNodeLabel is set on the capabilities ConfigMap to the node it summarizes.
+	CapabilitiesNodeLabel = "org.instaslice/node"
+	// CapabilitiesProfilesKey holds the JSON list of the profiles the GPUs of the node support.
+	CapabilitiesProfilesKey = "profiles"
+	// CapabilitiesFreeKey holds the JSON object giving, per profile, how many slices of it alone the node can still carve.
+	CapabilitiesFreeKey = "free"
+	// CapabilitiesPooledKey holds the JSON object giving, per profile of the pools, how many idle slices of it are
+	// carved and waiting for a pod.
+	CapabilitiesPooledKey = "pooled"
+	// CapabilitiesPinnedKey holds the JSON object giving, per namespace GPUs are pinned to, how many slices of each
+	// profile the GPUs pinned to it can still carve. They are left out of CapabilitiesFreeKey.
+	CapabilitiesPinnedKey = "pinned"
+)
+
+// capabilitiesConfigMapName returns the name of the ConfigMap summarizing the capabilities of the node.
+func capabilitiesConfigMapName(nodeName string) string {
+	return CapabilitiesConfigMapPrefix + nodeName
+}
+
+// freeSlicesPerProfile returns, per profile offered on the node, how many slices of it fit in the free indexes
+// of the GPUs of the node that are not cordoned, waiting for a reset, pinned to a namespace or restricted to other
+// profiles, and in the memory they have left for slices. Placements overlapping an occupied index or one another
+// count once at most, a profile whose placements are all taken has none free however many it has.
+func freeSlicesPerProfile(instaslice *inferencev1alpha1.Instaslice) map[string]int {
+	return freeSlicesOnGPUs(instaslice, func(gpuUUID string) bool {
+		return !gpuPinned(instaslice, gpuUUID)
+	})
+}
+
+// freeSlicesOnGPUs counts the free slices like freeSlicesPerProfile, on the GPUs of the node included alone.
+func freeSlicesOnGPUs(instaslice *inferencev1alpha1.Instaslice, included func(gpuUUID string) bool) map[string]int {
+	free := make(map[string]int)
+	for _, mig := range instaslice.Spec.Migplacement {
+		if !profileOffered(instaslice, mig.Profile) {
+			continue
+		}
+		free[mig.Profile] = 0
+		for gpuUUID := range instaslice.Spec.MigGPUUUID {
+			if !included(gpuUUID) || gpuCordoned(instaslice, gpuUUID) || gpuResetPending(instaslice, gpuUUID) || !gpuAllowsProfile(instaslice, gpuUUID, mig.Profile) {
+				continue
+			}
+			occupied := occupiedIndexes(instaslice, gpuUUID)
+			usedGB := gpuMemoryUsedGB(instaslice, gpuUUID)
+			for _, placement := range placementsByEnd(mig.Placements) {
+				if instaslice.Spec.ReservedMemoryGBPerGPU > 0 && usedGB+profileMemoryGB(mig.Profile) > usableMemoryGB(instaslice) {
+					break
+				}
+				if placementIsFree(placement, occupied) {
+					occupied.Occupy(uint32(placement.Start), uint32(placement.Size))
+					usedGB += profileMemoryGB(mig.Profile)
+					free[mig.Profile]++
+				}
+			}
+		}
+	}
+	return free
+}
+
+// capabilitiesData returns the summary of the node capabilities stored in its ConfigMap.
+func capabilitiesData(instaslice *inferencev1alpha1.Instaslice) (map[string]string, error) {
+	profiles := []string{}
+	for _, mig := range instaslice.Spec.Migplacement {
+		if profileOffered(instaslice, mig.Profile) {
+			profiles = append(profiles, mig.Profile)
+		}
+	}
+	profilesJSON, err := json.Marshal(profiles)
+	if err != nil {
+		return nil, err
+	}
+	freeJSON, err := json.Marshal(freeSlicesPerProfile(instaslice))
+	if err != nil {
+		return nil, err
+	}
+	pooledJSON, err := json.Marshal(pooledSlices(instaslice))
+	if err != nil {
+		return nil, err
+	}
+	pinnedJSON, err := json.Marshal(pinnedFreeSlices(instaslice))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		CapabilitiesProfilesKey: string(profilesJSON),
+		CapabilitiesFreeKey:     string(freeJSON),
+		CapabilitiesPooledKey:   string(pooledJSON),
+		CapabilitiesPinnedKey:   string(pinnedJSON),
+	}, nil
+}
+
+// exportCapabilities creates or refreshes the ConfigMap summarizing the profiles and free capacity of the node,
+// for schedulers that read standard objects rather than the instaslice. The ConfigMap goes with the instaslice.
+func (r *InstaSliceDaemonsetReconciler) exportCapabilities(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	if !r.ExportCapabilities {
+		return nil
+	}
+	data, err := capabilitiesData(instaslice)
+	if err != nil {
+		return err
+	}
+	var configMap v1.ConfigMap
+	err = r.Get(ctx, types.NamespacedName{Name: capabilitiesConfigMapName(instaslice.Name), Namespace: instaslice.Namespace}, &configMap)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	if err != nil {
+		configMap = v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      capabilitiesConfigMapName(instaslice.Name),
+				Namespace: instaslice.Namespace,
+				Labels:    map[string]string{CapabilitiesNodeLabel: instaslice.Name},
+			},
+			Data: data,
+		}
+		if err := controllerutil.SetControllerReference(instaslice, &configMap, r.Scheme); err != nil {
+			return err
+		}
+		return r.Create(ctx, &configMap)
+	}
+	// most reconciles leave the capacity as it was, spare the API server an update
+	if reflect.DeepEqual(configMap.Data, data) {
+		return nil
+	}
+	configMap.Data = data
+	return r.Update(ctx, &configMap)
+}
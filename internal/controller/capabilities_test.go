@@ -0,0 +1,91 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// exportedFreeSlices returns the free slices per profile read from the capabilities ConfigMap of node-1.
+func exportedFreeSlices(t *testing.T, c client.Client) map[string]int {
+	var configMap v1.ConfigMap
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "instaslice-capabilities-node-1", Namespace: "default"}, &configMap))
+	assert.Equal(t, "node-1", configMap.Labels[CapabilitiesNodeLabel])
+	var profiles []string
+	require.NoError(t, json.Unmarshal([]byte(configMap.Data[CapabilitiesProfilesKey]), &profiles))
+	assert.ElementsMatch(t, []string{"1g.5gb", "2g.10gb", "3g.20gb", "4g.20gb", "7g.40gb", "1g.5gb+me", "1g.10gb"}, profiles)
+	free := make(map[string]int)
+	require.NoError(t, json.Unmarshal([]byte(configMap.Data[CapabilitiesFreeKey]), &free))
+	return free
+}
+
+func TestCapabilitiesConfigMapReflectsFreeCapacity(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0, 1)
+	reconciler.ExportCapabilities = true
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	free := exportedFreeSlices(t, fakeClient)
+	assert.Equal(t, 5, free["1g.5gb"])
+	assert.Equal(t, 2, free["2g.10gb"])
+	assert.Equal(t, 1, free["3g.20gb"])
+	assert.Equal(t, 0, free["4g.20gb"])
+	assert.Equal(t, 0, free["7g.40gb"])
+
+	// the capacity freed by a deleted slice shows up on the next reconcile
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-1"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+	instaslice.Spec.Allocations["pod-uid-1"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	free = exportedFreeSlices(t, fakeClient)
+	assert.Equal(t, 6, free["1g.5gb"])
+	assert.Equal(t, 2, free["2g.10gb"])
+}
+
+func TestFreeSlicesCountOnlyPlacementsClearOfOccupiedSlots(t *testing.T) {
+	instaslice := newMemoryReservationTestInstaslice(0)
+	// 1g.5gb slices at indexes 2 and 5 overlap both 3g.20gb placements and two of the 2g.10gb ones
+	instaslice.Status.Prepared = map[string]inferencev1alpha1.PreparedDetails{
+		"MIG-1": {Profile: "1g.5gb", Parent: "GPU-1", Start: 2, Size: 1, PodUUID: "pod-uid-0"},
+		"MIG-2": {Profile: "1g.5gb", Parent: "GPU-1", Start: 5, Size: 1, PodUUID: "pod-uid-1"},
+	}
+	assert.Equal(t, map[string]int{"1g.5gb": 5, "2g.10gb": 1, "3g.20gb": 0, "7g.40gb": 0}, freeSlicesPerProfile(instaslice))
+
+	// placements overlapping one another count as many slices as fit together, whatever their order
+	instaslice.Status.Prepared = nil
+	instaslice.Spec.Migplacement = []inferencev1alpha1.Mig{
+		{Profile: "2g.10gb", Giprofileid: 5, Placements: []inferencev1alpha1.Placement{
+			{Size: 2, Start: 1}, {Size: 2, Start: 0}, {Size: 2, Start: 2}}},
+	}
+	assert.Equal(t, map[string]int{"2g.10gb": 2}, freeSlicesPerProfile(instaslice))
+}
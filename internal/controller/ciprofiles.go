@@ -0,0 +1,110 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// computeInstanceProfile is a compute instance and engine profile combination a GPU instance supports.
+type computeInstanceProfile struct {
+	profileID    int
+	engProfileID int
+	sliceCount   uint32
+}
+
+// supportedComputeInstanceProfiles lists the compute instance and engine profile combinations the GPU instance
+// supports. Combinations NVML reports as unsupported or invalid are skipped one by one, other errors abort.
+func supportedComputeInstanceProfiles(gi nvml.GpuInstance) ([]computeInstanceProfile, nvml.Return) {
+	var supported []computeInstanceProfile
+	for ciProfileID := 0; ciProfileID < nvml.COMPUTE_INSTANCE_PROFILE_COUNT; ciProfileID++ {
+		for ciEngProfileID := 0; ciEngProfileID < nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_COUNT; ciEngProfileID++ {
+			ciProfileInfo, ret := gi.GetComputeInstanceProfileInfo(ciProfileID, ciEngProfileID)
+			if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+				continue
+			}
+			if ret != nvml.SUCCESS {
+				return nil, ret
+			}
+			supported = append(supported, computeInstanceProfile{
+				profileID:    ciProfileID,
+				engProfileID: ciEngProfileID,
+				sliceCount:   ciProfileInfo.SliceCount,
+			})
+		}
+	}
+	return supported, nvml.SUCCESS
+}
+
+// fullComputeInstanceProfiles picks among the supported combinations, for every engine profile, one whose compute
+// instance spans the whole GPU instance, the profile of the same index as the GPU instance profile first. The
+// combinations are ordered by engine profile.
+func fullComputeInstanceProfiles(giProfileID int, giSliceCount uint32, supported []computeInstanceProfile) []computeInstanceProfile {
+	byEngine := make(map[int]computeInstanceProfile)
+	for _, ciProfile := range supported {
+		if ciProfile.sliceCount != giSliceCount {
+			continue
+		}
+		if picked, exists := byEngine[ciProfile.engProfileID]; !exists || (picked.profileID != giProfileID && ciProfile.profileID == giProfileID) {
+			byEngine[ciProfile.engProfileID] = ciProfile
+		}
+	}
+	full := make([]computeInstanceProfile, 0, len(byEngine))
+	for _, ciProfile := range byEngine {
+		full = append(full, ciProfile)
+	}
+	sort.Slice(full, func(i, j int) bool { return full[i].engProfileID < full[j].engProfileID })
+	return full
+}
+
+// discoverComputeInstanceProfiles returns the compute instance profiles carved in GPU instances of the profile, one
+// per engine profile, queried on an existing GPU instance of the profile or on one carved on a free placement and
+// destroyed right after. None is returned when the GPU instance profile supports no compute instance spanning it.
+// When no GPU instance can be had, e.g. all placements are in use, the compute instance profile of the same index
+// with shared engines is assumed.
+func discoverComputeInstanceProfiles(device nvml.Device, giProfileInfo nvml.GpuInstanceProfileInfo, placements []nvml.GpuInstancePlacement) ([]computeInstanceProfile, nvml.Return) {
+	assumed := computeInstanceProfile{
+		profileID:    int(giProfileInfo.Id),
+		engProfileID: nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED,
+		sliceCount:   giProfileInfo.SliceCount,
+	}
+	var gi nvml.GpuInstance
+	if existing, ret := device.GetGpuInstances(&giProfileInfo); ret == nvml.SUCCESS && len(existing) > 0 {
+		gi = existing[0]
+	} else {
+		for _, placement := range placements {
+			created, ret := createGpuInstance(device, int(giProfileInfo.Id), placement)
+			if ret == nvml.SUCCESS {
+				gi = created
+				defer created.Destroy()
+				break
+			}
+		}
+	}
+	if gi == nil {
+		log.Log.Info("no GPU instance to list compute instance profiles on, assuming the profile of the same index", "giProfileID", giProfileInfo.Id)
+		return []computeInstanceProfile{assumed}, nvml.SUCCESS
+	}
+	supported, ret := supportedComputeInstanceProfiles(gi)
+	if ret != nvml.SUCCESS {
+		return nil, ret
+	}
+	return fullComputeInstanceProfiles(int(giProfileInfo.Id), giProfileInfo.SliceCount, supported), nvml.SUCCESS
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// restrictComputeInstanceProfiles makes the GPU instances carved on the device report the compute instance
+// profiles for which restrict returns something else than SUCCESS with that return value.
+func restrictComputeInstanceProfiles(device *dgxa100.Device, restrict func(giProfileID uint32, ciProfileID int) nvml.Return) {
+	createGpuInstance := device.CreateGpuInstanceWithPlacementFunc
+	device.CreateGpuInstanceWithPlacementFunc = func(info *nvml.GpuInstanceProfileInfo, placement *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		gi, ret := createGpuInstance(info, placement)
+		if ret != nvml.SUCCESS {
+			return gi, ret
+		}
+		giProfileID := info.Id
+		getComputeInstanceProfileInfo := gi.(*dgxa100.GpuInstance).GetComputeInstanceProfileInfoFunc
+		gi.(*dgxa100.GpuInstance).GetComputeInstanceProfileInfoFunc = func(ciProfileID int, ciEngProfileID int) (nvml.ComputeInstanceProfileInfo, nvml.Return) {
+			if ret := restrict(giProfileID, ciProfileID); ret != nvml.SUCCESS {
+				return nvml.ComputeInstanceProfileInfo{}, ret
+			}
+			return getComputeInstanceProfileInfo(ciProfileID, ciEngProfileID)
+		}
+		return gi, ret
+	}
+}
+
+func TestSupportedComputeInstanceProfilesSkipsUnsupportedCombos(t *testing.T) {
+	gi := &mock.GpuInstance{
+		GetComputeInstanceProfileInfoFunc: func(ciProfileID int, ciEngProfileID int) (nvml.ComputeInstanceProfileInfo, nvml.Return) {
+			switch ciProfileID {
+			case nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE:
+				return nvml.ComputeInstanceProfileInfo{Id: uint32(ciProfileID), SliceCount: 1}, nvml.SUCCESS
+			case nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE:
+				return nvml.ComputeInstanceProfileInfo{}, nvml.ERROR_NOT_SUPPORTED
+			case nvml.COMPUTE_INSTANCE_PROFILE_3_SLICE:
+				return nvml.ComputeInstanceProfileInfo{Id: uint32(ciProfileID), SliceCount: 3}, nvml.SUCCESS
+			}
+			return nvml.ComputeInstanceProfileInfo{}, nvml.ERROR_INVALID_ARGUMENT
+		},
+	}
+
+	supported, ret := supportedComputeInstanceProfiles(gi)
+	require.Equal(t, nvml.SUCCESS, ret)
+	assert.Equal(t, []computeInstanceProfile{
+		{profileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, engProfileID: nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED, sliceCount: 1},
+		{profileID: nvml.COMPUTE_INSTANCE_PROFILE_3_SLICE, engProfileID: nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED, sliceCount: 3},
+	}, supported)
+
+	// other errors are not a matter of support, discovery can not go on
+	gi.GetComputeInstanceProfileInfoFunc = func(int, int) (nvml.ComputeInstanceProfileInfo, nvml.Return) {
+		return nvml.ComputeInstanceProfileInfo{}, nvml.ERROR_UNKNOWN
+	}
+	_, ret = supportedComputeInstanceProfiles(gi)
+	assert.Equal(t, nvml.ERROR_UNKNOWN, ret)
+}
+
+func TestDiscoveryAdvertisesOnlyProfilesWithSupportedComputeInstances(t *testing.T) {
+	t.Setenv(FakeGPUEnv, "1")
+	nvmllib, err := newNvmlLib(nil)
+	require.NoError(t, err)
+	handle, ret := nvmllib.DeviceGetHandleByIndex(0)
+	require.Equal(t, nvml.SUCCESS, ret)
+	device := handle.(*dgxa100.Device)
+	// 2g.10gb GPU instances only take 1 slice compute instances, the 3 slice compute instance of 3g.20gb is invalid
+	restrictComputeInstanceProfiles(device, func(giProfileID uint32, ciProfileID int) nvml.Return {
+		switch {
+		case giProfileID == nvml.GPU_INSTANCE_PROFILE_2_SLICE && ciProfileID == nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE:
+			return nvml.ERROR_NOT_SUPPORTED
+		case giProfileID == nvml.GPU_INSTANCE_PROFILE_3_SLICE && ciProfileID == nvml.COMPUTE_INSTANCE_PROFILE_3_SLICE:
+			return nvml.ERROR_INVALID_ARGUMENT
+		}
+		return nvml.SUCCESS
+	})
+	reconciler := &InstaSliceDaemonsetReconciler{nvmlHandler: newDeviceHandler(nvmllib)}
+
+	instaslice, _, _, _, _, err := reconciler.discoverAvailableProfilesOnGpus(context.Background())
+	require.NoError(t, err)
+
+	ciProfiles := make(map[string]int)
+	for _, mig := range instaslice.Spec.Migplacement {
+		ciProfiles[mig.Profile] = mig.CIProfileID
+	}
+	assert.NotContains(t, ciProfiles, "2g.10gb")
+	assert.NotContains(t, ciProfiles, "3g.20gb")
+	assert.Equal(t, nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, ciProfiles["1g.5gb"])
+	assert.Equal(t, nvml.COMPUTE_INSTANCE_PROFILE_4_SLICE, ciProfiles["4g.20gb"])
+	// the media extension profile carves the 1 slice compute instance, not the one of its own index
+	assert.Equal(t, nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, ciProfiles["1g.5gb+me"])
+	// GPU instances carved to list the compute instance profiles are gone
+	assert.Empty(t, device.GpuInstances)
+}
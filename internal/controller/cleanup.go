@@ -0,0 +1,159 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// errSliceInUse is returned while a process still holds a slice that is being destroyed.
+var errSliceInUse = errors.New("slice is in use")
+
+// isSliceInUse reports whether destroying a slice failed because a process still holds it.
+func isSliceInUse(err error) bool {
+	return errors.Is(err, errSliceInUse)
+}
+
+const (
+	// first and longest wait before destroying a slice held by a process is retried.
+	sliceInUseInitialBackoff = 2 * time.Second
+	sliceInUseMaxBackoff     = 1 * time.Minute
+)
+
+// sliceInUseBackoff returns how long to wait before destroying the slices of the pod again, doubling on every attempt.
+func (r *InstaSliceDaemonsetReconciler) sliceInUseBackoff(podUUID string) time.Duration {
+	r.sliceInUseMu.Lock()
+	if r.sliceInUseRetries == nil {
+		r.sliceInUseRetries = make(map[string]int)
+	}
+	attempt := r.sliceInUseRetries[podUUID]
+	r.sliceInUseRetries[podUUID] = attempt + 1
+	r.sliceInUseMu.Unlock()
+	backoff := sliceInUseInitialBackoff
+	for i := 0; i < attempt && backoff < sliceInUseMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > sliceInUseMaxBackoff {
+		return sliceInUseMaxBackoff
+	}
+	return backoff
+}
+
+// clearSliceInUseRetries forgets the attempts to destroy the slices of the pod that found them in use.
+func (r *InstaSliceDaemonsetReconciler) clearSliceInUseRetries(podUUID string) {
+	r.sliceInUseMu.Lock()
+	defer r.sliceInUseMu.Unlock()
+	delete(r.sliceInUseRetries, podUUID)
+}
+
+// pruneSliceInUseRetries forgets the attempts of the pods the instaslice holds no allocation of anymore, e.g. once
+// it was removed by hand while its slice was in use.
+func (r *InstaSliceDaemonsetReconciler) pruneSliceInUseRetries(instaslice *inferencev1alpha1.Instaslice) {
+	r.sliceInUseMu.Lock()
+	defer r.sliceInUseMu.Unlock()
+	for podUUID := range r.sliceInUseRetries {
+		if !hasPodAllocation(instaslice, podUUID) {
+			delete(r.sliceInUseRetries, podUUID)
+		}
+	}
+}
+
+// hasPodAllocation reports whether the instaslice holds an allocation of the pod.
+func hasPodAllocation(instaslice *inferencev1alpha1.Instaslice, podUUID string) bool {
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.PodUUID == podUUID {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanUp destroys the slices of the pod and, once they are gone, drops its prepared entries and allocations.
+// Nothing is dropped while a slice is still in use, errSliceInUse is returned so that the caller retries.
+func (r *InstaSliceDaemonsetReconciler) cleanUp(ctx context.Context, podUUID string) error {
+	var instaslice inferencev1alpha1.Instaslice
+	typeNamespacedName := types.NamespacedName{
+		Name:      os.Getenv("NODE_NAME"),
+		Namespace: r.instasliceNamespace(),
+	}
+	if err := r.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+		return err
+	}
+	spanCtx, span := r.startSliceSpan(ctx, SpanSliceDeletion, instaslice.Spec.Allocations[podUUID])
+	_, err := r.cleanUpCiAndGi(spanCtx, podUUID, instaslice)
+	endSliceSpan(span, err)
+	if err != nil {
+		return err
+	}
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.PodUUID != podUUID {
+			continue
+		}
+		// a slice carved before a restart has no prepared entry for cleanUpCiAndGi to find
+		if err := r.rollBackSliceIntent(ctx, &instaslice, allocation); err != nil {
+			return err
+		}
+	}
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID == podUUID {
+			r.slices.release(migUUID)
+		}
+	}
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.PodUUID != podUUID {
+			continue
+		}
+		// the slice is gone, the ConfigMap mapping it can go as well
+		if err := r.removeConfigMapFinalizer(ctx, allocation.PodName, allocation.Namespace, allocation.PodUUID); err != nil {
+			return err
+		}
+		r.slices.forget(allocation.PodName)
+	}
+	gpus := slicedGPUs(&instaslice, podUUID)
+	migUUIDs := strings.Join(allocationMigUUIDs(&instaslice)[podUUID], ",")
+	updated, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+		for migUUID, prepared := range latest.Status.Prepared {
+			if prepared.PodUUID == podUUID {
+				delete(latest.Status.Prepared, migUUID)
+			}
+		}
+		for key, allocation := range latest.Spec.Allocations {
+			if allocation.PodUUID == podUUID {
+				delete(latest.Spec.Allocations, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.clearSliceInUseRetries(podUUID)
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.PodUUID == podUUID {
+			r.recordSliceDeleted(allocation, migUUIDs)
+		}
+	}
+	r.resetIdleGPUs(ctx, updated, gpus)
+	return r.clearSliceIntents(ctx, instaslice.Name, podUUID)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestCarveComputeInstancesInOneGpuInstance(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Profile = "2g.10gb"
+	allocation.Size = 2
+	allocation.Giprofileid = nvml.GPU_INSTANCE_PROFILE_2_SLICE
+	allocation.CIProfileID = nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE
+	allocation.ComputeInstances = 2
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	require.Len(t, instaslice.Status.Prepared, 2)
+	var migUUIDs []string
+	giIDs := make(map[uint32]bool)
+	ciIDs := make(map[uint32]bool)
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		assert.Equal(t, "pod-uid-0", prepared.PodUUID)
+		giIDs[prepared.Giinfoid] = true
+		ciIDs[prepared.Ciinfoid] = true
+		migUUIDs = append(migUUIDs, migUUID)
+	}
+	assert.Len(t, giIDs, 1)
+	assert.Len(t, ciIDs, 2)
+
+	require.Len(t, device.GpuInstances, 1)
+	var gi *dgxa100.GpuInstance
+	for gi = range device.GpuInstances {
+		assert.Len(t, gi.ComputeInstances, 2)
+	}
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-0", Namespace: "default"}, &configMap))
+	visible := strings.Split(configMap.Data["NVIDIA_VISIBLE_DEVICES"], ",")
+	assert.ElementsMatch(t, migUUIDs, visible)
+
+	require.NoError(t, reconciler.cleanUp(ctx, "pod-uid-0"))
+	assert.Empty(t, gi.ComputeInstances)
+	assert.Empty(t, device.GpuInstances)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Empty(t, instaslice.Status.Prepared)
+	assert.Empty(t, instaslice.Spec.Allocations)
+}
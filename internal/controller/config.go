@@ -0,0 +1,152 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigKey is the key of the config ConfigMap holding the configuration of the daemonset, as YAML or JSON.
+const ConfigKey = "config.yaml"
+
+// Config is the configuration of the daemonset read from its config ConfigMap. It holds the settings that can
+// change while the daemonset runs, each overrides the flag of the same name and keeps its value when left out.
+type Config struct {
+	MaxSliceCreationAttempts  *int             `json:"maxSliceCreationAttempts,omitempty"`
+	SliceCreationRetryBase    *metav1.Duration `json:"sliceCreationRetryBase,omitempty"`
+	SliceCreationRetryMax     *metav1.Duration `json:"sliceCreationRetryMax,omitempty"`
+	SliceCreationRetryFactor  *float64         `json:"sliceCreationRetryFactor,omitempty"`
+	QuarantineInvalidPrepared *bool            `json:"quarantineInvalidPrepared,omitempty"`
+	CheckConfigMapReferences  *bool            `json:"checkConfigMapReferences,omitempty"`
+	XidErrorThreshold         *int             `json:"xidErrorThreshold,omitempty"`
+}
+
+// ParseConfig reads the configuration from the data of the config ConfigMap. Unknown settings and values their
+// flag would refuse are rejected.
+func ParseConfig(data map[string]string) (*Config, error) {
+	config := &Config{}
+	if err := yaml.UnmarshalStrict([]byte(data[ConfigKey]), config); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ConfigKey, err)
+	}
+	if config.MaxSliceCreationAttempts != nil && *config.MaxSliceCreationAttempts < 0 {
+		return nil, fmt.Errorf("maxSliceCreationAttempts must not be negative, got %d", *config.MaxSliceCreationAttempts)
+	}
+	if config.SliceCreationRetryBase != nil && config.SliceCreationRetryBase.Duration <= 0 {
+		return nil, fmt.Errorf("sliceCreationRetryBase must be positive, got %s", config.SliceCreationRetryBase.Duration)
+	}
+	if config.SliceCreationRetryBase != nil && config.SliceCreationRetryMax != nil &&
+		config.SliceCreationRetryMax.Duration < config.SliceCreationRetryBase.Duration {
+		return nil, fmt.Errorf("sliceCreationRetryMax must be at least sliceCreationRetryBase, got %s", config.SliceCreationRetryMax.Duration)
+	}
+	if config.SliceCreationRetryFactor != nil && *config.SliceCreationRetryFactor < 1 {
+		return nil, fmt.Errorf("sliceCreationRetryFactor must be at least 1, got %g", *config.SliceCreationRetryFactor)
+	}
+	if config.XidErrorThreshold != nil && *config.XidErrorThreshold < 0 {
+		return nil, fmt.Errorf("xidErrorThreshold must not be negative, got %d", *config.XidErrorThreshold)
+	}
+	return config, nil
+}
+
+// daemonsetSettings are the settings the daemonset runs with, its flags overridden by the config ConfigMap.
+type daemonsetSettings struct {
+	sliceCreationRetry        SliceCreationRetryPolicy
+	quarantineInvalidPrepared bool
+	checkConfigMapReferences  bool
+	xidErrorThreshold         int
+}
+
+// settings returns the settings in effect, the fields of the reconciler overridden by the last configuration
+// loaded from the config ConfigMap.
+func (r *InstaSliceDaemonsetReconciler) settings() daemonsetSettings {
+	settings := daemonsetSettings{
+		sliceCreationRetry:        r.SliceCreationRetryPolicy,
+		quarantineInvalidPrepared: r.QuarantineInvalidPrepared,
+		checkConfigMapReferences:  r.CheckConfigMapReferences,
+		xidErrorThreshold:         r.XidErrorThreshold,
+	}
+	config := r.config.Load()
+	if config == nil {
+		return settings
+	}
+	if config.MaxSliceCreationAttempts != nil {
+		settings.sliceCreationRetry.MaxAttempts = *config.MaxSliceCreationAttempts
+	}
+	if config.SliceCreationRetryBase != nil {
+		settings.sliceCreationRetry.Base = config.SliceCreationRetryBase.Duration
+	}
+	if config.SliceCreationRetryMax != nil {
+		settings.sliceCreationRetry.Max = config.SliceCreationRetryMax.Duration
+	}
+	if config.SliceCreationRetryFactor != nil {
+		settings.sliceCreationRetry.Factor = *config.SliceCreationRetryFactor
+	}
+	if config.QuarantineInvalidPrepared != nil {
+		settings.quarantineInvalidPrepared = *config.QuarantineInvalidPrepared
+	}
+	if config.CheckConfigMapReferences != nil {
+		settings.checkConfigMapReferences = *config.CheckConfigMapReferences
+	}
+	if config.XidErrorThreshold != nil {
+		settings.xidErrorThreshold = *config.XidErrorThreshold
+	}
+	return settings
+}
+
+// reloadConfig loads the configuration from the config ConfigMap whenever it changes. An invalid configuration
+// is logged and the previous one kept, a deleted ConfigMap brings the flags back in effect.
+func (r *InstaSliceDaemonsetReconciler) reloadConfig(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var configMap v1.ConfigMap
+	err := r.Get(ctx, req.NamespacedName, &configMap)
+	if apierrors.IsNotFound(err) {
+		log.FromContext(ctx).Info("config ConfigMap is gone, running with the flags", "configMap", req.NamespacedName)
+		r.config.Store(nil)
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	config, err := ParseConfig(configMap.Data)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "ignoring invalid configuration, keeping the current one", "configMap", req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+	r.config.Store(config)
+	log.FromContext(ctx).Info("configuration reloaded", "configMap", req.NamespacedName)
+	return ctrl.Result{}, nil
+}
+
+// setupConfigReload watches the config ConfigMap of the daemonset, in the namespace of the operator.
+func (r *InstaSliceDaemonsetReconciler) setupConfigReload(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("InstaSliceConfig").
+		For(&v1.ConfigMap{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(object client.Object) bool {
+			return object.GetNamespace() == r.instasliceNamespace() && object.GetName() == r.ConfigConfigMap
+		}))).
+		Complete(reconcile.Func(r.reloadConfig))
+}
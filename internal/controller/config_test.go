@@ -0,0 +1,103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEditingConfigConfigMapReloadsSettings(t *testing.T) {
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "instaslice-config", Namespace: "default"},
+		Data:       map[string]string{ConfigKey: "maxSliceCreationAttempts: 3\nsliceCreationRetryBase: 5s\n"},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(configMap).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:                   fakeClient,
+		ConfigConfigMap:          "instaslice-config",
+		SliceCreationRetryPolicy: SliceCreationRetryPolicy{MaxAttempts: DefaultMaxSliceCreationAttempts},
+		XidErrorThreshold:        10,
+	}
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "instaslice-config", Namespace: "default"}}
+
+	_, err := reconciler.reloadConfig(ctx, req)
+	require.NoError(t, err)
+	settings := reconciler.settings()
+	assert.Equal(t, 3, settings.sliceCreationRetry.MaxAttempts)
+	assert.Equal(t, 5*time.Second, settings.sliceCreationRetry.Base)
+	// settings left out keep the value of their flag
+	assert.Equal(t, 10, settings.xidErrorThreshold)
+	assert.Equal(t, 5*time.Second, reconciler.sliceCreationRetryAfter("pod-uid-0"))
+
+	// an edit is picked up without a restart
+	configMap.Data[ConfigKey] = "maxSliceCreationAttempts: 0\ncheckConfigMapReferences: true\nxidErrorThreshold: 2\n"
+	require.NoError(t, fakeClient.Update(ctx, configMap))
+	_, err = reconciler.reloadConfig(ctx, req)
+	require.NoError(t, err)
+	settings = reconciler.settings()
+	assert.Equal(t, 0, settings.sliceCreationRetry.MaxAttempts)
+	assert.True(t, settings.checkConfigMapReferences)
+	assert.Equal(t, 2, settings.xidErrorThreshold)
+	assert.Equal(t, DefaultSliceCreationRetryBase, reconciler.sliceCreationRetryAfter("pod-uid-0"))
+
+	// an invalid configuration leaves the current one in effect
+	configMap.Data[ConfigKey] = "sliceCreationRetryFactor: 0.5\n"
+	require.NoError(t, fakeClient.Update(ctx, configMap))
+	_, err = reconciler.reloadConfig(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reconciler.settings().xidErrorThreshold)
+
+	// without the ConfigMap the flags are back in effect
+	require.NoError(t, fakeClient.Delete(ctx, configMap))
+	_, err = reconciler.reloadConfig(ctx, req)
+	require.NoError(t, err)
+	settings = reconciler.settings()
+	assert.Equal(t, DefaultMaxSliceCreationAttempts, settings.sliceCreationRetry.MaxAttempts)
+	assert.False(t, settings.checkConfigMapReferences)
+	assert.Equal(t, 10, settings.xidErrorThreshold)
+}
+
+func TestParseConfigRejectsUnknownAndInvalidSettings(t *testing.T) {
+	_, err := ParseConfig(map[string]string{ConfigKey: "maxSliceCreationAttemps: 3\n"})
+	assert.Error(t, err)
+	_, err = ParseConfig(map[string]string{ConfigKey: "sliceCreationRetryBase: 10s\nsliceCreationRetryMax: 5s\n"})
+	assert.Error(t, err)
+	_, err = ParseConfig(map[string]string{ConfigKey: "xidErrorThreshold: -1\n"})
+	assert.Error(t, err)
+
+	config, err := ParseConfig(map[string]string{ConfigKey: `{"quarantineInvalidPrepared": true}`})
+	require.NoError(t, err)
+	require.NotNil(t, config.QuarantineInvalidPrepared)
+	assert.True(t, *config.QuarantineInvalidPrepared)
+	assert.Nil(t, config.XidErrorThreshold)
+
+	config, err = ParseConfig(nil)
+	require.NoError(t, err)
+	assert.Equal(t, &Config{}, config)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// correctConfigMapDrift points the ConfigMaps of the realized slices of the node back at the MIG devices prepared
+// for their pods when they drifted, e.g. after a slice was carved again with a new MIG UUID. Containers of the pod
+// started afterwards see the right device. Missing ConfigMaps are left to restoreMissingConfigMaps.
+func (r *InstaSliceDaemonsetReconciler) correctConfigMapDrift(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	migUUIDs := allocationMigUUIDs(instaslice)
+	for podUUID, allocation := range instaslice.Spec.Allocations {
+		if !settledAllocation(allocation) || podUUID != allocation.PodUUID || len(migUUIDs[podUUID]) == 0 {
+			continue
+		}
+		migGPUUUID, err := formatVisibleDevices(migUUIDs[podUUID])
+		if err != nil {
+			log.FromContext(ctx).Error(err, "invalid MIG UUIDs for ", "pod", allocation.PodName)
+			continue
+		}
+		visibleDevices, err := r.visibleDevicesFor(ctx, migUUIDs[podUUID])
+		if err != nil {
+			log.FromContext(ctx).Error(err, "unable to reference the MIG devices of ", "pod", allocation.PodName)
+			continue
+		}
+		var configMap v1.ConfigMap
+		err = r.Get(ctx, r.configMapKey(allocation.Namespace, allocation.PodName), &configMap)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if configMap.Data["NVIDIA_VISIBLE_DEVICES"] == visibleDevices && configMap.Data["CUDA_VISIBLE_DEVICES"] == visibleDevices {
+			continue
+		}
+		log.FromContext(ctx).Info("ConfigMap drifted from the prepared slice, correcting it for ", "pod", allocation.PodName,
+			"found", configMap.Data["NVIDIA_VISIBLE_DEVICES"], "prepared", visibleDevices)
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		configMap.Data["NVIDIA_VISIBLE_DEVICES"] = visibleDevices
+		configMap.Data["CUDA_VISIBLE_DEVICES"] = visibleDevices
+		delete(configMap.Labels, ConfigMapMigUUIDLabel)
+		if migUUIDLabel, exists := configMapLabels(podUUID, allocation.Profile, migGPUUUID)[ConfigMapMigUUIDLabel]; exists {
+			if configMap.Labels == nil {
+				configMap.Labels = make(map[string]string)
+			}
+			configMap.Labels[ConfigMapMigUUIDLabel] = migUUIDLabel
+		}
+		if err := r.Update(ctx, &configMap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
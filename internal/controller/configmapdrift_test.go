@@ -0,0 +1,70 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestReconcileCorrectsDriftedConfigMap(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	migUUID, _ := preparedOfPod(*latestTestInstaslice(t, fakeClient), "pod-uid-0")
+	require.NotEmpty(t, migUUID)
+
+	// the slice was carved again, the ConfigMap still names the MIG device it had before
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-0", Namespace: "default"}, &configMap))
+	require.Equal(t, migUUID, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	configMap.Data["NVIDIA_VISIBLE_DEVICES"] = "MIG-stale"
+	configMap.Data["CUDA_VISIBLE_DEVICES"] = "MIG-stale"
+	configMap.Labels[ConfigMapMigUUIDLabel] = "MIG-stale"
+	require.NoError(t, fakeClient.Update(ctx, &configMap))
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-0", Namespace: "default"}, &configMap))
+	assert.Equal(t, migUUID, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	assert.Equal(t, migUUID, configMap.Data["CUDA_VISIBLE_DEVICES"])
+	assert.Equal(t, migUUID, configMap.Labels[ConfigMapMigUUIDLabel])
+	assert.Equal(t, "pod-uid-0", configMap.Labels[ConfigMapPodUIDLabel])
+}
+
+func TestConfigMapDriftLeavesMissingConfigMaps(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default"}}
+	require.NoError(t, fakeClient.Delete(ctx, configMap))
+
+	require.NoError(t, reconciler.correctConfigMapDrift(ctx, latestTestInstaslice(t, fakeClient)))
+	err = fakeClient.Get(ctx, types.NamespacedName{Name: "pod-0", Namespace: "default"}, configMap)
+	assert.True(t, apierrors.IsNotFound(err))
+}
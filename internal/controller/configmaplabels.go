@@ -0,0 +1,79 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ConfigMapPodUIDLabel holds the UID of the pod on the ConfigMap mapping its slice.
+	ConfigMapPodUIDLabel = "instaslice.codeflare.dev/pod-uid"
+	// ConfigMapProfileLabel holds the profile of the slice on the ConfigMap of its pod, with the + and , of the
+	// profile attributes replaced by -, e.g. 1g.5gb-me for 1g.5gb+me.
+	ConfigMapProfileLabel = "instaslice.codeflare.dev/profile"
+	// ConfigMapMigUUIDLabel holds the MIG UUID of the slice on the ConfigMap of its pod.
+	ConfigMapMigUUIDLabel = "instaslice.codeflare.dev/mig-uuid"
+)
+
+// profileLabelValue returns the profile in a form allowed as a label value.
+func profileLabelValue(profileName string) string {
+	return strings.NewReplacer("+", "-", ",", "-").Replace(profileName)
+}
+
+// configMapLabels returns the labels letting tools select the ConfigMaps of pods by pod, profile or MIG device.
+// Values that cannot be label values are left out, like the MIG UUIDs of a slice split in several compute instances.
+func configMapLabels(podUUID, profileName, migGPUUUID string) map[string]string {
+	labels := make(map[string]string)
+	for key, value := range map[string]string{
+		ConfigMapPodUIDLabel:  podUUID,
+		ConfigMapProfileLabel: profileLabelValue(profileName),
+		ConfigMapMigUUIDLabel: migGPUUUID,
+	} {
+		if value != "" && len(validation.IsValidLabelValue(value)) == 0 {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// strayConfigMaps returns the ConfigMaps labeled with the UID of the pod in all namespaces but the one at expected,
+// where the ConfigMap of the pod is looked up first, so that a ConfigMap is not leaked when the namespace recorded
+// on the allocation does not match the one it was created in.
+func (r *InstaSliceDaemonsetReconciler) strayConfigMaps(ctx context.Context, expected types.NamespacedName, podUUID string) ([]v1.ConfigMap, error) {
+	if podUUID == "" {
+		return nil, nil
+	}
+	var configMaps v1.ConfigMapList
+	if err := r.List(ctx, &configMaps, client.MatchingLabels{ConfigMapPodUIDLabel: podUUID}); err != nil {
+		return nil, err
+	}
+	var strays []v1.ConfigMap
+	for _, configMap := range configMaps.Items {
+		if configMap.Name == expected.Name && configMap.Namespace == expected.Namespace {
+			continue
+		}
+		strays = append(strays, configMap)
+	}
+	return strays, nil
+}
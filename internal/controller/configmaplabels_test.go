@@ -0,0 +1,96 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestConfigMapLabelsSelectPodsByProfile(t *testing.T) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s}
+	ctx := context.Background()
+
+	require.NoError(t, reconciler.createConfigMap(ctx, []string{"MIG-1"}, "default", "pod-name-1", "pod-uid-1", "1g.5gb", 4864))
+	require.NoError(t, reconciler.createConfigMap(ctx, []string{"MIG-2"}, "default", "pod-name-2", "pod-uid-2", "1g.5gb+me", 4864))
+	// a slice split in several compute instances maps more than one MIG device
+	require.NoError(t, reconciler.createConfigMap(ctx, []string{"MIG-3", "MIG-4"}, "default", "pod-name-3", "pod-uid-3", "1g.5gb", 4864))
+
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-name-1", Namespace: "default"}, &configMap))
+	assert.Equal(t, map[string]string{
+		ConfigMapPodUIDLabel:  "pod-uid-1",
+		ConfigMapProfileLabel: "1g.5gb",
+		ConfigMapMigUUIDLabel: "MIG-1",
+	}, configMap.Labels)
+	configMap = v1.ConfigMap{}
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-name-3", Namespace: "default"}, &configMap))
+	assert.Equal(t, "pod-uid-3", configMap.Labels[ConfigMapPodUIDLabel])
+	assert.NotContains(t, configMap.Labels, ConfigMapMigUUIDLabel)
+
+	var configMaps v1.ConfigMapList
+	require.NoError(t, fakeClient.List(ctx, &configMaps, client.MatchingLabels{ConfigMapProfileLabel: "1g.5gb"}))
+	var names []string
+	for _, cm := range configMaps.Items {
+		names = append(names, cm.Name)
+	}
+	assert.ElementsMatch(t, []string{"pod-name-1", "pod-name-3"}, names)
+
+	configMaps = v1.ConfigMapList{}
+	require.NoError(t, fakeClient.List(ctx, &configMaps, client.MatchingLabels{ConfigMapProfileLabel: "1g.5gb-me"}))
+	require.Len(t, configMaps.Items, 1)
+	assert.Equal(t, "pod-name-2", configMaps.Items[0].Name)
+
+	configMaps = v1.ConfigMapList{}
+	require.NoError(t, fakeClient.List(ctx, &configMaps, client.MatchingLabels{ConfigMapMigUUIDLabel: "MIG-2"}))
+	require.Len(t, configMaps.Items, 1)
+	assert.Equal(t, "pod-uid-2", configMaps.Items[0].Labels[ConfigMapPodUIDLabel])
+}
+
+func TestDeleteConfigMapFindsItByLabelDespiteStaleNamespace(t *testing.T) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s, ProtectConfigMaps: true}
+	ctx := context.Background()
+	require.NoError(t, reconciler.createConfigMap(ctx, []string{"MIG-1"}, "team-b", "pod-name-1", "pod-uid-1", "1g.5gb", 4864))
+	// the ConfigMap of another pod of the same name is left alone
+	require.NoError(t, reconciler.createConfigMap(ctx, []string{"MIG-2"}, "team-c", "pod-name-1", "pod-uid-2", "1g.5gb", 4864))
+
+	// the allocation records team-a while the ConfigMap was created in team-b
+	require.NoError(t, reconciler.deleteConfigMap(ctx, "pod-name-1", "team-a", "pod-uid-1"))
+	require.NoError(t, reconciler.removeConfigMapFinalizer(ctx, "pod-name-1", "team-a", "pod-uid-1"))
+
+	var configMaps v1.ConfigMapList
+	require.NoError(t, fakeClient.List(ctx, &configMaps))
+	require.Len(t, configMaps.Items, 1)
+	assert.Equal(t, types.NamespacedName{Name: "pod-name-1", Namespace: "team-c"},
+		types.NamespacedName{Name: configMaps.Items[0].Name, Namespace: configMaps.Items[0].Namespace})
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ConfigMapNamespacePolicy is where the daemonset keeps the ConfigMaps mapping pods to their MIG devices.
+type ConfigMapNamespacePolicy string
+
+const (
+	// ConfigMapNamespacePod keeps the ConfigMap of a pod in the namespace of the pod, named after the pod.
+	ConfigMapNamespacePod ConfigMapNamespacePolicy = "pod-namespace"
+	// ConfigMapNamespaceOperator keeps the ConfigMaps of all pods in the namespace of the operator, named
+	// <pod namespace>.<pod name> so that pods of different namespaces do not collide.
+	ConfigMapNamespaceOperator ConfigMapNamespacePolicy = "operator-namespace"
+)
+
+// ParseConfigMapNamespacePolicy validates a policy name, an empty name is ConfigMapNamespacePod.
+func ParseConfigMapNamespacePolicy(value string) (ConfigMapNamespacePolicy, error) {
+	switch ConfigMapNamespacePolicy(value) {
+	case "", ConfigMapNamespacePod:
+		return ConfigMapNamespacePod, nil
+	case ConfigMapNamespaceOperator:
+		return ConfigMapNamespaceOperator, nil
+	}
+	return "", fmt.Errorf("unknown ConfigMap namespace policy %q, must be %s or %s", value, ConfigMapNamespacePod, ConfigMapNamespaceOperator)
+}
+
+// configMapKey returns the name and namespace of the ConfigMap of the pod under the ConfigMap namespace policy.
+// Creating, correcting and deleting the ConfigMap all go through it, so that they agree on where it is.
+func (r *InstaSliceDaemonsetReconciler) configMapKey(podNamespace, podName string) types.NamespacedName {
+	if r.ConfigMapNamespacePolicy != ConfigMapNamespaceOperator {
+		return types.NamespacedName{Name: podName, Namespace: podNamespace}
+	}
+	return types.NamespacedName{Name: podNamespace + "." + podName, Namespace: r.instasliceNamespace()}
+}
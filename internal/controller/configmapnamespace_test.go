@@ -0,0 +1,71 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestConfigMapNamespacePolicies(t *testing.T) {
+	for policy, expected := range map[ConfigMapNamespacePolicy]types.NamespacedName{
+		ConfigMapNamespacePod:      {Name: "pod-name-1", Namespace: "team-a"},
+		ConfigMapNamespaceOperator: {Name: "team-a.pod-name-1", Namespace: "instaslice-system"},
+	} {
+		s := scheme.Scheme
+		_ = inferencev1alpha1.AddToScheme(s)
+		fakeClient := runtimefake.NewClientBuilder().WithScheme(s).Build()
+		reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s,
+			ConfigMapNamespacePolicy: policy, OperatorNamespace: "instaslice-system", ProtectConfigMaps: true}
+		ctx := context.Background()
+
+		require.NoError(t, reconciler.createConfigMap(ctx, []string{"MIG-1"}, "team-a", "pod-name-1", "pod-uid-1", "1g.5gb", 4864))
+		var configMap v1.ConfigMap
+		require.NoError(t, fakeClient.Get(ctx, expected, &configMap), "%s", policy)
+		assert.Equal(t, "MIG-1", configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+		var configMaps v1.ConfigMapList
+		require.NoError(t, fakeClient.List(ctx, &configMaps))
+		assert.Len(t, configMaps.Items, 1, "%s", policy)
+
+		// deletion goes by the same policy, the finalizer is removed from the same ConfigMap
+		require.NoError(t, reconciler.deleteConfigMap(ctx, "pod-name-1", "team-a", "pod-uid-1"))
+		require.NoError(t, reconciler.removeConfigMapFinalizer(ctx, "pod-name-1", "team-a", "pod-uid-1"))
+		err := fakeClient.Get(ctx, expected, &configMap)
+		assert.True(t, apierrors.IsNotFound(err), "%s: %v", policy, err)
+	}
+}
+
+func TestParseConfigMapNamespacePolicy(t *testing.T) {
+	policy, err := ParseConfigMapNamespacePolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, ConfigMapNamespacePod, policy)
+	policy, err = ParseConfigMapNamespacePolicy("operator-namespace")
+	require.NoError(t, err)
+	assert.Equal(t, ConfigMapNamespaceOperator, policy)
+	_, err = ParseConfigMapNamespacePolicy("cluster")
+	assert.Error(t, err)
+}
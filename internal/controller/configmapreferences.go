@@ -0,0 +1,85 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// EventReasonSliceNotConsumed is the reason of the event emitted on a pod none of whose containers reads the
+// ConfigMap of its slice.
+const EventReasonSliceNotConsumed = "SliceNotConsumed"
+
+// podReferencesConfigMap tells whether a container of the pod reads the ConfigMap through envFrom or env, or the pod
+// mounts it in a volume, directly or projected.
+func podReferencesConfigMap(pod *v1.Pod, name string) bool {
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, source := range container.EnvFrom {
+			if source.ConfigMapRef != nil && source.ConfigMapRef.Name == name {
+				return true
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil && env.ValueFrom.ConfigMapKeyRef.Name == name {
+				return true
+			}
+		}
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.ConfigMap != nil && volume.ConfigMap.Name == name {
+			return true
+		}
+		if volume.Projected == nil {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			if source.ConfigMap != nil && source.ConfigMap.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// warnUnreferencedConfigMap reports, in the logs and when a recorder is set in a warning event on the pod, a pod
+// that does not read the ConfigMap created for its slice: its containers would not see the device and the slice is
+// wasted. ConfigMaps kept in another namespace than the pod are read through means the pod spec does not show, they
+// are not checked.
+func (r *InstaSliceDaemonsetReconciler) warnUnreferencedConfigMap(ctx context.Context, key types.NamespacedName, namespace, podName string) {
+	if key.Namespace != namespace {
+		return
+	}
+	var pod v1.Pod
+	if err := r.Get(ctx, types.NamespacedName{Name: podName, Namespace: namespace}, &pod); err != nil {
+		log.FromContext(ctx).Error(err, "unable to get pod to check it reads its ConfigMap", "pod", podName, "namespace", namespace)
+		return
+	}
+	if podReferencesConfigMap(&pod, key.Name) {
+		return
+	}
+	log.FromContext(ctx).Info("pod does not read the ConfigMap of its slice", "pod", podName, "namespace", namespace, "configMap", key.Name)
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Eventf(&pod, v1.EventTypeWarning, EventReasonSliceNotConsumed,
+		"No container reads ConfigMap %s through envFrom, env or a volume, the pod will not see its MIG slice", key.Name)
+}
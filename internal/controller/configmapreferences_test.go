@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newConfigMapReferencesTestReconciler returns a reconciler checking ConfigMap references, holding the given pod.
+func newConfigMapReferencesTestReconciler(pod *v1.Pod) (*InstaSliceDaemonsetReconciler, *record.FakeRecorder) {
+	recorder := record.NewFakeRecorder(10)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(pod).Build()
+	return &InstaSliceDaemonsetReconciler{Client: fakeClient, Recorder: recorder, CheckConfigMapReferences: true}, recorder
+}
+
+func TestCreateConfigMapWarnsPodNotReadingIt(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-name-1", Namespace: "default", UID: "pod-uid-1"},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name: "main",
+			// reads another ConfigMap than the one of its slice
+			EnvFrom: []v1.EnvFromSource{{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "settings"}}}},
+		}}},
+	}
+	reconciler, recorder := newConfigMapReferencesTestReconciler(pod)
+
+	require.NoError(t, reconciler.createConfigMap(context.Background(), []string{"MIG-1"}, "default", "pod-name-1", "pod-uid-1", "1g.5gb", 4864))
+
+	require.Len(t, recorder.Events, 1)
+	event := <-recorder.Events
+	assert.Contains(t, event, "Warning "+EventReasonSliceNotConsumed)
+	assert.Contains(t, event, "pod-name-1")
+}
+
+func TestCreateConfigMapAcceptsPodReadingIt(t *testing.T) {
+	configMapRef := v1.LocalObjectReference{Name: "pod-name-1"}
+	for name, spec := range map[string]v1.PodSpec{
+		"envFrom": {Containers: []v1.Container{{Name: "main",
+			EnvFrom: []v1.EnvFromSource{{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: configMapRef}}}}}},
+		"env": {InitContainers: []v1.Container{{Name: "setup",
+			Env: []v1.EnvVar{{Name: "NVIDIA_VISIBLE_DEVICES", ValueFrom: &v1.EnvVarSource{
+				ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: configMapRef, Key: "NVIDIA_VISIBLE_DEVICES"}}}}}}},
+		"volume": {Containers: []v1.Container{{Name: "main"}}, Volumes: []v1.Volume{{Name: "devices",
+			VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: configMapRef}}}}},
+		"projected": {Containers: []v1.Container{{Name: "main"}}, Volumes: []v1.Volume{{Name: "devices",
+			VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{Sources: []v1.VolumeProjection{
+				{ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: configMapRef}}}}}}}},
+	} {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-name-1", Namespace: "default", UID: "pod-uid-1"}, Spec: spec}
+		reconciler, recorder := newConfigMapReferencesTestReconciler(pod)
+
+		require.NoError(t, reconciler.createConfigMap(context.Background(), []string{"MIG-1"}, "default", "pod-name-1", "pod-uid-1", "1g.5gb", 4864))
+
+		assert.Empty(t, recorder.Events, name)
+	}
+}
+
+func TestCreateConfigMapDoesNotCheckReferencesByDefault(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-name-1", Namespace: "default", UID: "pod-uid-1"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "main"}}},
+	}
+	reconciler, recorder := newConfigMapReferencesTestReconciler(pod)
+	reconciler.CheckConfigMapReferences = false
+
+	require.NoError(t, reconciler.createConfigMap(context.Background(), []string{"MIG-1"}, "default", "pod-name-1", "pod-uid-1", "1g.5gb", 4864))
+
+	assert.Empty(t, recorder.Events)
+}
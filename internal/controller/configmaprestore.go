@@ -0,0 +1,115 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// recreateConfigMap creates the ConfigMap of the pod of the allocation again for its MIG devices, unless it exists.
+// It reports false, creating nothing, when a MIG device is no longer on the node.
+func (r *InstaSliceDaemonsetReconciler) recreateConfigMap(ctx context.Context, migUUIDs []string, allocation inferencev1alpha1.AllocationDetails) (bool, error) {
+	if len(migUUIDs) == 0 {
+		return false, nil
+	}
+	nvmllib := r.handler().nvml
+	var memorySizeMB uint64
+	for _, migUUID := range migUUIDs {
+		device, ret := nvmllib.DeviceGetHandleByUUID(migUUID)
+		if ret != nvml.SUCCESS {
+			log.FromContext(ctx).Info("MIG device of the pod is gone", "pod", allocation.PodName, "migUUID", migUUID, "ret", ret)
+			return false, nil
+		}
+		if attributes, ret := device.GetAttributes(); ret == nvml.SUCCESS {
+			memorySizeMB += attributes.MemorySizeMB
+		}
+	}
+	if err := r.createConfigMap(ctx, migUUIDs, allocation.Namespace, allocation.PodName, allocation.PodUUID, allocation.Profile, memorySizeMB); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// restoreMissingConfigMaps creates again the ConfigMaps of the realized slices of the node that were deleted, e.g.
+// by hand, leaving their pods without a device while the slices stay held. When the pod is gone as well, the
+// allocation moves to deleting for the slice to be reclaimed. It reports whether any was.
+func (r *InstaSliceDaemonsetReconciler) restoreMissingConfigMaps(ctx context.Context, nodeName string, instaslice *inferencev1alpha1.Instaslice) (bool, error) {
+	migUUIDs := allocationMigUUIDs(instaslice)
+	var orphaned []string
+	for podUUID, allocation := range instaslice.Spec.Allocations {
+		if !settledAllocation(allocation) || podUUID != allocation.PodUUID || isPoolAllocation(allocation) || len(migUUIDs[podUUID]) == 0 {
+			continue
+		}
+		var configMap v1.ConfigMap
+		err := r.Get(ctx, r.configMapKey(allocation.Namespace, allocation.PodName), &configMap)
+		if err == nil {
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		// the cache may not have seen a pod the controller just placed, only the API server tells it is gone
+		var pod v1.Pod
+		err = r.apiReader().Get(ctx, types.NamespacedName{Name: allocation.PodName, Namespace: allocation.Namespace}, &pod)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		if err != nil || string(pod.UID) != allocation.PodUUID {
+			orphaned = append(orphaned, podUUID)
+			continue
+		}
+		log.FromContext(ctx).Info("ConfigMap of the slice is missing, creating it again for ", "pod", allocation.PodName)
+		if _, err := r.recreateConfigMap(ctx, migUUIDs[podUUID], allocation); err != nil {
+			return false, err
+		}
+	}
+	if len(orphaned) == 0 {
+		return false, nil
+	}
+	sort.Strings(orphaned)
+	reclaimed := 0
+	_, err := r.updateInstaslice(ctx, nodeName, func(latest *inferencev1alpha1.Instaslice) error {
+		reclaimed = 0
+		for _, podUUID := range orphaned {
+			allocation, exists := latest.Spec.Allocations[podUUID]
+			if !exists || !settledAllocation(allocation) {
+				continue
+			}
+			log.FromContext(ctx).Info("ConfigMap and pod of the slice are gone, reclaiming slice of ", "pod", allocation.PodName)
+			allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+			latest.Spec.Allocations[podUUID] = allocation
+			reclaimed++
+		}
+		if reclaimed == 0 {
+			return errInstasliceUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return reclaimed > 0, nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestReconcileRecreatesDeletedConfigMapOfLivePod(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	migUUID, _ := preparedOfPod(*latestTestInstaslice(t, fakeClient), "pod-uid-0")
+	require.NotEmpty(t, migUUID)
+	require.NoError(t, fakeClient.Create(ctx, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default", UID: "pod-uid-0"}}))
+	require.NoError(t, fakeClient.Delete(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default"}}))
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-0", Namespace: "default"}, &configMap))
+	assert.Equal(t, migUUID, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	assert.Equal(t, "pod-uid-0", configMap.Labels[ConfigMapPodUIDLabel])
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, latestTestInstaslice(t, fakeClient).Spec.Allocations["pod-uid-0"].Allocationstatus)
+}
+
+func TestRestoreMissingConfigMapsReclaimsSliceOfDeletedPod(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Delete(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default"}}))
+
+	reclaimed, err := reconciler.restoreMissingConfigMaps(ctx, "node-1", latestTestInstaslice(t, fakeClient))
+	require.NoError(t, err)
+	assert.True(t, reclaimed)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, latestTestInstaslice(t, fakeClient).Spec.Allocations["pod-uid-0"].Allocationstatus)
+}
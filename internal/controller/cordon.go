@@ -0,0 +1,37 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// gpuCordoned reports whether the GPU is cordoned, new slices are not placed on it.
+func gpuCordoned(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) bool {
+	for _, cordoned := range instaslice.Spec.CordonedGPUs {
+		if cordoned == gpuUUID {
+			return true
+		}
+	}
+	return false
+}
+
+// gpuTakesNewSlices reports whether new slices may be placed on the GPU, neither cordoned, waiting for a reset
+// nor draining for its desired layout.
+func gpuTakesNewSlices(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) bool {
+	return !gpuCordoned(instaslice, gpuUUID) && !gpuResetPending(instaslice, gpuUUID) && !layoutPending(instaslice, gpuUUID)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newCordonTestInstaslice returns a node with two empty GPUs, GPU-1 being cordoned.
+func newCordonTestInstaslice() *inferencev1alpha1.Instaslice {
+	instaslice := newPlacementTestInstaslice()
+	instaslice.Spec.MigGPUUUID["GPU-2"] = "NVIDIA A100-PCIE-40GB"
+	instaslice.Status.Prepared = nil
+	instaslice.Spec.CordonedGPUs = []string{"GPU-1"}
+	return instaslice
+}
+
+func TestFindDeviceForASliceSkipsCordonedGPU(t *testing.T) {
+	r := &InstasliceReconciler{}
+	instaslice := newCordonTestInstaslice()
+
+	for i, podName := range []string{"pod-1", "pod-2", "pod-3"} {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: "default", UID: k8stypes.UID("uid-" + podName)}}
+		allocation, err := r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+		require.NoError(t, err)
+		assert.Equal(t, "GPU-2", allocation.GPUUUID)
+		assert.Equal(t, uint32(i), allocation.Start)
+		instaslice.Spec.Allocations[allocation.PodUUID] = *allocation
+	}
+
+	// a preferred GPU that is cordoned is not used either
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "pod-4", Namespace: "default", UID: "uid-pod-4",
+		Annotations: map[string]string{PreferredGPUAnnotation: "GPU-1"},
+	}}
+	allocation, err := r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, "GPU-2", allocation.GPUUUID)
+	assert.True(t, allocation.PreferredGPUFallback)
+
+	// once every GPU is cordoned nothing is placed
+	instaslice.Spec.CordonedGPUs = append(instaslice.Spec.CordonedGPUs, "GPU-2")
+	_, err = r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.Error(t, err)
+}
+
+func TestRetainedSliceOnCordonedGPUIsNotReused(t *testing.T) {
+	r := &InstasliceReconciler{}
+	instaslice := newCordonTestInstaslice()
+	retainedAt := metav1.Now()
+	retained := newRetainTestAllocation("pod-uid-a", "pod-a", "GPU-1", inferencev1alpha1.AllocationStatusRetained)
+	retained.RetainedAt = &retainedAt
+	instaslice.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{"pod-uid-a": retained}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-b", Namespace: "default", UID: "pod-uid-b"}}
+
+	allocation, err := r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Empty(t, allocation.ReusedFrom)
+	assert.Equal(t, "GPU-2", allocation.GPUUUID)
+}
+
+func TestCordonedGPUAdvertisesNoCapacity(t *testing.T) {
+	instaslice := newCordonTestInstaslice()
+
+	assert.Equal(t, map[string]int{"GPU-1": 0, "GPU-2": 7}, availableSlices(instaslice))
+	assert.Equal(t, map[string]int{"1g.5gb": 7}, freeSlicesPerProfile(instaslice))
+
+	instaslice.Spec.CordonedGPUs = nil
+	assert.Equal(t, map[string]int{"GPU-1": 7, "GPU-2": 7}, availableSlices(instaslice))
+	assert.Equal(t, map[string]int{"1g.5gb": 14}, freeSlicesPerProfile(instaslice))
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// DefaultMaxSliceCreationAttempts is the number of times the slice of an allocation is carved before
+	// its creation is given up.
+	DefaultMaxSliceCreationAttempts = 5
+	// DefaultSliceCreationRetryBase is how long after a first failed attempt the slice of an allocation is carved again.
+	DefaultSliceCreationRetryBase = 2 * time.Second
+	// DefaultSliceCreationRetryMax bounds the wait between attempts to carve the slice of an allocation.
+	DefaultSliceCreationRetryMax = 1 * time.Minute
+	// DefaultSliceCreationRetryFactor multiplies the wait after every further failed attempt.
+	DefaultSliceCreationRetryFactor = 2.0
+	// EventReasonSliceCreationFailed is the reason of the event emitted on a pod whose slice could not be carved.
+	EventReasonSliceCreationFailed = "SliceCreationFailed"
+	// ReasonSliceCreationFailed is recorded in status.unschedulableOnNode for a pod whose slice could not be carved.
+	ReasonSliceCreationFailed = "SliceCreationFailed"
+)
+
+// SliceCreationRetryPolicy bounds and spaces the attempts to carve the slice of an allocation.
+type SliceCreationRetryPolicy struct {
+	// MaxAttempts is the number of times in a row the slice may fail to be carved before the allocation is marked
+	// failed and the pod told in an event. Attempts are retried forever when unset, the daemonset sets it from
+	// --max-slice-creation-attempts, DefaultMaxSliceCreationAttempts unless given.
+	MaxAttempts int
+	// Base is how long after a first failed attempt the slice is carved again, every further failure multiplying
+	// the wait by Factor up to Max. The defaults are used for the ones unset.
+	Base   time.Duration
+	Max    time.Duration
+	Factor float64
+}
+
+// errSliceNotRealized is returned when the gi and ci of a slice were carved but not the MIG device backing them.
+var errSliceNotRealized = errors.New("MIG device of the slice was not found")
+
+// destroyUnrealizedSlice destroys a slice whose MIG device was not found, freeing its placement for the retry.
+func destroyUnrealizedSlice(ctx context.Context, device nvml.Device, giID uint32, ciIDs []int, allocation inferencev1alpha1.AllocationDetails) error {
+	if ret := destroySlice(device, int(giID), ciIDs...); ret != nvml.SUCCESS {
+		log.FromContext(ctx).Error(ret, "unable to destroy unrealized slice for ", "pod", allocation.PodName)
+	}
+	return errSliceNotRealized
+}
+
+// hardSliceCreationFailure reports whether carving the slice failed in a way retrying on the node will not fix,
+// the GPU not having the resources for it at any of the placements tried or not offering its profile at all.
+func hardSliceCreationFailure(err error) bool {
+	return errors.Is(err, nvml.ERROR_INSUFFICIENT_RESOURCES) || errors.Is(err, errProfileNotAvailable)
+}
+
+// sliceCreationFailed counts a failed attempt to carve the slice of the allocation. Once the MaxAttempts of the
+// retry policy failed in a row, or right away on a hard failure, the allocation is marked failed, the failure recorded in status.unschedulableOnNode and
+// reported in an event on the pod, which stays gated until it is deleted. It reports whether the allocation was
+// marked failed. Deferred attempts and attempts aborted on an invalidated NVML handle are not counted, and
+// attempts are never given up when MaxAttempts is unset, the count only spacing them then.
+func (r *InstaSliceDaemonsetReconciler) sliceCreationFailed(ctx context.Context, instasliceName string, allocation inferencev1alpha1.AllocationDetails, errCarving error) (bool, error) {
+	if _, deferred := sliceOperationRetryAfter(errCarving); deferred || isNVMLHandleLost(errCarving) {
+		return false, nil
+	}
+	r.recordCreationAttempts(ctx, instasliceName, creationAttempt(allocation, errCarving))
+	r.creationFailuresMu.Lock()
+	if r.creationFailures == nil {
+		r.creationFailures = make(map[string]int)
+	}
+	r.creationFailures[allocation.PodUUID]++
+	attempts := r.creationFailures[allocation.PodUUID]
+	r.creationFailuresMu.Unlock()
+	maxAttempts := r.settings().sliceCreationRetry.MaxAttempts
+	if !hardSliceCreationFailure(errCarving) && (maxAttempts <= 0 || attempts < maxAttempts) {
+		return false, nil
+	}
+
+	marked := false
+	if _, err := r.updateInstaslice(ctx, instasliceName, func(latest *inferencev1alpha1.Instaslice) error {
+		marked = false
+		current, exists := latest.Spec.Allocations[allocation.PodUUID]
+		if !exists || current.Allocationstatus != inferencev1alpha1.AllocationStatusCreating {
+			return errInstasliceUnchanged
+		}
+		current.Allocationstatus = inferencev1alpha1.AllocationStatusFailed
+		latest.Spec.Allocations[allocation.PodUUID] = current
+		marked = true
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	r.clearSliceCreationFailures(allocation.PodUUID)
+	if !marked {
+		return false, nil
+	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var latest inferencev1alpha1.Instaslice
+		if err := r.Get(ctx, types.NamespacedName{Name: instasliceName, Namespace: r.instasliceNamespace()}, &latest); err != nil {
+			return err
+		}
+		if latest.Status.UnschedulableOnNode == nil {
+			latest.Status.UnschedulableOnNode = make(map[string]inferencev1alpha1.UnschedulablePod)
+		}
+		latest.Status.UnschedulableOnNode[allocation.PodUUID] = inferencev1alpha1.UnschedulablePod{
+			PodName:   allocation.PodName,
+			Namespace: allocation.Namespace,
+			Profile:   allocation.Profile,
+			Reason:    ReasonSliceCreationFailed,
+			Since:     now(),
+		}
+		return r.Status().Update(ctx, &latest)
+	})
+	r.recordSliceCreationFailed(ctx, allocation, attempts, errCarving)
+	return true, err
+}
+
+// sliceCreationRetryAfter returns how long to wait before carving the slice of the pod again, the base wait
+// multiplied by the factor for every failed attempt after the first, up to the maximum.
+func (r *InstaSliceDaemonsetReconciler) sliceCreationRetryAfter(podUUID string) time.Duration {
+	policy := r.settings().sliceCreationRetry
+	base, maxWait, factor := policy.Base, policy.Max, policy.Factor
+	if base <= 0 {
+		base = DefaultSliceCreationRetryBase
+	}
+	if maxWait <= 0 {
+		maxWait = DefaultSliceCreationRetryMax
+	}
+	if factor < 1 {
+		factor = DefaultSliceCreationRetryFactor
+	}
+	r.creationFailuresMu.Lock()
+	attempts := r.creationFailures[podUUID]
+	r.creationFailuresMu.Unlock()
+	wait := base
+	for i := 1; i < attempts && wait < maxWait; i++ {
+		wait = time.Duration(float64(wait) * factor)
+	}
+	return min(wait, maxWait)
+}
+
+// clearSliceCreationFailures forgets the failed attempts to carve the slice of the pod.
+func (r *InstaSliceDaemonsetReconciler) clearSliceCreationFailures(podUUID string) {
+	r.creationFailuresMu.Lock()
+	defer r.creationFailuresMu.Unlock()
+	delete(r.creationFailures, podUUID)
+}
+
+// recordSliceCreationFailed reports the allocation given up in the logs and, when a recorder is set, in an event
+// on the pod.
+func (r *InstaSliceDaemonsetReconciler) recordSliceCreationFailed(ctx context.Context, allocation inferencev1alpha1.AllocationDetails, attempts int, errCarving error) {
+	log.FromContext(ctx).Error(errCarving, "giving up creating slice for ", "pod", allocation.PodName,
+		"namespace", allocation.Namespace, "profile", allocation.Profile, "attempts", attempts)
+	if r.Recorder == nil {
+		return
+	}
+	pod := &v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Name:       allocation.PodName,
+		Namespace:  allocation.Namespace,
+		UID:        types.UID(allocation.PodUUID),
+	}
+	r.Recorder.Eventf(pod, v1.EventTypeWarning, EventReasonSliceCreationFailed,
+		"Unable to create %s slice on GPU %s after %d attempts: %v", allocation.Profile, allocation.GPUUUID, attempts, errCarving)
+}
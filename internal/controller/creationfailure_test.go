@@ -0,0 +1,180 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestSliceCreationFailureIsReportedOnPod(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	recorder := record.NewFakeRecorder(10)
+	reconciler.Recorder = recorder
+	reconciler.SliceCreationRetryPolicy.MaxAttempts = 2
+	ctx := context.Background()
+	device.CreateGpuInstanceWithPlacementFunc = func(*nvml.GpuInstanceProfileInfo, *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		return nil, nvml.ERROR_UNKNOWN
+	}
+
+	var instaslice inferencev1alpha1.Instaslice
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.NotZero(t, result.RequeueAfter)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	// every failed attempt is reported on the pod and the instaslice, the allocation is only given up on later
+	require.Len(t, recorder.Events, 2)
+	assert.Contains(t, <-recorder.Events, "Warning "+EventReasonGpuInstanceCreateFailed)
+	assert.Contains(t, <-recorder.Events, "Warning "+EventReasonGpuInstanceCreateFailed)
+
+	result, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusFailed, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, ReasonSliceCreationFailed, instaslice.Status.UnschedulableOnNode["pod-uid-0"].Reason)
+	require.Len(t, recorder.Events, 3)
+	<-recorder.Events
+	<-recorder.Events
+	assert.Contains(t, <-recorder.Events, "Warning "+EventReasonSliceCreationFailed)
+	assert.Empty(t, device.GpuInstances)
+}
+
+func TestDeferredSliceCreationIsNotCountedAsFailure(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.SliceCreationRetryPolicy.MaxAttempts = 1
+	ctx := context.Background()
+	withoutPossiblePlacementAt(device, 0)
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Empty(t, reconciler.creationFailures)
+}
+
+func TestConfiguredRetriesSpaceAndBoundSliceCreation(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.SliceCreationRetryPolicy.MaxAttempts = 4
+	reconciler.SliceCreationRetryPolicy.Base = time.Second
+	reconciler.SliceCreationRetryPolicy.Max = 5 * time.Second
+	reconciler.SliceCreationRetryPolicy.Factor = 3
+	ctx := context.Background()
+	tries := 0
+	device.CreateGpuInstanceWithPlacementFunc = func(*nvml.GpuInstanceProfileInfo, *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		tries++
+		return nil, nvml.ERROR_UNKNOWN
+	}
+
+	var instaslice inferencev1alpha1.Instaslice
+	for _, retryAfter := range []time.Duration{time.Second, 3 * time.Second, 5 * time.Second} {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+		require.NoError(t, err)
+		assert.Equal(t, retryAfter, result.RequeueAfter)
+		require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+		assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	}
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusFailed, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, 4, tries)
+
+	// a failed allocation is not carved again
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, tries)
+}
+
+func TestSliceCreationRetryDefaults(t *testing.T) {
+	reconciler := &InstaSliceDaemonsetReconciler{creationFailures: map[string]int{"pod-uid-0": 1, "pod-uid-1": 3, "pod-uid-2": 10}}
+
+	assert.Equal(t, DefaultSliceCreationRetryBase, reconciler.sliceCreationRetryAfter("pod-uid-0"))
+	assert.Equal(t, 4*DefaultSliceCreationRetryBase, reconciler.sliceCreationRetryAfter("pod-uid-1"))
+	assert.Equal(t, DefaultSliceCreationRetryMax, reconciler.sliceCreationRetryAfter("pod-uid-2"))
+}
+
+func TestFailedComputeInstanceLeavesAllocationCreating(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	createGpuInstance := device.CreateGpuInstanceWithPlacementFunc
+	device.CreateGpuInstanceWithPlacementFunc = func(info *nvml.GpuInstanceProfileInfo, placement *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		gi, ret := createGpuInstance(info, placement)
+		if ret == nvml.SUCCESS {
+			gi.(*dgxa100.GpuInstance).CreateComputeInstanceFunc = func(*nvml.ComputeInstanceProfileInfo) (nvml.ComputeInstance, nvml.Return) {
+				return nil, nvml.ERROR_UNKNOWN
+			}
+		}
+		return gi, ret
+	}
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	capacity := node.Status.Capacity.DeepCopy()
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.NotZero(t, result.RequeueAfter)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Empty(t, instaslice.Status.Prepared)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.Equal(t, capacity, node.Status.Capacity)
+	var configMap v1.ConfigMap
+	err = fakeClient.Get(ctx, types.NamespacedName{Name: "pod-0", Namespace: "default"}, &configMap)
+	assert.True(t, apierrors.IsNotFound(err))
+	// the gi alone is destroyed so that the retry can carve the slice at the same placement
+	assert.Empty(t, device.GpuInstances)
+	_, cached := reconciler.slices.cached("pod-0")
+	assert.False(t, cached)
+}
+
+func TestInsufficientResourcesMarksAllocationFailed(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	device.CreateGpuInstanceWithPlacementFunc = func(*nvml.GpuInstanceProfileInfo, *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		return nil, nvml.ERROR_INSUFFICIENT_RESOURCES
+	}
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusFailed, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, ReasonSliceCreationFailed, instaslice.Status.UnschedulableOnNode["pod-uid-0"].Reason)
+}
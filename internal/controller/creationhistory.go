@@ -0,0 +1,109 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// CreationHistoryAnnotation holds, on the instaslice, the JSON list of the latest attempts to carve the slices
+	// of the node, oldest first, see CreationAttempt.
+	CreationHistoryAnnotation = "instaslice.codeflare.dev/creation-history"
+	// CreationSucceeded is the outcome of an attempt that carved the slice.
+	CreationSucceeded = "success"
+	// CreationFailed is the outcome of an attempt that failed to carve the slice.
+	CreationFailed = "failure"
+)
+
+// CreationAttempt is an entry of the creation history of the instaslice.
+type CreationAttempt struct {
+	Time      metav1.Time `json:"time"`
+	PodUUID   string      `json:"podUUID"`
+	PodName   string      `json:"podName,omitempty"`
+	Namespace string      `json:"namespace,omitempty"`
+	Profile   string      `json:"profile"`
+	GPUUUID   string      `json:"gpuUUID"`
+	Start     uint32      `json:"start"`
+	Size      uint32      `json:"size"`
+	Outcome   string      `json:"outcome"`
+	Reason    string      `json:"reason,omitempty"`
+}
+
+// creationAttempt describes an attempt to carve the slice of the allocation at its placement, failed with
+// errCarving unless it is nil.
+func creationAttempt(allocation inferencev1alpha1.AllocationDetails, errCarving error) CreationAttempt {
+	attempt := CreationAttempt{
+		Time:      now(),
+		PodUUID:   allocation.PodUUID,
+		PodName:   allocation.PodName,
+		Namespace: allocation.Namespace,
+		Profile:   allocation.Profile,
+		GPUUUID:   allocation.GPUUUID,
+		Start:     allocation.Start,
+		Size:      allocation.Size,
+		Outcome:   CreationSucceeded,
+	}
+	if errCarving != nil {
+		attempt.Outcome = CreationFailed
+		attempt.Reason = errCarving.Error()
+	}
+	return attempt
+}
+
+// creationHistory returns the creation history recorded on the instaslice, an unreadable one is started over.
+func creationHistory(instaslice *inferencev1alpha1.Instaslice) []CreationAttempt {
+	var history []CreationAttempt
+	if value := instaslice.Annotations[CreationHistoryAnnotation]; value != "" {
+		if err := json.Unmarshal([]byte(value), &history); err != nil {
+			return nil
+		}
+	}
+	return history
+}
+
+// recordCreationAttempts appends the attempts to the creation history of the instaslice, keeping the latest
+// CreationHistoryLength ones. The history is informational, failing to record it is only logged.
+func (r *InstaSliceDaemonsetReconciler) recordCreationAttempts(ctx context.Context, instasliceName string, attempts ...CreationAttempt) {
+	if r.CreationHistoryLength <= 0 || len(attempts) == 0 {
+		return
+	}
+	_, err := r.updateInstaslice(ctx, instasliceName, func(latest *inferencev1alpha1.Instaslice) error {
+		history := append(creationHistory(latest), attempts...)
+		if len(history) > r.CreationHistoryLength {
+			history = history[len(history)-r.CreationHistoryLength:]
+		}
+		value, err := json.Marshal(history)
+		if err != nil {
+			return err
+		}
+		if latest.Annotations == nil {
+			latest.Annotations = make(map[string]string)
+		}
+		latest.Annotations[CreationHistoryAnnotation] = string(value)
+		return nil
+	})
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to record the creation history", "attempts", len(attempts))
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestCreationHistoryRecordsFailedAttemptsThenSuccess(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.CreationHistoryLength = 10
+	ctx := context.Background()
+	// the driver fails the first two attempts to carve the slice
+	failures := 2
+	createGpuInstanceWithPlacement := device.CreateGpuInstanceWithPlacementFunc
+	device.CreateGpuInstanceWithPlacementFunc = func(info *nvml.GpuInstanceProfileInfo, placement *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		if failures > 0 {
+			failures--
+			return nil, nvml.ERROR_UNKNOWN
+		}
+		return createGpuInstanceWithPlacement(info, placement)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+		require.NoError(t, err)
+	}
+	instaslice := latestTestInstaslice(t, fakeClient)
+	require.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+
+	history := creationHistory(instaslice)
+	require.Len(t, history, 3)
+	for i, outcome := range []string{CreationFailed, CreationFailed, CreationSucceeded} {
+		assert.Equal(t, outcome, history[i].Outcome, "attempt %d", i)
+		assert.Equal(t, "pod-uid-0", history[i].PodUUID)
+		assert.Equal(t, "1g.5gb", history[i].Profile)
+		assert.Equal(t, device.UUID, history[i].GPUUUID)
+		assert.Equal(t, uint32(0), history[i].Start)
+		assert.Equal(t, uint32(1), history[i].Size)
+	}
+	assert.NotEmpty(t, history[0].Reason)
+	assert.Empty(t, history[2].Reason)
+}
+
+func TestCreationHistoryIsBounded(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t)
+	reconciler.CreationHistoryLength = 3
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		allocation := inferencev1alpha1.AllocationDetails{PodUUID: fmt.Sprintf("pod-uid-%d", i), Profile: "1g.5gb"}
+		reconciler.recordCreationAttempts(ctx, "node-1", creationAttempt(allocation, nil))
+	}
+
+	history := creationHistory(latestTestInstaslice(t, fakeClient))
+	require.Len(t, history, 3)
+	assert.Equal(t, "pod-uid-2", history[0].PodUUID, "the oldest attempts are dropped")
+	assert.Equal(t, "pod-uid-4", history[2].PodUUID)
+}
+
+func TestCreationHistoryIsOffByDefault(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	_, err := reconciler.Reconcile(context.Background(), ctrl.Request{})
+	require.NoError(t, err)
+	assert.NotContains(t, latestTestInstaslice(t, fakeClient).Annotations, CreationHistoryAnnotation)
+}
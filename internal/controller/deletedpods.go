@@ -0,0 +1,198 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"os"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// reclaimableOnPodRemoval reports whether the slice of the allocation has to be torn down once its pod is gone.
+// Retained slices and the slices of the pools belong to no pod, and the slices already being torn down or torn
+// down are left alone, as are failed allocations which have no slice.
+func reclaimableOnPodRemoval(allocation inferencev1alpha1.AllocationDetails) bool {
+	if allocation.Namespace == "" || isPoolAllocation(allocation) {
+		return false
+	}
+	switch allocation.Allocationstatus {
+	case inferencev1alpha1.AllocationStatusDeleting, inferencev1alpha1.AllocationStatusDeleted, inferencev1alpha1.AllocationStatusFailed,
+		inferencev1alpha1.AllocationStatusRetained, inferencev1alpha1.AllocationStatusPreempted:
+		return false
+	}
+	return true
+}
+
+// reclaimAllocationsOfDeletedPods moves the allocations whose pod no longer exists to deleting. The controller
+// marks the allocation of a pod deleting when it sees the pod go, a deletion missed, e.g. while the controller
+// was down, would otherwise keep the slice carved for good. Retained slices and the slices of the pools belong
+// to no pod and are left alone.
+func (r *InstaSliceDaemonsetReconciler) reclaimAllocationsOfDeletedPods(ctx context.Context, nodeName string) error {
+	var instaslice inferencev1alpha1.Instaslice
+	typeNamespacedName := types.NamespacedName{
+		Name:      nodeName,
+		Namespace: r.instasliceNamespace(),
+	}
+	if err := r.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+		return err
+	}
+	if len(instaslice.Spec.Allocations) == 0 {
+		return nil
+	}
+
+	// only the namespaces of the allocations are listed, not every pod of the cluster
+	namespaces := make(map[string]bool)
+	for _, allocation := range instaslice.Spec.Allocations {
+		if reclaimableOnPodRemoval(allocation) {
+			namespaces[allocation.Namespace] = true
+		}
+	}
+	livePods := make(map[string]bool)
+	for namespace := range namespaces {
+		var pods v1.PodList
+		if err := r.List(ctx, &pods, client.InNamespace(namespace)); err != nil {
+			return err
+		}
+		for _, pod := range pods.Items {
+			livePods[string(pod.UID)] = true
+		}
+	}
+	gone := make(map[string]bool)
+	for _, allocation := range instaslice.Spec.Allocations {
+		if livePods[allocation.PodUUID] || !reclaimableOnPodRemoval(allocation) {
+			continue
+		}
+		// the cache may not have seen a pod the controller just placed, only the API server tells it is gone
+		var pod v1.Pod
+		err := r.apiReader().Get(ctx, types.NamespacedName{Name: allocation.PodName, Namespace: allocation.Namespace}, &pod)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err == nil && string(pod.UID) == allocation.PodUUID {
+			continue
+		}
+		gone[allocation.PodUUID] = true
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+	_, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+		reclaimed := 0
+		for key, allocation := range latest.Spec.Allocations {
+			if !gone[allocation.PodUUID] || !reclaimableOnPodRemoval(allocation) {
+				continue
+			}
+			log.FromContext(ctx).Info("pod of the allocation is gone, reclaiming slice of ", "pod", allocation.PodName, "namespace", allocation.Namespace, "status", allocation.Allocationstatus)
+			allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+			latest.Spec.Allocations[key] = allocation
+			reclaimed++
+		}
+		if reclaimed == 0 {
+			return errInstasliceUnchanged
+		}
+		return nil
+	})
+	return err
+}
+
+// removedPodsHandler records the pods of the node, or not scheduled yet, as they are deleted and enqueues the
+// instaslice of the node. A pod force-deleted or evicted by a drain would otherwise keep its slice until the next
+// periodic verification.
+func (r *InstaSliceDaemonsetReconciler) removedPodsHandler() handler.EventHandler {
+	return handler.Funcs{
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			pod, ok := e.Object.(*v1.Pod)
+			nodeName := os.Getenv("NODE_NAME")
+			if !ok || (pod.Spec.NodeName != "" && pod.Spec.NodeName != nodeName) {
+				return
+			}
+			r.recordRemovedPods(string(pod.UID))
+			q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Name: nodeName, Namespace: r.instasliceNamespace()}})
+		},
+	}
+}
+
+// recordRemovedPods records the UIDs of deleted pods for the next reconcile.
+func (r *InstaSliceDaemonsetReconciler) recordRemovedPods(podUUIDs ...string) {
+	r.removedPodsMu.Lock()
+	defer r.removedPodsMu.Unlock()
+	if r.removedPods == nil {
+		r.removedPods = make(map[string]bool)
+	}
+	for _, podUUID := range podUUIDs {
+		r.removedPods[podUUID] = true
+	}
+}
+
+// takeRemovedPods returns the UIDs of the pods deleted since it was last called.
+func (r *InstaSliceDaemonsetReconciler) takeRemovedPods() map[string]bool {
+	r.removedPodsMu.Lock()
+	defer r.removedPodsMu.Unlock()
+	removed := r.removedPods
+	r.removedPods = nil
+	return removed
+}
+
+// reclaimAllocationsOfRemovedPods moves the allocations of the pods deleted since the last reconcile to deleting,
+// the reconcile then destroys their slices, deletes their ConfigMaps and withdraws their capacity as for any
+// deleted pod. It reports whether the instaslice was updated.
+func (r *InstaSliceDaemonsetReconciler) reclaimAllocationsOfRemovedPods(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) bool {
+	removed := r.takeRemovedPods()
+	if len(removed) == 0 || instaslice.Name == "" {
+		return false
+	}
+	pending := false
+	for _, allocation := range instaslice.Spec.Allocations {
+		if removed[allocation.PodUUID] && reclaimableOnPodRemoval(allocation) {
+			pending = true
+		}
+	}
+	if !pending {
+		return false
+	}
+	_, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+		for key, allocation := range latest.Spec.Allocations {
+			if !removed[allocation.PodUUID] || !reclaimableOnPodRemoval(allocation) {
+				continue
+			}
+			log.FromContext(ctx).Info("pod of the allocation was deleted, reclaiming slice of ", "pod", allocation.PodName, "namespace", allocation.Namespace, "status", allocation.Allocationstatus)
+			allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+			latest.Spec.Allocations[key] = allocation
+		}
+		return nil
+	})
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to reclaim slices of deleted pods")
+		// the next reconcile tries again
+		for podUUID := range removed {
+			r.recordRemovedPods(podUUID)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestSliceOfDeletedPodIsReclaimed(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.Len(t, device.GpuInstances, 2)
+	// pod-1 went away without its allocation being marked deleting
+	require.NoError(t, fakeClient.Create(ctx, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default", UID: "pod-uid-0"}}))
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	instaslice.Spec.Allocations["retained-uid"] = inferencev1alpha1.AllocationDetails{
+		PodUUID: "retained-uid", PodName: "retained", Namespace: "default", Allocationstatus: inferencev1alpha1.AllocationStatusRetained,
+	}
+	instaslice.Spec.Allocations["failed-uid"] = inferencev1alpha1.AllocationDetails{
+		PodUUID: "failed-uid", PodName: "failed", Namespace: "default", Allocationstatus: inferencev1alpha1.AllocationStatusFailed,
+	}
+	instaslice.Spec.Allocations["deleted-uid"] = inferencev1alpha1.AllocationDetails{
+		PodUUID: "deleted-uid", PodName: "deleted", Namespace: "default", Allocationstatus: inferencev1alpha1.AllocationStatusDeleted,
+	}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	require.NoError(t, reconciler.reclaimAllocationsOfDeletedPods(ctx, "node-1"))
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, instaslice.Spec.Allocations["pod-uid-1"].Allocationstatus)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusRetained, instaslice.Spec.Allocations["retained-uid"].Allocationstatus)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusFailed, instaslice.Spec.Allocations["failed-uid"].Allocationstatus)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleted, instaslice.Spec.Allocations["deleted-uid"].Allocationstatus)
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Contains(t, instaslice.Spec.Allocations, "pod-uid-0")
+	assert.NotContains(t, instaslice.Spec.Allocations, "pod-uid-1")
+	assert.Len(t, instaslice.Status.Prepared, 1)
+	assert.Len(t, device.GpuInstances, 1)
+}
+
+func TestAllocationOfPodRecreatedWithSameNameIsReclaimed(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	require.NoError(t, fakeClient.Create(ctx, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default", UID: "pod-uid-new"}}))
+
+	require.NoError(t, reconciler.reclaimAllocationsOfDeletedPods(ctx, "node-1"))
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+}
+
+func TestSliceOfPodDeletedOutOfBandIsTornDown(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.Len(t, device.GpuInstances, 2)
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-1", Namespace: "default"}, &configMap))
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+	removed := reconciler.removedPodsHandler()
+	// pods of other nodes hold no slice here
+	removed.Delete(ctx, event.DeleteEvent{Object: &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default", UID: "pod-uid-0"},
+		Spec:       v1.PodSpec{NodeName: "node-2"},
+	}}, queue)
+	assert.Equal(t, 0, queue.Len())
+	// pod-1 was force-deleted, its allocation is still created
+	removed.Delete(ctx, event.DeleteEvent{Object: &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"},
+		Spec:       v1.PodSpec{NodeName: "node-1"},
+	}}, queue)
+	require.Equal(t, 1, queue.Len())
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: nsName})
+	require.NoError(t, err)
+	assert.True(t, result.Requeue)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, instaslice.Spec.Allocations["pod-uid-1"].Allocationstatus)
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: nsName})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Contains(t, instaslice.Spec.Allocations, "pod-uid-0")
+	assert.NotContains(t, instaslice.Spec.Allocations, "pod-uid-1")
+	assert.Len(t, instaslice.Status.Prepared, 1)
+	assert.Len(t, device.GpuInstances, 1)
+	err = fakeClient.Get(ctx, types.NamespacedName{Name: "pod-1", Namespace: "default"}, &configMap)
+	assert.True(t, apierrors.IsNotFound(err), "%v", err)
+}
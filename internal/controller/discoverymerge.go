@@ -0,0 +1,130 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// gpuReferenced reports whether an allocation or a prepared slice of the instaslice is on the GPU.
+func gpuReferenced(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) bool {
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.GPUUUID == gpuUUID {
+			return true
+		}
+	}
+	for _, prepared := range instaslice.Status.Prepared {
+		if prepared.Parent == gpuUUID {
+			return true
+		}
+	}
+	return false
+}
+
+// profileReferenced reports whether an allocation of the instaslice is for a slice of the profile.
+func profileReferenced(instaslice *inferencev1alpha1.Instaslice, profileName string) bool {
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.Profile == profileName {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeDiscovered folds what discovery found on the GPUs into the instaslice the node already has, e.g. after a
+// restart of the daemonset. Allocations are left alone and prepared slices keep the pod they are recorded for.
+// GPUs and profiles that were not found again are kept while allocations refer to them, slices not found again
+// are kept for the reconciles to carve them again or drop them.
+func mergeDiscovered(existing *inferencev1alpha1.Instaslice, discovered *inferencev1alpha1.Instaslice) {
+	gpus := make(map[string]string, len(discovered.Spec.MigGPUUUID))
+	for gpuUUID, model := range existing.Spec.MigGPUUUID {
+		if gpuReferenced(existing, gpuUUID) {
+			gpus[gpuUUID] = model
+		}
+	}
+	for gpuUUID, model := range discovered.Spec.MigGPUUUID {
+		gpus[gpuUUID] = model
+	}
+	existing.Spec.MigGPUUUID = gpus
+
+	migPlacement := append([]inferencev1alpha1.Mig(nil), discovered.Spec.Migplacement...)
+	for _, mig := range existing.Spec.Migplacement {
+		rediscovered := false
+		for _, discoveredMig := range discovered.Spec.Migplacement {
+			if discoveredMig.Profile == mig.Profile {
+				rediscovered = true
+				break
+			}
+		}
+		if !rediscovered && profileReferenced(existing, mig.Profile) {
+			migPlacement = append(migPlacement, mig)
+		}
+	}
+	existing.Spec.Migplacement = migPlacement
+
+	if existing.Status.Prepared == nil && len(discovered.Status.Prepared) > 0 {
+		existing.Status.Prepared = make(map[string]inferencev1alpha1.PreparedDetails, len(discovered.Status.Prepared))
+	}
+	for migUUID, prepared := range discovered.Status.Prepared {
+		// the hardware knows the placement and current GI/CI ids of the slice but not the pod it was carved for nor
+		// the version of the operator that carved it
+		if current, exists := existing.Status.Prepared[migUUID]; exists {
+			prepared.PodUUID = current.PodUUID
+			prepared.CarvedBy = current.CarvedBy
+			// a version of the operator named the profile of its slice, even one this version does not know
+			if current.CarvedBy != "" && prepared.Profile == ForeignSliceProfile {
+				prepared.Profile = current.Profile
+			}
+		}
+		existing.Status.Prepared[migUUID] = prepared
+	}
+}
+
+// moveSpecPrepared moves the prepared entries an earlier version kept under spec.prepared of the instaslice to its
+// status, leaving the entries already recorded there alone, and clears the deprecated field. It returns the MIG
+// UUIDs of the moved entries.
+func moveSpecPrepared(instaslice *inferencev1alpha1.Instaslice) []string {
+	if len(instaslice.Spec.Prepared) == 0 {
+		instaslice.Spec.Prepared = nil
+		return nil
+	}
+	if instaslice.Status.Prepared == nil {
+		instaslice.Status.Prepared = make(map[string]inferencev1alpha1.PreparedDetails, len(instaslice.Spec.Prepared))
+	}
+	var moved []string
+	for migUUID, prepared := range instaslice.Spec.Prepared {
+		if _, exists := instaslice.Status.Prepared[migUUID]; exists {
+			continue
+		}
+		instaslice.Status.Prepared[migUUID] = prepared
+		moved = append(moved, migUUID)
+	}
+	instaslice.Spec.Prepared = nil
+	sort.Strings(moved)
+	return moved
+}
+
+// mergeDiscoveredConditions sets the conditions discovery came to on the status, leaving the other ones alone.
+func mergeDiscoveredConditions(status *inferencev1alpha1.InstasliceStatus, discovered *inferencev1alpha1.InstasliceStatus) {
+	for _, condition := range discovered.Conditions {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestDiscoveryMergesIntoExistingInstaslice(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: nsName})
+	require.NoError(t, err)
+	var before inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &before))
+	require.Len(t, before.Status.Prepared, 2)
+	meta.SetStatusCondition(&before.Status.Conditions, metav1.Condition{
+		Type: ConditionLayoutPending, Status: metav1.ConditionFalse, Reason: "LayoutsApplied",
+	})
+	require.NoError(t, fakeClient.Status().Update(ctx, &before))
+
+	// the daemonset restarts and discovers the GPUs again
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+
+	var after inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &after))
+	assert.Equal(t, before.Spec.Allocations, after.Spec.Allocations)
+	assert.Equal(t, before.Status.Prepared, after.Status.Prepared)
+	for _, prepared := range after.Status.Prepared {
+		assert.NotEmpty(t, prepared.PodUUID)
+	}
+	assert.Equal(t, before.Spec.MigGPUUUID, after.Spec.MigGPUUUID)
+	assert.Equal(t, before.Spec.Migplacement, after.Spec.Migplacement)
+	assert.Equal(t, "true", after.Status.Processed)
+	assert.Equal(t, 2, after.Status.CarvedSlices[device.UUID])
+	assert.NotNil(t, meta.FindStatusCondition(after.Status.Conditions, ConditionLayoutPending))
+	assert.False(t, meta.IsStatusConditionTrue(after.Status.Conditions, ConditionDegraded))
+}
+
+func TestDiscoveryMovesSpecPreparedToStatus(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: nsName})
+	require.NoError(t, err)
+	// an earlier version kept the prepared entries in the spec
+	var before inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &before))
+	require.Len(t, before.Status.Prepared, 2)
+	prepared := before.Status.Prepared
+	before.Status.Prepared = nil
+	require.NoError(t, fakeClient.Status().Update(ctx, &before))
+	before.Spec.Prepared = prepared
+	require.NoError(t, fakeClient.Update(ctx, &before))
+
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+
+	var after inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &after))
+	assert.Empty(t, after.Spec.Prepared)
+	assert.Equal(t, prepared, after.Status.Prepared)
+	assert.Len(t, after.Spec.Allocations, 2)
+}
+
+func TestMoveSpecPreparedKeepsStatusEntries(t *testing.T) {
+	instaslice := &inferencev1alpha1.Instaslice{
+		Spec: inferencev1alpha1.InstasliceSpec{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{
+				"MIG-1": {Profile: "1g.5gb", Parent: "GPU-1", PodUUID: "pod-uid-old", Giinfoid: 1},
+				"MIG-2": {Profile: "1g.5gb", Parent: "GPU-1", PodUUID: "pod-uid-2", Giinfoid: 2},
+			},
+		},
+		Status: inferencev1alpha1.InstasliceStatus{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{
+				"MIG-1": {Profile: "1g.5gb", Parent: "GPU-1", PodUUID: "pod-uid-1", Giinfoid: 1},
+			},
+		},
+	}
+
+	assert.Equal(t, []string{"MIG-2"}, moveSpecPrepared(instaslice))
+	assert.Nil(t, instaslice.Spec.Prepared)
+	assert.Equal(t, "pod-uid-1", instaslice.Status.Prepared["MIG-1"].PodUUID)
+	assert.Equal(t, "pod-uid-2", instaslice.Status.Prepared["MIG-2"].PodUUID)
+	assert.Empty(t, moveSpecPrepared(instaslice))
+}
+
+func TestMergeDiscoveredKeepsReferencedState(t *testing.T) {
+	existing := &inferencev1alpha1.Instaslice{
+		Spec: inferencev1alpha1.InstasliceSpec{
+			MigGPUUUID: map[string]string{"GPU-1": "NVIDIA A100-SXM4-40GB", "GPU-2": "NVIDIA A100-SXM4-40GB", "GPU-3": "NVIDIA A100-SXM4-40GB"},
+			Migplacement: []inferencev1alpha1.Mig{
+				{Profile: "1g.5gb", Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 1}}},
+				{Profile: "1g.5gb+me", Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 1}}},
+				{Profile: "1g.10gb", Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 2}}},
+			},
+			Allocations: map[string]inferencev1alpha1.AllocationDetails{
+				"pod-uid-1": {PodUUID: "pod-uid-1", GPUUUID: "GPU-2", Profile: "1g.5gb+me", Allocationstatus: inferencev1alpha1.AllocationStatusCreated},
+			},
+		},
+		Status: inferencev1alpha1.InstasliceStatus{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{
+				"MIG-1": {Profile: "1g.5gb+me", Parent: "GPU-2", PodUUID: "pod-uid-1", Giinfoid: 1},
+				"MIG-2": {Profile: "1g.5gb", Parent: "GPU-1", PodUUID: "pod-uid-2", Giinfoid: 3},
+			},
+		},
+	}
+	discovered := &inferencev1alpha1.Instaslice{
+		Spec: inferencev1alpha1.InstasliceSpec{
+			MigGPUUUID: map[string]string{"GPU-1": "NVIDIA A100-SXM4-80GB"},
+			Migplacement: []inferencev1alpha1.Mig{
+				{Profile: "1g.5gb", Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 1}, {Start: 1, Size: 1}}},
+			},
+		},
+		Status: inferencev1alpha1.InstasliceStatus{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{
+				"MIG-2": {Profile: "1g.5gb", Parent: "GPU-1", Giinfoid: 5},
+				"MIG-3": {Profile: "1g.5gb", Parent: "GPU-1", Giinfoid: 6},
+			},
+		},
+	}
+
+	mergeDiscovered(existing, discovered)
+
+	// GPU-3 holds nothing and was not found again
+	assert.Equal(t, map[string]string{"GPU-1": "NVIDIA A100-SXM4-80GB", "GPU-2": "NVIDIA A100-SXM4-40GB"}, existing.Spec.MigGPUUUID)
+	require.Len(t, existing.Spec.Migplacement, 2)
+	assert.Len(t, existing.Spec.Migplacement[0].Placements, 2)
+	assert.Equal(t, "1g.5gb+me", existing.Spec.Migplacement[1].Profile)
+	assert.Len(t, existing.Spec.Allocations, 1)
+	assert.Equal(t, "pod-uid-1", existing.Status.Prepared["MIG-1"].PodUUID)
+	assert.Equal(t, "pod-uid-2", existing.Status.Prepared["MIG-2"].PodUUID)
+	assert.Equal(t, uint32(5), existing.Status.Prepared["MIG-2"].Giinfoid)
+	assert.Empty(t, existing.Status.Prepared["MIG-3"].PodUUID)
+}
+
+func TestDiscoveryCompletesWhenAnotherInstanceCreatesTheInstaslice(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-1")
+	nvmllib := newFakeGPUs(1)
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	raced, lagged, conflicted := false, false, false
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			// another instance creates the instaslice between the lookup and the create of discovery
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				if _, ok := obj.(*inferencev1alpha1.Instaslice); !ok || raced {
+					return c.Create(ctx, obj, opts...)
+				}
+				raced = true
+				other := &inferencev1alpha1.Instaslice{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"}}
+				other.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{
+					"pod-uid-0": {PodUUID: "pod-uid-0", Allocationstatus: inferencev1alpha1.AllocationStatusCreating, Profile: "1g.5gb"},
+				}
+				require.NoError(t, c.Create(ctx, other))
+				return apierrors.NewAlreadyExists(inferencev1alpha1.GroupVersion.WithResource("instaslices").GroupResource(), "node-1")
+			},
+			// the cache has not seen it yet
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if _, ok := obj.(*inferencev1alpha1.Instaslice); ok && raced && !lagged {
+					lagged = true
+					return apierrors.NewNotFound(inferencev1alpha1.GroupVersion.WithResource("instaslices").GroupResource(), key.Name)
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+			// and writes its status first
+			SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+				if _, ok := obj.(*inferencev1alpha1.Instaslice); ok && !conflicted {
+					conflicted = true
+					return apierrors.NewConflict(inferencev1alpha1.GroupVersion.WithResource("instaslices").GroupResource(), obj.GetName(), nil)
+				}
+				return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+			},
+		}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s, nvmlHandler: newDeviceHandler(nvmllib)}
+	ctx := context.Background()
+
+	_, err := reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+	assert.True(t, raced)
+	assert.True(t, lagged)
+	assert.True(t, conflicted)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, "true", instaslice.Status.Processed)
+	assert.Len(t, instaslice.Spec.MigGPUUUID, 1)
+	assert.NotEmpty(t, instaslice.Spec.Migplacement)
+	assert.Contains(t, instaslice.Spec.Allocations, "pod-uid-0")
+}
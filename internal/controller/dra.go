@@ -0,0 +1,198 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// DRADriverName is the driver the ResourceSlices published for the slices of the nodes belong to.
+const DRADriverName = "instaslice.codeflare.dev"
+
+// resourceSliceGVK is the ResourceSlice kind of the Dynamic Resource Allocation API. The vendored Kubernetes API
+// predates it, ResourceSlices are handled as unstructured objects.
+var resourceSliceGVK = schema.GroupVersionKind{Group: "resource.k8s.io", Version: "v1beta1", Kind: "ResourceSlice"}
+
+// profileMemory matches the memory size in a profile name, e.g. 5 for 1g.5gb.
+var profileMemory = regexp.MustCompile(`\.(\d+)gb`)
+
+// draNameInvalid matches the characters of a profile name not allowed in a device name.
+var draNameInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// draNameSegment turns a profile name into a part of a device name, e.g. 1g-5gb-me for 1g.5gb+me.
+func draNameSegment(profileName string) string {
+	return strings.Trim(draNameInvalid.ReplaceAllString(strings.ToLower(profileName), "-"), "-")
+}
+
+// resourceSliceName returns the name of the ResourceSlice publishing the slices of a GPU of the node.
+func resourceSliceName(nodeName string, gpuIndex int) string {
+	return fmt.Sprintf("%s-%s-gpu-%d", nodeName, DRADriverName, gpuIndex)
+}
+
+// draDevices returns the DRA devices of a GPU, one for every placement of every profile the GPU supports
+// and allows.
+// Devices of overlapping placements cannot be carved together, which the devices themselves do not express.
+func draDevices(instaslice *inferencev1alpha1.Instaslice, gpuIndex int, gpuUUID string) []interface{} {
+	var devices []interface{}
+	for _, mig := range instaslice.Spec.Migplacement {
+		if !gpuAllowsProfile(instaslice, gpuUUID, mig.Profile) {
+			continue
+		}
+		for _, placement := range mig.Placements {
+			basic := map[string]interface{}{
+				"attributes": map[string]interface{}{
+					"uuid":           map[string]interface{}{"string": gpuUUID},
+					"productName":    map[string]interface{}{"string": instaslice.Spec.MigGPUUUID[gpuUUID]},
+					"profile":        map[string]interface{}{"string": mig.Profile},
+					"placementStart": map[string]interface{}{"int": int64(placement.Start)},
+					"placementSize":  map[string]interface{}{"int": int64(placement.Size)},
+				},
+			}
+			if match := profileMemory.FindStringSubmatch(mig.Profile); match != nil {
+				basic["capacity"] = map[string]interface{}{
+					"memory": map[string]interface{}{"value": match[1] + "Gi"},
+				}
+			}
+			devices = append(devices, map[string]interface{}{
+				"name":  fmt.Sprintf("gpu-%d-mig-%s-%d", gpuIndex, draNameSegment(mig.Profile), placement.Start),
+				"basic": basic,
+			})
+		}
+	}
+	return devices
+}
+
+// desiredResourceSlices returns the ResourceSlices publishing the slices the GPUs of the node can carve, one per
+// GPU as a node quickly has more placements than a ResourceSlice holds devices. They form a pool named after the node.
+func desiredResourceSlices(instaslice *inferencev1alpha1.Instaslice, generation int64) []*unstructured.Unstructured {
+	gpuUUIDs := make([]string, 0, len(instaslice.Spec.MigGPUUUID))
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		gpuUUIDs = append(gpuUUIDs, gpuUUID)
+	}
+	sort.Strings(gpuUUIDs)
+	slices := make([]*unstructured.Unstructured, 0, len(gpuUUIDs))
+	for gpuIndex, gpuUUID := range gpuUUIDs {
+		slice := &unstructured.Unstructured{}
+		slice.SetGroupVersionKind(resourceSliceGVK)
+		slice.SetName(resourceSliceName(instaslice.Name, gpuIndex))
+		slice.SetLabels(map[string]string{CapabilitiesNodeLabel: instaslice.Name})
+		slice.Object["spec"] = map[string]interface{}{
+			"driver":   DRADriverName,
+			"nodeName": instaslice.Name,
+			"pool": map[string]interface{}{
+				"name":               instaslice.Name,
+				"generation":         generation,
+				"resourceSliceCount": int64(len(gpuUUIDs)),
+			},
+			"devices": draDevices(instaslice, gpuIndex, gpuUUID),
+		}
+		slices = append(slices, slice)
+	}
+	return slices
+}
+
+// publishResourceSlices creates or refreshes the ResourceSlices advertising the slices of the node as DRA devices.
+// The generation of the pool is bumped whenever its content changes, ResourceSlices of GPUs gone are deleted.
+func (r *InstaSliceDaemonsetReconciler) publishResourceSlices(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	if !r.PublishResourceSlices {
+		return nil
+	}
+	existing := &unstructured.UnstructuredList{}
+	existing.SetGroupVersionKind(resourceSliceGVK.GroupVersion().WithKind(resourceSliceGVK.Kind + "List"))
+	if err := r.List(ctx, existing, client.MatchingLabels{CapabilitiesNodeLabel: instaslice.Name}); err != nil {
+		if meta.IsNoMatchError(err) {
+			return fmt.Errorf("the cluster does not serve %s: %w", resourceSliceGVK, err)
+		}
+		return err
+	}
+	var generation int64
+	existingByName := make(map[string]*unstructured.Unstructured)
+	for i := range existing.Items {
+		item := &existing.Items[i]
+		existingByName[item.GetName()] = item
+		if itemGeneration, found, _ := unstructured.NestedInt64(item.Object, "spec", "pool", "generation"); found && itemGeneration > generation {
+			generation = itemGeneration
+		}
+	}
+	desired := desiredResourceSlices(instaslice, generation)
+	if len(desired) == len(existingByName) && resourceSlicesMatch(existingByName, desired) {
+		return nil
+	}
+	desired = desiredResourceSlices(instaslice, generation+1)
+
+	// the slices go with the node, the API server removes them along with it
+	var ownerReferences []interface{}
+	node := &v1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: instaslice.Name}, node); err == nil {
+		ownerReferences = []interface{}{map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Node",
+			"name":       node.Name,
+			"uid":        string(node.UID),
+		}}
+	}
+	for _, slice := range desired {
+		if ownerReferences != nil {
+			slice.Object["metadata"].(map[string]interface{})["ownerReferences"] = ownerReferences
+		}
+		current, exists := existingByName[slice.GetName()]
+		delete(existingByName, slice.GetName())
+		if !exists {
+			if err := r.Create(ctx, slice); err != nil {
+				return err
+			}
+			continue
+		}
+		current.Object["spec"] = slice.Object["spec"]
+		if err := r.Update(ctx, current); err != nil {
+			return err
+		}
+	}
+	for _, stale := range existingByName {
+		if err := r.Delete(ctx, stale); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	log.FromContext(ctx).Info("published ResourceSlices of the node", "slices", len(desired), "generation", generation+1)
+	return nil
+}
+
+// resourceSlicesMatch reports whether the existing ResourceSlices already have the desired content.
+func resourceSlicesMatch(existing map[string]*unstructured.Unstructured, desired []*unstructured.Unstructured) bool {
+	for _, slice := range desired {
+		current, exists := existing[slice.GetName()]
+		if !exists || !reflect.DeepEqual(current.Object["spec"], slice.Object["spec"]) {
+			return false
+		}
+	}
+	return true
+}
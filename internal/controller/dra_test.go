@@ -0,0 +1,168 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newDRATestClient returns a fake client serving ResourceSlices.
+func newDRATestClient(objects ...client.Object) client.Client {
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(resourceSliceGVK, meta.RESTScopeRoot)
+	restMapper.Add(v1.SchemeGroupVersion.WithKind("Node"), meta.RESTScopeRoot)
+	return runtimefake.NewClientBuilder().WithScheme(scheme.Scheme).WithRESTMapper(restMapper).WithObjects(objects...).Build()
+}
+
+// listResourceSlices returns the ResourceSlices of the node.
+func listResourceSlices(t *testing.T, c client.Client) []unstructured.Unstructured {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(resourceSliceGVK.GroupVersion().WithKind("ResourceSliceList"))
+	require.NoError(t, c.List(context.Background(), list, client.MatchingLabels{CapabilitiesNodeLabel: "node-1"}))
+	return list.Items
+}
+
+func newDRATestInstaslice() *inferencev1alpha1.Instaslice {
+	return &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"},
+		Spec: inferencev1alpha1.InstasliceSpec{
+			MigGPUUUID: map[string]string{"GPU-2": "NVIDIA A100-SXM4-40GB", "GPU-1": "NVIDIA A100-SXM4-40GB"},
+			Migplacement: []inferencev1alpha1.Mig{
+				{Profile: "1g.5gb+me", Placements: []inferencev1alpha1.Placement{{Size: 1, Start: 0}}},
+				{Profile: "3g.20gb", Placements: []inferencev1alpha1.Placement{{Size: 4, Start: 0}, {Size: 4, Start: 4}}},
+			},
+		},
+	}
+}
+
+func TestDesiredResourceSlicesFromDiscoveredProfiles(t *testing.T) {
+	slices := desiredResourceSlices(newDRATestInstaslice(), 3)
+
+	require.Len(t, slices, 2)
+	assert.Equal(t, "node-1-instaslice.codeflare.dev-gpu-0", slices[0].GetName())
+	assert.Equal(t, "node-1-instaslice.codeflare.dev-gpu-1", slices[1].GetName())
+	assert.Equal(t, map[string]string{CapabilitiesNodeLabel: "node-1"}, slices[0].GetLabels())
+	assert.Equal(t, map[string]interface{}{
+		"driver":   DRADriverName,
+		"nodeName": "node-1",
+		"pool": map[string]interface{}{
+			"name":               "node-1",
+			"generation":         int64(3),
+			"resourceSliceCount": int64(2),
+		},
+		"devices": []interface{}{
+			map[string]interface{}{
+				"name": "gpu-0-mig-1g-5gb-me-0",
+				"basic": map[string]interface{}{
+					"attributes": map[string]interface{}{
+						"uuid":           map[string]interface{}{"string": "GPU-1"},
+						"productName":    map[string]interface{}{"string": "NVIDIA A100-SXM4-40GB"},
+						"profile":        map[string]interface{}{"string": "1g.5gb+me"},
+						"placementStart": map[string]interface{}{"int": int64(0)},
+						"placementSize":  map[string]interface{}{"int": int64(1)},
+					},
+					"capacity": map[string]interface{}{"memory": map[string]interface{}{"value": "5Gi"}},
+				},
+			},
+			map[string]interface{}{
+				"name": "gpu-0-mig-3g-20gb-0",
+				"basic": map[string]interface{}{
+					"attributes": map[string]interface{}{
+						"uuid":           map[string]interface{}{"string": "GPU-1"},
+						"productName":    map[string]interface{}{"string": "NVIDIA A100-SXM4-40GB"},
+						"profile":        map[string]interface{}{"string": "3g.20gb"},
+						"placementStart": map[string]interface{}{"int": int64(0)},
+						"placementSize":  map[string]interface{}{"int": int64(4)},
+					},
+					"capacity": map[string]interface{}{"memory": map[string]interface{}{"value": "20Gi"}},
+				},
+			},
+			map[string]interface{}{
+				"name": "gpu-0-mig-3g-20gb-4",
+				"basic": map[string]interface{}{
+					"attributes": map[string]interface{}{
+						"uuid":           map[string]interface{}{"string": "GPU-1"},
+						"productName":    map[string]interface{}{"string": "NVIDIA A100-SXM4-40GB"},
+						"profile":        map[string]interface{}{"string": "3g.20gb"},
+						"placementStart": map[string]interface{}{"int": int64(4)},
+						"placementSize":  map[string]interface{}{"int": int64(4)},
+					},
+					"capacity": map[string]interface{}{"memory": map[string]interface{}{"value": "20Gi"}},
+				},
+			},
+		},
+	}, slices[0].Object["spec"])
+	devices, _, _ := unstructured.NestedSlice(slices[1].Object, "spec", "devices")
+	require.Len(t, devices, 3)
+	assert.Equal(t, "gpu-1-mig-1g-5gb-me-0", devices[0].(map[string]interface{})["name"])
+}
+
+func TestPublishResourceSlices(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", UID: "node-uid"}}
+	fakeClient := newDRATestClient(node)
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, PublishResourceSlices: true}
+	ctx := context.Background()
+	instaslice := newDRATestInstaslice()
+
+	require.NoError(t, reconciler.publishResourceSlices(ctx, instaslice))
+	slices := listResourceSlices(t, fakeClient)
+	require.Len(t, slices, 2)
+	for _, slice := range slices {
+		generation, _, _ := unstructured.NestedInt64(slice.Object, "spec", "pool", "generation")
+		assert.Equal(t, int64(1), generation)
+		require.Len(t, slice.GetOwnerReferences(), 1)
+		assert.Equal(t, "node-uid", string(slice.GetOwnerReferences()[0].UID))
+	}
+
+	// publishing the same content again leaves the pool alone
+	require.NoError(t, reconciler.publishResourceSlices(ctx, instaslice))
+	for _, slice := range listResourceSlices(t, fakeClient) {
+		generation, _, _ := unstructured.NestedInt64(slice.Object, "spec", "pool", "generation")
+		assert.Equal(t, int64(1), generation)
+	}
+
+	// a GPU gone takes its ResourceSlice along and bumps the generation of the pool
+	delete(instaslice.Spec.MigGPUUUID, "GPU-2")
+	require.NoError(t, reconciler.publishResourceSlices(ctx, instaslice))
+	slices = listResourceSlices(t, fakeClient)
+	require.Len(t, slices, 1)
+	generation, _, _ := unstructured.NestedInt64(slices[0].Object, "spec", "pool", "generation")
+	assert.Equal(t, int64(2), generation)
+	count, _, _ := unstructured.NestedInt64(slices[0].Object, "spec", "pool", "resourceSliceCount")
+	assert.Equal(t, int64(1), count)
+}
+
+func TestPublishResourceSlicesDisabled(t *testing.T) {
+	fakeClient := newDRATestClient()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient}
+
+	require.NoError(t, reconciler.publishResourceSlices(context.Background(), newDRATestInstaslice()))
+	assert.Empty(t, listResourceSlices(t, fakeClient))
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// ConditionDegraded is set on the instaslice when the daemonset no longer tracks every slice of the node.
+	ConditionDegraded = "Degraded"
+	// ReasonDuplicateMigUUID is the degraded reason used when two slices were reported with the same MIG UUID.
+	ReasonDuplicateMigUUID = "DuplicateMigUUID"
+)
+
+// preparedConflicts reports whether the Prepared map already holds a different slice under the MIG UUID.
+// The same slice handed over to another pod is not a conflict.
+func preparedConflicts(prepared map[string]inferencev1alpha1.PreparedDetails, migUUID string, candidate inferencev1alpha1.PreparedDetails) bool {
+	existing, exists := prepared[migUUID]
+	if !exists {
+		return false
+	}
+	return existing.Parent != candidate.Parent || existing.Giinfoid != candidate.Giinfoid || existing.Ciinfoid != candidate.Ciinfoid
+}
+
+// duplicateMigUUIDError returns the error logged when a slice is refused because its MIG UUID is already tracked.
+func duplicateMigUUIDError(migUUID string, existing, candidate inferencev1alpha1.PreparedDetails) error {
+	return fmt.Errorf("MIG UUID %s of gi %d ci %d on GPU %s is already tracked for gi %d ci %d on GPU %s, not overwriting it",
+		migUUID, candidate.Giinfoid, candidate.Ciinfoid, candidate.Parent, existing.Giinfoid, existing.Ciinfoid, existing.Parent)
+}
+
+// setDuplicateMigUUIDCondition marks the status degraded for the given duplicate MIG UUIDs, or clears the
+// condition when there are none.
+func setDuplicateMigUUIDCondition(status *inferencev1alpha1.InstasliceStatus, duplicates []string) {
+	if len(duplicates) == 0 {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ConditionDegraded,
+			Status:  metav1.ConditionFalse,
+			Reason:  "AllSlicesTracked",
+			Message: "every slice of the node is tracked",
+		})
+		return
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    ConditionDegraded,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonDuplicateMigUUID,
+		Message: "slices reported with an already tracked MIG UUID: " + strings.Join(duplicates, ", "),
+	})
+}
+
+// markDuplicateMigUUID records on the latest instaslice that a slice was refused because of a duplicate MIG UUID.
+func (r *InstaSliceDaemonsetReconciler) markDuplicateMigUUID(ctx context.Context, name string, duplicates []string) {
+	var instaslice inferencev1alpha1.Instaslice
+	typeNamespacedName := types.NamespacedName{
+		Name:      name,
+		Namespace: r.instasliceNamespace(),
+	}
+	if err := r.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+		log.FromContext(ctx).Error(err, "unable to get instaslice to mark it degraded")
+		return
+	}
+	setDuplicateMigUUIDCondition(&instaslice.Status, duplicates)
+	if err := r.Status().Update(ctx, &instaslice); err != nil {
+		log.FromContext(ctx).Error(err, "unable to mark instaslice degraded")
+	}
+}
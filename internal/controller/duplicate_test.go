@@ -0,0 +1,109 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// reportMigUUID makes every MIG device of the GPU report the given UUID.
+func reportMigUUID(device *dgxa100.Device, migUUID string) {
+	getMigDeviceHandleByIndex := device.GetMigDeviceHandleByIndexFunc
+	device.GetMigDeviceHandleByIndexFunc = func(index int) (nvml.Device, nvml.Return) {
+		mig, ret := getMigDeviceHandleByIndex(index)
+		if ret != nvml.SUCCESS {
+			return mig, ret
+		}
+		mig.(*mock.Device).GetUUIDFunc = func() (string, nvml.Return) {
+			return migUUID, nvml.SUCCESS
+		}
+		return mig, ret
+	}
+}
+
+func TestDiscoverDanglingSlicesReportsDuplicateMigUUID(t *testing.T) {
+	nvmllib := newFakeGPUs(2)
+	var gpuUUIDs []string
+	for i := 0; i < 2; i++ {
+		handle, ret := nvmllib.DeviceGetHandleByIndex(i)
+		require.Equal(t, nvml.SUCCESS, ret)
+		device := handle.(*dgxa100.Device)
+		gi, ret := createGpuInstance(device, nvml.GPU_INSTANCE_PROFILE_1_SLICE, nvml.GpuInstancePlacement{Start: 0, Size: 1})
+		require.Equal(t, nvml.SUCCESS, ret)
+		_, ret = createComputeInstance(gi, nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED)
+		require.Equal(t, nvml.SUCCESS, ret)
+		reportMigUUID(device, "MIG-duplicate")
+		gpuUUIDs = append(gpuUUIDs, device.UUID)
+	}
+	reconciler := &InstaSliceDaemonsetReconciler{nvmlHandler: newDeviceHandler(nvmllib)}
+
+	instaslice := &inferencev1alpha1.Instaslice{}
+	require.NoError(t, reconciler.discoverDanglingSlices(instaslice))
+
+	require.Len(t, instaslice.Status.Prepared, 1)
+	assert.Equal(t, gpuUUIDs[0], instaslice.Status.Prepared["MIG-duplicate"].Parent)
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionDegraded)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, ReasonDuplicateMigUUID, condition.Reason)
+	assert.Contains(t, condition.Message, "MIG-duplicate")
+}
+
+func TestCreatePreparedEntryRefusesDuplicateMigUUID(t *testing.T) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	existing := inferencev1alpha1.PreparedDetails{Profile: "1g.5gb", Parent: "GPU-1", PodUUID: "pod-uid-1", Giinfoid: 1, Ciinfoid: 0}
+	instaslice := &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"},
+		Spec: inferencev1alpha1.InstasliceSpec{
+			Allocations: map[string]inferencev1alpha1.AllocationDetails{
+				"pod-uid-2": {PodUUID: "pod-uid-2", PodName: "pod-2", GPUUUID: "GPU-1", Start: 1, Size: 1},
+			},
+		},
+		Status: inferencev1alpha1.InstasliceStatus{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{"MIG-1": existing},
+		},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(instaslice).WithStatusSubresource(instaslice).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s}
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	var latest inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &latest))
+	err := reconciler.createPreparedEntry(ctx, "1g.5gb", "pod-uid-2", "GPU-1", 2, 0, &latest, "MIG-1")
+	assert.Error(t, err)
+
+	var updated inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &updated))
+	assert.Equal(t, existing, updated.Status.Prepared["MIG-1"])
+	assert.True(t, meta.IsStatusConditionTrue(updated.Status.Conditions, ConditionDegraded))
+}
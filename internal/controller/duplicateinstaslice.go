@@ -0,0 +1,102 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// DuplicateOfAnnotation is set by the daemonset on an instaslice duplicating the one of its node kept in the
+	// namespace of the operator, to the namespace/name of the authoritative one.
+	DuplicateOfAnnotation = "instaslice.codeflare.dev/duplicate-of"
+	// EventReasonDuplicateInstaslice is the reason of the event emitted on an instaslice duplicating the one of its node.
+	EventReasonDuplicateInstaslice = "DuplicateInstaslice"
+)
+
+// instaslicePrecedes reports whether the instaslice takes precedence over the other of the same node name: the one
+// in the namespace of the operator first, then the oldest, then the one of the first namespace in lexical order.
+func instaslicePrecedes(instaslice, other *inferencev1alpha1.Instaslice, namespace string) bool {
+	if (instaslice.Namespace == namespace) != (other.Namespace == namespace) {
+		return instaslice.Namespace == namespace
+	}
+	if !instaslice.CreationTimestamp.Equal(&other.CreationTimestamp) {
+		return instaslice.CreationTimestamp.Before(&other.CreationTimestamp)
+	}
+	return instaslice.Namespace < other.Namespace
+}
+
+// authoritativeInstaslices splits the instaslices into the one acting for each node name, by instaslicePrecedes,
+// and the duplicates of those, keeping their order. namespace is the namespace of the operator.
+func authoritativeInstaslices(instaslices []inferencev1alpha1.Instaslice, namespace string) ([]inferencev1alpha1.Instaslice, []inferencev1alpha1.Instaslice) {
+	winners := make(map[string]int)
+	for i := range instaslices {
+		winner, seen := winners[instaslices[i].Name]
+		if !seen || instaslicePrecedes(&instaslices[i], &instaslices[winner], namespace) {
+			winners[instaslices[i].Name] = i
+		}
+	}
+	var authoritative, duplicates []inferencev1alpha1.Instaslice
+	for i, instaslice := range instaslices {
+		if winners[instaslice.Name] == i {
+			authoritative = append(authoritative, instaslice)
+		} else {
+			duplicates = append(duplicates, instaslice)
+		}
+	}
+	return authoritative, duplicates
+}
+
+// flagDuplicateInstaslices warns about the instaslices of the node name kept outside the namespace of the operator,
+// e.g. left behind by a namespace migration. The daemonset only ever acts on the one in its namespace, the others are
+// annotated with DuplicateOfAnnotation and reported in an event once, and the controller places no slices on them.
+func (r *InstaSliceDaemonsetReconciler) flagDuplicateInstaslices(ctx context.Context, nodeName string) error {
+	var instaslices inferencev1alpha1.InstasliceList
+	if err := r.List(ctx, &instaslices); err != nil {
+		return err
+	}
+	authoritative := r.instasliceNamespace() + "/" + nodeName
+	var duplicates []inferencev1alpha1.Instaslice
+	for _, instaslice := range instaslices.Items {
+		if instaslice.Name == nodeName && instaslice.Namespace != r.instasliceNamespace() && instaslice.Annotations[DuplicateOfAnnotation] != authoritative {
+			duplicates = append(duplicates, instaslice)
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].Namespace < duplicates[j].Namespace })
+	for i := range duplicates {
+		duplicate := &duplicates[i]
+		log.FromContext(ctx).Info("instaslice of the node found in another namespace, ignoring it", "namespace", duplicate.Namespace, "authoritative", authoritative)
+		if duplicate.Annotations == nil {
+			duplicate.Annotations = make(map[string]string)
+		}
+		duplicate.Annotations[DuplicateOfAnnotation] = authoritative
+		if err := r.Update(ctx, duplicate); err != nil {
+			return err
+		}
+		if r.Recorder != nil {
+			r.Recorder.Eventf(duplicate, v1.EventTypeWarning, EventReasonDuplicateInstaslice,
+				"Instaslice duplicates %s of the node, which takes precedence, it is ignored", authoritative)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestInstasliceOfOperatorNamespaceIsAuthoritative(t *testing.T) {
+	older := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	newer := metav1.NewTime(older.Add(time.Hour))
+	instaslices := []inferencev1alpha1.Instaslice{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "instaslice-system", CreationTimestamp: older}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: DefaultOperatorNamespace, CreationTimestamp: newer}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2", Namespace: "instaslice-system", CreationTimestamp: newer}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-3", Namespace: "team-b", CreationTimestamp: newer}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-3", Namespace: "team-a", CreationTimestamp: older}},
+	}
+
+	authoritative, duplicates := authoritativeInstaslices(instaslices, DefaultOperatorNamespace)
+	namespacedNames := func(instaslices []inferencev1alpha1.Instaslice) []string {
+		var names []string
+		for _, instaslice := range instaslices {
+			names = append(names, instaslice.Namespace+"/"+instaslice.Name)
+		}
+		return names
+	}
+	// the operator namespace wins however old the other one is, the oldest wins elsewhere
+	assert.Equal(t, []string{"default/node-1", "instaslice-system/node-2", "team-a/node-3"}, namespacedNames(authoritative))
+	assert.Equal(t, []string{"instaslice-system/node-1", "team-b/node-3"}, namespacedNames(duplicates))
+}
+
+func TestDaemonsetFlagsAndIgnoresDuplicateInstaslice(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	recorder := record.NewFakeRecorder(10)
+	reconciler.Recorder = recorder
+	ctx := context.Background()
+	// a copy of the instaslice of the node left behind in another namespace
+	duplicate := latestTestInstaslice(t, fakeClient).DeepCopy()
+	duplicate.ObjectMeta = metav1.ObjectMeta{Name: "node-1", Namespace: "instaslice-system"}
+	require.NoError(t, fakeClient.Create(ctx, duplicate))
+
+	for i := 0; i < 2; i++ {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, latestTestInstaslice(t, fakeClient).Spec.Allocations["pod-uid-0"].Allocationstatus)
+	var flagged inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "instaslice-system"}, &flagged))
+	assert.Equal(t, "default/node-1", flagged.Annotations[DuplicateOfAnnotation])
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, flagged.Spec.Allocations["pod-uid-0"].Allocationstatus, "the duplicate is not acted on")
+	assert.Empty(t, flagged.Status.Prepared)
+	close(recorder.Events)
+	reported := 0
+	for event := range recorder.Events {
+		if strings.Contains(event, EventReasonDuplicateInstaslice) {
+			reported++
+		}
+	}
+	assert.Equal(t, 1, reported, "the duplicate is reported once")
+}
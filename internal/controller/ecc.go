@@ -0,0 +1,77 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+const (
+	// ECC modes the daemonset can be told to expect on the GPUs of its node.
+	ECCModeEnabled  = "enabled"
+	ECCModeDisabled = "disabled"
+)
+
+// eccReservedMemoryDenominator is the share of device memory, 1/16, set aside for ECC check bits
+// when ECC is enabled on GPUs storing them inline.
+const eccReservedMemoryDenominator = 16
+
+// profileMemoryTotal returns the device memory the GB label of profiles is derived from and whether ECC is enabled.
+// With ECC enabled, GPUs storing check bits inline report the memory left once they are reserved, which would
+// shift the labels. The reservation is added back so that a profile gets the same name on nodes with and without ECC.
+// GPUs keeping check bits out of band, like HBM based ones, report the same total in both modes and are left as is.
+func profileMemoryTotal(device nvml.Device) (uint64, bool, error) {
+	memory, ret := device.GetMemoryInfo()
+	if ret != nvml.SUCCESS {
+		return 0, false, fmt.Errorf("unable to get GPU memory info: %v", ret)
+	}
+	current, _, ret := device.GetEccMode()
+	if ret == nvml.ERROR_NOT_SUPPORTED {
+		return memory.Total, false, nil
+	}
+	if ret != nvml.SUCCESS {
+		return 0, false, fmt.Errorf("unable to get GPU ECC mode: %v", ret)
+	}
+	if current != nvml.FEATURE_ENABLED {
+		return memory.Total, false, nil
+	}
+	return withoutECCReservation(memory.Total), true, nil
+}
+
+// withoutECCReservation returns the memory before check bits were reserved when total is exactly what is left
+// of a whole number of GiB once 1/16 is reserved, and total otherwise.
+func withoutECCReservation(total uint64) uint64 {
+	const oneGB = 1024 * 1024 * 1024
+	if total%(eccReservedMemoryDenominator-1) != 0 {
+		return total
+	}
+	restored := total / (eccReservedMemoryDenominator - 1) * eccReservedMemoryDenominator
+	if restored%oneGB != 0 {
+		return total
+	}
+	return restored
+}
+
+// eccModeName returns the ECC mode in the form used by the expected ECC mode setting.
+func eccModeName(eccEnabled bool) string {
+	if eccEnabled {
+		return ECCModeEnabled
+	}
+	return ECCModeDisabled
+}
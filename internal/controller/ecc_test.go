@@ -0,0 +1,78 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newECCTestDevice returns an A100-40GB reporting the given memory total and ECC mode.
+func newECCTestDevice(total uint64, ecc nvml.EnableState, ret nvml.Return) *dgxa100.Device {
+	device := dgxa100.New().Devices[0].(*dgxa100.Device)
+	device.MemoryInfo.Total = total
+	device.GetEccModeFunc = func() (nvml.EnableState, nvml.EnableState, nvml.Return) {
+		return ecc, ecc, ret
+	}
+	return device
+}
+
+// profileName labels a GI profile of the device the way discovery does.
+func profileName(t *testing.T, device *dgxa100.Device, giProfileID int) string {
+	memoryTotal, _, err := profileMemoryTotal(device)
+	require.NoError(t, err)
+	giProfileInfo, ret := device.GetGpuInstanceProfileInfo(giProfileID)
+	require.Equal(t, nvml.SUCCESS, ret)
+	return NewMigProfile(giProfileID, giProfileID, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED, giProfileInfo.SliceCount, giProfileInfo.SliceCount, giProfileInfo.MemorySizeMB, memoryTotal).String()
+}
+
+func TestProfileMemoryTotalRestoresECCReservation(t *testing.T) {
+	const fullMemory = 40 * 1024 * 1024 * 1024
+	device := newECCTestDevice(fullMemory/16*15, nvml.FEATURE_ENABLED, nvml.SUCCESS)
+
+	memoryTotal, eccEnabled, err := profileMemoryTotal(device)
+	assert.NoError(t, err)
+	assert.True(t, eccEnabled)
+	assert.Equal(t, uint64(fullMemory), memoryTotal)
+	assert.Equal(t, "1g.5gb", profileName(t, device, nvml.GPU_INSTANCE_PROFILE_1_SLICE))
+	assert.Equal(t, "3g.20gb", profileName(t, device, nvml.GPU_INSTANCE_PROFILE_3_SLICE))
+}
+
+func TestProfileMemoryTotalKeepsMemoryWithoutECCReservation(t *testing.T) {
+	const fullMemory = 40 * 1024 * 1024 * 1024
+	for _, device := range []*dgxa100.Device{
+		newECCTestDevice(fullMemory, nvml.FEATURE_ENABLED, nvml.SUCCESS),
+		newECCTestDevice(fullMemory, nvml.FEATURE_DISABLED, nvml.SUCCESS),
+		newECCTestDevice(fullMemory, nvml.FEATURE_DISABLED, nvml.ERROR_NOT_SUPPORTED),
+	} {
+		memoryTotal, _, err := profileMemoryTotal(device)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(fullMemory), memoryTotal)
+		assert.Equal(t, "1g.5gb", profileName(t, device, nvml.GPU_INSTANCE_PROFILE_1_SLICE))
+	}
+}
+
+func TestProfileMemoryTotalFailsWhenECCModeIsUnknown(t *testing.T) {
+	device := newECCTestDevice(40*1024*1024*1024, nvml.FEATURE_DISABLED, nvml.ERROR_UNKNOWN)
+
+	_, _, err := profileMemoryTotal(device)
+	assert.Error(t, err)
+}
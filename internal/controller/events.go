@@ -0,0 +1,110 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"os"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// DefaultAllocationCreator is the creator recorded on the allocations of the instaslice controller.
+	DefaultAllocationCreator = "instaslice-controller"
+	// EventReasonSliceCreated is the reason of the event emitted on a pod once its slice is carved.
+	EventReasonSliceCreated = "SliceCreated"
+	// EventReasonSliceDeleted is the reason of the event emitted on a pod once its slice is destroyed.
+	EventReasonSliceDeleted = "SliceDeleted"
+	// EventReasonGpuInstanceCreateFailed is the reason of the event emitted on a pod when NVML fails to create the
+	// GPU instance of its slice.
+	EventReasonGpuInstanceCreateFailed = "GpuInstanceCreateFailed"
+	// EventReasonComputeInstanceCreateFailed is the reason of the event emitted on a pod when NVML fails to create
+	// a compute instance of its slice.
+	EventReasonComputeInstanceCreateFailed = "ComputeInstanceCreateFailed"
+	// unknownAllocationCreator attributes allocations written without a creator.
+	unknownAllocationCreator = "unknown"
+)
+
+// allocationCreator returns the identity the controller records on the allocations it writes.
+func (r *InstasliceReconciler) allocationCreator() string {
+	if r.Identity == "" {
+		return DefaultAllocationCreator
+	}
+	return r.Identity
+}
+
+// creatorOf returns who wrote the allocation.
+func creatorOf(allocation inferencev1alpha1.AllocationDetails) string {
+	if allocation.Creator == "" {
+		return unknownAllocationCreator
+	}
+	return allocation.Creator
+}
+
+// recordSliceCreated attributes the slice carved for the allocation to whoever wrote the allocation, in the
+// logs and, when a recorder is set, in an event on the pod and the instaslice.
+func (r *InstaSliceDaemonsetReconciler) recordSliceCreated(ctx context.Context, allocation inferencev1alpha1.AllocationDetails, migUUIDs string) {
+	creator := creatorOf(allocation)
+	log.FromContext(ctx).Info("slice created", "pod", allocation.PodName, "namespace", allocation.Namespace,
+		"profile", allocation.Profile, "gpu", allocation.GPUUUID, "migUUID", migUUIDs, "creator", creator)
+	r.recordSliceEvent(allocation, v1.EventTypeNormal, EventReasonSliceCreated,
+		"Created %s slice %s on GPU %s for the allocation written by %s", allocation.Profile, migUUIDs, allocation.GPUUUID, creator)
+}
+
+// recordSliceDeleted reports, when a recorder is set, the slice of the allocation destroyed in an event on the pod
+// and the instaslice.
+func (r *InstaSliceDaemonsetReconciler) recordSliceDeleted(allocation inferencev1alpha1.AllocationDetails, migUUIDs string) {
+	r.recordSliceEvent(allocation, v1.EventTypeNormal, EventReasonSliceDeleted,
+		"Deleted %s slice %s on GPU %s", allocation.Profile, migUUIDs, allocation.GPUUUID)
+}
+
+// recordInstanceCreateFailed reports, when a recorder is set, the NVML error that failed a step of carving the
+// slice of the allocation in an event on the pod and the instaslice.
+func (r *InstaSliceDaemonsetReconciler) recordInstanceCreateFailed(allocation inferencev1alpha1.AllocationDetails, reason string, ret nvml.Return) {
+	r.recordSliceEvent(allocation, v1.EventTypeWarning, reason,
+		"Unable to create %s slice on GPU %s: %s", allocation.Profile, allocation.GPUUUID, ret.Error())
+}
+
+// recordSliceEvent emits the event on the pod of the allocation, so that describing the pod shows it, and on the
+// instaslice of the node naming the pod. Events are emitted asynchronously, they never hold up a reconcile.
+func (r *InstaSliceDaemonsetReconciler) recordSliceEvent(allocation inferencev1alpha1.AllocationDetails, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	pod := &v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Name:       allocation.PodName,
+		Namespace:  allocation.Namespace,
+		UID:        types.UID(allocation.PodUUID),
+	}
+	r.Recorder.Eventf(pod, eventType, reason, messageFmt, args...)
+	instaslice := &v1.ObjectReference{
+		Kind:       "Instaslice",
+		APIVersion: inferencev1alpha1.GroupVersion.String(),
+		Name:       os.Getenv("NODE_NAME"),
+		Namespace:  r.instasliceNamespace(),
+	}
+	r.Recorder.Eventf(instaslice, eventType, reason, "Pod %s/%s: "+messageFmt,
+		append([]interface{}{allocation.Namespace, allocation.PodName}, args...)...)
+}
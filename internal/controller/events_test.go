@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestSliceCreatedEventNamesTheCreator(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	recorder := record.NewFakeRecorder(10)
+	reconciler.Recorder = recorder
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Creator = "team-a-scheduler"
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.Len(t, recorder.Events, 2)
+	event := <-recorder.Events
+	assert.Contains(t, event, "Normal "+EventReasonSliceCreated)
+	assert.Contains(t, event, "team-a-scheduler")
+	// the instaslice of the node gets the same event naming the pod
+	event = <-recorder.Events
+	assert.Contains(t, event, "Normal "+EventReasonSliceCreated)
+	assert.Contains(t, event, "Pod default/pod-0")
+}
+
+func TestFailedGpuInstanceCreationIsReportedWithTheNVMLError(t *testing.T) {
+	reconciler, _, device, _ := newFakeGPUTestReconciler(t, 0)
+	recorder := record.NewFakeRecorder(10)
+	reconciler.Recorder = recorder
+	device.CreateGpuInstanceWithPlacementFunc = func(*nvml.GpuInstanceProfileInfo, *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		return nil, nvml.ERROR_UNKNOWN
+	}
+
+	_, err := reconciler.Reconcile(context.Background(), ctrl.Request{})
+	require.NoError(t, err)
+	require.Len(t, recorder.Events, 2)
+	for _, prefix := range []string{"", "Pod default/pod-0: "} {
+		event := <-recorder.Events
+		assert.Contains(t, event, "Warning "+EventReasonGpuInstanceCreateFailed+" "+prefix)
+		assert.Contains(t, event, nvml.ERROR_UNKNOWN.Error())
+	}
+}
+
+func TestSliceDeletedEventIsRecorded(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	migUUIDs := instaslice.Status.MigUUIDs["pod-uid-0"]
+	require.Len(t, migUUIDs, 1)
+
+	recorder := record.NewFakeRecorder(10)
+	reconciler.Recorder = recorder
+	require.NoError(t, reconciler.cleanUp(ctx, "pod-uid-0"))
+	require.Len(t, recorder.Events, 2)
+	event := <-recorder.Events
+	assert.Contains(t, event, "Normal "+EventReasonSliceDeleted)
+	assert.Contains(t, event, migUUIDs[0])
+	assert.Contains(t, <-recorder.Events, "Normal "+EventReasonSliceDeleted+" Pod default/pod-0")
+}
+
+func TestAllocationsRecordControllerIdentity(t *testing.T) {
+	r := &InstasliceReconciler{}
+	allocation, err := r.findDeviceForASlice(newPlacementTestInstaslice(), "1g.5gb", &FirstFitPolicy{}, newPreferredTestPod(""))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultAllocationCreator, allocation.Creator)
+
+	r.Identity = "team-b-controller"
+	allocation, err = r.findDeviceForASlice(newPlacementTestInstaslice(), "1g.5gb", &FirstFitPolicy{}, newPreferredTestPod(""))
+	require.NoError(t, err)
+	assert.Equal(t, "team-b-controller", allocation.Creator)
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+)
+
+// FakeGPUEnv holds the number of simulated A100-40GB GPUs the daemonset uses instead of NVML,
+// letting the controllers run on machines without NVIDIA hardware (CI, kind, envtest).
+// It can be sourced from a ConfigMap through the daemonset env.
+const FakeGPUEnv = "INSTASLICE_FAKE_GPU"
+
+// newNvmlLib returns the real NVML library, loaded from the first of the library paths found, or the built-in
+// fake when FakeGPUEnv is set. The default paths are searched when none are given.
+func newNvmlLib(libraryPaths []string) (nvml.Interface, error) {
+	value := os.Getenv(FakeGPUEnv)
+	if value == "" {
+		if len(libraryPaths) == 0 {
+			libraryPaths = defaultNvmlLibraryPaths
+		}
+		return nvml.New(nvml.WithLibraryPath(findNvmlLibrary(libraryPaths))), nil
+	}
+	maxGPUs := len(dgxa100.Server{}.Devices)
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 || count > maxGPUs {
+		return nil, fmt.Errorf("%s must be a number of GPUs between 1 and %d, got %q", FakeGPUEnv, maxGPUs, value)
+	}
+	return newFakeGPUs(count), nil
+}
+
+// newFakeGPUs simulates a node with count MIG enabled A100-40GB GPUs. GPU and compute instances carved
+// on it are tracked in memory, so slices can be created, discovered as MIG devices and destroyed again.
+func newFakeGPUs(count int) nvml.Interface {
+	server := dgxa100.New()
+	getHandleByIndex := server.DeviceGetHandleByIndexFunc
+	server.DeviceGetCountFunc = func() (int, nvml.Return) {
+		return count, nvml.SUCCESS
+	}
+	server.DeviceGetHandleByIndexFunc = func(index int) (nvml.Device, nvml.Return) {
+		if index >= count {
+			return nil, nvml.ERROR_INVALID_ARGUMENT
+		}
+		return getHandleByIndex(index)
+	}
+	for _, device := range server.Devices[:count] {
+		setFakeDeviceFuncs(device.(*dgxa100.Device))
+	}
+	getHandleByUUID := server.DeviceGetHandleByUUIDFunc
+	server.DeviceGetHandleByUUIDFunc = func(uuid string) (nvml.Device, nvml.Return) {
+		// like the driver, MIG devices are found by their UUID too
+		for _, device := range server.Devices[:count] {
+			for _, mig := range fakeMigDevices(device.(*dgxa100.Device)) {
+				if migUUID, _ := mig.GetUUID(); migUUID == uuid {
+					return mig, nvml.SUCCESS
+				}
+			}
+		}
+		return getHandleByUUID(uuid)
+	}
+	return server
+}
+
+// setFakeDeviceFuncs fills in the MIG calls the dgxa100 mock leaves unimplemented.
+func setFakeDeviceFuncs(device *dgxa100.Device) {
+	device.MigMode = nvml.DEVICE_MIG_ENABLE
+	// like A100s, ECC is on and does not reduce the reported memory
+	device.GetEccModeFunc = func() (nvml.EnableState, nvml.EnableState, nvml.Return) {
+		return nvml.FEATURE_ENABLED, nvml.FEATURE_ENABLED, nvml.SUCCESS
+	}
+	// like PCIe cards, the GPUs are not linked by NVLink
+	device.GetNvLinkStateFunc = func(link int) (nvml.EnableState, nvml.Return) {
+		return nvml.FEATURE_DISABLED, nvml.ERROR_NOT_SUPPORTED
+	}
+	// like healthy A100s, no row of memory waits to be remapped
+	device.GetRemappedRowsFunc = func() (int, int, bool, bool, nvml.Return) {
+		return 0, 0, false, false, nvml.SUCCESS
+	}
+
+	createGpuInstance := device.CreateGpuInstanceWithPlacementFunc
+	device.CreateGpuInstanceWithPlacementFunc = func(info *nvml.GpuInstanceProfileInfo, placement *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		// like the driver, refuse to carve over memory slices already in use
+		device.RLock()
+		for gi := range device.GpuInstances {
+			existing := gi.Info.Placement
+			if placement.Start < existing.Start+existing.Size && existing.Start < placement.Start+placement.Size {
+				device.RUnlock()
+				return nil, nvml.ERROR_INSUFFICIENT_RESOURCES
+			}
+		}
+		device.RUnlock()
+		gi, ret := createGpuInstance(info, placement)
+		if ret == nvml.SUCCESS {
+			setFakeGpuInstanceFuncs(gi.(*dgxa100.GpuInstance))
+		}
+		return gi, ret
+	}
+	device.GetGpuInstanceByIdFunc = func(id int) (nvml.GpuInstance, nvml.Return) {
+		device.RLock()
+		defer device.RUnlock()
+		for gi := range device.GpuInstances {
+			if int(gi.Info.Id) == id {
+				return gi, nvml.SUCCESS
+			}
+		}
+		return nil, nvml.ERROR_NOT_FOUND
+	}
+	device.IsMigDeviceHandleFunc = func() (bool, nvml.Return) {
+		return false, nvml.SUCCESS
+	}
+	device.GetMaxMigDeviceCountFunc = func() (int, nvml.Return) {
+		return len(dgxa100.MIGPlacements.GpuInstancePossiblePlacements[nvml.GPU_INSTANCE_PROFILE_1_SLICE]), nvml.SUCCESS
+	}
+	device.GetMigDeviceHandleByIndexFunc = func(index int) (nvml.Device, nvml.Return) {
+		migs := fakeMigDevices(device)
+		if index >= len(migs) {
+			return nil, nvml.ERROR_NOT_FOUND
+		}
+		return migs[index], nvml.SUCCESS
+	}
+}
+
+func setFakeGpuInstanceFuncs(gi *dgxa100.GpuInstance) {
+	gi.GetComputeInstanceByIdFunc = func(id int) (nvml.ComputeInstance, nvml.Return) {
+		gi.RLock()
+		defer gi.RUnlock()
+		for ci := range gi.ComputeInstances {
+			if int(ci.Info.Id) == id {
+				return ci, nvml.SUCCESS
+			}
+		}
+		return nil, nvml.ERROR_NOT_FOUND
+	}
+}
+
+// fakeMigDevices returns a MIG device handle for every compute instance on the GPU, ordered by GI and CI id.
+func fakeMigDevices(device *dgxa100.Device) []nvml.Device {
+	type migIDs struct {
+		gi        uint32
+		ci        uint32
+		profileID uint32
+	}
+	var ids []migIDs
+	device.RLock()
+	for gi := range device.GpuInstances {
+		gi.RLock()
+		for ci := range gi.ComputeInstances {
+			ids = append(ids, migIDs{gi: gi.Info.Id, ci: ci.Info.Id, profileID: gi.Info.ProfileId})
+		}
+		gi.RUnlock()
+	}
+	device.RUnlock()
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].gi != ids[j].gi {
+			return ids[i].gi < ids[j].gi
+		}
+		return ids[i].ci < ids[j].ci
+	})
+
+	migs := make([]nvml.Device, 0, len(ids))
+	for _, id := range ids {
+		id := id
+		giProfileInfo := dgxa100.MIGProfiles.GpuInstanceProfiles[int(id.profileID)]
+		// GI ids are never reused by the fake, which keeps the MIG UUIDs unique
+		migUUID := fmt.Sprintf("MIG-%s-%d-%d", strings.TrimPrefix(device.UUID, "GPU-"), id.gi, id.ci)
+		migs = append(migs, &mock.Device{
+			IsMigDeviceHandleFunc: func() (bool, nvml.Return) {
+				return true, nvml.SUCCESS
+			},
+			GetUUIDFunc: func() (string, nvml.Return) {
+				return migUUID, nvml.SUCCESS
+			},
+			GetDeviceHandleFromMigDeviceHandleFunc: func() (nvml.Device, nvml.Return) {
+				return device, nvml.SUCCESS
+			},
+			GetGpuInstanceIdFunc: func() (int, nvml.Return) {
+				return int(id.gi), nvml.SUCCESS
+			},
+			GetComputeInstanceIdFunc: func() (int, nvml.Return) {
+				return int(id.ci), nvml.SUCCESS
+			},
+			// slices of the fake run nothing
+			GetUtilizationRatesFunc: func() (nvml.Utilization, nvml.Return) {
+				return nvml.Utilization{}, nvml.SUCCESS
+			},
+			GetMemoryInfoFunc: func() (nvml.Memory, nvml.Return) {
+				total := giProfileInfo.MemorySizeMB * 1024 * 1024
+				return nvml.Memory{Total: total, Free: total}, nvml.SUCCESS
+			},
+			// like on A100s, the power draw is only known for the whole GPU
+			GetPowerUsageFunc: func() (uint32, nvml.Return) {
+				return 0, nvml.ERROR_NOT_SUPPORTED
+			},
+			GetAttributesFunc: func() (nvml.DeviceAttributes, nvml.Return) {
+				return nvml.DeviceAttributes{
+					MultiprocessorCount:   giProfileInfo.MultiprocessorCount,
+					GpuInstanceSliceCount: giProfileInfo.SliceCount,
+					MemorySizeMB:          giProfileInfo.MemorySizeMB,
+				}, nvml.SUCCESS
+			},
+		})
+	}
+	return migs
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestNewNvmlLibRejectsInvalidFakeGPUCount(t *testing.T) {
+	t.Setenv(FakeGPUEnv, "9")
+	_, err := newNvmlLib(nil)
+	assert.Error(t, err)
+
+	t.Setenv(FakeGPUEnv, "two")
+	_, err = newNvmlLib(nil)
+	assert.Error(t, err)
+}
+
+func TestFakeGPUModeCreateAndDeleteSlice(t *testing.T) {
+	t.Setenv(FakeGPUEnv, "2")
+	t.Setenv("NODE_NAME", "node-1")
+	nvmllib, err := newNvmlLib(nil)
+	require.NoError(t, err)
+	count, ret := nvmllib.DeviceGetCount()
+	require.Equal(t, nvml.SUCCESS, ret)
+	assert.Equal(t, 2, count)
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(node).WithStatusSubresource(&inferencev1alpha1.Instaslice{}, &v1.Node{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:      fakeClient,
+		Scheme:      s,
+		nvmlHandler: newDeviceHandler(nvmllib),
+	}
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	// discover
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, "true", instaslice.Status.Processed)
+	assert.Len(t, instaslice.Spec.MigGPUUUID, 2)
+	assert.NotEmpty(t, instaslice.Spec.Migplacement)
+	assert.Empty(t, instaslice.Status.Prepared)
+
+	// creating -> created
+	device, ret := nvmllib.DeviceGetHandleByIndex(1)
+	require.Equal(t, nvml.SUCCESS, ret)
+	gpuUUID, _ := device.GetUUID()
+	instaslice.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{
+		"pod-uid-fake": {
+			PodUUID:          "pod-uid-fake",
+			PodName:          "pod-fake",
+			Namespace:        "default",
+			GPUUUID:          gpuUUID,
+			Nodename:         "node-1",
+			Profile:          "1g.5gb",
+			Start:            2,
+			Size:             1,
+			Giprofileid:      nvml.GPU_INSTANCE_PROFILE_1_SLICE,
+			CIProfileID:      nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+			CIEngProfileID:   nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED,
+			Allocationstatus: inferencev1alpha1.AllocationStatusCreating,
+		},
+	}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-fake"].Allocationstatus)
+	require.Len(t, instaslice.Status.Prepared, 1)
+	var migUUID string
+	for uuid, prepared := range instaslice.Status.Prepared {
+		migUUID = uuid
+		assert.Equal(t, "pod-uid-fake", prepared.PodUUID)
+		assert.Equal(t, gpuUUID, prepared.Parent)
+		assert.Equal(t, uint32(2), prepared.Start)
+	}
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-fake", Namespace: "default"}, &configMap))
+	assert.Equal(t, migUUID, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	assert.Len(t, device.(*dgxa100.Device).GpuInstances, 1)
+
+	// the fake reports the slice back as a MIG device
+	migUUIDs, ret := migUUIDsOnNode(nvmllib)
+	require.Equal(t, nvml.SUCCESS, ret)
+	assert.Contains(t, migUUIDs, migUUID)
+
+	// deleting -> removed
+	allocation := instaslice.Spec.Allocations["pod-uid-fake"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+	instaslice.Spec.Allocations["pod-uid-fake"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Empty(t, instaslice.Spec.Allocations)
+	assert.Empty(t, instaslice.Status.Prepared)
+	assert.Empty(t, device.(*dgxa100.Device).GpuInstances)
+	err = fakeClient.Get(ctx, types.NamespacedName{Name: "pod-fake", Namespace: "default"}, &configMap)
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestFakeGPUSliceLifecycleByProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		profile     string
+		start       uint32
+		size        uint32
+		giProfileID int32
+		ciProfileID int32
+	}{
+		{"1g.5gb", "1g.5gb", 3, 1, nvml.GPU_INSTANCE_PROFILE_1_SLICE, nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE},
+		{"2g.10gb", "2g.10gb", 2, 2, nvml.GPU_INSTANCE_PROFILE_2_SLICE, nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE},
+		{"3g.20gb", "3g.20gb", 4, 4, nvml.GPU_INSTANCE_PROFILE_3_SLICE, nvml.COMPUTE_INSTANCE_PROFILE_3_SLICE},
+		{"7g.40gb", "7g.40gb", 0, 8, nvml.GPU_INSTANCE_PROFILE_7_SLICE, nvml.COMPUTE_INSTANCE_PROFILE_7_SLICE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t)
+			ctx := context.Background()
+			nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+			var instaslice inferencev1alpha1.Instaslice
+			require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+			instaslice.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{}
+			instaslice.Spec.Allocations["pod-uid-0"] = inferencev1alpha1.AllocationDetails{
+				PodUUID:          "pod-uid-0",
+				PodName:          "pod-0",
+				Namespace:        "default",
+				GPUUUID:          device.UUID,
+				Nodename:         "node-1",
+				Profile:          tt.profile,
+				Start:            tt.start,
+				Size:             tt.size,
+				Giprofileid:      int(tt.giProfileID),
+				CIProfileID:      int(tt.ciProfileID),
+				CIEngProfileID:   nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED,
+				Allocationstatus: inferencev1alpha1.AllocationStatusCreating,
+			}
+			require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+			// creating -> created
+			_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+			require.NoError(t, err)
+			require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+			assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+			require.Len(t, instaslice.Status.Prepared, 1)
+			for _, prepared := range instaslice.Status.Prepared {
+				assert.Equal(t, tt.start, prepared.Start)
+				assert.Equal(t, tt.size, prepared.Size)
+			}
+
+			// discovery probes placements with its own calls, the slice is the last one
+			giCalls := device.CreateGpuInstanceWithPlacementCalls()
+			require.NotEmpty(t, giCalls)
+			giCall := giCalls[len(giCalls)-1]
+			assert.Equal(t, uint32(tt.giProfileID), giCall.GpuInstanceProfileInfo.Id)
+			assert.Equal(t, tt.start, giCall.GpuInstancePlacement.Start)
+			assert.Equal(t, tt.size, giCall.GpuInstancePlacement.Size)
+			require.Len(t, device.GpuInstances, 1)
+			for gi := range device.GpuInstances {
+				ciCalls := gi.CreateComputeInstanceCalls()
+				require.Len(t, ciCalls, 1)
+				assert.Equal(t, uint32(tt.ciProfileID), ciCalls[0].ComputeInstanceProfileInfo.Id)
+			}
+
+			// deleting -> deleted, the slice is destroyed
+			allocation := instaslice.Spec.Allocations["pod-uid-0"]
+			allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+			instaslice.Spec.Allocations["pod-uid-0"] = allocation
+			require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+			_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+			require.NoError(t, err)
+			require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+			assert.Empty(t, instaslice.Status.Prepared)
+			assert.Empty(t, device.GpuInstances)
+
+			// deleted -> removed
+			if allocation, exists := instaslice.Spec.Allocations["pod-uid-0"]; exists {
+				assert.Equal(t, inferencev1alpha1.AllocationStatusDeleted, allocation.Allocationstatus)
+				_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+				require.NoError(t, err)
+				require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+			}
+			assert.Empty(t, instaslice.Spec.Allocations)
+		})
+	}
+}
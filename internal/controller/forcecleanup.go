@@ -0,0 +1,139 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// ForceCleanupAnnotation set on an instaslice to a pod UUID makes the daemonset remove the allocation of the
+	// pod and tear down its slices whatever state they are stuck in.
+	ForceCleanupAnnotation = "instaslice.codeflare.dev/force-cleanup"
+	// EventReasonForceCleanup is the reason of the event emitted on the instaslice once an allocation is force-cleaned.
+	EventReasonForceCleanup = "ForceCleanup"
+)
+
+// forceDestroySlices destroys the slices of the prepared entries of the pod, going on past failures which are
+// returned as messages.
+func (r *InstaSliceDaemonsetReconciler) forceDestroySlices(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, podUUID string) []string {
+	nvmllib := r.handler().nvml
+	computeInstances := make(map[gpuInstanceRef][]int)
+	for _, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID == podUUID {
+			key := gpuInstanceRef{parent: prepared.Parent, gi: prepared.Giinfoid}
+			computeInstances[key] = append(computeInstances[key], int(prepared.Ciinfoid))
+		}
+	}
+	var failures []string
+	for _, teardown := range orderSliceTeardowns(computeInstances) {
+		device, ret := nvmllib.DeviceGetHandleByUUID(teardown.parent)
+		if ret != nvml.SUCCESS {
+			failures = append(failures, fmt.Sprintf("unable to get GPU %s: %v", teardown.parent, ret))
+			continue
+		}
+		// a slice already gone is what a forced cleanup is after
+		ret = destroySlice(device, int(teardown.gi), teardown.cis...)
+		countSliceDeletion(ret)
+		if ret != nvml.SUCCESS {
+			log.FromContext(ctx).Error(ret, "unable to destroy slice during forced cleanup", "gi", teardown.gi, "gpu", teardown.parent)
+			failures = append(failures, fmt.Sprintf("unable to destroy gi %d on GPU %s: %v", teardown.gi, teardown.parent, ret))
+		}
+	}
+	sort.Strings(failures)
+	return failures
+}
+
+// forceCleanUp removes the allocation and prepared entries of the pod from the instaslice after a best-effort
+// teardown of its slices, ConfigMap and node capacity, skipping the guards of a normal deletion. It is meant to
+// recover from allocations stuck in any state. What was removed is recorded in the instaslice status.
+func (r *InstaSliceDaemonsetReconciler) forceCleanUp(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, podUUID string) error {
+	record := inferencev1alpha1.ForceCleanup{PodUUID: podUUID, CleanedAt: now()}
+	var forced inferencev1alpha1.AllocationDetails
+	for key, allocation := range instaslice.Spec.Allocations {
+		if key == podUUID || allocation.PodUUID == podUUID {
+			record.PodName = allocation.PodName
+			record.AllocationStatus = string(allocation.Allocationstatus)
+			forced = allocation
+		}
+	}
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID == podUUID {
+			record.MigUUIDs = append(record.MigUUIDs, migUUID)
+		}
+	}
+	sort.Strings(record.MigUUIDs)
+
+	record.Errors = r.forceDestroySlices(ctx, instaslice, podUUID)
+	if record.PodName != "" {
+		if err := r.removeConfigMapFinalizer(ctx, record.PodName, forced.Namespace, forced.PodUUID); err != nil {
+			record.Errors = append(record.Errors, fmt.Sprintf("unable to remove the finalizer of ConfigMap %s: %v", record.PodName, err))
+		}
+		if err := r.deleteConfigMap(ctx, record.PodName, forced.Namespace, forced.PodUUID); err != nil {
+			record.Errors = append(record.Errors, fmt.Sprintf("unable to delete ConfigMap %s: %v", record.PodName, err))
+		}
+		if err := r.cleanUpInstaSliceResource(ctx, forced); err != nil {
+			record.Errors = append(record.Errors, fmt.Sprintf("unable to remove the instaslice resource of pod %s: %v", record.PodName, err))
+		}
+		if err := r.updateNodeCapacity(ctx, os.Getenv("NODE_NAME")); err != nil {
+			record.Errors = append(record.Errors, fmt.Sprintf("unable to reload the node capacity: %v", err))
+		}
+	}
+
+	updated, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+		for key, allocation := range latest.Spec.Allocations {
+			if key == podUUID || allocation.PodUUID == podUUID {
+				delete(latest.Spec.Allocations, key)
+			}
+		}
+		for migUUID, prepared := range latest.Status.Prepared {
+			if prepared.PodUUID == podUUID {
+				delete(latest.Status.Prepared, migUUID)
+			}
+		}
+		// the cleanup is done once, annotate the instaslice again to repeat it
+		delete(latest.Annotations, ForceCleanupAnnotation)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.slices.forget(record.PodName)
+	for _, migUUID := range record.MigUUIDs {
+		r.slices.release(migUUID)
+	}
+	r.clearSliceInUseRetries(podUUID)
+
+	log.FromContext(ctx).Info("forcibly cleaned up allocation", "podUUID", podUUID, "pod", record.PodName,
+		"status", record.AllocationStatus, "migUUIDs", record.MigUUIDs, "errors", record.Errors)
+	if r.Recorder != nil {
+		r.Recorder.Eventf(updated, v1.EventTypeWarning, EventReasonForceCleanup,
+			"Forcibly removed the allocation of pod UUID %s and %d prepared entries, %d teardown errors", podUUID, len(record.MigUUIDs), len(record.Errors))
+	}
+	updated.Status.LastForceCleanup = &record
+	delete(updated.Status.SliceIntents, podUUID)
+	return r.Status().Update(ctx, updated)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestForceCleanupRemovesStuckAllocation(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	recorder := record.NewFakeRecorder(10)
+	reconciler.Recorder = recorder
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.Len(t, device.GpuInstances, 2)
+	for len(recorder.Events) > 0 {
+		<-recorder.Events
+	}
+
+	// a process never lets go of the slice of pod-0, its deletion is stuck
+	var stuckGI uint32
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	for _, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID == "pod-uid-0" {
+			stuckGI = prepared.Giinfoid
+		}
+	}
+	for gi := range device.GpuInstances {
+		if gi.Info.Id != stuckGI {
+			continue
+		}
+		for ci := range gi.ComputeInstances {
+			ci.DestroyFunc = func() nvml.Return { return nvml.ERROR_IN_USE }
+		}
+	}
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	require.Contains(t, instaslice.Spec.Allocations, "pod-uid-0")
+
+	instaslice.Annotations = map[string]string{ForceCleanupAnnotation: "pod-uid-0"}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var latest inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &latest))
+	assert.NotContains(t, latest.Spec.Allocations, "pod-uid-0")
+	assert.NotContains(t, latest.Annotations, ForceCleanupAnnotation)
+	for _, prepared := range latest.Status.Prepared {
+		assert.NotEqual(t, "pod-uid-0", prepared.PodUUID)
+	}
+	// the slice of the other pod is left alone
+	assert.Contains(t, latest.Spec.Allocations, "pod-uid-1")
+	assert.Len(t, latest.Status.Prepared, 1)
+
+	cleanup := latest.Status.LastForceCleanup
+	require.NotNil(t, cleanup)
+	assert.Equal(t, "pod-uid-0", cleanup.PodUUID)
+	assert.Equal(t, "pod-0", cleanup.PodName)
+	assert.Equal(t, "deleting", cleanup.AllocationStatus)
+	assert.Len(t, cleanup.MigUUIDs, 1)
+	require.Len(t, cleanup.Errors, 1)
+	assert.Contains(t, cleanup.Errors[0], "ERROR_IN_USE")
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.NotContains(t, node.Status.Capacity, v1.ResourceName("org.instaslice/pod-0"))
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, EventReasonForceCleanup)
+}
+
+func TestForceCleanupOfUnknownPodOnlyClearsAnnotation(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	instaslice.Annotations = map[string]string{ForceCleanupAnnotation: "pod-uid-unknown"}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var latest inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &latest))
+	assert.NotContains(t, latest.Annotations, ForceCleanupAnnotation)
+	assert.Contains(t, latest.Spec.Allocations, "pod-uid-0")
+	require.NotNil(t, latest.Status.LastForceCleanup)
+	assert.Empty(t, latest.Status.LastForceCleanup.PodName)
+	assert.Empty(t, latest.Status.LastForceCleanup.Errors)
+}
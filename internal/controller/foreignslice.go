@@ -0,0 +1,36 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// ForeignSliceProfile is the profile recorded for a slice found on a GPU whose profile the operator does not
+// recognize, e.g. one carved out of band. The slice keeps its indexes occupied, so that nothing is carved over it,
+// but it is never handed over to a pod nor counted as capacity of any profile.
+const ForeignSliceProfile = "foreign"
+
+// recognizedProfile returns the profile when it is one discovered on the node, ForeignSliceProfile otherwise.
+func recognizedProfile(instaslice *inferencev1alpha1.Instaslice, profileName string) string {
+	for _, mig := range instaslice.Spec.Migplacement {
+		if mig.Profile == profileName {
+			return profileName
+		}
+	}
+	return ForeignSliceProfile
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestDiscoverDanglingSlicesTracksForeignSlices(t *testing.T) {
+	nvmllib := newFakeGPUs(1)
+	handle, ret := nvmllib.DeviceGetHandleByIndex(0)
+	require.Equal(t, nvml.SUCCESS, ret)
+	device := handle.(*dgxa100.Device)
+	for _, slice := range []struct {
+		giProfileID, ciProfileID int
+		placement                nvml.GpuInstancePlacement
+	}{
+		{nvml.GPU_INSTANCE_PROFILE_1_SLICE, nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, nvml.GpuInstancePlacement{Start: 0, Size: 1}},
+		// carved out of band with a profile the node does not offer
+		{nvml.GPU_INSTANCE_PROFILE_2_SLICE, nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE, nvml.GpuInstancePlacement{Start: 2, Size: 2}},
+	} {
+		gi, ret := createGpuInstance(device, slice.giProfileID, slice.placement)
+		require.Equal(t, nvml.SUCCESS, ret)
+		_, ret = createComputeInstance(gi, slice.ciProfileID, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED)
+		require.Equal(t, nvml.SUCCESS, ret)
+	}
+	reconciler := &InstaSliceDaemonsetReconciler{nvmlHandler: newDeviceHandler(nvmllib)}
+	var placements []inferencev1alpha1.Placement
+	for start := 0; start < 7; start++ {
+		placements = append(placements, inferencev1alpha1.Placement{Size: 1, Start: start})
+	}
+	instaslice := &inferencev1alpha1.Instaslice{Spec: inferencev1alpha1.InstasliceSpec{
+		MigGPUUUID:   map[string]string{device.UUID: "NVIDIA A100-SXM4-40GB"},
+		Migplacement: []inferencev1alpha1.Mig{{Profile: "1g.5gb", Giprofileid: nvml.GPU_INSTANCE_PROFILE_1_SLICE, Placements: placements}},
+	}}
+
+	require.NoError(t, reconciler.discoverDanglingSlices(instaslice))
+
+	require.Len(t, instaslice.Status.Prepared, 2)
+	profiles := make(map[uint32]string)
+	for _, prepared := range instaslice.Status.Prepared {
+		assert.Equal(t, device.UUID, prepared.Parent)
+		profiles[prepared.Start] = prepared.Profile
+	}
+	assert.Equal(t, map[uint32]string{0: "1g.5gb", 2: ForeignSliceProfile}, profiles)
+	// the foreign slice is not offered, nor carved over
+	assert.Equal(t, map[string]int{"1g.5gb": 4}, freeSlicesPerProfile(instaslice))
+	assert.True(t, CanPlace(instaslice, "1g.5gb", device.UUID))
+	assert.Equal(t, PlacementSet{true, false, true, true, false, false, false, false}, occupiedIndexes(instaslice, device.UUID))
+}
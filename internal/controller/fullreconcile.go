@@ -0,0 +1,93 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// DefaultFullReconcileInterval is how often the daemonset re-evaluates all the allocations and prepared
+// slices of its node when no event triggered a reconcile.
+const DefaultFullReconcileInterval = 10 * time.Minute
+
+// runFullReconcile periodically requests a full reconcile of the node so that a divergence between the hardware
+// and the instaslice is caught without waiting for an unrelated event.
+func (r *InstaSliceDaemonsetReconciler) runFullReconcile(ctx context.Context, nodeName string) {
+	ticker := time.NewTicker(r.FullReconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.nvmlNotReady.Load() {
+				continue
+			}
+			r.requestFullReconcile(nodeName)
+		}
+	}
+}
+
+// requestFullReconcile marks the next reconcile of the node as a full one and queues a reconcile through
+// fullReconcileEvents. The pass goes through the workqueue rather than being run here, so that it never carves
+// or destroys slices alongside another reconcile. A request made while one is still queued is merged into it.
+func (r *InstaSliceDaemonsetReconciler) requestFullReconcile(nodeName string) {
+	r.fullReconcilePending.Store(true)
+	select {
+	case r.fullReconcileEvents <- event.GenericEvent{Object: &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName, Namespace: r.instasliceNamespace()},
+	}}:
+	default:
+	}
+}
+
+// fullReconcile carves again the slices the instaslice records but the GPUs lost and checks the GPUs for a
+// pending reset. It is run by the reconcile picking up a requested full reconcile, whose remainder brings the
+// allocations, their ConfigMaps and the node capacity back to the desired state.
+func (r *InstaSliceDaemonsetReconciler) fullReconcile(ctx context.Context, nodeName string) error {
+	if err := r.recarveSlicesAfterReboot(ctx, nodeName); err != nil {
+		return err
+	}
+	var instaslice inferencev1alpha1.Instaslice
+	if err := r.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: r.instasliceNamespace()}, &instaslice); err != nil {
+		return err
+	}
+	_, err := r.recordResetPendingGPUs(ctx, &instaslice)
+	return err
+}
+
+// runRequestedFullReconcile runs the full reconcile requested since the last reconcile, if any. A failed one is
+// requested again for the next reconcile.
+func (r *InstaSliceDaemonsetReconciler) runRequestedFullReconcile(ctx context.Context, nodeName string) error {
+	if !r.fullReconcilePending.CompareAndSwap(true, false) {
+		return nil
+	}
+	if err := r.fullReconcile(ctx, nodeName); err != nil {
+		r.fullReconcilePending.Store(true)
+		log.FromContext(ctx).Error(err, "unable to fully reconcile the node")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// fullyReconcile requests a full reconcile of the node and runs the reconcile picking it up.
+func fullyReconcile(t *testing.T, reconciler *InstaSliceDaemonsetReconciler) {
+	reconciler.requestFullReconcile("node-1")
+	_, err := reconciler.Reconcile(context.Background(), ctrl.Request{})
+	require.NoError(t, err)
+	assert.False(t, reconciler.fullReconcilePending.Load())
+}
+
+func TestFullReconcileCorrectsDrift(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	require.NoError(t, fakeClient.Create(ctx, &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "pod-0",
+		Namespace: "default",
+		UID:       types.UID("pod-uid-0"),
+	}}))
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	require.Len(t, instaslice.Status.Prepared, 1)
+	var lostMigUUID string
+	for migUUID := range instaslice.Status.Prepared {
+		lostMigUUID = migUUID
+	}
+
+	// the slice is destroyed out-of-band, no event tells the daemonset about it
+	device.GpuInstances = make(map[*dgxa100.GpuInstance]struct{})
+	reconciler.slices = sliceCache{}
+
+	fullyReconcile(t, reconciler)
+
+	instaslice = inferencev1alpha1.Instaslice{}
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Len(t, device.GpuInstances, 1)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	require.Len(t, instaslice.Status.Prepared, 1)
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		assert.NotEqual(t, lostMigUUID, migUUID)
+		assert.Equal(t, "pod-uid-0", prepared.PodUUID)
+		var configMap v1.ConfigMap
+		require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-0", Namespace: "default"}, &configMap))
+		assert.Equal(t, migUUID, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	}
+
+	// nothing drifted, the full reconcile leaves the node alone
+	fullyReconcile(t, reconciler)
+	assert.Len(t, device.GpuInstances, 1)
+}
+
+func TestFullReconcileIsQueuedRatherThanRun(t *testing.T) {
+	reconciler, _, device, _ := newFakeGPUTestReconciler(t)
+	reconciler.fullReconcileEvents = make(chan event.GenericEvent, 1)
+	ctx := context.Background()
+
+	reconciler.requestFullReconcile("node-1")
+	// a second request while the first is queued is merged into it instead of blocking
+	reconciler.requestFullReconcile("node-1")
+	require.Len(t, reconciler.fullReconcileEvents, 1)
+	queued := <-reconciler.fullReconcileEvents
+	assert.Equal(t, "node-1", queued.Object.GetName())
+	assert.Equal(t, "default", queued.Object.GetNamespace())
+	assert.True(t, reconciler.fullReconcilePending.Load())
+	assert.Empty(t, device.GpuInstances, "nothing is carved until the reconcile picks the request up")
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.False(t, reconciler.fullReconcilePending.Load())
+}
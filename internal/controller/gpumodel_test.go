@@ -0,0 +1,62 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestPreparedEntryRecordsGPUModel(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	require.Len(t, instaslice.Status.Prepared, 1)
+	for _, prepared := range instaslice.Status.Prepared {
+		assert.Equal(t, "pod-uid-0", prepared.PodUUID)
+		assert.Equal(t, "Mock NVIDIA A100-SXM4-40GB", prepared.GPUModel)
+		assert.Equal(t, instaslice.Spec.MigGPUUUID[prepared.Parent], prepared.GPUModel)
+	}
+}
+
+func TestDiscoveredSliceRecordsGPUModel(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	// the slice is found again on the GPU as a restarted daemonset discovers it
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	require.Len(t, instaslice.Status.Prepared, 1)
+	for _, prepared := range instaslice.Status.Prepared {
+		assert.Equal(t, "Mock NVIDIA A100-SXM4-40GB", prepared.GPUModel)
+	}
+}
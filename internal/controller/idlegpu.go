@@ -0,0 +1,178 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// IdleGPUPolicy is what the daemonset does with a GPU once the last slice on it is torn down.
+type IdleGPUPolicy string
+
+const (
+	// IdleGPUPolicyLeave leaves the GPU as it is, in MIG mode and ready for the next slice.
+	IdleGPUPolicyLeave IdleGPUPolicy = "leave"
+	// IdleGPUPolicyResetWhenIdle destroys every compute and GPU instance still on the GPU, e.g. ones left behind
+	// by a crash or carved outside of the daemonset, so that the next slice starts from an empty GPU.
+	IdleGPUPolicyResetWhenIdle IdleGPUPolicy = "reset-when-idle"
+)
+
+// ParseIdleGPUPolicy validates a policy name, an empty name is IdleGPUPolicyLeave.
+func ParseIdleGPUPolicy(value string) (IdleGPUPolicy, error) {
+	switch IdleGPUPolicy(value) {
+	case "", IdleGPUPolicyLeave:
+		return IdleGPUPolicyLeave, nil
+	case IdleGPUPolicyResetWhenIdle:
+		return IdleGPUPolicyResetWhenIdle, nil
+	}
+	return "", fmt.Errorf("unknown idle GPU policy %q, must be %s or %s", value, IdleGPUPolicyLeave, IdleGPUPolicyResetWhenIdle)
+}
+
+// ParseIdleGPUPolicies parses a comma separated list of <GPU UUID>=<policy> entries.
+func ParseIdleGPUPolicies(value string) (map[string]IdleGPUPolicy, error) {
+	policies := make(map[string]IdleGPUPolicy)
+	if value == "" {
+		return policies, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		gpuUUID, name, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || gpuUUID == "" || name == "" {
+			return nil, fmt.Errorf("invalid idle GPU policy entry %q, expected <GPU UUID>=<policy>", entry)
+		}
+		if _, exists := policies[gpuUUID]; exists {
+			return nil, fmt.Errorf("GPU %s is given more than one idle GPU policy", gpuUUID)
+		}
+		policy, err := ParseIdleGPUPolicy(name)
+		if err != nil {
+			return nil, err
+		}
+		policies[gpuUUID] = policy
+	}
+	return policies, nil
+}
+
+// idleGPUPolicy returns the policy of the GPU, its own one first.
+func (r *InstaSliceDaemonsetReconciler) idleGPUPolicy(gpuUUID string) IdleGPUPolicy {
+	if policy, exists := r.IdleGPUPolicies[gpuUUID]; exists {
+		return policy
+	}
+	if r.IdleGPUPolicy == "" {
+		return IdleGPUPolicyLeave
+	}
+	return r.IdleGPUPolicy
+}
+
+// slicedGPUs returns the GPUs the slices of the pod are on.
+func slicedGPUs(instaslice *inferencev1alpha1.Instaslice, podUUID string) []string {
+	seen := make(map[string]bool)
+	var gpus []string
+	add := func(gpuUUID string) {
+		if gpuUUID != "" && !seen[gpuUUID] {
+			seen[gpuUUID] = true
+			gpus = append(gpus, gpuUUID)
+		}
+	}
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.PodUUID == podUUID {
+			add(allocation.GPUUUID)
+		}
+	}
+	for _, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID == podUUID {
+			add(prepared.Parent)
+		}
+	}
+	return gpus
+}
+
+// resetIdleGPUs applies the idle GPU policy of the GPUs no allocation or prepared slice of the instaslice is on
+// anymore. The slices were torn down already, a failed reset is only logged.
+func (r *InstaSliceDaemonsetReconciler) resetIdleGPUs(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, gpus []string) {
+	for _, gpuUUID := range gpus {
+		if r.idleGPUPolicy(gpuUUID) != IdleGPUPolicyResetWhenIdle || gpuReferenced(instaslice, gpuUUID) {
+			continue
+		}
+		if err := r.resetGPU(gpuUUID); err != nil {
+			log.FromContext(ctx).Error(err, "unable to reset idle GPU", "gpu", gpuUUID)
+			continue
+		}
+		log.FromContext(ctx).Info("reset idle GPU", "gpu", gpuUUID)
+	}
+}
+
+// resetGPU destroys every compute instance and GPU instance on the GPU.
+func (r *InstaSliceDaemonsetReconciler) resetGPU(gpuUUID string) error {
+	nvmllib := r.handler().nvml
+	device, ret := nvmllib.DeviceGetHandleByUUID(gpuUUID)
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to get GPU %s: %v", gpuUUID, ret)
+	}
+	for giProfileID := 0; giProfileID < nvml.GPU_INSTANCE_PROFILE_COUNT; giProfileID++ {
+		giProfileInfo, ret := device.GetGpuInstanceProfileInfo(giProfileID)
+		if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("unable to get gpu instance profile %d: %v", giProfileID, ret)
+		}
+		gis, ret := device.GetGpuInstances(&giProfileInfo)
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("unable to list gpu instances of profile %d: %v", giProfileID, ret)
+		}
+		for _, gi := range gis {
+			if err := destroyComputeInstances(gi); err != nil {
+				return err
+			}
+			if ret := gi.Destroy(); ret != nvml.SUCCESS {
+				return fmt.Errorf("unable to destroy gpu instance: %v", ret)
+			}
+		}
+	}
+	return nil
+}
+
+// destroyComputeInstances destroys every compute instance of the GPU instance.
+func destroyComputeInstances(gi nvml.GpuInstance) error {
+	for ciProfileID := 0; ciProfileID < nvml.COMPUTE_INSTANCE_PROFILE_COUNT; ciProfileID++ {
+		for engProfileID := 0; engProfileID < nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_COUNT; engProfileID++ {
+			ciProfileInfo, ret := gi.GetComputeInstanceProfileInfo(ciProfileID, engProfileID)
+			if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+				continue
+			}
+			if ret != nvml.SUCCESS {
+				return fmt.Errorf("unable to get compute instance profile %d: %v", ciProfileID, ret)
+			}
+			cis, ret := gi.GetComputeInstances(&ciProfileInfo)
+			if ret != nvml.SUCCESS {
+				return fmt.Errorf("unable to list compute instances of profile %d: %v", ciProfileID, ret)
+			}
+			for _, ci := range cis {
+				if ret := ci.Destroy(); ret != nvml.SUCCESS {
+					return fmt.Errorf("unable to destroy compute instance: %v", ret)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// carveStrayInstance carves a slice at the last memory slice of the GPU that no allocation records, like one left
+// behind by a crash.
+func carveStrayInstance(t *testing.T, device *dgxa100.Device) {
+	gi, ret := createGpuInstance(device, nvml.GPU_INSTANCE_PROFILE_1_SLICE, nvml.GpuInstancePlacement{Start: 6, Size: 1})
+	require.Equal(t, nvml.SUCCESS, ret)
+	_, ret = createComputeInstance(gi, nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED)
+	require.Equal(t, nvml.SUCCESS, ret)
+}
+
+func TestTeardownOfLastSliceResetsIdleGPU(t *testing.T) {
+	reconciler, _, device, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.IdleGPUPolicy = IdleGPUPolicyResetWhenIdle
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	carveStrayInstance(t, device)
+	require.Len(t, device.GpuInstances, 2)
+
+	require.NoError(t, reconciler.cleanUp(ctx, "pod-uid-0"))
+	assert.Empty(t, device.GpuInstances)
+}
+
+func TestTeardownLeavesGPUsStillInUse(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	reconciler.IdleGPUPolicy = IdleGPUPolicyResetWhenIdle
+	ctx := context.Background()
+	carvedTestSlices(t, reconciler, fakeClient)
+	carveStrayInstance(t, device)
+
+	// the slice of pod-1 keeps the GPU busy
+	require.NoError(t, reconciler.cleanUp(ctx, "pod-uid-0"))
+	assert.Len(t, device.GpuInstances, 2)
+}
+
+func TestTeardownLeavesIdleGPUByDefault(t *testing.T) {
+	reconciler, _, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	carveStrayInstance(t, device)
+
+	require.NoError(t, reconciler.cleanUp(ctx, "pod-uid-0"))
+	assert.Len(t, device.GpuInstances, 1)
+
+	// a GPU of its own policy is reset whatever the default
+	reconciler.IdleGPUPolicies = map[string]IdleGPUPolicy{device.UUID: IdleGPUPolicyResetWhenIdle}
+	assert.Equal(t, IdleGPUPolicyResetWhenIdle, reconciler.idleGPUPolicy(device.UUID))
+	assert.Equal(t, IdleGPUPolicyLeave, reconciler.idleGPUPolicy("GPU-other"))
+}
+
+func TestParseIdleGPUPolicies(t *testing.T) {
+	policies, err := ParseIdleGPUPolicies("GPU-a=reset-when-idle, GPU-b=leave")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]IdleGPUPolicy{"GPU-a": IdleGPUPolicyResetWhenIdle, "GPU-b": IdleGPUPolicyLeave}, policies)
+
+	policies, err = ParseIdleGPUPolicies("")
+	require.NoError(t, err)
+	assert.Empty(t, policies)
+
+	for _, value := range []string{"GPU-a", "GPU-a=", "=leave", "GPU-a=reset", "GPU-a=leave,GPU-a=leave"} {
+		_, err := ParseIdleGPUPolicies(value)
+		assert.Error(t, err, value)
+	}
+	_, err = ParseIdleGPUPolicy("reboot")
+	assert.Error(t, err)
+}
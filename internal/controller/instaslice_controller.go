@@ -30,6 +30,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -43,19 +44,31 @@ type InstasliceReconciler struct {
 	client.Client
 	Scheme     *runtime.Scheme
 	kubeClient *kubernetes.Clientset
+	// PlacementSelector chooses among the free placements on a GPU, first fit is used when nil.
+	PlacementSelector PlacementSelector
+	// Identity is recorded as the creator of the allocations this controller writes, DefaultAllocationCreator when unset.
+	Identity string
+	// Recorder emits the events of preempted slices on their pods, none are emitted when unset.
+	Recorder record.EventRecorder
+	// DefaultAllocationTTL is how long after they are allocated the slices of pods without a TTL annotation
+	// expire. They never do when unset.
+	DefaultAllocationTTL time.Duration
+	// ProfileOrder is the order in which the profiles satisfying a pod asking for any slice, or for GPU memory,
+	// are tried, ProfileOrderSmallestFirst when unset.
+	ProfileOrder ProfileOrder
+	// PreemptionGuard decides whether evictable slices of lower priority may be preempted, e.g. without breaking
+	// PodDisruptionBudgets. Any of them may be when unset.
+	PreemptionGuard PreemptionGuard
+	// OperatorNamespace is the namespace of the operator the instaslices of the nodes are kept in,
+	// DefaultOperatorNamespace when unset.
+	OperatorNamespace string
 }
 
 // AllocationPolicy interface with a single method
 type AllocationPolicy interface {
-	SetAllocationDetails(profileName string, newStart, size uint32, podUUID string, nodename string, processed string, discoveredGiprofile int, Ciprofileid int, Ciengprofileid int, namespace string, podName string, gpuUuid string) *inferencev1alpha1.AllocationDetails
+	SetAllocationDetails(profileName string, newStart, size uint32, podUUID string, nodename string, processed inferencev1alpha1.AllocationStatus, discoveredGiprofile int, Ciprofileid int, Ciengprofileid int, namespace string, podName string, gpuUuid string) *inferencev1alpha1.AllocationDetails
 }
 
-// not implemented
-type RightToLeftPolicy struct{}
-
-// not implemented
-type LeftToRightPolicy struct{}
-
 // first fit policy is implemented at the moment
 type FirstFitPolicy struct{}
 
@@ -64,6 +77,8 @@ type FirstFitPolicy struct{}
 //+kubebuilder:rbac:groups=inference.codeflare.dev,resources=instaslices/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch
 
 func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 
@@ -94,23 +109,36 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	if err := r.List(ctx, &instasliceList, &client.ListOptions{}); err != nil {
 		log.FromContext(ctx).Error(err, "Error listing Instaslice")
 	}
+	// an instaslice of the same node in another namespace, e.g. left behind by a migration, must not get slices
+	instasliceList.Items, _ = authoritativeInstaslices(instasliceList.Items, r.instasliceNamespace())
 
-	// pod is completed move allocation to deleting state and return
+	// pod is completed move allocation to deleting or retained state and return
 	if pod.Status.Phase == v1.PodSucceeded && controllerutil.ContainsFinalizer(pod, "org.instaslice/accelarator") {
 		for _, instaslice := range instasliceList.Items {
 			for podUuid, allocation := range instaslice.Spec.Allocations {
 				if podUuid == string(pod.UID) {
-					log.FromContext(ctx).Info("deleting allocation for completed ", "pod", allocation.PodName)
-					allocation.Allocationstatus = "deleting"
 					var updateInstasliceObject inferencev1alpha1.Instaslice
 					typeNamespacedName := types.NamespacedName{
 						Name:      instaslice.Name,
-						Namespace: "default", // TODO: modify
+						Namespace: r.instasliceNamespace(),
 					}
 					err := r.Get(ctx, typeNamespacedName, &updateInstasliceObject)
 					if err != nil {
 						log.FromContext(ctx).Error(err, "error getting latest instaslice object")
 					}
+					// only a realized slice with a single compute instance can be handed to the next pod
+					if updateInstasliceObject.Spec.RetainSlices && allocation.Allocationstatus == inferencev1alpha1.AllocationStatusUngated && allocation.ComputeInstances <= 1 {
+						log.FromContext(ctx).Info("retaining allocation for completed ", "pod", allocation.PodName)
+						retainedAt := now()
+						allocation.Allocationstatus = inferencev1alpha1.AllocationStatusRetained
+						allocation.RetainedAt = &retainedAt
+					} else if allocation.Allocationstatus != inferencev1alpha1.AllocationStatusRetained {
+						log.FromContext(ctx).Info("deleting allocation for completed ", "pod", allocation.PodName)
+						allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+					}
+					if updateInstasliceObject.Spec.Allocations == nil {
+						updateInstasliceObject.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
+					}
 					updateInstasliceObject.Spec.Allocations[podUuid] = allocation
 					errUpdatingInstaslice := r.Update(ctx, &updateInstasliceObject)
 					if errUpdatingInstaslice != nil {
@@ -141,20 +169,23 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	// handle deleted pod that never gets ungated
 	//set allocation status to deleting to cleanup resources if any
 	if !pod.DeletionTimestamp.IsZero() && isPodGated {
-		// allocation can be in creating or created while the user deletes the pod.
+		// allocation can be in creating, created or failed while the user deletes the pod.
 		for _, instaslice := range instasliceList.Items {
 			for podUuid, allocation := range instaslice.Spec.Allocations {
-				if podUuid == string(pod.UID) && (allocation.Allocationstatus == "creating" || allocation.Allocationstatus == "created") {
-					allocation.Allocationstatus = "deleting"
+				if podUuid == string(pod.UID) && (allocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreating || allocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreated || allocation.Allocationstatus == inferencev1alpha1.AllocationStatusFailed) {
 					var updateInstasliceObject inferencev1alpha1.Instaslice
 					typeNamespacedName := types.NamespacedName{
 						Name:      instaslice.Name,
-						Namespace: "default", // TODO: modify
+						Namespace: r.instasliceNamespace(),
 					}
 					err := r.Get(ctx, typeNamespacedName, &updateInstasliceObject)
 					if err != nil {
 						log.FromContext(ctx).Error(err, "error getting latest instaslice object")
 					}
+					releaseDeletedAllocation(&updateInstasliceObject, &allocation)
+					if updateInstasliceObject.Spec.Allocations == nil {
+						updateInstasliceObject.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
+					}
 					updateInstasliceObject.Spec.Allocations[podUuid] = allocation
 					errUpdatingInstaslice := r.Update(ctx, &updateInstasliceObject)
 					if errUpdatingInstaslice != nil {
@@ -164,6 +195,10 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 				}
 			}
 		}
+		if err := r.clearUnschedulableOnNode(ctx, instasliceList.Items, string(pod.UID)); err != nil {
+			log.FromContext(ctx).Error(err, "unable to clear the unschedulable status of ", "pod", pod.Name)
+			return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
+		}
 		// allocation is updated to deleting that will trigger daemonset to cleanup
 		// remove the finalizer
 		if controllerutil.RemoveFinalizer(pod, "org.instaslice/accelarator") {
@@ -185,16 +220,20 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 					if podUuid == string(pod.UID) {
 						elapsed := time.Since(pod.DeletionTimestamp.Time)
 						if elapsed > 30*time.Second {
-							allocation.Allocationstatus = "deleting"
 							var updateInstasliceObject inferencev1alpha1.Instaslice
 							typeNamespacedName := types.NamespacedName{
 								Name:      instaslice.Name,
-								Namespace: "default", // TODO: modify
+								Namespace: r.instasliceNamespace(),
 							}
 							err := r.Get(ctx, typeNamespacedName, &updateInstasliceObject)
 							if err != nil {
 								log.FromContext(ctx).Error(err, "error getting latest instaslice object")
 							}
+							// a slice kept for the grace period waits for the pod to be re-created
+							releaseDeletedAllocation(&updateInstasliceObject, &allocation)
+							if updateInstasliceObject.Spec.Allocations == nil {
+								updateInstasliceObject.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
+							}
 							updateInstasliceObject.Spec.Allocations[podUuid] = allocation
 							errUpdatingInstaslice := r.Update(ctx, &updateInstasliceObject)
 							if errUpdatingInstaslice != nil {
@@ -235,18 +274,18 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		profileName := r.extractProfileName(limits)
 		for _, instaslice := range instasliceList.Items {
 			for podUuid, allocations := range instaslice.Spec.Allocations {
-				if allocations.Allocationstatus == "created" && allocations.PodUUID == string(pod.UID) {
+				if allocations.Allocationstatus == inferencev1alpha1.AllocationStatusCreated && allocations.PodUUID == string(pod.UID) {
 					pod := r.unGatePod(pod)
 					errForUngating := r.Update(ctx, pod)
 					if errForUngating != nil {
 						return ctrl.Result{Requeue: true}, nil
 					}
-					allocations.Allocationstatus = "ungated"
+					allocations.Allocationstatus = inferencev1alpha1.AllocationStatusUngated
 					instaslice.Spec.Allocations[podUuid] = allocations
 					var updateInstasliceObject inferencev1alpha1.Instaslice
 					typeNamespacedName := types.NamespacedName{
 						Name:      instaslice.Name,
-						Namespace: "default", // TODO: modify
+						Namespace: r.instasliceNamespace(),
 					}
 					err := r.Get(ctx, typeNamespacedName, &updateInstasliceObject)
 					if err != nil {
@@ -266,14 +305,20 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		// pod does not have an allocation yet, make allocation
 		// find the node
 		podHasNodeAllocation := false
+		unschedulable := make(map[string]string)
 		for _, instaslice := range instasliceList.Items {
 			// find the GPU on the node and the GPU index where the slice can be created
 			allocDetails, err := r.findDeviceForASlice(&instaslice, profileName, policy, pod)
 			if err != nil {
+				unschedulable[instaslice.Name] = unschedulableReason(&instaslice, profileName)
 				continue
 			}
 			podHasNodeAllocation = true
-			for _, item := range instaslice.Spec.Prepared {
+			for _, item := range instaslice.Status.Prepared {
+				// a retained slice is taken over as is, its prepared entry is expected to exist
+				if allocDetails.ReusedFrom != "" {
+					break
+				}
 				if item.Parent == allocDetails.GPUUUID && item.Size == allocDetails.Size && item.Start == allocDetails.Start {
 					log.FromContext(ctx).Info("prepared allocation is yet to be deleted, retrying new allocation")
 					return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
@@ -283,12 +328,19 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 				var updateInstasliceObject inferencev1alpha1.Instaslice
 				typeNamespacedName := types.NamespacedName{
 					Name:      instaslice.Name,
-					Namespace: "default", // TODO: modify
+					Namespace: r.instasliceNamespace(),
 				}
 				err := r.Get(ctx, typeNamespacedName, &updateInstasliceObject)
 				if err != nil {
 					log.FromContext(ctx).Error(err, "error getting latest instaslice object")
 				}
+				if allocDetails.ReusedFrom != "" {
+					retained, exists := updateInstasliceObject.Spec.Allocations[allocDetails.ReusedFrom]
+					if !exists || retained.Allocationstatus != inferencev1alpha1.AllocationStatusRetained || retainedSliceClaimed(&updateInstasliceObject, allocDetails.ReusedFrom, allocDetails.PodUUID) {
+						log.FromContext(ctx).Info("retained slice is no longer available, retrying new allocation")
+						return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
+					}
+				}
 				log.FromContext(ctx).Info("allocation obtained for ", "pod", allocDetails.PodName)
 				if updateInstasliceObject.Spec.Allocations == nil {
 					updateInstasliceObject.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
@@ -298,11 +350,32 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 					log.FromContext(ctx).Error(err, "Error updating instaslice allocations")
 					return ctrl.Result{Requeue: true}, nil
 				}
+				if err := r.clearUnschedulableOnNode(ctx, instasliceList.Items, string(pod.UID)); err != nil {
+					log.FromContext(ctx).Error(err, "unable to clear the unschedulable status of ", "pod", pod.Name)
+				}
+			}
+		}
+		// make room by tearing down evictable slices of pods of lower priority
+		if !podHasNodeAllocation {
+			preempting, errPreempting := r.preemptForPod(ctx, instasliceList.Items, profileName, pod)
+			if errPreempting != nil {
+				log.FromContext(ctx).Error(errPreempting, "unable to preempt slices for ", "pod", pod.Name)
+				return ctrl.Result{Requeue: true}, nil
+			}
+			if preempting {
+				log.FromContext(ctx).Info("waiting for preempted slices to be torn down for ", "pod", pod.Name)
+				return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
 			}
 		}
 		//if the cluster does not have suitable node, requeue request
 		if !podHasNodeAllocation {
 			log.FromContext(ctx).Info("no suitable node found in cluster for ", "pod", pod.Name)
+			// let schedulers see why each node turned the pod down and move it elsewhere
+			for instasliceName, reason := range unschedulable {
+				if err := r.markUnschedulableOnNode(ctx, instasliceName, pod, profileName, reason); err != nil {
+					log.FromContext(ctx).Error(err, "unable to record the unschedulable status of ", "pod", pod.Name, "node", instasliceName)
+				}
+			}
 			// Generate a random duration between 1 and 10 seconds
 			randomDuration := time.Duration(rand.Intn(10)+1) * time.Second
 			return ctrl.Result{RequeueAfter: randomDuration}, nil
@@ -315,12 +388,79 @@ func (r *InstasliceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 // find node, gpu and gpu index to place the slice
 func (r *InstasliceReconciler) findDeviceForASlice(instaslice *inferencev1alpha1.Instaslice, profileName string, policy AllocationPolicy, pod *v1.Pod) (*inferencev1alpha1.AllocationDetails, error) {
+	// any slice goes, or any with enough memory, the first profile in the preferred order with a free placement
+	// is recorded on the allocation
+	if profiles, derived := r.preferredCandidateProfiles(instaslice, profileName); derived {
+		for _, profile := range profiles {
+			if allocDetails, err := r.findDeviceForASlice(instaslice, profile, policy, pod); err == nil {
+				return allocDetails, nil
+			}
+		}
+		return nil, fmt.Errorf("failed to find allocatable gpu")
+	}
+	preferred := preferredGPUFor(pod)
+	if _, exists := instaslice.Spec.MigGPUUUID[preferred]; preferred != "" && exists {
+		if allocDetails := r.placeSlice(instaslice, profileName, policy, pod, preferred); allocDetails != nil {
+			recordPreferredGPU(allocDetails, preferred)
+			recordPreemptionPolicy(allocDetails, pod)
+			allocDetails.SliceGroup = sliceGroupFor(pod)
+			allocDetails.MultiSlice = multiSliceRequested(pod)
+			allocDetails.Creator = r.allocationCreator()
+			recordTraceParent(allocDetails, pod)
+			r.recordAllocationTTL(allocDetails, pod)
+			return allocDetails, nil
+		}
+	}
+	allocDetails := r.placeSlice(instaslice, profileName, policy, pod, "")
+	if allocDetails == nil {
+		return nil, fmt.Errorf("failed to find allocatable gpu")
+	}
+	recordPreferredGPU(allocDetails, preferred)
+	if allocDetails.PreferredGPUFallback {
+		log.Log.Info("preferred gpu has no free placement, falling back for ", "pod", pod.Name, "preferred", preferred, "gpu", allocDetails.GPUUUID)
+	}
+	recordPreemptionPolicy(allocDetails, pod)
+	allocDetails.SliceGroup = sliceGroupFor(pod)
+	allocDetails.MultiSlice = multiSliceRequested(pod)
+	allocDetails.Creator = r.allocationCreator()
+	recordTraceParent(allocDetails, pod)
+	r.recordAllocationTTL(allocDetails, pod)
+	return allocDetails, nil
+}
+
+// placeSlice returns the allocation of a slice on the given GPU, or on any GPU of the node when gpuUUID is empty.
+// It returns nil when no placement is free.
+func (r *InstasliceReconciler) placeSlice(instaslice *inferencev1alpha1.Instaslice, profileName string, policy AllocationPolicy, pod *v1.Pod, gpuUUID string) *inferencev1alpha1.AllocationDetails {
+	// a retained slice of the same profile is handed over without carving a new one
+	if retainedPodUUID, retained, found := findRetainedSlice(instaslice, profileName, pod, gpuUUID); found {
+		allocDetails := policy.SetAllocationDetails(profileName, retained.Start, retained.Size,
+			string(pod.UID), instaslice.Name, inferencev1alpha1.AllocationStatusCreating, retained.Giprofileid,
+			retained.CIProfileID, retained.CIEngProfileID, pod.Namespace, pod.Name, retained.GPUUUID)
+		allocDetails.ReusedFrom = retainedPodUUID
+		return allocDetails
+	}
 	//TODO: discover this value, this may work for A100 and H100 for now.
-	for gpuuuid, _ := range instaslice.Spec.MigGPUUUID {
+	// slices of a group go to GPUs linked by NVLink first
+	for _, gpuuuid := range gpusByNVLinkAffinity(instaslice, pod) {
+		if gpuUUID != "" && gpuuuid != gpuUUID {
+			continue
+		}
+		// cordoned GPUs keep their slices, and GPUs waiting for their desired layout drain, without new ones
+		if !gpuTakesNewSlices(instaslice, gpuuuid) || !gpuAllowsProfile(instaslice, gpuuuid, profileName) {
+			continue
+		}
+		// GPUs dedicated to a tenant only take the pods of its namespace
+		if !gpuAdmitsNamespace(instaslice, gpuuuid, pod.Namespace) {
+			continue
+		}
+		// the memory reserved for the OS and the driver is not there for slices
+		if !gpuHasMemoryFor(instaslice, gpuuuid, profileName) {
+			continue
+		}
 		if instaslice.Spec.Allocations == nil {
 			instaslice.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
 		}
-		newStart := r.getStartIndexFromPreparedState(instaslice, gpuuuid, profileName)
+		newStart := r.getStartIndexFromPreparedState(instaslice, gpuuuid, profileName, reserveFor(instaslice, pod))
 		//size cannot be 9 atleast for A100s 40GB/80GB and H100 variants
 		notValidIndex := uint32(9)
 		if newStart == notValidIndex {
@@ -328,19 +468,25 @@ func (r *InstasliceReconciler) findDeviceForASlice(instaslice *inferencev1alpha1
 			continue
 		}
 		size, discoveredGiprofile, Ciprofileid, Ciengprofileid := r.extractGpuProfile(instaslice, profileName)
-		allocDetails := policy.SetAllocationDetails(profileName, uint32(newStart), uint32(size),
-			string(pod.UID), instaslice.Name, "creating", discoveredGiprofile,
+		return policy.SetAllocationDetails(profileName, uint32(newStart), uint32(size),
+			string(pod.UID), instaslice.Name, inferencev1alpha1.AllocationStatusCreating, discoveredGiprofile,
 			Ciprofileid, Ciengprofileid, pod.Namespace, pod.Name, gpuuuid)
-		return allocDetails, nil
 	}
-
-	return nil, fmt.Errorf("failed to find allocatable gpu")
+	return nil
 }
 
 // Extract profile name from the container limits spec
 func (*InstasliceReconciler) extractProfileName(limits v1.ResourceList) string {
 	profileName := ""
-	for k, _ := range limits {
+	for k, v := range limits {
+		if k.String() == AnySliceResource {
+			profileName = AnySliceProfile
+			continue
+		}
+		if k.String() == GPUMemoryResource {
+			profileName = memoryProfileName(v)
+			continue
+		}
 		if strings.Contains(k.String(), "nvidia") {
 
 			re := regexp.MustCompile(`(\d+g\.\d+gb)`)
@@ -357,108 +503,70 @@ func (*InstasliceReconciler) extractProfileName(limits v1.ResourceList) string {
 
 // Extract NVML specific attributes for GPUs, this will change for different generations of the GPU.
 func (*InstasliceReconciler) extractGpuProfile(instaslice *inferencev1alpha1.Instaslice, profileName string) (int, int, int, int) {
-	var size int
-	var discoveredGiprofile int
-	var Ciprofileid int
-	var Ciengprofileid int
-	for _, item := range instaslice.Spec.Migplacement {
-		if item.Profile == profileName {
-			for _, aPlacement := range item.Placements {
-				size = aPlacement.Size
-				discoveredGiprofile = item.Giprofileid
-				Ciprofileid = item.CIProfileID
-				Ciengprofileid = item.CIEngProfileID
-				break
-			}
-		}
+	giProfileID, ciProfileID, ciEngProfileID, placements, err := ResolveProfileIDs(profileName, instaslice.Spec.Migplacement)
+	if err != nil || len(placements) == 0 {
+		return 0, giProfileID, ciProfileID, ciEngProfileID
 	}
-	return size, discoveredGiprofile, Ciprofileid, Ciengprofileid
+	return placements[0].Size, giProfileID, ciProfileID, ciEngProfileID
 }
 
 // accounting logic that finds the correct GPU and index where a slice could be placed.
-func (*InstasliceReconciler) getStartIndexFromPreparedState(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string) uint32 {
-	//TODO: generalize, A100 and H100 have 8 indexes for 3g and 7g and 7 for rest, so go with 8 and we are bounded by
-	//only valid placement indexes for a profile.
-	var gpuAllocatedIndex [8]uint32
-	// clean slate init
-	for i := range gpuAllocatedIndex {
-		gpuAllocatedIndex[i] = 0
-	}
-	//TODO: remove this once we start using GPU operator with device plugin fix
-	for _, item := range instaslice.Spec.Prepared {
-		if item.Parent == gpuUUID {
-			for i := 0; i < int(item.Size); i++ {
-				gpuAllocatedIndex[int(item.Start)+i] = 1
-			}
-
-		}
-	}
-	// deleted allocations can be reused
-	// ungated allocations are already counted in prepared
-	for _, item := range instaslice.Spec.Allocations {
-		if item.GPUUUID == gpuUUID && item.Allocationstatus != "deleted" && item.Allocationstatus != "ungated" {
-			for i := 0; i < int(item.Size); i++ {
-				gpuAllocatedIndex[int(item.Start)+i] = 1
-			}
-		}
-	}
+// reserve is the number of smallest profile slots the placement must leave free on the GPU.
+func (r *InstasliceReconciler) getStartIndexFromPreparedState(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profileName string, reserve int) uint32 {
+	gpuAllocatedIndex := occupiedIndexes(instaslice, gpuUUID)
 
-	var neededContinousSlot int
-	var possiblePlacements []int
+	var possiblePlacements []inferencev1alpha1.Placement
 	for _, placement := range instaslice.Spec.Migplacement {
-		if placement.Profile == profileName {
-			neededContinousSlot = placement.Placements[0].Size
-			for _, placement := range placement.Placements {
-				possiblePlacements = append(possiblePlacements, placement.Start)
-			}
+		if ProfilesMatch(placement.Profile, profileName) {
+			possiblePlacements = placement.Placements
 			break
 		}
 	}
 	//TODO: generalize for other hardware models like A30, no slices can be placed on 9th index
 	//if we return 9 then assume no valid index is found.
 	var newStart = uint32(9)
-	for _, value := range possiblePlacements {
-		if gpuAllocatedIndex[value] == 0 {
-			if neededContinousSlot == 1 {
-				newStart = uint32(value)
-				break
-			}
-			if neededContinousSlot == 2 {
-				if value+neededContinousSlot < len(gpuAllocatedIndex) {
-					if gpuAllocatedIndex[value] == 0 && gpuAllocatedIndex[value+1] == 0 {
-						newStart = uint32(value)
-						break
-					}
-				}
-
-			}
-			if neededContinousSlot == 4 {
-				if value+neededContinousSlot < len(gpuAllocatedIndex) {
-					if gpuAllocatedIndex[value] == 0 && gpuAllocatedIndex[value+1] == 0 && gpuAllocatedIndex[value+2] == 0 && gpuAllocatedIndex[value+3] == 0 {
-						newStart = uint32(value)
-						break
-					}
-				}
-			}
+	freePlacements := freePlacementsFor(possiblePlacements, gpuAllocatedIndex)
+	freePlacements = placementsKeepingReserve(instaslice.Spec.Migplacement, freePlacements, gpuAllocatedIndex, reserve)
+	if selected, ok := r.placementSelector().Select(profileName, freePlacements, gpuAllocatedIndex); ok {
+		newStart = uint32(selected.Start)
+	}
 
-			if neededContinousSlot == 8 {
-				//special case
-				if value+neededContinousSlot < len(gpuAllocatedIndex) {
-					if gpuAllocatedIndex[value] == 0 && gpuAllocatedIndex[value+1] == 0 &&
-						gpuAllocatedIndex[value+2] == 0 && gpuAllocatedIndex[value+3] == 0 &&
-						gpuAllocatedIndex[value+4] == 0 && gpuAllocatedIndex[value+5] == 0 &&
-						gpuAllocatedIndex[value+6] == 0 && gpuAllocatedIndex[value+7] == 0 {
-						newStart = uint32(value)
-					}
-				}
-			}
-		}
+	return newStart
+}
 
+// placementSelector returns the configured selector or first fit when none is set.
+func (r *InstasliceReconciler) placementSelector() PlacementSelector {
+	if r.PlacementSelector == nil {
+		return &FirstFitSelector{}
 	}
+	return r.PlacementSelector
+}
 
-	return newStart
+// occupiedIndexes returns, per slice index of the GPU, whether it is used by a prepared slice or a pending allocation.
+func occupiedIndexes(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) PlacementSet {
+	gpuAllocatedIndex := NewPlacementSet(gpuMemorySlices)
+	//TODO: remove this once we start using GPU operator with device plugin fix
+	for _, item := range instaslice.Status.Prepared {
+		if item.Parent == gpuUUID {
+			gpuAllocatedIndex.Occupy(item.Start, item.Size)
+		}
+	}
+	// deleted allocations can be reused
+	// ungated allocations are already counted in prepared
+	// failed allocations never got a slice
+	for _, item := range instaslice.Spec.Allocations {
+		if item.GPUUUID == gpuUUID && item.Allocationstatus != inferencev1alpha1.AllocationStatusDeleted && item.Allocationstatus != inferencev1alpha1.AllocationStatusUngated && item.Allocationstatus != inferencev1alpha1.AllocationStatusFailed {
+			gpuAllocatedIndex.Occupy(item.Start, item.Size)
+		}
+	}
+	return gpuAllocatedIndex
 }
 
+// gpuMemorySlices is the number of memory slices of a GPU.
+// TODO: generalize, A100 and H100 have 8 indexes for 3g and 7g and 7 for rest, so go with 8 and we are bounded by
+// only valid placement indexes for a profile.
+const gpuMemorySlices = 8
+
 func checkIfPodGated(pod *v1.Pod, isPodGated bool) bool {
 	for _, gate := range pod.Spec.SchedulingGates {
 		if gate.Name == "org.instaslice/accelarator" {
@@ -474,7 +582,7 @@ func checkIfPodGated(pod *v1.Pod, isPodGated bool) bool {
 func (r *InstasliceReconciler) podMapFunc(ctx context.Context, obj client.Object) []reconcile.Request {
 	instaslice := obj.(*inferencev1alpha1.Instaslice)
 	for _, allocation := range instaslice.Spec.Allocations {
-		if allocation.Allocationstatus == "created" || allocation.Allocationstatus == "deleted" {
+		if allocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreated || allocation.Allocationstatus == inferencev1alpha1.AllocationStatusDeleted {
 			return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: allocation.Namespace, Name: allocation.PodName}}}
 		}
 	}
@@ -510,7 +618,7 @@ func (r *InstasliceReconciler) unGatePod(podUpdate *v1.Pod) *v1.Pod {
 
 // Policy based allocation - FirstFit
 func (r *FirstFitPolicy) SetAllocationDetails(profileName string, newStart, size uint32, podUUID, nodename string,
-	processed string, discoveredGiprofile int, Ciprofileid int, Ciengprofileid int,
+	processed inferencev1alpha1.AllocationStatus, discoveredGiprofile int, Ciprofileid int, Ciengprofileid int,
 	namespace string, podName string, gpuUuid string) *inferencev1alpha1.AllocationDetails {
 	return &inferencev1alpha1.AllocationDetails{
 		Profile:          profileName,
@@ -527,19 +635,3 @@ func (r *FirstFitPolicy) SetAllocationDetails(profileName string, newStart, size
 		GPUUUID:          gpuUuid,
 	}
 }
-
-// Policy based allocation - LeftToRIght
-func (l *LeftToRightPolicy) SetAllocationDetails(profileName string, newStart, size uint32, podUUID, nodename string,
-	processed string, discoveredGiprofile int, Ciprofileid int, Ciengprofileid int,
-	namespace string, podName string, gpuUuid string) *inferencev1alpha1.AllocationDetails {
-	// Implement the left-to-right policy here
-	return &inferencev1alpha1.AllocationDetails{}
-}
-
-// Policy based allocation - RigghToLeft
-func (l *RightToLeftPolicy) SetAllocationDetails(profileName string, newStart, size uint32, podUUID, nodename string,
-	processed string, discoveredGiprofile int, Ciprofileid int, Ciengprofileid int,
-	namespace string, podName string, gpuUuid string) *inferencev1alpha1.AllocationDetails {
-	// Implement the left-to-right policy here
-	return &inferencev1alpha1.AllocationDetails{}
-}
@@ -22,7 +22,11 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
@@ -32,12 +36,22 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	nvdevice "github.com/NVIDIA/go-nvlib/pkg/nvlib/device"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -47,6 +61,177 @@ type InstaSliceDaemonsetReconciler struct {
 	Scheme     *runtime.Scheme
 	kubeClient *kubernetes.Clientset
 	NodeName   string
+	// ExposeSliceDetails adds the profile and memory size of the slice to the pod ConfigMap.
+	ExposeSliceDetails bool
+	// ExpectedECCMode, enabled or disabled, logs a warning for GPUs in another ECC mode when set.
+	ExpectedECCMode string
+	// ProtectConfigMaps adds a finalizer to pod ConfigMaps so that they outlive the slice they map.
+	ProtectConfigMaps bool
+	// DiscoveryConcurrency is the number of GPUs searched for existing slices at once on startup, one at a time when unset.
+	DiscoveryConcurrency int
+	// CapacityReloader makes the device plugin pick up slice changes, the node label is toggled when unset.
+	CapacityReloader CapacityReloader
+	// CapacityAdvertiser publishes the capacity of the slices on the node, a resource per pod is added when unset.
+	CapacityAdvertiser CapacityAdvertiser
+	// Recorder emits the events of the slices of the node, none are emitted when unset.
+	Recorder record.EventRecorder
+	// ExportCapabilities maintains a ConfigMap summarizing the profiles and free capacity of the node.
+	ExportCapabilities bool
+	// PublishResourceSlices advertises the slices the GPUs of the node can carve as DRA devices in ResourceSlices.
+	PublishResourceSlices bool
+	// CacheProfiles keeps the discovered profiles in a ConfigMap and reuses them on startup while the GPUs of
+	// the node stay the same.
+	CacheProfiles bool
+	// SliceOperationPolicy tells how often and when the slices of the node may be created or destroyed, they
+	// may be at any time when unset.
+	SliceOperationPolicy SliceOperationPolicy
+	// SliceMetricsInterval is how often the utilization and memory usage of the slices of the node are sampled
+	// and published as metrics. Slices are not sampled when unset.
+	SliceMetricsInterval time.Duration
+	// CapacityFailClosed keeps allocations in creating until the device plugin was asked to advertise their slices,
+	// a failed capacity update is retried instead of leaving created slices unadvertised. Batches of slices are
+	// marked created before the capacity is updated when unset.
+	CapacityFailClosed bool
+	// NvmlLibraryPaths are searched in order for the NVML library, common host and driver container locations
+	// are searched when unset.
+	NvmlLibraryPaths []string
+	// SnapshotPath is the file the allocations, prepared slices and free capacity of the node are written to
+	// after every reconcile, for offline debugging. No snapshot is taken when unset.
+	SnapshotPath string
+	// ReclaimOrphanSlices destroys the slices found on the GPUs that no allocation holds once they stayed orphaned
+	// for OrphanSliceGracePeriod, DefaultOrphanSliceGracePeriod when unset. Orphaned slices are only reported in
+	// the OrphanedSlices condition and the logs when unset.
+	ReclaimOrphanSlices    bool
+	OrphanSliceGracePeriod time.Duration
+	// OperatorVersion is recorded on the slices the daemonset carves, so that the versions of the operator running
+	// side by side during a rollout leave the slices of each other alone. DefaultOperatorVersion when unset.
+	OperatorVersion string
+	// MinDriverVersion is the oldest driver the node may run, discovery marks the instaslice degraded and stops
+	// on an older one. Any driver is accepted when unset.
+	MinDriverVersion string
+	// APIReader reads the node from the API server when the cached copy may be stale, e.g. to check that a
+	// resource is really gone after a patch removing it failed. The cached client is used when unset.
+	APIReader client.Reader
+	// CorrectCapacityDrift removes from the node capacity the per pod resources of pods without a slice, on top of
+	// restoring the missing ones of realized slices. Stale resources are left alone when unset.
+	CorrectCapacityDrift bool
+	// SliceCreationRetryPolicy bounds and spaces the attempts to carve the slice of an allocation, the config
+	// ConfigMap overriding each of its settings.
+	SliceCreationRetryPolicy SliceCreationRetryPolicy
+	// BestEffortDiscovery skips the GPUs NVML fails on during discovery rather than failing it, the others are
+	// advertised and the skipped ones listed in the PartiallyDiscovered condition. Any failure stops discovery
+	// when unset.
+	BestEffortDiscovery bool
+	// ManagedGPUs lists the indexes or UUIDs of the GPUs of the node the daemonset manages, e.g. to leave some to
+	// other systems. The other GPUs are left out of discovery, no slice is carved, tracked or advertised on them.
+	// Every GPU is managed when unset.
+	ManagedGPUs []string
+	// IdleGPUPolicy is what is done with a GPU once the last slice on it is torn down, IdleGPUPolicyLeave when
+	// unset. IdleGPUPolicies overrides it for the GPUs of the given UUIDs.
+	IdleGPUPolicy   IdleGPUPolicy
+	IdleGPUPolicies map[string]IdleGPUPolicy
+	// IdleCriterion tells when the slice of an allocation past its TTL is idle and reaped, IdleCriterionPodCompleted
+	// when unset.
+	IdleCriterion IdleCriterion
+	// ConfigMapNamespacePolicy is where the ConfigMaps of pods are kept, ConfigMapNamespacePod when unset.
+	// OperatorNamespace is the namespace of the operator, the instaslice of the node and the ConfigMaps of the
+	// operator are kept in it, and so are the ones of pods under ConfigMapNamespaceOperator. DefaultOperatorNamespace
+	// when unset.
+	ConfigMapNamespacePolicy ConfigMapNamespacePolicy
+	OperatorNamespace        string
+	// MigDeviceFormat is how ConfigMaps reference the MIG devices of the slices, MigDeviceFormatUUID when unset.
+	MigDeviceFormat MigDeviceFormat
+	// XidPollInterval is how often the Xid errors NVML reports for the GPUs are collected, never when unset.
+	// XidErrorThreshold is the number of Xid errors of a GPU from which the instaslice is marked degraded, never
+	// when unset.
+	XidPollInterval   time.Duration
+	XidErrorThreshold int
+	// QuarantineInvalidPrepared moves the prepared entries breaking their invariants out of status.prepared instead of
+	// only flagging them.
+	QuarantineInvalidPrepared bool
+	// CheckConfigMapReferences warns in an event on the pod when none of its containers reads the ConfigMap created
+	// for its slice. Pods are not checked when unset.
+	CheckConfigMapReferences bool
+	// FullReconcileInterval is how often all the allocations and prepared slices of the node are reconciled
+	// against the hardware without an event, never when unset.
+	FullReconcileInterval time.Duration
+	// ConfigConfigMap is the name of the ConfigMap in OperatorNamespace whose configuration overrides some of
+	// the settings above while the daemonset runs, see Config. None is watched when unset.
+	ConfigConfigMap string
+	// StuckAllocationThreshold is how long an allocation stays creating or deleting before it is counted in the
+	// instaslice_stuck_allocations gauge, allocations are not counted when unset.
+	StuckAllocationThreshold time.Duration
+	// LogAllocationTransitions writes a JSON line to stdout whenever an allocation of the node is created, fails or
+	// is deleted, for log pipelines, see AllocationTransition.
+	LogAllocationTransitions bool
+	// StuckPodThreshold is how long the pod of a realized slice may be unable to start, e.g. stuck in
+	// ContainerCreating, before its slice is verified and then reclaimed. Stuck pods are left alone when unset.
+	StuckPodThreshold time.Duration
+	// CreationHistoryLength is how many of the latest attempts to carve the slices of the node are kept in the
+	// CreationHistoryAnnotation of the instaslice, none are recorded when unset.
+	CreationHistoryLength int
+	// TracerProvider provides the tracer of the spans of the carving and the destruction of slices, none are
+	// recorded when unset.
+	TracerProvider trace.TracerProvider
+	// MaxParallelGPUs is how many GPUs of the node the slices of a batch are carved on at once, the slices of a
+	// GPU are always carved one after the other. GPUs are handled one at a time when unset.
+	MaxParallelGPUs int
+	// all NVML calls go through this handler, it talks to the real library unless one is injected.
+	nvmlHandler *deviceHandler
+	// rates the slice operations when the Rate of SliceOperationPolicy is set.
+	sliceOperations     *rate.Limiter
+	sliceOperationsOnce sync.Once
+	// set while NVML cannot be initialized, reconciles wait for it rather than failing every NVML call.
+	nvmlNotReady atomic.Bool
+	// set while the daemonset holds the NVML session every NVML call is made in.
+	nvmlInitialized atomic.Bool
+	// set once a reconcile ran into an invalidated NVML handle, the next one opens a new NVML session first.
+	nvmlReinit atomic.Bool
+	// queues the full reconciles requested every FullReconcileInterval or for slices lost on the GPUs.
+	fullReconcileEvents chan event.GenericEvent
+	// set while a requested full reconcile waits for the next reconcile to run it.
+	fullReconcilePending atomic.Bool
+	// the GPUs registered for Xid errors and the errors counted per GPU since the daemonset started.
+	xidEvents nvml.EventSet
+	xidErrors map[string]int
+	// failed attempts to carve the slice of each pod, spacing and bounding the next ones.
+	creationFailures   map[string]int
+	creationFailuresMu sync.Mutex
+	// attempts to destroy the slices of each pod that found them in use, spacing the next ones.
+	sliceInUseRetries map[string]int
+	sliceInUseMu      sync.Mutex
+	// serializes the capacity updates of the GPUs of a batch carved at once, each reads then patches the node.
+	capacityMu sync.Mutex
+	// indexes of the GPUs BestEffortDiscovery skipped and of the unmanaged ones, left out of the rest of discovery.
+	undiscoveredGPUs map[int]bool
+	// the configuration last loaded from ConfigConfigMap, nil while there is none.
+	config atomic.Pointer[Config]
+	// when each slice held by no allocation was first seen orphaned, keyed by MIG UUID.
+	orphanSlices   map[string]time.Time
+	orphanSlicesMu sync.Mutex
+	// the driver version of the node NVML reported at the latest discovery, empty until it did.
+	driverVersion atomic.Value
+	// the transitional status each allocation was first seen in, timing the stuck ones.
+	transitions   map[string]allocationTransition
+	transitionsMu sync.Mutex
+	// the allocations seen by the last reconcile, telling what changed since then.
+	lastAllocations   map[string]inferencev1alpha1.AllocationDetails
+	lastAllocationsMu sync.Mutex
+	// when the slice of each stuck pod was found sound, reclaiming it if the pod still does not start.
+	stuckPodsVerified map[string]time.Time
+	stuckPodsMu       sync.Mutex
+	// the status each allocation was last seen in or set to, and the ones the last reconcile found in an
+	// illegal transition from it.
+	allocationStatuses   map[string]inferencev1alpha1.AllocationStatus
+	illegalTransitions   map[string]bool
+	allocationStatusesMu sync.Mutex
+	// the device plugin config derived from the GPU models found at discovery, nil before it.
+	devicePluginConfig atomic.Pointer[devicePluginBaseConfig]
+	// the UIDs of the pods deleted since the last reconcile, whose slices are reclaimed by the next one.
+	removedPods   map[string]bool
+	removedPodsMu sync.Mutex
+	// the slices carved for pods until their allocations are recorded created, and the retained ones.
+	slices sliceCache
 }
 
 //+kubebuilder:rbac:groups=inference.codeflare.dev,resources=instaslices,verbs=get;list;watch;create;update;patch;delete
@@ -55,6 +240,10 @@ type InstaSliceDaemonsetReconciler struct {
 //+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=nodes/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=resource.k8s.io,resources=resourceslices,verbs=get;list;watch;create;update;delete
 
 var discoveredGpusOnHost []string
 
@@ -64,6 +253,22 @@ type deviceHandler struct {
 	nvml     nvml.Interface
 }
 
+// newDeviceHandler builds a handler making NVML calls through the given library.
+func newDeviceHandler(nvmllib nvml.Interface) *deviceHandler {
+	return &deviceHandler{
+		nvml:     nvmllib,
+		nvdevice: nvdevice.New(nvdevice.WithNvml(nvmllib)),
+	}
+}
+
+// handler returns the handler used for NVML calls, falling back to the real library when none was set.
+func (r *InstaSliceDaemonsetReconciler) handler() *deviceHandler {
+	if r.nvmlHandler != nil {
+		return r.nvmlHandler
+	}
+	return newDeviceHandler(nvml.New())
+}
+
 // this struct is created to represent profiles
 // in human readable format and perform string comparison
 // NVML provides int values which are hard to interpret.
@@ -86,290 +291,542 @@ type ResPatchOperation struct {
 const (
 	// media extension MIG profile attribute
 	AttributeMediaExtensions = "me"
+	// prefix of the MIG profile attribute naming the compute instance engine profile, e.g. eng1, omitted for
+	// engines shared within the gpu instance
+	AttributeEngineProfilePrefix = "eng"
 )
 
+// ConfigMapFinalizer keeps the ConfigMap of a pod until the slice it maps is torn down.
+const ConfigMapFinalizer = "org.instaslice/configmap"
+
 // struct to get ci and gi after a mig has been created.
 type preparedMig struct {
 	gid     uint32
 	miguuid string
 	cid     uint32
+	// time NVML took to create the gi and ci
+	creationDuration time.Duration
+	// memory of the gi as reported by NVML
+	memorySizeMB uint64
+	// every ci of the gi ordered by ci id, only set when the allocation asked for more than one
+	computeInstances []preparedComputeInstance
+	// the gi was carved at start rather than at the placement of the allocation, which was taken
+	relocated bool
+	start     uint32
+}
+
+// a ci carved in the gi of a slice and the MIG device backing it.
+type preparedComputeInstance struct {
+	cid     uint32
+	miguuid string
+}
+
+// migDevices returns the ci of the slice and their MIG UUIDs, the first one being the one of gid, cid and miguuid.
+func (p preparedMig) migDevices() []preparedComputeInstance {
+	if len(p.computeInstances) == 0 {
+		return []preparedComputeInstance{{cid: p.cid, miguuid: p.miguuid}}
+	}
+	return p.computeInstances
 }
 
-// TODO: remove once we figure out NVML calls that does CI and GI discovery
-var cachedPreparedMig = make(map[string]preparedMig)
+// migUUIDs returns the MIG UUIDs of the slice ordered by ci id.
+func (p preparedMig) migUUIDs() []string {
+	var migUUIDs []string
+	for _, ci := range p.migDevices() {
+		migUUIDs = append(migUUIDs, ci.miguuid)
+	}
+	return migUUIDs
+}
+
+// visibleDevices returns the MIG UUIDs of the slice as a pod consumes them.
+func (p preparedMig) visibleDevices() string {
+	return strings.Join(p.migUUIDs(), ",")
+}
+
+// formatVisibleDevices returns the NVIDIA_VISIBLE_DEVICES value of a pod given the MIG UUIDs of its slice: the
+// UUIDs separated by commas, without spaces, in the order given so that device indexes in the container follow
+// the ci ids. Empty and repeated UUIDs are refused, the container runtime would reject the value.
+func formatVisibleDevices(migUUIDs []string) (string, error) {
+	if len(migUUIDs) == 0 {
+		return "", fmt.Errorf("no MIG UUID to expose")
+	}
+	seen := make(map[string]bool, len(migUUIDs))
+	formatted := make([]string, 0, len(migUUIDs))
+	for i, migUUID := range migUUIDs {
+		migUUID = strings.TrimSpace(migUUID)
+		if migUUID == "" {
+			return "", fmt.Errorf("MIG UUID %d of %d is empty", i+1, len(migUUIDs))
+		}
+		if strings.Contains(migUUID, ",") {
+			return "", fmt.Errorf("MIG UUID %q contains a comma", migUUID)
+		}
+		if seen[migUUID] {
+			return "", fmt.Errorf("MIG UUID %s is listed twice", migUUID)
+		}
+		seen[migUUID] = true
+		formatted = append(formatted, migUUID)
+	}
+	return strings.Join(formatted, ","), nil
+}
+
+// computeInstanceCount returns how many ci the gi of the allocation is split into.
+func computeInstanceCount(allocation inferencev1alpha1.AllocationDetails) int {
+	if allocation.ComputeInstances < 1 {
+		return 1
+	}
+	return allocation.ComputeInstances
+}
+
+// reconcileHeartbeatInterval makes idle nodes reconcile periodically so that LastReconcileTime only goes stale
+// when the daemonset is wedged.
+const reconcileHeartbeatInterval = 1 * time.Minute
+
+// now is swapped in tests to control the recorded reconcile time.
+var now = metav1.Now
 
 func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	// nothing can be carved or destroyed yet, the startup runnable retries NVML and reports it on the instaslice
+	if r.nvmlNotReady.Load() {
+		return ctrl.Result{RequeueAfter: nvmlNotReadyRequeueInterval}, nil
+	}
 
 	nodeName := os.Getenv("NODE_NAME")
+	// the handles of an aborted reconcile went stale, nothing is done before NVML was initialized again
+	if r.nvmlReinit.Load() && !r.reinitNVML(ctx, nodeName) {
+		return ctrl.Result{RequeueAfter: nvmlNotReadyRequeueInterval}, nil
+	}
+	if errFull := r.runRequestedFullReconcile(ctx, nodeName); errFull != nil {
+		if isNVMLHandleLost(errFull) {
+			return r.abortOnLostNVML(ctx, errFull)
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
 	nsName := types.NamespacedName{
 		Name:      nodeName,
-		Namespace: "default",
+		Namespace: r.instasliceNamespace(),
 	}
 	var instaslice inferencev1alpha1.Instaslice
 	if err := r.Get(ctx, nsName, &instaslice); err != nil {
 		log.FromContext(ctx).Error(err, "Error listing Instaslice")
+	} else {
+		if r.StuckAllocationThreshold > 0 {
+			r.observeStuckAllocations(nodeName, &instaslice)
+		}
+		if r.LogAllocationTransitions {
+			r.logAllocationTransitions(nodeName, &instaslice)
+		}
+		r.checkAllocationTransitions(ctx, &instaslice)
+		r.pruneSliceInUseRetries(&instaslice)
+	}
+	// whatever path the reconcile takes, the snapshot shows the state it left behind
+	defer r.snapshotState(ctx, nsName)
+	defer r.publishPreparedSlices(ctx, nodeName, nsName)
+
+	if errFlagging := r.flagDuplicateInstaslices(ctx, nodeName); errFlagging != nil {
+		log.FromContext(ctx).Error(errFlagging, "unable to flag the duplicates of the instaslice of the node")
+	}
+
+	// nothing is carved for a node that is gone, its capacity could not be advertised
+	if instaslice.Name != "" {
+		exists, updated, errChecking := r.checkNodeExists(ctx, &instaslice)
+		if errChecking != nil {
+			log.FromContext(ctx).Error(errChecking, "unable to check the node object")
+			return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
+		}
+		if !exists {
+			return ctrl.Result{RequeueAfter: nodeMissingRequeueInterval}, nil
+		}
+		// the node is back, carry on with the instaslice the condition was cleared on
+		if updated {
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+
+	// slices carved on a GPU waiting for a reset would fail, the controller keeps new ones off it. The GPUs are
+	// only checked before carving, the full reconcile notices the resets done meanwhile.
+	if instaslice.Status.Processed == "true" && carvingPending(&instaslice) {
+		updated, errChecking := r.recordResetPendingGPUs(ctx, &instaslice)
+		if errChecking != nil {
+			log.FromContext(ctx).Error(errChecking, "unable to check the GPUs for a pending reset")
+		}
+		if updated {
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+
+	// an operator asked to get rid of an allocation stuck in whatever state, no guard applies
+	if podUUID := instaslice.Annotations[ForceCleanupAnnotation]; podUUID != "" {
+		if errForcingCleanup := r.forceCleanUp(ctx, &instaslice, podUUID); errForcingCleanup != nil {
+			log.FromContext(ctx).Error(errForcingCleanup, "unable to force the cleanup of ", "podUUID", podUUID)
+		}
+		return ctrl.Result{Requeue: true}, nil
 	}
 
-	for _, allocations := range instaslice.Spec.Allocations {
+	// the slices of the pods deleted meanwhile are torn down like the ones the controller marked deleting
+	if r.reclaimAllocationsOfRemovedPods(ctx, &instaslice) {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// slices left behind e.g. by a crashed daemonset would hold capacity no pod can get
+	orphansChanged, errOrphans := r.reconcileOrphanSlices(ctx, &instaslice)
+	if errOrphans != nil {
+		log.FromContext(ctx).Error(errOrphans, "unable to reconcile the slices held by no allocation")
+	}
+	if orphansChanged || errOrphans != nil {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// the instaslice changed under the object read above, carry on with the latest one
+	if r.flagMismatchedAllocations(ctx, &instaslice, mismatchedAllocations(&instaslice)) {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// corrupt prepared entries are flagged, and quarantined when asked to, before anything acts on them
+	if r.checkPreparedEntries(ctx, &instaslice) {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// a driver upgrade may have dropped the profile of pending allocations, NVML would refuse them forever
+	failedAllocations, errFailing := r.failUnsupportedProfileAllocations(ctx, &instaslice)
+	if errFailing != nil {
+		log.FromContext(ctx).Error(errFailing, "unable to fail allocations of unsupported profiles")
+		return ctrl.Result{Requeue: true}, nil
+	}
+	if failedAllocations {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	if errRestoring := r.restoreInstaSliceResources(ctx, nodeName, &instaslice); errRestoring != nil {
+		log.FromContext(ctx).Error(errRestoring, "unable to restore instaslice resources in the node capacity")
+	}
+	// a slice carved again gets a new MIG UUID its pod ConfigMap must follow
+	if errCorrecting := r.correctConfigMapDrift(ctx, &instaslice); errCorrecting != nil {
+		log.FromContext(ctx).Error(errCorrecting, "unable to correct the ConfigMaps of the slices")
+	}
+	// a ConfigMap deleted under a realized slice leaves its pod without a device
+	restored, errRestoring := r.restoreMissingConfigMaps(ctx, nodeName, &instaslice)
+	if errRestoring != nil {
+		log.FromContext(ctx).Error(errRestoring, "unable to restore the missing ConfigMaps of the slices")
+	}
+	if restored {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	// pods unable to start on their slice get it verified, and give it back when that does not help
+	if r.StuckPodThreshold > 0 {
+		reclaimed, errReclaiming := r.reclaimSlicesOfStuckPods(ctx, nodeName, &instaslice)
+		if errReclaiming != nil {
+			log.FromContext(ctx).Error(errReclaiming, "unable to reclaim the slices of stuck pods")
+		}
+		if reclaimed {
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+
+	// GPUs whose slices differ from their desired layout are carved again once no pod holds them
+	reconfigured, errReconfiguring := r.reconcileLayouts(ctx, nodeName, &instaslice)
+	if errReconfiguring != nil {
+		log.FromContext(ctx).Error(errReconfiguring, "unable to carve GPUs in their desired layout")
+		return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
+	}
+	if reconfigured {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// idle slices of the pools are carved ahead of the pods taking them over
+	pooled, errPooling := r.fillSlicePools(ctx, nodeName, &instaslice)
+	if retryAfter, throttled := sliceOperationRetryAfter(errPooling); throttled {
+		log.FromContext(ctx).Info("deferring slices of pools over the allowed rate", "after", retryAfter)
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
+	}
+	if errPooling != nil {
+		log.FromContext(ctx).Error(errPooling, "unable to carve the slices of the pools")
+		return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
+	}
+	if pooled {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// slices past their TTL are torn down like the ones of deleted pods once idle
+	expired, errExpiring := r.expireIdleAllocations(ctx, nodeName, &instaslice)
+	if errExpiring != nil {
+		log.FromContext(ctx).Error(errExpiring, "unable to expire idle allocations")
+	}
+	if expired {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// updates touching only realized slices, e.g. the controller ungating a pod, leave nothing to do
+	if !hasTransitionalAllocations(&instaslice) {
+		if errRecordingReconcileTime := r.recordReconcileTime(ctx, nsName); errRecordingReconcileTime != nil {
+			log.FromContext(ctx).Error(errRecordingReconcileTime, "unable to record last reconcile time")
+		}
+		return ctrl.Result{RequeueAfter: reconcileHeartbeatInterval}, nil
+	}
+
+	// many pending slices are created together, saving a round trip to the API server per slice
+	batched, errCreatingBatch := r.createSlicesInBatch(ctx, nodeName, &instaslice)
+	if retryAfter, throttled := sliceOperationRetryAfter(errCreatingBatch); throttled {
+		log.FromContext(ctx).Info("deferring slices of the batch over the allowed rate", "after", retryAfter)
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
+	}
+	if isNVMLHandleLost(errCreatingBatch) {
+		return r.abortOnLostNVML(ctx, errCreatingBatch)
+	}
+	if errCreatingBatch != nil {
+		log.FromContext(ctx).Error(errCreatingBatch, "error creating slices in batch")
+		return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
+	}
+	if batched {
+		if err := r.Get(ctx, nsName, &instaslice); err != nil {
+			log.FromContext(ctx).Error(err, "error getting latest instaslice object")
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+
+	for key, allocations := range instaslice.Spec.Allocations {
+		if settledAllocation(allocations) || allocations.Allocationstatus == inferencev1alpha1.AllocationStatusFailed || key != allocations.PodUUID {
+			continue
+		}
+		if r.illegalTransition(key) {
+			continue
+		}
+		// slices of the pools are carved above, they have no pod to set up
+		if isPoolAllocation(allocations) && allocations.Allocationstatus == inferencev1alpha1.AllocationStatusCreating {
+			continue
+		}
 		//TODO: we make assumption that resources would always exists to delete
 		// if user deletes abruptly, cm, instaslice resource, ci and gi may not exists
 		// handle such scenario's.
 		// delete first before creating new slice
-		if allocations.Allocationstatus == "deleting" {
+		// preempted slices are torn down like the ones of deleted pods to make room for the preempting pod
+		if allocations.Allocationstatus == inferencev1alpha1.AllocationStatusDeleting || allocations.Allocationstatus == inferencev1alpha1.AllocationStatusPreempted {
 			log.FromContext(ctx).Info("Performing cleanup ", "pod", allocations.PodName)
-			if errDeletingCm := r.deleteConfigMap(ctx, allocations.PodName, allocations.Namespace); errDeletingCm != nil {
+			if errDeletingCm := r.deleteConfigMap(ctx, allocations.PodName, allocations.Namespace, allocations.PodUUID); errDeletingCm != nil {
 				log.FromContext(ctx).Error(errDeletingCm, "error deleting configmap for ", "pod", allocations.PodName)
 				return ctrl.Result{Requeue: true}, nil
 			}
 
-			if errDeletingInstaSliceResource := r.cleanUpInstaSliceResource(ctx, allocations.PodName); errDeletingInstaSliceResource != nil {
+			if errDeletingInstaSliceResource := r.cleanUpInstaSliceResource(ctx, allocations); errDeletingInstaSliceResource != nil {
 				log.FromContext(ctx).Error(errDeletingInstaSliceResource, "Error deleting InstaSlice resource object")
 				return ctrl.Result{Requeue: true}, nil
 			}
 
-			nodeName := os.Getenv("NODE_NAME")
 			if errUpdatingNodeCapacity := r.updateNodeCapacity(ctx, nodeName); errUpdatingNodeCapacity != nil {
 				return ctrl.Result{Requeue: true}, nil
 			}
-			deletePrepared, errDeletingCiorGi := r.cleanUpCiAndGi(ctx, allocations.PodUUID, instaslice)
-			if errDeletingCiorGi != nil {
-				// deleting CI and GI is important
-				//avoid "error" : "Invalid Argument"
-				// which means that slice was deleted in previous reconcile logic and controller is trying to
-				// delete an non-existent slice
-				if errDeletingCiorGi.Error() != "Invalid Argument" {
-					log.FromContext(ctx).Error(errDeletingCiorGi, "error deleting ci or gi for ", "pod", allocations.PodName)
-					return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
+			// the slice of a deleted pod goes at any time, a preempted one waits for the maintenance window
+			reserve := r.reserveSliceOperation
+			if allocations.Allocationstatus == inferencev1alpha1.AllocationStatusPreempted {
+				reserve = r.reserveDeferrableSliceOperation
+			}
+			if errThrottled := reserve(); errThrottled != nil {
+				retryAfter, _ := sliceOperationRetryAfter(errThrottled)
+				log.FromContext(ctx).Info("deferring slice deletion for ", "pod", allocations.PodName, "after", retryAfter)
+				return ctrl.Result{RequeueAfter: retryAfter}, nil
+			}
+			if errCleaningUp := r.cleanUp(ctx, allocations.PodUUID); errCleaningUp != nil {
+				// a process still holds the slice, keep deleting until it lets go
+				if isSliceInUse(errCleaningUp) {
+					retryAfter := r.sliceInUseBackoff(allocations.PodUUID)
+					log.FromContext(ctx).Info("slice is still in use, retrying deletion for ", "pod", allocations.PodName, "after", retryAfter)
+					return ctrl.Result{RequeueAfter: retryAfter}, nil
 				}
-
+				log.FromContext(ctx).Error(errCleaningUp, "error deleting ci or gi for ", "pod", allocations.PodName)
+				return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
 			}
 			log.FromContext(ctx).Info("Done deleting ci and gi for ", "pod", allocations.PodName)
-			delete(cachedPreparedMig, allocations.PodName)
-			//TODO: could be merged with the creating call above
-			var updateInstasliceObject inferencev1alpha1.Instaslice
-			typeNamespacedName := types.NamespacedName{
-				Name:      instaslice.Name,
-				Namespace: "default", // TODO: modify
+			continue
+		}
+		// keep the slice of a completed pod for the next pod, destroy it once it stayed idle for too long
+		if allocations.Allocationstatus == inferencev1alpha1.AllocationStatusRetained {
+			var migUUID string
+			for uuid, prepared := range instaslice.Status.Prepared {
+				if prepared.PodUUID == allocations.PodUUID {
+					migUUID = uuid
+				}
 			}
-			err := r.Get(ctx, typeNamespacedName, &updateInstasliceObject)
-			if err != nil {
-				log.FromContext(ctx).Error(err, "error getting latest instaslice object")
+			if errReleasing := r.releaseRetainedSlice(ctx, nodeName, &instaslice, allocations, migUUID); errReleasing != nil {
+				log.FromContext(ctx).Error(errReleasing, "error releasing retained slice of ", "pod", allocations.PodName)
 				return ctrl.Result{Requeue: true}, nil
 			}
-			// previous reconcile loop might have deleted prepared
-			// so we need to search the MIG UUID in prepared section
-			if deletePrepared != "" {
-				delete(updateInstasliceObject.Spec.Prepared, deletePrepared)
-			} else {
-				var searchToDelPrepared string
-				for migUuid, v := range updateInstasliceObject.Spec.Prepared {
-					if v.PodUUID == allocations.PodUUID {
-						searchToDelPrepared = migUuid
+			// an idle slice disrupts nothing, it waits for the maintenance window to be destroyed
+			if _, outside := r.outsideMaintenanceWindow(); !outside && retainedSliceExpired(&instaslice, allocations) {
+				log.FromContext(ctx).Info("retained slice expired, deleting slice of ", "pod", allocations.PodName)
+				var updateInstasliceObject inferencev1alpha1.Instaslice
+				typeNamespacedName := types.NamespacedName{
+					Name:      instaslice.Name,
+					Namespace: r.instasliceNamespace(),
+				}
+				if err := r.Get(ctx, typeNamespacedName, &updateInstasliceObject); err != nil {
+					log.FromContext(ctx).Error(err, "error getting latest instaslice object")
+					return ctrl.Result{Requeue: true}, nil
+				}
+				// a new allocation may have claimed the slice meanwhile
+				if latest, exists := updateInstasliceObject.Spec.Allocations[allocations.PodUUID]; exists && latest.Allocationstatus == inferencev1alpha1.AllocationStatusRetained && retainedSliceExpired(&updateInstasliceObject, latest) {
+					latest.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+					updateInstasliceObject.Spec.Allocations[allocations.PodUUID] = latest
+					if err := r.Update(ctx, &updateInstasliceObject); err != nil {
+						log.FromContext(ctx).Error(err, "error expiring retained slice of ", "pod", allocations.PodName)
+						return ctrl.Result{Requeue: true}, nil
 					}
 				}
-				delete(updateInstasliceObject.Spec.Prepared, searchToDelPrepared)
-			}
-
-			allocations.Allocationstatus = "deleted"
-			updateInstasliceObject.Spec.Allocations[allocations.PodUUID] = allocations
-			errUpdatingAllocation := r.Update(ctx, &updateInstasliceObject)
-			if errUpdatingAllocation != nil {
-				log.FromContext(ctx).Error(errUpdatingAllocation, "Error updating InstaSlice object for ", "pod", allocations.PodName)
-				return ctrl.Result{Requeue: true}, nil
 			}
 		}
 		// create new slice by obeying controller allocation
-		if allocations.Allocationstatus == "creating" {
+		if allocations.Allocationstatus == inferencev1alpha1.AllocationStatusCreating {
+			// discovery populates Migplacement, carving before it completes would use an incomplete topology.
+			if instaslice.Status.Processed != "true" {
+				log.FromContext(ctx).Info("discovery has not completed, retrying allocation for ", "pod", allocations.PodName)
+				return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
+			}
 			//Assume pod only has one container with one GPU request
 			log.FromContext(ctx).Info("creating allocation for ", "pod", allocations.PodName)
 			var podUUID = allocations.PodUUID
-			ret := nvml.Init()
-			if ret != nvml.SUCCESS {
-				log.FromContext(ctx).Error(ret, "Unable to initialize NVML")
+			// the allocation names the GPU, profile and placement of its slice, no other allocation is looked at
+			profileName := allocations.Profile
+			// no GPU of the node would match, skip asking NVML for it
+			if _, exists := instaslice.Spec.MigGPUUUID[allocations.GPUUUID]; allocations.GPUUUID == "" || !exists {
+				log.FromContext(ctx).Info("allocation does not target a GPU of this node, retrying for ", "pod", allocations.PodName, "gpu", allocations.GPUUUID)
+				return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
 			}
-			// TODO: make function createCiAndGi and move this logic
-			var shutdownErr error
-
-			defer func() {
-				if shutdownErr = nvml.Shutdown(); shutdownErr != nvml.SUCCESS {
-					log.FromContext(ctx).Error(shutdownErr, "error to perform nvml.Shutdown")
+			if allocations.ReusedFrom != "" {
+				reused, errReusing := r.reuseRetainedSlice(ctx, nodeName, &instaslice, allocations)
+				if errReusing != nil {
+					log.FromContext(ctx).Error(errReusing, "error reusing retained slice for ", "pod", allocations.PodName)
+					return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
 				}
-			}()
-
-			availableGpus, ret := nvml.DeviceGetCount()
+				if reused {
+					continue
+				}
+			}
+			nvmllib := r.handler().nvml
+			device, ret := nvmllib.DeviceGetHandleByUUID(allocations.GPUUUID)
+			if errLost := checkNVMLHandle("DeviceGetHandleByUUID", ret); errLost != nil {
+				return r.abortOnLostNVML(ctx, errLost)
+			}
 			if ret != nvml.SUCCESS {
-				log.FromContext(ctx).Error(ret, "Unable to get device count")
+				log.FromContext(ctx).Error(ret, "error getting GPU device handle, retrying allocation for ", "pod", allocations.PodName, "gpu", allocations.GPUUUID)
+				return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
 			}
-
-			if errCreatingInstaSliceResource := r.createInstaSliceResource(ctx, nodeName, allocations.PodName); errCreatingInstaSliceResource != nil {
-				return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
+			existingAllocations := allocations
+
+			// an earlier run may have written the prepared entries then stopped before setting the allocation to
+			// created, finish its creation with them
+			if _, exists := r.slices.cached(allocations.PodName); !exists {
+				if prepared, found := preparedSliceOf(device, &instaslice, allocations); found {
+					log.FromContext(ctx).Info("slice already prepared, finishing its creation for ", "pod", allocations.PodName, "migUUID", prepared.migUUIDs())
+					r.slices.cache(allocations.PodName, prepared)
+				}
 			}
+			//TODO: any GPU can fail creating CI and GI
+			if _, exists := r.slices.cached(allocations.PodName); !exists {
+				log.FromContext(ctx).Info("Slice does not exists on GPU for ", "pod", allocations.PodName)
 
-			deviceForMig, profileName, Giprofileid, Ciprofileid, CiEngProfileid, errGettingControllerAllocation := r.getAllocation(instaslice, allocations.PodUUID)
-			if errGettingControllerAllocation != nil {
-				log.FromContext(ctx).Error(errGettingControllerAllocation, "allocation was not found, retrying will not help")
-				return ctrl.Result{}, nil
-			}
-			placement := nvml.GpuInstancePlacement{}
-			for i := 0; i < availableGpus; i++ {
-				existingAllocations := instaslice.Spec.Allocations[podUUID]
+				log.FromContext(ctx).Info("The profile id is", "giProfileId", allocations.Giprofileid, "pod", podUUID)
 
-				device, ret := nvml.DeviceGetHandleByIndex(i)
-				if ret != nvml.SUCCESS {
-					log.FromContext(ctx).Error(ret, "Unable to get device at index")
+				updatedPlacement, err := allocationPlacement(&instaslice, allocations)
+				if err != nil {
+					// this should never happen, if it does then there is an issue with controller accounting logic
+					log.FromContext(ctx).Error(err, "prepared already exists for ", "pod", allocations.PodName)
+					return ctrl.Result{}, nil
 				}
-
-				uuid, ret := device.GetUUID()
-				if ret != nvml.SUCCESS {
-					log.FromContext(ctx).Error(ret, "Unable to get uuid of device at index")
+				if errThrottled := r.reserveDeferrableSliceOperation(); errThrottled != nil {
+					retryAfter, _ := sliceOperationRetryAfter(errThrottled)
+					log.FromContext(ctx).Info("deferring slice creation for ", "pod", allocations.PodName, "after", retryAfter)
+					return ctrl.Result{RequeueAfter: retryAfter}, nil
 				}
-				if deviceForMig != uuid {
-					continue
+				createdSlice, errCarving := r.carveSliceWithIntent(ctx, device, instaslice, allocations, updatedPlacement)
+				if retryAfter, deferred := sliceOperationRetryAfter(errCarving); deferred {
+					log.FromContext(ctx).Info("deferring slice creation for ", "pod", allocations.PodName, "after", retryAfter, "reason", errCarving.Error())
+					return ctrl.Result{RequeueAfter: retryAfter}, nil
 				}
-
-				//Move to next GPU as this is not the selected GPU by the controller.
-				if allocations.GPUUUID != uuid {
-					continue
+				if isNVMLHandleLost(errCarving) {
+					return r.abortOnLostNVML(ctx, errCarving)
 				}
-				//TODO: any GPU can fail creating CI and GI
-				if _, exists := cachedPreparedMig[allocations.PodName]; !exists {
-					var giInfo nvml.GpuInstanceInfo
-					log.FromContext(ctx).Info("Slice does not exists on GPU for ", "pod", allocations.PodName)
-
-					device, retCodeForDevice := nvml.DeviceGetHandleByUUID(uuid)
-
-					if retCodeForDevice != nvml.SUCCESS {
-						log.FromContext(ctx).Error(ret, "error getting GPU device handle")
-					}
-
-					giProfileInfo, retCodeForGi := device.GetGpuInstanceProfileInfo(Giprofileid)
-					if retCodeForGi != nvml.SUCCESS {
-						log.FromContext(ctx).Error(retCodeForGi, "error getting GPU instance profile info", "giProfileInfo", giProfileInfo, "retCodeForGi", retCodeForGi)
-					}
-
-					log.FromContext(ctx).Info("The profile id is", "giProfileInfo", giProfileInfo.Id, "Memory", giProfileInfo.MemorySizeMB, "pod", podUUID)
-
-					updatedPlacement, err := r.getAllocationsToprepare(ctx, placement, instaslice, allocations.PodUUID)
-					if err != nil {
-						// this should never happen, if it does then there is an issue with controller accounting logic
-						log.FromContext(ctx).Error(err, "prepared already exists for ", "pod", allocations.PodName)
+				if errCarving != nil {
+					log.FromContext(ctx).Error(errCarving, "error creating slice for ", "pod", allocations.PodName)
+					if gaveUp, errFailing := r.sliceCreationFailed(ctx, instaslice.Name, allocations, errCarving); gaveUp && errFailing == nil {
 						return ctrl.Result{}, nil
 					}
-					var gi nvml.GpuInstance
-					var retCodeForGiWithPlacement nvml.Return
-					gi, retCodeForGiWithPlacement = device.CreateGpuInstanceWithPlacement(&giProfileInfo, &updatedPlacement)
-					if retCodeForGiWithPlacement != nvml.SUCCESS {
-						//TODO: dont see it yet, should we handle Invalid Argument error?
-						// avoid "error": "Insufficient Resources",
-						// which means that previous GI was not deleted and hence daemonset is unable to
-						// recreate MIG on the same index. this will cause slice to not get realized and
-						// workload would never run.
-
-						if retCodeForGiWithPlacement.Error() != "Insufficient Resources" {
-							gi, err := r.searchGi(ctx, device, instaslice)
-							if err != nil {
-								log.FromContext(ctx).Error(err, "gi not found after searching not retrying")
-							} else {
-								log.FromContext(ctx).Info("found an gi that does not exists in prepared section yet with ", "value", gi)
-							}
-
-						} else {
-							log.FromContext(ctx).Error(retCodeForGiWithPlacement, "gi not created yet retrying")
-							return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
-						}
-
-						log.FromContext(ctx).Error(retCodeForGiWithPlacement, "error creating gi for ", "pod", allocations.PodName)
-
-						return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
-					}
-					giInfo, retForGiInfor := gi.GetInfo()
-					if retForGiInfor != nvml.SUCCESS {
-						log.FromContext(ctx).Error(retForGiInfor, "error getting GPU instance info for ", "giInfo", &giInfo)
-
-					}
-					//TODO: figure out the compute slice scenario, I think Kubernetes does not support this use case yet
-					ciProfileInfo, retCodeForCiProfile := gi.GetComputeInstanceProfileInfo(Ciprofileid, CiEngProfileid)
-					if retCodeForCiProfile != nvml.SUCCESS {
-						//TODO: clean up GI and then return or may be re-use since we have the logic
-						log.FromContext(ctx).Error(retCodeForGiWithPlacement, "error creating ci since gi might have failed for ", "pod", allocations.PodName)
-						return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
-					}
-					ci, retCodeForComputeInstance := gi.CreateComputeInstance(&ciProfileInfo)
-					if retCodeForComputeInstance != nvml.SUCCESS {
-						log.FromContext(ctx).Error(retCodeForComputeInstance, "error creating Compute instance for ", "ci", ci)
-					}
-
-					//get created mig details
-					giId, migUUID, ciId, errGettingSliceDetails := r.getCreatedSliceDetails(ctx, giInfo, ret, device, uuid, profileName)
-					if errGettingSliceDetails != nil {
-						//TODO: should we retry?
-						log.FromContext(ctx).Error(errGettingSliceDetails, "slice details not found in prepared section", "pod", allocations.PodName)
-					}
-					//add ci and gi values to cache so that we avoid re-creating. if ci or gi creation fails, we need to clean up.
-					cachedPreparedMig[allocations.PodName] = preparedMig{gid: giId, miguuid: migUUID, cid: ciId}
+					return ctrl.Result{RequeueAfter: r.sliceCreationRetryAfter(allocations.PodUUID)}, nil
 				}
+				r.clearSliceCreationFailures(allocations.PodUUID)
+				//add ci and gi values to cache so that we avoid re-creating. if ci or gi creation fails, we need to clean up.
+				r.slices.cache(allocations.PodName, createdSlice)
+			}
 
-				createdSliceDetails := cachedPreparedMig[allocations.PodName]
-				//log.FromContext(ctx).Info("The created cache details loaded are", "pod name", allocations.PodName, "slice details", createdSliceDetails)
-				//making sure that ci, gi and migUUID are not nil or dafault for the target pod.
-				if createdSliceDetails.miguuid != "" {
-
-					if errCreatingConfigMap := r.createConfigMap(ctx, createdSliceDetails.miguuid, existingAllocations.Namespace, existingAllocations.PodName); errCreatingConfigMap != nil {
-						return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
-					}
+			createdSliceDetails, _ := r.slices.cached(allocations.PodName)
+			//log.FromContext(ctx).Info("The created cache details loaded are", "pod name", allocations.PodName, "slice details", createdSliceDetails)
+			// the allocation stays in creating until the MIG device of its slice is known
+			if createdSliceDetails.miguuid == "" {
+				log.FromContext(ctx).Error(errSliceNotRealized, "slice is not realized, retrying allocation for ", "pod", allocations.PodName)
+				r.slices.forget(allocations.PodName)
+				return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
+			}
 
-					if errAddingPrepared := r.createPreparedEntry(ctx, profileName, podUUID, allocations.GPUUUID, createdSliceDetails.gid, createdSliceDetails.cid, &instaslice, createdSliceDetails.miguuid); errAddingPrepared != nil {
-						return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
-					}
-					nodeName := os.Getenv("NODE_NAME")
-					if errUpdatingNodeCapacity := r.updateNodeCapacity(ctx, nodeName); errUpdatingNodeCapacity != nil {
-						return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
-					}
-					var updateInstasliceObject inferencev1alpha1.Instaslice
-					typeNamespacedName := types.NamespacedName{
-						Name:      instaslice.Name,
-						Namespace: "default", // TODO: modify
-					}
+			if errCreatingConfigMap := r.createConfigMap(ctx, createdSliceDetails.migUUIDs(), existingAllocations.Namespace, existingAllocations.PodName, existingAllocations.PodUUID, profileName, createdSliceDetails.memorySizeMB); errCreatingConfigMap != nil {
+				return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
+			}
 
-					//TODO: could be merged with the deleted call below
-					err := r.Get(ctx, typeNamespacedName, &updateInstasliceObject)
-					if err != nil {
-						log.FromContext(ctx).Error(err, "error getting latest instaslice object")
-					}
-					updatedAllocation := updateInstasliceObject.Spec.Allocations[podUUID]
-					// updated object is still in creating status, chances are user has not yet deleted
-					// set status to created.
-					if updatedAllocation.Allocationstatus == existingAllocations.Allocationstatus {
-						existingAllocations.Allocationstatus = "created"
-					} else {
-						// Add the new allocation status which is not created and let the daemonset handle in next reconcile
-						log.FromContext(ctx).Info("allocation status changed for ", "pod", allocations.PodName, "status", updatedAllocation.Allocationstatus)
-						existingAllocations.Allocationstatus = updatedAllocation.Allocationstatus
-					}
-					updateInstasliceObject.Spec.Allocations[podUUID] = existingAllocations
-					errForUpdate := r.Update(ctx, &updateInstasliceObject)
-					if errForUpdate != nil {
-						log.FromContext(ctx).Error(errForUpdate, "error adding prepared statement")
-						return ctrl.Result{Requeue: true}, nil
-					}
+			// the placement of the allocation was taken, record where the slice was carved instead
+			if createdSliceDetails.relocated {
+				if errRelocating := r.recordRelocatedPlacement(ctx, &instaslice, podUUID, createdSliceDetails.start); errRelocating != nil {
+					log.FromContext(ctx).Error(errRelocating, "error recording alternate placement for ", "pod", allocations.PodName)
+					return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
 				}
+				existingAllocations.Start = createdSliceDetails.start
 			}
 
-		}
-		// delete slice
-		if allocations.Allocationstatus == "deleted" {
-			var updateInstasliceObject inferencev1alpha1.Instaslice
-			typeNamespacedName := types.NamespacedName{
-				Name:      instaslice.Name,
-				Namespace: "default", // TODO: modify
+			for _, ci := range createdSliceDetails.migDevices() {
+				if errAddingPrepared := r.createPreparedEntry(ctx, profileName, podUUID, allocations.GPUUUID, createdSliceDetails.gid, ci.cid, &instaslice, ci.miguuid); errAddingPrepared != nil {
+					return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
+				}
 			}
-			err := r.Get(ctx, typeNamespacedName, &updateInstasliceObject)
-			if err != nil {
-				log.FromContext(ctx).Error(err, "error getting latest instaslice object")
-				return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
+			// the capacity of the pod is only advertised once its slice exists
+			if errCreatingInstaSliceResource := r.createInstaSliceResource(ctx, nodeName, allocations); errCreatingInstaSliceResource != nil {
+				return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
+			}
+			if errUpdatingNodeCapacity := r.updateNodeCapacity(ctx, nodeName); errUpdatingNodeCapacity != nil {
+				return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
+			}
+			creatingStatus := existingAllocations.Allocationstatus
+			_, errForUpdate := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+				updatedAllocation := latest.Spec.Allocations[podUUID]
+				// updated object is still in creating status, chances are user has not yet deleted
+				// set status to created.
+				if updatedAllocation.Allocationstatus == creatingStatus {
+					existingAllocations.Allocationstatus = inferencev1alpha1.AllocationStatusCreated
+				} else {
+					// Add the new allocation status which is not created and let the daemonset handle in next reconcile
+					log.FromContext(ctx).Info("allocation status changed for ", "pod", allocations.PodName, "status", updatedAllocation.Allocationstatus)
+					existingAllocations.Allocationstatus = updatedAllocation.Allocationstatus
+				}
+				// the allocation may have been removed meanwhile, leaving no map to write to
+				if latest.Spec.Allocations == nil {
+					latest.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
+				}
+				latest.Spec.Allocations[podUUID] = existingAllocations
+				return nil
+			})
+			if errForUpdate != nil {
+				log.FromContext(ctx).Error(errForUpdate, "error adding prepared statement")
+				return ctrl.Result{Requeue: true}, nil
+			}
+			r.recordAllocationStatus(podUUID, existingAllocations.Allocationstatus)
+			r.recordSliceCreated(ctx, existingAllocations, createdSliceDetails.visibleDevices())
+			// the prepared entries now track the slice
+			durations := map[string]metav1.Duration{profileName: {Duration: createdSliceDetails.creationDuration}}
+			if errClearing := r.clearCarvedSliceIntents(ctx, instaslice.Name, durations, podUUID); errClearing != nil {
+				log.FromContext(ctx).Error(errClearing, "unable to clear the intent to carve the slice of ", "pod", allocations.PodName)
 			}
-			delete(updateInstasliceObject.Spec.Allocations, allocations.PodUUID)
-			errUpdatingAllocation := r.Update(ctx, &updateInstasliceObject)
+			r.recordCreationAttempts(ctx, instaslice.Name, creationAttempt(existingAllocations, nil))
+		}
+		// delete slice
+		if allocations.Allocationstatus == inferencev1alpha1.AllocationStatusDeleted {
+			_, errUpdatingAllocation := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+				delete(latest.Spec.Allocations, allocations.PodUUID)
+				return nil
+			})
 			if errUpdatingAllocation != nil {
 				log.FromContext(ctx).Error(errUpdatingAllocation, "Error updating InstaSlice object for ", "pod", allocations.PodName)
 				// deleted allocations are re-used by the controller, we can be slow to delete these
@@ -380,19 +837,65 @@ func (r *InstaSliceDaemonsetReconciler) Reconcile(ctx context.Context, req ctrl.
 
 	}
 
-	return ctrl.Result{}, nil
+	if errRecordingReconcileTime := r.recordReconcileTime(ctx, nsName); errRecordingReconcileTime != nil {
+		log.FromContext(ctx).Error(errRecordingReconcileTime, "unable to record last reconcile time")
+	}
+	return ctrl.Result{RequeueAfter: reconcileHeartbeatInterval}, nil
+}
+
+// settledAllocation reports whether the slice of the allocation is realized and the daemonset has nothing to do for it.
+func settledAllocation(allocation inferencev1alpha1.AllocationDetails) bool {
+	return allocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreated || allocation.Allocationstatus == inferencev1alpha1.AllocationStatusUngated
+}
+
+// hasTransitionalAllocations reports whether any allocation of the node still needs work from the daemonset.
+func hasTransitionalAllocations(instaslice *inferencev1alpha1.Instaslice) bool {
+	for key, allocation := range instaslice.Spec.Allocations {
+		if !settledAllocation(allocation) && allocation.Allocationstatus != inferencev1alpha1.AllocationStatusFailed && key == allocation.PodUUID {
+			return true
+		}
+	}
+	return false
+}
+
+// stores the time of the latest successful reconcile and the slice counts of the node in the instaslice status,
+// and refreshes the capabilities ConfigMap when exported.
+func (r *InstaSliceDaemonsetReconciler) recordReconcileTime(ctx context.Context, nsName types.NamespacedName) error {
+	// allocations above may have updated the object, fetch the latest version
+	var instaslice inferencev1alpha1.Instaslice
+	if err := r.Get(ctx, nsName, &instaslice); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	reconcileTime := now()
+	instaslice.Status.LastReconcileTime = &reconcileTime
+	instaslice.Status.AvailableSlices = availableSlices(&instaslice)
+	instaslice.Status.TotalSlices, instaslice.Status.UsedSlices, instaslice.Status.FreeSlices = sliceCounts(&instaslice)
+	instaslice.Status.CarvedSlices = carvedSlices(&instaslice)
+	instaslice.Status.OccupiedRanges = occupiedRanges(&instaslice)
+	instaslice.Status.MigUUIDs = allocationMigUUIDs(&instaslice)
+	instaslice.Status.AffectedPods = affectedPods(&instaslice, failingGPUs(instaslice.Status.XidErrors, r.settings().xidErrorThreshold))
+	if driverVersion, _ := r.driverVersion.Load().(string); driverVersion != "" {
+		instaslice.Status.DriverVersion = driverVersion
+	}
+	instaslice.Status.Summary = nodeSummary(&instaslice)
+	if err := r.Status().Update(ctx, &instaslice); err != nil {
+		return err
+	}
+	observeGPUState(instaslice.Status)
+	if err := r.exportCapabilities(ctx, &instaslice); err != nil {
+		return fmt.Errorf("unable to export node capabilities: %w", err)
+	}
+	return nil
 }
 
 func (r *InstaSliceDaemonsetReconciler) searchGi(ctx context.Context, device nvml.Device, instaslice inferencev1alpha1.Instaslice) (int, error) {
 	var preparedGis []uint32
 	var giError int
-	for _, prepared := range instaslice.Spec.Prepared {
+	for _, prepared := range instaslice.Status.Prepared {
 		preparedGis = append(preparedGis, prepared.Giinfoid)
 	}
 
-	h := &deviceHandler{}
-	h.nvml = nvml.New()
-	h.nvdevice = nvdevice.New(nvdevice.WithNvml(h.nvml))
+	h := r.handler()
 	nvlibParentDevice, err := h.nvdevice.NewDevice(device)
 	if err != nil {
 		log.FromContext(ctx).Error(err, "error init new device")
@@ -415,75 +918,40 @@ func (r *InstaSliceDaemonsetReconciler) searchGi(ctx context.Context, device nvm
 	return giError, fmt.Errorf("unable to search for gi")
 }
 
-// this method creates an extended resource to help scheduler place pod on the controller selected node.
-func (r *InstaSliceDaemonsetReconciler) createInstaSliceResource(ctx context.Context, nodeName string, podName string) error {
-	node := &v1.Node{}
-	if err := r.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
-		log.FromContext(ctx).Error(err, "unable to fetch Node")
-		return err
-	}
-	capacityKey := "org.instaslice/" + podName
-	//desiredCapacity := resource.MustParse("1")
-	if _, exists := node.Status.Capacity[v1.ResourceName(capacityKey)]; exists {
-		log.FromContext(ctx).Info("Node already patched with ", "capacity", capacityKey)
-		return nil
-	}
-	patchData, err := createPatchData("org.instaslice/"+podName, "1")
-	if err != nil {
-		log.FromContext(ctx).Error(err, "unable to create correct json for patching node")
-		return err
-	}
-
-	if err := r.Status().Patch(ctx, node, client.RawPatch(types.JSONPatchType, patchData)); err != nil {
-		log.FromContext(ctx).Error(err, "unable to patch Node status")
-		return err
-	}
-	return nil
+// advertises the capacity of the slice of the allocation to help scheduler place pod on the controller selected node.
+func (r *InstaSliceDaemonsetReconciler) createInstaSliceResource(ctx context.Context, nodeName string, allocation inferencev1alpha1.AllocationDetails) error {
+	r.capacityMu.Lock()
+	defer r.capacityMu.Unlock()
+	return r.capacityAdvertiser().Advertise(ctx, nodeName, allocation)
 }
 
-// controller will set allocations that need to created (prepared) on the GPU nodes.
-func (r *InstaSliceDaemonsetReconciler) getAllocationsToprepare(ctx context.Context, placement nvml.GpuInstancePlacement, instaslice inferencev1alpha1.Instaslice, podUuid string) (nvml.GpuInstancePlacement, error) {
-	allocationExists := false
-	for _, prepared := range instaslice.Spec.Prepared {
-		if prepared.PodUUID == podUuid {
-			allocationExists = true
-		}
-	}
-	for _, v := range instaslice.Spec.Allocations {
-		if !allocationExists {
-			if v.Allocationstatus == "creating" && v.PodUUID == podUuid {
-				placement.Size = v.Size
-				placement.Start = v.Start
-				return placement, nil
-			}
+// allocationPlacement returns the placement the controller chose for the slice of the allocation. It fails when a
+// slice of the pod is already prepared, it must not be carved twice.
+func allocationPlacement(instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) (nvml.GpuInstancePlacement, error) {
+	for _, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID == allocation.PodUUID {
+			return nvml.GpuInstancePlacement{}, fmt.Errorf("got prepared slice wait for object to be updated")
 		}
 	}
-	log.FromContext(ctx).Info("placement not found for ", "podUuid", podUuid)
-	return placement, fmt.Errorf("got prepared slice wait for object to be updated")
+	return nvml.GpuInstancePlacement{Start: allocation.Start, Size: allocation.Size}, nil
 }
 
 // when a slice is created we do a discovery again to get MIG uuid device details
-func (*InstaSliceDaemonsetReconciler) getCreatedSliceDetails(ctx context.Context, giInfo nvml.GpuInstanceInfo, ret nvml.Return, device nvml.Device, uuid string, profileName string) (uint32, string, uint32, error) {
+func (r *InstaSliceDaemonsetReconciler) getCreatedSliceDetails(ctx context.Context, giInfo nvml.GpuInstanceInfo, ret nvml.Return, device nvml.Device, uuid string, profileName string) (uint32, string, uint32, error) {
 	var giIdError, ciMigInfoError uint32
 	// setting large number to return error
 	giIdError = 1000
 	ciMigInfoError = 1000
 	realizedMigError := ""
-	h := &deviceHandler{}
-	h.nvml = nvml.New()
-	h.nvdevice = nvdevice.New(nvdevice.WithNvml(h.nvml))
+	h := r.handler()
 
-	ret1 := h.nvml.Init()
-	if ret1 != nvml.SUCCESS {
-		log.FromContext(ctx).Error(ret1, "Unable to initialize NVML")
-	}
 	nvlibParentDevice, err := h.nvdevice.NewDevice(device)
 	if err != nil {
-		log.FromContext(ctx).Error(err, "unable to get nvlib GPU parent device for MIG UUID")
+		return giIdError, realizedMigError, ciMigInfoError, fmt.Errorf("unable to get nvlib GPU parent device for MIG UUID: %w", err)
 	}
 	migs, err := nvlibParentDevice.GetMigDevices()
 	if err != nil {
-		log.FromContext(ctx).Error(err, "unable to get MIG devices on GPU")
+		return giIdError, realizedMigError, ciMigInfoError, fmt.Errorf("unable to get MIG devices on GPU: %w", err)
 	}
 	for _, mig := range migs {
 		obtainedProfileName, _ := mig.GetProfile()
@@ -496,7 +964,7 @@ func (*InstaSliceDaemonsetReconciler) getCreatedSliceDetails(ctx context.Context
 			log.FromContext(ctx).Error(errGettingMigGi, "Unable to get GPU instance")
 		}
 
-		if profileName == obtainedProfileName.String() && giID == int(giInfo.Id) {
+		if ProfilesMatch(profileName, obtainedProfileName.String()) && giID == int(giInfo.Id) {
 			realizedMig, errGettingMigUid := mig.GetUUID()
 			if errGettingMigUid != nvml.SUCCESS {
 				log.FromContext(ctx).Error(errGettingMigGi, "Unable to get MIG uid")
@@ -520,127 +988,239 @@ func (*InstaSliceDaemonsetReconciler) getCreatedSliceDetails(ctx context.Context
 	return giIdError, realizedMigError, ciMigInfoError, fmt.Errorf("unable to get prepared details")
 }
 
-// controller provides placement we do a read from allocation object.
-// TODO: see if this method can be removed to simplify code
-func (r *InstaSliceDaemonsetReconciler) getAllocation(instaslice inferencev1alpha1.Instaslice, podUuid string) (string, string, int, int, int, error) {
-	var gpuUUID, profile string
-	var giprofileid, ciProfileID, ciEngProfileID int
+// carves the GI and CI of an allocation at the placement and returns the realized slice.
+func (r *InstaSliceDaemonsetReconciler) carveSlice(ctx context.Context, device nvml.Device, instaslice inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails, placement nvml.GpuInstancePlacement) (preparedMig, error) {
+	// free placements can change between discovery and creation
+	if err := checkPlacementPossible(device, allocation.Giprofileid, placement); err != nil {
+		return preparedMig{}, err
+	}
+	creationStart := time.Now()
+	gi, retCodeForGiWithPlacement := createGpuInstanceWithRetry(ctx, device, instaslice, allocation, placement)
+	if retCodeForGiWithPlacement != nvml.SUCCESS {
+		countSliceCreation(allocation.Profile, retCodeForGiWithPlacement)
+		r.recordInstanceCreateFailed(allocation, EventReasonGpuInstanceCreateFailed, retCodeForGiWithPlacement)
+	}
+	if errLost := checkNVMLHandle("CreateGpuInstanceWithPlacement", retCodeForGiWithPlacement); errLost != nil {
+		return preparedMig{}, errLost
+	}
+	if retCodeForGiWithPlacement != nvml.SUCCESS {
+		//TODO: dont see it yet, should we handle Invalid Argument error?
+		// avoid "error": "Insufficient Resources",
+		// which means that previous GI was not deleted and hence daemonset is unable to
+		// recreate MIG on the same index. this will cause slice to not get realized and
+		// workload would never run.
+		if retCodeForGiWithPlacement.Error() != "Insufficient Resources" {
+			gi, err := r.searchGi(ctx, device, instaslice)
+			if err != nil {
+				log.FromContext(ctx).Error(err, "gi not found after searching not retrying")
+			} else {
+				log.FromContext(ctx).Info("found an gi that does not exists in prepared section yet with ", "value", gi)
+			}
+		}
+		return preparedMig{}, fmt.Errorf("unable to create gi: %w", retCodeForGiWithPlacement)
+	}
+	giInfo, retForGiInfor := gi.GetInfo()
+	if retForGiInfor != nvml.SUCCESS {
+		log.FromContext(ctx).Error(retForGiInfor, "error getting GPU instance info for ", "giInfo", &giInfo)
+	}
+	count := computeInstanceCount(allocation)
+	var ciIDs []int
+	for i := 0; i < count; i++ {
+		ci, retCodeForComputeInstance := createComputeInstance(gi, allocation.CIProfileID, allocation.CIEngProfileID)
+		if retCodeForComputeInstance != nvml.SUCCESS {
+			countSliceCreation(allocation.Profile, retCodeForComputeInstance)
+			r.recordInstanceCreateFailed(allocation, EventReasonComputeInstanceCreateFailed, retCodeForComputeInstance)
+			if len(ciIDs) > 0 {
+				// a partially split gi cannot be used, free the placement for the retry
+				if ret := destroySlice(device, int(giInfo.Id), ciIDs...); ret != nvml.SUCCESS {
+					log.FromContext(ctx).Error(ret, "unable to destroy partially created slice for ", "pod", allocation.PodName)
+				}
+			}
+			if errLost := checkNVMLHandle("CreateComputeInstance", retCodeForComputeInstance); errLost != nil {
+				return preparedMig{}, errLost
+			}
+			//TODO: clean up GI and then return or may be re-use since we have the logic
+			// the gi alone cannot be used either, free the placement for the retry
+			if ret := destroySlice(device, int(giInfo.Id)); ret != nvml.SUCCESS {
+				log.FromContext(ctx).Error(ret, "unable to destroy gi of failed slice for ", "pod", allocation.PodName)
+			}
+			return preparedMig{}, fmt.Errorf("unable to create ci: %w", retCodeForComputeInstance)
+		}
+		ciInfo, retForCiInfo := ci.GetInfo()
+		if retForCiInfo != nvml.SUCCESS {
+			log.FromContext(ctx).Error(retForCiInfo, "error getting compute instance info for ", "pod", allocation.PodName)
+		}
+		ciIDs = append(ciIDs, int(ciInfo.Id))
+	}
+	creationDuration := time.Since(creationStart)
+	observeSliceCreation(allocation.Profile, creationDuration)
+	countSliceCreation(allocation.Profile, nvml.SUCCESS)
+	giProfileInfo, retForGiProfileInfo := device.GetGpuInstanceProfileInfo(allocation.Giprofileid)
+	if retForGiProfileInfo != nvml.SUCCESS {
+		log.FromContext(ctx).Error(retForGiProfileInfo, "error getting GPU instance profile info for ", "pod", allocation.PodName)
+	}
+
+	relocated := giInfo.Placement.Start != placement.Start
 
-	for _, v := range instaslice.Spec.Allocations {
-		if v.Allocationstatus == "creating" && v.PodUUID == podUuid {
-			return v.GPUUUID, v.Profile, v.Giprofileid, v.CIProfileID, v.CIEngProfileID, nil
+	if count > 1 {
+		computeInstances, errGettingSliceDetails := r.getCreatedComputeInstances(ctx, device, giInfo.Id)
+		if errGettingSliceDetails != nil || len(computeInstances) != count {
+			log.FromContext(ctx).Error(errGettingSliceDetails, "slice details not found in prepared section", "pod", allocation.PodName, "computeInstances", len(computeInstances))
+			return preparedMig{}, destroyUnrealizedSlice(ctx, device, giInfo.Id, ciIDs, allocation)
 		}
+		return preparedMig{gid: giInfo.Id, miguuid: computeInstances[0].miguuid, cid: computeInstances[0].cid, creationDuration: creationDuration,
+			memorySizeMB: giProfileInfo.MemorySizeMB, computeInstances: computeInstances, relocated: relocated, start: giInfo.Placement.Start}, nil
 	}
 
-	return gpuUUID, profile, giprofileid, ciProfileID, ciEngProfileID, fmt.Errorf("allocation with PodUUID %s not found", podUuid)
+	//get created mig details
+	giId, migUUID, ciId, errGettingSliceDetails := r.waitForCreatedSliceDetails(ctx, giInfo, device, allocation.GPUUUID, allocation.Profile)
+	if errGettingSliceDetails != nil || migUUID == "" {
+		log.FromContext(ctx).Error(errGettingSliceDetails, "slice details not found in prepared section", "pod", allocation.PodName)
+		return preparedMig{}, destroyUnrealizedSlice(ctx, device, giInfo.Id, ciIDs, allocation)
+	}
+	return preparedMig{gid: giId, miguuid: migUUID, cid: ciId, creationDuration: creationDuration, memorySizeMB: giProfileInfo.MemorySizeMB,
+		relocated: relocated, start: giInfo.Placement.Start}, nil
+}
+
+// getCreatedComputeInstances returns the ci of the gi and the MIG devices backing them, ordered by ci id.
+func (r *InstaSliceDaemonsetReconciler) getCreatedComputeInstances(ctx context.Context, device nvml.Device, giID uint32) ([]preparedComputeInstance, error) {
+	nvlibParentDevice, err := r.handler().nvdevice.NewDevice(device)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get nvlib GPU parent device: %w", err)
+	}
+	migs, err := nvlibParentDevice.GetMigDevices()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get MIG devices on GPU: %w", err)
+	}
+	var computeInstances []preparedComputeInstance
+	for _, mig := range migs {
+		migGiID, ret := mig.GetGpuInstanceId()
+		if ret != nvml.SUCCESS || migGiID != int(giID) {
+			continue
+		}
+		migCiID, ret := mig.GetComputeInstanceId()
+		if ret != nvml.SUCCESS {
+			return nil, fmt.Errorf("unable to get MIG ci: %v", ret)
+		}
+		migUUID, ret := mig.GetUUID()
+		if ret != nvml.SUCCESS {
+			return nil, fmt.Errorf("unable to get MIG uid: %v", ret)
+		}
+		computeInstances = append(computeInstances, preparedComputeInstance{cid: uint32(migCiID), miguuid: migUUID})
+	}
+	sort.Slice(computeInstances, func(i, j int) bool {
+		return computeInstances[i].cid < computeInstances[j].cid
+	})
+	log.FromContext(ctx).Info("Prepared details", "giId", giID, "computeInstances", len(computeInstances))
+	return computeInstances, nil
 }
 
 // deletes CI and GI in that order.
 // TODO: split this method into two methods.
 func (r *InstaSliceDaemonsetReconciler) cleanUpCiAndGi(ctx context.Context, podUuid string, instaslice inferencev1alpha1.Instaslice) (string, error) {
-	ret := nvml.Init()
-	if ret != nvml.SUCCESS {
-		log.FromContext(ctx).Error(ret, "Unable to initialize NVML")
-	}
-	var shutdownErr error
-
-	defer func() {
-		if shutdownErr = nvml.Shutdown(); shutdownErr != nvml.SUCCESS {
-			log.FromContext(ctx).Error(shutdownErr, "error to perform nvml.Shutdown")
-		}
-	}()
+	nvmllib := r.handler().nvml
 
+	// the ci of a gi split for the pod go before the gi, which is destroyed once
 	var candidateDel string
-	prepared := instaslice.Spec.Prepared
-	for migUUID, value := range prepared {
-		if value.PodUUID == podUuid {
-			parent, errRecievingDeviceHandle := nvml.DeviceGetHandleByUUID(value.Parent)
+	computeInstances := make(map[gpuInstanceRef][]int)
+	parents := make(map[string]nvml.Device)
+	prepared := instaslice.Status.Prepared
+	migUUIDs := make([]string, 0, len(prepared))
+	for migUUID := range prepared {
+		migUUIDs = append(migUUIDs, migUUID)
+	}
+	sort.Strings(migUUIDs)
+	for _, migUUID := range migUUIDs {
+		value := prepared[migUUID]
+		if value.PodUUID != podUuid {
+			continue
+		}
+		candidateDel = migUUID
+		parent, seen := parents[value.Parent]
+		if !seen {
+			var errRecievingDeviceHandle nvml.Return
+			parent, errRecievingDeviceHandle = nvmllib.DeviceGetHandleByUUID(value.Parent)
 			if errRecievingDeviceHandle != nvml.SUCCESS {
 				log.FromContext(ctx).Error(errRecievingDeviceHandle, "error obtaining GPU handle")
+				parent = nil
 			}
-			gi, errRetrievingGi := parent.GetGpuInstanceById(int(value.Giinfoid))
-			if errRetrievingGi != nvml.SUCCESS {
-				log.FromContext(ctx).Error(errRetrievingGi, "error obtaining GPU instance")
-			}
-			ci, errRetrievingCi := gi.GetComputeInstanceById(int(value.Ciinfoid))
-			if errRetrievingCi != nvml.SUCCESS {
-				log.FromContext(ctx).Error(errRetrievingCi, "error obtaining compute instance")
-			}
-
-			errDestroyingCi := ci.Destroy()
-			if errDestroyingCi != nvml.SUCCESS {
-				// should we return and retry?
-				log.FromContext(ctx).Error(errDestroyingCi, "error deleting compute instance")
-			}
-			errDestroyingGi := gi.Destroy()
-			if errDestroyingGi != nvml.SUCCESS {
-				// should we return and retry?
-				log.FromContext(ctx).Error(errDestroyingGi, "error deleting GPU instance")
-			}
-			candidateDel = migUUID
-			log.FromContext(ctx).Info("done deleting MIG slice for pod", "UUID", value.PodUUID)
+			parents[value.Parent] = parent
+		}
+		if parent == nil {
+			continue
+		}
+		giID, ciID, exists, errResolving := sliceIDsForCleanup(ctx, parent, migUUID, value)
+		if errResolving != nil {
+			return "", errResolving
 		}
+		if !exists {
+			continue
+		}
+		key := gpuInstanceRef{parent: value.Parent, gi: giID}
+		computeInstances[key] = append(computeInstances[key], int(ciID))
+	}
+	for _, teardown := range orderSliceTeardowns(computeInstances) {
+		errDestroyingSlice := destroySlice(parents[teardown.parent], int(teardown.gi), teardown.cis...)
+		countSliceDeletion(errDestroyingSlice)
+		if errDestroyingSlice == nvml.ERROR_IN_USE {
+			return "", fmt.Errorf("%w: gi %d on GPU %s", errSliceInUse, teardown.gi, teardown.parent)
+		} else if errDestroyingSlice != nvml.SUCCESS {
+			// should we return and retry?
+			log.FromContext(ctx).Error(errDestroyingSlice, "error deleting MIG slice")
+		}
+		log.FromContext(ctx).Info("done deleting MIG slice for pod", "UUID", podUuid)
 	}
 
 	return candidateDel, nil
 }
 
-// delete custom extended resource when a pod is deleted.
-func (r *InstaSliceDaemonsetReconciler) cleanUpInstaSliceResource(ctx context.Context, podName string) error {
-	nodeName := os.Getenv("NODE_NAME")
-	deletePatch, err := deletePatchData(podName)
-	if err != nil {
-		log.FromContext(ctx).Error(err, "unable to create delete json patch data")
-		return err
-	}
-
-	// Apply the patch to remove the resource
-	node := &v1.Node{}
-	if err := r.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
-		log.FromContext(ctx).Error(err, "unable to fetch Node")
-		return err
-	}
-	resourceName := v1.ResourceName(fmt.Sprintf("org.instaslice/%s", podName))
-	//&& val.String() == "1"
-	if _, ok := node.Status.Capacity[resourceName]; !ok {
-		log.FromContext(ctx).Info("skipping non-existent deletion of instaslice resource for ", "pod", podName)
-		return nil
-	}
-	if err := r.Status().Patch(ctx, node, client.RawPatch(types.JSONPatchType, deletePatch)); err != nil {
-		log.FromContext(ctx).Error(err, "unable to patch Node status")
-		return err
-	}
-	return nil
+// withdraws the capacity of the slice of the allocation when a pod is deleted.
+func (r *InstaSliceDaemonsetReconciler) cleanUpInstaSliceResource(ctx context.Context, allocation inferencev1alpha1.AllocationDetails) error {
+	return r.capacityAdvertiser().Withdraw(ctx, os.Getenv("NODE_NAME"), allocation)
 }
 
 // prepared entry is created when a GPU slice exists on a node.
 func (r *InstaSliceDaemonsetReconciler) createPreparedEntry(ctx context.Context, profileName string, podUUID string, deviceUUID string, giId uint32, ciId uint32, instaslice *inferencev1alpha1.Instaslice, migUUID string) error {
-	existingPreparedDetails := instaslice.Spec.Prepared
-	checkAPreparedDetails := existingPreparedDetails[migUUID]
-	if checkAPreparedDetails.Ciinfoid == ciId && checkAPreparedDetails.Giinfoid == giId && checkAPreparedDetails.PodUUID == podUUID {
-		log.FromContext(ctx).Info("updated prepared details already exists")
+	var errDuplicate error
+	updated, errForUpdate := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+		checkAPreparedDetails := latest.Status.Prepared[migUUID]
+		if checkAPreparedDetails.Ciinfoid == ciId && checkAPreparedDetails.Giinfoid == giId && checkAPreparedDetails.PodUUID == podUUID {
+			log.FromContext(ctx).Info("updated prepared details already exists")
+			return errInstasliceUnchanged
+		}
+		updatedAllocation := latest.Spec.Allocations[podUUID]
+		instaslicePrepared := inferencev1alpha1.PreparedDetails{
+			Profile:  profileName,
+			Start:    updatedAllocation.Start,
+			Size:     updatedAllocation.Size,
+			Parent:   deviceUUID,
+			PodUUID:  podUUID,
+			Giinfoid: giId,
+			Ciinfoid: ciId,
+			GPUModel: latest.Spec.MigGPUUUID[deviceUUID],
+			CarvedBy: r.operatorVersion(),
+		}
+		if latest.Status.Prepared == nil {
+			latest.Status.Prepared = make(map[string]inferencev1alpha1.PreparedDetails)
+		}
+		if preparedConflicts(latest.Status.Prepared, migUUID, instaslicePrepared) {
+			errDuplicate = duplicateMigUUIDError(migUUID, latest.Status.Prepared[migUUID], instaslicePrepared)
+			log.FromContext(ctx).Error(errDuplicate, "refusing to overwrite prepared entry for ", "pod", updatedAllocation.PodName)
+			return errDuplicate
+		}
+		latest.Status.Prepared[migUUID] = instaslicePrepared
 		return nil
+	})
+	if errDuplicate != nil {
+		r.markDuplicateMigUUID(ctx, instaslice.Name, []string{migUUID})
+		return errDuplicate
 	}
-	updatedAllocation := instaslice.Spec.Allocations[podUUID]
-	instaslicePrepared := inferencev1alpha1.PreparedDetails{
-		Profile:  profileName,
-		Start:    updatedAllocation.Start,
-		Size:     updatedAllocation.Size,
-		Parent:   deviceUUID,
-		PodUUID:  podUUID,
-		Giinfoid: giId,
-		Ciinfoid: ciId,
-	}
-	if instaslice.Spec.Prepared == nil {
-		instaslice.Spec.Prepared = make(map[string]inferencev1alpha1.PreparedDetails)
-	}
-
-	instaslice.Spec.Prepared[migUUID] = instaslicePrepared
-	errForUpdate := r.Update(ctx, instaslice)
 	if errForUpdate != nil {
 		log.FromContext(ctx).Error(errForUpdate, "error adding prepared statement")
 		return errForUpdate
 	}
+	// callers keep working on the instaslice, hand them the version that was written
+	*instaslice = *updated
 	return nil
 }
 
@@ -649,28 +1229,7 @@ func (r *InstaSliceDaemonsetReconciler) createPreparedEntry(ctx context.Context,
 // sometimes the device plugin pod needs to be manually bounced before a burst of short lived
 // pods are submitted for testing, this check could be part of installation.
 func (r *InstaSliceDaemonsetReconciler) updateNodeCapacity(ctx context.Context, nodeName string) error {
-	node := &v1.Node{}
-	nodeNameObject := types.NamespacedName{Name: nodeName}
-	err := r.Get(ctx, nodeNameObject, node)
-	if err != nil {
-		log.FromContext(ctx).Error(err, "unable to get node object")
-		return err
-	}
-	// NOTE: Label value should be maunally added when the cluster is setup.
-	if value, exists := node.Labels["nvidia.com/device-plugin.config"]; exists && value == "update-capacity-1" {
-		node.Labels["nvidia.com/device-plugin.config"] = "update-capacity"
-	}
-
-	if value, exists := node.Labels["nvidia.com/device-plugin.config"]; exists && value == "update-capacity" {
-		node.Labels["nvidia.com/device-plugin.config"] = "update-capacity-1"
-	}
-
-	err = r.Update(ctx, node)
-	if err != nil {
-		log.FromContext(ctx).Error(err, "unable to update Node")
-		return err
-	}
-	return nil
+	return r.capacityReloader().Reload(ctx, nodeName)
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -683,13 +1242,27 @@ func (r *InstaSliceDaemonsetReconciler) SetupWithManager(mgr ctrl.Manager) error
 	if err != nil {
 		return err
 	}
+	if r.nvmlHandler == nil {
+		nvmllib, err := newNvmlLib(r.NvmlLibraryPaths)
+		if err != nil {
+			return err
+		}
+		r.nvmlHandler = newDeviceHandler(nvmllib)
+	}
 	if err := r.setupWithManager(mgr); err != nil {
 		return err
 	}
+	if r.ConfigConfigMap != "" {
+		if err := r.setupConfigReload(mgr); err != nil {
+			return err
+		}
+	}
 
 	//make InstaSlice object when it does not exists
 	//if it got restarted then use the existing state.
 	nodeName := os.Getenv("NODE_NAME")
+	// reconciles wait until the startup runnable got NVML initialized
+	r.nvmlNotReady.Store(true)
 
 	//Init InstaSlice obj as the first thing when cache is loaded.
 	//RunnableFunc is added to the manager.
@@ -700,7 +1273,7 @@ func (r *InstaSliceDaemonsetReconciler) SetupWithManager(mgr ctrl.Manager) error
 		typeNamespacedName := types.NamespacedName{
 			Name: nodeName,
 			//TODO: change namespace
-			Namespace: "default",
+			Namespace: r.instasliceNamespace(),
 		}
 		errRetrievingInstaSliceForSetup := r.Get(ctx, typeNamespacedName, &instaslice)
 		if errRetrievingInstaSliceForSetup != nil {
@@ -708,224 +1281,566 @@ func (r *InstaSliceDaemonsetReconciler) SetupWithManager(mgr ctrl.Manager) error
 			//TODO: should we do hard exit?
 			//os.Exit(1)
 		}
-		if instaslice.Status.Processed != "true" || (instaslice.Name == "" && instaslice.Namespace == "") {
-			_, errForDiscoveringGpus := r.discoverMigEnabledGpuWithSlices()
-			if errForDiscoveringGpus != nil {
-				log.FromContext(ctx).Error(errForDiscoveringGpus, "error discovering GPUs")
+		// the driver may not be loaded yet at boot
+		if errWaiting := r.waitForNVML(ctx, nodeName); errWaiting != nil {
+			log.FromContext(ctx).Error(errWaiting, "NVML never became ready")
+			return nil
+		}
+		// discovery merges into an instaslice left by an earlier run, keeping the allocations and slices it records
+		_, errForDiscoveringGpus := r.discoverMigEnabledGpuWithSlices(ctx)
+		// GPUs may show up later, e.g. once their driver is loaded, keep looking until they do
+		for isNoGPUsDiscovered(errForDiscoveringGpus) {
+			log.FromContext(ctx).Info("no GPU discovered on the node, retrying", "after", noGPUsRetryInterval)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(noGPUsRetryInterval):
 			}
+			_, errForDiscoveringGpus = r.discoverMigEnabledGpuWithSlices(ctx)
+		}
+		if errForDiscoveringGpus != nil {
+			log.FromContext(ctx).Error(errForDiscoveringGpus, "error discovering GPUs")
+		}
+		if instaslice.Status.Processed != "true" {
+			return nil
+		}
+		// slices recorded by an earlier version may carry profile names the current scheme no longer gives
+		if errRelabeling := r.relabelPreparedProfiles(ctx, nodeName); errRelabeling != nil {
+			log.FromContext(ctx).Error(errRelabeling, "error renaming the profiles of prepared slices")
+		}
+		// slices do not survive a reboot of the node, realize again the ones recorded before it
+		if errRecarving := r.recarveSlicesAfterReboot(ctx, nodeName); errRecarving != nil {
+			log.FromContext(ctx).Error(errRecarving, "error carving slices lost with a reboot")
 		}
 		return nil
 	}))
 
+	// the NVML session opened on startup is held while the manager runs, close it on exit
+	mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		r.shutdownNVML(ctx)
+		return nil
+	}))
+
+	// slices destroyed out-of-band leave ghost Prepared entries behind, keep them in sync with the hardware.
+	mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		<-mgr.Elected()
+		r.runPreparedVerification(ctx, nodeName)
+		return nil
+	}))
+
+	if r.SliceMetricsInterval > 0 {
+		mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			<-mgr.Elected()
+			r.runSliceMetrics(ctx, nodeName)
+			return nil
+		}))
+	}
+
+	if r.XidPollInterval > 0 {
+		mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			<-mgr.Elected()
+			r.runXidPolling(ctx, nodeName)
+			return nil
+		}))
+	}
+
+	// the operator is event driven, a divergence nothing reports would otherwise persist
+	if r.FullReconcileInterval > 0 {
+		mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			<-mgr.Elected()
+			r.runFullReconcile(ctx, nodeName)
+			return nil
+		}))
+	}
+
 	return nil
 }
 
 // Enable creation of controller caches to talk to the API server in order to perform
 // object discovery in SetupWithManager
 func (r *InstaSliceDaemonsetReconciler) setupWithManager(mgr ctrl.Manager) error {
+	r.fullReconcileEvents = make(chan event.GenericEvent, 1)
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&inferencev1alpha1.Instaslice{}).Named("InstaSliceDaemonSet").
+		// status updates, like the reconcile heartbeat, must not trigger another reconcile, a forced cleanup
+		// requested with an annotation must
+		For(&inferencev1alpha1.Instaslice{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).Named("InstaSliceDaemonSet").
+		// a restart of the device plugin can wipe the capacity advertised for realized slices
+		Watches(&v1.Node{}, handler.EnqueueRequestsFromMapFunc(r.nodeMapFunc), builder.WithPredicates(instaSliceResourceLostPredicate)).
+		// a pod deleted without the controller marking its allocation deleting would leak its slice
+		Watches(&v1.Pod{}, r.removedPodsHandler()).
+		// full reconciles, periodic or requested for lost slices, go through the workqueue, serialized with
+		// every other reconcile
+		WatchesRawSource(&source.Channel{Source: r.fullReconcileEvents}, &handler.EnqueueRequestForObject{}).
 		Complete(r)
 }
 
 // This function discovers MIG devices as the plugin comes up. this is run exactly once.
-func (r *InstaSliceDaemonsetReconciler) discoverMigEnabledGpuWithSlices() ([]string, error) {
-	instaslice, _, gpuModelMap, failed, returnValue, errorDiscoveringProfiles := r.discoverAvailableProfilesOnGpus()
+// Its client calls go through ctx, the runnable of the manager passes one cancelled on shutdown.
+func (r *InstaSliceDaemonsetReconciler) discoverMigEnabledGpuWithSlices(ctx context.Context) ([]string, error) {
+	if err := r.checkDriverVersion(ctx, os.Getenv("NODE_NAME")); err != nil {
+		return nil, err
+	}
+	instaslice, _, gpuModelMap, failed, returnValue, errorDiscoveringProfiles := r.discoverAvailableProfilesOnGpus(ctx)
 	if failed {
 		return returnValue, errorDiscoveringProfiles
 	}
+	// an empty instaslice marked processed would hide a missing GPU or driver behind a node without capacity
+	if errorDiscoveringProfiles == nil && len(gpuModelMap) == 0 {
+		return nil, r.markNoGPUsDiscovered(ctx, os.Getenv("NODE_NAME"))
+	}
 
 	err := r.discoverDanglingSlices(instaslice)
 
+	if err != nil {
+		return nil, err
+	}
+	migEnabled, err := r.discoverMigMode()
+	if err != nil {
+		return nil, err
+	}
+	nvlinkPeers, err := r.discoverNVLinkPeers()
 	if err != nil {
 		return nil, err
 	}
 
 	nodeName := os.Getenv("NODE_NAME")
 	instaslice.Name = nodeName
-	instaslice.Namespace = "default"
+	instaslice.Namespace = r.instasliceNamespace()
 	instaslice.Spec.MigGPUUUID = gpuModelMap
+	r.recordDevicePluginConfig(ctx, gpuModelMap)
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		prepared.GPUModel = gpuModelMap[prepared.Parent]
+		instaslice.Status.Prepared[migUUID] = prepared
+	}
 	instaslice.Status.Processed = "true"
-	//TODO: should we use context.TODO() ?
-	customCtx := context.TODO()
-	errToCreate := r.Create(customCtx, instaslice)
+	discoveredPrepared := instaslice.Status.Prepared
+	errToCreate := r.Create(ctx, instaslice)
+	if errToCreate == nil {
+		// the status is not stored on create, the slices found on the GPUs are written with it below
+		instaslice.Status.Prepared = discoveredPrepared
+	}
+	if errors.IsAlreadyExists(errToCreate) {
+		// the instaslice outlived an earlier run or discovery, merge into it without losing its allocations
+		discovered := instaslice
+		var renamed map[string]string
+		var moved []string
+		// another daemonset instance may have just created it, the cache can lag behind
+		errToCreate = retry.OnError(retry.DefaultBackoff, errors.IsNotFound, func() error {
+			var errMerging error
+			instaslice, errMerging = r.updateInstaslice(ctx, nodeName, func(latest *inferencev1alpha1.Instaslice) error {
+				// an earlier version kept the prepared entries in the spec, they keep the pods they were carved for
+				moved = moveSpecPrepared(latest)
+				// profiles named by an earlier version are recorded under their current names before the merge
+				// drops the old ones
+				renamed = renamedProfiles(latest, discovered)
+				renameProfiles(latest, renamed)
+				mergeDiscovered(latest, discovered)
+				return nil
+			})
+			return errMerging
+		})
+		if errToCreate == nil {
+			if len(moved) > 0 {
+				log.FromContext(ctx).Info("moved prepared entries from the spec to the status of the instaslice", "migUUIDs", moved)
+			}
+			// the update returns the status stored so far, e.g. still saying no GPU was found
+			mergeDiscoveredConditions(&instaslice.Status, &discovered.Status)
+			if errMigrating := r.migrateProfileCapacity(ctx, instaslice, renamed); errMigrating != nil {
+				log.FromContext(ctx).Error(errMigrating, "unable to move the capacity of renamed profiles", "renamed", renamed)
+			}
+		}
+	}
 	if errToCreate != nil {
 		return nil, errToCreate
 	}
 
-	// Object exists, update its status
-	instaslice.Status.Processed = "true"
-	if errForStatus := r.Status().Update(customCtx, instaslice); errForStatus != nil {
+	// Object exists, update its status, another daemonset instance may write it concurrently
+	discoveredStatus := instaslice.Status.DeepCopy()
+	var migDisabled []string
+	errForStatus := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		instaslice.Status.Processed = "true"
+		instaslice.Status.MigEnabled = migEnabled
+		// the GPUs in MIG mode are still advertised, the others are flagged
+		migDisabled = setMigModeCondition(&instaslice.Status, migEnabled)
+		instaslice.Status.NVLinkPeers = nvlinkPeers
+		instaslice.Status.CarvedSlices = carvedSlices(instaslice)
+		instaslice.Status.OccupiedRanges = occupiedRanges(instaslice)
+		instaslice.Status.MigUUIDs = allocationMigUUIDs(instaslice)
+		errUpdating := r.Status().Update(ctx, instaslice)
+		if !errors.IsConflict(errUpdating) {
+			return errUpdating
+		}
+		latest := &inferencev1alpha1.Instaslice{}
+		if err := r.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: r.instasliceNamespace()}, latest); err != nil {
+			return err
+		}
+		mergeDiscoveredConditions(&latest.Status, discoveredStatus)
+		// the status of a created instaslice was not written yet
+		if latest.Status.Prepared == nil {
+			latest.Status.Prepared = discoveredStatus.Prepared
+		}
+		instaslice = latest
+		return errUpdating
+	})
+	if errForStatus != nil {
 		return nil, errForStatus
 	}
+	observeGPUState(instaslice.Status)
+	if len(migDisabled) > 0 {
+		r.recordMigModeInconsistent(ctx, instaslice, migDisabled)
+	}
+	for _, invalid := range invalidAllowedProfiles(instaslice) {
+		log.FromContext(ctx).Info("ignoring allowed profiles entry", "reason", invalid)
+	}
+	for _, invalid := range invalidPinnedNamespaces(instaslice) {
+		log.FromContext(ctx).Info("ignoring pinned namespace entry", "reason", invalid)
+	}
+	// the cache only speeds up the next start, discovery went through without it
+	if errCaching := r.cacheDiscoveredProfiles(ctx, instaslice); errCaching != nil {
+		log.FromContext(ctx).Error(errCaching, "unable to cache the discovered profiles")
+	}
+	if errExporting := r.exportCapabilities(ctx, instaslice); errExporting != nil {
+		return nil, errExporting
+	}
+	// nodeSelectors on the profile labels only miss the node until the next discovery
+	if errLabeling := r.labelSupportedProfiles(ctx, instaslice); errLabeling != nil {
+		log.FromContext(ctx).Error(errLabeling, "unable to label the node with its supported profiles")
+	}
+	// pods placed through extended resources are not affected, keep the node usable on clusters without DRA
+	if errPublishing := r.publishResourceSlices(ctx, instaslice); errPublishing != nil {
+		log.FromContext(ctx).Error(errPublishing, "unable to publish the ResourceSlices of the node")
+	}
 
 	return discoveredGpusOnHost, nil
 }
 
-// during init time we need to discover GPU that are MIG enabled and slices if any on them to start making allocations of the next pods.
-func (r *InstaSliceDaemonsetReconciler) discoverAvailableProfilesOnGpus() (*inferencev1alpha1.Instaslice, nvml.Return, map[string]string, bool, []string, error) {
-	instaslice := &inferencev1alpha1.Instaslice{}
-	ret := nvml.Init()
+// discoverMigMode returns, per GPU of the node, whether MIG mode is enabled.
+func (r *InstaSliceDaemonsetReconciler) discoverMigMode() (map[string]bool, error) {
+	nvmllib := r.handler().nvml
+	count, ret := nvmllib.DeviceGetCount()
 	if ret != nvml.SUCCESS {
-		return nil, ret, nil, false, nil, ret
+		return nil, ret
+	}
+	migEnabled := make(map[string]bool)
+	for i := 0; i < count; i++ {
+		if r.undiscoveredGPUs[i] {
+			continue
+		}
+		device, ret := nvmllib.DeviceGetHandleByIndex(i)
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		uuid, ret := device.GetUUID()
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		currentMigMode, _, ret := device.GetMigMode()
+		// GPUs without MIG support report it as not supported
+		if ret == nvml.ERROR_NOT_SUPPORTED {
+			migEnabled[uuid] = false
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		migEnabled[uuid] = currentMigMode == nvml.DEVICE_MIG_ENABLE
 	}
+	return migEnabled, nil
+}
 
-	count, ret := nvml.DeviceGetCount()
+// during init time we need to discover GPU that are MIG enabled and slices if any on them to start making allocations of the next pods.
+func (r *InstaSliceDaemonsetReconciler) discoverAvailableProfilesOnGpus(ctx context.Context) (*inferencev1alpha1.Instaslice, nvml.Return, map[string]string, bool, []string, error) {
+	instaslice := &inferencev1alpha1.Instaslice{}
+	nvmllib := r.handler().nvml
+
+	count, ret := nvmllib.DeviceGetCount()
 	if ret != nvml.SUCCESS {
 		return nil, ret, nil, false, nil, ret
 	}
 	gpuModelMap := make(map[string]string)
-	discoverProfilePerNode := true
+	// profiles are discovered on the first GPU and assumed to be the same on the others, the next GPUs are
+	// only tried when BestEffortDiscovery skips the first ones
+	type profileDevice struct {
+		index  int
+		uuid   string
+		device nvml.Device
+		memory uint64
+	}
+	var profileDevices []profileDevice
+	var failedGPUs []string
+	r.undiscoveredGPUs = nil
 	for i := 0; i < count; i++ {
-		device, ret := nvml.DeviceGetHandleByIndex(i)
+		// GPUs left to other systems are not even asked for their UUID
+		if !r.mayManageGPU(i) {
+			r.skipUnmanagedGPU(i, "")
+			continue
+		}
+		device, ret := nvmllib.DeviceGetHandleByIndex(i)
 		if ret != nvml.SUCCESS {
-			return nil, ret, nil, false, nil, ret
+			if !r.BestEffortDiscovery {
+				return nil, ret, nil, false, nil, ret
+			}
+			failedGPUs = r.skipUndiscoveredGPU(failedGPUs, i, "", ret)
+			continue
 		}
 
-		uuid, _ := device.GetUUID()
-		gpuName, _ := device.GetName()
+		// a GPU that cannot tell its UUID would be recorded under an empty one
+		uuid, gpuName, err := deviceIdentity(device)
+		if err == nil && !r.managesGPU(i, uuid) {
+			r.skipUnmanagedGPU(i, uuid)
+			continue
+		}
+		if err != nil {
+			if !r.BestEffortDiscovery {
+				return nil, 0, nil, false, nil, err
+			}
+			failedGPUs = r.skipUndiscoveredGPU(failedGPUs, i, uuid, err)
+			continue
+		}
+		memoryTotal, eccEnabled, err := profileMemoryTotal(device)
+		if err != nil {
+			if !r.BestEffortDiscovery {
+				return nil, 0, nil, false, nil, err
+			}
+			failedGPUs = r.skipUndiscoveredGPU(failedGPUs, i, uuid, err)
+			continue
+		}
 		gpuModelMap[uuid] = gpuName
 		discoveredGpusOnHost = append(discoveredGpusOnHost, uuid)
-		if discoverProfilePerNode {
-
-			for i := 0; i < nvml.GPU_INSTANCE_PROFILE_COUNT; i++ {
-				giProfileInfo, ret := device.GetGpuInstanceProfileInfo(i)
-				if ret == nvml.ERROR_NOT_SUPPORTED {
-					continue
-				}
-				if ret == nvml.ERROR_INVALID_ARGUMENT {
-					continue
-				}
-				if ret != nvml.SUCCESS {
-					return nil, ret, nil, false, nil, ret
-				}
+		// nodes disagreeing on ECC are a sign of drift in the cluster setup
+		if r.ExpectedECCMode != "" && r.ExpectedECCMode != eccModeName(eccEnabled) {
+			log.Log.Info("GPU ECC mode differs from the expected one", "gpu", uuid, "ecc", eccModeName(eccEnabled), "expected", r.ExpectedECCMode)
+		}
+		profileDevices = append(profileDevices, profileDevice{index: i, uuid: uuid, device: device, memory: memoryTotal})
+	}
+	if r.BestEffortDiscovery {
+		defer func() { setPartiallyDiscoveredCondition(&instaslice.Status, failedGPUs) }()
+	}
+	if len(profileDevices) == 0 {
+		return instaslice, ret, gpuModelMap, false, nil, nil
+	}
+	// enumerating the profiles is slow, a node with the same GPUs as when they were cached has the same profiles
+	if migPlacement, cached := r.loadCachedProfiles(ctx, os.Getenv("NODE_NAME"), gpuModelMap); cached {
+		instaslice.Spec.Migplacement = allocatableMigPlacement(migPlacement)
+		return instaslice, ret, gpuModelMap, false, nil, nil
+	}
+	for i, candidate := range profileDevices {
+		migPlacement, failed, ret := discoverMigPlacement(candidate.device, candidate.memory)
+		if ret == nvml.SUCCESS {
+			instaslice.Spec.Migplacement = allocatableMigPlacement(migPlacement)
+			return instaslice, ret, gpuModelMap, false, nil, nil
+		}
+		if !r.BestEffortDiscovery || i == len(profileDevices)-1 {
+			if failed {
+				return nil, 0, nil, true, nil, ret
+			}
+			return nil, ret, nil, false, nil, ret
+		}
+		failedGPUs = r.skipUndiscoveredGPU(failedGPUs, candidate.index, candidate.uuid, ret)
+		delete(gpuModelMap, candidate.uuid)
+		discoveredGpusOnHost = withoutGPU(discoveredGpusOnHost, candidate.uuid)
+	}
+	return instaslice, ret, gpuModelMap, false, nil, nil
+}
 
-				memory, ret := device.GetMemoryInfo()
-				if ret != nvml.SUCCESS {
-					return nil, ret, nil, false, nil, ret
-				}
+// discoverMigPlacement enumerates the profiles the device supports and their placements. failed is set when
+// the placements of a profile could not be listed.
+func discoverMigPlacement(device nvml.Device, memoryTotal uint64) ([]inferencev1alpha1.Mig, bool, nvml.Return) {
+	var migPlacement []inferencev1alpha1.Mig
+	for i := 0; i < nvml.GPU_INSTANCE_PROFILE_COUNT; i++ {
+		giProfileInfo, ret := device.GetGpuInstanceProfileInfo(i)
+		if ret == nvml.ERROR_NOT_SUPPORTED {
+			continue
+		}
+		if ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return nil, false, ret
+		}
 
-				profile := NewMigProfile(i, i, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED, giProfileInfo.SliceCount, giProfileInfo.SliceCount, giProfileInfo.MemorySizeMB, memory.Total)
+		giPossiblePlacements, ret := possiblePlacements(device, giProfileInfo)
+		if ret == nvml.ERROR_NOT_SUPPORTED {
+			continue
+		}
+		if ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return nil, true, ret
+		}
+		// only profiles whose slices can actually be carved are advertised
+		ciProfiles, ret := discoverComputeInstanceProfiles(device, giProfileInfo, giPossiblePlacements)
+		if ret != nvml.SUCCESS {
+			return nil, false, ret
+		}
+		if len(ciProfiles) == 0 {
+			log.Log.Info("GPU instance profile supports no compute instance spanning it, skipping", "giProfileID", i)
+			continue
+		}
 
-				giPossiblePlacements, ret := device.GetGpuInstancePossiblePlacements(&giProfileInfo)
-				if ret == nvml.ERROR_NOT_SUPPORTED {
-					continue
-				}
-				if ret == nvml.ERROR_INVALID_ARGUMENT {
-					continue
-				}
-				if ret != nvml.SUCCESS {
-					return nil, 0, nil, true, nil, ret
-				}
-				placementsForProfile := []inferencev1alpha1.Placement{}
-				for _, p := range giPossiblePlacements {
-					placement := inferencev1alpha1.Placement{
-						Size:  int(p.Size),
-						Start: int(p.Start),
-					}
-					placementsForProfile = append(placementsForProfile, placement)
-				}
+		placementsForProfile := []inferencev1alpha1.Placement{}
+		for _, p := range giPossiblePlacements {
+			placement := inferencev1alpha1.Placement{
+				Size:  int(p.Size),
+				Start: int(p.Start),
+			}
+			placementsForProfile = append(placementsForProfile, placement)
+		}
 
-				aggregatedPlacementsForProfile := inferencev1alpha1.Mig{
-					Placements:     placementsForProfile,
-					Profile:        profile.String(),
-					Giprofileid:    i,
-					CIProfileID:    profile.CIProfileID,
-					CIEngProfileID: profile.CIEngProfileID,
-				}
-				instaslice.Spec.Migplacement = append(instaslice.Spec.Migplacement, aggregatedPlacementsForProfile)
+		// slices of dedicated engines are named apart from the ones sharing them
+		for _, ciProfile := range ciProfiles {
+			profile := NewMigProfile(i, ciProfile.profileID, ciProfile.engProfileID, giProfileInfo.SliceCount, ciProfile.sliceCount, giProfileInfo.MemorySizeMB, memoryTotal)
+			aggregatedPlacementsForProfile := inferencev1alpha1.Mig{
+				Placements:     placementsForProfile,
+				Profile:        profile.String(),
+				Giprofileid:    i,
+				CIProfileID:    profile.CIProfileID,
+				CIEngProfileID: profile.CIEngProfileID,
 			}
-			discoverProfilePerNode = false
+			migPlacement = append(migPlacement, aggregatedPlacementsForProfile)
 		}
 	}
-	return instaslice, ret, gpuModelMap, false, nil, nil
+	return migPlacement, false, nvml.SUCCESS
 }
 
 // TODO: remove this logic once we are able to use clean slate GPUs from upstream GPU operator fixes
 func (r *InstaSliceDaemonsetReconciler) discoverDanglingSlices(instaslice *inferencev1alpha1.Instaslice) error {
-	h := &deviceHandler{}
-	h.nvml = nvml.New()
-	h.nvdevice = nvdevice.New(nvdevice.WithNvml(h.nvml))
-
-	errInitNvml := h.nvml.Init()
-	if errInitNvml != nvml.SUCCESS {
-		return errInitNvml
-	}
+	h := r.handler()
 
 	availableGpusOnNode, errObtainingDeviceCount := h.nvml.DeviceGetCount()
 	if errObtainingDeviceCount != nvml.SUCCESS {
 		return errObtainingDeviceCount
 	}
 
+	// GPUs are walked by a bounded pool of workers, results are merged in device order to stay deterministic
+	workers := r.DiscoveryConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	slicesPerDevice := make([]map[string]inferencev1alpha1.PreparedDetails, availableGpusOnNode)
+	errsPerDevice := make([]error, availableGpusOnNode)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < availableGpusOnNode; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				slicesPerDevice[i], errsPerDevice[i] = danglingSlicesOnDevice(h, i)
+			}
+		}()
+	}
 	for i := 0; i < availableGpusOnNode; i++ {
-		device, errObtainingDeviceHandle := h.nvml.DeviceGetHandleByIndex(i)
-		if errObtainingDeviceHandle != nvml.SUCCESS {
-			return errObtainingDeviceHandle
-		}
-
-		uuid, errObtainingDeviceUUID := device.GetUUID()
-		if errObtainingDeviceUUID != nvml.SUCCESS {
-			return errObtainingDeviceUUID
+		if r.undiscoveredGPUs[i] {
+			continue
 		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
 
-		nvlibParentDevice, errObtainingParentDevice := h.nvdevice.NewDevice(device)
-		if errObtainingParentDevice != nil {
-			return errObtainingParentDevice
+	var duplicates []string
+	for i := 0; i < availableGpusOnNode; i++ {
+		if errsPerDevice[i] != nil {
+			return errsPerDevice[i]
 		}
-		migs, errRetrievingMigDevices := nvlibParentDevice.GetMigDevices()
-		if errRetrievingMigDevices != nil {
-			return errRetrievingMigDevices
+		migUUIDs := make([]string, 0, len(slicesPerDevice[i]))
+		for migUUID := range slicesPerDevice[i] {
+			migUUIDs = append(migUUIDs, migUUID)
 		}
-
-		for _, mig := range migs {
-			migUUID, _ := mig.GetUUID()
-			profile, errForProfile := mig.GetProfile()
-			if errForProfile != nil {
-				return errForProfile
+		sort.Strings(migUUIDs)
+		for _, migUUID := range migUUIDs {
+			prepared := slicesPerDevice[i][migUUID]
+			if profileName := recognizedProfile(instaslice, prepared.Profile); profileName != prepared.Profile {
+				log.Log.Info("slice found on the GPU has a profile not discovered on the node, tracking it as foreign",
+					"migUUID", migUUID, "gpu", prepared.Parent, "profile", prepared.Profile)
+				prepared.Profile = profileName
 			}
-
-			giID, errForMigGid := mig.GetGpuInstanceId()
-			if errForMigGid != nvml.SUCCESS {
-				return errForMigGid
-			}
-			gpuInstance, errRetrievingDeviceGid := device.GetGpuInstanceById(giID)
-			if errRetrievingDeviceGid != nvml.SUCCESS {
-				return errRetrievingDeviceGid
+			if instaslice.Status.Prepared == nil {
+				instaslice.Status.Prepared = make(map[string]inferencev1alpha1.PreparedDetails)
 			}
-			gpuInstanceInfo, errObtainingInfo := gpuInstance.GetInfo()
-			if errObtainingInfo != nvml.SUCCESS {
-				return errObtainingInfo
-			}
-
-			ciID, ret := mig.GetComputeInstanceId()
-			if ret != nvml.SUCCESS {
-				return ret
-			}
-			ci, ret := gpuInstance.GetComputeInstanceById(ciID)
-			if ret != nvml.SUCCESS {
-				return ret
-			}
-			ciInfo, ret := ci.GetInfo()
-			if ret != nvml.SUCCESS {
-				return ret
-			}
-			prepared := inferencev1alpha1.PreparedDetails{
-				Profile:  profile.GetInfo().String(),
-				Start:    gpuInstanceInfo.Placement.Start,
-				Size:     gpuInstanceInfo.Placement.Size,
-				Parent:   uuid,
-				Giinfoid: gpuInstanceInfo.Id,
-				Ciinfoid: ciInfo.Id,
-			}
-			if instaslice.Spec.Prepared == nil {
-				instaslice.Spec.Prepared = make(map[string]inferencev1alpha1.PreparedDetails)
+			// the first slice found keeps the entry, the other one is reported instead of silently dropped
+			if preparedConflicts(instaslice.Status.Prepared, migUUID, prepared) {
+				log.Log.Error(duplicateMigUUIDError(migUUID, instaslice.Status.Prepared[migUUID], prepared), "duplicate MIG UUID found during discovery")
+				duplicates = append(duplicates, migUUID)
+				continue
 			}
-			instaslice.Spec.Prepared[migUUID] = prepared
+			instaslice.Status.Prepared[migUUID] = prepared
 		}
 	}
+	setDuplicateMigUUIDCondition(&instaslice.Status, duplicates)
 	return nil
 }
 
+// danglingSlicesOnDevice returns the prepared entries of the slices found on the GPU at index, keyed by MIG UUID.
+func danglingSlicesOnDevice(h *deviceHandler, index int) (map[string]inferencev1alpha1.PreparedDetails, error) {
+	device, errObtainingDeviceHandle := h.nvml.DeviceGetHandleByIndex(index)
+	if errObtainingDeviceHandle != nvml.SUCCESS {
+		return nil, errObtainingDeviceHandle
+	}
+
+	uuid, errObtainingDeviceUUID := device.GetUUID()
+	if errObtainingDeviceUUID != nvml.SUCCESS {
+		return nil, errObtainingDeviceUUID
+	}
+
+	nvlibParentDevice, errObtainingParentDevice := h.nvdevice.NewDevice(device)
+	if errObtainingParentDevice != nil {
+		return nil, errObtainingParentDevice
+	}
+	migs, errRetrievingMigDevices := nvlibParentDevice.GetMigDevices()
+	if errRetrievingMigDevices != nil {
+		return nil, errRetrievingMigDevices
+	}
+
+	slices := make(map[string]inferencev1alpha1.PreparedDetails)
+	for _, mig := range migs {
+		migUUID, _ := mig.GetUUID()
+		giID, errForMigGid := mig.GetGpuInstanceId()
+		if errForMigGid != nvml.SUCCESS {
+			return nil, errForMigGid
+		}
+		gpuInstance, errRetrievingDeviceGid := device.GetGpuInstanceById(giID)
+		if errRetrievingDeviceGid != nvml.SUCCESS {
+			return nil, errRetrievingDeviceGid
+		}
+		gpuInstanceInfo, errObtainingInfo := gpuInstance.GetInfo()
+		if errObtainingInfo != nvml.SUCCESS {
+			return nil, errObtainingInfo
+		}
+
+		ciID, ret := mig.GetComputeInstanceId()
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		ci, ret := gpuInstance.GetComputeInstanceById(ciID)
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		ciInfo, ret := ci.GetInfo()
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		// the slice is still tracked when its profile cannot be named, so that nothing is carved over it
+		profileName, err := sliceProfileName(device, gpuInstance, ci)
+		if err != nil {
+			log.Log.Error(err, "unable to name the profile of a slice found on the GPU, tracking it as foreign", "migUUID", migUUID, "gpu", uuid)
+			profileName = ForeignSliceProfile
+		}
+		slices[migUUID] = inferencev1alpha1.PreparedDetails{
+			Profile:  profileName,
+			Start:    gpuInstanceInfo.Placement.Start,
+			Size:     gpuInstanceInfo.Placement.Size,
+			Parent:   uuid,
+			Giinfoid: gpuInstanceInfo.Id,
+			Ciinfoid: ciInfo.Id,
+		}
+	}
+	return slices, nil
+}
+
 // NewMigProfile constructs a new MigProfile struct using info from the giProfiles and ciProfiles used to create it.
 func NewMigProfile(giProfileID, ciProfileID, ciEngProfileID int, giSliceCount, ciSliceCount uint32, migMemorySizeMB, totalDeviceMemoryBytes uint64) *MigProfile {
 	return &MigProfile{
@@ -939,6 +1854,7 @@ func NewMigProfile(giProfileID, ciProfileID, ciEngProfileID int, giSliceCount, c
 }
 
 // Helper function to get GPU memory size in GBs.
+// totalDeviceMemory must not be reduced by ECC, see profileMemoryTotal.
 func getMigMemorySizeInGB(totalDeviceMemory, migMemorySizeMB uint64) uint64 {
 	const fracDenominator = 8
 	const oneMB = 1024 * 1024
@@ -961,61 +1877,130 @@ func (m MigProfile) String() string {
 	return fmt.Sprintf("%dc.%dg.%dgb%s", m.C, m.G, m.GB, suffix)
 }
 
-// Attributes returns the list of attributes associated with a MigProfile.
+// Attributes returns the list of attributes associated with a MigProfile, in their canonical order: media
+// extensions first, then the engine profile.
 func (m MigProfile) Attributes() []string {
 	var attr []string
 	switch m.GIProfileID {
-	case nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1:
+	case nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1, nvml.GPU_INSTANCE_PROFILE_2_SLICE_REV1:
 		attr = append(attr, AttributeMediaExtensions)
 	}
+	if m.CIEngProfileID != nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED {
+		attr = append(attr, fmt.Sprintf("%s%d", AttributeEngineProfilePrefix, m.CIEngProfileID))
+	}
 	return attr
 }
 
 // Create configmap which is used by Pods to consume MIG device
-func (r *InstaSliceDaemonsetReconciler) createConfigMap(ctx context.Context, migGPUUUID string, namespace string, podName string) error {
+func (r *InstaSliceDaemonsetReconciler) createConfigMap(ctx context.Context, migUUIDs []string, namespace string, podName string, podUUID string, profileName string, memorySizeMB uint64) error {
+	migGPUUUID, err := formatVisibleDevices(migUUIDs)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "invalid MIG UUIDs for ", "pod", podName)
+		return err
+	}
+	visibleDevices, err := r.visibleDevicesFor(ctx, migUUIDs)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to reference the MIG devices of ", "pod", podName)
+		return err
+	}
+	key := r.configMapKey(namespace, podName)
 	var configMap v1.ConfigMap
-	err := r.Get(ctx, types.NamespacedName{Name: podName, Namespace: namespace}, &configMap)
+	err = r.Get(ctx, key, &configMap)
 	if err != nil {
-		log.FromContext(ctx).Info("ConfigMap not found, creating for ", "pod", podName, "migGPUUUID", migGPUUUID)
+		log.FromContext(ctx).Info("ConfigMap not found, creating for ", "pod", podName, "migGPUUUID", migGPUUUID, "visibleDevices", visibleDevices)
 		configMapToCreate := &v1.ConfigMap{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      podName,
-				Namespace: namespace,
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Labels:    configMapLabels(podUUID, profileName, migGPUUUID),
 			},
 			Data: map[string]string{
-				"NVIDIA_VISIBLE_DEVICES": migGPUUUID,
-				"CUDA_VISIBLE_DEVICES":   migGPUUUID,
+				"NVIDIA_VISIBLE_DEVICES": visibleDevices,
+				"CUDA_VISIBLE_DEVICES":   visibleDevices,
 			},
 		}
+		// lets init containers of the pod self-configure for the slice they got
+		if r.ExposeSliceDetails {
+			configMapToCreate.Data["INSTASLICE_PROFILE"] = profileName
+			configMapToCreate.Data["INSTASLICE_MEMORY_MB"] = strconv.FormatUint(memorySizeMB, 10)
+		}
+		// deleting the ConfigMap while the slice exists would leave the pod without its device mapping
+		if r.ProtectConfigMaps {
+			controllerutil.AddFinalizer(configMapToCreate, ConfigMapFinalizer)
+		}
 		if err := r.Create(ctx, configMapToCreate); err != nil {
 			log.FromContext(ctx).Error(err, "failed to create ConfigMap")
 			return err
 		}
-
+		// a pod not reading the ConfigMap never sees its device
+		if r.settings().checkConfigMapReferences {
+			r.warnUnreferencedConfigMap(ctx, key, namespace, podName)
+		}
 	}
 	return nil
 }
 
 // Manage lifecycle of configmap, delete it once the pod is deleted from the system
-func (r *InstaSliceDaemonsetReconciler) deleteConfigMap(ctx context.Context, configMapName string, namespace string) error {
+// ConfigMaps of the pod found by label elsewhere, e.g. when the namespace of the allocation is stale, go as well.
+func (r *InstaSliceDaemonsetReconciler) deleteConfigMap(ctx context.Context, configMapName string, namespace string, podUUID string) error {
 	// Define the ConfigMap object with the name and namespace
+	key := r.configMapKey(namespace, configMapName)
 	configMap := &v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      configMapName,
-			Namespace: namespace,
+			Name:      key.Name,
+			Namespace: key.Namespace,
 		},
 	}
 
 	err := r.Delete(ctx, configMap)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			log.FromContext(ctx).Error(err, "configmap not found for ", "pod", configMapName)
-			return nil
+		if !errors.IsNotFound(err) {
+			return err
 		}
+		log.FromContext(ctx).Error(err, "configmap not found for ", "pod", configMapName)
+	} else {
+		log.FromContext(ctx).Info("ConfigMap deleted successfully ", "name", configMapName)
+	}
+
+	strays, err := r.strayConfigMaps(ctx, key, podUUID)
+	if err != nil {
 		return err
 	}
+	for i := range strays {
+		log.FromContext(ctx).Info("deleting ConfigMap of the pod found outside of the namespace of its allocation",
+			"pod", configMapName, "name", strays[i].Name, "namespace", strays[i].Namespace)
+		if err := r.Delete(ctx, &strays[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	log.FromContext(ctx).Info("ConfigMap deleted successfully ", "name", configMapName)
+// Remove the finalizer of the configmap once the slice is torn down, letting a pending deletion complete.
+// ConfigMaps of the pod found by label elsewhere are released as well.
+func (r *InstaSliceDaemonsetReconciler) removeConfigMapFinalizer(ctx context.Context, configMapName string, namespace string, podUUID string) error {
+	key := r.configMapKey(namespace, configMapName)
+	var configMap v1.ConfigMap
+	err := r.Get(ctx, key, &configMap)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	configMaps := []v1.ConfigMap{}
+	if err == nil {
+		configMaps = append(configMaps, configMap)
+	}
+	strays, err := r.strayConfigMaps(ctx, key, podUUID)
+	if err != nil {
+		return err
+	}
+	for _, configMap := range append(configMaps, strays...) {
+		if !controllerutil.RemoveFinalizer(&configMap, ConfigMapFinalizer) {
+			continue
+		}
+		if err := r.Update(ctx, &configMap); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
 	return nil
 }
 
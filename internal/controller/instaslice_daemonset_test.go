@@ -18,20 +18,28 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/NVIDIA/go-nvml/pkg/nvml"
 	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/kubernetes/scheme"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 )
 
 func TestCleanUp(t *testing.T) {
@@ -58,7 +66,7 @@ func TestCleanUp(t *testing.T) {
 	// Create a fake Kubernetes client
 	s := scheme.Scheme
 	_ = inferencev1alpha1.AddToScheme(s)
-	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).Build()
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).Build()
 
 	// Create a fake kubernetes clientset
 
@@ -66,15 +74,26 @@ func TestCleanUp(t *testing.T) {
 
 	// Create an InstaSliceDaemonsetReconciler
 	reconciler := &InstaSliceDaemonsetReconciler{
-		Client: fakeClient,
-		Scheme: s,
+		Client:      fakeClient,
+		Scheme:      s,
+		nvmlHandler: newDeviceHandler(server),
 	}
 	// Create a fake Instaslice resource
 	instaslice := &inferencev1alpha1.Instaslice{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: "node-1",
+			Name:      "node-1",
+			Namespace: "default",
 		},
 		Spec: inferencev1alpha1.InstasliceSpec{
+			Allocations: map[string]inferencev1alpha1.AllocationDetails{
+				"allocation-1": {
+					PodUUID:   "pod-uid-1",
+					PodName:   "pod-name-1",
+					Namespace: "default",
+				},
+			},
+		},
+		Status: inferencev1alpha1.InstasliceStatus{
 			Prepared: map[string]inferencev1alpha1.PreparedDetails{
 				"mig-uuid-1": {
 					PodUUID:  "pod-uid-1",
@@ -83,13 +102,6 @@ func TestCleanUp(t *testing.T) {
 					Ciinfoid: 1,
 				},
 			},
-			Allocations: map[string]inferencev1alpha1.AllocationDetails{
-				"allocation-1": {
-					PodUUID:   "pod-uid-1",
-					PodName:   "pod-name-1",
-					Namespace: "default",
-				},
-			},
 		},
 	}
 	fakeClient.Create(context.Background(), instaslice)
@@ -108,12 +120,627 @@ func TestCleanUp(t *testing.T) {
 	}
 
 	// Call the cleanUp function
-	reconciler.cleanUp(context.Background(), string(pod.UID))
+	err := reconciler.cleanUp(context.Background(), string(pod.UID))
+	assert.NoError(t, err)
 
 	// Verify the Instaslice resource was updated
 	var updatedInstaslice inferencev1alpha1.Instaslice
-	err := fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1"}, &updatedInstaslice)
+	err = fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &updatedInstaslice)
 	assert.NoError(t, err)
-	assert.Empty(t, updatedInstaslice.Spec.Prepared)
+	assert.Empty(t, updatedInstaslice.Status.Prepared)
 	assert.Empty(t, updatedInstaslice.Spec.Allocations)
 }
+
+func TestReconcileRetriesDeletionWhileSliceIsInUse(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	// a process holds the slice for the first two attempts
+	inUse := 2
+	for gi := range device.GpuInstances {
+		for ci := range gi.ComputeInstances {
+			destroy := ci.DestroyFunc
+			ci.DestroyFunc = func() nvml.Return {
+				if inUse > 0 {
+					inUse--
+					return nvml.ERROR_IN_USE
+				}
+				return destroy()
+			}
+		}
+	}
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	var backoffs []time.Duration
+	for i := 0; i < 2; i++ {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+		require.NoError(t, err)
+		backoffs = append(backoffs, result.RequeueAfter)
+		require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+		assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+		assert.Len(t, instaslice.Status.Prepared, 1)
+		assert.Len(t, device.GpuInstances, 1)
+	}
+	assert.Less(t, backoffs[0], backoffs[1])
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Empty(t, instaslice.Spec.Allocations)
+	assert.Empty(t, instaslice.Status.Prepared)
+	assert.Empty(t, device.GpuInstances)
+	assert.NotContains(t, reconciler.sliceInUseRetries, "pod-uid-0")
+}
+
+func TestPruneSliceInUseRetriesForgetsRemovedAllocations(t *testing.T) {
+	reconciler := &InstaSliceDaemonsetReconciler{}
+	reconciler.sliceInUseBackoff("pod-uid-0")
+	reconciler.sliceInUseBackoff("pod-uid-1")
+	instaslice := &inferencev1alpha1.Instaslice{Spec: inferencev1alpha1.InstasliceSpec{
+		Allocations: map[string]inferencev1alpha1.AllocationDetails{
+			"pod-uid-0": {PodUUID: "pod-uid-0", Allocationstatus: inferencev1alpha1.AllocationStatusDeleting},
+		},
+	}}
+
+	reconciler.pruneSliceInUseRetries(instaslice)
+
+	assert.Equal(t, map[string]int{"pod-uid-0": 1}, reconciler.sliceInUseRetries)
+}
+
+func TestReconcileRequeuesCreatingBeforeDiscovery(t *testing.T) {
+	initCalled := false
+	server := dgxa100.New()
+	server.InitFunc = func() nvml.Return {
+		initCalled = true
+		return nvml.SUCCESS
+	}
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	instaslice := &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "node-1",
+			Namespace: "default",
+		},
+		Spec: inferencev1alpha1.InstasliceSpec{
+			Allocations: map[string]inferencev1alpha1.AllocationDetails{
+				"pod-uid-1": {
+					PodUUID:          "pod-uid-1",
+					PodName:          "pod-name-1",
+					Namespace:        "default",
+					GPUUUID:          "GPU-1",
+					Profile:          "1g.5gb",
+					Allocationstatus: inferencev1alpha1.AllocationStatusCreating,
+				},
+			},
+		},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(instaslice).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:      fakeClient,
+		Scheme:      s,
+		nvmlHandler: newDeviceHandler(server),
+	}
+
+	os.Setenv("NODE_NAME", "node-1")
+	defer os.Unsetenv("NODE_NAME")
+
+	result, err := reconciler.Reconcile(context.Background(), ctrl.Request{})
+	assert.NoError(t, err)
+	assert.NotZero(t, result.RequeueAfter)
+	assert.False(t, initCalled)
+
+	var updatedInstaslice inferencev1alpha1.Instaslice
+	err = fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &updatedInstaslice)
+	assert.NoError(t, err)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, updatedInstaslice.Spec.Allocations["pod-uid-1"].Allocationstatus)
+	assert.Empty(t, updatedInstaslice.Status.Prepared)
+}
+
+func TestReconcileAdvancesLastReconcileTime(t *testing.T) {
+	origNow := now
+	defer func() { now = origNow }()
+	first := metav1.NewTime(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC))
+	second := metav1.NewTime(first.Add(time.Minute))
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	instaslice := &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "node-1",
+			Namespace: "default",
+		},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(instaslice, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}).WithStatusSubresource(instaslice).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client: fakeClient,
+		Scheme: s,
+	}
+
+	os.Setenv("NODE_NAME", "node-1")
+	defer os.Unsetenv("NODE_NAME")
+
+	var updatedInstaslice inferencev1alpha1.Instaslice
+	now = func() metav1.Time { return first }
+	result, err := reconciler.Reconcile(context.Background(), ctrl.Request{})
+	assert.NoError(t, err)
+	assert.Equal(t, reconcileHeartbeatInterval, result.RequeueAfter)
+	err = fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &updatedInstaslice)
+	assert.NoError(t, err)
+	assert.True(t, first.Equal(updatedInstaslice.Status.LastReconcileTime))
+
+	now = func() metav1.Time { return second }
+	_, err = reconciler.Reconcile(context.Background(), ctrl.Request{})
+	assert.NoError(t, err)
+	err = fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &updatedInstaslice)
+	assert.NoError(t, err)
+	assert.True(t, second.Equal(updatedInstaslice.Status.LastReconcileTime))
+}
+
+func TestRecordReconcileTimeUpdatesSliceCounts(t *testing.T) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	instaslice := newPlacementTestInstaslice()
+	instaslice.Namespace = "default"
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(instaslice).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client: fakeClient,
+		Scheme: s,
+	}
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	require.NoError(t, reconciler.recordReconcileTime(ctx, nsName))
+	var updatedInstaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &updatedInstaslice))
+	assert.Equal(t, 7, updatedInstaslice.Status.TotalSlices)
+	assert.Equal(t, 1, updatedInstaslice.Status.UsedSlices)
+	assert.Equal(t, 6, updatedInstaslice.Status.FreeSlices)
+
+	updatedInstaslice.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{
+		"pod-uid-1": {PodUUID: "pod-uid-1", GPUUUID: "GPU-1", Profile: "1g.5gb", Start: 1, Size: 1, Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+	}
+	require.NoError(t, fakeClient.Update(ctx, &updatedInstaslice))
+	require.NoError(t, reconciler.recordReconcileTime(ctx, nsName))
+	require.NoError(t, fakeClient.Get(ctx, nsName, &updatedInstaslice))
+	assert.Equal(t, 7, updatedInstaslice.Status.TotalSlices)
+	assert.Equal(t, 2, updatedInstaslice.Status.UsedSlices)
+	assert.Equal(t, 5, updatedInstaslice.Status.FreeSlices)
+}
+
+func TestCreateConfigMapExposesSliceDetails(t *testing.T) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:             fakeClient,
+		Scheme:             s,
+		ExposeSliceDetails: true,
+	}
+
+	err := reconciler.createConfigMap(context.Background(), []string{"MIG-1"}, "default", "pod-name-1", "pod-uid-1", "1g.5gb", 4864)
+	assert.NoError(t, err)
+
+	var configMap v1.ConfigMap
+	err = fakeClient.Get(context.Background(), types.NamespacedName{Name: "pod-name-1", Namespace: "default"}, &configMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "MIG-1", configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	assert.Equal(t, "1g.5gb", configMap.Data["INSTASLICE_PROFILE"])
+	assert.Equal(t, "4864", configMap.Data["INSTASLICE_MEMORY_MB"])
+}
+
+func TestCreateConfigMapListsEveryMigUUID(t *testing.T) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s}
+	ctx := context.Background()
+	migUUIDs := []string{
+		"MIG-c1a7e0b4-6f2d-5e3a-9b1c-0d2e3f4a5b6c",
+		"MIG-0a1b2c3d-4e5f-5a6b-8c7d-9e0f1a2b3c4d",
+		"MIG-7f6e5d4c-3b2a-5190-8f7e-6d5c4b3a2910",
+	}
+
+	require.NoError(t, reconciler.createConfigMap(ctx, migUUIDs, "default", "pod-name-1", "pod-uid-1", "3g.20gb", 19968))
+
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-name-1", Namespace: "default"}, &configMap))
+	// the order of the compute instances is kept, it gives the device indexes in the container
+	expected := "MIG-c1a7e0b4-6f2d-5e3a-9b1c-0d2e3f4a5b6c,MIG-0a1b2c3d-4e5f-5a6b-8c7d-9e0f1a2b3c4d,MIG-7f6e5d4c-3b2a-5190-8f7e-6d5c4b3a2910"
+	assert.Equal(t, expected, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	assert.Equal(t, expected, configMap.Data["CUDA_VISIBLE_DEVICES"])
+}
+
+func TestCreateConfigMapRefusesMalformedMigUUIDs(t *testing.T) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s}
+	ctx := context.Background()
+
+	for _, migUUIDs := range [][]string{nil, {"MIG-1", ""}, {"MIG-1", " "}, {"MIG-1", "MIG-1"}, {"MIG-1,MIG-2"}} {
+		assert.Error(t, reconciler.createConfigMap(ctx, migUUIDs, "default", "pod-name-1", "pod-uid-1", "1g.5gb", 4864), "%q", migUUIDs)
+	}
+	err := fakeClient.Get(ctx, types.NamespacedName{Name: "pod-name-1", Namespace: "default"}, &v1.ConfigMap{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// surrounding spaces are dropped
+	formatted, err := formatVisibleDevices([]string{" MIG-1", "MIG-2 "})
+	require.NoError(t, err)
+	assert.Equal(t, "MIG-1,MIG-2", formatted)
+}
+
+func TestCreateConfigMapOmitsSliceDetailsByDefault(t *testing.T) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client: fakeClient,
+		Scheme: s,
+	}
+
+	err := reconciler.createConfigMap(context.Background(), []string{"MIG-1"}, "default", "pod-name-1", "pod-uid-1", "1g.5gb", 4864)
+	assert.NoError(t, err)
+
+	var configMap v1.ConfigMap
+	err = fakeClient.Get(context.Background(), types.NamespacedName{Name: "pod-name-1", Namespace: "default"}, &configMap)
+	assert.NoError(t, err)
+	assert.NotContains(t, configMap.Data, "INSTASLICE_PROFILE")
+	assert.NotContains(t, configMap.Data, "INSTASLICE_MEMORY_MB")
+}
+
+func TestProtectedConfigMapOutlivesSlice(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-1")
+	nvmllib := newFakeGPUs(1)
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(node).WithStatusSubresource(&inferencev1alpha1.Instaslice{}, &v1.Node{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:            fakeClient,
+		Scheme:            s,
+		ProtectConfigMaps: true,
+		nvmlHandler:       newDeviceHandler(nvmllib),
+	}
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	configMapName := types.NamespacedName{Name: "pod-protected", Namespace: "default"}
+	_, err := reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+	device, _ := nvmllib.DeviceGetHandleByIndex(0)
+	gpuUUID, _ := device.GetUUID()
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	instaslice.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{
+		"pod-uid-protected": {
+			PodUUID:          "pod-uid-protected",
+			PodName:          "pod-protected",
+			Namespace:        "default",
+			GPUUUID:          gpuUUID,
+			Nodename:         "node-1",
+			Profile:          "1g.5gb",
+			Start:            0,
+			Size:             1,
+			Giprofileid:      nvml.GPU_INSTANCE_PROFILE_1_SLICE,
+			CIProfileID:      nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+			CIEngProfileID:   nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED,
+			Allocationstatus: inferencev1alpha1.AllocationStatusCreating,
+		},
+	}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	// deleting the ConfigMap while the slice is live only marks it for deletion
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, configMapName, &configMap))
+	require.NoError(t, fakeClient.Delete(ctx, &configMap))
+	require.NoError(t, fakeClient.Get(ctx, configMapName, &configMap))
+	assert.False(t, configMap.DeletionTimestamp.IsZero())
+	assert.NotEmpty(t, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-protected"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+	instaslice.Spec.Allocations["pod-uid-protected"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	assert.Empty(t, device.(*dgxa100.Device).GpuInstances)
+	err = fakeClient.Get(ctx, configMapName, &configMap)
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestReconcileSkipsAllocationWithoutTargetGpu(t *testing.T) {
+	initCalled := false
+	server := dgxa100.New()
+	server.InitFunc = func() nvml.Return {
+		initCalled = true
+		return nvml.SUCCESS
+	}
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	instaslice := &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "node-1",
+			Namespace: "default",
+		},
+		Spec: inferencev1alpha1.InstasliceSpec{
+			MigGPUUUID: map[string]string{"GPU-1": "NVIDIA A100-PCIE-40GB"},
+			Allocations: map[string]inferencev1alpha1.AllocationDetails{
+				"pod-uid-1": {
+					PodUUID:          "pod-uid-1",
+					PodName:          "pod-name-1",
+					Namespace:        "default",
+					GPUUUID:          "",
+					Profile:          "1g.5gb",
+					Allocationstatus: inferencev1alpha1.AllocationStatusCreating,
+				},
+			},
+		},
+		Status: inferencev1alpha1.InstasliceStatus{Processed: "true"},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(instaslice).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:      fakeClient,
+		Scheme:      s,
+		nvmlHandler: newDeviceHandler(server),
+	}
+
+	os.Setenv("NODE_NAME", "node-1")
+	defer os.Unsetenv("NODE_NAME")
+
+	result, err := reconciler.Reconcile(context.Background(), ctrl.Request{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Second, result.RequeueAfter)
+	assert.False(t, initCalled)
+
+	var updatedInstaslice inferencev1alpha1.Instaslice
+	err = fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &updatedInstaslice)
+	assert.NoError(t, err)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, updatedInstaslice.Spec.Allocations["pod-uid-1"].Allocationstatus)
+}
+
+// newTwoGPUTestReconciler returns a daemonset reconciler on a node with two discovered fake GPUs, holding a creating
+// 1g.5gb allocation at the first placement of the GPU of each given index, and the UUIDs of the GPUs.
+func newTwoGPUTestReconciler(t *testing.T, gpus ...int) (*InstaSliceDaemonsetReconciler, client.Client, []string) {
+	t.Setenv(FakeGPUEnv, "2")
+	t.Setenv("NODE_NAME", "node-1")
+	nvmllib, err := newNvmlLib(nil)
+	require.NoError(t, err)
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(node).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}, &v1.Node{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:      fakeClient,
+		Scheme:      s,
+		nvmlHandler: newDeviceHandler(nvmllib),
+	}
+	ctx := context.Background()
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+
+	var uuids []string
+	for i := 0; i < 2; i++ {
+		device, ret := nvmllib.DeviceGetHandleByIndex(i)
+		require.Equal(t, nvml.SUCCESS, ret)
+		uuid, ret := device.GetUUID()
+		require.Equal(t, nvml.SUCCESS, ret)
+		uuids = append(uuids, uuid)
+	}
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	instaslice.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
+	for _, gpu := range gpus {
+		podUUID := fmt.Sprintf("pod-uid-%d", gpu)
+		instaslice.Spec.Allocations[podUUID] = inferencev1alpha1.AllocationDetails{
+			PodUUID:          podUUID,
+			PodName:          fmt.Sprintf("pod-%d", gpu),
+			Namespace:        "default",
+			GPUUUID:          uuids[gpu],
+			Nodename:         "node-1",
+			Profile:          "1g.5gb",
+			Start:            0,
+			Size:             1,
+			Giprofileid:      nvml.GPU_INSTANCE_PROFILE_1_SLICE,
+			CIProfileID:      nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+			CIEngProfileID:   nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED,
+			Allocationstatus: inferencev1alpha1.AllocationStatusCreating,
+		}
+	}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	return reconciler, fakeClient, uuids
+}
+
+// assertCreatedOn asserts that the allocation of the pod is created and its slice prepared on the GPU.
+func assertCreatedOn(t *testing.T, instaslice *inferencev1alpha1.Instaslice, podUUID, gpuUUID string) {
+	t.Helper()
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations[podUUID].Allocationstatus, podUUID)
+	var parents []string
+	for _, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID == podUUID {
+			parents = append(parents, prepared.Parent)
+		}
+	}
+	assert.Equal(t, []string{gpuUUID}, parents, podUUID)
+}
+
+func TestReconcileCreatesEveryPendingAllocationOnItsGPU(t *testing.T) {
+	reconciler, fakeClient, uuids := newTwoGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assertCreatedOn(t, &instaslice, "pod-uid-0", uuids[0])
+	assertCreatedOn(t, &instaslice, "pod-uid-1", uuids[1])
+}
+
+func TestReconcileCreatesSliceOnTheGPUOfTheAllocation(t *testing.T) {
+	// a single allocation goes through the sequential path, on the second GPU of the node
+	reconciler, fakeClient, uuids := newTwoGPUTestReconciler(t, 1)
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assertCreatedOn(t, &instaslice, "pod-uid-1", uuids[1])
+}
+
+func TestAllocationPlacementIsReadFromTheAllocation(t *testing.T) {
+	instaslice := &inferencev1alpha1.Instaslice{}
+	allocation := inferencev1alpha1.AllocationDetails{PodUUID: "pod-uid-1", Start: 4, Size: 2}
+
+	placement, err := allocationPlacement(instaslice, allocation)
+	require.NoError(t, err)
+	assert.Equal(t, nvml.GpuInstancePlacement{Start: 4, Size: 2}, placement)
+
+	// a slice of the pod is already prepared, it is not carved again
+	instaslice.Status.Prepared = map[string]inferencev1alpha1.PreparedDetails{"MIG-1": {PodUUID: "pod-uid-1"}}
+	_, err = allocationPlacement(instaslice, allocation)
+	assert.Error(t, err)
+}
+
+// newDanglingSlicesTestHandler returns a node with count fake GPUs, each holding slices left behind by a previous run.
+func newDanglingSlicesTestHandler(t testing.TB, count int) *deviceHandler {
+	nvmllib := newFakeGPUs(count)
+	for i := 0; i < count; i++ {
+		device, ret := nvmllib.DeviceGetHandleByIndex(i)
+		require.Equal(t, nvml.SUCCESS, ret)
+		for start := uint32(0); start < uint32(i%4+1); start++ {
+			gi, ret := createGpuInstance(device, nvml.GPU_INSTANCE_PROFILE_1_SLICE, nvml.GpuInstancePlacement{Start: start, Size: 1})
+			require.Equal(t, nvml.SUCCESS, ret)
+			_, ret = createComputeInstance(gi, nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED)
+			require.Equal(t, nvml.SUCCESS, ret)
+		}
+	}
+	return newDeviceHandler(nvmllib)
+}
+
+func TestDiscoverDanglingSlicesConcurrently(t *testing.T) {
+	handler := newDanglingSlicesTestHandler(t, 8)
+
+	sequential := &inferencev1alpha1.Instaslice{}
+	reconciler := &InstaSliceDaemonsetReconciler{nvmlHandler: handler}
+	require.NoError(t, reconciler.discoverDanglingSlices(sequential))
+	assert.Len(t, sequential.Status.Prepared, 20)
+
+	for _, concurrency := range []int{2, 4, 16} {
+		concurrent := &inferencev1alpha1.Instaslice{}
+		reconciler := &InstaSliceDaemonsetReconciler{nvmlHandler: handler, DiscoveryConcurrency: concurrency}
+		require.NoError(t, reconciler.discoverDanglingSlices(concurrent))
+		assert.Equal(t, sequential.Status.Prepared, concurrent.Status.Prepared, "concurrency %d", concurrency)
+	}
+}
+
+func BenchmarkDiscoverDanglingSlices(b *testing.B) {
+	handler := newDanglingSlicesTestHandler(b, 8)
+	for _, concurrency := range []int{1, 8} {
+		reconciler := &InstaSliceDaemonsetReconciler{nvmlHandler: handler, DiscoveryConcurrency: concurrency}
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := reconciler.discoverDanglingSlices(&inferencev1alpha1.Instaslice{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileSkipsSettledAllocations(t *testing.T) {
+	reconciler, fakeClient, _, updates := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	ungated := instaslice.Spec.Allocations["pod-uid-1"]
+	ungated.Allocationstatus = inferencev1alpha1.AllocationStatusUngated
+	instaslice.Spec.Allocations["pod-uid-1"] = ungated
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	server := reconciler.handler().nvml.(*dgxa100.Server)
+	initCalls := len(server.InitCalls())
+	*updates = 0
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	nodeVersion := node.ResourceVersion
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, reconcileHeartbeatInterval, result.RequeueAfter)
+	assert.Equal(t, initCalls, len(server.InitCalls()))
+	assert.Zero(t, *updates)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.Equal(t, nodeVersion, node.ResourceVersion)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.NotNil(t, instaslice.Status.LastReconcileTime)
+}
+
+func TestDiscoveryAbortsOnCancelledContext(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-1")
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	calls := 0
+	// like the API server, refuse requests whose context is done
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				calls++
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				calls++
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				return c.Create(ctx, obj, opts...)
+			},
+			SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+				calls++
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+			},
+		}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s, nvmlHandler: newDeviceHandler(newFakeGPUs(1))}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	// the first refused call ends discovery
+	assert.Equal(t, 1, calls)
+	var instaslice inferencev1alpha1.Instaslice
+	err = fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice)
+	assert.True(t, apierrors.IsNotFound(err))
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"go.opentelemetry.io/otel/attribute"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// carveSliceWithIntent carves the slice of the allocation once the intent to do so is recorded in the status of
+// the instaslice. A slice an earlier run carved for the intent, before crashing ahead of its prepared entries,
+// is taken over instead of being carved a second time and leaving the first one behind.
+func (r *InstaSliceDaemonsetReconciler) carveSliceWithIntent(ctx context.Context, device nvml.Device, instaslice inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails, placement nvml.GpuInstancePlacement) (created preparedMig, err error) {
+	ctx, span := r.startSliceSpan(ctx, SpanSliceCreation, allocation)
+	defer func() {
+		if err == nil {
+			span.SetAttributes(attribute.StringSlice("instaslice.mig.uuids", created.migUUIDs()))
+		}
+		endSliceSpan(span, err)
+	}()
+	allocation, err = resolveAllocationProfile(device, instaslice, allocation)
+	if err != nil {
+		return preparedMig{}, err
+	}
+	if intent, exists := instaslice.Status.SliceIntents[allocation.PodUUID]; exists {
+		recovered, found, err := r.recoverIntendedSlice(ctx, device, &instaslice, allocation, intent)
+		if err != nil {
+			return preparedMig{}, fmt.Errorf("unable to recover the slice being carved: %w", err)
+		}
+		if found {
+			log.FromContext(ctx).Info("recovered slice carved before a restart for ", "pod", allocation.PodName, "gi", recovered.gid, "migUUID", recovered.visibleDevices())
+			return recovered, nil
+		}
+	}
+	if err := r.recordSliceIntent(ctx, instaslice.Name, allocation); err != nil {
+		return preparedMig{}, fmt.Errorf("unable to record the intent to carve the slice: %w", err)
+	}
+	return r.carveSlice(ctx, device, instaslice, allocation, placement)
+}
+
+// recordSliceIntent records in the status of the instaslice that the slice of the allocation is about to be carved.
+func (r *InstaSliceDaemonsetReconciler) recordSliceIntent(ctx context.Context, instasliceName string, allocation inferencev1alpha1.AllocationDetails) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var latest inferencev1alpha1.Instaslice
+		if err := r.Get(ctx, types.NamespacedName{Name: instasliceName, Namespace: r.instasliceNamespace()}, &latest); err != nil {
+			return err
+		}
+		current, exists := latest.Status.SliceIntents[allocation.PodUUID]
+		if exists && current.GPUUUID == allocation.GPUUUID && current.Start == allocation.Start && current.Size == allocation.Size {
+			return nil
+		}
+		if latest.Status.SliceIntents == nil {
+			latest.Status.SliceIntents = make(map[string]inferencev1alpha1.SliceIntent)
+		}
+		latest.Status.SliceIntents[allocation.PodUUID] = inferencev1alpha1.SliceIntent{
+			PodName: allocation.PodName,
+			GPUUUID: allocation.GPUUUID,
+			Profile: allocation.Profile,
+			Start:   allocation.Start,
+			Size:    allocation.Size,
+			Since:   now(),
+		}
+		return r.Status().Update(ctx, &latest)
+	})
+}
+
+// clearSliceIntents drops the intents of the pods, once their prepared entries are written or their slices are gone.
+func (r *InstaSliceDaemonsetReconciler) clearSliceIntents(ctx context.Context, instasliceName string, podUUIDs ...string) error {
+	return r.clearCarvedSliceIntents(ctx, instasliceName, nil, podUUIDs...)
+}
+
+// clearCarvedSliceIntents drops the intents of the pods whose slices were carved and records, in the same status
+// update, how long carving a slice of each profile last took. Written apart, the durations would go with an
+// object the update clearing the intents already made stale. Zero durations, of slices picked up from the cache
+// of a previous reconcile, were not timed and are skipped.
+func (r *InstaSliceDaemonsetReconciler) clearCarvedSliceIntents(ctx context.Context, instasliceName string, durations map[string]metav1.Duration, podUUIDs ...string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var latest inferencev1alpha1.Instaslice
+		if err := r.Get(ctx, types.NamespacedName{Name: instasliceName, Namespace: r.instasliceNamespace()}, &latest); err != nil {
+			return err
+		}
+		changed := false
+		for _, podUUID := range podUUIDs {
+			if _, exists := latest.Status.SliceIntents[podUUID]; exists {
+				delete(latest.Status.SliceIntents, podUUID)
+				changed = true
+			}
+		}
+		for profileName, duration := range durations {
+			if duration.Duration == 0 {
+				continue
+			}
+			if latest.Status.LastSliceCreationDuration == nil {
+				latest.Status.LastSliceCreationDuration = make(map[string]metav1.Duration)
+			}
+			latest.Status.LastSliceCreationDuration[profileName] = duration
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		return r.Status().Update(ctx, &latest)
+	})
+}
+
+// intendedGpuInstance returns the info of the gi an earlier run carved for the intent of the pod: a gi of the
+// profile at the placement of the intent, or at another one NVML may have moved it to, that no other pod claims.
+func intendedGpuInstance(device nvml.Device, instaslice *inferencev1alpha1.Instaslice, podUUID string, intent inferencev1alpha1.SliceIntent, giProfileInfo nvml.GpuInstanceProfileInfo) (nvml.GpuInstanceInfo, bool, error) {
+	claimed := make(map[uint32]bool)
+	for _, prepared := range instaslice.Status.Prepared {
+		if prepared.Parent == intent.GPUUUID && prepared.PodUUID != "" && prepared.PodUUID != podUUID {
+			claimed[prepared.Giinfoid] = true
+		}
+	}
+	// the placements other pods are being carved at hold their own slices
+	intendedByOthers := make(map[uint32]bool)
+	for otherPodUUID, other := range instaslice.Status.SliceIntents {
+		if otherPodUUID != podUUID && other.GPUUUID == intent.GPUUUID {
+			intendedByOthers[other.Start] = true
+		}
+	}
+	gpuInstances, ret := device.GetGpuInstances(&giProfileInfo)
+	if ret != nvml.SUCCESS {
+		return nvml.GpuInstanceInfo{}, false, ret
+	}
+	var relocated *nvml.GpuInstanceInfo
+	for _, gi := range gpuInstances {
+		info, ret := gi.GetInfo()
+		if ret != nvml.SUCCESS {
+			return nvml.GpuInstanceInfo{}, false, ret
+		}
+		if claimed[info.Id] {
+			continue
+		}
+		if info.Placement.Start == intent.Start {
+			return info, true, nil
+		}
+		if relocated == nil && !intendedByOthers[info.Placement.Start] {
+			relocated = &info
+		}
+	}
+	if relocated != nil {
+		return *relocated, true, nil
+	}
+	return nvml.GpuInstanceInfo{}, false, nil
+}
+
+// recoverIntendedSlice returns the slice an earlier run carved for the intent if it was carved entirely. A gi
+// whose compute instances were not all created is destroyed, for the slice to be carved again from scratch.
+func (r *InstaSliceDaemonsetReconciler) recoverIntendedSlice(ctx context.Context, device nvml.Device, instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails, intent inferencev1alpha1.SliceIntent) (preparedMig, bool, error) {
+	giProfileInfo, ret := device.GetGpuInstanceProfileInfo(allocation.Giprofileid)
+	if ret != nvml.SUCCESS {
+		return preparedMig{}, false, ret
+	}
+	giInfo, found, err := intendedGpuInstance(device, instaslice, allocation.PodUUID, intent, giProfileInfo)
+	if err != nil || !found {
+		return preparedMig{}, false, err
+	}
+	computeInstances, err := r.getCreatedComputeInstances(ctx, device, giInfo.Id)
+	if err != nil {
+		return preparedMig{}, false, err
+	}
+	if len(computeInstances) != computeInstanceCount(allocation) {
+		log.FromContext(ctx).Info("rolling back slice partially carved before a restart for ", "pod", allocation.PodName, "gi", giInfo.Id)
+		if ret := destroySlice(device, int(giInfo.Id), computeInstanceIDs(computeInstances)...); ret != nvml.SUCCESS {
+			return preparedMig{}, false, ret
+		}
+		return preparedMig{}, false, nil
+	}
+	recovered := preparedMig{
+		gid:          giInfo.Id,
+		miguuid:      computeInstances[0].miguuid,
+		cid:          computeInstances[0].cid,
+		memorySizeMB: giProfileInfo.MemorySizeMB,
+		relocated:    giInfo.Placement.Start != allocation.Start,
+		start:        giInfo.Placement.Start,
+	}
+	if len(computeInstances) > 1 {
+		recovered.computeInstances = computeInstances
+	}
+	return recovered, true, nil
+}
+
+// rollBackSliceIntent destroys the slice an earlier run carved for the pod without recording its prepared
+// entries, the pod went away before the slice could be taken over.
+func (r *InstaSliceDaemonsetReconciler) rollBackSliceIntent(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) error {
+	intent, exists := instaslice.Status.SliceIntents[allocation.PodUUID]
+	if !exists {
+		return nil
+	}
+	for _, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID == allocation.PodUUID {
+			return nil
+		}
+	}
+	nvmllib := r.handler().nvml
+	device, ret := nvmllib.DeviceGetHandleByUUID(intent.GPUUUID)
+	if ret != nvml.SUCCESS {
+		return ret
+	}
+	giProfileInfo, ret := device.GetGpuInstanceProfileInfo(allocation.Giprofileid)
+	if ret != nvml.SUCCESS {
+		return ret
+	}
+	giInfo, found, err := intendedGpuInstance(device, instaslice, allocation.PodUUID, intent, giProfileInfo)
+	if err != nil || !found {
+		return err
+	}
+	computeInstances, err := r.getCreatedComputeInstances(ctx, device, giInfo.Id)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("rolling back slice carved before a restart for ", "pod", allocation.PodName, "gi", giInfo.Id)
+	if ret := destroySlice(device, int(giInfo.Id), computeInstanceIDs(computeInstances)...); ret == nvml.ERROR_IN_USE {
+		return fmt.Errorf("%w: gi %d on GPU %s", errSliceInUse, giInfo.Id, intent.GPUUUID)
+	} else if ret != nvml.SUCCESS {
+		return ret
+	}
+	return nil
+}
+
+// preparedSliceOf returns the slice whose prepared entries were written for the allocation by an earlier run that
+// stopped before setting the allocation to created, so that its creation is finished rather than a second slice
+// carved. It returns false when the allocation has no prepared entry on the GPU.
+func preparedSliceOf(device nvml.Device, instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) (preparedMig, bool) {
+	var slice preparedMig
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID != allocation.PodUUID || prepared.Parent != allocation.GPUUUID {
+			continue
+		}
+		slice.gid = prepared.Giinfoid
+		slice.start = prepared.Start
+		slice.computeInstances = append(slice.computeInstances, preparedComputeInstance{cid: prepared.Ciinfoid, miguuid: migUUID})
+	}
+	if len(slice.computeInstances) == 0 {
+		return preparedMig{}, false
+	}
+	sort.Slice(slice.computeInstances, func(i, j int) bool {
+		return slice.computeInstances[i].cid < slice.computeInstances[j].cid
+	})
+	slice.cid = slice.computeInstances[0].cid
+	slice.miguuid = slice.computeInstances[0].miguuid
+	if len(slice.computeInstances) == 1 {
+		slice.computeInstances = nil
+	}
+	// the memory is only informational, a GPU failing to report it leaves it unset
+	if giProfileInfo, ret := device.GetGpuInstanceProfileInfo(allocation.Giprofileid); ret == nvml.SUCCESS {
+		slice.memorySizeMB = giProfileInfo.MemorySizeMB
+	}
+	return slice, true
+}
+
+// computeInstanceIDs returns the ids of the compute instances.
+func computeInstanceIDs(computeInstances []preparedComputeInstance) []int {
+	ids := make([]int, 0, len(computeInstances))
+	for _, ci := range computeInstances {
+		ids = append(ids, int(ci.cid))
+	}
+	return ids
+}
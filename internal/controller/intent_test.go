@@ -0,0 +1,149 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func onlyGpuInstance(t *testing.T, device *dgxa100.Device) *dgxa100.GpuInstance {
+	require.Len(t, device.GpuInstances, 1)
+	for gi := range device.GpuInstances {
+		return gi
+	}
+	return nil
+}
+
+func TestReconcileRecoversSliceCarvedBeforeCrash(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+
+	// the daemonset carves the slice then crashes before writing its prepared entry
+	carved, err := reconciler.carveSliceWithIntent(ctx, device, instaslice, allocation, nvml.GpuInstancePlacement{Start: 0, Size: 1})
+	require.NoError(t, err)
+	reconciler.slices = sliceCache{}
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	require.Contains(t, instaslice.Status.SliceIntents, "pod-uid-0")
+	assert.Empty(t, instaslice.Status.Prepared)
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	gi := onlyGpuInstance(t, device)
+	assert.Equal(t, carved.gid, gi.Info.Id)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	require.Contains(t, instaslice.Status.Prepared, carved.miguuid)
+	assert.Equal(t, "pod-uid-0", instaslice.Status.Prepared[carved.miguuid].PodUUID)
+	assert.Empty(t, instaslice.Status.SliceIntents)
+}
+
+func TestReconcileRollsBackPartiallyCarvedSlice(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+
+	// the daemonset crashes between creating the gi and its ci
+	require.NoError(t, reconciler.recordSliceIntent(ctx, "node-1", instaslice.Spec.Allocations["pod-uid-0"]))
+	giProfileInfo, ret := device.GetGpuInstanceProfileInfo(nvml.GPU_INSTANCE_PROFILE_1_SLICE)
+	require.Equal(t, nvml.SUCCESS, ret)
+	_, ret = device.CreateGpuInstanceWithPlacement(&giProfileInfo, &nvml.GpuInstancePlacement{Start: 0, Size: 1})
+	require.Equal(t, nvml.SUCCESS, ret)
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	// the empty gi made way for a complete slice at the placement of the allocation
+	gi := onlyGpuInstance(t, device)
+	assert.Equal(t, uint32(0), gi.Info.Placement.Start)
+	assert.Len(t, gi.ComputeInstances, 1)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, uint32(0), instaslice.Spec.Allocations["pod-uid-0"].Start)
+	assert.Len(t, instaslice.Status.Prepared, 1)
+	assert.Empty(t, instaslice.Status.SliceIntents)
+}
+
+func TestCleanUpRollsBackSliceCarvedBeforeCrash(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	_, err := reconciler.carveSliceWithIntent(ctx, device, instaslice, allocation, nvml.GpuInstancePlacement{Start: 0, Size: 1})
+	require.NoError(t, err)
+	reconciler.slices = sliceCache{}
+
+	// the pod went away while the daemonset was down
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	require.NoError(t, reconciler.cleanUp(ctx, "pod-uid-0"))
+
+	assert.Empty(t, device.GpuInstances)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Empty(t, instaslice.Spec.Allocations)
+	assert.Empty(t, instaslice.Status.SliceIntents)
+}
+
+func TestReconcileFinishesSlicePreparedBeforeCrash(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	migUUID, prepared := preparedOfPod(instaslice, "pod-uid-0")
+	require.NotEmpty(t, migUUID)
+
+	// the daemonset crashes after writing the prepared entry, before setting the allocation to created
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusCreating
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	reconciler.slices = sliceCache{}
+	reconciler.allocationStatuses = nil
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	gi := onlyGpuInstance(t, device)
+	assert.Equal(t, prepared.Giinfoid, gi.Info.Id)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, map[string]inferencev1alpha1.PreparedDetails{migUUID: prepared}, instaslice.Status.Prepared)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// Inventory is the fleet wide inventory of the slices recorded in the instaslices of the cluster, built from
+// their specs alone so that it can be taken from outside the nodes.
+type Inventory struct {
+	// Profiles holds, per profile, the slices used and free across all nodes.
+	Profiles map[string]ProfileInventory `json:"profiles"`
+	// Nodes holds the inventory of every node, keyed by the name of its instaslice.
+	Nodes map[string]NodeInventory `json:"nodes"`
+}
+
+// ProfileInventory counts the slices of a profile.
+type ProfileInventory struct {
+	// Used is the number of slices of the profile carved or being carved.
+	Used int `json:"used"`
+	// Free is the number of slices of the profile alone that still fit on the GPUs, as published in the
+	// capabilities ConfigMap of the nodes. Free slices of different profiles compete for the same memory.
+	Free int `json:"free"`
+}
+
+// NodeInventory is the inventory of the slices of a node.
+type NodeInventory struct {
+	// GPUs is the number of GPUs of the node.
+	GPUs int `json:"gpus"`
+	// Profiles holds, per profile, the slices used and free on the node.
+	Profiles map[string]ProfileInventory `json:"profiles"`
+	// Fragmentation holds, per GPU, the share of its free memory slices outside its largest free contiguous region.
+	Fragmentation map[string]float64 `json:"fragmentation"`
+	// AffectedPods holds, per cordoned GPU or GPU the daemonset reported failing, the pods with slices on it.
+	AffectedPods map[string][]inferencev1alpha1.AffectedPod `json:"affectedPods,omitempty"`
+}
+
+// BuildInventory aggregates the slices of the given instaslices into a fleet wide inventory.
+func BuildInventory(instaslices []inferencev1alpha1.Instaslice) Inventory {
+	inventory := Inventory{
+		Profiles: make(map[string]ProfileInventory),
+		Nodes:    make(map[string]NodeInventory, len(instaslices)),
+	}
+	for i := range instaslices {
+		node := nodeInventory(&instaslices[i])
+		inventory.Nodes[instaslices[i].Name] = node
+		for profile, counts := range node.Profiles {
+			total := inventory.Profiles[profile]
+			total.Used += counts.Used
+			total.Free += counts.Free
+			inventory.Profiles[profile] = total
+		}
+	}
+	return inventory
+}
+
+// nodeInventory returns the inventory of the node of the instaslice. Every allocation holding or waiting for a
+// slice uses one, prepared slices no such allocation accounts for, e.g. idle slices of the pools, use one each.
+func nodeInventory(instaslice *inferencev1alpha1.Instaslice) NodeInventory {
+	node := NodeInventory{
+		GPUs:          len(instaslice.Spec.MigGPUUUID),
+		Profiles:      make(map[string]ProfileInventory),
+		Fragmentation: make(map[string]float64, len(instaslice.Spec.MigGPUUUID)),
+	}
+	for profile, free := range freeSlicesPerProfile(instaslice) {
+		node.Profiles[profile] = ProfileInventory{Free: free}
+	}
+	used := func(profile string) {
+		counts := node.Profiles[profile]
+		counts.Used++
+		node.Profiles[profile] = counts
+	}
+	allocated := make(map[string]bool, len(instaslice.Spec.Allocations))
+	for podUUID, allocation := range instaslice.Spec.Allocations {
+		if allocation.Allocationstatus == inferencev1alpha1.AllocationStatusDeleted || allocation.Allocationstatus == inferencev1alpha1.AllocationStatusFailed {
+			continue
+		}
+		allocated[podUUID] = true
+		used(allocation.Profile)
+	}
+	for _, prepared := range instaslice.Status.Prepared {
+		if !allocated[prepared.PodUUID] {
+			used(prepared.Profile)
+		}
+	}
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		node.Fragmentation[gpuUUID] = fragmentationOf(occupiedIndexes(instaslice, gpuUUID))
+	}
+	// the Xid error threshold is a setting of the daemonset, the GPUs it found failing are the ones it reported
+	failing := make(map[string]bool, len(instaslice.Status.AffectedPods))
+	for gpuUUID := range instaslice.Status.AffectedPods {
+		failing[gpuUUID] = true
+	}
+	if affected := affectedPods(instaslice, failing); len(affected) > 0 {
+		node.AffectedPods = affected
+	}
+	return node
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newInventoryTestInstaslice returns the instaslice of a node of A100 GPUs offering 1g.5gb and 2g.10gb slices.
+func newInventoryTestInstaslice(name string, gpus ...string) inferencev1alpha1.Instaslice {
+	instaslice := inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: inferencev1alpha1.InstasliceSpec{
+			MigGPUUUID: map[string]string{},
+			Migplacement: []inferencev1alpha1.Mig{
+				{Profile: "1g.5gb", Giprofileid: 0, Placements: []inferencev1alpha1.Placement{
+					{Size: 1, Start: 0}, {Size: 1, Start: 1}, {Size: 1, Start: 2}, {Size: 1, Start: 3},
+					{Size: 1, Start: 4}, {Size: 1, Start: 5}, {Size: 1, Start: 6}}},
+				{Profile: "2g.10gb", Giprofileid: 5, Placements: []inferencev1alpha1.Placement{
+					{Size: 2, Start: 0}, {Size: 2, Start: 2}, {Size: 2, Start: 4}}},
+			},
+			Allocations: map[string]inferencev1alpha1.AllocationDetails{},
+		},
+		Status: inferencev1alpha1.InstasliceStatus{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{},
+		},
+	}
+	for _, gpu := range gpus {
+		instaslice.Spec.MigGPUUUID[gpu] = "NVIDIA A100-PCIE-40GB"
+	}
+	return instaslice
+}
+
+func TestBuildInventoryAggregatesNodes(t *testing.T) {
+	node1 := newInventoryTestInstaslice("node-1", "GPU-1")
+	node1.Spec.Allocations["pod-1"] = inferencev1alpha1.AllocationDetails{
+		Profile: "1g.5gb", Start: 0, Size: 1, PodUUID: "pod-1", GPUUUID: "GPU-1", Allocationstatus: inferencev1alpha1.AllocationStatusCreated}
+	node1.Status.Prepared["MIG-1"] = inferencev1alpha1.PreparedDetails{Profile: "1g.5gb", Start: 0, Size: 1, Parent: "GPU-1", PodUUID: "pod-1"}
+	// an idle slice of a pool, no allocation accounts for it
+	node1.Status.Prepared["MIG-2"] = inferencev1alpha1.PreparedDetails{Profile: "2g.10gb", Start: 2, Size: 2, Parent: "GPU-1", PodUUID: "pool-1"}
+
+	node2 := newInventoryTestInstaslice("node-2", "GPU-2", "GPU-3")
+	node2.Spec.Allocations["pod-2"] = inferencev1alpha1.AllocationDetails{
+		Profile: "2g.10gb", Start: 0, Size: 2, PodUUID: "pod-2", GPUUUID: "GPU-2", Allocationstatus: inferencev1alpha1.AllocationStatusCreating}
+	node2.Spec.Allocations["pod-3"] = inferencev1alpha1.AllocationDetails{
+		Profile: "1g.5gb", Start: 6, Size: 1, PodUUID: "pod-3", GPUUUID: "GPU-3", Allocationstatus: inferencev1alpha1.AllocationStatusDeleted}
+
+	inventory := BuildInventory([]inferencev1alpha1.Instaslice{node1, node2})
+
+	assert.Equal(t, map[string]ProfileInventory{
+		"1g.5gb":  {Used: 1, Free: 16},
+		"2g.10gb": {Used: 2, Free: 6},
+	}, inventory.Profiles)
+	first := inventory.Nodes["node-1"]
+	assert.Equal(t, 1, first.GPUs)
+	assert.Equal(t, map[string]ProfileInventory{
+		"1g.5gb":  {Used: 1, Free: 4},
+		"2g.10gb": {Used: 1, Free: 1},
+	}, first.Profiles)
+	// indexes 1 and 4 to 7 are free, 4 of the 5 contiguous
+	assert.InDelta(t, 0.2, first.Fragmentation["GPU-1"], 1e-9)
+	second := inventory.Nodes["node-2"]
+	assert.Equal(t, 2, second.GPUs)
+	assert.Equal(t, map[string]ProfileInventory{
+		"1g.5gb":  {Used: 0, Free: 12},
+		"2g.10gb": {Used: 1, Free: 5},
+	}, second.Profiles)
+	assert.Equal(t, map[string]float64{"GPU-2": 0, "GPU-3": 0}, second.Fragmentation)
+}
+
+func TestBuildInventoryListsPodsOnCordonedGPUs(t *testing.T) {
+	node := newInventoryTestInstaslice("node-1", "GPU-1", "GPU-2")
+	node.Spec.CordonedGPUs = []string{"GPU-1"}
+	node.Spec.Allocations["pod-1"] = inferencev1alpha1.AllocationDetails{
+		Profile: "1g.5gb", Start: 0, Size: 1, PodUUID: "pod-1", PodName: "pod-one", Namespace: "default", GPUUUID: "GPU-1", Allocationstatus: inferencev1alpha1.AllocationStatusUngated}
+	node.Spec.Allocations["pod-2"] = inferencev1alpha1.AllocationDetails{
+		Profile: "1g.5gb", Start: 0, Size: 1, PodUUID: "pod-2", PodName: "pod-two", Namespace: "default", GPUUUID: "GPU-2", Allocationstatus: inferencev1alpha1.AllocationStatusUngated}
+	// GPU-2 reported failing by the daemonset
+	node.Status.AffectedPods = map[string][]inferencev1alpha1.AffectedPod{"GPU-2": {{PodUUID: "pod-2"}}}
+
+	inventory := BuildInventory([]inferencev1alpha1.Instaslice{node})
+	assert.Equal(t, map[string][]inferencev1alpha1.AffectedPod{
+		"GPU-1": {{PodUUID: "pod-1", PodName: "pod-one", Namespace: "default"}},
+		"GPU-2": {{PodUUID: "pod-2", PodName: "pod-two", Namespace: "default"}},
+	}, inventory.Nodes["node-1"].AffectedPods)
+}
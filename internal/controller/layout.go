@@ -0,0 +1,370 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// ConditionLayoutPending is set on the instaslice while a GPU is not carved in its desired layout yet.
+	ConditionLayoutPending = "LayoutPending"
+	// ReasonGPUInUse is the reason used when pods still hold slices of a GPU waiting for its desired layout.
+	ReasonGPUInUse = "GPUInUse"
+	// ReasonInvalidLayout is the reason used when a desired layout does not fit its GPU.
+	ReasonInvalidLayout = "InvalidLayout"
+)
+
+// layoutSlice is a slice of a desired layout along with the placement it is carved at.
+type layoutSlice struct {
+	mig       inferencev1alpha1.Mig
+	placement nvml.GpuInstancePlacement
+}
+
+// currentLayout returns the sorted profiles of the slices carved on the GPU, a slice split in several compute
+// instances counting once.
+func currentLayout(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) []string {
+	gpuInstances := make(map[uint32]string)
+	for _, prepared := range instaslice.Status.Prepared {
+		if prepared.Parent == gpuUUID {
+			gpuInstances[prepared.Giinfoid] = prepared.Profile
+		}
+	}
+	profiles := make([]string, 0, len(gpuInstances))
+	for _, profile := range gpuInstances {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+// layoutPending reports whether the GPU has a desired layout that its slices do not match yet.
+func layoutPending(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) bool {
+	desired, exists := instaslice.Spec.DesiredLayouts[gpuUUID]
+	if !exists {
+		return false
+	}
+	sorted := append([]string(nil), desired...)
+	sort.Strings(sorted)
+	current := currentLayout(instaslice, gpuUUID)
+	if len(current) != len(sorted) {
+		return true
+	}
+	for i := range current {
+		if current[i] != sorted[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// planLayout places the slices of the profiles on an empty GPU, the largest ones first as they have the fewest
+// placements to choose from. An error is returned when a profile is unknown or the slices do not fit together.
+func planLayout(migPlacements []inferencev1alpha1.Mig, profiles []string) ([]layoutSlice, error) {
+	migs := make([]inferencev1alpha1.Mig, 0, len(profiles))
+	for _, profile := range profiles {
+		found := false
+		for _, mig := range migPlacements {
+			if mig.Profile == profile {
+				migs = append(migs, mig)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("profile %s is not supported by the GPU", profile)
+		}
+	}
+	sliceSize := func(mig inferencev1alpha1.Mig) int {
+		if len(mig.Placements) == 0 {
+			return 0
+		}
+		return mig.Placements[0].Size
+	}
+	sort.SliceStable(migs, func(i, j int) bool {
+		return sliceSize(migs[i]) > sliceSize(migs[j])
+	})
+	occupied := NewPlacementSet(gpuMemorySlices)
+	plan := make([]layoutSlice, 0, len(migs))
+	for _, mig := range migs {
+		free := freePlacementsFor(mig.Placements, occupied)
+		if len(free) == 0 {
+			return nil, fmt.Errorf("no room left on the GPU for a %s slice", mig.Profile)
+		}
+		occupied.Occupy(uint32(free[0].Start), uint32(free[0].Size))
+		plan = append(plan, layoutSlice{
+			mig:       mig,
+			placement: nvml.GpuInstancePlacement{Start: uint32(free[0].Start), Size: uint32(free[0].Size)},
+		})
+	}
+	return plan, nil
+}
+
+// layoutBlockers returns the allocations holding the GPU for a pod, retained slices are idle and do not count.
+func layoutBlockers(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) []inferencev1alpha1.AllocationDetails {
+	var blockers []inferencev1alpha1.AllocationDetails
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.GPUUUID == gpuUUID && allocation.Allocationstatus != inferencev1alpha1.AllocationStatusRetained {
+			blockers = append(blockers, allocation)
+		}
+	}
+	return blockers
+}
+
+// setLayoutCondition marks the status with the GPUs still waiting for their desired layout and why, or clears
+// the condition when there are none. It reports whether the condition changed.
+func setLayoutCondition(status *inferencev1alpha1.InstasliceStatus, pending map[string]string, invalid bool) bool {
+	if len(pending) == 0 {
+		return meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ConditionLayoutPending,
+			Status:  metav1.ConditionFalse,
+			Reason:  "LayoutsApplied",
+			Message: "every GPU is carved in its desired layout",
+		})
+	}
+	gpuUUIDs := make([]string, 0, len(pending))
+	for gpuUUID := range pending {
+		gpuUUIDs = append(gpuUUIDs, gpuUUID)
+	}
+	sort.Strings(gpuUUIDs)
+	messages := make([]string, 0, len(gpuUUIDs))
+	for _, gpuUUID := range gpuUUIDs {
+		messages = append(messages, gpuUUID+": "+pending[gpuUUID])
+	}
+	reason := ReasonGPUInUse
+	if invalid {
+		reason = ReasonInvalidLayout
+	}
+	return meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    ConditionLayoutPending,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: strings.Join(messages, "; "),
+	})
+}
+
+// reconcileLayouts carves again the GPUs of the node whose slices differ from their desired layout. A GPU is
+// only reconfigured once no pod holds a slice of it, its evictable slices are preempted first when the spec
+// allows it. It reports whether the instaslice changed, in which case the caller should start over.
+func (r *InstaSliceDaemonsetReconciler) reconcileLayouts(ctx context.Context, nodeName string, instaslice *inferencev1alpha1.Instaslice) (bool, error) {
+	if len(instaslice.Spec.DesiredLayouts) == 0 && meta.FindStatusCondition(instaslice.Status.Conditions, ConditionLayoutPending) == nil {
+		return false, nil
+	}
+	gpuUUIDs := make([]string, 0, len(instaslice.Spec.DesiredLayouts))
+	for gpuUUID := range instaslice.Spec.DesiredLayouts {
+		gpuUUIDs = append(gpuUUIDs, gpuUUID)
+	}
+	sort.Strings(gpuUUIDs)
+	pending := make(map[string]string)
+	invalid := false
+	for _, gpuUUID := range gpuUUIDs {
+		if !layoutPending(instaslice, gpuUUID) {
+			continue
+		}
+		if _, exists := instaslice.Spec.MigGPUUUID[gpuUUID]; !exists {
+			pending[gpuUUID] = "GPU not found on the node"
+			invalid = true
+			continue
+		}
+		plan, err := planLayout(instaslice.Spec.Migplacement, instaslice.Spec.DesiredLayouts[gpuUUID])
+		if err != nil {
+			pending[gpuUUID] = err.Error()
+			invalid = true
+			continue
+		}
+		if count := r.otherVersionSlices(instaslice, gpuUUID); count > 0 {
+			pending[gpuUUID] = fmt.Sprintf("waiting for %d slices carved by other versions of the operator", count)
+			continue
+		}
+		if blockers := layoutBlockers(instaslice, gpuUUID); len(blockers) > 0 {
+			if instaslice.Spec.PreemptForLayout {
+				preempted, err := r.preemptForLayout(ctx, instaslice.Name, gpuUUID)
+				if err != nil || preempted {
+					return preempted, err
+				}
+			}
+			pending[gpuUUID] = fmt.Sprintf("waiting for %d pods to release their slices", len(blockers))
+			continue
+		}
+		if _, outside := r.outsideMaintenanceWindow(); outside {
+			pending[gpuUUID] = "waiting for the maintenance window"
+			continue
+		}
+		if err := r.applyLayout(ctx, nodeName, instaslice, gpuUUID, plan); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	var latest inferencev1alpha1.Instaslice
+	if err := r.Get(ctx, types.NamespacedName{Name: instaslice.Name, Namespace: r.instasliceNamespace()}, &latest); err != nil {
+		return false, err
+	}
+	if !setLayoutCondition(&latest.Status, pending, invalid) {
+		return false, nil
+	}
+	if err := r.Status().Update(ctx, &latest); err != nil {
+		return false, err
+	}
+	*instaslice = latest
+	return false, nil
+}
+
+// preemptForLayout marks the realized evictable slices of the GPU preempted, their teardown drains the GPU for
+// its desired layout. It reports whether any slice was marked.
+func (r *InstaSliceDaemonsetReconciler) preemptForLayout(ctx context.Context, instasliceName string, gpuUUID string) (bool, error) {
+	var preempted []inferencev1alpha1.AllocationDetails
+	if _, err := r.updateInstaslice(ctx, instasliceName, func(latest *inferencev1alpha1.Instaslice) error {
+		preempted = nil
+		for key, allocation := range latest.Spec.Allocations {
+			if key != allocation.PodUUID || allocation.GPUUUID != gpuUUID || !allocation.Evictable || !settledAllocation(allocation) {
+				continue
+			}
+			allocation.Allocationstatus = inferencev1alpha1.AllocationStatusPreempted
+			latest.Spec.Allocations[key] = allocation
+			preempted = append(preempted, allocation)
+		}
+		if len(preempted) == 0 {
+			return errInstasliceUnchanged
+		}
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	for _, allocation := range preempted {
+		log.FromContext(ctx).Info("preempting slice to reconfigure its GPU for ", "pod", allocation.PodName,
+			"namespace", allocation.Namespace, "profile", allocation.Profile, "gpu", gpuUUID)
+		if r.Recorder == nil {
+			continue
+		}
+		pod := &v1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Name:       allocation.PodName,
+			Namespace:  allocation.Namespace,
+			UID:        types.UID(allocation.PodUUID),
+		}
+		r.Recorder.Eventf(pod, v1.EventTypeWarning, EventReasonSlicePreempted,
+			"The %s slice on GPU %s is preempted to carve the GPU in its desired layout", allocation.Profile, gpuUUID)
+	}
+	return len(preempted) > 0, nil
+}
+
+// applyLayout destroys the idle slices of the GPU and carves the planned ones. The new slices belong to no pod,
+// like the ones found on the GPUs at startup. Slices carved before a failure are recorded, the next attempt
+// destroys them again.
+func (r *InstaSliceDaemonsetReconciler) applyLayout(ctx context.Context, nodeName string, instaslice *inferencev1alpha1.Instaslice, gpuUUID string, plan []layoutSlice) error {
+	log.FromContext(ctx).Info("carving GPU in its desired layout", "gpu", gpuUUID,
+		"from", currentLayout(instaslice, gpuUUID), "to", instaslice.Spec.DesiredLayouts[gpuUUID])
+	for podUUID, allocation := range instaslice.Spec.Allocations {
+		if allocation.GPUUUID == gpuUUID && allocation.Allocationstatus == inferencev1alpha1.AllocationStatusRetained {
+			if err := r.cleanUp(ctx, podUUID); err != nil {
+				return err
+			}
+		}
+	}
+
+	nvmllib := r.handler().nvml
+	device, ret := nvmllib.DeviceGetHandleByUUID(gpuUUID)
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to get GPU %s: %v", gpuUUID, ret)
+	}
+
+	destroyed := make(map[string]bool)
+	computeInstances := make(map[uint32][]int)
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		if prepared.Parent == gpuUUID && prepared.PodUUID == "" {
+			computeInstances[prepared.Giinfoid] = append(computeInstances[prepared.Giinfoid], int(prepared.Ciinfoid))
+			destroyed[migUUID] = true
+		}
+	}
+	for giID, ciIDs := range computeInstances {
+		if ret := destroySlice(device, int(giID), ciIDs...); ret != nvml.SUCCESS {
+			return fmt.Errorf("unable to destroy gi %d on GPU %s: %v", giID, gpuUUID, ret)
+		}
+	}
+
+	carved := make(map[string]inferencev1alpha1.PreparedDetails)
+	var errCarving error
+	for _, slice := range plan {
+		gi, ret := createGpuInstance(device, slice.mig.Giprofileid, slice.placement)
+		if ret != nvml.SUCCESS {
+			errCarving = fmt.Errorf("unable to create gi of %s slice: %v", slice.mig.Profile, ret)
+			break
+		}
+		giInfo, ret := gi.GetInfo()
+		if ret != nvml.SUCCESS {
+			errCarving = fmt.Errorf("unable to get gi of %s slice: %v", slice.mig.Profile, ret)
+			break
+		}
+		if _, ret := createComputeInstance(gi, slice.mig.CIProfileID, slice.mig.CIEngProfileID); ret != nvml.SUCCESS {
+			if ret := gi.Destroy(); ret != nvml.SUCCESS {
+				log.FromContext(ctx).Error(ret, "unable to destroy gi of partially created slice", "gi", giInfo.Id)
+			}
+			errCarving = fmt.Errorf("unable to create ci of %s slice: %v", slice.mig.Profile, ret)
+			break
+		}
+		created, err := r.getCreatedComputeInstances(ctx, device, giInfo.Id)
+		if err != nil {
+			errCarving = err
+			break
+		}
+		for _, ci := range created {
+			carved[ci.miguuid] = inferencev1alpha1.PreparedDetails{
+				Profile:  slice.mig.Profile,
+				Start:    giInfo.Placement.Start,
+				Size:     giInfo.Placement.Size,
+				Parent:   gpuUUID,
+				Giinfoid: giInfo.Id,
+				Ciinfoid: ci.cid,
+				GPUModel: instaslice.Spec.MigGPUUUID[gpuUUID],
+				CarvedBy: r.operatorVersion(),
+			}
+		}
+	}
+
+	if _, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+		for migUUID := range destroyed {
+			delete(latest.Status.Prepared, migUUID)
+		}
+		if latest.Status.Prepared == nil {
+			latest.Status.Prepared = make(map[string]inferencev1alpha1.PreparedDetails)
+		}
+		for migUUID, prepared := range carved {
+			latest.Status.Prepared[migUUID] = prepared
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if errCarving != nil {
+		return errCarving
+	}
+	return r.updateNodeCapacity(ctx, nodeName)
+}
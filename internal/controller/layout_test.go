@@ -0,0 +1,160 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// setDesiredLayout asks for the GPU to be carved in the profiles and runs a reconcile of the daemonset.
+func setDesiredLayout(t *testing.T, reconciler *InstaSliceDaemonsetReconciler, fakeClient client.Client, device *dgxa100.Device, profiles ...string) *inferencev1alpha1.Instaslice {
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	instaslice.Spec.DesiredLayouts = map[string][]string{device.UUID: profiles}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: nsName})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	return &instaslice
+}
+
+func TestReconcileCarvesDesiredLayoutOnceIdle(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t)
+
+	instaslice := setDesiredLayout(t, reconciler, fakeClient, device,
+		"1g.5gb", "1g.5gb", "1g.5gb", "1g.5gb", "1g.5gb", "1g.5gb", "1g.5gb")
+	assert.Equal(t, []string{"1g.5gb", "1g.5gb", "1g.5gb", "1g.5gb", "1g.5gb", "1g.5gb", "1g.5gb"}, currentLayout(instaslice, device.UUID))
+	assert.Len(t, device.GpuInstances, 7)
+
+	instaslice = setDesiredLayout(t, reconciler, fakeClient, device, "2g.10gb", "2g.10gb", "2g.10gb")
+	assert.Equal(t, []string{"2g.10gb", "2g.10gb", "2g.10gb"}, currentLayout(instaslice, device.UUID))
+	assert.Len(t, device.GpuInstances, 3)
+	starts := make(map[uint32]bool)
+	for _, prepared := range instaslice.Status.Prepared {
+		assert.Empty(t, prepared.PodUUID)
+		assert.Equal(t, uint32(2), prepared.Size)
+		starts[prepared.Start] = true
+	}
+	assert.Equal(t, map[uint32]bool{0: true, 2: true, 4: true}, starts)
+
+	// the layout is in place, the next reconcile only reports it
+	_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "node-1", Namespace: "default"}})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, instaslice))
+	assert.True(t, meta.IsStatusConditionFalse(instaslice.Status.Conditions, ConditionLayoutPending))
+	assert.Len(t, device.GpuInstances, 3)
+}
+
+func TestReconcileWaitsForPodsBeforeChangingLayout(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "node-1", Namespace: "default"}})
+	require.NoError(t, err)
+
+	updated := setDesiredLayout(t, reconciler, fakeClient, device, "2g.10gb", "2g.10gb", "2g.10gb")
+	assert.Equal(t, []string{"1g.5gb"}, currentLayout(updated, device.UUID))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, updated.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	condition := meta.FindStatusCondition(updated.Status.Conditions, ConditionLayoutPending)
+	require.NotNil(t, condition)
+	assert.Equal(t, ReasonGPUInUse, condition.Reason)
+	assert.Len(t, device.GpuInstances, 1)
+}
+
+func TestReconcilePreemptsEvictableSlicesForLayout(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: nsName})
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Evictable = true
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	instaslice.Spec.PreemptForLayout = true
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	updated := setDesiredLayout(t, reconciler, fakeClient, device, "7g.40gb")
+	assert.Equal(t, inferencev1alpha1.AllocationStatusPreempted, updated.Spec.Allocations["pod-uid-0"].Allocationstatus)
+}
+
+func TestFindDeviceForASliceSkipsGPUWaitingForLayout(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+	instaslice := newPlacementTestInstaslice()
+	instaslice.Spec.DesiredLayouts = map[string][]string{"GPU-1": {"1g.5gb", "1g.5gb"}}
+
+	_, err := r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.Error(t, err)
+
+	instaslice.Spec.DesiredLayouts["GPU-1"] = []string{"1g.5gb"}
+	_, err = r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.NoError(t, err)
+}
+
+func TestPlanLayout(t *testing.T) {
+	migPlacements := []inferencev1alpha1.Mig{
+		{Profile: "1g.5gb", Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 1}, {Start: 1, Size: 1}, {Start: 2, Size: 1},
+			{Start: 3, Size: 1}, {Start: 4, Size: 1}, {Start: 5, Size: 1}, {Start: 6, Size: 1}}},
+		{Profile: "3g.20gb", Placements: []inferencev1alpha1.Placement{{Start: 0, Size: 4}, {Start: 4, Size: 4}}},
+	}
+
+	plan, err := planLayout(migPlacements, []string{"1g.5gb", "3g.20gb", "1g.5gb"})
+	require.NoError(t, err)
+	require.Len(t, plan, 3)
+	// the larger slice is placed first, the small ones fill the rest of the GPU
+	assert.Equal(t, "3g.20gb", plan[0].mig.Profile)
+	assert.Equal(t, uint32(0), plan[0].placement.Start)
+	assert.Equal(t, uint32(4), plan[1].placement.Start)
+	assert.Equal(t, uint32(5), plan[2].placement.Start)
+
+	_, err = planLayout(migPlacements, []string{"3g.20gb", "3g.20gb", "1g.5gb"})
+	assert.Error(t, err)
+	_, err = planLayout(migPlacements, []string{"2g.10gb"})
+	assert.Error(t, err)
+}
+
+func TestLayoutPending(t *testing.T) {
+	instaslice := &inferencev1alpha1.Instaslice{Status: inferencev1alpha1.InstasliceStatus{
+		Prepared: map[string]inferencev1alpha1.PreparedDetails{
+			"MIG-1": {Profile: "2g.10gb", Parent: "GPU-1", Giinfoid: 1},
+			"MIG-2": {Profile: "1g.5gb", Parent: "GPU-1", Giinfoid: 2},
+		},
+	}}
+	assert.False(t, layoutPending(instaslice, "GPU-1"))
+
+	instaslice.Spec.DesiredLayouts = map[string][]string{"GPU-1": {"1g.5gb", "2g.10gb"}}
+	assert.False(t, layoutPending(instaslice, "GPU-1"))
+	instaslice.Spec.DesiredLayouts["GPU-1"] = []string{"2g.10gb", "2g.10gb"}
+	assert.True(t, layoutPending(instaslice, "GPU-1"))
+}
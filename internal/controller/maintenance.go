@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaintenanceWindow is a daily range of time, in UTC, during which the daemonset may disrupt the GPUs of the node
+// by creating slices or destroying the ones no deleted pod is waiting on. The range wraps around midnight when
+// it ends before it starts.
+type MaintenanceWindow struct {
+	// Start and End are offsets from midnight UTC.
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseMaintenanceWindow parses a window given as HH:MM-HH:MM in UTC, e.g. 22:00-06:00.
+func ParseMaintenanceWindow(value string) (*MaintenanceWindow, error) {
+	var startHour, startMinute, endHour, endMinute int
+	if _, err := fmt.Sscanf(value, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute); err != nil {
+		return nil, fmt.Errorf("maintenance window %q is not of the form HH:MM-HH:MM", value)
+	}
+	for _, hour := range []int{startHour, endHour} {
+		if hour < 0 || hour > 23 {
+			return nil, fmt.Errorf("maintenance window %q has an hour out of range", value)
+		}
+	}
+	for _, minute := range []int{startMinute, endMinute} {
+		if minute < 0 || minute > 59 {
+			return nil, fmt.Errorf("maintenance window %q has a minute out of range", value)
+		}
+	}
+	window := &MaintenanceWindow{
+		Start: time.Duration(startHour)*time.Hour + time.Duration(startMinute)*time.Minute,
+		End:   time.Duration(endHour)*time.Hour + time.Duration(endMinute)*time.Minute,
+	}
+	if window.Start == window.End {
+		return nil, fmt.Errorf("maintenance window %q is empty", value)
+	}
+	return window, nil
+}
+
+// untilOpen returns how long after t the window opens, zero when it is open at t.
+func (w *MaintenanceWindow) untilOpen(t time.Time) time.Duration {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	offset := t.Sub(midnight)
+	open := offset >= w.Start && offset < w.End
+	if w.End < w.Start {
+		open = offset >= w.Start || offset < w.End
+	}
+	if open {
+		return 0
+	}
+	if offset < w.Start {
+		return w.Start - offset
+	}
+	return 24*time.Hour - offset + w.Start
+}
+
+// outsideMaintenanceWindowError is returned when an operation that can wait is attempted outside the maintenance
+// window, it is deferred until the window opens.
+type outsideMaintenanceWindowError struct {
+	retryAfter time.Duration
+}
+
+func (e *outsideMaintenanceWindowError) Error() string {
+	return fmt.Sprintf("outside the maintenance window, retry after %v", e.retryAfter)
+}
+
+// outsideMaintenanceWindow returns how long until the maintenance window opens, and false when it is open or
+// no window is set.
+func (r *InstaSliceDaemonsetReconciler) outsideMaintenanceWindow() (time.Duration, bool) {
+	window := r.SliceOperationPolicy.MaintenanceWindow
+	if window == nil {
+		return 0, false
+	}
+	wait := window.untilOpen(sliceOperationNow())
+	return wait, wait > 0
+}
+
+// reserveDeferrableSliceOperation accounts for an NVML create or destroy operation that can wait, unlike the
+// teardown of the slice of a deleted pod. Outside the maintenance window it returns an
+// outsideMaintenanceWindowError, otherwise the operation is rated like any other.
+func (r *InstaSliceDaemonsetReconciler) reserveDeferrableSliceOperation() error {
+	if wait, outside := r.outsideMaintenanceWindow(); outside {
+		return &outsideMaintenanceWindowError{retryAfter: wait}
+	}
+	return r.reserveSliceOperation()
+}
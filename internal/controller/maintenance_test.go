@@ -0,0 +1,93 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	window, err := ParseMaintenanceWindow("22:00-06:30")
+	require.NoError(t, err)
+	assert.Equal(t, 22*time.Hour, window.Start)
+	assert.Equal(t, 6*time.Hour+30*time.Minute, window.End)
+
+	for _, value := range []string{"", "22:00", "24:00-06:00", "22:60-06:00", "06:00-06:00"} {
+		_, err := ParseMaintenanceWindow(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestMaintenanceWindowUntilOpen(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 5, 1, hour, minute, 0, 0, time.UTC)
+	}
+	overnight := &MaintenanceWindow{Start: 22 * time.Hour, End: 6 * time.Hour}
+	assert.Equal(t, 10*time.Hour, overnight.untilOpen(at(12, 0)))
+	assert.Zero(t, overnight.untilOpen(at(23, 0)))
+	assert.Zero(t, overnight.untilOpen(at(5, 59)))
+	assert.Equal(t, 16*time.Hour, overnight.untilOpen(at(6, 0)))
+
+	daytime := &MaintenanceWindow{Start: 9 * time.Hour, End: 17 * time.Hour}
+	assert.Equal(t, 2*time.Hour, daytime.untilOpen(at(7, 0)))
+	assert.Zero(t, daytime.untilOpen(at(9, 0)))
+	assert.Equal(t, 15*time.Hour, daytime.untilOpen(at(18, 0)))
+}
+
+func TestSliceCreationDeferredOutsideMaintenanceWindow(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.SliceOperationPolicy.MaintenanceWindow = &MaintenanceWindow{Start: 22 * time.Hour, End: 6 * time.Hour}
+	ctx := context.Background()
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	sliceOperationNow = func() time.Time { return clock }
+	t.Cleanup(func() { sliceOperationNow = time.Now })
+
+	// outside the window the slice waits for it to open
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Hour, result.RequeueAfter)
+	assert.Empty(t, device.GpuInstances)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+
+	clock = clock.Add(result.RequeueAfter)
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Len(t, device.GpuInstances, 1)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+
+	// the slice of a deleted pod goes even outside the window
+	clock = time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Empty(t, device.GpuInstances)
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ParseManagedGPUs parses a comma separated list of GPU indexes and UUIDs, e.g. 0,1 or GPU-1a2b,GPU-3c4d, into the
+// ManagedGPUs of the daemonset.
+func ParseManagedGPUs(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var gpus []string
+	seen := make(map[string]bool)
+	for _, gpu := range strings.Split(value, ",") {
+		gpu = strings.TrimSpace(gpu)
+		if gpu == "" {
+			return nil, fmt.Errorf("managed GPUs %q hold an empty entry", value)
+		}
+		if index, err := strconv.Atoi(gpu); err == nil && index < 0 {
+			return nil, fmt.Errorf("managed GPU index %d is negative", index)
+		}
+		if seen[gpu] {
+			return nil, fmt.Errorf("managed GPU %s is listed more than once", gpu)
+		}
+		seen[gpu] = true
+		gpus = append(gpus, gpu)
+	}
+	return gpus, nil
+}
+
+// managesGPU reports whether the GPU at index, of the given UUID, is one the daemonset manages. Every GPU is
+// managed when ManagedGPUs is empty.
+func (r *InstaSliceDaemonsetReconciler) managesGPU(index int, uuid string) bool {
+	if len(r.ManagedGPUs) == 0 {
+		return true
+	}
+	for _, gpu := range r.ManagedGPUs {
+		if gpu == strconv.Itoa(index) || (uuid != "" && gpu == uuid) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayManageGPU reports whether the GPU at index may be managed before its UUID is known, it is unmanaged for sure
+// when ManagedGPUs lists indexes alone and not this one.
+func (r *InstaSliceDaemonsetReconciler) mayManageGPU(index int) bool {
+	if r.managesGPU(index, "") {
+		return true
+	}
+	for _, gpu := range r.ManagedGPUs {
+		if _, err := strconv.Atoi(gpu); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// skipUnmanagedGPU leaves the GPU at index out of the rest of discovery, nothing is carved, tracked or advertised
+// on it.
+func (r *InstaSliceDaemonsetReconciler) skipUnmanagedGPU(index int, uuid string) {
+	if r.undiscoveredGPUs == nil {
+		r.undiscoveredGPUs = make(map[int]bool)
+	}
+	r.undiscoveredGPUs[index] = true
+	log.Log.Info("leaving out GPU not managed by the daemonset", "index", index, "gpu", uuid)
+}
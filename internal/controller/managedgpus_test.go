@@ -0,0 +1,131 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOnlyManagedGPUsAreDiscoveredAndAllocated(t *testing.T) {
+	t.Setenv(FakeGPUEnv, "4")
+	t.Setenv("NODE_NAME", "node-1")
+	nvmllib, err := newNvmlLib(nil)
+	require.NoError(t, err)
+	var uuids []string
+	for i := 0; i < 4; i++ {
+		device, ret := nvmllib.DeviceGetHandleByIndex(i)
+		require.Equal(t, nvml.SUCCESS, ret)
+		uuid, ret := device.GetUUID()
+		require.Equal(t, nvml.SUCCESS, ret)
+		uuids = append(uuids, uuid)
+	}
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(node).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}, &v1.Node{}).Build()
+	// GPU 1 by index and GPU 3 by UUID
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:      fakeClient,
+		Scheme:      s,
+		nvmlHandler: newDeviceHandler(nvmllib),
+		ManagedGPUs: []string{"1", uuids[3]},
+	}
+	ctx := context.Background()
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	var discovered []string
+	for uuid := range instaslice.Spec.MigGPUUUID {
+		discovered = append(discovered, uuid)
+	}
+	assert.ElementsMatch(t, []string{uuids[1], uuids[3]}, discovered)
+	migEnabled, err := reconciler.discoverMigMode()
+	require.NoError(t, err)
+	assert.Len(t, migEnabled, 2)
+
+	// the seven 1g.5gb slices of each managed GPU are all there is to allocate
+	if instaslice.Spec.Allocations == nil {
+		instaslice.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
+	}
+	r := &InstasliceReconciler{}
+	slices := make(map[string]int)
+	for i := 0; i < 14; i++ {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("pod-%d", i), Namespace: "default", UID: types.UID(fmt.Sprintf("pod-uid-%d", i)),
+		}}
+		allocation, err := r.findDeviceForASlice(&instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+		require.NoError(t, err)
+		slices[allocation.GPUUUID]++
+		instaslice.Spec.Allocations[allocation.PodUUID] = *allocation
+	}
+	assert.Equal(t, map[string]int{uuids[1]: 7, uuids[3]: 7}, slices)
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-14", Namespace: "default", UID: "pod-uid-14"}}
+	_, err = r.findDeviceForASlice(&instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.Error(t, err)
+}
+
+func TestUnmanagedGPUsAreNotAskedForTheirUUID(t *testing.T) {
+	r := &InstaSliceDaemonsetReconciler{ManagedGPUs: []string{"1"}}
+	assert.True(t, r.mayManageGPU(1))
+	assert.False(t, r.mayManageGPU(0))
+	assert.True(t, r.managesGPU(1, "GPU-1"))
+	assert.False(t, r.managesGPU(0, "GPU-0"))
+
+	// a GPU listed by UUID may be any index
+	r.ManagedGPUs = []string{"GPU-1"}
+	assert.True(t, r.mayManageGPU(0))
+	assert.True(t, r.managesGPU(0, "GPU-1"))
+	assert.False(t, r.managesGPU(1, "GPU-0"))
+
+	r.ManagedGPUs = nil
+	assert.True(t, r.managesGPU(3, "GPU-3"))
+}
+
+func TestParseManagedGPUs(t *testing.T) {
+	gpus, err := ParseManagedGPUs("0, GPU-1a2b")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0", "GPU-1a2b"}, gpus)
+
+	gpus, err = ParseManagedGPUs("")
+	require.NoError(t, err)
+	assert.Empty(t, gpus)
+
+	for _, value := range []string{"0,,1", "-1", "0,0"} {
+		_, err := ParseManagedGPUs(value)
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// gpuMemoryGB returns the memory of the GPUs of the node, that of the largest profile they support.
+func gpuMemoryGB(instaslice *inferencev1alpha1.Instaslice) int {
+	memoryGB := 0
+	for _, mig := range instaslice.Spec.Migplacement {
+		memoryGB = max(memoryGB, profileMemoryGB(mig.Profile))
+	}
+	return memoryGB
+}
+
+// usableMemoryGB returns the memory of a GPU of the node the slices may take once the reserved memory is set aside.
+func usableMemoryGB(instaslice *inferencev1alpha1.Instaslice) int {
+	return gpuMemoryGB(instaslice) - instaslice.Spec.ReservedMemoryGBPerGPU
+}
+
+// gpuMemoryUsedGB returns the memory taken on the GPU by its slices and the pending allocations on it, the
+// same slices occupiedIndexes counts.
+func gpuMemoryUsedGB(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) int {
+	// a slice split in several compute instances has a prepared entry per instance, all at the same start
+	profileAt := make(map[uint32]string)
+	for _, item := range instaslice.Status.Prepared {
+		if item.Parent == gpuUUID {
+			profileAt[item.Start] = item.Profile
+		}
+	}
+	for _, item := range instaslice.Spec.Allocations {
+		if item.GPUUUID == gpuUUID && item.Allocationstatus != inferencev1alpha1.AllocationStatusDeleted && item.Allocationstatus != inferencev1alpha1.AllocationStatusUngated && item.Allocationstatus != inferencev1alpha1.AllocationStatusFailed {
+			profileAt[item.Start] = item.Profile
+		}
+	}
+	usedGB := 0
+	for _, profile := range profileAt {
+		usedGB += profileMemoryGB(profile)
+	}
+	return usedGB
+}
+
+// gpuHasMemoryFor tells whether a slice of the profile fits in the memory of the GPU left to slices, always true
+// when no memory is reserved.
+func gpuHasMemoryFor(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, profile string) bool {
+	if instaslice.Spec.ReservedMemoryGBPerGPU <= 0 {
+		return true
+	}
+	return gpuMemoryUsedGB(instaslice, gpuUUID)+profileMemoryGB(profile) <= usableMemoryGB(instaslice)
+}
+
+// profileFitsReservedMemory tells whether a slice of the profile fits on an empty GPU of the node once the reserved
+// memory is set aside.
+func profileFitsReservedMemory(instaslice *inferencev1alpha1.Instaslice, profile string) bool {
+	return instaslice.Spec.ReservedMemoryGBPerGPU <= 0 || profileMemoryGB(profile) <= usableMemoryGB(instaslice)
+}
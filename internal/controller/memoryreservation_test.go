@@ -0,0 +1,95 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newMemoryReservationTestInstaslice returns the instaslice of a node with an A100 40GB GPU holding a 3g.20gb slice.
+func newMemoryReservationTestInstaslice(reservedGB int) *inferencev1alpha1.Instaslice {
+	return &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec: inferencev1alpha1.InstasliceSpec{
+			MigGPUUUID: map[string]string{"GPU-1": "NVIDIA A100-PCIE-40GB"},
+			Migplacement: []inferencev1alpha1.Mig{
+				{Profile: "1g.5gb", Giprofileid: 0, Placements: []inferencev1alpha1.Placement{
+					{Size: 1, Start: 0}, {Size: 1, Start: 1}, {Size: 1, Start: 2}, {Size: 1, Start: 3},
+					{Size: 1, Start: 4}, {Size: 1, Start: 5}, {Size: 1, Start: 6}}},
+				{Profile: "2g.10gb", Giprofileid: 5, Placements: []inferencev1alpha1.Placement{
+					{Size: 2, Start: 0}, {Size: 2, Start: 2}, {Size: 2, Start: 4}}},
+				{Profile: "3g.20gb", Giprofileid: 9, Placements: []inferencev1alpha1.Placement{
+					{Size: 4, Start: 0}, {Size: 4, Start: 4}}},
+				{Profile: "7g.40gb", Giprofileid: 0, Placements: []inferencev1alpha1.Placement{
+					{Size: 8, Start: 0}}},
+			},
+			ReservedMemoryGBPerGPU: reservedGB,
+		},
+		Status: inferencev1alpha1.InstasliceStatus{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{
+				"MIG-1": {Profile: "3g.20gb", Parent: "GPU-1", Start: 0, Size: 4, PodUUID: "pod-uid-0"},
+			},
+		},
+	}
+}
+
+func TestReservedMemoryLimitsSimultaneousSlices(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+
+	allocation, err := r.findDeviceForASlice(newMemoryReservationTestInstaslice(0), "3g.20gb", &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(4), allocation.Start)
+
+	// with 5GB reserved, 35GB are left: a second 3g.20gb no longer fits next to the first one, a 2g.10gb does
+	instaslice := newMemoryReservationTestInstaslice(5)
+	_, err = r.findDeviceForASlice(instaslice, "3g.20gb", &FirstFitPolicy{}, pod)
+	assert.Error(t, err)
+	allocation, err = r.findDeviceForASlice(instaslice, "2g.10gb", &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(4), allocation.Start)
+	assert.Equal(t, map[string]int{"1g.5gb": 3, "2g.10gb": 1, "3g.20gb": 0, "7g.40gb": 0}, freeSlicesPerProfile(instaslice))
+	assert.Equal(t, map[string]int{"1g.5gb": 3, "2g.10gb": 1, "3g.20gb": 1, "7g.40gb": 0}, freeSlicesPerProfile(newMemoryReservationTestInstaslice(0)))
+}
+
+func TestReservedMemoryNarrowsMemoryRequestProfiles(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+	profileName := memoryProfileName(resource.MustParse("21Gi"))
+	instaslice := newMemoryReservationTestInstaslice(5)
+	instaslice.Status.Prepared = nil
+
+	// only 7g.40gb has enough memory, more than the 35GB left
+	profiles, derived := candidateProfiles(instaslice, profileName)
+	assert.True(t, derived)
+	assert.Empty(t, profiles)
+	_, err := r.findDeviceForASlice(instaslice, profileName, &FirstFitPolicy{}, pod)
+	assert.Error(t, err)
+
+	instaslice.Spec.ReservedMemoryGBPerGPU = 0
+	allocation, err := r.findDeviceForASlice(instaslice, profileName, &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, "7g.40gb", allocation.Profile)
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// GPUMemoryResource is the limit a pod sets to get the smallest slice with at least that much memory
+	// instead of a slice of a given profile, e.g. nvidia.com/gpu-memory: 5Gi.
+	GPUMemoryResource = "nvidia.com/gpu-memory"
+	// MemoryProfilePrefix starts the profile of a pod limiting GPUMemoryResource until a node resolves it,
+	// followed by the requested memory in GB, e.g. memory-5gb.
+	MemoryProfilePrefix = "memory-"
+)
+
+// memoryProfileName returns the profile standing for a request of the given GPU memory, rounded up to the GB.
+func memoryProfileName(memory resource.Quantity) string {
+	gb := (memory.Value() + 1<<30 - 1) >> 30
+	return fmt.Sprintf("%s%dgb", MemoryProfilePrefix, gb)
+}
+
+// requestedMemoryGB returns the memory a profile built by memoryProfileName requests, false for other profiles.
+func requestedMemoryGB(profileName string) (int, bool) {
+	memory, found := strings.CutPrefix(profileName, MemoryProfilePrefix)
+	if !found {
+		return 0, false
+	}
+	gb, err := strconv.Atoi(strings.TrimSuffix(memory, "gb"))
+	if err != nil {
+		return 0, false
+	}
+	return gb, true
+}
+
+// profilesWithMemory returns the profiles of the node with at least the given memory, from the smallest to the
+// largest like profilesBySize.
+func profilesWithMemory(instaslice *inferencev1alpha1.Instaslice, gb int) []string {
+	var profiles []string
+	for _, profile := range profilesBySize(instaslice) {
+		if profileMemoryGB(profile) >= gb {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
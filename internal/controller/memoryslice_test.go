@@ -0,0 +1,61 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFindDeviceForMemoryRequestOnA100(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+	limits := v1.ResourceList{GPUMemoryResource: resource.MustParse("5Gi")}
+
+	profileName := r.extractProfileName(limits)
+	assert.Equal(t, "memory-5gb", profileName)
+	allocation, err := r.findDeviceForASlice(newAnySliceTestInstaslice(), profileName, &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, "1g.5gb", allocation.Profile)
+	assert.Equal(t, uint32(1), allocation.Size)
+}
+
+func TestFindDeviceForMemoryRequestRoundsUpToLargerProfile(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+	profileName := r.extractProfileName(v1.ResourceList{GPUMemoryResource: resource.MustParse("5500Mi")})
+
+	allocation, err := r.findDeviceForASlice(newAnySliceTestInstaslice(), profileName, &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, "2g.10gb", allocation.Profile)
+	assert.Equal(t, "2g.10gb", nodeProfileFor(newAnySliceTestInstaslice(), profileName))
+}
+
+func TestMemoryRequestExceedingEveryProfileIsUnsupported(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+	profileName := r.extractProfileName(v1.ResourceList{GPUMemoryResource: resource.MustParse("80Gi")})
+
+	_, err := r.findDeviceForASlice(newAnySliceTestInstaslice(), profileName, &FirstFitPolicy{}, pod)
+	assert.Error(t, err)
+	assert.Equal(t, ReasonProfileUnsupported, unschedulableReason(newAnySliceTestInstaslice(), profileName))
+}
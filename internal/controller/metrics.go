@@ -0,0 +1,233 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+var (
+	// sliceCreationDuration tracks the time NVML takes to carve a slice, from
+	// CreateGpuInstanceWithPlacement through CreateComputeInstance.
+	sliceCreationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "instaslice_slice_creation_duration_seconds",
+			Help:    "Time spent creating the GPU instance and compute instance of a MIG slice.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		},
+		[]string{"profile"},
+	)
+	// gpuMigEnabled reports, per GPU, whether MIG mode is enabled.
+	gpuMigEnabled = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "instaslice_gpu_mig_enabled",
+			Help: "Whether MIG mode is enabled on the GPU, 1 when enabled and 0 otherwise.",
+		},
+		[]string{"gpu"},
+	)
+	// gpuCarvedSlices reports, per GPU, the number of slices carved on it.
+	gpuCarvedSlices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "instaslice_gpu_carved_slices",
+			Help: "Number of MIG slices carved on the GPU, whatever their profile.",
+		},
+		[]string{"gpu"},
+	)
+	// gpuFragmentation reports, per GPU, how scattered its free memory slices are.
+	gpuFragmentation = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "instaslice_gpu_fragmentation_ratio",
+			Help: "Share of the free memory slices of the GPU outside its largest free contiguous region, 0 when the free slices are contiguous or none is free.",
+		},
+		[]string{"gpu"},
+	)
+	// gpuXidErrors counts, per GPU, the Xid critical errors NVML reported.
+	gpuXidErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "instaslice_gpu_xid_errors_total",
+			Help: "Number of Xid critical errors NVML reported for the GPU.",
+		},
+		[]string{"gpu"},
+	)
+	// sliceGPUUtilization reports, per MIG device, the share of time its kernels were running.
+	sliceGPUUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "instaslice_slice_gpu_utilization_percent",
+			Help: "Percent of time over the last sample period one or more kernels ran on the MIG device.",
+		},
+		sliceUsageLabels,
+	)
+	// sliceMemoryUsed reports, per MIG device, the memory allocated on it.
+	sliceMemoryUsed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "instaslice_slice_memory_used_bytes",
+			Help: "Memory allocated on the MIG device.",
+		},
+		sliceUsageLabels,
+	)
+	// sliceMemoryTotal reports, per MIG device, the memory it holds.
+	sliceMemoryTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "instaslice_slice_memory_total_bytes",
+			Help: "Memory of the MIG device.",
+		},
+		sliceUsageLabels,
+	)
+	// slicePowerUsage reports, per MIG device, the power drawn by it.
+	slicePowerUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "instaslice_slice_power_usage_watts",
+			Help: "Power drawn by the MIG device, only reported by drivers supporting it.",
+		},
+		sliceUsageLabels,
+	)
+	// stuckAllocations reports, per node, the allocations stuck in a transitional status.
+	stuckAllocations = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "instaslice_stuck_allocations",
+			Help: "Number of allocations of the node that have been creating or deleting for longer than the stuck allocation threshold.",
+		},
+		[]string{"node"},
+	)
+	// sliceCreations counts, per profile, the attempts to carve a slice by the NVML return code they ended with.
+	sliceCreations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "instaslice_slice_creations_total",
+			Help: "Number of attempts to create the GPU instance and compute instance of a MIG slice, by NVML return code.",
+		},
+		[]string{"profile", "code"},
+	)
+	// sliceDeletions counts the attempts to destroy a slice by the NVML return code they ended with.
+	sliceDeletions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "instaslice_slice_deletions_total",
+			Help: "Number of attempts to destroy the compute instances and GPU instance of a MIG slice, by NVML return code.",
+		},
+		[]string{"code"},
+	)
+	// preparedSlices reports, per node and profile, the slices prepared on the node.
+	preparedSlices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "instaslice_prepared_slices",
+			Help: "Number of MIG slices prepared on the node, by profile.",
+		},
+		[]string{"node", "profile"},
+	)
+	// nodeAPIReads counts the reads of the node sent to the API server instead of being served by the cache.
+	nodeAPIReads = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "instaslice_node_api_reads_total",
+			Help: "Number of reads of the node sent to the API server because the cached node was stale.",
+		},
+	)
+)
+
+// sliceUsageLabels identify the slice and the pod it is prepared for on the slice usage gauges.
+var sliceUsageLabels = []string{"mig_uuid", "pod", "namespace"}
+
+func init() {
+	metrics.Registry.MustRegister(sliceCreationDuration, gpuMigEnabled, gpuCarvedSlices, gpuFragmentation, gpuXidErrors,
+		sliceGPUUtilization, sliceMemoryUsed, sliceMemoryTotal, slicePowerUsage, nodeAPIReads, stuckAllocations,
+		sliceCreations, sliceDeletions, preparedSlices)
+}
+
+// observeSliceCreation records how long it took to carve a slice of the given profile.
+func observeSliceCreation(profile string, elapsed time.Duration) {
+	sliceCreationDuration.WithLabelValues(profile).Observe(elapsed.Seconds())
+}
+
+// countSliceCreation counts an attempt to carve a slice of the given profile that ended with ret.
+func countSliceCreation(profile string, ret nvml.Return) {
+	sliceCreations.WithLabelValues(profile, ret.String()).Inc()
+}
+
+// countSliceDeletion counts an attempt to destroy a slice that ended with ret.
+func countSliceDeletion(ret nvml.Return) {
+	sliceDeletions.WithLabelValues(ret.String()).Inc()
+}
+
+// observePreparedSlices publishes the slices prepared on the node by profile, recomputed from the instaslice so
+// that the gauge is right again after a restart or a missed update. Profiles no longer prepared are dropped.
+func observePreparedSlices(nodeName string, instaslice *inferencev1alpha1.Instaslice) {
+	counts := make(map[string]int)
+	for _, prepared := range instaslice.Status.Prepared {
+		counts[prepared.Profile]++
+	}
+	preparedSlices.DeletePartialMatch(prometheus.Labels{"node": nodeName})
+	for profile, count := range counts {
+		preparedSlices.WithLabelValues(nodeName, profile).Set(float64(count))
+	}
+}
+
+// publishPreparedSlices publishes the slices prepared on the node once a reconcile is done with the instaslice.
+func (r *InstaSliceDaemonsetReconciler) publishPreparedSlices(ctx context.Context, nodeName string, nsName types.NamespacedName) {
+	var instaslice inferencev1alpha1.Instaslice
+	if err := r.Get(ctx, nsName, &instaslice); err != nil {
+		log.FromContext(ctx).Error(err, "unable to read the instaslice to publish its prepared slices")
+		return
+	}
+	observePreparedSlices(nodeName, &instaslice)
+}
+
+// observeGPUState publishes the MIG mode and carved slice count of every GPU recorded in the instaslice status.
+func observeGPUState(status inferencev1alpha1.InstasliceStatus) {
+	for gpuUUID, enabled := range status.MigEnabled {
+		value := 0.0
+		if enabled {
+			value = 1
+		}
+		gpuMigEnabled.WithLabelValues(gpuUUID).Set(value)
+	}
+	for gpuUUID, count := range status.CarvedSlices {
+		gpuCarvedSlices.WithLabelValues(gpuUUID).Set(float64(count))
+	}
+	for gpuUUID, ranges := range status.OccupiedRanges {
+		gpuFragmentation.WithLabelValues(gpuUUID).Set(fragmentationRatio(ranges, gpuMemorySlices))
+	}
+}
+
+// fragmentationRatio returns the share of the free memory slices outside the largest free contiguous region, given
+// the occupied ranges of a GPU of the given number of memory slices. A GPU with free memory but no room left for a
+// larger profile has a ratio close to 1.
+func fragmentationRatio(occupied []inferencev1alpha1.SliceRange, memorySlices int) float64 {
+	taken := NewPlacementSet(memorySlices)
+	for _, r := range occupied {
+		taken.Occupy(r.Start, r.Size)
+	}
+	return fragmentationOf(taken)
+}
+
+// fragmentationOf returns the fragmentation ratio of a GPU given which of its memory slices are taken.
+func fragmentationOf(taken PlacementSet) float64 {
+	var free uint32
+	for _, region := range taken.regions(false) {
+		free += region.Size
+	}
+	if free == 0 {
+		return 0
+	}
+	return 1 - float64(taken.LargestFreeRegion().Size)/float64(free)
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// histogramSampleCount returns the number of observations recorded by a histogram.
+func histogramSampleCount(t *testing.T, observer prometheus.Observer) uint64 {
+	var m dto.Metric
+	assert.NoError(t, observer.(prometheus.Metric).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+// gaugeValue returns the current value of a gauge.
+func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	var m dto.Metric
+	assert.NoError(t, gauge.Write(&m))
+	return m.GetGauge().GetValue()
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	var m dto.Metric
+	assert.NoError(t, counter.Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func TestObserveSliceCreationRecordsProfileLabel(t *testing.T) {
+	before := histogramSampleCount(t, sliceCreationDuration.WithLabelValues("2g.10gb"))
+	otherBefore := histogramSampleCount(t, sliceCreationDuration.WithLabelValues("1g.5gb"))
+
+	observeSliceCreation("2g.10gb", 150*time.Millisecond)
+
+	assert.Equal(t, before+1, histogramSampleCount(t, sliceCreationDuration.WithLabelValues("2g.10gb")))
+	assert.Equal(t, otherBefore, histogramSampleCount(t, sliceCreationDuration.WithLabelValues("1g.5gb")))
+}
+
+func TestClearCarvedSliceIntentsRecordsCreationDuration(t *testing.T) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	instaslice := &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"},
+		Status: inferencev1alpha1.InstasliceStatus{
+			SliceIntents: map[string]inferencev1alpha1.SliceIntent{"pod-uid-1": {}},
+		},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(instaslice).WithStatusSubresource(instaslice).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s}
+
+	durations := map[string]metav1.Duration{"1g.5gb": {Duration: 2 * time.Second}, "2g.10gb": {}}
+	assert.NoError(t, reconciler.clearCarvedSliceIntents(context.Background(), "node-1", durations, "pod-uid-1"))
+
+	var updated inferencev1alpha1.Instaslice
+	assert.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &updated))
+	assert.Empty(t, updated.Status.SliceIntents)
+	assert.Equal(t, 2*time.Second, updated.Status.LastSliceCreationDuration["1g.5gb"].Duration)
+	assert.NotContains(t, updated.Status.LastSliceCreationDuration, "2g.10gb", "untimed slices are not recorded")
+}
+
+func TestReconcileRecordsSliceCreationDuration(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	instaslice := latestTestInstaslice(t, fakeClient)
+	require.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Empty(t, instaslice.Status.SliceIntents)
+	assert.Contains(t, instaslice.Status.LastSliceCreationDuration, "1g.5gb")
+}
+
+func TestBatchReconcileRecordsSliceCreationDuration(t *testing.T) {
+	starts := make([]uint32, batchCreationThreshold)
+	for i := range starts {
+		starts[i] = uint32(i)
+	}
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, starts...)
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	require.Len(t, device.GpuInstances, len(starts))
+	instaslice := latestTestInstaslice(t, fakeClient)
+	assert.Empty(t, instaslice.Status.SliceIntents)
+	assert.Contains(t, instaslice.Status.LastSliceCreationDuration, "1g.5gb")
+}
+
+func TestGPUGaugesReflectCreatedSlices(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	assert.Equal(t, float64(1), gaugeValue(t, gpuMigEnabled.WithLabelValues(device.UUID)))
+	assert.Equal(t, float64(0), gaugeValue(t, gpuCarvedSlices.WithLabelValues(device.UUID)))
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	assert.Equal(t, float64(2), gaugeValue(t, gpuCarvedSlices.WithLabelValues(device.UUID)))
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, 2, instaslice.Status.CarvedSlices[device.UUID])
+	assert.True(t, instaslice.Status.MigEnabled[device.UUID])
+}
+
+func TestGPUFragmentationGaugeReflectsScatteredFreeSlices(t *testing.T) {
+	// 1g slices at indexes 1 and 5 leave 6 memory slices free, but at most 3 in a row, no room for a 4g slice
+	observeGPUState(inferencev1alpha1.InstasliceStatus{OccupiedRanges: map[string][]inferencev1alpha1.SliceRange{
+		"GPU-fragmented": {{Start: 1, Size: 1}, {Start: 5, Size: 1}},
+		"GPU-packed":     {{Start: 0, Size: 2}},
+		"GPU-full":       {{Start: 0, Size: 8}},
+		"GPU-empty":      {},
+	}})
+
+	assert.Equal(t, 0.5, gaugeValue(t, gpuFragmentation.WithLabelValues("GPU-fragmented")))
+	assert.Equal(t, float64(0), gaugeValue(t, gpuFragmentation.WithLabelValues("GPU-packed")))
+	assert.Equal(t, float64(0), gaugeValue(t, gpuFragmentation.WithLabelValues("GPU-full")))
+	assert.Equal(t, float64(0), gaugeValue(t, gpuFragmentation.WithLabelValues("GPU-empty")))
+}
+
+func TestSliceCreationsAreCountedByReturnCode(t *testing.T) {
+	created := sliceCreations.WithLabelValues("1g.5gb", nvml.SUCCESS.String())
+	failed := sliceCreations.WithLabelValues("1g.5gb", nvml.ERROR_UNKNOWN.String())
+	createdBefore, failedBefore := counterValue(t, created), counterValue(t, failed)
+	ctx := context.Background()
+
+	reconciler, _, _, _ := newFakeGPUTestReconciler(t, 0)
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, createdBefore+1, counterValue(t, created))
+	assert.Equal(t, failedBefore, counterValue(t, failed))
+	assert.Equal(t, float64(1), gaugeValue(t, preparedSlices.WithLabelValues("node-1", "1g.5gb")))
+
+	reconciler, _, device, _ := newFakeGPUTestReconciler(t, 0)
+	device.CreateGpuInstanceWithPlacementFunc = func(*nvml.GpuInstanceProfileInfo, *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		return nil, nvml.ERROR_UNKNOWN
+	}
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, createdBefore+1, counterValue(t, created))
+	assert.Equal(t, failedBefore+1, counterValue(t, failed))
+	// nothing is prepared on the node of the failed creation
+	assert.Equal(t, float64(0), gaugeValue(t, preparedSlices.WithLabelValues("node-1", "1g.5gb")))
+}
+
+func TestObservePreparedSlicesDropsProfilesNoLongerPrepared(t *testing.T) {
+	instaslice := &inferencev1alpha1.Instaslice{Status: inferencev1alpha1.InstasliceStatus{
+		Prepared: map[string]inferencev1alpha1.PreparedDetails{
+			"MIG-1": {Profile: "1g.5gb"},
+			"MIG-2": {Profile: "1g.5gb"},
+			"MIG-3": {Profile: "3g.20gb"},
+		},
+	}}
+	observePreparedSlices("node-prepared", instaslice)
+	assert.Equal(t, float64(2), gaugeValue(t, preparedSlices.WithLabelValues("node-prepared", "1g.5gb")))
+	assert.Equal(t, float64(1), gaugeValue(t, preparedSlices.WithLabelValues("node-prepared", "3g.20gb")))
+
+	delete(instaslice.Status.Prepared, "MIG-3")
+	observePreparedSlices("node-prepared", instaslice)
+	assert.Equal(t, float64(2), gaugeValue(t, preparedSlices.WithLabelValues("node-prepared", "1g.5gb")))
+	assert.False(t, preparedSlices.DeleteLabelValues("node-prepared", "3g.20gb"))
+}
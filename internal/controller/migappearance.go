@@ -0,0 +1,57 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// migAppearancePolls is the number of times the MIG devices of the GPU are listed for the one of a freshly
+// carved slice, NVML may expose it some time after its compute instance was created.
+var migAppearancePolls = 5
+
+// migAppearancePollInterval is how long is waited between two listings of the MIG devices of the GPU.
+var migAppearancePollInterval = 200 * time.Millisecond
+
+// waitForCreatedSliceDetails polls getCreatedSliceDetails until the MIG device of the slice carved in the gi shows
+// up, at most migAppearancePolls times, and returns the error of the last poll when it never does.
+func (r *InstaSliceDaemonsetReconciler) waitForCreatedSliceDetails(ctx context.Context, giInfo nvml.GpuInstanceInfo, device nvml.Device, uuid string, profileName string) (uint32, string, uint32, error) {
+	var (
+		giID, ciID uint32
+		migUUID    string
+		err        error
+	)
+	for poll := 1; ; poll++ {
+		giID, migUUID, ciID, err = r.getCreatedSliceDetails(ctx, giInfo, nvml.SUCCESS, device, uuid, profileName)
+		if err == nil && migUUID != "" {
+			return giID, migUUID, ciID, nil
+		}
+		if poll >= migAppearancePolls {
+			return giID, migUUID, ciID, err
+		}
+		log.FromContext(ctx).Info("MIG device of the slice not exposed yet, polling again", "gpu", uuid, "gi", giInfo.Id, "poll", poll)
+		select {
+		case <-ctx.Done():
+			return giID, migUUID, ciID, ctx.Err()
+		case <-time.After(migAppearancePollInterval):
+		}
+	}
+}
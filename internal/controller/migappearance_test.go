@@ -0,0 +1,90 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hideMigDevices makes the GPU list no MIG device for its first hiddenPolls listings and returns the number of
+// listings made.
+func hideMigDevices(device *dgxa100.Device, hiddenPolls int) *int {
+	listings := 0
+	getMaxMigDeviceCount := device.GetMaxMigDeviceCountFunc
+	device.GetMaxMigDeviceCountFunc = func() (int, nvml.Return) {
+		listings++
+		if listings <= hiddenPolls {
+			return 0, nvml.SUCCESS
+		}
+		return getMaxMigDeviceCount()
+	}
+	return &listings
+}
+
+// carveFakeSlice carves a 1g.5gb slice at the start of the first fake GPU and returns the GPU and the gi info.
+func carveFakeSlice(t *testing.T) (*InstaSliceDaemonsetReconciler, *dgxa100.Device, nvml.GpuInstanceInfo) {
+	nvmllib := newFakeGPUs(1)
+	handle, ret := nvmllib.DeviceGetHandleByIndex(0)
+	require.Equal(t, nvml.SUCCESS, ret)
+	device := handle.(*dgxa100.Device)
+	gi, ret := createGpuInstance(device, nvml.GPU_INSTANCE_PROFILE_1_SLICE, nvml.GpuInstancePlacement{Start: 0, Size: 1})
+	require.Equal(t, nvml.SUCCESS, ret)
+	_, ret = createComputeInstance(gi, nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED)
+	require.Equal(t, nvml.SUCCESS, ret)
+	giInfo, ret := gi.GetInfo()
+	require.Equal(t, nvml.SUCCESS, ret)
+	return &InstaSliceDaemonsetReconciler{nvmlHandler: newDeviceHandler(nvmllib)}, device, giInfo
+}
+
+func TestWaitForCreatedSliceDetailsPollsUntilMigAppears(t *testing.T) {
+	defer func(interval time.Duration) { migAppearancePollInterval = interval }(migAppearancePollInterval)
+	migAppearancePollInterval = time.Millisecond
+	reconciler, device, giInfo := carveFakeSlice(t)
+	expected := fakeMigDevices(device)
+	require.Len(t, expected, 1)
+	expectedUUID, ret := expected[0].GetUUID()
+	require.Equal(t, nvml.SUCCESS, ret)
+	listings := hideMigDevices(device, 1)
+
+	giID, migUUID, ciID, err := reconciler.waitForCreatedSliceDetails(context.Background(), giInfo, device, device.UUID, "1g.5gb")
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, *listings)
+	assert.Equal(t, expectedUUID, migUUID)
+	assert.Equal(t, giInfo.Id, giID)
+	assert.Equal(t, uint32(0), ciID)
+}
+
+func TestWaitForCreatedSliceDetailsGivesUpWhenMigNeverAppears(t *testing.T) {
+	defer func(interval time.Duration) { migAppearancePollInterval = interval }(migAppearancePollInterval)
+	migAppearancePollInterval = time.Millisecond
+	reconciler, device, giInfo := carveFakeSlice(t)
+	listings := hideMigDevices(device, migAppearancePolls+1)
+
+	_, migUUID, _, err := reconciler.waitForCreatedSliceDetails(context.Background(), giInfo, device, device.UUID, "1g.5gb")
+
+	assert.Error(t, err)
+	assert.Empty(t, migUUID)
+	assert.Equal(t, migAppearancePolls, *listings)
+}
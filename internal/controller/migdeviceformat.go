@@ -0,0 +1,87 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// MigDeviceFormat is how the ConfigMap of a pod references the MIG devices of its slice in NVIDIA_VISIBLE_DEVICES
+// and CUDA_VISIBLE_DEVICES.
+type MigDeviceFormat string
+
+const (
+	// MigDeviceFormatUUID references a MIG device by its UUID, e.g. MIG-4f6ef7c3-6a5d-5d6b-8b0a-3a6f2ff7d2a1.
+	MigDeviceFormatUUID MigDeviceFormat = "uuid"
+	// MigDeviceFormatIndex references a MIG device by its GPU and its gi and ci ids, e.g. MIG-GPU-<uuid>/7/0, the
+	// form container runtimes and drivers predating MIG UUIDs understand.
+	MigDeviceFormatIndex MigDeviceFormat = "index"
+)
+
+// ParseMigDeviceFormat validates a format name, an empty name is MigDeviceFormatUUID.
+func ParseMigDeviceFormat(value string) (MigDeviceFormat, error) {
+	switch MigDeviceFormat(value) {
+	case "", MigDeviceFormatUUID:
+		return MigDeviceFormatUUID, nil
+	case MigDeviceFormatIndex:
+		return MigDeviceFormatIndex, nil
+	}
+	return "", fmt.Errorf("unknown MIG device format %q, must be %s or %s", value, MigDeviceFormatUUID, MigDeviceFormatIndex)
+}
+
+// migDeviceIndexReference returns the reference to the MIG device in the index form.
+func migDeviceIndexReference(gpuUUID string, gi, ci int) string {
+	return fmt.Sprintf("MIG-%s/%d/%d", gpuUUID, gi, ci)
+}
+
+// visibleDevicesFor returns the NVIDIA_VISIBLE_DEVICES value of a pod given the MIG UUIDs of its slice, in the
+// MigDeviceFormat of the reconciler. The index form is resolved through NVML, a MIG device NVML does not know
+// is an error.
+func (r *InstaSliceDaemonsetReconciler) visibleDevicesFor(ctx context.Context, migUUIDs []string) (string, error) {
+	if r.MigDeviceFormat != MigDeviceFormatIndex {
+		return formatVisibleDevices(migUUIDs)
+	}
+	nvmllib := r.handler().nvml
+	references := make([]string, 0, len(migUUIDs))
+	for _, migUUID := range migUUIDs {
+		device, ret := nvmllib.DeviceGetHandleByUUID(migUUID)
+		if ret != nvml.SUCCESS {
+			return "", fmt.Errorf("unable to find MIG device %s: %v", migUUID, ret)
+		}
+		parent, ret := device.GetDeviceHandleFromMigDeviceHandle()
+		if ret != nvml.SUCCESS {
+			return "", fmt.Errorf("unable to find the GPU of MIG device %s: %v", migUUID, ret)
+		}
+		gpuUUID, ret := parent.GetUUID()
+		if ret != nvml.SUCCESS {
+			return "", fmt.Errorf("unable to get the UUID of the GPU of MIG device %s: %v", migUUID, ret)
+		}
+		gi, ret := device.GetGpuInstanceId()
+		if ret != nvml.SUCCESS {
+			return "", fmt.Errorf("unable to get the gi of MIG device %s: %v", migUUID, ret)
+		}
+		ci, ret := device.GetComputeInstanceId()
+		if ret != nvml.SUCCESS {
+			return "", fmt.Errorf("unable to get the ci of MIG device %s: %v", migUUID, ret)
+		}
+		references = append(references, migDeviceIndexReference(gpuUUID, gi, ci))
+	}
+	return formatVisibleDevices(references)
+}
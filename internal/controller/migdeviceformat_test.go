@@ -0,0 +1,73 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestParseMigDeviceFormat(t *testing.T) {
+	for value, expected := range map[string]MigDeviceFormat{
+		"":      MigDeviceFormatUUID,
+		"uuid":  MigDeviceFormatUUID,
+		"index": MigDeviceFormatIndex,
+	} {
+		format, err := ParseMigDeviceFormat(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, format)
+	}
+	_, err := ParseMigDeviceFormat("gi-ci")
+	assert.Error(t, err)
+}
+
+func TestConfigMapReferencesSliceInEachMigDeviceFormat(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.MigDeviceFormat = MigDeviceFormatIndex
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	migUUID, prepared := preparedOfPod(*latestTestInstaslice(t, fakeClient), "pod-uid-0")
+	require.NotEmpty(t, migUUID)
+	indexReference := fmt.Sprintf("MIG-%s/%d/%d", prepared.Parent, prepared.Giinfoid, prepared.Ciinfoid)
+
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-0", Namespace: "default"}, &configMap))
+	assert.Equal(t, indexReference, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	assert.Equal(t, indexReference, configMap.Data["CUDA_VISIBLE_DEVICES"])
+	assert.Equal(t, migUUID, configMap.Labels[ConfigMapMigUUIDLabel], "the label keeps the MIG UUID")
+
+	// the ConfigMap in the index form is no drift
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-0", Namespace: "default"}, &configMap))
+	assert.Equal(t, indexReference, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+
+	// the same slice referenced by its UUID
+	reconciler.MigDeviceFormat = MigDeviceFormatUUID
+	require.NoError(t, reconciler.createConfigMap(ctx, []string{migUUID}, "default", "pod-uuid-form", "pod-uid-0", "1g.5gb", 4864))
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-uuid-form", Namespace: "default"}, &configMap))
+	assert.Equal(t, migUUID, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	assert.Equal(t, migUUID, configMap.Data["CUDA_VISIBLE_DEVICES"])
+}
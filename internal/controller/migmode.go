@@ -0,0 +1,96 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// ConditionMigModeInconsistent is set on the instaslice when MIG mode is enabled on some GPUs of the node only.
+	ConditionMigModeInconsistent = "MigModeInconsistent"
+	// ReasonMixedMigModes is the MIG mode inconsistent reason used when the GPUs of the node disagree on MIG mode.
+	ReasonMixedMigModes = "MixedMigModes"
+	// ReasonUniformMigMode is the reason used when every GPU of the node is in the same MIG mode.
+	ReasonUniformMigMode = "UniformMigMode"
+	// EventReasonMigModeInconsistent is the reason of the event emitted on the instaslice for mixed MIG modes.
+	EventReasonMigModeInconsistent = "MigModeInconsistent"
+)
+
+// migDisabledGPUs returns the GPUs MIG mode is disabled on when it is enabled on others, sorted, and none when
+// every GPU is in the same mode.
+func migDisabledGPUs(migEnabled map[string]bool) []string {
+	var enabled, disabled []string
+	for gpuUUID, isEnabled := range migEnabled {
+		if isEnabled {
+			enabled = append(enabled, gpuUUID)
+		} else {
+			disabled = append(disabled, gpuUUID)
+		}
+	}
+	if len(enabled) == 0 {
+		return nil
+	}
+	sort.Strings(disabled)
+	return disabled
+}
+
+// setMigModeCondition flags the status when the GPUs of the node disagree on MIG mode, or clears the condition
+// when they agree. It returns the GPUs MIG mode is disabled on in the first case.
+func setMigModeCondition(status *inferencev1alpha1.InstasliceStatus, migEnabled map[string]bool) []string {
+	disabled := migDisabledGPUs(migEnabled)
+	if len(disabled) == 0 {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ConditionMigModeInconsistent,
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonUniformMigMode,
+			Message: "every GPU of the node is in the same MIG mode",
+		})
+		return nil
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    ConditionMigModeInconsistent,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonMixedMigModes,
+		Message: migModeInconsistentMessage(disabled),
+	})
+	return disabled
+}
+
+// migModeInconsistentMessage tells which GPUs are out of MIG mode and what to do about it.
+func migModeInconsistentMessage(disabled []string) string {
+	return "MIG mode is disabled on GPUs " + strings.Join(disabled, ", ") +
+		" while enabled on the others, enable MIG mode on every GPU of the node for uniform scheduling"
+}
+
+// recordMigModeInconsistent logs and emits a warning event on the instaslice for the GPUs out of MIG mode.
+func (r *InstaSliceDaemonsetReconciler) recordMigModeInconsistent(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, disabled []string) {
+	log.FromContext(ctx).Info("GPUs of the node disagree on MIG mode", "migDisabled", disabled)
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Eventf(instaslice, v1.EventTypeWarning, EventReasonMigModeInconsistent, "%s", migModeInconsistentMessage(disabled))
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestDiscoveryFlagsMixedMigModes(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-1")
+	nvmllib := newFakeGPUs(2)
+	server := nvmllib.(*dgxa100.Server)
+	enabled := server.Devices[0].(*dgxa100.Device)
+	disabled := server.Devices[1].(*dgxa100.Device)
+	disabled.MigMode = nvml.DEVICE_MIG_DISABLE
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).Build()
+	recorder := record.NewFakeRecorder(10)
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s, Recorder: recorder, nvmlHandler: newDeviceHandler(nvmllib)}
+	ctx := context.Background()
+
+	_, err := reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, map[string]bool{enabled.UUID: true, disabled.UUID: false}, instaslice.Status.MigEnabled)
+	// the GPU in MIG mode is still advertised
+	assert.Contains(t, instaslice.Spec.MigGPUUUID, enabled.UUID)
+	assert.NotEmpty(t, instaslice.Spec.Migplacement)
+
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionMigModeInconsistent)
+	require.NotNil(t, condition)
+	assert.Equal(t, ReasonMixedMigModes, condition.Reason)
+	assert.Contains(t, condition.Message, disabled.UUID)
+	assert.NotContains(t, condition.Message, enabled.UUID)
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, EventReasonMigModeInconsistent)
+}
+
+func TestMigModeConditionClearedOnUniformModes(t *testing.T) {
+	var status inferencev1alpha1.InstasliceStatus
+	assert.Equal(t, []string{"GPU-2"}, setMigModeCondition(&status, map[string]bool{"GPU-1": true, "GPU-2": false}))
+	assert.True(t, meta.IsStatusConditionTrue(status.Conditions, ConditionMigModeInconsistent))
+
+	assert.Empty(t, setMigModeCondition(&status, map[string]bool{"GPU-1": true, "GPU-2": true}))
+	assert.True(t, meta.IsStatusConditionFalse(status.Conditions, ConditionMigModeInconsistent))
+	// a node without MIG at all is uniform too
+	assert.Empty(t, setMigModeCondition(&status, map[string]bool{"GPU-1": false, "GPU-2": false}))
+	assert.True(t, meta.IsStatusConditionFalse(status.Conditions, ConditionMigModeInconsistent))
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestMigUUIDsFollowAllocationLifecycle(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	require.Len(t, instaslice.Status.Prepared, 1)
+	for migUUID := range instaslice.Status.Prepared {
+		assert.Equal(t, []string{migUUID}, instaslice.Status.MigUUIDs["pod-uid-0"])
+	}
+
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.NotContains(t, instaslice.Status.MigUUIDs, "pod-uid-0")
+}
+
+func TestAllocationMigUUIDsOrderedByComputeInstance(t *testing.T) {
+	instaslice := &inferencev1alpha1.Instaslice{
+		Status: inferencev1alpha1.InstasliceStatus{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{
+				"MIG-b": {PodUUID: "pod-uid-0", Ciinfoid: 1},
+				"MIG-a": {PodUUID: "pod-uid-0", Ciinfoid: 2},
+				"MIG-c": {PodUUID: "pod-uid-1"},
+				"MIG-d": {},
+			},
+		},
+	}
+	assert.Equal(t, map[string][]string{
+		"pod-uid-0": {"MIG-b", "MIG-a"},
+		"pod-uid-1": {"MIG-c"},
+	}, allocationMigUUIDs(instaslice))
+}
@@ -0,0 +1,30 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// MultiSliceAnnotation set to true tells that the pod asks for several slices. Its allocation is marked so that
+// the validating webhook admits other allocations of the same pod UUID, which it rejects otherwise.
+const MultiSliceAnnotation = "org.instaslice/multi-slice"
+
+// multiSliceRequested reports whether the pod asks for several slices.
+func multiSliceRequested(pod *v1.Pod) bool {
+	return pod != nil && pod.Annotations[MultiSliceAnnotation] == "true"
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindDeviceForASliceRecordsMultiSlice(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := newPreemptTestPod(0)
+
+	allocation, err := r.findDeviceForASlice(newPlacementTestInstaslice(), "1g.5gb", &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.False(t, allocation.MultiSlice)
+
+	pod.Annotations = map[string]string{MultiSliceAnnotation: "true"}
+	allocation, err = r.findDeviceForASlice(newPlacementTestInstaslice(), "1g.5gb", &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.True(t, allocation.MultiSlice)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// reclaimAllocationsOfDeletedNamespaces moves the allocations whose namespace no longer exists to deleting.
+// Deleting a namespace removes the pods and ConfigMaps in it without the pods ever being seen as completed,
+// their slices would otherwise stay carved for good.
+func (r *InstaSliceDaemonsetReconciler) reclaimAllocationsOfDeletedNamespaces(ctx context.Context, nodeName string) error {
+	var instaslice inferencev1alpha1.Instaslice
+	typeNamespacedName := types.NamespacedName{
+		Name:      nodeName,
+		Namespace: r.instasliceNamespace(),
+	}
+	if err := r.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+		return err
+	}
+
+	deletedNamespaces := make(map[string]bool)
+	reclaimed := 0
+	for podUUID, allocation := range instaslice.Spec.Allocations {
+		if allocation.Namespace == "" || allocation.Allocationstatus == inferencev1alpha1.AllocationStatusDeleting {
+			continue
+		}
+		deleted, checked := deletedNamespaces[allocation.Namespace]
+		if !checked {
+			err := r.Get(ctx, types.NamespacedName{Name: allocation.Namespace}, &v1.Namespace{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+			deleted = apierrors.IsNotFound(err)
+			deletedNamespaces[allocation.Namespace] = deleted
+		}
+		if !deleted {
+			continue
+		}
+		log.FromContext(ctx).Info("namespace of the pod was deleted, reclaiming slice of ", "pod", allocation.PodName, "namespace", allocation.Namespace)
+		allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+		instaslice.Spec.Allocations[podUUID] = allocation
+		reclaimed++
+	}
+	if reclaimed == 0 {
+		return nil
+	}
+	return r.Update(ctx, &instaslice)
+}
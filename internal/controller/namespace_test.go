@@ -0,0 +1,60 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestSliceOfDeletedNamespaceIsReclaimed(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	require.NoError(t, fakeClient.Create(ctx, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}))
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	orphaned := instaslice.Spec.Allocations["pod-uid-1"]
+	orphaned.Namespace = "gone"
+	instaslice.Spec.Allocations["pod-uid-1"] = orphaned
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.Len(t, device.GpuInstances, 2)
+
+	require.NoError(t, reconciler.reclaimAllocationsOfDeletedNamespaces(ctx, "node-1"))
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, instaslice.Spec.Allocations["pod-uid-1"].Allocationstatus)
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Contains(t, instaslice.Spec.Allocations, "pod-uid-0")
+	assert.NotContains(t, instaslice.Spec.Allocations, "pod-uid-1")
+	assert.Len(t, instaslice.Status.Prepared, 1)
+	assert.Len(t, device.GpuInstances, 1)
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// InstaSliceResourcePrefix prefixes the extended resource advertised on the node for the slice of each pod.
+const InstaSliceResourcePrefix = "org.instaslice/"
+
+// missingInstaSliceResources lists the extended resources of the realized slices of the instaslice that the node
+// does not advertise, e.g. because a restart of the device plugin wiped them from its capacity.
+func missingInstaSliceResources(node *v1.Node, instaslice *inferencev1alpha1.Instaslice) []string {
+	var missing []string
+	for key, allocation := range instaslice.Spec.Allocations {
+		if !settledAllocation(allocation) || key != allocation.PodUUID {
+			continue
+		}
+		resourceName := InstaSliceResourcePrefix + allocation.PodName
+		if _, exists := node.Status.Capacity[v1.ResourceName(resourceName)]; !exists {
+			missing = append(missing, resourceName)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// staleInstaSliceResources lists the extended resources the node advertises for pods none of the allocations of
+// the instaslice carves or holds a slice for, e.g. because the patch removing the resource of a deleted pod was lost.
+func staleInstaSliceResources(node *v1.Node, instaslice *inferencev1alpha1.Instaslice) []string {
+	holding := make(map[string]bool)
+	for key, allocation := range instaslice.Spec.Allocations {
+		// the resource of a slice being carved is advertised before the slice exists
+		if key == allocation.PodUUID && (settledAllocation(allocation) || allocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreating) {
+			holding[InstaSliceResourcePrefix+allocation.PodName] = true
+		}
+	}
+	var stale []string
+	for resourceName := range node.Status.Capacity {
+		if strings.HasPrefix(string(resourceName), InstaSliceResourcePrefix) && !holding[string(resourceName)] {
+			stale = append(stale, string(resourceName))
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// restoreInstaSliceResources patches back in the node capacity the extended resources of realized slices that
+// went missing, pods still to be scheduled would otherwise never fit on the node. When CorrectCapacityDrift is set
+// the stale resources of pods without a slice are removed in the same patch.
+// Only the resources advertised per pod are restored, other advertisers own what they publish.
+func (r *InstaSliceDaemonsetReconciler) restoreInstaSliceResources(ctx context.Context, nodeName string, instaslice *inferencev1alpha1.Instaslice) error {
+	if _, perPod := r.capacityAdvertiser().(*PodResourceAdvertiser); !perPod {
+		return nil
+	}
+	node := &v1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		return err
+	}
+	missing := missingInstaSliceResources(node, instaslice)
+	var stale []string
+	if r.CorrectCapacityDrift {
+		stale = staleInstaSliceResources(node, instaslice)
+	}
+	if len(missing) == 0 && len(stale) == 0 {
+		return nil
+	}
+	patch := make([]ResPatchOperation, 0, len(missing)+len(stale))
+	for _, resourceName := range missing {
+		patch = append(patch, ResPatchOperation{
+			Op:    "add",
+			Path:  fmt.Sprintf("/status/capacity/%s", strings.ReplaceAll(resourceName, "/", "~1")),
+			Value: "1",
+		})
+	}
+	for _, resourceName := range stale {
+		patch = append(patch, ResPatchOperation{
+			Op:   "remove",
+			Path: fmt.Sprintf("/status/capacity/%s", strings.ReplaceAll(resourceName, "/", "~1")),
+		})
+	}
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("correcting instaslice resources of the node capacity", "missing", missing, "stale", stale)
+	return r.Status().Patch(ctx, node, client.RawPatch(types.JSONPatchType, patchData))
+}
+
+// lostInstaSliceResource reports whether an instaslice resource advertised by the old node is gone from the new one.
+func lostInstaSliceResource(oldNode, newNode *v1.Node) bool {
+	for resourceName := range oldNode.Status.Capacity {
+		if !strings.HasPrefix(string(resourceName), InstaSliceResourcePrefix) {
+			continue
+		}
+		if _, exists := newNode.Status.Capacity[resourceName]; !exists {
+			return true
+		}
+	}
+	return false
+}
+
+// instaSliceResourceLostPredicate only lets through updates of the node dropping instaslice resources.
+var instaSliceResourceLostPredicate = predicate.Funcs{
+	CreateFunc:  func(event.CreateEvent) bool { return false },
+	DeleteFunc:  func(event.DeleteEvent) bool { return false },
+	GenericFunc: func(event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldNode, okOld := e.ObjectOld.(*v1.Node)
+		newNode, okNew := e.ObjectNew.(*v1.Node)
+		return okOld && okNew && lostInstaSliceResource(oldNode, newNode)
+	},
+}
+
+// nodeMapFunc maps the node the daemonset runs on to its instaslice.
+func (r *InstaSliceDaemonsetReconciler) nodeMapFunc(ctx context.Context, obj client.Object) []reconcile.Request {
+	if obj.GetName() != os.Getenv("NODE_NAME") {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: obj.GetName(), Namespace: r.instasliceNamespace()}}}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestReconcileRestoresWipedInstaSliceResources(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0, 1, 2)
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	// pod-0 was realized and pod-1 ungated before the device plugin restart, pod-2 is being torn down
+	statuses := map[string]inferencev1alpha1.AllocationStatus{
+		"pod-uid-0": inferencev1alpha1.AllocationStatusCreated,
+		"pod-uid-1": inferencev1alpha1.AllocationStatusUngated,
+		"pod-uid-2": inferencev1alpha1.AllocationStatusDeleting,
+	}
+	for podUUID, status := range statuses {
+		allocation := instaslice.Spec.Allocations[podUUID]
+		allocation.Allocationstatus = status
+		instaslice.Spec.Allocations[podUUID] = allocation
+	}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "node-1", Namespace: "default"}})
+	require.NoError(t, err)
+
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.Equal(t, resource.MustParse("1"), node.Status.Capacity["org.instaslice/pod-0"])
+	assert.Equal(t, resource.MustParse("1"), node.Status.Capacity["org.instaslice/pod-1"])
+	assert.NotContains(t, node.Status.Capacity, v1.ResourceName("org.instaslice/pod-2"))
+	// capacity advertised by others is left alone
+	assert.Equal(t, resource.MustParse("8"), node.Status.Capacity[v1.ResourceCPU])
+}
+
+func TestRestoreInstaSliceResourcesSkipsAdvertisedCapacity(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	require.NoError(t, reconciler.createInstaSliceResource(ctx, "node-1", inferencev1alpha1.AllocationDetails{PodName: "pod-0"}))
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusCreated
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	var before v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &before))
+
+	require.NoError(t, reconciler.restoreInstaSliceResources(ctx, "node-1", &instaslice))
+
+	var after v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &after))
+	assert.Equal(t, before.ResourceVersion, after.ResourceVersion)
+}
+
+func TestRestoreInstaSliceResourcesCorrectsDrift(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0, 1, 2)
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	statuses := map[string]inferencev1alpha1.AllocationStatus{
+		"pod-uid-0": inferencev1alpha1.AllocationStatusCreated,
+		"pod-uid-1": inferencev1alpha1.AllocationStatusUngated,
+		"pod-uid-2": inferencev1alpha1.AllocationStatusCreating,
+	}
+	for podUUID, status := range statuses {
+		allocation := instaslice.Spec.Allocations[podUUID]
+		allocation.Allocationstatus = status
+		instaslice.Spec.Allocations[podUUID] = allocation
+	}
+	// pod-1 lost its resource, gone-pod and pod-3 kept theirs after their slices were torn down
+	for _, podName := range []string{"pod-0", "pod-2", "gone-pod", "pod-3"} {
+		require.NoError(t, reconciler.createInstaSliceResource(ctx, "node-1", inferencev1alpha1.AllocationDetails{PodName: podName}))
+	}
+
+	// stale resources are only removed when asked for
+	require.NoError(t, reconciler.restoreInstaSliceResources(ctx, "node-1", &instaslice))
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.Contains(t, node.Status.Capacity, v1.ResourceName("org.instaslice/gone-pod"))
+
+	reconciler.CorrectCapacityDrift = true
+	require.NoError(t, reconciler.restoreInstaSliceResources(ctx, "node-1", &instaslice))
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	var advertised []string
+	for resourceName := range node.Status.Capacity {
+		advertised = append(advertised, string(resourceName))
+	}
+	// the resource of pod-2 was advertised ahead of its slice
+	assert.ElementsMatch(t, []string{"cpu", "org.instaslice/pod-0", "org.instaslice/pod-1", "org.instaslice/pod-2"}, advertised)
+
+	// a converged node is not patched again
+	require.NoError(t, reconciler.restoreInstaSliceResources(ctx, "node-1", &instaslice))
+	var after v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &after))
+	assert.Equal(t, node.ResourceVersion, after.ResourceVersion)
+}
+
+func TestInstaSliceResourceLostPredicate(t *testing.T) {
+	node := func(resources ...string) *v1.Node {
+		capacity := v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}
+		for _, resourceName := range resources {
+			capacity[v1.ResourceName(resourceName)] = resource.MustParse("1")
+		}
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Status: v1.NodeStatus{Capacity: capacity}}
+	}
+
+	assert.True(t, instaSliceResourceLostPredicate.Update(event.UpdateEvent{ObjectOld: node("org.instaslice/pod-0"), ObjectNew: node()}))
+	assert.False(t, instaSliceResourceLostPredicate.Update(event.UpdateEvent{ObjectOld: node(), ObjectNew: node("org.instaslice/pod-0")}))
+	assert.False(t, instaSliceResourceLostPredicate.Update(event.UpdateEvent{ObjectOld: node("org.instaslice/pod-0"), ObjectNew: node("org.instaslice/pod-0")}))
+	assert.False(t, instaSliceResourceLostPredicate.Create(event.CreateEvent{Object: node()}))
+}
+
+func TestNodeMapFuncOnlyMapsOwnNode(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-1")
+	reconciler := &InstaSliceDaemonsetReconciler{}
+
+	assert.Equal(t, types.NamespacedName{Name: "node-1", Namespace: "default"},
+		reconciler.nodeMapFunc(context.Background(), &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}})[0].NamespacedName)
+	assert.Empty(t, reconciler.nodeMapFunc(context.Background(), &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}}))
+}
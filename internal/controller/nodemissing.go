@@ -0,0 +1,108 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// ConditionNodeMissing is set on the instaslice while the node object it is named after cannot be found.
+	ConditionNodeMissing = "NodeMissing"
+	// ReasonNodeNotFound is the reason used when the node object of the instaslice is not found.
+	ReasonNodeNotFound = "NodeNotFound"
+	// nodeMissingRequeueInterval is how often the node object is looked for again while it is missing.
+	nodeMissingRequeueInterval = 30 * time.Second
+)
+
+// setNodeMissingCondition marks the status while the node is missing, or clears the condition once it is back.
+// It reports whether the condition changed.
+func setNodeMissingCondition(status *inferencev1alpha1.InstasliceStatus, nodeName string, missing bool) bool {
+	if !missing {
+		return meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ConditionNodeMissing,
+			Status:  metav1.ConditionFalse,
+			Reason:  "NodeFound",
+			Message: "node " + nodeName + " exists",
+		})
+	}
+	return meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    ConditionNodeMissing,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonNodeNotFound,
+		Message: "node " + nodeName + " not found, capacity and labels are not updated until it is back",
+	})
+}
+
+// nodeExists reports whether the node object is there. The cache may not have seen a node just created, a node
+// it misses is looked for on the API server before being reported missing.
+func (r *InstaSliceDaemonsetReconciler) nodeExists(ctx context.Context, nodeName string) (bool, error) {
+	err := r.Get(ctx, types.NamespacedName{Name: nodeName}, &v1.Node{})
+	if apierrors.IsNotFound(err) {
+		_, err = getFreshNode(ctx, r.apiReader(), nodeName)
+	}
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkNodeExists reports whether the node object of the instaslice exists and records the NodeMissing condition
+// on the instaslice accordingly, it also reports whether the instaslice was updated. Capacity patches and label
+// updates would otherwise be made against a node that is not there, the reconcile waits for it instead.
+func (r *InstaSliceDaemonsetReconciler) checkNodeExists(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) (bool, bool, error) {
+	exists, err := r.nodeExists(ctx, instaslice.Name)
+	if err != nil {
+		return false, false, err
+	}
+	if !exists {
+		log.FromContext(ctx).Info("node object not found, waiting for it", "node", instaslice.Name)
+	}
+	if exists && !meta.IsStatusConditionTrue(instaslice.Status.Conditions, ConditionNodeMissing) {
+		return true, false, nil
+	}
+	updated := false
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var latest inferencev1alpha1.Instaslice
+		if err := r.Get(ctx, types.NamespacedName{Name: instaslice.Name, Namespace: instaslice.Namespace}, &latest); err != nil {
+			return err
+		}
+		if !setNodeMissingCondition(&latest.Status, instaslice.Name, !exists) {
+			return nil
+		}
+		if err := r.Status().Update(ctx, &latest); err != nil {
+			return err
+		}
+		updated = true
+		return nil
+	})
+	return exists, updated, err
+}
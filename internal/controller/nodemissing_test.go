@@ -0,0 +1,60 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestReconcileWaitsForMissingNode(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	require.NoError(t, fakeClient.Delete(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}))
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, nodeMissingRequeueInterval, result.RequeueAfter)
+	instaslice := latestTestInstaslice(t, fakeClient)
+	assert.True(t, meta.IsStatusConditionTrue(instaslice.Status.Conditions, ConditionNodeMissing))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus, "no slice is carved for a missing node")
+	assert.Empty(t, instaslice.Status.Prepared)
+
+	// the node is back, the condition is cleared and the slice carved
+	require.NoError(t, fakeClient.Create(ctx, &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}},
+	}))
+	result, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.True(t, result.Requeue)
+	assert.True(t, meta.IsStatusConditionFalse(latestTestInstaslice(t, fakeClient).Status.Conditions, ConditionNodeMissing))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	instaslice = latestTestInstaslice(t, fakeClient)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Nodes are read through the cached client of the manager, which is kept up to date by the node watch.
+// The API server is only asked when the cached copy proved stale, e.g. a patch or update based on it failed.
+
+// getFreshNode reads the node from the API server, bypassing the cache.
+func getFreshNode(ctx context.Context, reader client.Reader, nodeName string) (*v1.Node, error) {
+	nodeAPIReads.Inc()
+	node := &v1.Node{}
+	if err := reader.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// apiReader returns the reader of fresh nodes, the cached client when none was set.
+func (r *InstaSliceDaemonsetReconciler) apiReader() client.Reader {
+	if r.APIReader == nil {
+		return r.Client
+	}
+	return r.APIReader
+}
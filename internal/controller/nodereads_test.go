@@ -0,0 +1,155 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// countingReader counts the nodes read through it.
+type countingReader struct {
+	client.Reader
+	nodeGets int
+}
+
+func (c *countingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if _, ok := obj.(*v1.Node); ok {
+		c.nodeGets++
+	}
+	return c.Reader.Get(ctx, key, obj, opts...)
+}
+
+func nodeAPIReadsValue(t *testing.T) float64 {
+	var m dto.Metric
+	require.NoError(t, nodeAPIReads.Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+// staleNodeCache returns a client standing for the cache of the manager on top of the API server, it still
+// serves stale for the reads of its node.
+func staleNodeCache(apiServer client.WithWatch, stale *v1.Node) client.Client {
+	return interceptor.NewClient(apiServer, interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if cachedNode, ok := obj.(*v1.Node); ok && key.Name == stale.Name {
+				stale.DeepCopyInto(cachedNode)
+				return nil
+			}
+			return c.Get(ctx, key, obj, opts...)
+		},
+	})
+}
+
+func TestReconcileReadsNodeFromCacheOnly(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0, 1)
+	reader := &countingReader{Reader: fakeClient}
+	reconciler.APIReader = reader
+	before := nodeAPIReadsValue(t)
+
+	_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "node-1", Namespace: "default"}})
+	require.NoError(t, err)
+
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1"}, &node))
+	assert.Contains(t, node.Status.Capacity, v1.ResourceName("org.instaslice/pod-0"))
+	assert.Zero(t, reader.nodeGets)
+	assert.Equal(t, before, nodeAPIReadsValue(t))
+}
+
+func TestLabelToggleReloaderRereadsStaleNode(t *testing.T) {
+	ctx := context.Background()
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "node-1",
+		Labels: map[string]string{"nvidia.com/device-plugin.config": "update-capacity"},
+	}}
+	apiServer := runtimefake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(node).Build()
+	var stale v1.Node
+	require.NoError(t, apiServer.Get(ctx, types.NamespacedName{Name: "node-1"}, &stale))
+	// the label was already toggled by a reload the cache has not seen yet
+	current := stale.DeepCopy()
+	current.Labels["nvidia.com/device-plugin.config"] = "update-capacity-1"
+	require.NoError(t, apiServer.Update(ctx, current))
+	reader := &countingReader{Reader: apiServer}
+	before := nodeAPIReadsValue(t)
+
+	reloader := &LabelToggleReloader{Client: staleNodeCache(apiServer, &stale), APIReader: reader}
+	require.NoError(t, reloader.Reload(ctx, "node-1"))
+
+	var after v1.Node
+	require.NoError(t, apiServer.Get(ctx, types.NamespacedName{Name: "node-1"}, &after))
+	assert.Equal(t, "update-capacity", after.Labels["nvidia.com/device-plugin.config"])
+	assert.Equal(t, 1, reader.nodeGets)
+	assert.Equal(t, before+1, nodeAPIReadsValue(t))
+}
+
+func TestLabelToggleReloaderUsesCachedNodeWhenCurrent(t *testing.T) {
+	fakeClient := newReloadTestClient()
+	reader := &countingReader{Reader: fakeClient}
+	reloader := &LabelToggleReloader{Client: fakeClient, APIReader: reader}
+
+	require.NoError(t, reloader.Reload(context.Background(), "node-1"))
+	require.NoError(t, reloader.Reload(context.Background(), "node-1"))
+
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1"}, &node))
+	assert.Equal(t, "update-capacity", node.Labels["nvidia.com/device-plugin.config"])
+	assert.Zero(t, reader.nodeGets)
+}
+
+func TestCleanUpInstaSliceResourceChecksStaleNode(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-1")
+	ctx := context.Background()
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}},
+	}
+	// the cache still lists the resource of pod-0, which the API server already removed
+	stale := node.DeepCopy()
+	stale.Status.Capacity["org.instaslice/pod-0"] = resource.MustParse("1")
+	apiServer := runtimefake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(node).
+		WithStatusSubresource(&v1.Node{}).Build()
+	reader := &countingReader{Reader: apiServer}
+	reconciler := &InstaSliceDaemonsetReconciler{Client: staleNodeCache(apiServer, stale), APIReader: reader}
+
+	require.NoError(t, reconciler.cleanUpInstaSliceResource(ctx, inferencev1alpha1.AllocationDetails{PodName: "pod-0"}))
+	assert.Equal(t, 1, reader.nodeGets)
+
+	// a resource still advertised is removed without asking the API server
+	stale.Status.Capacity["org.instaslice/pod-1"] = resource.MustParse("1")
+	node.Status.Capacity["org.instaslice/pod-1"] = resource.MustParse("1")
+	require.NoError(t, apiServer.Status().Update(ctx, node))
+	require.NoError(t, reconciler.cleanUpInstaSliceResource(ctx, inferencev1alpha1.AllocationDetails{PodName: "pod-1"}))
+	assert.Equal(t, 1, reader.nodeGets)
+	var after v1.Node
+	require.NoError(t, apiServer.Get(ctx, types.NamespacedName{Name: "node-1"}, &after))
+	assert.NotContains(t, after.Status.Capacity, v1.ResourceName("org.instaslice/pod-1"))
+}
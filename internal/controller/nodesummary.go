@@ -0,0 +1,48 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// nodeSummary returns the one-line account of the node shown by kubectl get instaslice: the slots used out of the
+// total, the pods waiting for their slice to be carved, the driver version and the GPUs with MIG mode enabled.
+// The slot counts are the ones of the status, they are expected to be refreshed first.
+func nodeSummary(instaslice *inferencev1alpha1.Instaslice) string {
+	pending := make(map[string]bool)
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreating {
+			pending[allocation.PodUUID] = true
+		}
+	}
+	driverVersion := instaslice.Status.DriverVersion
+	if driverVersion == "" {
+		driverVersion = "unknown"
+	}
+	migEnabled := 0
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		if instaslice.Status.MigEnabled[gpuUUID] {
+			migEnabled++
+		}
+	}
+	return fmt.Sprintf("%d/%d slices used, %d pods pending, driver %s, MIG enabled on %d/%d GPUs",
+		instaslice.Status.UsedSlices, instaslice.Status.TotalSlices, len(pending), driverVersion,
+		migEnabled, len(instaslice.Spec.MigGPUUUID))
+}
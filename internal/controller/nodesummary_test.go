@@ -0,0 +1,64 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestNodeSummary(t *testing.T) {
+	instaslice := &inferencev1alpha1.Instaslice{
+		Spec: inferencev1alpha1.InstasliceSpec{
+			MigGPUUUID: map[string]string{"GPU-1": "NVIDIA A100-PCIE-40GB", "GPU-2": "NVIDIA A100-PCIE-40GB"},
+			Allocations: map[string]inferencev1alpha1.AllocationDetails{
+				"pod-uid-1": {PodUUID: "pod-uid-1", Allocationstatus: inferencev1alpha1.AllocationStatusCreated},
+				"pod-uid-2": {PodUUID: "pod-uid-2", Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+				"pod-uid-3": {PodUUID: "pod-uid-3", Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+			},
+		},
+		Status: inferencev1alpha1.InstasliceStatus{
+			TotalSlices:   14,
+			UsedSlices:    4,
+			DriverVersion: "535.104.05",
+			MigEnabled:    map[string]bool{"GPU-1": true, "GPU-2": false},
+		},
+	}
+	assert.Equal(t, "4/14 slices used, 2 pods pending, driver 535.104.05, MIG enabled on 1/2 GPUs", nodeSummary(instaslice))
+
+	instaslice.Status.DriverVersion = ""
+	assert.Contains(t, nodeSummary(instaslice), "driver unknown")
+}
+
+func TestReconcileMaintainsTheNodeSummary(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, "550.54.15", instaslice.Status.DriverVersion)
+	assert.Equal(t, "2/7 slices used, 0 pods pending, driver 550.54.15, MIG enabled on 1/1 GPUs", instaslice.Status.Summary)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// ReasonNoGPUsDiscovered is the degraded reason used when discovery found no GPU on the node.
+const ReasonNoGPUsDiscovered = "NoGPUsDiscovered"
+
+// noGPUsRetryInterval is how long discovery waits before looking for GPUs again when it found none.
+var noGPUsRetryInterval = 30 * time.Second
+
+// errNoGPUsDiscovered is returned by discovery when NVML reports no GPU on the node.
+var errNoGPUsDiscovered = errors.New("no GPU discovered on the node")
+
+// isNoGPUsDiscovered reports whether discovery failed because the node has no GPU.
+func isNoGPUsDiscovered(err error) bool {
+	return errors.Is(err, errNoGPUsDiscovered)
+}
+
+// markNoGPUsDiscovered records on the instaslice of the node, creating it without any capacity if needed, that
+// discovery found no GPU. The instaslice is left unprocessed so that discovery runs again once GPUs show up.
+func (r *InstaSliceDaemonsetReconciler) markNoGPUsDiscovered(ctx context.Context, nodeName string) error {
+	if err := r.markDegraded(ctx, nodeName, ReasonNoGPUsDiscovered,
+		"NVML reports no GPU on the node, check that the GPUs and their driver are available"); err != nil {
+		return err
+	}
+	return errNoGPUsDiscovered
+}
+
+// markDegraded sets the degraded condition on the instaslice of the node, creating it without any capacity if
+// needed, for the given reason.
+func (r *InstaSliceDaemonsetReconciler) markDegraded(ctx context.Context, nodeName, reason, message string) error {
+	var instaslice inferencev1alpha1.Instaslice
+	typeNamespacedName := types.NamespacedName{
+		Name:      nodeName,
+		Namespace: r.instasliceNamespace(),
+	}
+	err := r.Get(ctx, typeNamespacedName, &instaslice)
+	if apierrors.IsNotFound(err) {
+		instaslice = inferencev1alpha1.Instaslice{
+			ObjectMeta: metav1.ObjectMeta{Name: nodeName, Namespace: r.instasliceNamespace()},
+		}
+		err = r.Create(ctx, &instaslice)
+		// another daemonset instance created it first
+		if apierrors.IsAlreadyExists(err) {
+			err = r.Get(ctx, typeNamespacedName, &instaslice)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	meta.SetStatusCondition(&instaslice.Status.Conditions, metav1.Condition{
+		Type:    ConditionDegraded,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+	return r.Status().Update(ctx, &instaslice)
+}
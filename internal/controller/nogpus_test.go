@@ -0,0 +1,72 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestDiscoveryWithoutGPUsIsDegraded(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-1")
+	server := newFakeGPUs(1).(*dgxa100.Server)
+	count := 0
+	server.DeviceGetCountFunc = func() (int, nvml.Return) {
+		return count, nvml.SUCCESS
+	}
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s, nvmlHandler: newDeviceHandler(server)}
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	_, err := reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	assert.True(t, isNoGPUsDiscovered(err))
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.NotEqual(t, "true", instaslice.Status.Processed)
+	assert.Empty(t, instaslice.Spec.MigGPUUUID)
+	assert.Empty(t, instaslice.Spec.Migplacement)
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionDegraded)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, ReasonNoGPUsDiscovered, condition.Reason)
+
+	// the GPU shows up, discovering it again fills in the instaslice
+	count = 1
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+	instaslice = inferencev1alpha1.Instaslice{}
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, "true", instaslice.Status.Processed)
+	assert.Len(t, instaslice.Spec.MigGPUUUID, 1)
+	assert.NotEmpty(t, instaslice.Spec.Migplacement)
+	assert.False(t, meta.IsStatusConditionTrue(instaslice.Status.Conditions, ConditionDegraded))
+}
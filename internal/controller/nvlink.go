@@ -0,0 +1,172 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	v1 "k8s.io/api/core/v1"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// SliceGroupAnnotation groups the pods of a job spanning several GPUs. Slices of pods of the same group and
+// namespace are placed on GPUs linked by NVLink to the GPUs already holding slices of the group when possible.
+const SliceGroupAnnotation = "org.instaslice/slice-group"
+
+// sliceGroupFor returns the group of the pod, or an empty string when it is not part of one.
+func sliceGroupFor(pod *v1.Pod) string {
+	if pod == nil {
+		return ""
+	}
+	return pod.Annotations[SliceGroupAnnotation]
+}
+
+// sliceGroupGPUs returns the GPUs of the node holding a slice of another pod of the group of the allocation.
+func sliceGroupGPUs(instaslice *inferencev1alpha1.Instaslice, namespace, group, podUUID string) map[string]bool {
+	gpus := make(map[string]bool)
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.SliceGroup != group || allocation.Namespace != namespace || allocation.PodUUID == podUUID {
+			continue
+		}
+		switch allocation.Allocationstatus {
+		case inferencev1alpha1.AllocationStatusDeleting, inferencev1alpha1.AllocationStatusPreempted, inferencev1alpha1.AllocationStatusFailed, inferencev1alpha1.AllocationStatusRetained:
+			continue
+		}
+		gpus[allocation.GPUUUID] = true
+	}
+	return gpus
+}
+
+// gpusByNVLinkAffinity returns the GPUs of the node in the order slices of the pod are tried on them. Pods outside
+// a group take the GPUs in UUID order, so that the same state always gives the same placement. For a pod of a group, GPUs linked to more GPUs holding slices of the group
+// come first, or, before any slice of the group is placed, GPUs with more NVLink peers, so that the next slices of
+// the group find a linked GPU. GPUs already holding a slice of the group come last, the group spans several GPUs.
+func gpusByNVLinkAffinity(instaslice *inferencev1alpha1.Instaslice, pod *v1.Pod) []string {
+	gpus := make([]string, 0, len(instaslice.Spec.MigGPUUUID))
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		gpus = append(gpus, gpuUUID)
+	}
+	group := sliceGroupFor(pod)
+	if group == "" {
+		sort.Strings(gpus)
+		return gpus
+	}
+	members := sliceGroupGPUs(instaslice, pod.Namespace, group, string(pod.UID))
+	score := func(gpuUUID string) int {
+		peers := instaslice.Status.NVLinkPeers[gpuUUID]
+		if len(members) == 0 {
+			return len(peers)
+		}
+		linked := 0
+		for _, peer := range peers {
+			if members[peer] {
+				linked++
+			}
+		}
+		return linked
+	}
+	sort.Slice(gpus, func(i, j int) bool {
+		if members[gpus[i]] != members[gpus[j]] {
+			return !members[gpus[i]]
+		}
+		if left, right := score(gpus[i]), score(gpus[j]); left != right {
+			return left > right
+		}
+		return gpus[i] < gpus[j]
+	})
+	return gpus
+}
+
+// discoverNVLinkPeers returns, per GPU, the GPUs of the node it has an active NVLink to. Links to NVSwitches
+// and GPUs without NVLink support are left out.
+func (r *InstaSliceDaemonsetReconciler) discoverNVLinkPeers() (map[string][]string, error) {
+	nvmllib := r.handler().nvml
+	count, ret := nvmllib.DeviceGetCount()
+	if ret != nvml.SUCCESS {
+		return nil, ret
+	}
+	devices := make(map[string]nvml.Device, count)
+	gpusByBusID := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		if r.undiscoveredGPUs[i] {
+			continue
+		}
+		device, ret := nvmllib.DeviceGetHandleByIndex(i)
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		uuid, ret := device.GetUUID()
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		pciInfo, ret := device.GetPciInfo()
+		if ret != nvml.SUCCESS {
+			return nil, fmt.Errorf("unable to get the PCI info of GPU %s: %v", uuid, ret)
+		}
+		devices[uuid] = device
+		gpusByBusID[pciBusID(pciInfo)] = uuid
+	}
+
+	var peers map[string][]string
+	for uuid, device := range devices {
+		linked := make(map[string]bool)
+		for link := 0; link < nvml.NVLINK_MAX_LINKS; link++ {
+			state, ret := device.GetNvLinkState(link)
+			if ret == nvml.ERROR_NOT_SUPPORTED {
+				break
+			}
+			// links past the ones of the GPU are reported as invalid
+			if ret != nvml.SUCCESS || state != nvml.FEATURE_ENABLED {
+				continue
+			}
+			remote, ret := device.GetNvLinkRemotePciInfo(link)
+			if ret != nvml.SUCCESS {
+				continue
+			}
+			if peer, isGPU := gpusByBusID[pciBusID(remote)]; isGPU && peer != uuid {
+				linked[peer] = true
+			}
+		}
+		if len(linked) == 0 {
+			continue
+		}
+		if peers == nil {
+			peers = make(map[string][]string)
+		}
+		for peer := range linked {
+			peers[uuid] = append(peers[uuid], peer)
+		}
+		sort.Strings(peers[uuid])
+	}
+	return peers, nil
+}
+
+// pciBusID returns the PCI bus id of the device in lower case.
+func pciBusID(pciInfo nvml.PciInfo) string {
+	var busID strings.Builder
+	for _, c := range pciInfo.BusId {
+		if c == 0 {
+			break
+		}
+		busID.WriteByte(byte(c))
+	}
+	return strings.ToLower(busID.String())
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newNVLinkTestInstaslice returns a node with three empty GPUs, GPU-2 and GPU-3 being linked by NVLink.
+func newNVLinkTestInstaslice() *inferencev1alpha1.Instaslice {
+	instaslice := newPlacementTestInstaslice()
+	instaslice.Status.Prepared = map[string]inferencev1alpha1.PreparedDetails{}
+	instaslice.Spec.MigGPUUUID["GPU-2"] = "NVIDIA A100-SXM4-40GB"
+	instaslice.Spec.MigGPUUUID["GPU-3"] = "NVIDIA A100-SXM4-40GB"
+	instaslice.Status.NVLinkPeers = map[string][]string{"GPU-2": {"GPU-3"}, "GPU-3": {"GPU-2"}}
+	return instaslice
+}
+
+func newSliceGroupTestPod(name, group string) *v1.Pod {
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   "default",
+		UID:         types.UID(name + "-uid"),
+		Annotations: map[string]string{SliceGroupAnnotation: group},
+	}}
+}
+
+func TestSliceGroupPrefersNVLinkedPair(t *testing.T) {
+	r := &InstasliceReconciler{}
+	instaslice := newNVLinkTestInstaslice()
+
+	first, err := r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, newSliceGroupTestPod("pod-1", "job"))
+	require.NoError(t, err)
+	assert.Equal(t, "job", first.SliceGroup)
+	assert.Contains(t, []string{"GPU-2", "GPU-3"}, first.GPUUUID)
+	instaslice.Spec.Allocations[first.PodUUID] = *first
+
+	second, err := r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, newSliceGroupTestPod("pod-2", "job"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"GPU-2", "GPU-3"}, []string{first.GPUUUID, second.GPUUUID})
+}
+
+func TestSliceGroupFallsBackWithoutLinkedRoom(t *testing.T) {
+	r := &InstasliceReconciler{}
+	instaslice := newNVLinkTestInstaslice()
+	instaslice.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{
+		"pod-1-uid": {PodUUID: "pod-1-uid", Namespace: "default", SliceGroup: "job", GPUUUID: "GPU-2", Allocationstatus: inferencev1alpha1.AllocationStatusCreated, Profile: "1g.5gb", Start: 0, Size: 1},
+	}
+	instaslice.Spec.CordonedGPUs = []string{"GPU-3"}
+
+	allocation, err := r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, newSliceGroupTestPod("pod-2", "job"))
+	require.NoError(t, err)
+	assert.Equal(t, "GPU-1", allocation.GPUUUID)
+}
+
+func TestDiscoveryRecordsNVLinkPeers(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-1")
+	nvmllib := newFakeGPUs(3)
+	server := nvmllib.(*dgxa100.Server)
+	busIDs := []string{"00000000:07:00.0", "00000000:0F:00.0", "00000000:47:00.0"}
+	for i, device := range server.Devices[:3] {
+		i, device := i, device.(*dgxa100.Device)
+		device.GetPciInfoFunc = func() (nvml.PciInfo, nvml.Return) {
+			var pciInfo nvml.PciInfo
+			for j, c := range busIDs[i] {
+				pciInfo.BusId[j] = int8(c)
+			}
+			return pciInfo, nvml.SUCCESS
+		}
+		// GPU 0 and 1 are linked by two links, GPU 2 has none
+		device.GetNvLinkStateFunc = func(link int) (nvml.EnableState, nvml.Return) {
+			if i == 2 {
+				return nvml.FEATURE_DISABLED, nvml.ERROR_NOT_SUPPORTED
+			}
+			if link >= 2 {
+				return nvml.FEATURE_DISABLED, nvml.ERROR_INVALID_ARGUMENT
+			}
+			return nvml.FEATURE_ENABLED, nvml.SUCCESS
+		}
+		if i < 2 {
+			peer := server.Devices[1-i].(*dgxa100.Device)
+			device.GetNvLinkRemotePciInfoFunc = func(link int) (nvml.PciInfo, nvml.Return) {
+				return peer.GetPciInfoFunc()
+			}
+		}
+	}
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s, nvmlHandler: newDeviceHandler(nvmllib)}
+	ctx := context.Background()
+
+	_, err := reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	gpu0 := server.Devices[0].(*dgxa100.Device).UUID
+	gpu1 := server.Devices[1].(*dgxa100.Device).UUID
+	assert.Equal(t, map[string][]string{gpu0: {gpu1}, gpu1: {gpu0}}, instaslice.Status.NVLinkPeers)
+}
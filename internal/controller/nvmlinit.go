@@ -0,0 +1,119 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// ReasonNVMLNotReady is the degraded reason used while NVML cannot be initialized, e.g. before the driver is
+// loaded at boot.
+const ReasonNVMLNotReady = "NVMLNotReady"
+
+// nvmlInitBackoff spaces the attempts to initialize NVML on startup, the last step is repeated until it succeeds.
+var nvmlInitBackoff = wait.Backoff{Duration: 1 * time.Second, Factor: 2, Jitter: 0.1, Steps: 8, Cap: 2 * time.Minute}
+
+// nvmlNotReadyRequeueInterval is how long a reconcile waits for NVML to become ready before looking again.
+var nvmlNotReadyRequeueInterval = 10 * time.Second
+
+// waitForNVML initializes NVML with backoff until it succeeds or ctx is done. The instaslice of the node is
+// marked degraded with the NVML error while it fails, and the condition is cleared once NVML is ready.
+// Reconciles are held back meanwhile rather than failing every NVML call. The session it opens is the one every
+// NVML call of the daemonset is made in, it is held until shutdownNVML closes it.
+func (r *InstaSliceDaemonsetReconciler) waitForNVML(ctx context.Context, nodeName string) error {
+	backoff := nvmlInitBackoff
+	lastErr := ""
+	for {
+		ret := r.handler().nvml.Init()
+		if ret == nvml.SUCCESS {
+			r.nvmlInitialized.Store(true)
+			return r.markNVMLReady(ctx, nodeName)
+		}
+		r.nvmlNotReady.Store(true)
+		// the condition is only written again when the error changes
+		message := fmt.Sprintf("NVML cannot be initialized: %v", ret)
+		if message != lastErr {
+			if err := r.markDegraded(ctx, nodeName, ReasonNVMLNotReady, message); err != nil {
+				log.FromContext(ctx).Error(err, "unable to mark the instaslice degraded")
+			} else {
+				lastErr = message
+			}
+		}
+		// the last step of the backoff is repeated until NVML comes up
+		delay := backoff.Duration
+		if backoff.Steps > 1 {
+			delay = backoff.Step()
+		}
+		log.FromContext(ctx).Info("NVML is not ready, retrying", "error", ret.Error(), "after", delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// markNVMLReady lets reconciles through again and clears the degraded condition of the instaslice of the node
+// when NVML was the reason, leaving a condition set for another reason alone.
+func (r *InstaSliceDaemonsetReconciler) markNVMLReady(ctx context.Context, nodeName string) error {
+	r.nvmlNotReady.Store(false)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var latest inferencev1alpha1.Instaslice
+		err := r.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: r.instasliceNamespace()}, &latest)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		condition := meta.FindStatusCondition(latest.Status.Conditions, ConditionDegraded)
+		if condition == nil || condition.Reason != ReasonNVMLNotReady {
+			return nil
+		}
+		meta.SetStatusCondition(&latest.Status.Conditions, metav1.Condition{
+			Type:    ConditionDegraded,
+			Status:  metav1.ConditionFalse,
+			Reason:  "NVMLReady",
+			Message: "NVML is initialized",
+		})
+		log.FromContext(ctx).Info("NVML is ready", "node", nodeName)
+		return r.Status().Update(ctx, &latest)
+	})
+}
+
+// shutdownNVML closes the NVML session held by the daemonset, if any.
+func (r *InstaSliceDaemonsetReconciler) shutdownNVML(ctx context.Context) {
+	if !r.nvmlInitialized.Swap(false) {
+		return
+	}
+	if ret := r.handler().nvml.Shutdown(); ret != nvml.SUCCESS {
+		log.FromContext(ctx).Error(ret, "error to perform nvml.Shutdown")
+	}
+}
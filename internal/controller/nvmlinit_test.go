@@ -0,0 +1,133 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestWaitForNVMLClearsDegradedOnceReady(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-1")
+	backoff := nvmlInitBackoff
+	nvmlInitBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 1}
+	defer func() { nvmlInitBackoff = backoff }()
+
+	server := newFakeGPUs(1).(*dgxa100.Server)
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s, nvmlHandler: newDeviceHandler(server)}
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	// the driver is not loaded for the first attempts, the degraded condition is checked in between
+	attempts := 0
+	var whileFailing *metav1.Condition
+	server.InitFunc = func() nvml.Return {
+		attempts++
+		if attempts < 3 {
+			return nvml.ERROR_DRIVER_NOT_LOADED
+		}
+		var instaslice inferencev1alpha1.Instaslice
+		require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+		whileFailing = meta.FindStatusCondition(instaslice.Status.Conditions, ConditionDegraded)
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+		require.NoError(t, err)
+		assert.Equal(t, nvmlNotReadyRequeueInterval, result.RequeueAfter)
+		return nvml.SUCCESS
+	}
+
+	require.NoError(t, reconciler.waitForNVML(ctx, "node-1"))
+	assert.Equal(t, 3, attempts)
+	require.NotNil(t, whileFailing)
+	assert.Equal(t, metav1.ConditionTrue, whileFailing.Status)
+	assert.Equal(t, ReasonNVMLNotReady, whileFailing.Reason)
+	assert.Contains(t, whileFailing.Message, nvml.ERROR_DRIVER_NOT_LOADED.Error())
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.False(t, meta.IsStatusConditionTrue(instaslice.Status.Conditions, ConditionDegraded))
+	assert.False(t, reconciler.nvmlNotReady.Load())
+}
+
+func TestNVMLReadyKeepsOtherDegradedReasons(t *testing.T) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	instaslice := &inferencev1alpha1.Instaslice{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"}}
+	meta.SetStatusCondition(&instaslice.Status.Conditions, metav1.Condition{
+		Type: ConditionDegraded, Status: metav1.ConditionTrue, Reason: ReasonDuplicateMigUUID, Message: "MIG-1",
+	})
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).WithObjects(instaslice).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s, nvmlHandler: newDeviceHandler(newFakeGPUs(1))}
+	ctx := context.Background()
+
+	require.NoError(t, reconciler.waitForNVML(ctx, "node-1"))
+	var latest inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &latest))
+	condition := meta.FindStatusCondition(latest.Status.Conditions, ConditionDegraded)
+	require.NotNil(t, condition)
+	assert.Equal(t, ReasonDuplicateMigUUID, condition.Reason)
+}
+
+func TestNVMLIsInitializedOnceForAllReconciles(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	server := reconciler.handler().nvml.(*dgxa100.Server)
+	inits, shutdowns := 0, 0
+	server.InitFunc = func() nvml.Return {
+		inits++
+		return nvml.SUCCESS
+	}
+	server.ShutdownFunc = func() nvml.Return {
+		shutdowns++
+		return nvml.SUCCESS
+	}
+
+	require.NoError(t, reconciler.waitForNVML(ctx, "node-1"))
+	for i := 0; i < 5; i++ {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+		require.NoError(t, err)
+	}
+	require.NoError(t, reconciler.pruneGhostPreparedSlices(ctx, server, "node-1"))
+	require.NoError(t, reconciler.cleanUp(ctx, "pod-uid-0"))
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	require.NoError(t, reconciler.discoverDanglingSlices(&instaslice))
+	assert.Len(t, device.GpuInstances, 1)
+
+	assert.Equal(t, 1, inits)
+	assert.Equal(t, 0, shutdowns)
+	reconciler.shutdownNVML(ctx)
+	reconciler.shutdownNVML(ctx)
+	assert.Equal(t, 1, shutdowns)
+}
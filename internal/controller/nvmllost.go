@@ -0,0 +1,98 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// nvmlReinitRequeueInterval is how long a reconcile aborted on an invalidated NVML handle waits before NVML is
+// initialized again.
+var nvmlReinitRequeueInterval = 1 * time.Second
+
+// nvmlHandleLostError is returned by an NVML call that failed because the handles in use went stale, e.g. the
+// GPU fell off the bus or the driver was reset in the middle of the reconcile.
+type nvmlHandleLostError struct {
+	call string
+	ret  nvml.Return
+}
+
+func (e *nvmlHandleLostError) Error() string {
+	return fmt.Sprintf("NVML handle invalidated during %s: %v", e.call, e.ret)
+}
+
+// handleInvalidated reports whether the NVML return tells that the handle the call was made with is no longer
+// usable, only a new NVML session gives usable handles again.
+func handleInvalidated(ret nvml.Return) bool {
+	switch ret {
+	case nvml.ERROR_GPU_IS_LOST, nvml.ERROR_UNINITIALIZED, nvml.ERROR_RESET_REQUIRED:
+		return true
+	}
+	return false
+}
+
+// checkNVMLHandle returns an nvmlHandleLostError when the call failed on an invalidated handle, nil otherwise.
+func checkNVMLHandle(call string, ret nvml.Return) error {
+	if handleInvalidated(ret) {
+		return &nvmlHandleLostError{call: call, ret: ret}
+	}
+	return nil
+}
+
+// isNVMLHandleLost reports whether err comes from an NVML call made on an invalidated handle.
+func isNVMLHandleLost(err error) bool {
+	var lost *nvmlHandleLostError
+	return errors.As(err, &lost)
+}
+
+// abortOnLostNVML ends a reconcile that ran into an invalidated NVML handle rather than carrying on with dead
+// handles. NVML is initialized again at the start of the next reconcile.
+func (r *InstaSliceDaemonsetReconciler) abortOnLostNVML(ctx context.Context, err error) (ctrl.Result, error) {
+	log.FromContext(ctx).Error(err, "aborting reconcile, NVML will be initialized again")
+	r.nvmlReinit.Store(true)
+	return ctrl.Result{RequeueAfter: nvmlReinitRequeueInterval}, nil
+}
+
+// reinitNVML opens a new NVML session after a reconcile was aborted on an invalidated handle. The instaslice of
+// the node is marked degraded while NVML cannot be initialized, and the condition is cleared once it can. It
+// reports whether the reconcile can go on.
+func (r *InstaSliceDaemonsetReconciler) reinitNVML(ctx context.Context, nodeName string) bool {
+	// the handles of the session held so far are stale
+	r.shutdownNVML(ctx)
+	ret := r.handler().nvml.Init()
+	if ret != nvml.SUCCESS {
+		if err := r.markDegraded(ctx, nodeName, ReasonNVMLNotReady, fmt.Sprintf("NVML cannot be initialized: %v", ret)); err != nil {
+			log.FromContext(ctx).Error(err, "unable to mark the instaslice degraded")
+		}
+		log.FromContext(ctx).Info("NVML is not ready, retrying", "error", ret.Error(), "after", nvmlNotReadyRequeueInterval)
+		return false
+	}
+	r.nvmlInitialized.Store(true)
+	r.nvmlReinit.Store(false)
+	if err := r.markNVMLReady(ctx, nodeName); err != nil {
+		log.FromContext(ctx).Error(err, "unable to clear the degraded condition of the instaslice")
+	}
+	log.FromContext(ctx).Info("NVML initialized again after an invalidated handle", "node", nodeName)
+	return true
+}
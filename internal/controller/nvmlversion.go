@@ -0,0 +1,129 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DefaultMinDriverVersion is the oldest driver supporting MIG on A100 GPUs.
+const DefaultMinDriverVersion = "450.80.02"
+
+// ReasonUnsupportedDriverVersion is the degraded reason used when the driver of the node is older than required.
+const ReasonUnsupportedDriverVersion = "UnsupportedDriverVersion"
+
+// defaultNvmlLibraryPaths are searched in order for the NVML library when no path is configured. They cover the
+// host library directories of the common distributions and the driver root of the GPU operator.
+var defaultNvmlLibraryPaths = []string{
+	"/usr/lib64/libnvidia-ml.so.1",
+	"/usr/lib/x86_64-linux-gnu/libnvidia-ml.so.1",
+	"/usr/lib/aarch64-linux-gnu/libnvidia-ml.so.1",
+	"/run/nvidia/driver/usr/lib64/libnvidia-ml.so.1",
+	"/run/nvidia/driver/usr/lib/x86_64-linux-gnu/libnvidia-ml.so.1",
+	"/run/nvidia/driver/usr/lib/aarch64-linux-gnu/libnvidia-ml.so.1",
+}
+
+// errUnsupportedDriverVersion is returned by discovery when the driver of the node is older than required.
+var errUnsupportedDriverVersion = errors.New("unsupported driver version")
+
+// isUnsupportedDriverVersion reports whether discovery failed because the driver of the node is too old.
+func isUnsupportedDriverVersion(err error) bool {
+	return errors.Is(err, errUnsupportedDriverVersion)
+}
+
+// findNvmlLibrary returns the first of the paths holding a file, or an empty path to leave the lookup of the
+// library to the dynamic loader.
+func findNvmlLibrary(paths []string) string {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// compareVersions compares two dotted driver versions like 550.54.15 component by component, a missing
+// component counts as 0. It returns -1, 0 or 1 as a is older than, the same as or newer than b.
+func compareVersions(a, b string) (int, error) {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aNum, bNum int
+		var err error
+		if i < len(aParts) {
+			if aNum, err = strconv.Atoi(aParts[i]); err != nil {
+				return 0, fmt.Errorf("invalid version %q", a)
+			}
+		}
+		if i < len(bParts) {
+			if bNum, err = strconv.Atoi(bParts[i]); err != nil {
+				return 0, fmt.Errorf("invalid version %q", b)
+			}
+		}
+		if aNum != bNum {
+			if aNum < bNum {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// checkDriverVersion verifies that the driver of the node is at least MinDriverVersion. An older driver is
+// recorded as degraded on the instaslice of the node and errUnsupportedDriverVersion is returned, rather than
+// letting MIG calls fail in obscure ways later. Nothing is checked when MinDriverVersion is unset, the driver
+// version is kept for the summary of the node either way.
+func (r *InstaSliceDaemonsetReconciler) checkDriverVersion(ctx context.Context, nodeName string) error {
+	nvmllib := r.handler().nvml
+	driverVersion, ret := nvmllib.SystemGetDriverVersion()
+	if ret == nvml.SUCCESS {
+		r.driverVersion.Store(driverVersion)
+	}
+	if r.MinDriverVersion == "" {
+		return nil
+	}
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to get the driver version: %v", ret)
+	}
+	nvmlVersion, ret := nvmllib.SystemGetNVMLVersion()
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to get the NVML version: %v", ret)
+	}
+	log.FromContext(ctx).Info("detected NVML", "driverVersion", driverVersion, "nvmlVersion", nvmlVersion)
+	cmp, err := compareVersions(driverVersion, r.MinDriverVersion)
+	if err != nil {
+		return err
+	}
+	if cmp >= 0 {
+		return nil
+	}
+	message := fmt.Sprintf("driver version %s is older than the minimum supported version %s, upgrade the driver of the node",
+		driverVersion, r.MinDriverVersion)
+	if err := r.markDegraded(ctx, nodeName, ReasonUnsupportedDriverVersion, message); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s", errUnsupportedDriverVersion, message)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"550.54.15", "450.80.02", 1},
+		{"450.80.02", "450.80.02", 0},
+		{"450.36.06", "450.80.02", -1},
+		{"470", "470.0.0", 0},
+		{"535.104.05", "535.104", 1},
+	}
+	for _, tt := range tests {
+		got, err := compareVersions(tt.a, tt.b)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got, "%s vs %s", tt.a, tt.b)
+	}
+	_, err := compareVersions("r550", "450.80.02")
+	assert.Error(t, err)
+}
+
+func TestFindNvmlLibrary(t *testing.T) {
+	dir := t.TempDir()
+	library := filepath.Join(dir, "libnvidia-ml.so.1")
+	require.NoError(t, os.WriteFile(library, nil, 0o644))
+
+	assert.Equal(t, library, findNvmlLibrary([]string{filepath.Join(dir, "missing.so"), library}))
+	// nothing found, the dynamic loader looks the library up
+	assert.Empty(t, findNvmlLibrary([]string{filepath.Join(dir, "missing.so")}))
+}
+
+func TestDiscoveryWithOldDriverIsDegraded(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-1")
+	server := newFakeGPUs(1).(*dgxa100.Server)
+	server.DriverVersion = "440.33.01"
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&inferencev1alpha1.Instaslice{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:           fakeClient,
+		Scheme:           s,
+		MinDriverVersion: DefaultMinDriverVersion,
+		nvmlHandler:      newDeviceHandler(server),
+	}
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	_, err := reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	assert.True(t, isUnsupportedDriverVersion(err))
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.NotEqual(t, "true", instaslice.Status.Processed)
+	assert.Empty(t, instaslice.Spec.MigGPUUUID)
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionDegraded)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, ReasonUnsupportedDriverVersion, condition.Reason)
+	assert.Contains(t, condition.Message, "440.33.01")
+
+	// once the driver is upgraded, discovery goes through
+	server.DriverVersion = "550.54.15"
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+	instaslice = inferencev1alpha1.Instaslice{}
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, "true", instaslice.Status.Processed)
+	assert.False(t, meta.IsStatusConditionTrue(instaslice.Status.Conditions, ConditionDegraded))
+}
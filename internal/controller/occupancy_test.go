@@ -0,0 +1,95 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestOccupiedRangesFollowCarvedSlices(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 2)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	// turn the allocation of pod-0 into a 2g slice at offset 2
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	for _, mig := range instaslice.Spec.Migplacement {
+		if mig.Profile == "2g.10gb" {
+			allocation.Profile = mig.Profile
+			allocation.Giprofileid = mig.Giprofileid
+			allocation.CIProfileID = mig.CIProfileID
+			allocation.CIEngProfileID = mig.CIEngProfileID
+			allocation.Size = uint32(mig.Placements[0].Size)
+		}
+	}
+	require.Equal(t, "2g.10gb", allocation.Profile)
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	require.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	gpuUUID := allocation.GPUUUID
+	assert.Equal(t, []inferencev1alpha1.SliceRange{{Start: 2, Size: 2}}, instaslice.Status.OccupiedRanges[gpuUUID])
+	for otherGPU, ranges := range instaslice.Status.OccupiedRanges {
+		if otherGPU != gpuUUID {
+			assert.Empty(t, ranges)
+		}
+	}
+
+	// the range is freed with the slice
+	allocation = instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Contains(t, instaslice.Status.OccupiedRanges, gpuUUID)
+	assert.Empty(t, instaslice.Status.OccupiedRanges[gpuUUID])
+}
+
+func TestOccupiedRangesMergeAdjacentSlices(t *testing.T) {
+	instaslice := &inferencev1alpha1.Instaslice{
+		Spec: inferencev1alpha1.InstasliceSpec{
+			MigGPUUUID: map[string]string{"GPU-1": "NVIDIA A100-PCIE-40GB"},
+			Allocations: map[string]inferencev1alpha1.AllocationDetails{
+				"pod-uid-3": {PodUUID: "pod-uid-3", GPUUUID: "GPU-1", Start: 4, Size: 4, Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+				"pod-uid-4": {PodUUID: "pod-uid-4", GPUUUID: "GPU-1", Start: 2, Size: 1, Allocationstatus: inferencev1alpha1.AllocationStatusFailed},
+			},
+		},
+		Status: inferencev1alpha1.InstasliceStatus{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{
+				"MIG-1": {Parent: "GPU-1", Start: 0, Size: 1},
+				"MIG-2": {Parent: "GPU-1", Start: 1, Size: 1},
+			},
+		},
+	}
+	assert.Equal(t, map[string][]inferencev1alpha1.SliceRange{
+		"GPU-1": {{Start: 0, Size: 2}, {Start: 4, Size: 4}},
+	}, occupiedRanges(instaslice))
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	// DefaultOperatorNamespace is the namespace of the operator when none is given nor found.
+	DefaultOperatorNamespace = "default"
+	// OperatorNamespaceEnv is the environment variable naming the namespace of the operator.
+	OperatorNamespaceEnv = "INSTASLICE_NAMESPACE"
+	// PodNamespaceEnv is the environment variable the downward API may set to the namespace of the pod.
+	PodNamespaceEnv = "POD_NAMESPACE"
+)
+
+// serviceAccountNamespaceFile holds the namespace of the pod the operator runs in, mounted with its service account.
+var serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// OperatorNamespaceFromEnvironment returns the namespace the operator keeps the instaslices of the nodes in:
+// INSTASLICE_NAMESPACE when set, else the namespace of the pod it runs in, from POD_NAMESPACE or its service account,
+// DefaultOperatorNamespace outside a pod.
+func OperatorNamespaceFromEnvironment() string {
+	for _, env := range []string{OperatorNamespaceEnv, PodNamespaceEnv} {
+		if namespace := strings.TrimSpace(os.Getenv(env)); namespace != "" {
+			return namespace
+		}
+	}
+	if content, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if namespace := strings.TrimSpace(string(content)); namespace != "" {
+			return namespace
+		}
+	}
+	return DefaultOperatorNamespace
+}
+
+// operatorNamespace returns the namespace, DefaultOperatorNamespace when empty.
+func operatorNamespace(namespace string) string {
+	if namespace == "" {
+		return DefaultOperatorNamespace
+	}
+	return namespace
+}
+
+// instasliceNamespace returns the namespace the instaslice of the node is kept in.
+func (r *InstaSliceDaemonsetReconciler) instasliceNamespace() string {
+	return operatorNamespace(r.OperatorNamespace)
+}
+
+// instasliceNamespace returns the namespace the instaslices of the nodes are kept in.
+func (r *InstasliceReconciler) instasliceNamespace() string {
+	return operatorNamespace(r.OperatorNamespace)
+}
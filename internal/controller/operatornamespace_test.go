@@ -0,0 +1,134 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestOperatorNamespaceFromEnvironment(t *testing.T) {
+	previous := serviceAccountNamespaceFile
+	defer func() { serviceAccountNamespaceFile = previous }()
+	serviceAccountNamespaceFile = filepath.Join(t.TempDir(), "namespace")
+	t.Setenv(OperatorNamespaceEnv, "")
+	t.Setenv(PodNamespaceEnv, "")
+
+	assert.Equal(t, DefaultOperatorNamespace, OperatorNamespaceFromEnvironment())
+
+	require.NoError(t, os.WriteFile(serviceAccountNamespaceFile, []byte("from-service-account\n"), 0o600))
+	assert.Equal(t, "from-service-account", OperatorNamespaceFromEnvironment())
+
+	t.Setenv(PodNamespaceEnv, "from-downward-api")
+	assert.Equal(t, "from-downward-api", OperatorNamespaceFromEnvironment())
+
+	t.Setenv(OperatorNamespaceEnv, "instaslice-system")
+	assert.Equal(t, "instaslice-system", OperatorNamespaceFromEnvironment())
+}
+
+func TestDaemonsetTargetsOperatorNamespace(t *testing.T) {
+	t.Setenv(FakeGPUEnv, "1")
+	t.Setenv("NODE_NAME", "node-1")
+	t.Setenv(OperatorNamespaceEnv, "instaslice-system")
+	nvmllib, err := newNvmlLib(nil)
+	require.NoError(t, err)
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}},
+	}
+	// the namespaces the instaslice is read and written in
+	namespaces := make(map[string]bool)
+	record := func(obj client.Object, namespace string) {
+		if _, ok := obj.(*inferencev1alpha1.Instaslice); ok {
+			namespaces[namespace] = true
+		}
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(node).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}, &v1.Node{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				record(obj, key.Namespace)
+				return c.Get(ctx, key, obj, opts...)
+			},
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				record(obj, obj.GetNamespace())
+				return c.Create(ctx, obj, opts...)
+			},
+		}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:            fakeClient,
+		Scheme:            s,
+		nvmlHandler:       newDeviceHandler(nvmllib),
+		OperatorNamespace: OperatorNamespaceFromEnvironment(),
+	}
+	ctx := context.Background()
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "instaslice-system"}
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	handle, ret := nvmllib.DeviceGetHandleByIndex(0)
+	require.Equal(t, nvml.SUCCESS, ret)
+	instaslice.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{
+		"pod-uid-0": {
+			PodUUID:          "pod-uid-0",
+			PodName:          "pod-0",
+			Namespace:        "team-a",
+			GPUUUID:          handle.(*dgxa100.Device).UUID,
+			Nodename:         "node-1",
+			Profile:          "1g.5gb",
+			Size:             1,
+			Giprofileid:      nvml.GPU_INSTANCE_PROFILE_1_SLICE,
+			CIProfileID:      nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+			CIEngProfileID:   nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED,
+			Allocationstatus: inferencev1alpha1.AllocationStatusCreating,
+		},
+	}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: nsName})
+	require.NoError(t, err)
+
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, map[string]bool{"instaslice-system": true}, namespaces)
+	// the ConfigMap of the pod stays in the namespace of the pod
+	var configMap v1.ConfigMap
+	assert.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-0", Namespace: "team-a"}, &configMap))
+}
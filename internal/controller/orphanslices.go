@@ -0,0 +1,247 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// ConditionOrphanedSlices is set on the instaslice while slices found on the GPUs are held by no allocation.
+	ConditionOrphanedSlices = "OrphanedSlices"
+	// ReasonSlicesWithoutAllocation is the orphaned slices reason used while some slices are held by no allocation.
+	ReasonSlicesWithoutAllocation = "SlicesWithoutAllocation"
+	// ReasonNoOrphanedSlices clears the condition set for ReasonSlicesWithoutAllocation.
+	ReasonNoOrphanedSlices = "NoOrphanedSlices"
+	// DefaultOrphanSliceGracePeriod is how long a slice stays orphaned before ReclaimOrphanSlices destroys it.
+	DefaultOrphanSliceGracePeriod = 10 * time.Minute
+)
+
+// adoptingAllocation returns the allocation the slice found on the GPU was carved for: a created or ungated one at
+// its placement whose pod has no prepared entry, e.g. because the entry was lost while the daemonset was down.
+func adoptingAllocation(instaslice *inferencev1alpha1.Instaslice, prepared inferencev1alpha1.PreparedDetails) (inferencev1alpha1.AllocationDetails, bool) {
+	owned := allocationMigUUIDs(instaslice)
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.Allocationstatus != inferencev1alpha1.AllocationStatusCreated && allocation.Allocationstatus != inferencev1alpha1.AllocationStatusUngated {
+			continue
+		}
+		if allocation.GPUUUID != prepared.Parent || allocation.Start != prepared.Start || allocation.Size != prepared.Size {
+			continue
+		}
+		if len(owned[allocation.PodUUID]) > 0 {
+			continue
+		}
+		return allocation, true
+	}
+	return inferencev1alpha1.AllocationDetails{}, false
+}
+
+// sliceOrphaned reports whether the slice found on the GPU is held by nothing: no pod, no allocation or slice
+// intent at its placement and no desired layout of its GPU. Foreign slices and the ones of other versions of the
+// operator are left alone, they are not ours to destroy.
+func (r *InstaSliceDaemonsetReconciler) sliceOrphaned(instaslice *inferencev1alpha1.Instaslice, prepared inferencev1alpha1.PreparedDetails) bool {
+	if prepared.PodUUID != "" || prepared.Profile == ForeignSliceProfile || r.carvedByOtherVersion(prepared) {
+		return false
+	}
+	if _, exists := instaslice.Spec.DesiredLayouts[prepared.Parent]; exists {
+		return false
+	}
+	overlaps := func(gpuUUID string, start, size uint32) bool {
+		return gpuUUID == prepared.Parent && start < prepared.Start+prepared.Size && prepared.Start < start+size
+	}
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.Allocationstatus == inferencev1alpha1.AllocationStatusDeleted || allocation.Allocationstatus == inferencev1alpha1.AllocationStatusFailed {
+			continue
+		}
+		if overlaps(allocation.GPUUUID, allocation.Start, allocation.Size) {
+			return false
+		}
+	}
+	for _, intent := range instaslice.Status.SliceIntents {
+		if overlaps(intent.GPUUUID, intent.Start, intent.Size) {
+			return false
+		}
+	}
+	return true
+}
+
+// reconcileOrphanSlices hands the slices found on the GPUs over to the allocations they were carved for and
+// reports the ones held by nothing in the OrphanedSlices condition. With ReclaimOrphanSlices, a slice orphaned
+// for OrphanSliceGracePeriod is destroyed like the slice of a deleted pod and its prepared entry dropped. It
+// reports whether the instaslice changed.
+func (r *InstaSliceDaemonsetReconciler) reconcileOrphanSlices(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) (bool, error) {
+	adopted := make(map[string]string)
+	var orphans []string
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID != "" {
+			continue
+		}
+		if allocation, found := adoptingAllocation(instaslice, prepared); found {
+			adopted[migUUID] = allocation.PodUUID
+			continue
+		}
+		if r.sliceOrphaned(instaslice, prepared) {
+			orphans = append(orphans, migUUID)
+		}
+	}
+	sort.Strings(orphans)
+	if len(adopted) > 0 {
+		if _, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+			for migUUID, podUUID := range adopted {
+				if prepared, exists := latest.Status.Prepared[migUUID]; exists && prepared.PodUUID == "" {
+					prepared.PodUUID = podUUID
+					latest.Status.Prepared[migUUID] = prepared
+				}
+			}
+			return nil
+		}); err != nil {
+			return false, err
+		}
+		for migUUID, podUUID := range adopted {
+			log.FromContext(ctx).Info("adopted slice found on the GPU for its allocation", "migUUID", migUUID, "pod", podUUID)
+		}
+		return true, nil
+	}
+
+	expired := r.trackOrphanSlices(ctx, orphans)
+	if err := r.setOrphanedSlicesCondition(ctx, instaslice.Name, orphans); err != nil {
+		return false, err
+	}
+	if !r.ReclaimOrphanSlices || len(expired) == 0 {
+		return false, nil
+	}
+	return true, r.destroyOrphanSlices(ctx, instaslice, expired)
+}
+
+// trackOrphanSlices records when each orphaned slice was first seen, forgetting the ones no longer orphaned, and
+// returns the ones orphaned for at least the grace period.
+func (r *InstaSliceDaemonsetReconciler) trackOrphanSlices(ctx context.Context, orphans []string) []string {
+	gracePeriod := r.OrphanSliceGracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultOrphanSliceGracePeriod
+	}
+	current := now().Time
+	r.orphanSlicesMu.Lock()
+	defer r.orphanSlicesMu.Unlock()
+	seen := make(map[string]time.Time, len(orphans))
+	var expired []string
+	for _, migUUID := range orphans {
+		since, tracked := r.orphanSlices[migUUID]
+		if !tracked {
+			since = current
+			log.FromContext(ctx).Info("slice found on the GPU is held by no allocation", "migUUID", migUUID, "reclaim", r.ReclaimOrphanSlices)
+		}
+		seen[migUUID] = since
+		if current.Sub(since) >= gracePeriod {
+			expired = append(expired, migUUID)
+		}
+	}
+	r.orphanSlices = seen
+	return expired
+}
+
+// setOrphanedSlicesCondition lists the orphaned slices in the OrphanedSlices condition of the instaslice, or
+// clears a condition set earlier when there are none.
+func (r *InstaSliceDaemonsetReconciler) setOrphanedSlicesCondition(ctx context.Context, name string, orphans []string) error {
+	condition := metav1.Condition{
+		Type:    ConditionOrphanedSlices,
+		Status:  metav1.ConditionFalse,
+		Reason:  ReasonNoOrphanedSlices,
+		Message: "every slice of the node is held by an allocation",
+	}
+	if len(orphans) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonSlicesWithoutAllocation
+		condition.Message = "slices held by no allocation: " + strings.Join(orphans, ", ")
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var latest inferencev1alpha1.Instaslice
+		if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: r.instasliceNamespace()}, &latest); err != nil {
+			return err
+		}
+		if len(orphans) == 0 && meta.FindStatusCondition(latest.Status.Conditions, ConditionOrphanedSlices) == nil {
+			return nil
+		}
+		if !meta.SetStatusCondition(&latest.Status.Conditions, condition) {
+			return nil
+		}
+		return r.Status().Update(ctx, &latest)
+	})
+}
+
+// destroyOrphanSlices destroys the orphaned slices and drops their prepared entries. The slices destroyed before
+// a failure are dropped all the same, the next reconcile goes on with the others.
+func (r *InstaSliceDaemonsetReconciler) destroyOrphanSlices(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, migUUIDs []string) error {
+	nvmllib := r.handler().nvml
+	computeInstances := make(map[gpuInstanceRef][]int)
+	sliceOf := make(map[gpuInstanceRef][]string)
+	for _, migUUID := range migUUIDs {
+		prepared := instaslice.Status.Prepared[migUUID]
+		key := gpuInstanceRef{parent: prepared.Parent, gi: prepared.Giinfoid}
+		computeInstances[key] = append(computeInstances[key], int(prepared.Ciinfoid))
+		sliceOf[key] = append(sliceOf[key], migUUID)
+	}
+	destroyed := make(map[string]bool)
+	var errDestroying error
+	for _, teardown := range orderSliceTeardowns(computeInstances) {
+		device, ret := nvmllib.DeviceGetHandleByUUID(teardown.parent)
+		if ret != nvml.SUCCESS {
+			errDestroying = fmt.Errorf("unable to get GPU %s: %v", teardown.parent, ret)
+			break
+		}
+		ret = destroySlice(device, int(teardown.gi), teardown.cis...)
+		countSliceDeletion(ret)
+		if ret != nvml.SUCCESS {
+			errDestroying = fmt.Errorf("unable to destroy orphaned gi %d on GPU %s: %v", teardown.gi, teardown.parent, ret)
+			break
+		}
+		for _, migUUID := range sliceOf[teardown.gpuInstanceRef] {
+			destroyed[migUUID] = true
+			log.FromContext(ctx).Info("destroyed slice held by no allocation", "migUUID", migUUID, "gpu", teardown.parent, "gi", teardown.gi)
+		}
+	}
+	if len(destroyed) == 0 {
+		return errDestroying
+	}
+	if _, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+		for migUUID := range destroyed {
+			delete(latest.Status.Prepared, migUUID)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	r.orphanSlicesMu.Lock()
+	for migUUID := range destroyed {
+		delete(r.orphanSlices, migUUID)
+	}
+	r.orphanSlicesMu.Unlock()
+	return errDestroying
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newOrphanSliceTestReconciler returns a reconciler on a node whose GPU holds a 1g.5gb slice at index 3 left
+// behind by an earlier run, and the MIG UUID discovery recorded it under.
+func newOrphanSliceTestReconciler(t *testing.T) (*InstaSliceDaemonsetReconciler, client.Client, *dgxa100.Device, string) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t)
+	gi, ret := createGpuInstance(device, nvml.GPU_INSTANCE_PROFILE_1_SLICE, nvml.GpuInstancePlacement{Start: 3, Size: 1})
+	require.Equal(t, nvml.SUCCESS, ret)
+	_, ret = createComputeInstance(gi, nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED)
+	require.Equal(t, nvml.SUCCESS, ret)
+	_, err := reconciler.discoverMigEnabledGpuWithSlices(context.Background())
+	require.NoError(t, err)
+
+	instaslice := getOrphanSliceTestInstaslice(t, fakeClient)
+	require.Len(t, instaslice.Status.Prepared, 1)
+	var migUUID string
+	for key := range instaslice.Status.Prepared {
+		migUUID = key
+	}
+	require.Empty(t, instaslice.Status.Prepared[migUUID].PodUUID)
+	return reconciler, fakeClient, device, migUUID
+}
+
+func getOrphanSliceTestInstaslice(t *testing.T, fakeClient client.Client) *inferencev1alpha1.Instaslice {
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	return &instaslice
+}
+
+func TestSliceFoundForAnAllocationIsAdopted(t *testing.T) {
+	reconciler, fakeClient, device, migUUID := newOrphanSliceTestReconciler(t)
+	ctx := context.Background()
+	instaslice := getOrphanSliceTestInstaslice(t, fakeClient)
+	instaslice.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{
+		"pod-uid-0": {
+			PodUUID: "pod-uid-0", PodName: "pod-0", Namespace: "default", GPUUUID: device.UUID, Nodename: "node-1",
+			Profile: "1g.5gb", Start: 3, Size: 1, Allocationstatus: inferencev1alpha1.AllocationStatusUngated,
+		},
+	}
+	require.NoError(t, fakeClient.Update(ctx, instaslice))
+
+	changed, err := reconciler.reconcileOrphanSlices(ctx, instaslice)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	instaslice = getOrphanSliceTestInstaslice(t, fakeClient)
+	assert.Equal(t, "pod-uid-0", instaslice.Status.Prepared[migUUID].PodUUID)
+	assert.Nil(t, meta.FindStatusCondition(instaslice.Status.Conditions, ConditionOrphanedSlices))
+	assert.Len(t, device.GpuInstances, 1)
+}
+
+func TestOrphanedSliceIsOnlyReportedByDefault(t *testing.T) {
+	defer func() { now = metav1.Now }()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() metav1.Time { return metav1.NewTime(start) }
+	reconciler, fakeClient, device, migUUID := newOrphanSliceTestReconciler(t)
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	now = func() metav1.Time { return metav1.NewTime(start.Add(time.Hour)) }
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	instaslice := getOrphanSliceTestInstaslice(t, fakeClient)
+	assert.Contains(t, instaslice.Status.Prepared, migUUID)
+	assert.Len(t, device.GpuInstances, 1)
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionOrphanedSlices)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, ReasonSlicesWithoutAllocation, condition.Reason)
+	assert.Contains(t, condition.Message, migUUID)
+}
+
+func TestOrphanedSliceIsReclaimedAfterTheGracePeriod(t *testing.T) {
+	defer func() { now = metav1.Now }()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() metav1.Time { return metav1.NewTime(start) }
+	reconciler, fakeClient, device, migUUID := newOrphanSliceTestReconciler(t)
+	reconciler.ReclaimOrphanSlices = true
+	reconciler.OrphanSliceGracePeriod = time.Minute
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Len(t, device.GpuInstances, 1)
+	assert.Contains(t, getOrphanSliceTestInstaslice(t, fakeClient).Status.Prepared, migUUID)
+
+	now = func() metav1.Time { return metav1.NewTime(start.Add(2 * time.Minute)) }
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.True(t, result.Requeue)
+	assert.Empty(t, device.GpuInstances)
+	assert.NotContains(t, getOrphanSliceTestInstaslice(t, fakeClient).Status.Prepared, migUUID)
+
+	// once the slice is gone the condition is cleared
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	condition := meta.FindStatusCondition(getOrphanSliceTestInstaslice(t, fakeClient).Status.Conditions, ConditionOrphanedSlices)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+}
+
+func TestSlicesHeldByAllocationsOrLayoutsAreNotOrphaned(t *testing.T) {
+	r := &InstaSliceDaemonsetReconciler{}
+	instaslice := &inferencev1alpha1.Instaslice{
+		Spec: inferencev1alpha1.InstasliceSpec{
+			Allocations: map[string]inferencev1alpha1.AllocationDetails{
+				"pod-uid-0": {GPUUUID: "GPU-1", Start: 0, Size: 2, Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+				"pod-uid-1": {GPUUUID: "GPU-1", Start: 4, Size: 1, Allocationstatus: inferencev1alpha1.AllocationStatusDeleted},
+			},
+			DesiredLayouts: map[string][]string{"GPU-2": {"7g.40gb"}},
+		},
+	}
+	assert.False(t, r.sliceOrphaned(instaslice, inferencev1alpha1.PreparedDetails{Profile: "1g.5gb", Parent: "GPU-1", Start: 1, Size: 1}))
+	assert.True(t, r.sliceOrphaned(instaslice, inferencev1alpha1.PreparedDetails{Profile: "1g.5gb", Parent: "GPU-1", Start: 4, Size: 1}))
+	assert.False(t, r.sliceOrphaned(instaslice, inferencev1alpha1.PreparedDetails{Profile: "1g.5gb", Parent: "GPU-2", Start: 4, Size: 1}))
+	assert.False(t, r.sliceOrphaned(instaslice, inferencev1alpha1.PreparedDetails{Profile: ForeignSliceProfile, Parent: "GPU-1", Start: 5, Size: 1}))
+	assert.False(t, r.sliceOrphaned(instaslice, inferencev1alpha1.PreparedDetails{Profile: "1g.5gb", Parent: "GPU-1", Start: 5, Size: 1, CarvedBy: "v1alpha2"}))
+}
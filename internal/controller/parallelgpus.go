@@ -0,0 +1,113 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// allocationsByGPU groups the allocations by the GPU they target, ordered by GPU UUID. The allocations of a GPU
+// keep their order.
+func allocationsByGPU(allocations []inferencev1alpha1.AllocationDetails) [][]inferencev1alpha1.AllocationDetails {
+	groups := make(map[string][]inferencev1alpha1.AllocationDetails)
+	var gpuUUIDs []string
+	for _, allocation := range allocations {
+		if _, exists := groups[allocation.GPUUUID]; !exists {
+			gpuUUIDs = append(gpuUUIDs, allocation.GPUUUID)
+		}
+		groups[allocation.GPUUUID] = append(groups[allocation.GPUUUID], allocation)
+	}
+	sort.Strings(gpuUUIDs)
+	byGPU := make([][]inferencev1alpha1.AllocationDetails, 0, len(gpuUUIDs))
+	for _, gpuUUID := range gpuUUIDs {
+		byGPU = append(byGPU, groups[gpuUUID])
+	}
+	return byGPU
+}
+
+// parallelGPUs returns how many GPUs the slices of a batch are carved on at once.
+func (r *InstaSliceDaemonsetReconciler) parallelGPUs() int {
+	if r.MaxParallelGPUs < 1 {
+		return 1
+	}
+	return r.MaxParallelGPUs
+}
+
+// realizeBatchSlices realizes the slices of the batch, on up to MaxParallelGPUs GPUs at once. The slices of a GPU
+// are carved one after the other, the GPU serializes them anyway. It returns the allocations whose slice was
+// realized, in the order of pending, and why the others were not. An invalidated NVML handle is returned as is,
+// the GPU it happened on does not attempt its slices left.
+func (r *InstaSliceDaemonsetReconciler) realizeBatchSlices(ctx context.Context, nvmllib nvml.Interface, nodeName string, instaslice *inferencev1alpha1.Instaslice, pending []inferencev1alpha1.AllocationDetails) ([]inferencev1alpha1.AllocationDetails, map[string]error, error) {
+	var mu sync.Mutex
+	realized := make(map[string]bool)
+	failed := make(map[string]error)
+	var errLost error
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, r.parallelGPUs())
+	for _, allocations := range allocationsByGPU(pending) {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(allocations []inferencev1alpha1.AllocationDetails) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			for _, allocation := range allocations {
+				err := r.realizeBatchSlice(ctx, nvmllib, nodeName, instaslice, allocation)
+				mu.Lock()
+				if err == nil {
+					realized[allocation.PodUUID] = true
+				} else if isNVMLHandleLost(err) {
+					errLost = err
+				} else {
+					failed[allocation.PodName] = err
+				}
+				mu.Unlock()
+				if err == nil {
+					r.clearSliceCreationFailures(allocation.PodUUID)
+					continue
+				}
+				// the handles of the session went stale, the slices left are not attempted with them
+				if isNVMLHandleLost(err) {
+					return
+				}
+				if _, errFailing := r.sliceCreationFailed(ctx, instaslice.Name, allocation, err); errFailing != nil {
+					log.FromContext(ctx).Error(errFailing, "unable to mark slice creation failed for ", "pod", allocation.PodName)
+				}
+			}
+		}(allocations)
+	}
+	wg.Wait()
+	if errLost != nil {
+		return nil, nil, errLost
+	}
+
+	var created []inferencev1alpha1.AllocationDetails
+	for _, allocation := range pending {
+		if realized[allocation.PodUUID] {
+			created = append(created, allocation)
+		}
+	}
+	return created, failed, nil
+}
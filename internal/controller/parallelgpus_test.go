@@ -0,0 +1,162 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestCreateSlicesInBatchCarvesGPUsInParallel(t *testing.T) {
+	t.Setenv(FakeGPUEnv, "2")
+	t.Setenv("NODE_NAME", "node-1")
+	nvmllib, err := newNvmlLib(nil)
+	require.NoError(t, err)
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(node).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}, &v1.Node{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:          fakeClient,
+		Scheme:          s,
+		nvmlHandler:     newDeviceHandler(nvmllib),
+		MaxParallelGPUs: 2,
+	}
+	ctx := context.Background()
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+
+	// the first slice of each GPU waits for the other GPU to carve too, which only happens when they run in parallel
+	var inFlight, maxInFlight atomic.Int32
+	bothCarving := make(chan struct{})
+	var bothCarvingOnce sync.Once
+	devices := make([]*dgxa100.Device, 2)
+	perGPUInFlight := make([]atomic.Int32, 2)
+	var perGPUOverlap atomic.Bool
+	for i := range devices {
+		handle, ret := nvmllib.DeviceGetHandleByIndex(i)
+		require.Equal(t, nvml.SUCCESS, ret)
+		devices[i] = handle.(*dgxa100.Device)
+		createGpuInstance := devices[i].CreateGpuInstanceWithPlacementFunc
+		gpuInFlight := &perGPUInFlight[i]
+		devices[i].CreateGpuInstanceWithPlacementFunc = func(info *nvml.GpuInstanceProfileInfo, placement *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+			if gpuInFlight.Add(1) > 1 {
+				perGPUOverlap.Store(true)
+			}
+			defer gpuInFlight.Add(-1)
+			current := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				seen := maxInFlight.Load()
+				if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+			if current == 2 {
+				bothCarvingOnce.Do(func() { close(bothCarving) })
+			}
+			select {
+			case <-bothCarving:
+			case <-time.After(2 * time.Second):
+			}
+			return createGpuInstance(info, placement)
+		}
+	}
+
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	instaslice.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
+	for i := 0; i < 4; i++ {
+		podUUID := fmt.Sprintf("pod-uid-%d", i)
+		instaslice.Spec.Allocations[podUUID] = inferencev1alpha1.AllocationDetails{
+			PodUUID:          podUUID,
+			PodName:          fmt.Sprintf("pod-%d", i),
+			Namespace:        "default",
+			GPUUUID:          devices[i%2].UUID,
+			Nodename:         "node-1",
+			Profile:          "1g.5gb",
+			Start:            uint32(i / 2),
+			Size:             1,
+			Giprofileid:      nvml.GPU_INSTANCE_PROFILE_1_SLICE,
+			CIProfileID:      nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+			CIEngProfileID:   nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED,
+			Allocationstatus: inferencev1alpha1.AllocationStatusCreating,
+		}
+	}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	batched, err := reconciler.createSlicesInBatch(ctx, "node-1", &instaslice)
+	require.NoError(t, err)
+	assert.True(t, batched)
+	assert.Equal(t, int32(2), maxInFlight.Load(), "slices of different GPUs should be carved concurrently")
+	assert.False(t, perGPUOverlap.Load(), "slices of a GPU should be carved one after the other")
+
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	for podUUID, allocation := range instaslice.Spec.Allocations {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, allocation.Allocationstatus, podUUID)
+	}
+	require.Len(t, instaslice.Status.Prepared, 4)
+	startsByGPU := make(map[string][]uint32)
+	migUUIDs := make(map[string]bool)
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		allocation := instaslice.Spec.Allocations[prepared.PodUUID]
+		assert.Equal(t, allocation.GPUUUID, prepared.Parent)
+		assert.Equal(t, allocation.Start, prepared.Start)
+		startsByGPU[prepared.Parent] = append(startsByGPU[prepared.Parent], prepared.Start)
+		migUUIDs[migUUID] = true
+	}
+	assert.Len(t, migUUIDs, 4)
+	for _, device := range devices {
+		assert.ElementsMatch(t, []uint32{0, 1}, startsByGPU[device.UUID])
+		assert.Len(t, device.GpuInstances, 2)
+	}
+}
+
+func TestAllocationsByGPU(t *testing.T) {
+	allocations := []inferencev1alpha1.AllocationDetails{
+		{PodUUID: "pod-uid-0", GPUUUID: "GPU-b"},
+		{PodUUID: "pod-uid-1", GPUUUID: "GPU-a"},
+		{PodUUID: "pod-uid-2", GPUUUID: "GPU-b"},
+	}
+	byGPU := allocationsByGPU(allocations)
+	require.Len(t, byGPU, 2)
+	assert.Equal(t, []inferencev1alpha1.AllocationDetails{allocations[1]}, byGPU[0])
+	assert.Equal(t, []inferencev1alpha1.AllocationDetails{allocations[0], allocations[2]}, byGPU[1])
+}
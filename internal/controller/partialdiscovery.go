@@ -0,0 +1,99 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// ConditionPartiallyDiscovered is set on the instaslice when BestEffortDiscovery skipped GPUs NVML failed on.
+	ConditionPartiallyDiscovered = "PartiallyDiscovered"
+	// ReasonGPUsSkipped is the partially discovered reason used when some GPUs of the node are not advertised.
+	ReasonGPUsSkipped = "GPUsSkipped"
+	// ReasonAllGPUsDiscovered is the reason used when discovery went through on every GPU of the node.
+	ReasonAllGPUsDiscovered = "AllGPUsDiscovered"
+)
+
+// skipUndiscoveredGPU leaves the GPU at index out of the rest of discovery and returns failed with it added.
+func (r *InstaSliceDaemonsetReconciler) skipUndiscoveredGPU(failed []string, index int, uuid string, err error) []string {
+	if r.undiscoveredGPUs == nil {
+		r.undiscoveredGPUs = make(map[int]bool)
+	}
+	r.undiscoveredGPUs[index] = true
+	gpu := fmt.Sprintf("GPU %d", index)
+	if uuid != "" {
+		gpu = fmt.Sprintf("GPU %d (%s)", index, uuid)
+	}
+	log.Log.Error(err, "skipping GPU that failed discovery", "index", index, "gpu", uuid)
+	return append(failed, fmt.Sprintf("%s: %v", gpu, err))
+}
+
+// deviceIdentity returns the UUID and the model name of the GPU, failing when it cannot report either.
+func deviceIdentity(device nvml.Device) (string, string, error) {
+	uuid, ret := device.GetUUID()
+	if ret != nvml.SUCCESS {
+		return "", "", fmt.Errorf("unable to get the UUID of the GPU: %v", ret)
+	}
+	if uuid == "" {
+		return "", "", fmt.Errorf("GPU reported an empty UUID")
+	}
+	name, ret := device.GetName()
+	if ret != nvml.SUCCESS {
+		return uuid, "", fmt.Errorf("unable to get the name of the GPU: %v", ret)
+	}
+	return uuid, name, nil
+}
+
+// withoutGPU returns the discovered GPUs without the one of the UUID.
+func withoutGPU(gpus []string, uuid string) []string {
+	kept := gpus[:0]
+	for _, gpu := range gpus {
+		if gpu != uuid {
+			kept = append(kept, gpu)
+		}
+	}
+	return kept
+}
+
+// setPartiallyDiscoveredCondition flags the GPUs discovery skipped on the status, or clears the condition when
+// there are none.
+func setPartiallyDiscoveredCondition(status *inferencev1alpha1.InstasliceStatus, failed []string) {
+	if len(failed) == 0 {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ConditionPartiallyDiscovered,
+			Status:  metav1.ConditionFalse,
+			Reason:  ReasonAllGPUsDiscovered,
+			Message: "every GPU of the node was discovered",
+		})
+		return
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    ConditionPartiallyDiscovered,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonGPUsSkipped,
+		Message: "GPUs left out of discovery: " + strings.Join(failed, "; "),
+	})
+}
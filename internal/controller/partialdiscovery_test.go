@@ -0,0 +1,128 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newPartiallyFailingReconciler returns a reconciler on a node of two fake GPUs, the first one failing to report
+// its memory with the given return unless it is SUCCESS.
+func newPartiallyFailingReconciler(t *testing.T, failure nvml.Return) (*InstaSliceDaemonsetReconciler, string) {
+	t.Setenv(FakeGPUEnv, "2")
+	nvmllib, err := newNvmlLib(nil)
+	require.NoError(t, err)
+	server := nvmllib.(*dgxa100.Server)
+	if failure != nvml.SUCCESS {
+		failing := server.Devices[0].(*dgxa100.Device)
+		failing.GetMemoryInfoFunc = func() (nvml.Memory, nvml.Return) { return nvml.Memory{}, failure }
+	}
+	healthy, ret := server.Devices[1].GetUUID()
+	require.Equal(t, nvml.SUCCESS, ret)
+	return &InstaSliceDaemonsetReconciler{nvmlHandler: newDeviceHandler(nvmllib)}, healthy
+}
+
+func TestBestEffortDiscoverySkipsFailingGPUs(t *testing.T) {
+	reconciler, healthy := newPartiallyFailingReconciler(t, nvml.ERROR_GPU_IS_LOST)
+	reconciler.BestEffortDiscovery = true
+
+	instaslice, _, gpuModelMap, failed, _, err := reconciler.discoverAvailableProfilesOnGpus(context.Background())
+	require.NoError(t, err)
+	require.False(t, failed)
+	assert.Len(t, gpuModelMap, 1)
+	assert.Contains(t, gpuModelMap, healthy)
+	var profiles []string
+	for _, mig := range instaslice.Spec.Migplacement {
+		profiles = append(profiles, mig.Profile)
+	}
+	assert.Contains(t, profiles, "1g.5gb")
+	assert.Contains(t, profiles, "7g.40gb")
+
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionPartiallyDiscovered)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, ReasonGPUsSkipped, condition.Reason)
+	assert.Contains(t, condition.Message, "GPU 0")
+	assert.NotContains(t, condition.Message, healthy)
+
+	// the rest of discovery leaves the skipped GPU alone
+	migEnabled, err := reconciler.discoverMigMode()
+	require.NoError(t, err)
+	assert.Len(t, migEnabled, 1)
+	assert.Contains(t, migEnabled, healthy)
+	require.NoError(t, reconciler.discoverDanglingSlices(instaslice))
+}
+
+func TestDiscoveryFailsOnAnyGPUByDefault(t *testing.T) {
+	reconciler, _ := newPartiallyFailingReconciler(t, nvml.ERROR_GPU_IS_LOST)
+
+	_, _, _, _, _, err := reconciler.discoverAvailableProfilesOnGpus(context.Background())
+	assert.Error(t, err)
+}
+
+func TestBestEffortDiscoveryClearsTheConditionWhenEveryGPUIsDiscovered(t *testing.T) {
+	reconciler, _ := newPartiallyFailingReconciler(t, nvml.SUCCESS)
+	reconciler.BestEffortDiscovery = true
+
+	instaslice, _, gpuModelMap, _, _, err := reconciler.discoverAvailableProfilesOnGpus(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, gpuModelMap, 2)
+	assert.True(t, meta.IsStatusConditionFalse(instaslice.Status.Conditions, ConditionPartiallyDiscovered))
+}
+
+func TestBestEffortDiscoveryEnumeratesProfilesOnTheNextGPU(t *testing.T) {
+	reconciler, healthy := newPartiallyFailingReconciler(t, nvml.SUCCESS)
+	reconciler.BestEffortDiscovery = true
+	server := reconciler.handler().nvml.(*dgxa100.Server)
+	server.Devices[0].(*dgxa100.Device).GetGpuInstanceProfileInfoFunc = func(int) (nvml.GpuInstanceProfileInfo, nvml.Return) {
+		return nvml.GpuInstanceProfileInfo{}, nvml.ERROR_UNKNOWN
+	}
+
+	instaslice, _, gpuModelMap, _, _, err := reconciler.discoverAvailableProfilesOnGpus(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, gpuModelMap, 1)
+	assert.Contains(t, gpuModelMap, healthy)
+	assert.NotEmpty(t, instaslice.Spec.Migplacement)
+	assert.True(t, meta.IsStatusConditionTrue(instaslice.Status.Conditions, ConditionPartiallyDiscovered))
+}
+
+func TestGPUWithoutUUIDIsLeftOutOfTheModelMap(t *testing.T) {
+	reconciler, healthy := newPartiallyFailingReconciler(t, nvml.SUCCESS)
+	server := reconciler.handler().nvml.(*dgxa100.Server)
+	server.Devices[0].(*dgxa100.Device).GetUUIDFunc = func() (string, nvml.Return) { return "", nvml.ERROR_UNKNOWN }
+
+	_, _, _, _, _, err := reconciler.discoverAvailableProfilesOnGpus(context.Background())
+	assert.Error(t, err)
+
+	reconciler.BestEffortDiscovery = true
+	instaslice, _, gpuModelMap, failed, _, err := reconciler.discoverAvailableProfilesOnGpus(context.Background())
+	require.NoError(t, err)
+	require.False(t, failed)
+	assert.Equal(t, map[string]string{healthy: "Mock NVIDIA A100-SXM4-40GB"}, gpuModelMap)
+	assert.NotContains(t, gpuModelMap, "")
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionPartiallyDiscovered)
+	require.NotNil(t, condition)
+	assert.Contains(t, condition.Message, "GPU 0: unable to get the UUID of the GPU")
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// gpuPinned reports whether the GPU is pinned to a namespace in spec.pinnedNamespaces.
+func gpuPinned(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) bool {
+	_, pinned := instaslice.Spec.PinnedNamespaces[gpuUUID]
+	return pinned
+}
+
+// gpuAdmitsNamespace reports whether slices may be placed on the GPU for pods of the namespace, pods of any
+// namespace are admitted on GPUs that are not pinned.
+func gpuAdmitsNamespace(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, namespace string) bool {
+	pinnedNamespace, pinned := instaslice.Spec.PinnedNamespaces[gpuUUID]
+	return !pinned || pinnedNamespace == namespace
+}
+
+// pinnedFreeSlices returns, per namespace GPUs are pinned to, how many slices of each profile fit on its GPUs
+// like freeSlicesPerProfile counts them on the GPUs open to every namespace.
+func pinnedFreeSlices(instaslice *inferencev1alpha1.Instaslice) map[string]map[string]int {
+	pinned := make(map[string]map[string]int)
+	for _, namespace := range instaslice.Spec.PinnedNamespaces {
+		if _, counted := pinned[namespace]; counted {
+			continue
+		}
+		pinned[namespace] = freeSlicesOnGPUs(instaslice, func(gpuUUID string) bool {
+			return instaslice.Spec.PinnedNamespaces[gpuUUID] == namespace
+		})
+	}
+	return pinned
+}
+
+// invalidPinnedNamespaces describes, in order, the entries of spec.pinnedNamespaces naming a GPU the node does
+// not have or no namespace. They never match and most likely are typos.
+func invalidPinnedNamespaces(instaslice *inferencev1alpha1.Instaslice) []string {
+	var invalid []string
+	for gpuUUID, namespace := range instaslice.Spec.PinnedNamespaces {
+		if _, known := instaslice.Spec.MigGPUUUID[gpuUUID]; !known {
+			invalid = append(invalid, fmt.Sprintf("GPU %s is not on the node", gpuUUID))
+			continue
+		}
+		if namespace == "" {
+			invalid = append(invalid, fmt.Sprintf("GPU %s is pinned to no namespace", gpuUUID))
+		}
+	}
+	sort.Strings(invalid)
+	return invalid
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestGPUPinnedToNamespaceOnlyTakesItsPods(t *testing.T) {
+	r := &InstasliceReconciler{}
+	instaslice := newAllowedProfilesTestInstaslice()
+	instaslice.Spec.AllowedProfiles = nil
+	delete(instaslice.Spec.MigGPUUUID, "GPU-2")
+	instaslice.Spec.PinnedNamespaces = map[string]string{"GPU-1": "team-a"}
+
+	podOf := func(namespace string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: namespace, UID: types.UID("pod-uid-" + namespace)}}
+	}
+	_, err := r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, podOf("team-b"))
+	assert.Error(t, err, "GPU-1 is dedicated to team-a")
+
+	allocation, err := r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, podOf("team-a"))
+	require.NoError(t, err)
+	assert.Equal(t, "GPU-1", allocation.GPUUUID)
+	assert.Equal(t, "team-a", allocation.Namespace)
+}
+
+func TestPinnedGPUsAreAdvertisedToTheirNamespace(t *testing.T) {
+	instaslice := newAllowedProfilesTestInstaslice()
+	instaslice.Spec.AllowedProfiles = nil
+	instaslice.Spec.PinnedNamespaces = map[string]string{"GPU-1": "team-a"}
+
+	assert.Equal(t, map[string]int{"1g.5gb": 2, "3g.20gb": 2, "7g.40gb": 1}, freeSlicesPerProfile(instaslice))
+	data, err := capabilitiesData(instaslice)
+	require.NoError(t, err)
+	var pinned map[string]map[string]int
+	require.NoError(t, json.Unmarshal([]byte(data[CapabilitiesPinnedKey]), &pinned))
+	assert.Equal(t, map[string]map[string]int{"team-a": {"1g.5gb": 2, "3g.20gb": 2, "7g.40gb": 1}}, pinned)
+}
+
+func TestInvalidPinnedNamespaces(t *testing.T) {
+	instaslice := newAllowedProfilesTestInstaslice()
+	instaslice.Spec.PinnedNamespaces = map[string]string{"GPU-1": "team-a"}
+	assert.Empty(t, invalidPinnedNamespaces(instaslice))
+
+	instaslice.Spec.PinnedNamespaces["GPU-2"] = ""
+	instaslice.Spec.PinnedNamespaces["GPU-3"] = "team-b"
+	assert.Equal(t, []string{
+		"GPU GPU-2 is pinned to no namespace",
+		"GPU GPU-3 is not on the node",
+	}, invalidPinnedNamespaces(instaslice))
+}
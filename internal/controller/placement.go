@@ -0,0 +1,89 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// PlacementSelector picks where on a GPU a slice of the given profile is carved.
+// freePlacements holds the possible placements for the profile that do not overlap
+// any occupied slice index, in the order they were discovered. occupied has one entry
+// per slice index on the GPU and is true when the index is already in use, it is a
+// PlacementSet that implementations may convert back to use its methods.
+// Implementations return false when none of the free placements is acceptable.
+type PlacementSelector interface {
+	Select(profile string, freePlacements []inferencev1alpha1.Placement, occupied []bool) (inferencev1alpha1.Placement, bool)
+}
+
+// FirstFitSelector picks the first free placement, this is the default selector.
+type FirstFitSelector struct{}
+
+// Select returns the first free placement.
+func (*FirstFitSelector) Select(profile string, freePlacements []inferencev1alpha1.Placement, occupied []bool) (inferencev1alpha1.Placement, bool) {
+	if len(freePlacements) == 0 {
+		return inferencev1alpha1.Placement{}, false
+	}
+	return freePlacements[0], true
+}
+
+// freePlacementsFor returns the placements that fit on the GPU without overlapping an occupied index.
+func freePlacementsFor(placements []inferencev1alpha1.Placement, occupied PlacementSet) []inferencev1alpha1.Placement {
+	var free []inferencev1alpha1.Placement
+	for _, placement := range placements {
+		if placementIsFree(placement, occupied) {
+			free = append(free, placement)
+		}
+	}
+	return free
+}
+
+// placementsByEnd returns the placements ordered by the slice index they end at. Taking the free ones in this
+// order fits as many non-overlapping slices of a profile as its placements allow, whatever order they were
+// discovered in.
+func placementsByEnd(placements []inferencev1alpha1.Placement) []inferencev1alpha1.Placement {
+	sorted := append([]inferencev1alpha1.Placement(nil), placements...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start+sorted[i].Size < sorted[j].Start+sorted[j].Size
+	})
+	return sorted
+}
+
+// placementIsFree reports whether every slice index covered by the placement is unoccupied.
+func placementIsFree(placement inferencev1alpha1.Placement, occupied PlacementSet) bool {
+	start, size, ok := placementRegion(placement)
+	return ok && occupied.Fits(start, size)
+}
+
+// CanPlace reports whether a slice of the profile fits on the GPU of the instaslice, without side effects, for
+// webhooks and scheduler integrations asking about schedulability. The GPU has to be known to the instaslice and
+// take new slices of the profile, and a placement of the profile must not overlap a carved slice or a pending
+// allocation.
+// Slices kept free with spec.reservedSlicesPerGpu are not taken into account.
+func CanPlace(instaslice *inferencev1alpha1.Instaslice, profile string, gpuUUID string) bool {
+	if _, known := instaslice.Spec.MigGPUUUID[gpuUUID]; !known || !gpuTakesNewSlices(instaslice, gpuUUID) || !gpuAllowsProfile(instaslice, gpuUUID, profile) {
+		return false
+	}
+	for _, mig := range instaslice.Spec.Migplacement {
+		if ProfilesMatch(mig.Profile, profile) {
+			return len(freePlacementsFor(mig.Placements, occupiedIndexes(instaslice, gpuUUID))) > 0
+		}
+	}
+	return false
+}
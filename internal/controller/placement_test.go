@@ -0,0 +1,150 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// lastFitSelector picks the last free placement to pack slices from the end of the GPU.
+type lastFitSelector struct {
+	calls int
+}
+
+func (l *lastFitSelector) Select(profile string, freePlacements []inferencev1alpha1.Placement, occupied []bool) (inferencev1alpha1.Placement, bool) {
+	l.calls++
+	if len(freePlacements) == 0 {
+		return inferencev1alpha1.Placement{}, false
+	}
+	return freePlacements[len(freePlacements)-1], true
+}
+
+func newPlacementTestInstaslice() *inferencev1alpha1.Instaslice {
+	var placements []inferencev1alpha1.Placement
+	for i := 0; i < 7; i++ {
+		placements = append(placements, inferencev1alpha1.Placement{Size: 1, Start: i})
+	}
+	return &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec: inferencev1alpha1.InstasliceSpec{
+			MigGPUUUID: map[string]string{"GPU-1": "NVIDIA A100-PCIE-40GB"},
+			Migplacement: []inferencev1alpha1.Mig{
+				{Profile: "1g.5gb", Giprofileid: 0, Placements: placements},
+			},
+		},
+		Status: inferencev1alpha1.InstasliceStatus{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{
+				"MIG-1": {Profile: "1g.5gb", Parent: "GPU-1", Start: 0, Size: 1},
+			},
+		},
+	}
+}
+
+func TestFindDeviceForASliceDefaultsToFirstFit(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+
+	allocation, err := r.findDeviceForASlice(newPlacementTestInstaslice(), "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), allocation.Start)
+	assert.Equal(t, "GPU-1", allocation.GPUUUID)
+}
+
+func TestFindDeviceForASliceHonorsCustomSelector(t *testing.T) {
+	selector := &lastFitSelector{}
+	r := &InstasliceReconciler{PlacementSelector: selector}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+
+	allocation, err := r.findDeviceForASlice(newPlacementTestInstaslice(), "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(6), allocation.Start)
+	assert.Equal(t, 1, selector.calls)
+}
+
+func TestFreePlacementsForSkipsOccupiedIndexes(t *testing.T) {
+	occupied := []bool{false, false, true, false, false, false, false, false}
+	placements := []inferencev1alpha1.Placement{{Start: 0, Size: 2}, {Start: 2, Size: 2}, {Start: 4, Size: 2}, {Start: 6, Size: 4}}
+
+	free := freePlacementsFor(placements, occupied)
+	assert.Equal(t, []inferencev1alpha1.Placement{{Start: 0, Size: 2}, {Start: 4, Size: 2}}, free)
+}
+
+func TestCanPlace(t *testing.T) {
+	newInstaslice := func() *inferencev1alpha1.Instaslice {
+		return &inferencev1alpha1.Instaslice{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Spec: inferencev1alpha1.InstasliceSpec{
+				MigGPUUUID: map[string]string{"GPU-1": "NVIDIA A100-PCIE-40GB"},
+				Migplacement: []inferencev1alpha1.Mig{
+					{Profile: "1g.5gb", Placements: []inferencev1alpha1.Placement{
+						{Size: 1, Start: 0}, {Size: 1, Start: 1}, {Size: 1, Start: 2}, {Size: 1, Start: 3},
+						{Size: 1, Start: 4}, {Size: 1, Start: 5}, {Size: 1, Start: 6},
+					}},
+					{Profile: "2g.10gb", Placements: []inferencev1alpha1.Placement{{Size: 2, Start: 0}, {Size: 2, Start: 2}, {Size: 2, Start: 4}}},
+					{Profile: "3g.20gb", Placements: []inferencev1alpha1.Placement{{Size: 4, Start: 0}, {Size: 4, Start: 4}}},
+					{Profile: "7g.40gb", Placements: []inferencev1alpha1.Placement{{Size: 8, Start: 0}}},
+				},
+			},
+		}
+	}
+	full := newInstaslice()
+	full.Status.Prepared = map[string]inferencev1alpha1.PreparedDetails{
+		"MIG-1": {Profile: "7g.40gb", Parent: "GPU-1", Start: 0, Size: 8},
+	}
+	fragmented := newInstaslice()
+	fragmented.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{}
+	for _, start := range []uint32{1, 3, 5} {
+		podUUID := fmt.Sprintf("pod-uid-%d", start)
+		fragmented.Spec.Allocations[podUUID] = inferencev1alpha1.AllocationDetails{
+			PodUUID: podUUID, GPUUUID: "GPU-1", Profile: "1g.5gb", Start: start, Size: 1, Allocationstatus: inferencev1alpha1.AllocationStatusCreating,
+		}
+	}
+	cordoned := newInstaslice()
+	cordoned.Spec.CordonedGPUs = []string{"GPU-1"}
+
+	tests := []struct {
+		name       string
+		instaslice *inferencev1alpha1.Instaslice
+		profile    string
+		gpuUUID    string
+		want       bool
+	}{
+		{name: "empty GPU fits the largest profile", instaslice: newInstaslice(), profile: "7g.40gb", gpuUUID: "GPU-1", want: true},
+		{name: "empty GPU fits the smallest profile", instaslice: newInstaslice(), profile: "1g.5gb", gpuUUID: "GPU-1", want: true},
+		{name: "full GPU fits nothing", instaslice: full, profile: "1g.5gb", gpuUUID: "GPU-1", want: false},
+		{name: "fragmented GPU fits a small profile", instaslice: fragmented, profile: "1g.5gb", gpuUUID: "GPU-1", want: true},
+		{name: "fragmented GPU has no room for 2g", instaslice: fragmented, profile: "2g.10gb", gpuUUID: "GPU-1", want: false},
+		{name: "fragmented GPU has no room for 3g", instaslice: fragmented, profile: "3g.20gb", gpuUUID: "GPU-1", want: false},
+		{name: "unknown profile", instaslice: newInstaslice(), profile: "4g.20gb", gpuUUID: "GPU-1", want: false},
+		{name: "unknown GPU", instaslice: newInstaslice(), profile: "1g.5gb", gpuUUID: "GPU-2", want: false},
+		{name: "cordoned GPU", instaslice: cordoned, profile: "1g.5gb", gpuUUID: "GPU-1", want: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			before := tc.instaslice.DeepCopy()
+			assert.Equal(t, tc.want, CanPlace(tc.instaslice, tc.profile, tc.gpuUUID))
+			assert.Equal(t, before, tc.instaslice)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// validPlacement reports whether a slice could be carved at the placement, spanning at least one memory slice and
+// none past the GPU.
+func validPlacement(placement inferencev1alpha1.Placement) bool {
+	start, size, ok := placementRegion(placement)
+	return ok && NewPlacementSet(gpuMemorySlices).Fits(start, size)
+}
+
+// allocatableMigPlacement returns the profiles with their valid placements, leaving out the profiles without any.
+// Drivers may report a profile with no usable placement; advertised, it would never be allocatable.
+func allocatableMigPlacement(migPlacement []inferencev1alpha1.Mig) []inferencev1alpha1.Mig {
+	allocatable := make([]inferencev1alpha1.Mig, 0, len(migPlacement))
+	for _, mig := range migPlacement {
+		placements := make([]inferencev1alpha1.Placement, 0, len(mig.Placements))
+		for _, placement := range mig.Placements {
+			if validPlacement(placement) {
+				placements = append(placements, placement)
+			}
+		}
+		if len(placements) == 0 {
+			log.Log.Info("profile has no valid placement, not advertising it", "profile", mig.Profile, "placements", mig.Placements)
+			continue
+		}
+		mig.Placements = placements
+		allocatable = append(allocatable, mig)
+	}
+	return allocatable
+}
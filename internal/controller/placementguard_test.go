@@ -0,0 +1,61 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileWithoutValidPlacementIsNotAdvertised(t *testing.T) {
+	t.Setenv(FakeGPUEnv, "1")
+	nvmllib, err := newNvmlLib(nil)
+	require.NoError(t, err)
+	device := nvmllib.(*dgxa100.Server).Devices[0].(*dgxa100.Device)
+	// a driver quirk reports placements no slice fits in for the 2 slice profile
+	getPlacements := device.GetGpuInstancePossiblePlacementsFunc
+	device.GetGpuInstancePossiblePlacementsFunc = func(info *nvml.GpuInstanceProfileInfo) ([]nvml.GpuInstancePlacement, nvml.Return) {
+		if info.Id == nvml.GPU_INSTANCE_PROFILE_2_SLICE {
+			return []nvml.GpuInstancePlacement{{Start: 0, Size: 0}, {Start: 8, Size: 2}}, nvml.SUCCESS
+		}
+		return getPlacements(info)
+	}
+	reconciler := &InstaSliceDaemonsetReconciler{nvmlHandler: newDeviceHandler(nvmllib)}
+
+	instaslice, _, _, failed, _, err := reconciler.discoverAvailableProfilesOnGpus(context.Background())
+	require.NoError(t, err)
+	require.False(t, failed)
+
+	var profiles []string
+	for _, mig := range instaslice.Spec.Migplacement {
+		profiles = append(profiles, mig.Profile)
+		assert.NotEmpty(t, mig.Placements, mig.Profile)
+	}
+	assert.Contains(t, profiles, "1g.5gb")
+	assert.NotContains(t, profiles, "2g.10gb")
+	free := freeSlicesPerProfile(instaslice)
+	assert.Contains(t, free, "1g.5gb")
+	assert.NotContains(t, free, "2g.10gb")
+	data, err := capabilitiesData(instaslice)
+	require.NoError(t, err)
+	assert.NotContains(t, data[CapabilitiesProfilesKey], "2g.10gb")
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// maxPlacementRetries is the number of alternate placements tried when the one of an allocation was taken.
+const maxPlacementRetries = 3
+
+// placementNotPossibleRetryInterval is how long the creation of a slice at a placement the GPU no longer offers is
+// deferred.
+var placementNotPossibleRetryInterval = 10 * time.Second
+
+// placementNotPossibleError is returned when the placement of a slice is not among the placements the GPU reports
+// possible for its profile at creation time, the creation is deferred rather than attempted.
+type placementNotPossibleError struct {
+	placement  nvml.GpuInstancePlacement
+	retryAfter time.Duration
+}
+
+func (e *placementNotPossibleError) Error() string {
+	return fmt.Sprintf("placement at start %d of size %d is not possible on the GPU, retry after %v", e.placement.Start, e.placement.Size, e.retryAfter)
+}
+
+// checkPlacementPossible queries again the placements possible for the GPU instance profile on the device, they
+// may have changed since discovery, and returns a placementNotPossibleError when the placement is not one of them.
+func checkPlacementPossible(device nvml.Device, giProfileID int, placement nvml.GpuInstancePlacement) error {
+	giProfileInfo, ret := device.GetGpuInstanceProfileInfo(giProfileID)
+	if errLost := checkNVMLHandle("GetGpuInstanceProfileInfo", ret); errLost != nil {
+		return errLost
+	}
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to get GPU instance profile %d: %v", giProfileID, ret)
+	}
+	placements, ret := possiblePlacements(device, giProfileInfo)
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to get the possible placements of GPU instance profile %d: %v", giProfileID, ret)
+	}
+	for _, possible := range placements {
+		if possible.Start == placement.Start && possible.Size == placement.Size {
+			return nil
+		}
+	}
+	return &placementNotPossibleError{placement: placement, retryAfter: placementNotPossibleRetryInterval}
+}
+
+// occupiedPlacements returns the placements of the GPU instances carved on the device, whatever their profile.
+func occupiedPlacements(device nvml.Device) ([]nvml.GpuInstancePlacement, nvml.Return) {
+	var occupied []nvml.GpuInstancePlacement
+	for i := 0; i < nvml.GPU_INSTANCE_PROFILE_COUNT; i++ {
+		giProfileInfo, ret := device.GetGpuInstanceProfileInfo(i)
+		if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		gis, ret := device.GetGpuInstances(&giProfileInfo)
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		for _, gi := range gis {
+			giInfo, ret := gi.GetInfo()
+			if ret != nvml.SUCCESS {
+				return nil, ret
+			}
+			occupied = append(occupied, giInfo.Placement)
+		}
+	}
+	return occupied, nvml.SUCCESS
+}
+
+// alternatePlacements returns, ordered by start, the placements of the profile of the allocation that are free on
+// the device and not promised to another allocation of the GPU.
+func alternatePlacements(device nvml.Device, giProfileInfo nvml.GpuInstanceProfileInfo, instaslice inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) ([]nvml.GpuInstancePlacement, nvml.Return) {
+	candidates, ret := possiblePlacements(device, giProfileInfo)
+	if ret != nvml.SUCCESS {
+		return nil, ret
+	}
+	taken, ret := occupiedPlacements(device)
+	if ret != nvml.SUCCESS {
+		return nil, ret
+	}
+	for _, other := range instaslice.Spec.Allocations {
+		if other.PodUUID == allocation.PodUUID || other.GPUUUID != allocation.GPUUUID || other.Allocationstatus == inferencev1alpha1.AllocationStatusDeleted {
+			continue
+		}
+		taken = append(taken, nvml.GpuInstancePlacement{Start: other.Start, Size: other.Size})
+	}
+	occupied := NewPlacementSet(gpuMemorySlices)
+	for _, placement := range taken {
+		occupied.Occupy(placement.Start, placement.Size)
+	}
+	var free []nvml.GpuInstancePlacement
+	for _, candidate := range candidates {
+		if !occupied.Overlaps(candidate.Start, candidate.Size) {
+			free = append(free, candidate)
+		}
+	}
+	sort.Slice(free, func(i, j int) bool {
+		return free[i].Start < free[j].Start
+	})
+	return free, nvml.SUCCESS
+}
+
+// createGpuInstanceWithRetry creates the GPU instance of the allocation at the placement. When the placement was
+// taken in the meantime, the free placements are computed again and up to maxPlacementRetries of them are tried
+// rather than failing the allocation.
+func createGpuInstanceWithRetry(ctx context.Context, device nvml.Device, instaslice inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails, placement nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+	gi, ret := createGpuInstance(device, allocation.Giprofileid, placement)
+	if ret != nvml.ERROR_INSUFFICIENT_RESOURCES {
+		return gi, ret
+	}
+	giProfileInfo, retForProfile := device.GetGpuInstanceProfileInfo(allocation.Giprofileid)
+	if retForProfile != nvml.SUCCESS {
+		return nil, ret
+	}
+	candidates, retForCandidates := alternatePlacements(device, giProfileInfo, instaslice, allocation)
+	if retForCandidates != nvml.SUCCESS {
+		log.FromContext(ctx).Error(retForCandidates, "unable to compute alternate placements for ", "pod", allocation.PodName)
+		return nil, ret
+	}
+	for i, candidate := range candidates {
+		if i == maxPlacementRetries {
+			break
+		}
+		log.FromContext(ctx).Info("placement was taken, retrying at an alternate one for ", "pod", allocation.PodName,
+			"start", placement.Start, "alternateStart", candidate.Start)
+		gi, ret = createGpuInstance(device, allocation.Giprofileid, candidate)
+		if ret != nvml.ERROR_INSUFFICIENT_RESOURCES {
+			return gi, ret
+		}
+	}
+	return nil, ret
+}
+
+// recordRelocatedPlacement moves the allocation of the pod to the start its slice was carved at once its own
+// placement was taken.
+func (r *InstaSliceDaemonsetReconciler) recordRelocatedPlacement(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, podUUID string, start uint32) error {
+	updated, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+		allocation, exists := latest.Spec.Allocations[podUUID]
+		if !exists || allocation.Start == start {
+			return errInstasliceUnchanged
+		}
+		log.FromContext(ctx).Info("recording alternate placement for ", "pod", allocation.PodName, "start", allocation.Start, "alternateStart", start)
+		allocation.Start = start
+		latest.Spec.Allocations[podUUID] = allocation
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*instaslice = *updated
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestCarveSliceRetriesAtAlternatePlacement(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	// a slice carved behind the back of the controller takes the placement of pod-0
+	_, ret := createGpuInstance(device, nvml.GPU_INSTANCE_PROFILE_1_SLICE, nvml.GpuInstancePlacement{Start: 0, Size: 1})
+	require.Equal(t, nvml.SUCCESS, ret)
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, allocation.Allocationstatus)
+	assert.Equal(t, uint32(1), allocation.Start)
+	require.Len(t, instaslice.Status.Prepared, 1)
+	for _, prepared := range instaslice.Status.Prepared {
+		assert.Equal(t, uint32(1), prepared.Start)
+	}
+	assert.Len(t, device.GpuInstances, 2)
+}
+
+func TestCreateSlicesInBatchRetriesAtAlternatePlacement(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	_, ret := createGpuInstance(device, nvml.GPU_INSTANCE_PROFILE_1_SLICE, nvml.GpuInstancePlacement{Start: 1, Size: 1})
+	require.Equal(t, nvml.SUCCESS, ret)
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, uint32(0), instaslice.Spec.Allocations["pod-uid-0"].Start)
+	// the placement of pod-0 is promised, pod-1 moves past it
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-1"].Allocationstatus)
+	assert.Equal(t, uint32(2), instaslice.Spec.Allocations["pod-uid-1"].Start)
+	for _, prepared := range instaslice.Status.Prepared {
+		assert.Equal(t, instaslice.Spec.Allocations[prepared.PodUUID].Start, prepared.Start)
+	}
+}
+
+func TestCreateGpuInstanceWithRetryIsBounded(t *testing.T) {
+	_, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	// every placement is reported taken, as when other processes keep carving slices
+	attempts := 0
+	device.CreateGpuInstanceWithPlacementFunc = func(*nvml.GpuInstanceProfileInfo, *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		attempts++
+		return nil, nvml.ERROR_INSUFFICIENT_RESOURCES
+	}
+
+	_, ret := createGpuInstanceWithRetry(ctx, device, instaslice, instaslice.Spec.Allocations["pod-uid-0"], nvml.GpuInstancePlacement{Start: 0, Size: 1})
+	assert.Equal(t, nvml.ERROR_INSUFFICIENT_RESOURCES, ret)
+	assert.Equal(t, 1+maxPlacementRetries, attempts)
+}
+
+// withoutPossiblePlacementAt makes the GPU stop offering the placements of the given start.
+func withoutPossiblePlacementAt(device *dgxa100.Device, start uint32) {
+	possiblePlacements := device.GetGpuInstancePossiblePlacementsFunc
+	device.GetGpuInstancePossiblePlacementsFunc = func(info *nvml.GpuInstanceProfileInfo) ([]nvml.GpuInstancePlacement, nvml.Return) {
+		placements, ret := possiblePlacements(info)
+		var offered []nvml.GpuInstancePlacement
+		for _, placement := range placements {
+			if placement.Start != start {
+				offered = append(offered, placement)
+			}
+		}
+		return offered, ret
+	}
+}
+
+func TestCarveSliceDefersPlacementNoLongerPossible(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	withoutPossiblePlacementAt(device, 0)
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, placementNotPossibleRetryInterval, result.RequeueAfter)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Empty(t, instaslice.Status.Prepared)
+	assert.Empty(t, device.GpuInstances)
+}
+
+func TestCreateSlicesInBatchDefersPlacementNoLongerPossible(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	withoutPossiblePlacementAt(device, 1)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+
+	_, err := reconciler.createSlicesInBatch(ctx, "node-1", &instaslice)
+	retryAfter, deferred := sliceOperationRetryAfter(err)
+	assert.True(t, deferred)
+	assert.Equal(t, placementNotPossibleRetryInterval, retryAfter)
+
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Spec.Allocations["pod-uid-1"].Allocationstatus)
+	assert.Len(t, device.GpuInstances, 1)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"math"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// PlacementSet tells which memory slices of a GPU are taken, with one entry per slice offset from 0 to the number
+// of memory slices of the GPU. Regions are given by the offset they start at and the number of memory slices they
+// span; the memory slices of a region past the GPU are ignored. Being a []bool, a PlacementSet is passed as is to
+// PlacementSelector implementations.
+type PlacementSet []bool
+
+// NewPlacementSet returns the placements of a GPU with the given number of memory slices, all of them free.
+func NewPlacementSet(memorySlices int) PlacementSet {
+	return make(PlacementSet, memorySlices)
+}
+
+// Clone returns a copy of the set that can be changed without changing the set.
+func (s PlacementSet) Clone() PlacementSet {
+	return append(PlacementSet(nil), s...)
+}
+
+// Occupy marks the memory slices of the region as taken.
+func (s PlacementSet) Occupy(start, size uint32) {
+	s.set(start, size, true)
+}
+
+// Free marks the memory slices of the region as free.
+func (s PlacementSet) Free(start, size uint32) {
+	s.set(start, size, false)
+}
+
+// Overlaps reports whether any memory slice of the region is taken.
+func (s PlacementSet) Overlaps(start, size uint32) bool {
+	for i := uint64(start); i < uint64(start)+uint64(size) && i < uint64(len(s)); i++ {
+		if s[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Fits reports whether a slice could be carved at the region, spanning at least one memory slice, none of them
+// taken or past the GPU.
+func (s PlacementSet) Fits(start, size uint32) bool {
+	return size > 0 && uint64(start)+uint64(size) <= uint64(len(s)) && !s.Overlaps(start, size)
+}
+
+// LargestFreeRegion returns the largest region of contiguous free memory slices, the first one when several are
+// as large. Its size is 0 when every memory slice is taken.
+func (s PlacementSet) LargestFreeRegion() inferencev1alpha1.SliceRange {
+	var largest inferencev1alpha1.SliceRange
+	for _, region := range s.regions(false) {
+		if region.Size > largest.Size {
+			largest = region
+		}
+	}
+	return largest
+}
+
+// CanFit reports whether size contiguous memory slices are free, regardless of the placements a profile of that
+// size offers.
+func (s PlacementSet) CanFit(size uint32) bool {
+	return size > 0 && s.LargestFreeRegion().Size >= size
+}
+
+// regions returns the regions of contiguous memory slices that are taken, or free, ordered by start.
+func (s PlacementSet) regions(taken bool) []inferencev1alpha1.SliceRange {
+	var regions []inferencev1alpha1.SliceRange
+	for i := 0; i < len(s); i++ {
+		if s[i] != taken {
+			continue
+		}
+		start := i
+		for i < len(s) && s[i] == taken {
+			i++
+		}
+		regions = append(regions, inferencev1alpha1.SliceRange{Start: uint32(start), Size: uint32(i - start)})
+	}
+	return regions
+}
+
+// placementRegion returns the region of the placement, false when no GPU could have one at its offsets.
+func placementRegion(placement inferencev1alpha1.Placement) (uint32, uint32, bool) {
+	if placement.Start < 0 || placement.Size <= 0 || placement.Start > math.MaxUint32 || placement.Size > math.MaxUint32 {
+		return 0, 0, false
+	}
+	return uint32(placement.Start), uint32(placement.Size), true
+}
+
+// set marks the memory slices of the region.
+func (s PlacementSet) set(start, size uint32, taken bool) {
+	for i := uint64(start); i < uint64(start)+uint64(size) && i < uint64(len(s)); i++ {
+		s[i] = taken
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// placementSetOf returns a set of 8 memory slices with the given offsets taken.
+func placementSetOf(taken ...int) PlacementSet {
+	set := NewPlacementSet(gpuMemorySlices)
+	for _, offset := range taken {
+		set[offset] = true
+	}
+	return set
+}
+
+func TestPlacementSetOccupyAndFree(t *testing.T) {
+	tests := []struct {
+		name   string
+		set    PlacementSet
+		start  uint32
+		size   uint32
+		occupy bool
+		want   PlacementSet
+	}{
+		{"occupy first slice", placementSetOf(), 0, 1, true, placementSetOf(0)},
+		{"occupy last slice", placementSetOf(), 7, 1, true, placementSetOf(7)},
+		{"occupy whole GPU", placementSetOf(), 0, 8, true, placementSetOf(0, 1, 2, 3, 4, 5, 6, 7)},
+		{"occupy past the GPU is clipped", placementSetOf(), 6, 4, true, placementSetOf(6, 7)},
+		{"occupy at the max offset is ignored", placementSetOf(), math.MaxUint32, 1, true, placementSetOf()},
+		{"occupy the max size does not wrap", placementSetOf(), 1, math.MaxUint32, true, placementSetOf(1, 2, 3, 4, 5, 6, 7)},
+		{"occupy nothing", placementSetOf(), 3, 0, true, placementSetOf()},
+		{"occupy taken slices", placementSetOf(2), 1, 2, true, placementSetOf(1, 2)},
+		{"free first slice", placementSetOf(0, 1), 0, 1, false, placementSetOf(1)},
+		{"free last slice", placementSetOf(6, 7), 7, 1, false, placementSetOf(6)},
+		{"free past the GPU is clipped", placementSetOf(5, 6, 7), 6, 4, false, placementSetOf(5)},
+		{"free at the max offset is ignored", placementSetOf(7), math.MaxUint32, math.MaxUint32, false, placementSetOf(7)},
+		{"free free slices", placementSetOf(4), 0, 4, false, placementSetOf(4)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.occupy {
+				tt.set.Occupy(tt.start, tt.size)
+			} else {
+				tt.set.Free(tt.start, tt.size)
+			}
+			assert.Equal(t, tt.want, tt.set)
+		})
+	}
+}
+
+func TestPlacementSetOverlapsAndFits(t *testing.T) {
+	tests := []struct {
+		name     string
+		set      PlacementSet
+		start    uint32
+		size     uint32
+		overlaps bool
+		fits     bool
+	}{
+		{"empty GPU at offset 0", placementSetOf(), 0, 1, false, true},
+		{"empty GPU whole", placementSetOf(), 0, 8, false, true},
+		{"empty GPU last slice", placementSetOf(), 7, 1, false, true},
+		{"taken first slice", placementSetOf(0), 0, 1, true, false},
+		{"taken last slice", placementSetOf(7), 7, 1, true, false},
+		{"adjacent before", placementSetOf(4), 2, 2, false, true},
+		{"adjacent after", placementSetOf(3), 4, 4, false, true},
+		{"partial overlap at the start", placementSetOf(2, 3), 3, 2, true, false},
+		{"partial overlap at the end", placementSetOf(4, 5), 2, 3, true, false},
+		{"region holding a taken slice", placementSetOf(5), 4, 4, true, false},
+		{"past the GPU", placementSetOf(), 6, 4, false, false},
+		{"past the GPU over a taken slice", placementSetOf(7), 6, 4, true, false},
+		{"at the GPU end", placementSetOf(), 8, 1, false, false},
+		{"empty region", placementSetOf(), 0, 0, false, false},
+		{"max offset", placementSetOf(), math.MaxUint32, 1, false, false},
+		{"max size does not wrap", placementSetOf(0), 1, math.MaxUint32, false, false},
+		{"max offset and size do not wrap", placementSetOf(0, 1, 2, 3, 4, 5, 6, 7), math.MaxUint32, math.MaxUint32, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.overlaps, tt.set.Overlaps(tt.start, tt.size))
+			assert.Equal(t, tt.fits, tt.set.Fits(tt.start, tt.size))
+		})
+	}
+}
+
+func TestPlacementSetLargestFreeRegionAndCanFit(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     PlacementSet
+		largest inferencev1alpha1.SliceRange
+		canFit  map[uint32]bool
+	}{
+		{"empty GPU", placementSetOf(), inferencev1alpha1.SliceRange{Start: 0, Size: 8}, map[uint32]bool{0: false, 1: true, 8: true, 9: false}},
+		{"full GPU", placementSetOf(0, 1, 2, 3, 4, 5, 6, 7), inferencev1alpha1.SliceRange{}, map[uint32]bool{1: false}},
+		{"free at offset 0 only", placementSetOf(1, 2, 3, 4, 5, 6, 7), inferencev1alpha1.SliceRange{Start: 0, Size: 1}, map[uint32]bool{1: true, 2: false}},
+		{"free at the last offset only", placementSetOf(0, 1, 2, 3, 4, 5, 6), inferencev1alpha1.SliceRange{Start: 7, Size: 1}, map[uint32]bool{1: true, 2: false}},
+		{"largest region at the end", placementSetOf(1, 3), inferencev1alpha1.SliceRange{Start: 4, Size: 4}, map[uint32]bool{4: true, 5: false}},
+		{"first of equally large regions", placementSetOf(3, 4), inferencev1alpha1.SliceRange{Start: 0, Size: 3}, map[uint32]bool{3: true, 4: false}},
+		{"fragmented", placementSetOf(1, 3, 5, 7), inferencev1alpha1.SliceRange{Start: 0, Size: 1}, map[uint32]bool{1: true, 2: false, math.MaxUint32: false}},
+		{"no memory slice", NewPlacementSet(0), inferencev1alpha1.SliceRange{}, map[uint32]bool{1: false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.largest, tt.set.LargestFreeRegion())
+			for size, want := range tt.canFit {
+				assert.Equal(t, want, tt.set.CanFit(size), "size %d", size)
+			}
+		})
+	}
+}
+
+func TestPlacementSetCloneIsIndependent(t *testing.T) {
+	set := placementSetOf(2)
+	clone := set.Clone()
+	clone.Occupy(0, 2)
+	assert.Equal(t, placementSetOf(2), set)
+	assert.Equal(t, placementSetOf(0, 1, 2), clone)
+}
+
+func TestPlacementRegionRejectsOffsetsOutsideTheOffsetSpace(t *testing.T) {
+	tests := []struct {
+		name      string
+		placement inferencev1alpha1.Placement
+		ok        bool
+	}{
+		{"offset 0", inferencev1alpha1.Placement{Start: 0, Size: 1}, true},
+		{"max offset", inferencev1alpha1.Placement{Start: math.MaxUint32, Size: 1}, true},
+		{"negative start", inferencev1alpha1.Placement{Start: -1, Size: 1}, false},
+		{"empty", inferencev1alpha1.Placement{Start: 0, Size: 0}, false},
+		{"start past the max offset", inferencev1alpha1.Placement{Start: math.MaxUint32 + 1, Size: 1}, false},
+		{"size past the max offset", inferencev1alpha1.Placement{Start: 0, Size: math.MaxUint32 + 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, ok := placementRegion(tt.placement)
+			assert.Equal(t, tt.ok, ok)
+			// none of them is on a GPU but the first one
+			assert.Equal(t, tt.placement.Start == 0 && tt.ok, validPlacement(tt.placement))
+			assert.Equal(t, tt.placement.Start == 0 && tt.ok, placementIsFree(tt.placement, NewPlacementSet(gpuMemorySlices)))
+		})
+	}
+}
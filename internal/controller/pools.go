@@ -0,0 +1,252 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// SlicePoolPrefix prefixes the key, pod UUID and pod name of the allocations holding the slices of the pools.
+	// Pod UIDs never start with it.
+	SlicePoolPrefix = "pool-"
+	// SlicePoolCreator is the creator recorded on the allocations of the pools.
+	SlicePoolCreator = "instaslice-daemonset"
+)
+
+// isPoolAllocation reports whether the allocation holds a slice of a pool rather than the slice of a pod.
+func isPoolAllocation(allocation inferencev1alpha1.AllocationDetails) bool {
+	return strings.HasPrefix(allocation.PodUUID, SlicePoolPrefix)
+}
+
+// poolAllocationName returns the key, pod UUID and pod name of the allocation holding the index-th slice of the
+// pool of the profile.
+func poolAllocationName(profile string, index int) string {
+	return fmt.Sprintf("%s%s-%d", SlicePoolPrefix, profile, index)
+}
+
+// poolReplicas returns how many idle slices of the profile the pools of the instaslice keep.
+func poolReplicas(instaslice *inferencev1alpha1.Instaslice, profile string) int {
+	replicas := 0
+	for _, pool := range instaslice.Spec.SlicePools {
+		if pool.Profile == profile {
+			replicas += pool.Replicas
+		}
+	}
+	return replicas
+}
+
+// poolSliceExcess reports whether the allocation holds a slice its pool no longer wants, e.g. once the pool
+// shrank or was removed.
+func poolSliceExcess(instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) bool {
+	suffix := strings.TrimPrefix(allocation.PodUUID, SlicePoolPrefix+allocation.Profile+"-")
+	index, err := strconv.Atoi(suffix)
+	return err != nil || index >= poolReplicas(instaslice, allocation.Profile)
+}
+
+// pooledSlices returns, per profile of the pools, how many idle slices are carved and waiting for a pod.
+func pooledSlices(instaslice *inferencev1alpha1.Instaslice) map[string]int {
+	pooled := make(map[string]int)
+	for _, pool := range instaslice.Spec.SlicePools {
+		pooled[pool.Profile] = 0
+	}
+	for key, allocation := range instaslice.Spec.Allocations {
+		if key == allocation.PodUUID && isPoolAllocation(allocation) && allocation.Allocationstatus == inferencev1alpha1.AllocationStatusRetained &&
+			!retainedSliceClaimed(instaslice, key, "") {
+			pooled[allocation.Profile]++
+		}
+	}
+	return pooled
+}
+
+// newPoolAllocation places a slice of the pool of the profile at the first free placement of the first GPU, in
+// UUID order, that takes new slices of the profile. It returns false when no GPU has room left.
+func newPoolAllocation(instaslice *inferencev1alpha1.Instaslice, mig inferencev1alpha1.Mig, name string) (inferencev1alpha1.AllocationDetails, bool) {
+	gpuUUIDs := make([]string, 0, len(instaslice.Spec.MigGPUUUID))
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		gpuUUIDs = append(gpuUUIDs, gpuUUID)
+	}
+	sort.Strings(gpuUUIDs)
+	for _, gpuUUID := range gpuUUIDs {
+		if !gpuTakesNewSlices(instaslice, gpuUUID) || !gpuAllowsProfile(instaslice, gpuUUID, mig.Profile) {
+			continue
+		}
+		free := freePlacementsFor(mig.Placements, occupiedIndexes(instaslice, gpuUUID))
+		if len(free) == 0 {
+			continue
+		}
+		return inferencev1alpha1.AllocationDetails{
+			Profile:          mig.Profile,
+			Start:            uint32(free[0].Start),
+			Size:             uint32(free[0].Size),
+			PodUUID:          name,
+			PodName:          name,
+			GPUUUID:          gpuUUID,
+			Nodename:         instaslice.Name,
+			Allocationstatus: inferencev1alpha1.AllocationStatusCreating,
+			Giprofileid:      mig.Giprofileid,
+			CIProfileID:      mig.CIProfileID,
+			CIEngProfileID:   mig.CIEngProfileID,
+			Creator:          SlicePoolCreator,
+		}, true
+	}
+	return inferencev1alpha1.AllocationDetails{}, false
+}
+
+// reservePoolSlices adds an allocation in creating for every slice missing from the pools, so that the
+// controller places no pod on the indexes before the slice is carved. Allocations of the pools whose profile
+// the GPUs no longer support are dropped, they could never be carved.
+func reservePoolSlices(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) bool {
+	supported := make(map[string]inferencev1alpha1.Mig, len(instaslice.Spec.Migplacement))
+	for _, mig := range instaslice.Spec.Migplacement {
+		supported[mig.Profile] = mig
+	}
+	changed := false
+	for key, allocation := range instaslice.Spec.Allocations {
+		if _, exists := supported[allocation.Profile]; !exists && isPoolAllocation(allocation) && allocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreating {
+			delete(instaslice.Spec.Allocations, key)
+			changed = true
+		}
+	}
+	for _, pool := range instaslice.Spec.SlicePools {
+		mig, exists := supported[pool.Profile]
+		if !exists {
+			log.FromContext(ctx).Info("pool profile is not supported by the GPUs of the node", "profile", pool.Profile)
+			continue
+		}
+		for i := 0; i < poolReplicas(instaslice, pool.Profile); i++ {
+			name := poolAllocationName(pool.Profile, i)
+			if _, exists := instaslice.Spec.Allocations[name]; exists {
+				continue
+			}
+			allocation, placed := newPoolAllocation(instaslice, mig, name)
+			if !placed {
+				log.FromContext(ctx).Info("no room left for the slices of the pool", "profile", pool.Profile, "missing", poolReplicas(instaslice, pool.Profile)-i)
+				break
+			}
+			if instaslice.Spec.Allocations == nil {
+				instaslice.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
+			}
+			instaslice.Spec.Allocations[name] = allocation
+			changed = true
+		}
+	}
+	return changed
+}
+
+// fillSlicePools carves the slices missing from the pools of the instaslice. They are recorded as retained
+// slices belonging to no pod, which the controller hands over to the next pod of the profile, and are kept
+// until their pool no longer wants them. It reports whether the instaslice changed.
+func (r *InstaSliceDaemonsetReconciler) fillSlicePools(ctx context.Context, nodeName string, instaslice *inferencev1alpha1.Instaslice) (bool, error) {
+	if instaslice.Status.Processed != "true" {
+		return false, nil
+	}
+	reserved := false
+	latest, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+		reserved = reservePoolSlices(ctx, latest)
+		if !reserved {
+			return errInstasliceUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	var pending []inferencev1alpha1.AllocationDetails
+	for key, allocation := range latest.Spec.Allocations {
+		if key == allocation.PodUUID && isPoolAllocation(allocation) && allocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreating {
+			pending = append(pending, allocation)
+		}
+	}
+	if len(pending) == 0 {
+		return reserved, nil
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].PodUUID < pending[j].PodUUID
+	})
+
+	nvmllib := r.handler().nvml
+	for _, allocation := range pending {
+		if err := r.carvePoolSlice(ctx, nvmllib, latest, allocation); err != nil {
+			return true, err
+		}
+	}
+	return true, r.updateNodeCapacity(ctx, nodeName)
+}
+
+// carvePoolSlice carves the slice of an allocation of a pool and records it as retained.
+func (r *InstaSliceDaemonsetReconciler) carvePoolSlice(ctx context.Context, nvmllib nvml.Interface, instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) error {
+	device, ret := nvmllib.DeviceGetHandleByUUID(allocation.GPUUUID)
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to get GPU %s: %v", allocation.GPUUUID, ret)
+	}
+	if err := r.reserveDeferrableSliceOperation(); err != nil {
+		return err
+	}
+	placement := nvml.GpuInstancePlacement{Start: allocation.Start, Size: allocation.Size}
+	created, err := r.carveSliceWithIntent(ctx, device, *instaslice, allocation, placement)
+	if err != nil {
+		return fmt.Errorf("unable to carve %s slice of pool: %w", allocation.Profile, err)
+	}
+	// the placement of the allocation was taken, the slice is recorded where it was carved instead
+	if created.relocated {
+		allocation.Start = created.start
+	}
+	if _, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+		current, exists := latest.Spec.Allocations[allocation.PodUUID]
+		if !exists || current.Allocationstatus != inferencev1alpha1.AllocationStatusCreating {
+			return errInstasliceUnchanged
+		}
+		if latest.Status.Prepared == nil {
+			latest.Status.Prepared = make(map[string]inferencev1alpha1.PreparedDetails)
+		}
+		for _, ci := range created.migDevices() {
+			latest.Status.Prepared[ci.miguuid] = inferencev1alpha1.PreparedDetails{
+				Profile:  allocation.Profile,
+				Start:    allocation.Start,
+				Size:     allocation.Size,
+				Parent:   allocation.GPUUUID,
+				PodUUID:  allocation.PodUUID,
+				Giinfoid: created.gid,
+				Ciinfoid: ci.cid,
+				GPUModel: latest.Spec.MigGPUUUID[allocation.GPUUUID],
+				CarvedBy: r.operatorVersion(),
+			}
+		}
+		retainedAt := now()
+		current.Start = allocation.Start
+		current.Allocationstatus = inferencev1alpha1.AllocationStatusRetained
+		current.RetainedAt = &retainedAt
+		latest.Spec.Allocations[allocation.PodUUID] = current
+		return nil
+	}); err != nil {
+		return err
+	}
+	r.slices.retain(created.miguuid, created)
+	log.FromContext(ctx).Info("carved slice of pool", "profile", allocation.Profile, "gpu", allocation.GPUUUID, "migUUID", created.visibleDevices())
+	// the prepared entries now track the slice
+	return r.clearSliceIntents(ctx, instaslice.Name, allocation.PodUUID)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// setSlicePools replaces the pools of the instaslice of node-1.
+func setSlicePools(t *testing.T, c client.Client, pools ...inferencev1alpha1.SlicePool) {
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	instaslice.Spec.SlicePools = pools
+	require.NoError(t, c.Update(context.Background(), &instaslice))
+}
+
+// reconcileUntilIdle runs the reconciler until it no longer asks to be requeued right away.
+func reconcileUntilIdle(t *testing.T, reconciler *InstaSliceDaemonsetReconciler) {
+	for i := 0; i < 10; i++ {
+		result, err := reconciler.Reconcile(context.Background(), ctrl.Request{})
+		require.NoError(t, err)
+		if !result.Requeue {
+			return
+		}
+	}
+	t.Fatal("reconciler kept requeueing")
+}
+
+func TestSlicePoolIsCarvedAndAdvertised(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t)
+	reconciler.ExportCapabilities = true
+	ctx := context.Background()
+	setSlicePools(t, fakeClient, inferencev1alpha1.SlicePool{Profile: "1g.5gb", Replicas: 3})
+
+	reconcileUntilIdle(t, reconciler)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	poolSlices := []string{"pool-1g.5gb-0", "pool-1g.5gb-1", "pool-1g.5gb-2"}
+	for i, name := range poolSlices {
+		allocation, exists := instaslice.Spec.Allocations[name]
+		require.True(t, exists, name)
+		assert.Equal(t, inferencev1alpha1.AllocationStatusRetained, allocation.Allocationstatus)
+		assert.Equal(t, uint32(i), allocation.Start)
+		assert.Equal(t, device.UUID, allocation.GPUUUID)
+		assert.Equal(t, SlicePoolCreator, allocation.Creator)
+	}
+	require.Len(t, instaslice.Status.Prepared, 3)
+	giIDs := make(map[uint32]bool)
+	for _, prepared := range instaslice.Status.Prepared {
+		assert.Equal(t, "1g.5gb", prepared.Profile)
+		giIDs[prepared.Giinfoid] = true
+	}
+	assert.Len(t, giIDs, 3)
+	assert.Len(t, device.GpuInstances, 3)
+	assert.Empty(t, instaslice.Status.SliceIntents)
+
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "instaslice-capabilities-node-1", Namespace: "default"}, &configMap))
+	pooled := make(map[string]int)
+	require.NoError(t, json.Unmarshal([]byte(configMap.Data[CapabilitiesPooledKey]), &pooled))
+	assert.Equal(t, map[string]int{"1g.5gb": 3}, pooled)
+	free := make(map[string]int)
+	require.NoError(t, json.Unmarshal([]byte(configMap.Data[CapabilitiesFreeKey]), &free))
+	assert.Equal(t, 4, free["1g.5gb"])
+
+	// a pod of the profile is handed a slice of the pool
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+	allocation, err := r.findDeviceForASlice(&instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Contains(t, poolSlices, allocation.ReusedFrom)
+}
+
+func TestSlicePoolShrinks(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t)
+	ctx := context.Background()
+	setSlicePools(t, fakeClient, inferencev1alpha1.SlicePool{Profile: "1g.5gb", Replicas: 2})
+	reconcileUntilIdle(t, reconciler)
+	require.Len(t, device.GpuInstances, 2)
+
+	// the slices the pool no longer wants are destroyed
+	setSlicePools(t, fakeClient)
+	reconcileUntilIdle(t, reconciler)
+	reconcileUntilIdle(t, reconciler)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Empty(t, instaslice.Spec.Allocations)
+	assert.Empty(t, instaslice.Status.Prepared)
+	assert.Empty(t, device.GpuInstances)
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// EvictableAnnotation set to true lets the slice of a pod be preempted by pods of higher priority.
+	EvictableAnnotation = "org.instaslice/evictable"
+	// EventReasonSlicePreempted is the reason of the event emitted on a pod whose slice is preempted.
+	EventReasonSlicePreempted = "SlicePreempted"
+)
+
+// errPreemptionRaced is returned when the allocations picked for preemption changed before they were marked.
+var errPreemptionRaced = errors.New("allocations to preempt changed")
+
+// podPriority returns the priority of the pod, zero when it has none.
+func podPriority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
+
+// recordPreemptionPolicy records on the allocation whether the pod agreed to be preempted, its priority and its
+// QoS class.
+func recordPreemptionPolicy(allocDetails *inferencev1alpha1.AllocationDetails, pod *v1.Pod) {
+	allocDetails.Evictable = pod.Annotations[EvictableAnnotation] == "true"
+	allocDetails.Priority = podPriority(pod)
+	allocDetails.QOSClass = string(pod.Status.QOSClass)
+}
+
+// qosRank orders the QoS classes from the first preempted, BestEffort, to the last, Guaranteed. An unknown class
+// ranks as Burstable.
+func qosRank(qosClass string) int {
+	switch v1.PodQOSClass(qosClass) {
+	case v1.PodQOSBestEffort:
+		return 0
+	case v1.PodQOSGuaranteed:
+		return 2
+	}
+	return 1
+}
+
+// preemptionPending reports whether slices preempted earlier are still being torn down on the node.
+func preemptionPending(instaslice *inferencev1alpha1.Instaslice) bool {
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.Allocationstatus == inferencev1alpha1.AllocationStatusPreempted {
+			return true
+		}
+	}
+	return false
+}
+
+// preemptionCandidates returns the realized evictable allocations of the GPU of a lower priority than the pod,
+// lowest priority first and, among the same priority, by QoS class.
+func preemptionCandidates(instaslice *inferencev1alpha1.Instaslice, gpuUUID string, pod *v1.Pod) []inferencev1alpha1.AllocationDetails {
+	var candidates []inferencev1alpha1.AllocationDetails
+	for key, allocation := range instaslice.Spec.Allocations {
+		if key != allocation.PodUUID || allocation.GPUUUID != gpuUUID || !allocation.Evictable || !settledAllocation(allocation) {
+			continue
+		}
+		if allocation.Priority < podPriority(pod) {
+			candidates = append(candidates, allocation)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Priority != candidates[j].Priority {
+			return candidates[i].Priority < candidates[j].Priority
+		}
+		if qosRank(candidates[i].QOSClass) != qosRank(candidates[j].QOSClass) {
+			return qosRank(candidates[i].QOSClass) < qosRank(candidates[j].QOSClass)
+		}
+		return candidates[i].PodUUID < candidates[j].PodUUID
+	})
+	return candidates
+}
+
+// preemptionVictims returns the fewest evictable allocations of a single GPU of the node whose slices, once torn
+// down, leave room for a slice of the profile for the pod. Allocations the PreemptionGuard keeps are passed over.
+// Nothing is returned when no such allocations exist.
+func (r *InstasliceReconciler) preemptionVictims(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, profileName string, pod *v1.Pod) ([]inferencev1alpha1.AllocationDetails, error) {
+	gpuUUIDs := make([]string, 0, len(instaslice.Spec.MigGPUUUID))
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		gpuUUIDs = append(gpuUUIDs, gpuUUID)
+	}
+	sort.Strings(gpuUUIDs)
+	var victims []inferencev1alpha1.AllocationDetails
+	for _, gpuUUID := range gpuUUIDs {
+		// room made on a GPU taking no new slices, or none of the profile or the namespace, would not be used
+		if !gpuTakesNewSlices(instaslice, gpuUUID) || !gpuAllowsProfile(instaslice, gpuUUID, profileName) ||
+			!gpuAdmitsNamespace(instaslice, gpuUUID, pod.Namespace) {
+			continue
+		}
+		remaining := instaslice.DeepCopy()
+		var evicted []inferencev1alpha1.AllocationDetails
+		for _, candidate := range preemptionCandidates(instaslice, gpuUUID, pod) {
+			if victims != nil && len(evicted)+1 >= len(victims) {
+				break
+			}
+			if r.PreemptionGuard != nil {
+				allowed, err := r.PreemptionGuard.AllowsPreemption(ctx, candidate, evicted)
+				if err != nil {
+					return nil, err
+				}
+				if !allowed {
+					log.FromContext(ctx).Info("preemption guard keeps slice of ", "pod", candidate.PodName, "namespace", candidate.Namespace)
+					continue
+				}
+			}
+			delete(remaining.Spec.Allocations, candidate.PodUUID)
+			for migUUID, prepared := range remaining.Status.Prepared {
+				if prepared.PodUUID == candidate.PodUUID {
+					delete(remaining.Status.Prepared, migUUID)
+				}
+			}
+			evicted = append(evicted, candidate)
+			//size cannot be 9 atleast for A100s 40GB/80GB and H100 variants
+			if r.getStartIndexFromPreparedState(remaining, gpuUUID, profileName, reserveFor(remaining, pod)) != uint32(9) {
+				victims = evicted
+				break
+			}
+		}
+	}
+	return victims, nil
+}
+
+// preemptSlices marks the allocations preempted for the daemonset to tear down their slices, provided they did
+// not change since they were picked, and notifies their pods.
+func (r *InstasliceReconciler) preemptSlices(ctx context.Context, instasliceName string, victims []inferencev1alpha1.AllocationDetails, pod *v1.Pod) error {
+	var latest inferencev1alpha1.Instaslice
+	if err := r.Get(ctx, types.NamespacedName{Name: instasliceName, Namespace: r.instasliceNamespace()}, &latest); err != nil {
+		return err
+	}
+	for _, victim := range victims {
+		current, exists := latest.Spec.Allocations[victim.PodUUID]
+		if !exists || current.Allocationstatus != victim.Allocationstatus {
+			return errPreemptionRaced
+		}
+		current.Allocationstatus = inferencev1alpha1.AllocationStatusPreempted
+		latest.Spec.Allocations[victim.PodUUID] = current
+	}
+	if err := r.Update(ctx, &latest); err != nil {
+		return err
+	}
+	for _, victim := range victims {
+		log.FromContext(ctx).Info("preempting slice of ", "pod", victim.PodName, "namespace", victim.Namespace,
+			"profile", victim.Profile, "gpu", victim.GPUUUID, "for", pod.Name)
+		if r.Recorder == nil {
+			continue
+		}
+		victimPod := &v1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Name:       victim.PodName,
+			Namespace:  victim.Namespace,
+			UID:        types.UID(victim.PodUUID),
+		}
+		r.Recorder.Eventf(victimPod, v1.EventTypeWarning, EventReasonSlicePreempted,
+			"The %s slice on GPU %s is preempted by pod %s/%s of higher priority", victim.Profile, victim.GPUUUID, pod.Namespace, pod.Name)
+	}
+	return nil
+}
+
+// preemptForPod tears down evictable slices of lower priority on the first node where doing so makes room for
+// the pod. It reports whether the pod should wait for slices to be torn down, including ones preempted earlier.
+func (r *InstasliceReconciler) preemptForPod(ctx context.Context, instaslices []inferencev1alpha1.Instaslice, profileName string, pod *v1.Pod) (bool, error) {
+	for i := range instaslices {
+		instaslice := &instaslices[i]
+		// the room made by earlier preemptions is not visible until their slices are gone
+		if preemptionPending(instaslice) {
+			return true, nil
+		}
+		victims, err := r.preemptionVictims(ctx, instaslice, nodeProfileFor(instaslice, profileName), pod)
+		if err != nil {
+			return false, err
+		}
+		if len(victims) == 0 {
+			continue
+		}
+		if err := r.preemptSlices(ctx, instaslice.Name, victims, pod); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
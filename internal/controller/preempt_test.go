@@ -0,0 +1,176 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newPreemptTestInstaslice returns a GPU whose last 1g slot is held by the ungated slice of pod-low, the other
+// slots being taken by slices of no allocation.
+func newPreemptTestInstaslice(evictable bool) *inferencev1alpha1.Instaslice {
+	instaslice := newReserveTestInstaslice()
+	instaslice.Namespace = "default"
+	instaslice.Spec.ReservedSlicesPerGPU = 0
+	instaslice.Status.Prepared["MIG-7"] = inferencev1alpha1.PreparedDetails{Profile: "1g.5gb", Parent: "GPU-1", Start: 6, Size: 1, PodUUID: "pod-uid-low"}
+	instaslice.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{
+		"pod-uid-low": {
+			Profile: "1g.5gb", Start: 6, Size: 1, PodUUID: "pod-uid-low", GPUUUID: "GPU-1", Nodename: "node-1",
+			Allocationstatus: inferencev1alpha1.AllocationStatusUngated, Namespace: "default", PodName: "pod-low", Evictable: evictable, Priority: 10,
+		},
+	}
+	return instaslice
+}
+
+// newPreemptTestPod returns a gated pod requesting a 1g.5gb slice with the given priority.
+func newPreemptTestPod(priority int32) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-high", Namespace: "default", UID: "pod-uid-high"},
+		Spec: v1.PodSpec{
+			Priority:        &priority,
+			SchedulingGates: []v1.PodSchedulingGate{{Name: "org.instaslice/accelarator"}},
+			Containers: []v1.Container{{
+				Name: "workload",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{"nvidia.com/mig-1g.5gb": resource.MustParse("1")},
+				},
+			}},
+		},
+		Status: v1.PodStatus{
+			Phase:      v1.PodPending,
+			Conditions: []v1.PodCondition{{Type: v1.PodScheduled, Message: "Scheduling is blocked due to non-empty scheduling gates"}},
+		},
+	}
+}
+
+func newPreemptTestReconciler(t *testing.T, instaslice *inferencev1alpha1.Instaslice, pod *v1.Pod) (*InstasliceReconciler, client.Client, *record.FakeRecorder) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(instaslice, pod).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}, &v1.Pod{}).Build()
+	recorder := record.NewFakeRecorder(10)
+	return &InstasliceReconciler{Client: fakeClient, Scheme: s, Recorder: recorder}, fakeClient, recorder
+}
+
+func TestHigherPriorityPodPreemptsEvictableSliceForLastSlot(t *testing.T) {
+	reconciler, fakeClient, recorder := newPreemptTestReconciler(t, newPreemptTestInstaslice(true), newPreemptTestPod(100))
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "pod-high", Namespace: "default"}}
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	result, err := reconciler.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Second, result.RequeueAfter)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusPreempted, instaslice.Spec.Allocations["pod-uid-low"].Allocationstatus)
+	assert.NotContains(t, instaslice.Spec.Allocations, "pod-uid-high")
+	require.Len(t, recorder.Events, 1)
+	event := <-recorder.Events
+	assert.Contains(t, event, EventReasonSlicePreempted)
+	assert.Contains(t, event, "default/pod-high")
+
+	// the slot is taken until the daemonset tore the preempted slice down
+	_, err = reconciler.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Empty(t, recorder.Events)
+	delete(instaslice.Status.Prepared, "MIG-7")
+	require.NoError(t, fakeClient.Status().Update(ctx, &instaslice))
+	delete(instaslice.Spec.Allocations, "pod-uid-low")
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err = reconciler.Reconcile(ctx, req)
+	require.NoError(t, err)
+	var latest inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &latest))
+	require.Contains(t, latest.Spec.Allocations, "pod-uid-high")
+	allocation := latest.Spec.Allocations["pod-uid-high"]
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, allocation.Allocationstatus)
+	assert.Equal(t, uint32(6), allocation.Start)
+	assert.Equal(t, int32(100), allocation.Priority)
+	assert.False(t, allocation.Evictable)
+}
+
+func TestOnlyEvictableSlicesOfLowerPriorityArePreempted(t *testing.T) {
+	r := &InstasliceReconciler{}
+
+	ctx := context.Background()
+
+	victims, err := r.preemptionVictims(ctx, newPreemptTestInstaslice(false), "1g.5gb", newPreemptTestPod(100))
+	require.NoError(t, err)
+	assert.Empty(t, victims)
+	victims, err = r.preemptionVictims(ctx, newPreemptTestInstaslice(true), "1g.5gb", newPreemptTestPod(10))
+	require.NoError(t, err)
+	assert.Empty(t, victims)
+	victims, err = r.preemptionVictims(ctx, newPreemptTestInstaslice(true), "1g.5gb", newPreemptTestPod(11))
+	require.NoError(t, err)
+	require.Len(t, victims, 1)
+	assert.Equal(t, "pod-uid-low", victims[0].PodUUID)
+}
+
+func TestFindDeviceForASliceRecordsPreemptionPolicy(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := newPreemptTestPod(5)
+	pod.Annotations = map[string]string{EvictableAnnotation: "true"}
+	pod.Status.QOSClass = v1.PodQOSBurstable
+
+	allocation, err := r.findDeviceForASlice(newPlacementTestInstaslice(), "1g.5gb", &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.True(t, allocation.Evictable)
+	assert.Equal(t, int32(5), allocation.Priority)
+	assert.Equal(t, string(v1.PodQOSBurstable), allocation.QOSClass)
+}
+
+func TestDaemonsetTearsDownPreemptedSlice(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.Len(t, device.GpuInstances, 1)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusPreempted
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	assert.Empty(t, device.GpuInstances)
+	var latest inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &latest))
+	assert.Empty(t, latest.Status.Prepared)
+	assert.NotContains(t, latest.Spec.Allocations, "pod-uid-0")
+}
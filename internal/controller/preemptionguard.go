@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// PreemptionGuard decides whether the slice of an allocation may be preempted, on top of its pod being evictable
+// and of a lower priority than the pod waiting for a slice.
+type PreemptionGuard interface {
+	// AllowsPreemption reports whether the slice of the victim may be torn down, picked being the allocations
+	// already picked to make room for the same pod.
+	AllowsPreemption(ctx context.Context, victim inferencev1alpha1.AllocationDetails, picked []inferencev1alpha1.AllocationDetails) (bool, error)
+}
+
+// PodDisruptionBudgetGuard keeps the slice of a pod whose PodDisruptionBudgets allow no more disruptions, the pods
+// already picked counting as disrupted.
+type PodDisruptionBudgetGuard struct {
+	Client client.Reader
+}
+
+// AllowsPreemption refuses to preempt the slice of the victim when a PodDisruptionBudget selecting its pod allows
+// fewer disruptions than the victim and the picked pods it selects. The slice of a pod that is gone is preempted.
+func (g *PodDisruptionBudgetGuard) AllowsPreemption(ctx context.Context, victim inferencev1alpha1.AllocationDetails, picked []inferencev1alpha1.AllocationDetails) (bool, error) {
+	var pod v1.Pod
+	if err := g.Client.Get(ctx, types.NamespacedName{Name: victim.PodName, Namespace: victim.Namespace}, &pod); err != nil {
+		return apierrors.IsNotFound(err), client.IgnoreNotFound(err)
+	}
+	var budgets policyv1.PodDisruptionBudgetList
+	if err := g.Client.List(ctx, &budgets, client.InNamespace(victim.Namespace)); err != nil {
+		return false, err
+	}
+	var pickedPods []v1.Pod
+	for _, allocation := range picked {
+		if allocation.Namespace != victim.Namespace {
+			continue
+		}
+		var pickedPod v1.Pod
+		if err := g.Client.Get(ctx, types.NamespacedName{Name: allocation.PodName, Namespace: allocation.Namespace}, &pickedPod); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return false, err
+		}
+		pickedPods = append(pickedPods, pickedPod)
+	}
+	for _, budget := range budgets.Items {
+		selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+		if err != nil {
+			return false, fmt.Errorf("invalid selector of PodDisruptionBudget %s/%s: %w", budget.Namespace, budget.Name, err)
+		}
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		disruptions := int32(1)
+		for _, pickedPod := range pickedPods {
+			if selector.Matches(labels.Set(pickedPod.Labels)) {
+				disruptions++
+			}
+		}
+		if disruptions > budget.Status.DisruptionsAllowed {
+			return false, nil
+		}
+	}
+	return true, nil
+}
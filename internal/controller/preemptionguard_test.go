@@ -0,0 +1,148 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newGuardTestInstaslice returns a GPU whose last two 1g slots are held by the ungated slices of pod-guarded, of
+// priority 1, and pod-low, of priority 10, both evictable.
+func newGuardTestInstaslice() *inferencev1alpha1.Instaslice {
+	instaslice := newPreemptTestInstaslice(true)
+	instaslice.Status.Prepared["MIG-6"] = inferencev1alpha1.PreparedDetails{Profile: "1g.5gb", Parent: "GPU-1", Start: 5, Size: 1, PodUUID: "pod-uid-guarded"}
+	instaslice.Spec.Allocations["pod-uid-guarded"] = inferencev1alpha1.AllocationDetails{
+		Profile: "1g.5gb", Start: 5, Size: 1, PodUUID: "pod-uid-guarded", GPUUUID: "GPU-1", Nodename: "node-1",
+		Allocationstatus: inferencev1alpha1.AllocationStatusUngated, Namespace: "default", PodName: "pod-guarded", Evictable: true, Priority: 1,
+	}
+	return instaslice
+}
+
+// newGuardTestObjects returns the running pods of the slices of newGuardTestInstaslice and a PodDisruptionBudget
+// of pod-guarded allowing the given disruptions.
+func newGuardTestObjects(disruptionsAllowed int32) []client.Object {
+	return []client.Object{
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-guarded", Namespace: "default", Labels: map[string]string{"app": "guarded"}}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-low", Namespace: "default", Labels: map[string]string{"app": "low"}}},
+		&policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "guarded", Namespace: "default"},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "guarded"}}},
+			Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: disruptionsAllowed},
+		},
+	}
+}
+
+func TestHighPriorityPodReclaimsSliceRespectingDisruptionBudget(t *testing.T) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	objects := append(newGuardTestObjects(0), newGuardTestInstaslice(), newPreemptTestPod(100))
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(objects...).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}, &v1.Pod{}).Build()
+	reconciler := &InstasliceReconciler{Client: fakeClient, Scheme: s, PreemptionGuard: &PodDisruptionBudgetGuard{Client: fakeClient}}
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "pod-high", Namespace: "default"}})
+	require.NoError(t, err)
+
+	// the lowest priority slice is kept by its budget, the next one is reclaimed instead
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, instaslice.Spec.Allocations["pod-uid-guarded"].Allocationstatus)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusPreempted, instaslice.Spec.Allocations["pod-uid-low"].Allocationstatus)
+}
+
+func TestPreemptionGuardPassesOverProtectedSlices(t *testing.T) {
+	s := scheme.Scheme
+	ctx := context.Background()
+	instaslice := newGuardTestInstaslice()
+	pod := newPreemptTestPod(100)
+
+	// without a guard the lowest priority slice goes first
+	r := &InstasliceReconciler{}
+	victims, err := r.preemptionVictims(ctx, instaslice, "1g.5gb", pod)
+	require.NoError(t, err)
+	require.Len(t, victims, 1)
+	assert.Equal(t, "pod-uid-guarded", victims[0].PodUUID)
+
+	// a budget allowing a disruption lets it go
+	r.PreemptionGuard = &PodDisruptionBudgetGuard{Client: runtimefake.NewClientBuilder().WithScheme(s).WithObjects(newGuardTestObjects(1)...).Build()}
+	victims, err = r.preemptionVictims(ctx, instaslice, "1g.5gb", pod)
+	require.NoError(t, err)
+	require.Len(t, victims, 1)
+	assert.Equal(t, "pod-uid-guarded", victims[0].PodUUID)
+
+	// nothing is preempted when every candidate is protected
+	r.PreemptionGuard = &PodDisruptionBudgetGuard{Client: runtimefake.NewClientBuilder().WithScheme(s).WithObjects(newGuardTestObjects(0)...).Build()}
+	delete(instaslice.Spec.Allocations, "pod-uid-low")
+	victims, err = r.preemptionVictims(ctx, instaslice, "1g.5gb", pod)
+	require.NoError(t, err)
+	assert.Empty(t, victims)
+}
+
+func TestPodDisruptionBudgetGuardCountsPickedPods(t *testing.T) {
+	objects := newGuardTestObjects(1)
+	objects = append(objects, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-guarded-2", Namespace: "default", Labels: map[string]string{"app": "guarded"}}})
+	guard := &PodDisruptionBudgetGuard{Client: runtimefake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objects...).Build()}
+	ctx := context.Background()
+	victim := inferencev1alpha1.AllocationDetails{PodName: "pod-guarded", Namespace: "default"}
+
+	allowed, err := guard.AllowsPreemption(ctx, victim, nil)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// the single disruption allowed is taken by the other pod of the budget picked before
+	allowed, err = guard.AllowsPreemption(ctx, victim, []inferencev1alpha1.AllocationDetails{{PodName: "pod-guarded-2", Namespace: "default"}})
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	// pods of other budgets do not count, nor do pods that are gone
+	allowed, err = guard.AllowsPreemption(ctx, victim, []inferencev1alpha1.AllocationDetails{{PodName: "pod-low", Namespace: "default"}})
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, err = guard.AllowsPreemption(ctx, inferencev1alpha1.AllocationDetails{PodName: "pod-gone", Namespace: "default"}, nil)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestPreemptionCandidatesOrderQoSWithinPriority(t *testing.T) {
+	instaslice := newPreemptTestInstaslice(true)
+	for podUUID, qosClass := range map[string]v1.PodQOSClass{"pod-uid-a": v1.PodQOSGuaranteed, "pod-uid-b": v1.PodQOSBestEffort, "pod-uid-c": v1.PodQOSBurstable} {
+		instaslice.Spec.Allocations[podUUID] = inferencev1alpha1.AllocationDetails{
+			PodUUID: podUUID, GPUUUID: "GPU-1", Allocationstatus: inferencev1alpha1.AllocationStatusUngated, Evictable: true, Priority: 5, QOSClass: string(qosClass),
+		}
+	}
+
+	var order []string
+	for _, candidate := range preemptionCandidates(instaslice, "GPU-1", newPreemptTestPod(100)) {
+		order = append(order, candidate.PodUUID)
+	}
+	assert.Equal(t, []string{"pod-uid-b", "pod-uid-c", "pod-uid-a", "pod-uid-low"}, order)
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	v1 "k8s.io/api/core/v1"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// PreferredGPUAnnotation names the GPU, by UUID, a pod would rather have its slice carved on.
+// Unlike the GPU recorded in an allocation it is only a hint, the slice goes to another GPU when
+// the preferred one has no free placement.
+const PreferredGPUAnnotation = "org.instaslice/preferred-gpu"
+
+// preferredGPUFor returns the GPU the pod prefers, or an empty string when it has no preference.
+func preferredGPUFor(pod *v1.Pod) string {
+	if pod == nil {
+		return ""
+	}
+	return pod.Annotations[PreferredGPUAnnotation]
+}
+
+// recordPreferredGPU stores the preference of the pod on its allocation and whether it could not be honored.
+func recordPreferredGPU(allocation *inferencev1alpha1.AllocationDetails, preferred string) {
+	if preferred == "" {
+		return
+	}
+	allocation.PreferredGPUUUID = preferred
+	allocation.PreferredGPUFallback = allocation.GPUUUID != preferred
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newPreferredTestInstaslice returns a node with a full GPU-1 and a GPU-2 with one slice carved.
+func newPreferredTestInstaslice() *inferencev1alpha1.Instaslice {
+	instaslice := newPlacementTestInstaslice()
+	instaslice.Spec.MigGPUUUID["GPU-2"] = "NVIDIA A100-PCIE-40GB"
+	for i := 1; i < 7; i++ {
+		instaslice.Status.Prepared[fmt.Sprintf("MIG-%d", i+1)] = inferencev1alpha1.PreparedDetails{Profile: "1g.5gb", Parent: "GPU-1", Start: uint32(i), Size: 1}
+	}
+	instaslice.Status.Prepared["MIG-8"] = inferencev1alpha1.PreparedDetails{Profile: "1g.5gb", Parent: "GPU-2", Start: 0, Size: 1}
+	return instaslice
+}
+
+func newPreferredTestPod(gpuUUID string) *v1.Pod {
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "pod-1",
+		Namespace:   "default",
+		UID:         "pod-uid-1",
+		Annotations: map[string]string{PreferredGPUAnnotation: gpuUUID},
+	}}
+}
+
+func TestFindDeviceForASliceFallsBackFromFullPreferredGPU(t *testing.T) {
+	r := &InstasliceReconciler{}
+
+	allocation, err := r.findDeviceForASlice(newPreferredTestInstaslice(), "1g.5gb", &FirstFitPolicy{}, newPreferredTestPod("GPU-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "GPU-2", allocation.GPUUUID)
+	assert.Equal(t, uint32(1), allocation.Start)
+	assert.Equal(t, "GPU-1", allocation.PreferredGPUUUID)
+	assert.True(t, allocation.PreferredGPUFallback)
+}
+
+func TestFindDeviceForASlicePlacesOnPreferredGPU(t *testing.T) {
+	r := &InstasliceReconciler{}
+	// GPU-1 has free placements as well, the map order must not matter
+	for i := 0; i < 10; i++ {
+		allocation, err := r.findDeviceForASlice(newPlacementTestInstaslice(), "1g.5gb", &FirstFitPolicy{}, newPreferredTestPod("GPU-1"))
+		require.NoError(t, err)
+		assert.Equal(t, "GPU-1", allocation.GPUUUID)
+		assert.False(t, allocation.PreferredGPUFallback)
+
+		instaslice := newPlacementTestInstaslice()
+		instaslice.Spec.MigGPUUUID["GPU-2"] = "NVIDIA A100-PCIE-40GB"
+		allocation, err = r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, newPreferredTestPod("GPU-2"))
+		require.NoError(t, err)
+		assert.Equal(t, "GPU-2", allocation.GPUUUID)
+		assert.Equal(t, uint32(0), allocation.Start)
+		assert.False(t, allocation.PreferredGPUFallback)
+	}
+}
+
+func TestFindDeviceForASliceFallsBackFromUnknownPreferredGPU(t *testing.T) {
+	r := &InstasliceReconciler{}
+
+	allocation, err := r.findDeviceForASlice(newPlacementTestInstaslice(), "1g.5gb", &FirstFitPolicy{}, newPreferredTestPod("GPU-missing"))
+	require.NoError(t, err)
+	assert.Equal(t, "GPU-1", allocation.GPUUUID)
+	assert.True(t, allocation.PreferredGPUFallback)
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// how often Prepared entries are checked against the slices that exist on the GPUs.
+const preparedVerificationInterval = 1 * time.Minute
+
+const (
+	// EventReasonGhostSlicePruned is the reason of the event emitted on an instaslice whose prepared entry of a
+	// slice missing on the GPUs and held by no allocation was removed.
+	EventReasonGhostSlicePruned = "GhostSlicePruned"
+	// EventReasonSliceLost is the reason of the event emitted on a pod, and its instaslice, whose slice went
+	// missing on the GPUs and is carved again.
+	EventReasonSliceLost = "SliceLost"
+)
+
+// runPreparedVerification periodically prunes ghost Prepared entries and reclaims the slices of pods that are gone
+// or whose namespace was deleted until the context is cancelled.
+func (r *InstaSliceDaemonsetReconciler) runPreparedVerification(ctx context.Context, nodeName string) {
+	ticker := time.NewTicker(preparedVerificationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// the hardware cannot be listed before NVML is ready
+			if !r.nvmlNotReady.Load() {
+				if err := r.pruneGhostPreparedSlices(ctx, r.handler().nvml, nodeName); err != nil {
+					log.FromContext(ctx).Error(err, "unable to verify prepared slices against hardware")
+				}
+			}
+			if err := r.reclaimAllocationsOfDeletedNamespaces(ctx, nodeName); err != nil {
+				log.FromContext(ctx).Error(err, "unable to reclaim slices of deleted namespaces")
+			}
+			if err := r.reclaimAllocationsOfDeletedPods(ctx, nodeName); err != nil {
+				log.FromContext(ctx).Error(err, "unable to reclaim slices of deleted pods")
+			}
+		}
+	}
+}
+
+// pruneGhostPreparedSlices removes Prepared entries whose MIG device no longer exists on the node and that no
+// allocation holds, e.g. because the slice was destroyed out-of-band with nvidia-smi, and refreshes the node
+// capacity. Entries an allocation still holds are left to recarveSlicesAfterReboot, which carves the slice again
+// for a running pod or releases what the pod held, on a full reconcile requested for them.
+func (r *InstaSliceDaemonsetReconciler) pruneGhostPreparedSlices(ctx context.Context, nvmllib nvml.Interface, nodeName string) error {
+	// fetch the object before listing the hardware, a slice is always carved before its Prepared
+	// entry is added so entries in this snapshot can not be newer than the listing.
+	var instaslice inferencev1alpha1.Instaslice
+	typeNamespacedName := types.NamespacedName{
+		Name:      nodeName,
+		Namespace: r.instasliceNamespace(),
+	}
+	if err := r.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+		return err
+	}
+	if len(instaslice.Status.Prepared) == 0 {
+		return nil
+	}
+
+	migUUIDs, ret := migUUIDsOnNode(nvmllib)
+	if ret != nvml.SUCCESS {
+		return ret
+	}
+
+	var ghosts []string
+	lostPods := make(map[string]bool)
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		if _, exists := migUUIDs[migUUID]; exists {
+			continue
+		}
+		if _, held := instaslice.Spec.Allocations[prepared.PodUUID]; prepared.PodUUID != "" && held {
+			if !lostPods[prepared.PodUUID] {
+				lostPods[prepared.PodUUID] = true
+				allocation := instaslice.Spec.Allocations[prepared.PodUUID]
+				log.FromContext(ctx).Info("prepared slice missing on hardware, carving it again for ", "migUUID", migUUID, "pod", allocation.PodName)
+				r.recordSliceEvent(allocation, v1.EventTypeWarning, EventReasonSliceLost,
+					"MIG device %s of the %s slice on GPU %s is missing, carving the slice again", migUUID, allocation.Profile, allocation.GPUUUID)
+			}
+			continue
+		}
+		ghosts = append(ghosts, migUUID)
+	}
+	// the recarve goes through the workqueue, serialized with the reconciles carving and destroying slices
+	if len(lostPods) > 0 {
+		r.requestFullReconcile(nodeName)
+	}
+	if len(ghosts) == 0 {
+		return nil
+	}
+	sort.Strings(ghosts)
+	for _, migUUID := range ghosts {
+		log.FromContext(ctx).Info("pruning prepared slice missing on hardware ", "migUUID", migUUID, "pod", instaslice.Status.Prepared[migUUID].PodUUID)
+		delete(instaslice.Status.Prepared, migUUID)
+	}
+	if err := r.Status().Update(ctx, &instaslice); err != nil {
+		return err
+	}
+	if r.Recorder != nil {
+		r.Recorder.Eventf(&instaslice, v1.EventTypeWarning, EventReasonGhostSlicePruned,
+			"Removed the prepared entries of MIG devices missing on the GPUs and held by no allocation: %s", strings.Join(ghosts, ", "))
+	}
+	return r.updateNodeCapacity(ctx, nodeName)
+}
+
+// migUUIDsOnNode returns the UUIDs of all MIG devices on all GPUs of the node.
+func migUUIDsOnNode(nvmllib nvml.Interface) (map[string]struct{}, nvml.Return) {
+	migUUIDs := make(map[string]struct{})
+	count, ret := nvmllib.DeviceGetCount()
+	if ret != nvml.SUCCESS {
+		return nil, ret
+	}
+	for i := 0; i < count; i++ {
+		device, ret := nvmllib.DeviceGetHandleByIndex(i)
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		maxMigs, ret := device.GetMaxMigDeviceCount()
+		if ret == nvml.ERROR_NOT_SUPPORTED {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return nil, ret
+		}
+		for j := 0; j < maxMigs; j++ {
+			mig, ret := device.GetMigDeviceHandleByIndex(j)
+			if ret == nvml.ERROR_NOT_FOUND || ret == nvml.ERROR_INVALID_ARGUMENT {
+				continue
+			}
+			if ret != nvml.SUCCESS {
+				return nil, ret
+			}
+			migUUID, ret := mig.GetUUID()
+			if ret != nvml.SUCCESS {
+				return nil, ret
+			}
+			migUUIDs[migUUID] = struct{}{}
+		}
+	}
+	return migUUIDs, nvml.SUCCESS
+}
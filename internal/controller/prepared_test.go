@@ -0,0 +1,193 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// withMigDevices makes the fake GPUs report the given MIG device UUIDs, all on the first GPU.
+func withMigDevices(server *dgxa100.Server, migUUIDs ...string) {
+	for i, d := range server.Devices {
+		device := d.(*dgxa100.Device)
+		var migs []string
+		if i == 0 {
+			migs = migUUIDs
+		}
+		device.GetMaxMigDeviceCountFunc = func() (int, nvml.Return) {
+			return len(migs), nvml.SUCCESS
+		}
+		device.GetMigDeviceHandleByIndexFunc = func(index int) (nvml.Device, nvml.Return) {
+			migUUID := migs[index]
+			return &mock.Device{
+				GetUUIDFunc: func() (string, nvml.Return) { return migUUID, nvml.SUCCESS },
+			}, nvml.SUCCESS
+		}
+	}
+}
+
+func TestPruneGhostPreparedSlicesRemovesMissingMig(t *testing.T) {
+	server := dgxa100.New()
+	withMigDevices(server, "MIG-present")
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node-1",
+			Labels: map[string]string{"nvidia.com/device-plugin.config": "update-capacity"},
+		},
+	}
+	instaslice := &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"},
+		Status: inferencev1alpha1.InstasliceStatus{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{
+				"MIG-present": {PodUUID: "pod-uid-1", Profile: "1g.5gb", Start: 0, Size: 1},
+				"MIG-ghost":   {PodUUID: "pod-uid-2", Profile: "1g.5gb", Start: 1, Size: 1},
+			},
+		},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(node, instaslice).WithStatusSubresource(instaslice).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s}
+
+	err := reconciler.pruneGhostPreparedSlices(context.Background(), server, "node-1")
+	assert.NoError(t, err)
+
+	var updated inferencev1alpha1.Instaslice
+	assert.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &updated))
+	assert.Contains(t, updated.Status.Prepared, "MIG-present")
+	assert.NotContains(t, updated.Status.Prepared, "MIG-ghost")
+
+	// capacity is refreshed by flipping the device plugin config label
+	var updatedNode v1.Node
+	assert.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1"}, &updatedNode))
+	assert.Equal(t, "update-capacity-1", updatedNode.Labels["nvidia.com/device-plugin.config"])
+}
+
+func TestPruneGhostPreparedSlicesKeepsStateInSync(t *testing.T) {
+	server := dgxa100.New()
+	withMigDevices(server, "MIG-present")
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	instaslice := &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"},
+		Status: inferencev1alpha1.InstasliceStatus{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{
+				"MIG-present": {PodUUID: "pod-uid-1", Profile: "1g.5gb", Start: 0, Size: 1},
+			},
+		},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(instaslice).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s}
+
+	// no node object exists, so touching capacity would fail
+	err := reconciler.pruneGhostPreparedSlices(context.Background(), server, "node-1")
+	assert.NoError(t, err)
+
+	var updated inferencev1alpha1.Instaslice
+	assert.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &updated))
+	assert.Contains(t, updated.Status.Prepared, "MIG-present")
+}
+
+func TestPruneGhostPreparedSlicesLeavesHeldSlicesToRecarve(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	recorder := record.NewFakeRecorder(10)
+	reconciler.Recorder = recorder
+	ctx := context.Background()
+	require.NoError(t, fakeClient.Create(ctx, &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "pod-0",
+		Namespace: "default",
+		UID:       types.UID("pod-uid-0"),
+	}}))
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	instaslice := latestTestInstaslice(t, fakeClient)
+	require.Len(t, instaslice.Status.Prepared, 1)
+	var lostMigUUID string
+	for migUUID := range instaslice.Status.Prepared {
+		lostMigUUID = migUUID
+	}
+	for len(recorder.Events) > 0 {
+		<-recorder.Events
+	}
+
+	// the slice is destroyed out-of-band while the pod still holds it
+	device.GpuInstances = make(map[*dgxa100.GpuInstance]struct{})
+	require.NoError(t, reconciler.pruneGhostPreparedSlices(ctx, reconciler.handler().nvml, "node-1"))
+
+	instaslice = latestTestInstaslice(t, fakeClient)
+	assert.Contains(t, instaslice.Status.Prepared, lostMigUUID, "the entry is left to the recarve")
+	assert.True(t, reconciler.fullReconcilePending.Load())
+	require.NotEmpty(t, recorder.Events)
+	assert.Contains(t, <-recorder.Events, EventReasonSliceLost)
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	instaslice = latestTestInstaslice(t, fakeClient)
+	assert.Len(t, device.GpuInstances, 1)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	require.Len(t, instaslice.Status.Prepared, 1)
+	assert.NotContains(t, instaslice.Status.Prepared, lostMigUUID)
+}
+
+func TestPruneGhostPreparedSlicesReportsPrunedEntries(t *testing.T) {
+	server := dgxa100.New()
+	withMigDevices(server)
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	instaslice := &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"},
+		Status: inferencev1alpha1.InstasliceStatus{
+			Prepared: map[string]inferencev1alpha1.PreparedDetails{
+				"MIG-ghost": {Profile: "1g.5gb", Start: 1, Size: 1},
+			},
+		},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(node, instaslice).WithStatusSubresource(instaslice).Build()
+	recorder := record.NewFakeRecorder(10)
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s, Recorder: recorder}
+
+	require.NoError(t, reconciler.pruneGhostPreparedSlices(context.Background(), server, "node-1"))
+
+	var updated inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1", Namespace: "default"}, &updated))
+	assert.Empty(t, updated.Status.Prepared)
+	assert.False(t, reconciler.fullReconcilePending.Load())
+	require.Len(t, recorder.Events, 1)
+	event := <-recorder.Events
+	assert.Contains(t, event, EventReasonGhostSlicePruned)
+	assert.Contains(t, event, "MIG-ghost")
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// ReasonInvalidPreparedEntries is the degraded reason used while prepared entries break their invariants.
+	ReasonInvalidPreparedEntries = "InvalidPreparedEntries"
+	// ReasonPreparedEntriesValid clears the degraded condition set for ReasonInvalidPreparedEntries.
+	ReasonPreparedEntriesValid = "PreparedEntriesValid"
+)
+
+// preparedViolation returns which invariant of a prepared entry does not hold, empty when they all do: it names
+// its GPU, covers memory slices of the GPU and has a profile name that parses. NVML hands out 0 to the first GPU
+// and compute instances, so their ids are only checked by invalidPreparedEntries, against the other entries.
+func preparedViolation(prepared inferencev1alpha1.PreparedDetails) string {
+	if prepared.Parent == "" {
+		return "names no GPU"
+	}
+	if !NewPlacementSet(gpuMemorySlices).Fits(prepared.Start, prepared.Size) {
+		return fmt.Sprintf("covers memory slices %d to %d out of the %d of a GPU", prepared.Start, int64(prepared.Start)+int64(prepared.Size)-1, gpuMemorySlices)
+	}
+	if prepared.Profile == ForeignSliceProfile {
+		return ""
+	}
+	if _, err := ParseMigProfile(prepared.Profile); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// invalidPreparedEntries returns, keyed by MIG UUID, why the prepared entries of the instaslice breaking their
+// invariants do so. Entries of a GPU sharing both their GPU and compute instance ids describe a single MIG device,
+// at most one of them is right so all of them are reported.
+func invalidPreparedEntries(instaslice *inferencev1alpha1.Instaslice) map[string]string {
+	invalid := make(map[string]string)
+	type instanceKey struct {
+		parent     string
+		giID, ciID uint32
+	}
+	byInstance := make(map[instanceKey][]string)
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		if violation := preparedViolation(prepared); violation != "" {
+			invalid[migUUID] = violation
+			continue
+		}
+		key := instanceKey{parent: prepared.Parent, giID: prepared.Giinfoid, ciID: prepared.Ciinfoid}
+		byInstance[key] = append(byInstance[key], migUUID)
+	}
+	for key, migUUIDs := range byInstance {
+		if len(migUUIDs) < 2 {
+			continue
+		}
+		for _, migUUID := range migUUIDs {
+			invalid[migUUID] = fmt.Sprintf("shares GPU instance %d and compute instance %d of %s with other entries", key.giID, key.ciID, key.parent)
+		}
+	}
+	return invalid
+}
+
+// checkPreparedEntries flags the prepared entries of the instaslice breaking their invariants by marking it
+// degraded, and with QuarantineInvalidPrepared moves them out of status.prepared to status.quarantinedPrepared so
+// that nothing acts on them. The condition stays set while quarantined entries are left in the status. It reports
+// whether the instaslice was updated.
+func (r *InstaSliceDaemonsetReconciler) checkPreparedEntries(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) bool {
+	invalid := invalidPreparedEntries(instaslice)
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionDegraded)
+	flagged := condition != nil && condition.Status == metav1.ConditionTrue && condition.Reason == ReasonInvalidPreparedEntries
+	if len(invalid) == 0 && len(instaslice.Status.QuarantinedPrepared) == 0 && !flagged {
+		return false
+	}
+	quarantine := r.settings().quarantineInvalidPrepared
+	for migUUID, violation := range invalid {
+		log.FromContext(ctx).Error(fmt.Errorf("prepared entry %s %s", migUUID, violation), "invalid prepared entry", "quarantine", quarantine)
+	}
+	quarantined := make(map[string]inferencev1alpha1.PreparedDetails)
+	if quarantine && len(invalid) > 0 {
+		_, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+			quarantined = make(map[string]inferencev1alpha1.PreparedDetails)
+			for migUUID := range invalidPreparedEntries(latest) {
+				quarantined[migUUID] = latest.Status.Prepared[migUUID]
+				delete(latest.Status.Prepared, migUUID)
+			}
+			if len(quarantined) == 0 {
+				return errInstasliceUnchanged
+			}
+			return nil
+		})
+		if err != nil {
+			log.FromContext(ctx).Error(err, "unable to quarantine invalid prepared entries")
+			return false
+		}
+	}
+	changed := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		changed = len(quarantined) > 0
+		var latest inferencev1alpha1.Instaslice
+		if err := r.Get(ctx, types.NamespacedName{Name: instaslice.Name, Namespace: r.instasliceNamespace()}, &latest); err != nil {
+			return err
+		}
+		if len(quarantined) > 0 && latest.Status.QuarantinedPrepared == nil {
+			latest.Status.QuarantinedPrepared = make(map[string]inferencev1alpha1.PreparedDetails, len(quarantined))
+		}
+		for migUUID, prepared := range quarantined {
+			latest.Status.QuarantinedPrepared[migUUID] = prepared
+		}
+		var entries []string
+		for migUUID, violation := range invalidPreparedEntries(&latest) {
+			entries = append(entries, migUUID+" "+violation)
+		}
+		for migUUID := range latest.Status.QuarantinedPrepared {
+			entries = append(entries, migUUID+" quarantined")
+		}
+		sort.Strings(entries)
+		current := meta.FindStatusCondition(latest.Status.Conditions, ConditionDegraded)
+		if len(entries) > 0 {
+			changed = meta.SetStatusCondition(&latest.Status.Conditions, metav1.Condition{
+				Type:    ConditionDegraded,
+				Status:  metav1.ConditionTrue,
+				Reason:  ReasonInvalidPreparedEntries,
+				Message: "prepared entries break their invariants: " + strings.Join(entries, ", "),
+			}) || changed
+		} else if current != nil && current.Reason == ReasonInvalidPreparedEntries {
+			changed = meta.SetStatusCondition(&latest.Status.Conditions, metav1.Condition{
+				Type:    ConditionDegraded,
+				Status:  metav1.ConditionFalse,
+				Reason:  ReasonPreparedEntriesValid,
+				Message: "every prepared entry holds its invariants",
+			}) || changed
+		}
+		if !changed {
+			return nil
+		}
+		return r.Status().Update(ctx, &latest)
+	})
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to flag invalid prepared entries")
+		return false
+	}
+	return changed
+}
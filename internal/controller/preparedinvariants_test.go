@@ -0,0 +1,136 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestInvalidPreparedEntries(t *testing.T) {
+	instaslice := &inferencev1alpha1.Instaslice{Status: inferencev1alpha1.InstasliceStatus{
+		Prepared: map[string]inferencev1alpha1.PreparedDetails{
+			// the first instances NVML hands out have id 0
+			"MIG-valid":   {Profile: "7g.40gb", Parent: "GPU-1", Start: 0, Size: 8},
+			"MIG-foreign": {Profile: ForeignSliceProfile, Parent: "GPU-2", Start: 0, Size: 1, Giinfoid: 3},
+			"MIG-orphan":  {Profile: "1g.5gb", Start: 0, Size: 1, Giinfoid: 4},
+			"MIG-outside": {Profile: "3g.20gb", Parent: "GPU-2", Start: 6, Size: 4, Giinfoid: 5},
+			"MIG-empty":   {Profile: "1g.5gb", Parent: "GPU-2", Start: 1, Size: 0, Giinfoid: 6},
+			"MIG-garbled": {Profile: "1g.5gb-x", Parent: "GPU-2", Start: 1, Size: 1, Giinfoid: 7},
+			"MIG-twin-1":  {Profile: "1g.5gb", Parent: "GPU-2", Start: 2, Size: 1, Giinfoid: 8},
+			"MIG-twin-2":  {Profile: "1g.5gb", Parent: "GPU-2", Start: 3, Size: 1, Giinfoid: 8},
+		},
+	}}
+
+	invalid := invalidPreparedEntries(instaslice)
+
+	assert.ElementsMatch(t, []string{"MIG-orphan", "MIG-outside", "MIG-empty", "MIG-garbled", "MIG-twin-1", "MIG-twin-2"}, keysOf(invalid))
+	assert.Equal(t, "names no GPU", invalid["MIG-orphan"])
+	assert.Equal(t, "covers memory slices 6 to 9 out of the 8 of a GPU", invalid["MIG-outside"])
+	assert.Contains(t, invalid["MIG-twin-1"], "GPU instance 8 and compute instance 0 of GPU-2")
+}
+
+func keysOf(entries map[string]string) []string {
+	var keys []string
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// addCorruptPreparedEntry records a prepared entry naming no GPU for the pending allocation of pod-uid-0.
+func addCorruptPreparedEntry(t *testing.T, ctx context.Context, reconciler *InstaSliceDaemonsetReconciler) {
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, reconciler.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	instaslice.Status.Prepared = map[string]inferencev1alpha1.PreparedDetails{
+		"MIG-corrupt": {Profile: "1g.5gb", Start: 0, Size: 1, PodUUID: "pod-uid-0"},
+	}
+	require.NoError(t, reconciler.Status().Update(ctx, &instaslice))
+}
+
+func TestReconcileQuarantinesInvalidPreparedEntry(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.QuarantineInvalidPrepared = true
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	addCorruptPreparedEntry(t, ctx, reconciler)
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.True(t, result.Requeue)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.NotContains(t, instaslice.Status.Prepared, "MIG-corrupt")
+	assert.Equal(t, "pod-uid-0", instaslice.Status.QuarantinedPrepared["MIG-corrupt"].PodUUID)
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionDegraded)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, ReasonInvalidPreparedEntries, condition.Reason)
+	assert.Contains(t, condition.Message, "MIG-corrupt")
+	// the allocation is not finished from the corrupt entry
+	assert.Empty(t, device.GpuInstances)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreating, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+
+	// the slice of the allocation is carved as if the entry was never there
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	onlyGpuInstance(t, device)
+	migUUID, prepared := preparedOfPod(instaslice, "pod-uid-0")
+	assert.NotEqual(t, "MIG-corrupt", migUUID)
+	assert.Equal(t, device.UUID, prepared.Parent)
+	assert.Contains(t, instaslice.Status.QuarantinedPrepared, "MIG-corrupt")
+	assert.True(t, meta.IsStatusConditionTrue(instaslice.Status.Conditions, ConditionDegraded))
+
+	// the condition is cleared once the quarantined entry was looked at and removed
+	delete(instaslice.Status.QuarantinedPrepared, "MIG-corrupt")
+	require.NoError(t, fakeClient.Status().Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	condition = meta.FindStatusCondition(instaslice.Status.Conditions, ConditionDegraded)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, ReasonPreparedEntriesValid, condition.Reason)
+}
+
+func TestReconcileFlagsInvalidPreparedEntryWithoutQuarantine(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	addCorruptPreparedEntry(t, ctx, reconciler)
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Contains(t, instaslice.Status.Prepared, "MIG-corrupt")
+	assert.Empty(t, instaslice.Status.QuarantinedPrepared)
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionDegraded)
+	require.NotNil(t, condition)
+	assert.Equal(t, ReasonInvalidPreparedEntries, condition.Reason)
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// ProfileCacheConfigMapPrefix prefixes the node name to name the ConfigMap caching the discovered profiles.
+	ProfileCacheConfigMapPrefix = "instaslice-profiles-"
+	// ProfileCacheGPUsKey holds the JSON object giving the model of every GPU the profiles were discovered with.
+	ProfileCacheGPUsKey = "gpus"
+	// ProfileCacheMigPlacementKey holds the JSON list of the discovered profiles and their placements.
+	ProfileCacheMigPlacementKey = "migplacement"
+)
+
+// profileCacheConfigMapName returns the name of the ConfigMap caching the profiles discovered on the node.
+func profileCacheConfigMapName(nodeName string) string {
+	return ProfileCacheConfigMapPrefix + nodeName
+}
+
+// profileCacheData returns the content of the ConfigMap caching the profiles discovered on the GPUs of the instaslice.
+func profileCacheData(instaslice *inferencev1alpha1.Instaslice) (map[string]string, error) {
+	gpusJSON, err := json.Marshal(instaslice.Spec.MigGPUUUID)
+	if err != nil {
+		return nil, err
+	}
+	migPlacementJSON, err := json.Marshal(instaslice.Spec.Migplacement)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		ProfileCacheGPUsKey:         string(gpusJSON),
+		ProfileCacheMigPlacementKey: string(migPlacementJSON),
+	}, nil
+}
+
+// loadCachedProfiles returns the profiles cached for the node when they were discovered with the same GPUs,
+// identified by UUID and model. Any cache that can not be used is reported as a miss.
+func (r *InstaSliceDaemonsetReconciler) loadCachedProfiles(ctx context.Context, nodeName string, gpuModelMap map[string]string) ([]inferencev1alpha1.Mig, bool) {
+	if !r.CacheProfiles {
+		return nil, false
+	}
+	var configMap v1.ConfigMap
+	if err := r.Get(ctx, types.NamespacedName{Name: profileCacheConfigMapName(nodeName), Namespace: r.instasliceNamespace()}, &configMap); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			log.FromContext(ctx).Error(err, "unable to read the cached profiles")
+		}
+		return nil, false
+	}
+	var cachedGPUs map[string]string
+	var migPlacement []inferencev1alpha1.Mig
+	if err := json.Unmarshal([]byte(configMap.Data[ProfileCacheGPUsKey]), &cachedGPUs); err != nil {
+		log.FromContext(ctx).Error(err, "ignoring malformed cached profiles")
+		return nil, false
+	}
+	if err := json.Unmarshal([]byte(configMap.Data[ProfileCacheMigPlacementKey]), &migPlacement); err != nil {
+		log.FromContext(ctx).Error(err, "ignoring malformed cached profiles")
+		return nil, false
+	}
+	if !reflect.DeepEqual(cachedGPUs, gpuModelMap) {
+		log.FromContext(ctx).Info("GPUs changed since the profiles were cached, discovering them again")
+		return nil, false
+	}
+	log.FromContext(ctx).Info("using the cached profiles, the GPUs of the node did not change")
+	return migPlacement, true
+}
+
+// cacheDiscoveredProfiles creates or refreshes the ConfigMap caching the profiles discovered on the GPUs of the
+// instaslice for the next start. The ConfigMap goes with the instaslice.
+func (r *InstaSliceDaemonsetReconciler) cacheDiscoveredProfiles(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	if !r.CacheProfiles {
+		return nil
+	}
+	data, err := profileCacheData(instaslice)
+	if err != nil {
+		return err
+	}
+	var configMap v1.ConfigMap
+	err = r.Get(ctx, types.NamespacedName{Name: profileCacheConfigMapName(instaslice.Name), Namespace: instaslice.Namespace}, &configMap)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	if err != nil {
+		configMap = v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      profileCacheConfigMapName(instaslice.Name),
+				Namespace: instaslice.Namespace,
+				Labels:    map[string]string{CapabilitiesNodeLabel: instaslice.Name},
+			},
+			Data: data,
+		}
+		if err := controllerutil.SetControllerReference(instaslice, &configMap, r.Scheme); err != nil {
+			return err
+		}
+		return r.Create(ctx, &configMap)
+	}
+	// profiles loaded from the cache are written back as they were read
+	if reflect.DeepEqual(configMap.Data, data) {
+		return nil
+	}
+	configMap.Data = data
+	return r.Update(ctx, &configMap)
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// countProfileEnumerations counts the profiles looked up on any GPU of the reconciler.
+func countProfileEnumerations(t *testing.T, reconciler *InstaSliceDaemonsetReconciler) (*int, []*dgxa100.Device) {
+	nvmllib := reconciler.handler().nvml
+	count, ret := nvmllib.DeviceGetCount()
+	require.Equal(t, nvml.SUCCESS, ret)
+	enumerations := 0
+	var devices []*dgxa100.Device
+	for i := 0; i < count; i++ {
+		handle, ret := nvmllib.DeviceGetHandleByIndex(i)
+		require.Equal(t, nvml.SUCCESS, ret)
+		device := handle.(*dgxa100.Device)
+		getGpuInstanceProfileInfo := device.GetGpuInstanceProfileInfoFunc
+		device.GetGpuInstanceProfileInfoFunc = func(profile int) (nvml.GpuInstanceProfileInfo, nvml.Return) {
+			enumerations++
+			return getGpuInstanceProfileInfo(profile)
+		}
+		devices = append(devices, device)
+	}
+	return &enumerations, devices
+}
+
+func TestUnchangedGPUsLoadProfilesFromCache(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t)
+	reconciler.CacheProfiles = true
+	ctx := context.Background()
+	_, err := reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "instaslice-profiles-node-1", Namespace: "default"}, &configMap))
+	var discovered inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &discovered))
+	enumerations, _ := countProfileEnumerations(t, reconciler)
+
+	instaslice, _, gpuModelMap, _, _, err := reconciler.discoverAvailableProfilesOnGpus(ctx)
+	require.NoError(t, err)
+
+	assert.Zero(t, *enumerations)
+	assert.Equal(t, discovered.Spec.Migplacement, instaslice.Spec.Migplacement)
+	assert.Equal(t, discovered.Spec.MigGPUUUID, gpuModelMap)
+}
+
+func TestChangedGPUsDiscoverProfilesAgain(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t)
+	reconciler.CacheProfiles = true
+	ctx := context.Background()
+	_, err := reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+	enumerations, devices := countProfileEnumerations(t, reconciler)
+	// the GPU was swapped for another model with the same UUID
+	devices[0].Name = "NVIDIA A100-SXM4-80GB"
+
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+
+	assert.NotZero(t, *enumerations)
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "instaslice-profiles-node-1", Namespace: "default"}, &configMap))
+	assert.Contains(t, configMap.Data[ProfileCacheGPUsKey], "NVIDIA A100-SXM4-80GB")
+}
+
+func TestProfilesAreNotCachedByDefault(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t)
+	enumerations, _ := countProfileEnumerations(t, reconciler)
+
+	_, err := reconciler.discoverMigEnabledGpuWithSlices(context.Background())
+	require.NoError(t, err)
+
+	assert.NotZero(t, *enumerations)
+	var configMap v1.ConfigMap
+	err = fakeClient.Get(context.Background(), types.NamespacedName{Name: "instaslice-profiles-node-1", Namespace: "default"}, &configMap)
+	assert.True(t, apierrors.IsNotFound(err))
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// ProfileLabelPrefix prefixes the node labels set to true for every profile the GPUs of the node support,
+// e.g. instaslice.codeflare.dev/1g.5gb=true.
+const ProfileLabelPrefix = "instaslice.codeflare.dev/"
+
+// profileLabelName matches the part of a label key after ProfileLabelPrefix that names a profile, other labels
+// sharing the prefix are left alone.
+var profileLabelName = regexp.MustCompile(`^([0-9]+c\.)?[0-9]+g\.[0-9]+gb(-me)?$`)
+
+// profileLabelKey returns the node label of a profile, label keys cannot hold the + of e.g. 1g.10gb+me.
+func profileLabelKey(profile string) string {
+	return ProfileLabelPrefix + strings.ReplaceAll(profile, "+", "-")
+}
+
+// isProfileLabel reports whether the node label key was set for a profile.
+func isProfileLabel(key string) bool {
+	return strings.HasPrefix(key, ProfileLabelPrefix) && profileLabelName.MatchString(strings.TrimPrefix(key, ProfileLabelPrefix))
+}
+
+// labelSupportedProfiles sets a label on the node for every profile some GPU of the instaslice offers and removes
+// the labels of profiles its GPUs no longer support or allow.
+func (r *InstaSliceDaemonsetReconciler) labelSupportedProfiles(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	node := &v1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: instaslice.Name}, node); err != nil {
+		return err
+	}
+	desired := make(map[string]bool)
+	for _, mig := range instaslice.Spec.Migplacement {
+		if profileOffered(instaslice, mig.Profile) {
+			desired[profileLabelKey(mig.Profile)] = true
+		}
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	changed := false
+	for key := range node.Labels {
+		if isProfileLabel(key) && !desired[key] {
+			delete(node.Labels, key)
+			changed = true
+		}
+	}
+	for key := range desired {
+		if node.Labels[key] == "true" {
+			continue
+		}
+		if node.Labels == nil {
+			node.Labels = make(map[string]string)
+		}
+		node.Labels[key] = "true"
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return r.Patch(ctx, node, patch)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestDiscoveryLabelsNodeWithSupportedProfiles(t *testing.T) {
+	_, fakeClient, _, _ := newFakeGPUTestReconciler(t)
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	require.NotEmpty(t, instaslice.Spec.Migplacement)
+
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	for _, mig := range instaslice.Spec.Migplacement {
+		assert.Equal(t, "true", node.Labels[profileLabelKey(mig.Profile)], mig.Profile)
+	}
+	assert.Equal(t, "true", node.Labels["instaslice.codeflare.dev/1g.5gb"])
+}
+
+func TestLabelSupportedProfilesRemovesUnsupportedProfiles(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t)
+	ctx := context.Background()
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	node.Labels["instaslice.codeflare.dev/3g.40gb"] = "true"
+	node.Labels[ConfigMapProfileLabel] = "kept"
+	require.NoError(t, fakeClient.Update(ctx, &node))
+
+	instaslice := &inferencev1alpha1.Instaslice{}
+	instaslice.Name = "node-1"
+	instaslice.Spec.Migplacement = []inferencev1alpha1.Mig{{Profile: "1g.10gb+me"}, {Profile: "7g.40gb"}}
+	require.NoError(t, reconciler.labelSupportedProfiles(ctx, instaslice))
+
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.Equal(t, "true", node.Labels["instaslice.codeflare.dev/1g.10gb-me"])
+	assert.Equal(t, "true", node.Labels["instaslice.codeflare.dev/7g.40gb"])
+	assert.NotContains(t, node.Labels, "instaslice.codeflare.dev/3g.40gb")
+	assert.NotContains(t, node.Labels, "instaslice.codeflare.dev/1g.5gb")
+	assert.Equal(t, "kept", node.Labels[ConfigMapProfileLabel])
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// renamedProfiles maps the profiles the allocations of the existing instaslice are recorded under, but discovery
+// no longer names, to the name discovery now gives the same GPU and compute instance profiles, e.g. after a fix
+// of how the memory of a profile is rounded. Profiles discovery does not find again, or finds under several names,
+// are left out.
+func renamedProfiles(existing, discovered *inferencev1alpha1.Instaslice) map[string]string {
+	discoveredNames := make(map[string]bool, len(discovered.Spec.Migplacement))
+	for _, mig := range discovered.Spec.Migplacement {
+		discoveredNames[mig.Profile] = true
+	}
+	renamed := make(map[string]string)
+	for _, allocation := range existing.Spec.Allocations {
+		if allocation.Profile == "" || discoveredNames[allocation.Profile] {
+			continue
+		}
+		var matches []string
+		for _, mig := range discovered.Spec.Migplacement {
+			if mig.Giprofileid == allocation.Giprofileid && mig.CIProfileID == allocation.CIProfileID && mig.CIEngProfileID == allocation.CIEngProfileID {
+				matches = append(matches, mig.Profile)
+			}
+		}
+		if len(matches) == 1 {
+			renamed[allocation.Profile] = matches[0]
+		}
+	}
+	return renamed
+}
+
+// renameProfiles records the allocations and the prepared slices of the instaslice under the new names of their
+// profiles.
+func renameProfiles(instaslice *inferencev1alpha1.Instaslice, renamed map[string]string) {
+	for key, allocation := range instaslice.Spec.Allocations {
+		if name, found := renamed[allocation.Profile]; found {
+			allocation.Profile = name
+			instaslice.Spec.Allocations[key] = allocation
+		}
+	}
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		if name, found := renamed[prepared.Profile]; found {
+			prepared.Profile = name
+			instaslice.Status.Prepared[migUUID] = prepared
+		}
+	}
+}
+
+// migrateProfileCapacity moves the capacity advertised per profile from the old names of the renamed profiles to
+// their new ones, in a single patch of the node, so that pods requesting the new names find the slices already
+// held and no capacity is left stranded under the old names. The resources advertised per pod do not depend on
+// the profile names and are left alone.
+func (r *InstaSliceDaemonsetReconciler) migrateProfileCapacity(ctx context.Context, instaslice *inferencev1alpha1.Instaslice, renamed map[string]string) error {
+	advertiser, perProfile := r.capacityAdvertiser().(*ProfileResourceAdvertiser)
+	if !perProfile || len(renamed) == 0 {
+		return nil
+	}
+	node := &v1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: instaslice.Name}, node); err != nil {
+		return err
+	}
+	oldNames := make([]string, 0, len(renamed))
+	for oldName := range renamed {
+		oldNames = append(oldNames, oldName)
+	}
+	sort.Strings(oldNames)
+	var patch []ResPatchOperation
+	removed := make(map[string]bool)
+	for _, oldName := range oldNames {
+		oldResource := advertiser.resourceName(oldName)
+		newResource := advertiser.resourceName(renamed[oldName])
+		if oldResource == newResource {
+			continue
+		}
+		if _, exists := node.Status.Capacity[v1.ResourceName(oldResource)]; exists && !removed[oldResource] {
+			removed[oldResource] = true
+			patch = append(patch, ResPatchOperation{Op: "remove", Path: capacityPath(oldResource)})
+		}
+		count := profileSlices(instaslice, renamed[oldName], "")
+		if current, exists := node.Status.Capacity[v1.ResourceName(newResource)]; count > 0 && (!exists || current.Value() != int64(count)) {
+			patch = append(patch, ResPatchOperation{Op: "add", Path: capacityPath(newResource), Value: strconv.Itoa(count)})
+		}
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("moving the capacity of renamed profiles", "renamed", renamed)
+	return r.Status().Patch(ctx, node, client.RawPatch(types.JSONPatchType, patchData))
+}
+
+// capacityPath returns the JSON patch path of the extended resource in the capacity of a node.
+func capacityPath(resourceName string) string {
+	return fmt.Sprintf("/status/capacity/%s", strings.ReplaceAll(resourceName, "/", "~1"))
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestRenamedProfilesMatchTheProfileIDs(t *testing.T) {
+	existing := &inferencev1alpha1.Instaslice{}
+	existing.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{
+		"pod-uid-1": {PodUUID: "pod-uid-1", Profile: "1g.6gb", Giprofileid: nvml.GPU_INSTANCE_PROFILE_1_SLICE, CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE},
+		"pod-uid-2": {PodUUID: "pod-uid-2", Profile: "2g.10gb", Giprofileid: nvml.GPU_INSTANCE_PROFILE_2_SLICE, CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE},
+		"pod-uid-3": {PodUUID: "pod-uid-3", Profile: "9g.80gb", Giprofileid: 42},
+	}
+	discovered := &inferencev1alpha1.Instaslice{}
+	discovered.Spec.Migplacement = []inferencev1alpha1.Mig{
+		{Profile: "1g.5gb", Giprofileid: nvml.GPU_INSTANCE_PROFILE_1_SLICE, CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE},
+		{Profile: "2g.10gb", Giprofileid: nvml.GPU_INSTANCE_PROFILE_2_SLICE, CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE},
+	}
+
+	// names discovery still gives, and profiles it does not find, are left alone
+	assert.Equal(t, map[string]string{"1g.6gb": "1g.5gb"}, renamedProfiles(existing, discovered))
+}
+
+func TestCapacityMigratesToCorrectedProfileNames(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	// the slices were carved by a version naming the profile 1g.6gb, and its capacity advertised under that name
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		prepared.Profile = "1g.6gb"
+		instaslice.Status.Prepared[migUUID] = prepared
+	}
+	require.NoError(t, fakeClient.Status().Update(ctx, &instaslice))
+	for key, allocation := range instaslice.Spec.Allocations {
+		require.Equal(t, inferencev1alpha1.AllocationStatusCreated, allocation.Allocationstatus)
+		allocation.Profile = "1g.6gb"
+		instaslice.Spec.Allocations[key] = allocation
+	}
+	instaslice.Spec.Migplacement = append(instaslice.Spec.Migplacement, inferencev1alpha1.Mig{
+		Profile: "1g.6gb", Giprofileid: nvml.GPU_INSTANCE_PROFILE_1_SLICE, CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+	})
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	node.Status.Capacity[v1.ResourceName(ProfileResourcePrefix+"1g.6gb")] = resource.MustParse("2")
+	require.NoError(t, fakeClient.Status().Update(ctx, &node))
+
+	// the upgraded daemonset names the profile 1g.5gb
+	reconciler.CapacityAdvertiser = &ProfileResourceAdvertiser{Client: fakeClient, Namespace: "default"}
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	for _, allocation := range instaslice.Spec.Allocations {
+		assert.Equal(t, "1g.5gb", allocation.Profile)
+	}
+	require.Len(t, instaslice.Status.Prepared, 2)
+	for _, prepared := range instaslice.Status.Prepared {
+		assert.Equal(t, "1g.5gb", prepared.Profile)
+	}
+	for _, mig := range instaslice.Spec.Migplacement {
+		assert.NotEqual(t, "1g.6gb", mig.Profile)
+	}
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.NotContains(t, node.Status.Capacity, v1.ResourceName(ProfileResourcePrefix+"1g.6gb"))
+	capacity := node.Status.Capacity[v1.ResourceName(ProfileResourcePrefix+"1g.5gb")]
+	assert.Equal(t, int64(2), capacity.Value())
+}
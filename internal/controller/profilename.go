@@ -0,0 +1,150 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// profileNamePattern matches the names built by MigProfile.String, e.g. 1g.5gb, 1c.2g.10gb or 1g.5gb+me,eng1.
+var profileNamePattern = regexp.MustCompile(`^(?:(\d+)c\.)?(\d+)g\.(\d+)gb(?:\+([a-z0-9,]+))?$`)
+
+// gpu instance profiles by slice count, the media extension ones apart
+var (
+	giProfileIDs = map[int]int{
+		1: nvml.GPU_INSTANCE_PROFILE_1_SLICE,
+		2: nvml.GPU_INSTANCE_PROFILE_2_SLICE,
+		3: nvml.GPU_INSTANCE_PROFILE_3_SLICE,
+		4: nvml.GPU_INSTANCE_PROFILE_4_SLICE,
+		6: nvml.GPU_INSTANCE_PROFILE_6_SLICE,
+		7: nvml.GPU_INSTANCE_PROFILE_7_SLICE,
+		8: nvml.GPU_INSTANCE_PROFILE_8_SLICE,
+	}
+	mediaExtensionsGIProfileIDs = map[int]int{
+		1: nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1,
+		2: nvml.GPU_INSTANCE_PROFILE_2_SLICE_REV1,
+	}
+	ciProfileIDs = map[int]int{
+		1: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+		2: nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE,
+		3: nvml.COMPUTE_INSTANCE_PROFILE_3_SLICE,
+		4: nvml.COMPUTE_INSTANCE_PROFILE_4_SLICE,
+		6: nvml.COMPUTE_INSTANCE_PROFILE_6_SLICE,
+		7: nvml.COMPUTE_INSTANCE_PROFILE_7_SLICE,
+		8: nvml.COMPUTE_INSTANCE_PROFILE_8_SLICE,
+	}
+)
+
+// ParseMigProfile is the inverse of MigProfile.String. The profile ids are the ones NVML uses for the slice
+// counts, profiles told apart by memory alone, e.g. 1g.10gb of GPU_INSTANCE_PROFILE_1_SLICE_REV2, map to the
+// base profile of the slice count.
+func ParseMigProfile(name string) (MigProfile, error) {
+	matches := profileNamePattern.FindStringSubmatch(name)
+	if matches == nil {
+		return MigProfile{}, fmt.Errorf("invalid MIG profile name %q", name)
+	}
+	var profile MigProfile
+	profile.G, _ = strconv.Atoi(matches[2])
+	profile.GB, _ = strconv.Atoi(matches[3])
+	profile.C = profile.G
+	if matches[1] != "" {
+		profile.C, _ = strconv.Atoi(matches[1])
+		if profile.C >= profile.G {
+			return MigProfile{}, fmt.Errorf("invalid MIG profile name %q: compute slices must be fewer than the gpu slices", name)
+		}
+	}
+	giProfileID, ok := giProfileIDs[profile.G]
+	if !ok {
+		return MigProfile{}, fmt.Errorf("invalid MIG profile name %q: no gpu instance profile of %d slices", name, profile.G)
+	}
+	ciProfileID, ok := ciProfileIDs[profile.C]
+	if !ok {
+		return MigProfile{}, fmt.Errorf("invalid MIG profile name %q: no compute instance profile of %d slices", name, profile.C)
+	}
+	profile.GIProfileID = giProfileID
+	profile.CIProfileID = ciProfileID
+	profile.CIEngProfileID = nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED
+
+	if matches[4] == "" {
+		return profile, nil
+	}
+	seen := make(map[string]bool)
+	for _, attr := range strings.Split(matches[4], ",") {
+		if attr == "" || seen[attr] {
+			return MigProfile{}, fmt.Errorf("invalid MIG profile name %q: empty or repeated attribute %q", name, attr)
+		}
+		seen[attr] = true
+		switch {
+		case attr == AttributeMediaExtensions:
+			giProfileID, ok := mediaExtensionsGIProfileIDs[profile.G]
+			if !ok {
+				return MigProfile{}, fmt.Errorf("invalid MIG profile name %q: no media extension profile of %d slices", name, profile.G)
+			}
+			profile.GIProfileID = giProfileID
+		case strings.HasPrefix(attr, AttributeEngineProfilePrefix):
+			engProfileID, err := strconv.Atoi(strings.TrimPrefix(attr, AttributeEngineProfilePrefix))
+			if err != nil || engProfileID == nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED || engProfileID < 0 {
+				return MigProfile{}, fmt.Errorf("invalid MIG profile name %q: invalid engine profile %q", name, attr)
+			}
+			if seen[AttributeEngineProfilePrefix] {
+				return MigProfile{}, fmt.Errorf("invalid MIG profile name %q: more than one engine profile", name)
+			}
+			seen[AttributeEngineProfilePrefix] = true
+			profile.CIEngProfileID = engProfileID
+		default:
+			return MigProfile{}, fmt.Errorf("invalid MIG profile name %q: unknown attribute %q", name, attr)
+		}
+	}
+	return profile, nil
+}
+
+// CanonicalProfileName returns the name of the profile with its attributes in the order MigProfile.String gives
+// them, e.g. 1g.5gb+me,eng1 for 1g.5gb+eng1,me. Names that do not parse are returned as they are.
+func CanonicalProfileName(name string) string {
+	profile, err := ParseMigProfile(name)
+	if err != nil {
+		return name
+	}
+	return profile.String()
+}
+
+// ProfilesMatch reports whether both names are of the same profile, whatever the order of their attributes.
+func ProfilesMatch(a, b string) bool {
+	return a == b || CanonicalProfileName(a) == CanonicalProfileName(b)
+}
+
+// ResolveProfileIDs looks up the profile name among the profiles discovered on a node and returns the numeric ids
+// NVML needs to carve a slice of it, along with the placements of the profile. Names that are not valid MIG
+// profile names, or that were not discovered on the node, are an error.
+func ResolveProfileIDs(s string, migplacement []inferencev1alpha1.Mig) (giProfileID, ciProfileID, ciEngProfileID int, placements []inferencev1alpha1.Placement, err error) {
+	if _, err := ParseMigProfile(s); err != nil {
+		return 0, 0, 0, nil, err
+	}
+	for _, mig := range migplacement {
+		if ProfilesMatch(mig.Profile, s) {
+			return mig.Giprofileid, mig.CIProfileID, mig.CIEngProfileID, mig.Placements, nil
+		}
+	}
+	return 0, 0, 0, nil, fmt.Errorf("MIG profile %q was not discovered on the node", s)
+}
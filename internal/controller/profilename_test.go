@@ -0,0 +1,144 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestMigProfileNameRoundTrip(t *testing.T) {
+	for name, profile := range map[string]MigProfile{
+		"1g.5gb": {C: 1, G: 1, GB: 5, GIProfileID: nvml.GPU_INSTANCE_PROFILE_1_SLICE,
+			CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, CIEngProfileID: nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED},
+		"1g.5gb+me": {C: 1, G: 1, GB: 5, GIProfileID: nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1,
+			CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, CIEngProfileID: nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED},
+		"1g.5gb+eng1": {C: 1, G: 1, GB: 5, GIProfileID: nvml.GPU_INSTANCE_PROFILE_1_SLICE,
+			CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, CIEngProfileID: 1},
+		"2g.10gb+me": {C: 2, G: 2, GB: 10, GIProfileID: nvml.GPU_INSTANCE_PROFILE_2_SLICE_REV1,
+			CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE, CIEngProfileID: nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED},
+		"2g.10gb+me,eng1": {C: 2, G: 2, GB: 10, GIProfileID: nvml.GPU_INSTANCE_PROFILE_2_SLICE_REV1,
+			CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE, CIEngProfileID: 1},
+		"1c.3g.20gb": {C: 1, G: 3, GB: 20, GIProfileID: nvml.GPU_INSTANCE_PROFILE_3_SLICE,
+			CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, CIEngProfileID: nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED},
+		"1c.2g.10gb+eng2": {C: 1, G: 2, GB: 10, GIProfileID: nvml.GPU_INSTANCE_PROFILE_2_SLICE,
+			CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, CIEngProfileID: 2},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, name, profile.String())
+			parsed, err := ParseMigProfile(name)
+			require.NoError(t, err)
+			assert.Equal(t, profile, parsed)
+			assert.Equal(t, name, parsed.String())
+		})
+	}
+}
+
+func TestParseMigProfileRejectsInvalidNames(t *testing.T) {
+	for _, name := range []string{
+		"",
+		"1g",
+		"5g.40gb",
+		"2c.2g.10gb",
+		"3g.20gb+me",
+		"1g.5gb+",
+		"1g.5gb+me,me",
+		"1g.5gb+eng",
+		"1g.5gb+eng0",
+		"1g.5gb+eng1,eng2",
+		"1g.5gb+foo",
+	} {
+		_, err := ParseMigProfile(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFullComputeInstanceProfilesOnePerEngineProfile(t *testing.T) {
+	supported := []computeInstanceProfile{
+		{profileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, engProfileID: 1, sliceCount: 1},
+		{profileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE_REV1, engProfileID: nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED, sliceCount: 2},
+		{profileID: nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE, engProfileID: 1, sliceCount: 2},
+		{profileID: nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE, engProfileID: nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED, sliceCount: 2},
+	}
+
+	full := fullComputeInstanceProfiles(nvml.GPU_INSTANCE_PROFILE_2_SLICE, 2, supported)
+	assert.Equal(t, []computeInstanceProfile{
+		{profileID: nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE, engProfileID: nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED, sliceCount: 2},
+		{profileID: nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE, engProfileID: 1, sliceCount: 2},
+	}, full)
+
+	// the slices of the engine profiles are advertised under distinct names
+	var names []string
+	for _, ciProfile := range full {
+		names = append(names, NewMigProfile(nvml.GPU_INSTANCE_PROFILE_2_SLICE, ciProfile.profileID, ciProfile.engProfileID, 2, ciProfile.sliceCount, 9856, 40*1024*1024*1024).String())
+	}
+	assert.Equal(t, []string{"2g.10gb", "2g.10gb+eng1"}, names)
+
+	assert.Empty(t, fullComputeInstanceProfiles(nvml.GPU_INSTANCE_PROFILE_3_SLICE, 3, supported))
+}
+
+func TestResolveProfileIDs(t *testing.T) {
+	migplacement := []inferencev1alpha1.Mig{
+		{Profile: "1g.5gb", Giprofileid: nvml.GPU_INSTANCE_PROFILE_1_SLICE, CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+			Placements: []inferencev1alpha1.Placement{{Size: 1, Start: 0}, {Size: 1, Start: 1}}},
+		{Profile: "1g.5gb+me", Giprofileid: nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1, CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+			Placements: []inferencev1alpha1.Placement{{Size: 1, Start: 6}}},
+		{Profile: "1g.5gb+eng1", Giprofileid: nvml.GPU_INSTANCE_PROFILE_1_SLICE, CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+			CIEngProfileID: 1, Placements: []inferencev1alpha1.Placement{{Size: 1, Start: 0}}},
+	}
+
+	giProfileID, ciProfileID, ciEngProfileID, placements, err := ResolveProfileIDs("1g.5gb+me", migplacement)
+	require.NoError(t, err)
+	assert.Equal(t, nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1, giProfileID)
+	assert.Equal(t, nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE, ciProfileID)
+	assert.Equal(t, 0, ciEngProfileID)
+	assert.Equal(t, []inferencev1alpha1.Placement{{Size: 1, Start: 6}}, placements)
+
+	_, _, ciEngProfileID, _, err = ResolveProfileIDs("1g.5gb+eng1", migplacement)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ciEngProfileID)
+
+	_, _, _, _, err = ResolveProfileIDs("3g.20gb", migplacement)
+	assert.Error(t, err, "profiles not discovered on the node are unknown")
+	_, _, _, _, err = ResolveProfileIDs("bogus", migplacement)
+	assert.Error(t, err)
+}
+
+func TestProfilesMatchRegardlessOfAttributeOrder(t *testing.T) {
+	assert.Equal(t, "1g.5gb+me,eng1", CanonicalProfileName("1g.5gb+eng1,me"))
+	assert.Equal(t, "1g.5gb+me,eng1", CanonicalProfileName("1g.5gb+me,eng1"))
+	assert.Equal(t, "bogus", CanonicalProfileName("bogus"), "names that do not parse are kept as they are")
+
+	assert.True(t, ProfilesMatch("1g.5gb+me,eng1", "1g.5gb+eng1,me"))
+	assert.True(t, ProfilesMatch("2g.10gb", "2g.10gb"))
+	assert.False(t, ProfilesMatch("1g.5gb+me", "1g.5gb+eng1"))
+	assert.False(t, ProfilesMatch("1g.5gb", "1g.5gb+me"))
+
+	migplacement := []inferencev1alpha1.Mig{
+		{Profile: "1g.5gb+me,eng1", Giprofileid: nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1, CIProfileID: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+			CIEngProfileID: 1, Placements: []inferencev1alpha1.Placement{{Size: 1, Start: 6}}},
+	}
+	giProfileID, _, ciEngProfileID, _, err := ResolveProfileIDs("1g.5gb+eng1,me", migplacement)
+	require.NoError(t, err)
+	assert.Equal(t, nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1, giProfileID)
+	assert.Equal(t, 1, ciEngProfileID)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"slices"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// ProfileOrder is the order in which the profiles satisfying a pod asking for any slice, or for GPU memory, are
+// tried when placing its slice.
+type ProfileOrder string
+
+const (
+	// ProfileOrderSmallestFirst tries the smallest profiles first, packing as many slices as possible on the GPUs.
+	ProfileOrderSmallestFirst ProfileOrder = "smallest-first"
+	// ProfileOrderLargestFirst tries the largest profiles first, giving pods fewer, larger slices that leave
+	// fewer gaps on the GPUs.
+	ProfileOrderLargestFirst ProfileOrder = "largest-first"
+)
+
+// ParseProfileOrder validates a profile order name, an empty name is ProfileOrderSmallestFirst.
+func ParseProfileOrder(value string) (ProfileOrder, error) {
+	switch ProfileOrder(value) {
+	case "", ProfileOrderSmallestFirst:
+		return ProfileOrderSmallestFirst, nil
+	case ProfileOrderLargestFirst:
+		return ProfileOrderLargestFirst, nil
+	}
+	return "", fmt.Errorf("unknown profile order %q, must be %s or %s", value, ProfileOrderSmallestFirst, ProfileOrderLargestFirst)
+}
+
+// preferredCandidateProfiles returns the candidate profiles of the requested one in the preferred profile order.
+func (r *InstasliceReconciler) preferredCandidateProfiles(instaslice *inferencev1alpha1.Instaslice, profileName string) ([]string, bool) {
+	profiles, derived := candidateProfiles(instaslice, profileName)
+	if r.ProfileOrder == ProfileOrderLargestFirst {
+		slices.Reverse(profiles)
+	}
+	return profiles, derived
+}
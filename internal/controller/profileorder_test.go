@@ -0,0 +1,69 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProfileOrderChoosesAmongProfilesSatisfyingMemoryRequest(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+	// both 1g.5gb and 2g.10gb have enough memory
+	profileName := memoryProfileName(resource.MustParse("4Gi"))
+
+	smallest := &InstasliceReconciler{ProfileOrder: ProfileOrderSmallestFirst}
+	allocation, err := smallest.findDeviceForASlice(newAnySliceTestInstaslice(), profileName, &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, "1g.5gb", allocation.Profile)
+
+	largest := &InstasliceReconciler{ProfileOrder: ProfileOrderLargestFirst}
+	allocation, err = largest.findDeviceForASlice(newAnySliceTestInstaslice(), profileName, &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, "2g.10gb", allocation.Profile)
+	assert.Equal(t, uint32(2), allocation.Size)
+}
+
+func TestProfileOrderLargestFirstFallsBackToSmallerProfile(t *testing.T) {
+	r := &InstasliceReconciler{ProfileOrder: ProfileOrderLargestFirst}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+	instaslice := newAnySliceTestInstaslice()
+	// no GPU allows 2g.10gb slices
+	instaslice.Spec.AllowedProfiles = map[string][]string{"GPU-1": {"1g.5gb"}}
+
+	allocation, err := r.findDeviceForASlice(instaslice, AnySliceProfile, &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, "1g.5gb", allocation.Profile)
+}
+
+func TestParseProfileOrder(t *testing.T) {
+	order, err := ParseProfileOrder("")
+	require.NoError(t, err)
+	assert.Equal(t, ProfileOrderSmallestFirst, order)
+
+	order, err = ParseProfileOrder("largest-first")
+	require.NoError(t, err)
+	assert.Equal(t, ProfileOrderLargestFirst, order)
+
+	_, err = ParseProfileOrder("biggest")
+	assert.Error(t, err)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// knownDeviceProfiles lists, for a GPU model, the MIG profiles it supports in the order NVML reports them.
+type knownDeviceProfiles struct {
+	// nameParts must all appear in the device name reported by NVML, compared without case.
+	nameParts []string
+	profiles  []MigProfile
+}
+
+// knownProfile returns a profile of the table, named the same way discovery names the profile found on the device.
+func knownProfile(giProfileID, slices, gb int) MigProfile {
+	return MigProfile{
+		C:              slices,
+		G:              slices,
+		GB:             gb,
+		GIProfileID:    giProfileID,
+		CIProfileID:    giProfileID,
+		CIEngProfileID: nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED,
+	}
+}
+
+// a100And80GBProfiles is the profile set shared by the 80GB A100 and H100.
+var a100And80GBProfiles = []MigProfile{
+	knownProfile(nvml.GPU_INSTANCE_PROFILE_1_SLICE, 1, 10),
+	knownProfile(nvml.GPU_INSTANCE_PROFILE_2_SLICE, 2, 20),
+	knownProfile(nvml.GPU_INSTANCE_PROFILE_3_SLICE, 3, 40),
+	knownProfile(nvml.GPU_INSTANCE_PROFILE_4_SLICE, 4, 40),
+	knownProfile(nvml.GPU_INSTANCE_PROFILE_7_SLICE, 7, 80),
+	knownProfile(nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1, 1, 10),
+	knownProfile(nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV2, 1, 20),
+}
+
+// knownDevices is checked in order, the first entry matching the device name wins.
+var knownDevices = []knownDeviceProfiles{
+	{
+		nameParts: []string{"A100", "40GB"},
+		profiles: []MigProfile{
+			knownProfile(nvml.GPU_INSTANCE_PROFILE_1_SLICE, 1, 5),
+			knownProfile(nvml.GPU_INSTANCE_PROFILE_2_SLICE, 2, 10),
+			knownProfile(nvml.GPU_INSTANCE_PROFILE_3_SLICE, 3, 20),
+			knownProfile(nvml.GPU_INSTANCE_PROFILE_4_SLICE, 4, 20),
+			knownProfile(nvml.GPU_INSTANCE_PROFILE_7_SLICE, 7, 40),
+			knownProfile(nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1, 1, 5),
+			knownProfile(nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV2, 1, 10),
+		},
+	},
+	{nameParts: []string{"A100", "80GB"}, profiles: a100And80GBProfiles},
+	{
+		nameParts: []string{"A30"},
+		profiles: []MigProfile{
+			knownProfile(nvml.GPU_INSTANCE_PROFILE_1_SLICE, 1, 6),
+			knownProfile(nvml.GPU_INSTANCE_PROFILE_2_SLICE, 2, 12),
+			knownProfile(nvml.GPU_INSTANCE_PROFILE_4_SLICE, 4, 24),
+			knownProfile(nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1, 1, 6),
+			knownProfile(nvml.GPU_INSTANCE_PROFILE_2_SLICE_REV1, 2, 12),
+		},
+	},
+	{nameParts: []string{"H100", "80GB"}, profiles: a100And80GBProfiles},
+	// the PCIe H100 has 80GB but does not say so in its name
+	{nameParts: []string{"H100", "PCIE"}, profiles: a100And80GBProfiles},
+}
+
+// SupportedProfilesForDevice returns the MIG profiles of a GPU model, given its name as reported by NVML,
+// without querying the GPU. This lets callers running where NVML is not available, like a validating webhook,
+// check profile names against the GPU model of a node. It returns nil for models it does not know.
+func SupportedProfilesForDevice(deviceName string) []MigProfile {
+	name := strings.ToUpper(deviceName)
+	for _, device := range knownDevices {
+		if containsAll(name, device.nameParts) {
+			profiles := make([]MigProfile, len(device.profiles))
+			copy(profiles, device.profiles)
+			return profiles
+		}
+	}
+	return nil
+}
+
+// containsAll reports whether every part appears in s.
+func containsAll(s string, parts []string) bool {
+	for _, part := range parts {
+		if !strings.Contains(s, part) {
+			return false
+		}
+	}
+	return true
+}
+
+// possiblePlacements returns where GPU instances of the profile can be carved on the device. Some drivers report
+// no placement at all for a profile they support, the placements are then derived from the slice counts so that
+// the profile stays allocatable.
+func possiblePlacements(device nvml.Device, giProfileInfo nvml.GpuInstanceProfileInfo) ([]nvml.GpuInstancePlacement, nvml.Return) {
+	placements, ret := device.GetGpuInstancePossiblePlacements(&giProfileInfo)
+	if ret != nvml.SUCCESS || len(placements) > 0 {
+		return placements, ret
+	}
+	totalSlices, ret := deviceSliceCount(device)
+	if ret != nvml.SUCCESS {
+		return nil, ret
+	}
+	log.Log.Info("no placement reported for GPU instance profile, deriving them from its slice count", "giProfileId", giProfileInfo.Id)
+	return defaultPlacements(giProfileInfo.SliceCount, totalSlices), nvml.SUCCESS
+}
+
+// deviceSliceCount returns the number of slices of the device, which its largest GPU instance profile spans.
+func deviceSliceCount(device nvml.Device) (uint32, nvml.Return) {
+	var totalSlices uint32
+	for i := 0; i < nvml.GPU_INSTANCE_PROFILE_COUNT; i++ {
+		giProfileInfo, ret := device.GetGpuInstanceProfileInfo(i)
+		if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return 0, ret
+		}
+		if giProfileInfo.SliceCount > totalSlices {
+			totalSlices = giProfileInfo.SliceCount
+		}
+	}
+	return totalSlices, nvml.SUCCESS
+}
+
+// defaultPlacements returns a placement of sliceCount slices at every multiple of the slice count that fits in
+// totalSlices.
+func defaultPlacements(sliceCount uint32, totalSlices uint32) []nvml.GpuInstancePlacement {
+	var placements []nvml.GpuInstancePlacement
+	if sliceCount == 0 {
+		return placements
+	}
+	for start := uint32(0); start+sliceCount <= totalSlices; start += sliceCount {
+		placements = append(placements, nvml.GpuInstancePlacement{Start: start, Size: sliceCount})
+	}
+	return placements
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// profileNames returns the names of the profiles in order.
+func profileNames(profiles []MigProfile) []string {
+	var names []string
+	for _, profile := range profiles {
+		names = append(names, profile.String())
+	}
+	return names
+}
+
+func TestSupportedProfilesForA100With40GB(t *testing.T) {
+	expected := []string{"1g.5gb", "2g.10gb", "3g.20gb", "4g.20gb", "7g.40gb", "1g.5gb+me", "1g.10gb"}
+	for _, name := range []string{"NVIDIA A100-SXM4-40GB", "NVIDIA A100-PCIE-40GB"} {
+		assert.Equal(t, expected, profileNames(SupportedProfilesForDevice(name)), name)
+	}
+}
+
+func TestSupportedProfilesMatchDiscoveredProfiles(t *testing.T) {
+	t.Setenv(FakeGPUEnv, "1")
+	nvmllib, err := newNvmlLib(nil)
+	require.NoError(t, err)
+	reconciler := &InstaSliceDaemonsetReconciler{nvmlHandler: newDeviceHandler(nvmllib)}
+	instaslice, _, gpuModelMap, _, _, err := reconciler.discoverAvailableProfilesOnGpus(context.Background())
+	require.NoError(t, err)
+
+	var discovered []string
+	for _, mig := range instaslice.Spec.Migplacement {
+		discovered = append(discovered, mig.Profile)
+	}
+	for _, deviceName := range gpuModelMap {
+		assert.ElementsMatch(t, discovered, profileNames(SupportedProfilesForDevice(deviceName)))
+	}
+}
+
+func TestSupportedProfilesForOtherModels(t *testing.T) {
+	assert.Contains(t, profileNames(SupportedProfilesForDevice("NVIDIA A100-SXM4-80GB")), "7g.80gb")
+	assert.Contains(t, profileNames(SupportedProfilesForDevice("NVIDIA H100 80GB HBM3")), "3g.40gb")
+	assert.Contains(t, profileNames(SupportedProfilesForDevice("NVIDIA H100 PCIe")), "1g.10gb+me")
+	assert.Equal(t, []string{"1g.6gb", "2g.12gb", "4g.24gb", "1g.6gb+me", "2g.12gb+me"}, profileNames(SupportedProfilesForDevice("NVIDIA A30")))
+}
+
+func TestSupportedProfilesRejectsUnknownDevices(t *testing.T) {
+	for _, name := range []string{"", "NVIDIA T4", "NVIDIA A100", "NVIDIA L40S"} {
+		assert.Nil(t, SupportedProfilesForDevice(name), name)
+	}
+}
+
+func TestSupportedProfilesAreNotShared(t *testing.T) {
+	profiles := SupportedProfilesForDevice("NVIDIA A100-SXM4-80GB")
+	profiles[0].GB = 0
+	assert.Equal(t, "1g.10gb", SupportedProfilesForDevice("NVIDIA H100 80GB HBM3")[0].String())
+}
+
+func TestEmptyPlacementsAreBackfilled(t *testing.T) {
+	t.Setenv(FakeGPUEnv, "1")
+	nvmllib, err := newNvmlLib(nil)
+	require.NoError(t, err)
+	handle, ret := nvmllib.DeviceGetHandleByIndex(0)
+	require.Equal(t, nvml.SUCCESS, ret)
+	device := handle.(*dgxa100.Device)
+	// the driver supports the 2 slice profile but reports no placement for it
+	reportedPlacements := device.GetGpuInstancePossiblePlacementsFunc
+	device.GetGpuInstancePossiblePlacementsFunc = func(info *nvml.GpuInstanceProfileInfo) ([]nvml.GpuInstancePlacement, nvml.Return) {
+		if info.Id == nvml.GPU_INSTANCE_PROFILE_2_SLICE {
+			return []nvml.GpuInstancePlacement{}, nvml.SUCCESS
+		}
+		return reportedPlacements(info)
+	}
+	reconciler := &InstaSliceDaemonsetReconciler{nvmlHandler: newDeviceHandler(nvmllib)}
+	instaslice, _, _, _, _, err := reconciler.discoverAvailableProfilesOnGpus(context.Background())
+	require.NoError(t, err)
+
+	placements := make(map[string][]inferencev1alpha1.Placement)
+	for _, mig := range instaslice.Spec.Migplacement {
+		placements[mig.Profile] = mig.Placements
+	}
+	assert.Equal(t, []inferencev1alpha1.Placement{{Start: 0, Size: 2}, {Start: 2, Size: 2}, {Start: 4, Size: 2}}, placements["2g.10gb"])
+	// profiles with reported placements keep them
+	assert.Equal(t, []inferencev1alpha1.Placement{{Start: 0, Size: 4}, {Start: 4, Size: 4}}, placements["3g.20gb"])
+}
+
+func TestDefaultPlacements(t *testing.T) {
+	assert.Len(t, defaultPlacements(1, 7), 7)
+	assert.Equal(t, []nvml.GpuInstancePlacement{{Start: 0, Size: 3}, {Start: 3, Size: 3}}, defaultPlacements(3, 7))
+	assert.Equal(t, []nvml.GpuInstancePlacement{{Start: 0, Size: 7}}, defaultPlacements(7, 7))
+	assert.Empty(t, defaultPlacements(0, 7))
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// sliceOperationNow is the clock slice operations are rated against.
+var sliceOperationNow = time.Now
+
+// SliceOperationPolicy tells how often and when the NVML create and destroy operations of the node may run.
+type SliceOperationPolicy struct {
+	// Rate is the number of slices the node creates or destroys per second at most, operations in excess are
+	// deferred. Unlimited when unset.
+	Rate float64
+	// MaintenanceWindow is the daily range of time slices are created and idle or preempted slices destroyed in,
+	// outside it these operations are deferred while the slices of deleted pods are still destroyed. Slices are
+	// created and destroyed at any time when unset.
+	MaintenanceWindow *MaintenanceWindow
+}
+
+// sliceOperationThrottledError is returned when creating or destroying a slice now would exceed the rate
+// allowed on the node, the operation is deferred rather than sent to the driver.
+type sliceOperationThrottledError struct {
+	retryAfter time.Duration
+}
+
+func (e *sliceOperationThrottledError) Error() string {
+	return fmt.Sprintf("slice operation rate exceeded, retry after %v", e.retryAfter)
+}
+
+// sliceOperationRetryAfter returns how long to defer the operation that failed with err when it was throttled,
+// attempted outside the maintenance window or aimed at a placement the GPU does not offer.
+func sliceOperationRetryAfter(err error) (time.Duration, bool) {
+	var throttled *sliceOperationThrottledError
+	if errors.As(err, &throttled) {
+		return throttled.retryAfter, true
+	}
+	var outside *outsideMaintenanceWindowError
+	if errors.As(err, &outside) {
+		return outside.retryAfter, true
+	}
+	var notPossible *placementNotPossibleError
+	if errors.As(err, &notPossible) {
+		return notPossible.retryAfter, true
+	}
+	return 0, false
+}
+
+// sliceOperationLimiter returns the limiter shared by the NVML create and destroy operations of the node,
+// nil when they are not rate limited. One operation is let through at a time so that bursts are spread out.
+func (r *InstaSliceDaemonsetReconciler) sliceOperationLimiter() *rate.Limiter {
+	if r.SliceOperationPolicy.Rate <= 0 {
+		return nil
+	}
+	r.sliceOperationsOnce.Do(func() {
+		r.sliceOperations = rate.NewLimiter(rate.Limit(r.SliceOperationPolicy.Rate), 1)
+	})
+	return r.sliceOperations
+}
+
+// reserveSliceOperation accounts for an NVML create or destroy operation about to be made. It returns a
+// sliceOperationThrottledError without accounting for it when the operation has to wait.
+func (r *InstaSliceDaemonsetReconciler) reserveSliceOperation() error {
+	limiter := r.sliceOperationLimiter()
+	if limiter == nil {
+		return nil
+	}
+	now := sliceOperationNow()
+	reservation := limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return &sliceOperationThrottledError{retryAfter: delay}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestSliceCreationsSpreadOverConfiguredRate(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1, 2, 3, 4)
+	reconciler.SliceOperationPolicy.Rate = 2
+	ctx := context.Background()
+	start := time.Unix(0, 0)
+	clock := start
+	sliceOperationNow = func() time.Time { return clock }
+	t.Cleanup(func() { sliceOperationNow = time.Now })
+
+	for created := 1; created <= 5; created++ {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+		require.NoError(t, err)
+		// the burst is carved one slice per reconcile, the rest is deferred until the rate allows it
+		assert.Len(t, device.GpuInstances, created)
+		if created < 5 {
+			assert.Equal(t, 500*time.Millisecond, result.RequeueAfter)
+			// the deferred slice is not carved before its time
+			_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+			require.NoError(t, err)
+			assert.Len(t, device.GpuInstances, created)
+			clock = clock.Add(result.RequeueAfter)
+		}
+	}
+	assert.Equal(t, 2*time.Second, clock.Sub(start))
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	for _, allocation := range instaslice.Spec.Allocations {
+		assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, allocation.Allocationstatus)
+	}
+}
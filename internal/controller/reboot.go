@@ -0,0 +1,197 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// recarveSlicesAfterReboot realizes again the slices the instaslice records but the GPUs no longer have, as
+// happens when the node rebooted. Slices of pods still running are carved at the same placement and their
+// ConfigMap points to the new MIG device, allocations of pods that are gone are dropped with their slice.
+// A slice that cannot be carved again goes back to creating so that the next reconcile retries it, the failed
+// attempt counting towards the MaxAttempts of the retry policy and spacing the next ones like any failed creation.
+func (r *InstaSliceDaemonsetReconciler) recarveSlicesAfterReboot(ctx context.Context, nodeName string) error {
+	nvmllib := r.handler().nvml
+	var instaslice inferencev1alpha1.Instaslice
+	typeNamespacedName := types.NamespacedName{
+		Name:      nodeName,
+		Namespace: r.instasliceNamespace(),
+	}
+	if err := r.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+		return err
+	}
+	migUUIDs, ret := migUUIDsOnNode(nvmllib)
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to list MIG devices: %v", ret)
+	}
+	var lost []string
+	for migUUID := range instaslice.Status.Prepared {
+		if _, exists := migUUIDs[migUUID]; !exists {
+			lost = append(lost, migUUID)
+		}
+	}
+	if len(lost) == 0 {
+		return nil
+	}
+	sort.Strings(lost)
+	log.FromContext(ctx).Info("slices recorded for the node are missing on the GPUs, reconciling them", "count", len(lost))
+
+	// a slice split in several compute instances has a lost entry for each of them but is carved once
+	recarvedPods := make(map[string]bool)
+	retried := make(map[string]error)
+	for _, migUUID := range lost {
+		prepared := instaslice.Status.Prepared[migUUID]
+		delete(instaslice.Status.Prepared, migUUID)
+		allocation, exists := instaslice.Spec.Allocations[prepared.PodUUID]
+		if prepared.PodUUID == "" || !exists || recarvedPods[prepared.PodUUID] {
+			continue
+		}
+		recarvedPods[prepared.PodUUID] = true
+		running, err := r.podStillRunning(ctx, allocation)
+		if err != nil {
+			return err
+		}
+		if !running || !recarvable(allocation) {
+			log.FromContext(ctx).Info("dropping allocation whose slice was lost for ", "pod", allocation.PodName, "status", allocation.Allocationstatus)
+			if err := r.releaseLostSlice(ctx, allocation); err != nil {
+				return err
+			}
+			r.slices.forget(allocation.PodName)
+			delete(instaslice.Spec.Allocations, allocation.PodUUID)
+			continue
+		}
+		recarved, err := r.recarveSlice(ctx, nvmllib, nodeName, instaslice, allocation)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "unable to carve lost slice again, retrying for ", "pod", allocation.PodName)
+			r.slices.forget(allocation.PodName)
+			allocation.Allocationstatus = inferencev1alpha1.AllocationStatusCreating
+			instaslice.Spec.Allocations[allocation.PodUUID] = allocation
+			retried[allocation.PodUUID] = err
+			continue
+		}
+		for newMigUUID, prepared := range recarved {
+			log.FromContext(ctx).Info("carved lost slice again for ", "pod", allocation.PodName, "migUUID", newMigUUID)
+			instaslice.Status.Prepared[newMigUUID] = prepared
+		}
+	}
+	if err := r.writeInstaslice(ctx, &instaslice); err != nil {
+		return err
+	}
+	// sent back to creating on purpose, not a stale read of the allocations
+	for podUUID, errRecarving := range retried {
+		r.recordAllocationStatus(podUUID, inferencev1alpha1.AllocationStatusCreating)
+		if _, err := r.sliceCreationFailed(ctx, instaslice.Name, instaslice.Spec.Allocations[podUUID], errRecarving); err != nil {
+			return err
+		}
+	}
+	return r.updateNodeCapacity(ctx, nodeName)
+}
+
+// recarvable reports whether the lost slice of the allocation is carved again, which is the case while its pod
+// is waiting for the slice or running on it.
+func recarvable(allocation inferencev1alpha1.AllocationDetails) bool {
+	switch allocation.Allocationstatus {
+	case inferencev1alpha1.AllocationStatusCreating, inferencev1alpha1.AllocationStatusCreated, inferencev1alpha1.AllocationStatusUngated:
+		return true
+	}
+	return false
+}
+
+// podStillRunning reports whether the pod of the allocation still exists and has not terminated.
+func (r *InstaSliceDaemonsetReconciler) podStillRunning(ctx context.Context, allocation inferencev1alpha1.AllocationDetails) (bool, error) {
+	var pod v1.Pod
+	if err := r.Get(ctx, types.NamespacedName{Name: allocation.PodName, Namespace: allocation.Namespace}, &pod); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if string(pod.UID) != allocation.PodUUID {
+		return false, nil
+	}
+	return pod.Status.Phase != v1.PodSucceeded && pod.Status.Phase != v1.PodFailed, nil
+}
+
+// releaseLostSlice frees what the pod of a lost slice held on the node.
+func (r *InstaSliceDaemonsetReconciler) releaseLostSlice(ctx context.Context, allocation inferencev1alpha1.AllocationDetails) error {
+	if err := r.deleteConfigMap(ctx, allocation.PodName, allocation.Namespace, allocation.PodUUID); err != nil {
+		return err
+	}
+	if err := r.removeConfigMapFinalizer(ctx, allocation.PodName, allocation.Namespace, allocation.PodUUID); err != nil {
+		return err
+	}
+	return r.cleanUpInstaSliceResource(ctx, allocation)
+}
+
+// recarveSlice carves the slice of the allocation again at its recorded placement and maps its pod to it.
+// It returns the Prepared entries of the new slice keyed by MIG UUID.
+func (r *InstaSliceDaemonsetReconciler) recarveSlice(ctx context.Context, nvmllib nvml.Interface, nodeName string, instaslice inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) (map[string]inferencev1alpha1.PreparedDetails, error) {
+	device, ret := nvmllib.DeviceGetHandleByUUID(allocation.GPUUUID)
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("unable to get GPU %s: %v", allocation.GPUUUID, ret)
+	}
+	allocation, err := resolveAllocationProfile(device, instaslice, allocation)
+	if err != nil {
+		return nil, err
+	}
+	placement := nvml.GpuInstancePlacement{Start: allocation.Start, Size: allocation.Size}
+	createdSlice, err := r.carveSlice(ctx, device, instaslice, allocation, placement)
+	if err != nil {
+		return nil, err
+	}
+	if createdSlice.miguuid == "" {
+		return nil, fmt.Errorf("MIG device of the slice was not found")
+	}
+	r.slices.cache(allocation.PodName, createdSlice)
+
+	// the ConfigMap still names the lost MIG device, replace it
+	if err := r.deleteConfigMap(ctx, allocation.PodName, allocation.Namespace, allocation.PodUUID); err != nil {
+		return nil, err
+	}
+	if err := r.removeConfigMapFinalizer(ctx, allocation.PodName, allocation.Namespace, allocation.PodUUID); err != nil {
+		return nil, err
+	}
+	if err := r.createConfigMap(ctx, createdSlice.migUUIDs(), allocation.Namespace, allocation.PodName, allocation.PodUUID, allocation.Profile, createdSlice.memorySizeMB); err != nil {
+		return nil, err
+	}
+	if err := r.createInstaSliceResource(ctx, nodeName, allocation); err != nil {
+		return nil, err
+	}
+	recarved := make(map[string]inferencev1alpha1.PreparedDetails)
+	for _, ci := range createdSlice.migDevices() {
+		recarved[ci.miguuid] = inferencev1alpha1.PreparedDetails{
+			Profile:  allocation.Profile,
+			Start:    allocation.Start,
+			Size:     allocation.Size,
+			Parent:   allocation.GPUUUID,
+			PodUUID:  allocation.PodUUID,
+			Giinfoid: createdSlice.gid,
+			Ciinfoid: ci.cid,
+			GPUModel: instaslice.Spec.MigGPUUUID[allocation.GPUUUID],
+			CarvedBy: r.operatorVersion(),
+		}
+	}
+	return recarved, nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestRecarveSlicesAfterReboot(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1, 2)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	// pod-2 went away while the node was down
+	for i := 0; i < 2; i++ {
+		require.NoError(t, fakeClient.Create(ctx, &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("pod-%d", i),
+			Namespace: "default",
+			UID:       types.UID(fmt.Sprintf("pod-uid-%d", i)),
+		}}))
+	}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	require.Len(t, instaslice.Status.Prepared, 3)
+	ungated := instaslice.Spec.Allocations["pod-uid-1"]
+	ungated.Allocationstatus = inferencev1alpha1.AllocationStatusUngated
+	instaslice.Spec.Allocations["pod-uid-1"] = ungated
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	lostMigUUIDs := make(map[string]bool)
+	for migUUID := range instaslice.Status.Prepared {
+		lostMigUUIDs[migUUID] = true
+	}
+
+	// the reboot wipes the slices off the GPU and the daemonset restarts with an empty cache
+	device.GpuInstances = make(map[*dgxa100.GpuInstance]struct{})
+	reconciler.slices = sliceCache{}
+
+	require.NoError(t, reconciler.recarveSlicesAfterReboot(ctx, "node-1"))
+
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusUngated, instaslice.Spec.Allocations["pod-uid-1"].Allocationstatus)
+	assert.NotContains(t, instaslice.Spec.Allocations, "pod-uid-2")
+	assert.Len(t, device.GpuInstances, 2)
+	require.Len(t, instaslice.Status.Prepared, 2)
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		assert.False(t, lostMigUUIDs[migUUID])
+		allocation := instaslice.Spec.Allocations[prepared.PodUUID]
+		assert.Equal(t, allocation.Start, prepared.Start)
+		var configMap v1.ConfigMap
+		require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: allocation.PodName, Namespace: "default"}, &configMap))
+		assert.Equal(t, migUUID, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	}
+	err = fakeClient.Get(ctx, types.NamespacedName{Name: "pod-2", Namespace: "default"}, &v1.ConfigMap{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestRecarveSlicesAfterRebootCarvesCreatingAllocations(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	require.NoError(t, fakeClient.Create(ctx, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default", UID: "pod-uid-0"}}))
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	// the slice was carved but the allocation not yet marked created when it was lost
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	require.Len(t, instaslice.Status.Prepared, 1)
+	creating := instaslice.Spec.Allocations["pod-uid-0"]
+	creating.Allocationstatus = inferencev1alpha1.AllocationStatusCreating
+	instaslice.Spec.Allocations["pod-uid-0"] = creating
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	device.GpuInstances = make(map[*dgxa100.GpuInstance]struct{})
+	reconciler.slices = sliceCache{}
+
+	require.NoError(t, reconciler.recarveSlicesAfterReboot(ctx, "node-1"))
+
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Contains(t, instaslice.Spec.Allocations, "pod-uid-0")
+	assert.Len(t, instaslice.Status.Prepared, 1)
+	assert.Len(t, device.GpuInstances, 1)
+}
+
+func TestRecarveSlicesAfterRebootGivesUpAfterMaxAttempts(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.SliceCreationRetryPolicy.MaxAttempts = 1
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	require.NoError(t, fakeClient.Create(ctx, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default", UID: "pod-uid-0"}}))
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	device.GpuInstances = make(map[*dgxa100.GpuInstance]struct{})
+	reconciler.slices = sliceCache{}
+	device.CreateGpuInstanceWithPlacementFunc = func(*nvml.GpuInstanceProfileInfo, *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		return nil, nvml.ERROR_UNKNOWN
+	}
+
+	require.NoError(t, reconciler.recarveSlicesAfterReboot(ctx, "node-1"))
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusFailed, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	assert.Empty(t, instaslice.Status.Prepared)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// sliceProfileName returns the name the current naming scheme gives to the slice carved as the compute instance ci
+// of the GPU instance gi on device, the same name discovery advertises for its profile.
+func sliceProfileName(device nvml.Device, gi nvml.GpuInstance, ci nvml.ComputeInstance) (string, error) {
+	memoryTotal, _, err := profileMemoryTotal(device)
+	if err != nil {
+		return "", err
+	}
+	giInfo, ret := gi.GetInfo()
+	if ret != nvml.SUCCESS {
+		return "", fmt.Errorf("unable to get GPU instance info: %v", ret)
+	}
+	giProfileInfo, ret := device.GetGpuInstanceProfileInfo(int(giInfo.ProfileId))
+	if ret != nvml.SUCCESS {
+		return "", fmt.Errorf("unable to get GPU instance profile %d: %v", giInfo.ProfileId, ret)
+	}
+	ciInfo, ret := ci.GetInfo()
+	if ret != nvml.SUCCESS {
+		return "", fmt.Errorf("unable to get compute instance info: %v", ret)
+	}
+	ciProfileInfo, ret := gi.GetComputeInstanceProfileInfo(int(ciInfo.ProfileId), nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED)
+	if ret != nvml.SUCCESS {
+		return "", fmt.Errorf("unable to get compute instance profile %d: %v", ciInfo.ProfileId, ret)
+	}
+	profile := NewMigProfile(int(giInfo.ProfileId), int(ciInfo.ProfileId), nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED,
+		giProfileInfo.SliceCount, ciProfileInfo.SliceCount, giProfileInfo.MemorySizeMB, memoryTotal)
+	return profile.String(), nil
+}
+
+// preparedProfileName returns the current name of the profile of the slice backing the prepared entry, or false
+// when the slice is no longer on the GPU.
+func preparedProfileName(nvmllib nvml.Interface, prepared inferencev1alpha1.PreparedDetails) (string, bool, error) {
+	device, ret := nvmllib.DeviceGetHandleByUUID(prepared.Parent)
+	if ret != nvml.SUCCESS {
+		return "", false, fmt.Errorf("unable to get GPU %s: %v", prepared.Parent, ret)
+	}
+	gi, ret := device.GetGpuInstanceById(int(prepared.Giinfoid))
+	if ret == nvml.ERROR_NOT_FOUND {
+		return "", false, nil
+	}
+	if ret != nvml.SUCCESS {
+		return "", false, fmt.Errorf("unable to get GPU instance %d: %v", prepared.Giinfoid, ret)
+	}
+	ci, ret := gi.GetComputeInstanceById(int(prepared.Ciinfoid))
+	if ret == nvml.ERROR_NOT_FOUND {
+		return "", false, nil
+	}
+	if ret != nvml.SUCCESS {
+		return "", false, fmt.Errorf("unable to get compute instance %d: %v", prepared.Ciinfoid, ret)
+	}
+	name, err := sliceProfileName(device, gi, ci)
+	if err != nil {
+		return "", false, err
+	}
+	return name, true, nil
+}
+
+// relabelPreparedProfiles renames the profile of the Prepared entries of the node to the name the current naming
+// scheme derives from their slice, so that entries written by an earlier version keep matching the discovered
+// profiles, e.g. once the GB rounding changed. Entries whose slice is gone are left to the reboot handling.
+func (r *InstaSliceDaemonsetReconciler) relabelPreparedProfiles(ctx context.Context, nodeName string) error {
+	nvmllib := r.handler().nvml
+	var instaslice inferencev1alpha1.Instaslice
+	typeNamespacedName := types.NamespacedName{
+		Name:      nodeName,
+		Namespace: r.instasliceNamespace(),
+	}
+	if err := r.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+		return err
+	}
+	renamed := make(map[string]string)
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		// foreign slices have no name in the current naming scheme either
+		if prepared.Profile == ForeignSliceProfile {
+			continue
+		}
+		name, found, err := preparedProfileName(nvmllib, prepared)
+		if err != nil {
+			return err
+		}
+		if found && name != prepared.Profile {
+			renamed[migUUID] = name
+		}
+	}
+	if len(renamed) == 0 {
+		return nil
+	}
+
+	migUUIDs := make([]string, 0, len(renamed))
+	for migUUID := range renamed {
+		migUUIDs = append(migUUIDs, migUUID)
+	}
+	sort.Strings(migUUIDs)
+	_, err := r.updateInstaslice(ctx, nodeName, func(latest *inferencev1alpha1.Instaslice) error {
+		changed := false
+		for _, migUUID := range migUUIDs {
+			prepared, exists := latest.Status.Prepared[migUUID]
+			// the slice was torn down or replaced in the meantime
+			if !exists || prepared.Giinfoid != instaslice.Status.Prepared[migUUID].Giinfoid ||
+				prepared.Ciinfoid != instaslice.Status.Prepared[migUUID].Ciinfoid || prepared.Profile == renamed[migUUID] {
+				continue
+			}
+			log.FromContext(ctx).Info("renaming the profile of a prepared slice to the current naming scheme",
+				"migUUID", migUUID, "from", prepared.Profile, "to", renamed[migUUID])
+			prepared.Profile = renamed[migUUID]
+			latest.Status.Prepared[migUUID] = prepared
+			changed = true
+		}
+		if !changed {
+			return errInstasliceUnchanged
+		}
+		return nil
+	})
+	return err
+}
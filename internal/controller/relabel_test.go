@@ -0,0 +1,71 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestRelabelPreparedProfilesOnUpgrade(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	// an earlier version rounded the memory of the slice down and wrote another name
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	require.Len(t, instaslice.Status.Prepared, 1)
+	var migUUID string
+	for uuid, prepared := range instaslice.Status.Prepared {
+		migUUID = uuid
+		require.Equal(t, "1g.5gb", prepared.Profile)
+		prepared.Profile = "1g.4gb"
+		instaslice.Status.Prepared[uuid] = prepared
+	}
+	// the slice of this entry is gone, it is left to the reboot handling
+	instaslice.Status.Prepared["MIG-gone"] = inferencev1alpha1.PreparedDetails{
+		Profile:  "2g.9gb",
+		Parent:   instaslice.Status.Prepared[migUUID].Parent,
+		PodUUID:  "pod-uid-gone",
+		Giinfoid: 100,
+		Ciinfoid: 0,
+	}
+	require.NoError(t, fakeClient.Status().Update(ctx, &instaslice))
+
+	require.NoError(t, reconciler.relabelPreparedProfiles(ctx, "node-1"))
+	var latest inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &latest))
+	assert.Equal(t, "1g.5gb", latest.Status.Prepared[migUUID].Profile)
+	assert.Equal(t, instaslice.Status.Prepared[migUUID].Giinfoid, latest.Status.Prepared[migUUID].Giinfoid)
+	assert.Equal(t, "2g.9gb", latest.Status.Prepared["MIG-gone"].Profile)
+
+	// nothing is written once the names are current
+	resourceVersion := latest.ResourceVersion
+	require.NoError(t, reconciler.relabelPreparedProfiles(ctx, "node-1"))
+	require.NoError(t, fakeClient.Get(ctx, nsName, &latest))
+	assert.Equal(t, resourceVersion, latest.ResourceVersion)
+}
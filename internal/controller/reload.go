@@ -0,0 +1,260 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// Strategies the daemonset can use to make the device plugin pick up the slices of the node.
+	CapacityReloadLabel = "label"
+	CapacityReloadFile  = "file"
+
+	// DevicePluginConfigLabel is the label of the node toggled by LabelToggleReloader.
+	DevicePluginConfigLabel = "nvidia.com/device-plugin.config"
+	// DefaultDevicePluginConfig is the value the label toggles from while the GPU models of the node are unknown.
+	DefaultDevicePluginConfig = "update-capacity"
+)
+
+var (
+	// gpuFamilyPattern and gpuMemoryPattern match the words of a GPU model name, e.g. A100 and 40GB of
+	// NVIDIA A100-SXM4-40GB, the first word holding letters then digits is the family.
+	gpuFamilyPattern = regexp.MustCompile(`^[a-z]+[0-9]+$`)
+	gpuMemoryPattern = regexp.MustCompile(`^[0-9]+gb$`)
+)
+
+// CapacityReloader makes the device plugin re-read its configuration so that node capacity reflects the
+// slices carved or destroyed on the node.
+type CapacityReloader interface {
+	Reload(ctx context.Context, nodeName string) error
+}
+
+// LabelToggleReloader flips the device plugin config label of the node, the plugin restarts on the change.
+// Capacity briefly drops to zero while the plugin restarts, this is the default reloader.
+type LabelToggleReloader struct {
+	Client client.Client
+	// APIReader reads the node from the API server when the cached one was stale, Client is used when unset.
+	APIReader client.Reader
+	// BaseConfig is the device plugin config of the GPU model of the node, DefaultDevicePluginConfig when unset.
+	BaseConfig string
+}
+
+// Reload toggles the DevicePluginConfigLabel between the base config and the base config suffixed with -1.
+func (l *LabelToggleReloader) Reload(ctx context.Context, nodeName string) error {
+	node := &v1.Node{}
+	nodeNameObject := types.NamespacedName{Name: nodeName}
+	err := l.Client.Get(ctx, nodeNameObject, node)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to get node object")
+		return err
+	}
+	err = l.toggleLabel(ctx, node)
+	if apierrors.IsConflict(err) {
+		// the cached node is behind, flipping its label value could set the current one again
+		reader := l.APIReader
+		if reader == nil {
+			reader = l.Client
+		}
+		node, err = getFreshNode(ctx, reader, nodeName)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "unable to get node object")
+			return err
+		}
+		err = l.toggleLabel(ctx, node)
+	}
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to update Node")
+		return err
+	}
+	return nil
+}
+
+// baseConfig returns the value the label toggles from.
+func (l *LabelToggleReloader) baseConfig() string {
+	if l.BaseConfig == "" {
+		return DefaultDevicePluginConfig
+	}
+	return l.BaseConfig
+}
+
+// toggleLabel patches the flipped label on the node, the patch is rejected when the node changed since it was read.
+// A label removed by other tooling is restored first, the toggle would not trigger reloads anymore otherwise.
+func (l *LabelToggleReloader) toggleLabel(ctx context.Context, node *v1.Node) error {
+	if _, found := node.Labels[DevicePluginConfigLabel]; !found {
+		if err := l.restoreLabel(ctx, node); err != nil {
+			return err
+		}
+	}
+	patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	base := l.baseConfig()
+	switch value := node.Labels[DevicePluginConfigLabel]; value {
+	case base + "-1":
+		node.Labels[DevicePluginConfigLabel] = base
+	case base:
+		node.Labels[DevicePluginConfigLabel] = base + "-1"
+	default:
+		// the label names a config of the cluster setup this daemonset does not know the other value of
+		log.FromContext(ctx).Info("device plugin config label does not match the GPU model of the node, not toggling it",
+			"node", node.Name, "value", value, "expected", base)
+		return nil
+	}
+	return l.Client.Patch(ctx, node, patch)
+}
+
+// restoreLabel sets the device plugin config label of the node back to the base config.
+func (l *LabelToggleReloader) restoreLabel(ctx context.Context, node *v1.Node) error {
+	patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	if node.Labels == nil {
+		node.Labels = make(map[string]string)
+	}
+	node.Labels[DevicePluginConfigLabel] = l.baseConfig()
+	if err := l.Client.Patch(ctx, node, patch); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("restored the device plugin config label removed from the node", "node", node.Name, "value", l.baseConfig())
+	return nil
+}
+
+// DevicePluginConfigForModel returns the device plugin config of a GPU model, its lowercased family and memory,
+// e.g. a100-40gb for NVIDIA A100-SXM4-40GB or h100-80gb for NVIDIA H100 80GB HBM3. It returns false for names
+// without both.
+func DevicePluginConfigForModel(model string) (string, bool) {
+	var family, memory string
+	for _, word := range strings.FieldsFunc(strings.ToLower(model), func(r rune) bool { return r == ' ' || r == '-' || r == '_' }) {
+		if family == "" && gpuFamilyPattern.MatchString(word) {
+			family = word
+		}
+		if memory == "" && gpuMemoryPattern.MatchString(word) {
+			memory = word
+		}
+	}
+	if family == "" || memory == "" {
+		return "", false
+	}
+	return family + "-" + memory, true
+}
+
+// devicePluginConfigForModels returns the device plugin config shared by the GPUs of the node, by uuid, an error
+// when a model is unknown or the GPUs need different configs.
+func devicePluginConfigForModels(gpuModels map[string]string) (string, error) {
+	configs := make(map[string]bool)
+	for uuid, model := range gpuModels {
+		config, ok := DevicePluginConfigForModel(model)
+		if !ok {
+			return "", fmt.Errorf("no device plugin config for the model %q of GPU %s", model, uuid)
+		}
+		configs[config] = true
+	}
+	if len(configs) > 1 {
+		names := make([]string, 0, len(configs))
+		for config := range configs {
+			names = append(names, config)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("the GPUs of the node need different device plugin configs %s", strings.Join(names, ", "))
+	}
+	for config := range configs {
+		return config, nil
+	}
+	return DefaultDevicePluginConfig, nil
+}
+
+// devicePluginBaseConfig is the device plugin config derived from the GPU models found at discovery.
+type devicePluginBaseConfig struct {
+	base string
+	err  error
+}
+
+// recordDevicePluginConfig derives the device plugin config the label toggle uses from the discovered GPU models.
+func (r *InstaSliceDaemonsetReconciler) recordDevicePluginConfig(ctx context.Context, gpuModels map[string]string) {
+	base, err := devicePluginConfigForModels(gpuModels)
+	if err != nil {
+		log.FromContext(ctx).Info("unable to derive the device plugin config of the node, the config label will not be toggled", "reason", err.Error())
+	}
+	r.devicePluginConfig.Store(&devicePluginBaseConfig{base: base, err: err})
+}
+
+// skippedReloader stands in for the label toggle on nodes without a device plugin config, toggling the label
+// to a config the cluster setup does not have would leave the plugin without one.
+type skippedReloader struct {
+	reason error
+}
+
+// Reload logs why the device plugin is not made to reload.
+func (s skippedReloader) Reload(ctx context.Context, nodeName string) error {
+	log.FromContext(ctx).Info("skipping the device plugin config label toggle", "node", nodeName, "reason", s.reason.Error())
+	return nil
+}
+
+// FileSignalReloader writes the time of the request to a file shared with the device plugin, which watches
+// it and re-reads its configuration in place without touching the node.
+type FileSignalReloader struct {
+	Path string
+}
+
+// Reload replaces the content of the signal file, the rename makes the plugin never see a partial write.
+func (f *FileSignalReloader) Reload(ctx context.Context, nodeName string) error {
+	if f.Path == "" {
+		return fmt.Errorf("no device plugin reload file set")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to signal device plugin reload: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(time.Now().UTC().Format(time.RFC3339Nano) + "\n"); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to signal device plugin reload: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to signal device plugin reload: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.Path); err != nil {
+		return fmt.Errorf("unable to signal device plugin reload: %w", err)
+	}
+	log.FromContext(ctx).Info("signaled device plugin reload", "node", nodeName, "file", f.Path)
+	return nil
+}
+
+// capacityReloader returns the configured reloader or the label toggle of the GPU model of the node when none is set.
+func (r *InstaSliceDaemonsetReconciler) capacityReloader() CapacityReloader {
+	if r.CapacityReloader != nil {
+		return r.CapacityReloader
+	}
+	reloader := &LabelToggleReloader{Client: r.Client, APIReader: r.APIReader}
+	if config := r.devicePluginConfig.Load(); config != nil {
+		if config.err != nil {
+			return skippedReloader{reason: config.err}
+		}
+		reloader.BaseConfig = config.base
+	}
+	return reloader
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newReloadTestClient returns a client holding node-1 with the device plugin config label set.
+func newReloadTestClient() client.Client {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "node-1",
+		Labels: map[string]string{"nvidia.com/device-plugin.config": "update-capacity"},
+	}}
+	return runtimefake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(node).Build()
+}
+
+func TestUpdateNodeCapacityTogglesLabelByDefault(t *testing.T) {
+	fakeClient := newReloadTestClient()
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient}
+
+	require.NoError(t, reconciler.updateNodeCapacity(context.Background(), "node-1"))
+
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1"}, &node))
+	assert.Equal(t, "update-capacity-1", node.Labels["nvidia.com/device-plugin.config"])
+}
+
+func TestUpdateNodeCapacitySignalsReloadWithoutTouchingNode(t *testing.T) {
+	fakeClient := newReloadTestClient()
+	var before v1.Node
+	require.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1"}, &before))
+	path := filepath.Join(t.TempDir(), "reload")
+	reconciler := &InstaSliceDaemonsetReconciler{Client: fakeClient, CapacityReloader: &FileSignalReloader{Path: path}}
+
+	require.NoError(t, reconciler.updateNodeCapacity(context.Background(), "node-1"))
+	first, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotEmpty(t, first)
+	require.NoError(t, reconciler.updateNodeCapacity(context.Background(), "node-1"))
+	second, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotEqual(t, string(first), string(second))
+
+	var after v1.Node
+	require.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "node-1"}, &after))
+	assert.Equal(t, before.ResourceVersion, after.ResourceVersion)
+	assert.Equal(t, "update-capacity", after.Labels["nvidia.com/device-plugin.config"])
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestFileSignalReloaderRequiresPath(t *testing.T) {
+	assert.Error(t, (&FileSignalReloader{}).Reload(context.Background(), "node-1"))
+}
+
+// restoreRecordingClient records the device plugin config label of every node patch.
+type restoreRecordingClient struct {
+	client.Client
+	labels []string
+}
+
+func (c *restoreRecordingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	value, found := obj.GetLabels()[DevicePluginConfigLabel]
+	if !found {
+		value = "<missing>"
+	}
+	c.labels = append(c.labels, value)
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func TestUpdateNodeCapacityRestoresRemovedLabelBeforeToggling(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "node-1",
+		Labels: map[string]string{"kubernetes.io/hostname": "node-1"},
+	}}
+	recording := &restoreRecordingClient{Client: runtimefake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(node).Build()}
+	reconciler := &InstaSliceDaemonsetReconciler{Client: recording}
+
+	require.NoError(t, reconciler.updateNodeCapacity(context.Background(), "node-1"))
+
+	assert.Equal(t, []string{DefaultDevicePluginConfig, "update-capacity-1"}, recording.labels)
+	var after v1.Node
+	require.NoError(t, recording.Get(context.Background(), types.NamespacedName{Name: "node-1"}, &after))
+	assert.Equal(t, "update-capacity-1", after.Labels["nvidia.com/device-plugin.config"])
+	assert.Equal(t, "node-1", after.Labels["kubernetes.io/hostname"])
+
+	// once restored, the label toggles as usual
+	require.NoError(t, reconciler.updateNodeCapacity(context.Background(), "node-1"))
+	require.NoError(t, recording.Get(context.Background(), types.NamespacedName{Name: "node-1"}, &after))
+	assert.Equal(t, "update-capacity", after.Labels["nvidia.com/device-plugin.config"])
+	assert.Len(t, recording.labels, 3)
+}
+
+func TestDevicePluginConfigForModel(t *testing.T) {
+	for model, expected := range map[string]string{
+		"NVIDIA A100-SXM4-40GB":      "a100-40gb",
+		"NVIDIA A100-PCIE-80GB":      "a100-80gb",
+		"Mock NVIDIA A100-SXM4-40GB": "a100-40gb",
+		"NVIDIA H100 80GB HBM3":      "h100-80gb",
+	} {
+		config, ok := DevicePluginConfigForModel(model)
+		assert.True(t, ok, model)
+		assert.Equal(t, expected, config, model)
+	}
+	_, ok := DevicePluginConfigForModel("NVIDIA H100 PCIe")
+	assert.False(t, ok)
+}
+
+// newModelReloadTestClient returns a client holding node-1, with the device plugin config label set when not empty.
+func newModelReloadTestClient(label string) client.Client {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{}}}
+	if label != "" {
+		node.Labels[DevicePluginConfigLabel] = label
+	}
+	return runtimefake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(node).Build()
+}
+
+// reloadedLabels reloads the capacity of node-1 twice and returns the device plugin config label after each.
+func reloadedLabels(t *testing.T, reconciler *InstaSliceDaemonsetReconciler) []string {
+	var labels []string
+	for i := 0; i < 2; i++ {
+		require.NoError(t, reconciler.updateNodeCapacity(context.Background(), "node-1"))
+		var node v1.Node
+		require.NoError(t, reconciler.Get(context.Background(), types.NamespacedName{Name: "node-1"}, &node))
+		labels = append(labels, node.Labels[DevicePluginConfigLabel])
+	}
+	return labels
+}
+
+func TestUpdateNodeCapacityTogglesConfigOfA100Node(t *testing.T) {
+	reconciler := &InstaSliceDaemonsetReconciler{Client: newModelReloadTestClient("a100-40gb")}
+	reconciler.recordDevicePluginConfig(context.Background(), map[string]string{
+		"GPU-1": "NVIDIA A100-SXM4-40GB",
+		"GPU-2": "NVIDIA A100-SXM4-40GB",
+	})
+
+	assert.Equal(t, []string{"a100-40gb-1", "a100-40gb"}, reloadedLabels(t, reconciler))
+}
+
+func TestUpdateNodeCapacityTogglesConfigOfH100Node(t *testing.T) {
+	reconciler := &InstaSliceDaemonsetReconciler{Client: newModelReloadTestClient("h100-80gb-1")}
+	reconciler.recordDevicePluginConfig(context.Background(), map[string]string{"GPU-1": "NVIDIA H100 80GB HBM3"})
+
+	assert.Equal(t, []string{"h100-80gb", "h100-80gb-1"}, reloadedLabels(t, reconciler))
+}
+
+func TestUpdateNodeCapacitySetsConfigOfModelWhenLabelAbsent(t *testing.T) {
+	reconciler := &InstaSliceDaemonsetReconciler{Client: newModelReloadTestClient("")}
+	reconciler.recordDevicePluginConfig(context.Background(), map[string]string{"GPU-1": "NVIDIA H100 80GB HBM3"})
+
+	assert.Equal(t, []string{"h100-80gb-1", "h100-80gb"}, reloadedLabels(t, reconciler))
+}
+
+func TestUpdateNodeCapacitySkipsToggleOfMixedOrUnknownModels(t *testing.T) {
+	for name, models := range map[string]map[string]string{
+		"mixed":   {"GPU-1": "NVIDIA A100-SXM4-40GB", "GPU-2": "NVIDIA H100 80GB HBM3"},
+		"unknown": {"GPU-1": "NVIDIA H100 PCIe"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			reconciler := &InstaSliceDaemonsetReconciler{Client: newModelReloadTestClient("a100-40gb")}
+			reconciler.recordDevicePluginConfig(context.Background(), models)
+
+			assert.Equal(t, []string{"a100-40gb", "a100-40gb"}, reloadedLabels(t, reconciler))
+		})
+	}
+}
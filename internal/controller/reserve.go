@@ -0,0 +1,151 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// PriorityAnnotation sets the allocation priority of a pod.
+	PriorityAnnotation = "org.instaslice/priority"
+	// PriorityBurst lets a pod be placed on the slots reserved on a GPU.
+	PriorityBurst = "burst"
+)
+
+// reserveFor returns how many smallest profile slots an allocation for the pod must leave free on a GPU.
+func reserveFor(instaslice *inferencev1alpha1.Instaslice, pod *v1.Pod) int {
+	if pod != nil && pod.Annotations[PriorityAnnotation] == PriorityBurst {
+		return 0
+	}
+	return instaslice.Spec.ReservedSlicesPerGPU
+}
+
+// placementsKeepingReserve drops the placements that would leave fewer than reserve free smallest profile slots.
+func placementsKeepingReserve(migPlacements []inferencev1alpha1.Mig, freePlacements []inferencev1alpha1.Placement, occupied PlacementSet, reserve int) []inferencev1alpha1.Placement {
+	if reserve <= 0 {
+		return freePlacements
+	}
+	var kept []inferencev1alpha1.Placement
+	for _, placement := range freePlacements {
+		occupiedAfter := occupied.Clone()
+		occupiedAfter.Occupy(uint32(placement.Start), uint32(placement.Size))
+		if freeSmallestSlots(migPlacements, occupiedAfter) >= reserve {
+			kept = append(kept, placement)
+		}
+	}
+	return kept
+}
+
+// freeSmallestSlots counts the free placements of the smallest profile, the unit the reserve is expressed in.
+func freeSmallestSlots(migPlacements []inferencev1alpha1.Mig, occupied PlacementSet) int {
+	smallest := 0
+	for _, mig := range migPlacements {
+		for _, placement := range mig.Placements {
+			if smallest == 0 || placement.Size < smallest {
+				smallest = placement.Size
+			}
+		}
+	}
+	// profiles of the same size, like 1g.5gb and 1g.5gb+me, share their placements
+	free := make(map[inferencev1alpha1.Placement]struct{})
+	for _, mig := range migPlacements {
+		for _, placement := range mig.Placements {
+			if placement.Size == smallest && placementIsFree(placement, occupied) {
+				free[placement] = struct{}{}
+			}
+		}
+	}
+	return len(free)
+}
+
+// sliceCounts returns the smallest profile slots of all GPUs of the node, how many are taken and how many are free.
+func sliceCounts(instaslice *inferencev1alpha1.Instaslice) (int, int, int) {
+	var total, free int
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		total += freeSmallestSlots(instaslice.Spec.Migplacement, NewPlacementSet(gpuMemorySlices))
+		free += freeSmallestSlots(instaslice.Spec.Migplacement, occupiedIndexes(instaslice, gpuUUID))
+	}
+	return total, total - free, free
+}
+
+// carvedSlices returns, per GPU, the number of slices carved on it.
+func carvedSlices(instaslice *inferencev1alpha1.Instaslice) map[string]int {
+	carved := make(map[string]int)
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		carved[gpuUUID] = 0
+	}
+	for _, prepared := range instaslice.Status.Prepared {
+		if _, exists := carved[prepared.Parent]; exists {
+			carved[prepared.Parent]++
+		}
+	}
+	return carved
+}
+
+// occupiedRanges returns, per GPU, the ranges of contiguous memory slices taken by slices and pending allocations.
+// A GPU with nothing placed on it has no range.
+func occupiedRanges(instaslice *inferencev1alpha1.Instaslice) map[string][]inferencev1alpha1.SliceRange {
+	ranges := make(map[string][]inferencev1alpha1.SliceRange)
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		ranges[gpuUUID] = append([]inferencev1alpha1.SliceRange{}, occupiedIndexes(instaslice, gpuUUID).regions(true)...)
+	}
+	return ranges
+}
+
+// allocationMigUUIDs returns, per pod UUID, the MIG UUIDs of the slice carved for the pod ordered by ci id.
+func allocationMigUUIDs(instaslice *inferencev1alpha1.Instaslice) map[string][]string {
+	migUUIDs := make(map[string][]string)
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID == "" {
+			continue
+		}
+		migUUIDs[prepared.PodUUID] = append(migUUIDs[prepared.PodUUID], migUUID)
+	}
+	for podUUID := range migUUIDs {
+		sort.Slice(migUUIDs[podUUID], func(i, j int) bool {
+			left, right := instaslice.Status.Prepared[migUUIDs[podUUID][i]], instaslice.Status.Prepared[migUUIDs[podUUID][j]]
+			if left.Ciinfoid != right.Ciinfoid {
+				return left.Ciinfoid < right.Ciinfoid
+			}
+			return migUUIDs[podUUID][i] < migUUIDs[podUUID][j]
+		})
+	}
+	return migUUIDs
+}
+
+// availableSlices returns, per GPU, the free smallest profile slots normal allocations can still use, none on
+// cordoned GPUs or GPUs waiting for a reset.
+func availableSlices(instaslice *inferencev1alpha1.Instaslice) map[string]int {
+	available := make(map[string]int)
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		if gpuCordoned(instaslice, gpuUUID) || gpuResetPending(instaslice, gpuUUID) {
+			available[gpuUUID] = 0
+			continue
+		}
+		free := freeSmallestSlots(instaslice.Spec.Migplacement, occupiedIndexes(instaslice, gpuUUID)) - instaslice.Spec.ReservedSlicesPerGPU
+		if free < 0 {
+			free = 0
+		}
+		available[gpuUUID] = free
+	}
+	return available
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newReserveTestInstaslice returns a GPU where only the last 1g slot is free and one slot is reserved.
+func newReserveTestInstaslice() *inferencev1alpha1.Instaslice {
+	instaslice := newPlacementTestInstaslice()
+	instaslice.Spec.ReservedSlicesPerGPU = 1
+	for i := 1; i < 6; i++ {
+		instaslice.Status.Prepared[fmt.Sprintf("MIG-%d", i+1)] = inferencev1alpha1.PreparedDetails{Profile: "1g.5gb", Parent: "GPU-1", Start: uint32(i), Size: 1}
+	}
+	return instaslice
+}
+
+func TestFindDeviceForASliceKeepsReserveFromNormalAllocations(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-uid-1"}}
+
+	_, err := r.findDeviceForASlice(newReserveTestInstaslice(), "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.Error(t, err)
+}
+
+func TestFindDeviceForASliceLetsBurstUseReserve(t *testing.T) {
+	r := &InstasliceReconciler{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "pod-1",
+		Namespace:   "default",
+		UID:         "pod-uid-1",
+		Annotations: map[string]string{PriorityAnnotation: PriorityBurst},
+	}}
+
+	allocation, err := r.findDeviceForASlice(newReserveTestInstaslice(), "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(6), allocation.Start)
+}
+
+func TestAvailableSlicesExcludesReserve(t *testing.T) {
+	instaslice := newPlacementTestInstaslice()
+	instaslice.Spec.ReservedSlicesPerGPU = 2
+	assert.Equal(t, map[string]int{"GPU-1": 4}, availableSlices(instaslice))
+
+	assert.Equal(t, map[string]int{"GPU-1": 0}, availableSlices(newReserveTestInstaslice()))
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// ConditionGPUResetPending is set on the instaslice while some of its GPUs wait for a reset.
+	ConditionGPUResetPending = "GPUResetPending"
+	// ReasonResetRequired is the reason used when GPUs of the node need a reset before they take new slices.
+	ReasonResetRequired = "ResetRequired"
+)
+
+// gpuResetPending reports whether NVML reported a reset pending for the GPU, new slices are not placed on it.
+func gpuResetPending(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) bool {
+	for _, pending := range instaslice.Status.ResetPendingGPUs {
+		if pending == gpuUUID {
+			return true
+		}
+	}
+	return false
+}
+
+// carvingPending reports whether some allocation of the node waits for its slice to be carved.
+func carvingPending(instaslice *inferencev1alpha1.Instaslice) bool {
+	for key, allocation := range instaslice.Spec.Allocations {
+		if allocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreating && key == allocation.PodUUID {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceResetPending reports whether the GPU needs a reset: a change of MIG mode waits for one to apply, and so
+// do rows of memory pending remapping. GPUs that cannot remap rows are only checked for their MIG mode.
+func deviceResetPending(device nvml.Device) (bool, error) {
+	current, pending, ret := device.GetMigMode()
+	if ret != nvml.SUCCESS && ret != nvml.ERROR_NOT_SUPPORTED {
+		return false, fmt.Errorf("unable to get MIG mode: %v", ret)
+	}
+	if ret == nvml.SUCCESS && current != pending {
+		return true, nil
+	}
+	_, _, remappingPending, _, ret := device.GetRemappedRows()
+	if ret == nvml.ERROR_NOT_SUPPORTED {
+		return false, nil
+	}
+	if ret != nvml.SUCCESS {
+		return false, fmt.Errorf("unable to get remapped rows: %v", ret)
+	}
+	return remappingPending, nil
+}
+
+// resetPendingGPUs returns in order the UUIDs of the GPUs of the node NVML reports a reset pending for. A GPU
+// whose state cannot be read is left out, its slices would fail to be carved rather than be kept from it.
+func (r *InstaSliceDaemonsetReconciler) resetPendingGPUs(ctx context.Context) ([]string, error) {
+	nvmllib := r.handler().nvml
+	count, ret := nvmllib.DeviceGetCount()
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("unable to get device count: %v", ret)
+	}
+	var pending []string
+	for i := 0; i < count; i++ {
+		device, ret := nvmllib.DeviceGetHandleByIndex(i)
+		if ret != nvml.SUCCESS {
+			log.FromContext(ctx).Info("unable to get device, not checking it for a pending reset", "index", i, "error", ret)
+			continue
+		}
+		gpuUUID, ret := device.GetUUID()
+		if ret != nvml.SUCCESS {
+			log.FromContext(ctx).Info("unable to get uuid of device, not checking it for a pending reset", "index", i, "error", ret)
+			continue
+		}
+		if !r.managesGPU(i, gpuUUID) {
+			continue
+		}
+		resetPending, err := deviceResetPending(device)
+		if err != nil {
+			log.FromContext(ctx).Info("unable to check the GPU for a pending reset", "gpu", gpuUUID, "error", err.Error())
+			continue
+		}
+		if resetPending {
+			pending = append(pending, gpuUUID)
+		}
+	}
+	sort.Strings(pending)
+	return pending, nil
+}
+
+// setResetPendingCondition marks the status for the GPUs waiting for a reset, or clears the condition when there
+// are none.
+func setResetPendingCondition(status *inferencev1alpha1.InstasliceStatus, pending []string) {
+	if len(pending) == 0 {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ConditionGPUResetPending,
+			Status:  metav1.ConditionFalse,
+			Reason:  "NoResetPending",
+			Message: "no GPU waits for a reset",
+		})
+		return
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    ConditionGPUResetPending,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonResetRequired,
+		Message: "GPUs take no new slices until they are reset: " + strings.Join(pending, ", "),
+	})
+}
+
+// recordResetPendingGPUs checks the GPUs of the node for a pending reset and records those that wait for one in
+// the status of the instaslice, for the controller to keep new slices off them. It reports whether the
+// instaslice was updated.
+func (r *InstaSliceDaemonsetReconciler) recordResetPendingGPUs(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) (bool, error) {
+	pending, err := r.resetPendingGPUs(ctx)
+	if err != nil {
+		return false, err
+	}
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionGPUResetPending)
+	if strings.Join(pending, ",") == strings.Join(instaslice.Status.ResetPendingGPUs, ",") && (condition != nil || len(pending) == 0) {
+		return false, nil
+	}
+	for _, gpuUUID := range pending {
+		if !gpuResetPending(instaslice, gpuUUID) {
+			log.FromContext(ctx).Info("GPU waits for a reset, placing no new slice on it", "gpu", gpuUUID)
+		}
+	}
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var latest inferencev1alpha1.Instaslice
+		if err := r.Get(ctx, types.NamespacedName{Name: instaslice.Name, Namespace: instaslice.Namespace}, &latest); err != nil {
+			return err
+		}
+		latest.Status.ResetPendingGPUs = pending
+		setResetPendingCondition(&latest.Status, pending)
+		return r.Status().Update(ctx, &latest)
+	})
+	return err == nil, err
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestFindDeviceForASliceSkipsGPUWaitingForReset(t *testing.T) {
+	r := &InstasliceReconciler{}
+	instaslice := newCordonTestInstaslice()
+	instaslice.Spec.CordonedGPUs = nil
+	instaslice.Status.ResetPendingGPUs = []string{"GPU-1"}
+
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "uid-pod-1"}}
+	allocation, err := r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, "GPU-2", allocation.GPUUUID)
+	assert.Equal(t, map[string]int{"GPU-1": 0, "GPU-2": 7}, availableSlices(instaslice))
+	assert.Equal(t, map[string]int{"1g.5gb": 7}, freeSlicesPerProfile(instaslice))
+}
+
+func TestReconcileRecordsGPUsWaitingForReset(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	// MIG was disabled, the change waits for a reset of the GPU
+	device.GetMigModeFunc = func() (int, int, nvml.Return) {
+		return nvml.DEVICE_MIG_ENABLE, nvml.DEVICE_MIG_DISABLE, nvml.SUCCESS
+	}
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.True(t, result.Requeue)
+	instaslice := latestTestInstaslice(t, fakeClient)
+	assert.Equal(t, []string{device.UUID}, instaslice.Status.ResetPendingGPUs)
+	assert.True(t, meta.IsStatusConditionTrue(instaslice.Status.Conditions, ConditionGPUResetPending))
+
+	// the GPU was reset, the full reconcile notices it
+	device.GetMigModeFunc = func() (int, int, nvml.Return) {
+		return nvml.DEVICE_MIG_ENABLE, nvml.DEVICE_MIG_ENABLE, nvml.SUCCESS
+	}
+	device.GetRemappedRowsFunc = func() (int, int, bool, bool, nvml.Return) {
+		return 0, 0, true, false, nvml.SUCCESS
+	}
+	fullyReconcile(t, reconciler)
+	instaslice = latestTestInstaslice(t, fakeClient)
+	assert.Equal(t, []string{device.UUID}, instaslice.Status.ResetPendingGPUs, "rows pending remapping need a reset too")
+
+	device.GetRemappedRowsFunc = func() (int, int, bool, bool, nvml.Return) {
+		return 0, 0, false, false, nvml.SUCCESS
+	}
+	fullyReconcile(t, reconciler)
+	instaslice = latestTestInstaslice(t, fakeClient)
+	assert.Empty(t, instaslice.Status.ResetPendingGPUs)
+	assert.True(t, meta.IsStatusConditionFalse(instaslice.Status.Conditions, ConditionGPUResetPending))
+}
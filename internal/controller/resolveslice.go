@@ -0,0 +1,103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// errSliceGone is returned when no MIG device of the GPU has the MIG UUID of a prepared entry anymore.
+var errSliceGone = errors.New("MIG slice no longer exists")
+
+// errSlicePlacementMismatch is returned when the MIG UUID of a prepared entry is found at another placement.
+var errSlicePlacementMismatch = errors.New("MIG slice found at another placement")
+
+// resolveSliceIDs returns the GI and CI ids the driver currently uses for the slice of a prepared entry.
+// NVML hands out GI and CI ids again after a driver restart, so the stored ids may name another slice;
+// the slice is looked up by its MIG UUID instead and the placement of its GI has to match the entry.
+func resolveSliceIDs(device nvml.Device, migUUID string, prepared inferencev1alpha1.PreparedDetails) (uint32, uint32, error) {
+	maxMigs, ret := device.GetMaxMigDeviceCount()
+	if ret != nvml.SUCCESS {
+		return 0, 0, ret
+	}
+	for i := 0; i < maxMigs; i++ {
+		mig, ret := device.GetMigDeviceHandleByIndex(i)
+		if ret == nvml.ERROR_NOT_FOUND || ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return 0, 0, ret
+		}
+		uuid, ret := mig.GetUUID()
+		if ret != nvml.SUCCESS {
+			return 0, 0, ret
+		}
+		if uuid != migUUID {
+			continue
+		}
+		giID, ret := mig.GetGpuInstanceId()
+		if ret != nvml.SUCCESS {
+			return 0, 0, ret
+		}
+		ciID, ret := mig.GetComputeInstanceId()
+		if ret != nvml.SUCCESS {
+			return 0, 0, ret
+		}
+		gi, ret := device.GetGpuInstanceById(giID)
+		if ret != nvml.SUCCESS {
+			return 0, 0, ret
+		}
+		giInfo, ret := gi.GetInfo()
+		if ret != nvml.SUCCESS {
+			return 0, 0, ret
+		}
+		// entries written before the placement was recorded carry a size of zero
+		if prepared.Size != 0 && (giInfo.Placement.Start != prepared.Start || giInfo.Placement.Size != prepared.Size) {
+			return 0, 0, fmt.Errorf("%w: %s is at %d+%d instead of %d+%d", errSlicePlacementMismatch, migUUID,
+				giInfo.Placement.Start, giInfo.Placement.Size, prepared.Start, prepared.Size)
+		}
+		return uint32(giID), uint32(ciID), nil
+	}
+	return 0, 0, fmt.Errorf("%w: %s", errSliceGone, migUUID)
+}
+
+// sliceIDsForCleanup returns the ids of the slice of a prepared entry to destroy, and false if the slice is already gone.
+// A slice found at another placement is not touched; when the MIG devices cannot be listed the stored ids are used.
+func sliceIDsForCleanup(ctx context.Context, device nvml.Device, migUUID string, prepared inferencev1alpha1.PreparedDetails) (uint32, uint32, bool, error) {
+	giID, ciID, err := resolveSliceIDs(device, migUUID, prepared)
+	switch {
+	case errors.Is(err, errSliceGone):
+		log.FromContext(ctx).Info("MIG slice already gone", "migUUID", migUUID)
+		return 0, 0, false, nil
+	case errors.Is(err, errSlicePlacementMismatch):
+		return 0, 0, false, err
+	case err != nil:
+		log.FromContext(ctx).Error(err, "unable to resolve MIG slice ids, using the stored ones", "migUUID", migUUID)
+		return prepared.Giinfoid, prepared.Ciinfoid, true, nil
+	}
+	if giID != prepared.Giinfoid || ciID != prepared.Ciinfoid {
+		log.FromContext(ctx).Info("MIG slice ids changed since the slice was prepared", "migUUID", migUUID, "giId", giID, "ciId", ciID)
+	}
+	return giID, ciID, true, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// carvedTestSlices carves the slices of the allocations of newFakeGPUTestReconciler and returns the instaslice.
+func carvedTestSlices(t *testing.T, reconciler *InstaSliceDaemonsetReconciler, fakeClient client.Client) inferencev1alpha1.Instaslice {
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	_, err := reconciler.createSlicesInBatch(ctx, "node-1", &instaslice)
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	return instaslice
+}
+
+func preparedOfPod(instaslice inferencev1alpha1.Instaslice, podUUID string) (string, inferencev1alpha1.PreparedDetails) {
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		if prepared.PodUUID == podUUID {
+			return migUUID, prepared
+		}
+	}
+	return "", inferencev1alpha1.PreparedDetails{}
+}
+
+func TestCleanUpResolvesStaleSliceIDsByMigUUID(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	instaslice := carvedTestSlices(t, reconciler, fakeClient)
+	require.Len(t, device.GpuInstances, 2)
+	migUUID0, prepared0 := preparedOfPod(instaslice, "pod-uid-0")
+	migUUID1, prepared1 := preparedOfPod(instaslice, "pod-uid-1")
+	require.NotEqual(t, prepared0.Giinfoid, prepared1.Giinfoid)
+
+	// after a driver restart the ids stored for pod-0 name the slice of pod-1
+	stale := prepared0
+	stale.Giinfoid, stale.Ciinfoid = prepared1.Giinfoid, prepared1.Ciinfoid
+	instaslice.Status.Prepared[migUUID0] = stale
+	require.NoError(t, fakeClient.Status().Update(ctx, &instaslice))
+
+	require.NoError(t, reconciler.cleanUp(ctx, "pod-uid-0"))
+
+	require.Len(t, device.GpuInstances, 1)
+	for gi := range device.GpuInstances {
+		assert.Equal(t, prepared1.Giinfoid, gi.Info.Id)
+		assert.Equal(t, prepared1.Start, gi.Info.Placement.Start)
+	}
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.NotContains(t, instaslice.Status.Prepared, migUUID0)
+	assert.Contains(t, instaslice.Status.Prepared, migUUID1)
+}
+
+func TestCleanUpLeavesOtherSlicesWhenSliceIsGone(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	instaslice := carvedTestSlices(t, reconciler, fakeClient)
+	migUUID0, prepared0 := preparedOfPod(instaslice, "pod-uid-0")
+	_, prepared1 := preparedOfPod(instaslice, "pod-uid-1")
+
+	// the slice of pod-0 went away with the restart and its ids were handed to pod-1
+	delete(instaslice.Status.Prepared, migUUID0)
+	stale := prepared0
+	stale.Giinfoid, stale.Ciinfoid = prepared1.Giinfoid, prepared1.Ciinfoid
+	instaslice.Status.Prepared["MIG-gone"] = stale
+	require.NoError(t, fakeClient.Status().Update(ctx, &instaslice))
+
+	require.NoError(t, reconciler.cleanUp(ctx, "pod-uid-0"))
+
+	// neither slice is touched, the one of pod-0 is still carved as far as the fake is concerned
+	assert.Len(t, device.GpuInstances, 2)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.NotContains(t, instaslice.Status.Prepared, "MIG-gone")
+}
+
+func TestResolveSliceIDsRefusesAnotherPlacement(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	instaslice := carvedTestSlices(t, reconciler, fakeClient)
+	migUUID, prepared := preparedOfPod(instaslice, "pod-uid-0")
+
+	prepared.Start = 4
+	_, _, err := resolveSliceIDs(device, migUUID, prepared)
+	assert.ErrorIs(t, err, errSlicePlacementMismatch)
+
+	_, _, err = resolveSliceIDs(device, "MIG-unknown", prepared)
+	assert.ErrorIs(t, err, errSliceGone)
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// defaultRetainedSliceTTL is how long a retained slice stays unused when the spec does not set a TTL.
+const defaultRetainedSliceTTL = 10 * time.Minute
+
+// retainedSliceTTL returns how long a retained slice may stay unused on the node.
+func retainedSliceTTL(instaslice *inferencev1alpha1.Instaslice) time.Duration {
+	if instaslice.Spec.RetainedSliceTTL == nil {
+		return defaultRetainedSliceTTL
+	}
+	return instaslice.Spec.RetainedSliceTTL.Duration
+}
+
+// deletionGracePeriod returns how long the slice of a deleted pod is retained, zero when it is destroyed right away.
+func deletionGracePeriod(instaslice *inferencev1alpha1.Instaslice) time.Duration {
+	if instaslice.Spec.DeletionGracePeriod == nil {
+		return 0
+	}
+	return instaslice.Spec.DeletionGracePeriod.Duration
+}
+
+// releaseDeletedAllocation moves the allocation of a deleted pod to deleting. Within the deletion grace period of
+// the node, a realized slice with a single compute instance is retained instead, for a pod re-created with the
+// same name to take over.
+func releaseDeletedAllocation(instaslice *inferencev1alpha1.Instaslice, allocation *inferencev1alpha1.AllocationDetails) {
+	if deletionGracePeriod(instaslice) > 0 && allocation.ComputeInstances <= 1 &&
+		(allocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreated || allocation.Allocationstatus == inferencev1alpha1.AllocationStatusUngated) {
+		deletedAt := now()
+		allocation.Allocationstatus = inferencev1alpha1.AllocationStatusRetained
+		allocation.RetainedAt = &deletedAt
+		allocation.DeletedAt = &deletedAt
+		return
+	}
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+}
+
+// retainedSliceClaimed reports whether an allocation other than podUUID is taking over the retained slice.
+func retainedSliceClaimed(instaslice *inferencev1alpha1.Instaslice, retainedPodUUID string, podUUID string) bool {
+	for _, allocation := range instaslice.Spec.Allocations {
+		if allocation.ReusedFrom == retainedPodUUID && allocation.PodUUID != podUUID && allocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreating {
+			return true
+		}
+	}
+	return false
+}
+
+// findRetainedSlice returns the unclaimed retained allocation of the profile that has been idle the longest,
+// only looking at the given GPU unless gpuUUID is empty. GPUs taking no new slices, or no pods of the namespace of
+// the pod, are left out. The slice of a deleted pod within its grace period only goes to the pod re-created with
+// the same name, and to it first.
+func findRetainedSlice(instaslice *inferencev1alpha1.Instaslice, profileName string, pod *v1.Pod, gpuUUID string) (string, inferencev1alpha1.AllocationDetails, bool) {
+	podUUID := string(pod.UID)
+	var found string
+	var oldest inferencev1alpha1.AllocationDetails
+	for retainedPodUUID, allocation := range instaslice.Spec.Allocations {
+		if allocation.Allocationstatus != inferencev1alpha1.AllocationStatusRetained || allocation.Profile != profileName || allocation.RetainedAt == nil {
+			continue
+		}
+		recreated := allocation.PodName == pod.Name && allocation.Namespace == pod.Namespace
+		if allocation.DeletedAt != nil && !recreated {
+			continue
+		}
+		if found != "" && oldest.DeletedAt != nil {
+			continue
+		}
+		if gpuUUID != "" && allocation.GPUUUID != gpuUUID {
+			continue
+		}
+		if !gpuTakesNewSlices(instaslice, allocation.GPUUUID) || !gpuAllowsProfile(instaslice, allocation.GPUUUID, profileName) ||
+			!gpuAdmitsNamespace(instaslice, allocation.GPUUUID, pod.Namespace) {
+			continue
+		}
+		if retainedSliceClaimed(instaslice, retainedPodUUID, podUUID) {
+			continue
+		}
+		if found == "" || allocation.DeletedAt != nil || allocation.RetainedAt.Before(oldest.RetainedAt) {
+			found = retainedPodUUID
+			oldest = allocation
+		}
+	}
+	return found, oldest, found != ""
+}
+
+// retainedSliceExpired reports whether the retained allocation has been idle for longer than the TTL.
+func retainedSliceExpired(instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) bool {
+	if allocation.RetainedAt == nil || retainedSliceClaimed(instaslice, allocation.PodUUID, "") {
+		return false
+	}
+	// slices of the pools stay until their pool no longer wants them
+	if isPoolAllocation(allocation) {
+		return poolSliceExcess(instaslice, allocation)
+	}
+	if allocation.DeletedAt != nil {
+		return now().Sub(allocation.DeletedAt.Time) > deletionGracePeriod(instaslice)
+	}
+	return now().Sub(allocation.RetainedAt.Time) > retainedSliceTTL(instaslice)
+}
+
+// releaseRetainedSlice frees what the completed pod held besides the slice itself, so that only the
+// MIG device stays behind for the next pod. It goes by the allocation rather than the slices the daemonset cached,
+// which a restart loses, and releases the slice once, recording it retained.
+func (r *InstaSliceDaemonsetReconciler) releaseRetainedSlice(ctx context.Context, nodeName string, instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails, migUUID string) error {
+	if _, released := r.slices.retainedSlice(migUUID); released && migUUID != "" {
+		return nil
+	}
+	if err := r.deleteConfigMap(ctx, allocation.PodName, allocation.Namespace, allocation.PodUUID); err != nil {
+		return err
+	}
+	// the pod is done with the device mapping, the slice staying behind no longer needs it
+	if err := r.removeConfigMapFinalizer(ctx, allocation.PodName, allocation.Namespace, allocation.PodUUID); err != nil {
+		return err
+	}
+	if err := r.cleanUpInstaSliceResource(ctx, allocation); err != nil {
+		return err
+	}
+	if migUUID != "" {
+		slice, err := r.retainedSliceOf(instaslice, allocation)
+		if err != nil {
+			return err
+		}
+		r.slices.retain(migUUID, slice)
+	}
+	r.slices.forget(allocation.PodName)
+	return r.updateNodeCapacity(ctx, nodeName)
+}
+
+// retainedSliceOf returns the slice held by the retained allocation, rebuilt from its prepared entry when the
+// daemonset did not cache it, e.g. after a restart. The GPU is looked up in the NVML session the daemonset holds.
+func (r *InstaSliceDaemonsetReconciler) retainedSliceOf(instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) (preparedMig, error) {
+	if cached, exists := r.slices.cached(allocation.PodName); exists {
+		return cached, nil
+	}
+	device, ret := r.handler().nvml.DeviceGetHandleByUUID(allocation.GPUUUID)
+	if errLost := checkNVMLHandle("DeviceGetHandleByUUID", ret); errLost != nil {
+		return preparedMig{}, errLost
+	}
+	if ret != nvml.SUCCESS {
+		return preparedMig{}, fmt.Errorf("unable to get the GPU %s of the retained slice: %v", allocation.GPUUUID, ret)
+	}
+	slice, found := preparedSliceOf(device, instaslice, allocation)
+	if !found {
+		return preparedMig{}, fmt.Errorf("no prepared entry of the retained slice of pod UUID %s on GPU %s", allocation.PodUUID, allocation.GPUUUID)
+	}
+	return slice, nil
+}
+
+// reuseRetainedSlice hands the retained slice the allocation is taking over to its pod without any NVML call.
+// It returns false when the slice no longer exists on the node and has to be carved again.
+func (r *InstaSliceDaemonsetReconciler) reuseRetainedSlice(ctx context.Context, nodeName string, instaslice *inferencev1alpha1.Instaslice, allocation inferencev1alpha1.AllocationDetails) (bool, error) {
+	var migUUID string
+	var prepared inferencev1alpha1.PreparedDetails
+	for uuid, item := range instaslice.Status.Prepared {
+		if item.PodUUID == allocation.ReusedFrom && item.Parent == allocation.GPUUUID {
+			migUUID = uuid
+			prepared = item
+		}
+	}
+	if migUUID == "" {
+		log.FromContext(ctx).Info("retained slice not found, carving a new one for ", "pod", allocation.PodName)
+		return false, nil
+	}
+	log.FromContext(ctx).Info("reusing retained slice for ", "pod", allocation.PodName, "migUUID", migUUID)
+	retained := instaslice.Spec.Allocations[allocation.ReusedFrom]
+	if err := r.releaseRetainedSlice(ctx, nodeName, instaslice, retained, migUUID); err != nil {
+		return true, err
+	}
+	if err := r.createInstaSliceResource(ctx, nodeName, allocation); err != nil {
+		return true, err
+	}
+	retainedSlice, _ := r.slices.retainedSlice(migUUID)
+	r.slices.cache(allocation.PodName, preparedMig{
+		gid:          prepared.Giinfoid,
+		miguuid:      migUUID,
+		cid:          prepared.Ciinfoid,
+		memorySizeMB: retainedSlice.memorySizeMB,
+	})
+	if err := r.createConfigMap(ctx, []string{migUUID}, allocation.Namespace, allocation.PodName, allocation.PodUUID, allocation.Profile, retainedSlice.memorySizeMB); err != nil {
+		return true, err
+	}
+
+	prepared.PodUUID = allocation.PodUUID
+	var updatedAllocation inferencev1alpha1.AllocationDetails
+	_, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+		if latest.Status.Prepared == nil {
+			latest.Status.Prepared = make(map[string]inferencev1alpha1.PreparedDetails)
+		}
+		latest.Status.Prepared[migUUID] = prepared
+		updatedAllocation = latest.Spec.Allocations[allocation.PodUUID]
+		// the pod may have been deleted meanwhile, let the next reconcile handle the new status
+		if updatedAllocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreating {
+			updatedAllocation.Allocationstatus = inferencev1alpha1.AllocationStatusCreated
+		}
+		if latest.Spec.Allocations == nil {
+			latest.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
+		}
+		latest.Spec.Allocations[allocation.PodUUID] = updatedAllocation
+		delete(latest.Spec.Allocations, allocation.ReusedFrom)
+		return nil
+	})
+	if err != nil {
+		return true, err
+	}
+	r.slices.release(migUUID)
+	r.recordAllocationStatus(allocation.PodUUID, updatedAllocation.Allocationstatus)
+	return true, r.updateNodeCapacity(ctx, nodeName)
+}
@@ -0,0 +1,362 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newRetainTestReconciler returns a daemonset reconciler on a node with one fake GPU holding a created 1g.5gb slice
+// for pod-a, along with the GPU and the counter of GPU instances carved after setup.
+func newRetainTestReconciler(t *testing.T) (*InstaSliceDaemonsetReconciler, client.Client, *dgxa100.Device, *int) {
+	t.Setenv(FakeGPUEnv, "1")
+	t.Setenv("NODE_NAME", "node-1")
+	nvmllib, err := newNvmlLib(nil)
+	require.NoError(t, err)
+
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}},
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(node).WithStatusSubresource(&inferencev1alpha1.Instaslice{}, &v1.Node{}).Build()
+	reconciler := &InstaSliceDaemonsetReconciler{
+		Client:      fakeClient,
+		Scheme:      s,
+		nvmlHandler: newDeviceHandler(nvmllib),
+	}
+	ctx := context.Background()
+	_, err = reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+
+	handle, ret := nvmllib.DeviceGetHandleByIndex(0)
+	require.Equal(t, nvml.SUCCESS, ret)
+	device := handle.(*dgxa100.Device)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	instaslice.Spec.RetainSlices = true
+	instaslice.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{
+		"pod-uid-a": newRetainTestAllocation("pod-uid-a", "pod-a", device.UUID, inferencev1alpha1.AllocationStatusCreating),
+	}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.Len(t, device.GpuInstances, 1)
+
+	created := 0
+	createGpuInstance := device.CreateGpuInstanceWithPlacementFunc
+	device.CreateGpuInstanceWithPlacementFunc = func(info *nvml.GpuInstanceProfileInfo, placement *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+		created++
+		return createGpuInstance(info, placement)
+	}
+	return reconciler, fakeClient, device, &created
+}
+
+func newRetainTestAllocation(podUUID string, podName string, gpuUUID string, status inferencev1alpha1.AllocationStatus) inferencev1alpha1.AllocationDetails {
+	return inferencev1alpha1.AllocationDetails{
+		PodUUID:          podUUID,
+		PodName:          podName,
+		Namespace:        "default",
+		GPUUUID:          gpuUUID,
+		Nodename:         "node-1",
+		Profile:          "1g.5gb",
+		Start:            0,
+		Size:             1,
+		Giprofileid:      nvml.GPU_INSTANCE_PROFILE_1_SLICE,
+		CIProfileID:      nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+		CIEngProfileID:   nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED,
+		Allocationstatus: status,
+	}
+}
+
+// retainAllocation moves the allocation of the pod to retained the way the controller does on pod completion.
+func retainAllocation(t *testing.T, fakeClient client.Client, podUUID string, retainedAt metav1.Time) {
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	allocation := instaslice.Spec.Allocations[podUUID]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusRetained
+	allocation.RetainedAt = &retainedAt
+	instaslice.Spec.Allocations[podUUID] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+}
+
+func TestRetainedSliceIsReusedWithoutDestroyOrCreate(t *testing.T) {
+	reconciler, fakeClient, device, created := newRetainTestReconciler(t)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	var gi *dgxa100.GpuInstance
+	for existing := range device.GpuInstances {
+		gi = existing
+	}
+
+	retainAllocation(t, fakeClient, "pod-uid-a", now())
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	// the completed pod no longer holds the slice but it stays on the GPU
+	var configMap v1.ConfigMap
+	err = fakeClient.Get(ctx, types.NamespacedName{Name: "pod-a", Namespace: "default"}, &configMap)
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.Contains(t, device.GpuInstances, gi)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	allocation := newRetainTestAllocation("pod-uid-b", "pod-b", device.UUID, inferencev1alpha1.AllocationStatusCreating)
+	allocation.ReusedFrom = "pod-uid-a"
+	instaslice.Spec.Allocations["pod-uid-b"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-b"].Allocationstatus)
+	assert.NotContains(t, instaslice.Spec.Allocations, "pod-uid-a")
+	require.Len(t, instaslice.Status.Prepared, 1)
+	var migUUID string
+	for uuid, prepared := range instaslice.Status.Prepared {
+		migUUID = uuid
+		assert.Equal(t, "pod-uid-b", prepared.PodUUID)
+	}
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-b", Namespace: "default"}, &configMap))
+	assert.Equal(t, migUUID, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	assert.Equal(t, 0, *created)
+	assert.Len(t, device.GpuInstances, 1)
+	assert.Contains(t, device.GpuInstances, gi)
+}
+
+func TestRetainedSliceIsReleasedAndReusedAfterRestart(t *testing.T) {
+	reconciler, fakeClient, device, created := newRetainTestReconciler(t)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	var node v1.Node
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	require.Contains(t, node.Status.Capacity, v1.ResourceName("org.instaslice/pod-a"))
+	reconciler.ExposeSliceDetails = true
+	var configMap v1.ConfigMap
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-a", Namespace: "default"}, &configMap))
+	controllerutil.AddFinalizer(&configMap, ConfigMapFinalizer)
+	require.NoError(t, fakeClient.Update(ctx, &configMap))
+
+	retainAllocation(t, fakeClient, "pod-uid-a", now())
+	// a restarted daemonset has none of the slices it carved cached
+	reconciler.slices = sliceCache{}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	err = fakeClient.Get(ctx, types.NamespacedName{Name: "pod-a", Namespace: "default"}, &configMap)
+	assert.True(t, apierrors.IsNotFound(err))
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1"}, &node))
+	assert.NotContains(t, node.Status.Capacity, v1.ResourceName("org.instaslice/pod-a"))
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	allocation := newRetainTestAllocation("pod-uid-b", "pod-b", device.UUID, inferencev1alpha1.AllocationStatusCreating)
+	allocation.ReusedFrom = "pod-uid-a"
+	instaslice.Spec.Allocations["pod-uid-b"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-b", Namespace: "default"}, &configMap))
+	giProfileInfo, ret := device.GetGpuInstanceProfileInfo(nvml.GPU_INSTANCE_PROFILE_1_SLICE)
+	require.Equal(t, nvml.SUCCESS, ret)
+	assert.Equal(t, strconv.FormatUint(giProfileInfo.MemorySizeMB, 10), configMap.Data["INSTASLICE_MEMORY_MB"])
+	assert.Equal(t, 0, *created)
+}
+
+func TestRetainedSliceOfMissingGPUIsAnError(t *testing.T) {
+	reconciler, fakeClient, _, _ := newRetainTestReconciler(t)
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	reconciler.slices = sliceCache{}
+	allocation := instaslice.Spec.Allocations["pod-uid-a"]
+
+	_, err := reconciler.retainedSliceOf(&instaslice, allocation)
+	assert.NoError(t, err)
+
+	allocation.GPUUUID = "GPU-missing"
+	_, err = reconciler.retainedSliceOf(&instaslice, allocation)
+	assert.Error(t, err)
+}
+
+func TestRetainedSliceIsDeletedAfterTTL(t *testing.T) {
+	reconciler, fakeClient, device, _ := newRetainTestReconciler(t)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	retainAllocation(t, fakeClient, "pod-uid-a", metav1.NewTime(now().Add(-2*defaultRetainedSliceTTL)))
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, instaslice.Spec.Allocations["pod-uid-a"].Allocationstatus)
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Empty(t, instaslice.Status.Prepared)
+	assert.Empty(t, device.GpuInstances)
+}
+
+func TestRetainedSliceIsKeptWithinTTL(t *testing.T) {
+	reconciler, fakeClient, device, _ := newRetainTestReconciler(t)
+	ctx := context.Background()
+
+	retainAllocation(t, fakeClient, "pod-uid-a", metav1.NewTime(now().Add(-time.Minute)))
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusRetained, instaslice.Spec.Allocations["pod-uid-a"].Allocationstatus)
+	assert.Len(t, device.GpuInstances, 1)
+}
+
+func TestFindDeviceForASlicePrefersRetainedSlice(t *testing.T) {
+	r := &InstasliceReconciler{}
+	instaslice := newPlacementTestInstaslice()
+	retainedAt := metav1.Now()
+	retained := newRetainTestAllocation("pod-uid-a", "pod-a", "GPU-1", inferencev1alpha1.AllocationStatusRetained)
+	retained.Start = 3
+	retained.RetainedAt = &retainedAt
+	instaslice.Spec.Allocations = map[string]inferencev1alpha1.AllocationDetails{"pod-uid-a": retained}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-b", Namespace: "default", UID: "pod-uid-b"}}
+
+	allocation, err := r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, pod)
+	assert.NoError(t, err)
+	assert.Equal(t, "pod-uid-a", allocation.ReusedFrom)
+	assert.Equal(t, uint32(3), allocation.Start)
+
+	// a slice claimed by another pod is not handed out twice
+	instaslice.Spec.Allocations["pod-uid-b"] = *allocation
+	other := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-c", Namespace: "default", UID: "pod-uid-c"}}
+	allocation, err = r.findDeviceForASlice(instaslice, "1g.5gb", &FirstFitPolicy{}, other)
+	assert.NoError(t, err)
+	assert.Empty(t, allocation.ReusedFrom)
+}
+
+// setDeletionGracePeriod sets the deletion grace period of the node, retaining slices of completed pods is left off.
+func setDeletionGracePeriod(t *testing.T, fakeClient client.Client, gracePeriod time.Duration) {
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	instaslice.Spec.RetainSlices = false
+	instaslice.Spec.DeletionGracePeriod = &metav1.Duration{Duration: gracePeriod}
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+}
+
+func TestSliceOfPodRecreatedWithinGracePeriodIsReused(t *testing.T) {
+	reconciler, fakeClient, device, created := newRetainTestReconciler(t)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	setDeletionGracePeriod(t, fakeClient, 5*time.Minute)
+	var gi *dgxa100.GpuInstance
+	for existing := range device.GpuInstances {
+		gi = existing
+	}
+	// the gated pod holding the slice is deleted
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-a", Namespace: "default", UID: "pod-uid-a", Finalizers: []string{"org.instaslice/accelarator"}},
+		Spec:       v1.PodSpec{SchedulingGates: []v1.PodSchedulingGate{{Name: "org.instaslice/accelarator"}}},
+		Status: v1.PodStatus{
+			Phase:      v1.PodPending,
+			Conditions: []v1.PodCondition{{Type: v1.PodScheduled, Message: "Scheduling is blocked due to non-empty scheduling gates"}},
+		},
+	}
+	require.NoError(t, fakeClient.Create(ctx, pod))
+	require.NoError(t, fakeClient.Delete(ctx, pod))
+	controller := &InstasliceReconciler{Client: fakeClient, Scheme: fakeClient.Scheme()}
+	_, err := controller.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "pod-a", Namespace: "default"}})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	deleted := instaslice.Spec.Allocations["pod-uid-a"]
+	assert.Equal(t, inferencev1alpha1.AllocationStatusRetained, deleted.Allocationstatus)
+	assert.NotNil(t, deleted.DeletedAt)
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Contains(t, device.GpuInstances, gi)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+
+	// another pod of the profile does not get it, the pod re-created with the same name does
+	other := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-b", Namespace: "default", UID: "pod-uid-b"}}
+	allocation, err := controller.findDeviceForASlice(instaslice.DeepCopy(), "1g.5gb", &FirstFitPolicy{}, other)
+	require.NoError(t, err)
+	assert.Empty(t, allocation.ReusedFrom)
+	recreated := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-a", Namespace: "default", UID: "pod-uid-a2"}}
+	allocation, err = controller.findDeviceForASlice(&instaslice, "1g.5gb", &FirstFitPolicy{}, recreated)
+	require.NoError(t, err)
+	assert.Equal(t, "pod-uid-a", allocation.ReusedFrom)
+	instaslice.Spec.Allocations["pod-uid-a2"] = *allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, instaslice.Spec.Allocations["pod-uid-a2"].Allocationstatus)
+	assert.NotContains(t, instaslice.Spec.Allocations, "pod-uid-a")
+	assert.Equal(t, 0, *created)
+	assert.Len(t, device.GpuInstances, 1)
+	assert.Contains(t, device.GpuInstances, gi)
+}
+
+func TestSliceOfDeletedPodIsDestroyedAfterGracePeriod(t *testing.T) {
+	reconciler, fakeClient, device, _ := newRetainTestReconciler(t)
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	setDeletionGracePeriod(t, fakeClient, time.Minute)
+	deletedAt := metav1.NewTime(now().Add(-2 * time.Minute))
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	allocation := instaslice.Spec.Allocations["pod-uid-a"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusRetained
+	allocation.RetainedAt = &deletedAt
+	allocation.DeletedAt = &deletedAt
+	instaslice.Spec.Allocations["pod-uid-a"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, instaslice.Spec.Allocations["pod-uid-a"].Allocationstatus)
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Empty(t, instaslice.Status.Prepared)
+	assert.Empty(t, device.GpuInstances)
+}
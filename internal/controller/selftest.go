@@ -0,0 +1,209 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// SelfTestOptions configures a node self test.
+type SelfTestOptions struct {
+	// DeviceIndex is the NVML index of the GPU to test.
+	DeviceIndex int
+	// Profile is the MIG profile to carve, the smallest profile supported by the GPU is used when empty.
+	Profile string
+}
+
+// SelfTestResult reports what the self test carved and how long each step took.
+type SelfTestResult struct {
+	GPUUUID         string
+	Profile         string
+	Placement       nvml.GpuInstancePlacement
+	CreateDuration  time.Duration
+	VerifyDuration  time.Duration
+	DestroyDuration time.Duration
+}
+
+// RunSelfTest validates that a node can realize MIG slices: it carves a slice on one GPU,
+// verifies NVML reports the GPU and compute instances, then destroys the slice again.
+// It only talks to NVML and never touches Kubernetes.
+func RunSelfTest(nvmllib nvml.Interface, opts SelfTestOptions) (*SelfTestResult, error) {
+	if ret := nvmllib.Init(); ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("unable to initialize NVML: %v", ret)
+	}
+	defer nvmllib.Shutdown()
+
+	device, ret := nvmllib.DeviceGetHandleByIndex(opts.DeviceIndex)
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("unable to get GPU at index %d: %v", opts.DeviceIndex, ret)
+	}
+	uuid, ret := device.GetUUID()
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("unable to get uuid of GPU at index %d: %v", opts.DeviceIndex, ret)
+	}
+	currentMigMode, _, ret := device.GetMigMode()
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("unable to get MIG mode of GPU %s: %v", uuid, ret)
+	}
+	if currentMigMode != nvml.DEVICE_MIG_ENABLE {
+		return nil, fmt.Errorf("MIG mode is not enabled on GPU %s", uuid)
+	}
+
+	profile, giProfileInfo, err := selfTestProfile(device, opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	result := &SelfTestResult{GPUUUID: uuid, Profile: profile.String()}
+
+	placements, ret := possiblePlacements(device, giProfileInfo)
+	if ret != nvml.SUCCESS {
+		return result, fmt.Errorf("unable to get placements for profile %s: %v", result.Profile, ret)
+	}
+
+	// slices already carved on the GPU may occupy some placements, use the first one that is free
+	createStart := time.Now()
+	var gi nvml.GpuInstance
+	for _, placement := range placements {
+		gi, ret = createGpuInstance(device, profile.GIProfileID, placement)
+		if ret == nvml.SUCCESS {
+			result.Placement = placement
+			break
+		}
+	}
+	if ret != nvml.SUCCESS {
+		return result, fmt.Errorf("unable to create GPU instance for profile %s: %v", result.Profile, ret)
+	}
+	ci, ret := createComputeInstance(gi, profile.CIProfileID, profile.CIEngProfileID)
+	if ret != nvml.SUCCESS {
+		gi.Destroy()
+		return result, fmt.Errorf("unable to create compute instance for profile %s: %v", result.Profile, ret)
+	}
+	result.CreateDuration = time.Since(createStart)
+
+	giInfo, ret := gi.GetInfo()
+	if ret != nvml.SUCCESS {
+		ci.Destroy()
+		gi.Destroy()
+		return result, fmt.Errorf("unable to get GPU instance info: %v", ret)
+	}
+	ciInfo, ret := ci.GetInfo()
+	if ret != nvml.SUCCESS {
+		ci.Destroy()
+		gi.Destroy()
+		return result, fmt.Errorf("unable to get compute instance info: %v", ret)
+	}
+
+	verifyStart := time.Now()
+	found, err := sliceExists(device, giProfileInfo, giInfo.Id, profile, ciInfo.Id)
+	result.VerifyDuration = time.Since(verifyStart)
+	if err != nil || !found {
+		ci.Destroy()
+		gi.Destroy()
+		if err != nil {
+			return result, err
+		}
+		return result, fmt.Errorf("created slice gi %d ci %d is not reported by NVML", giInfo.Id, ciInfo.Id)
+	}
+
+	destroyStart := time.Now()
+	if ret := destroySlice(device, int(giInfo.Id), int(ciInfo.Id)); ret != nvml.SUCCESS {
+		return result, fmt.Errorf("unable to destroy slice gi %d ci %d: %v", giInfo.Id, ciInfo.Id, ret)
+	}
+	result.DestroyDuration = time.Since(destroyStart)
+
+	found, err = sliceExists(device, giProfileInfo, giInfo.Id, profile, ciInfo.Id)
+	if err != nil {
+		return result, err
+	}
+	if found {
+		return result, fmt.Errorf("destroyed slice gi %d is still reported by NVML", giInfo.Id)
+	}
+	return result, nil
+}
+
+// selfTestProfile finds the requested profile on the device, or the smallest supported one when name is empty.
+func selfTestProfile(device nvml.Device, name string) (*MigProfile, nvml.GpuInstanceProfileInfo, error) {
+	memoryTotal, _, err := profileMemoryTotal(device)
+	if err != nil {
+		return nil, nvml.GpuInstanceProfileInfo{}, err
+	}
+	var selected *MigProfile
+	var selectedInfo nvml.GpuInstanceProfileInfo
+	for i := 0; i < nvml.GPU_INSTANCE_PROFILE_COUNT; i++ {
+		giProfileInfo, ret := device.GetGpuInstanceProfileInfo(i)
+		if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return nil, nvml.GpuInstanceProfileInfo{}, fmt.Errorf("unable to get GPU instance profile %d: %v", i, ret)
+		}
+		profile := NewMigProfile(i, i, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED, giProfileInfo.SliceCount, giProfileInfo.SliceCount, giProfileInfo.MemorySizeMB, memoryTotal)
+		if name != "" {
+			if profile.String() == name {
+				return profile, giProfileInfo, nil
+			}
+			continue
+		}
+		// prefer fewer slices, then less memory, then profiles without extra attributes
+		if selected == nil || giProfileInfo.SliceCount < selectedInfo.SliceCount ||
+			(giProfileInfo.SliceCount == selectedInfo.SliceCount && giProfileInfo.MemorySizeMB < selectedInfo.MemorySizeMB) ||
+			(giProfileInfo.SliceCount == selectedInfo.SliceCount && giProfileInfo.MemorySizeMB == selectedInfo.MemorySizeMB &&
+				len(profile.Attributes()) < len(selected.Attributes())) {
+			selected = profile
+			selectedInfo = giProfileInfo
+		}
+	}
+	if name != "" {
+		return nil, nvml.GpuInstanceProfileInfo{}, fmt.Errorf("profile %s is not supported by the GPU", name)
+	}
+	if selected == nil {
+		return nil, nvml.GpuInstanceProfileInfo{}, fmt.Errorf("GPU does not support any MIG profile")
+	}
+	return selected, selectedInfo, nil
+}
+
+// sliceExists reports whether NVML lists the GPU instance and its compute instance.
+func sliceExists(device nvml.Device, giProfileInfo nvml.GpuInstanceProfileInfo, giID uint32, profile *MigProfile, ciID uint32) (bool, error) {
+	gis, ret := device.GetGpuInstances(&giProfileInfo)
+	if ret != nvml.SUCCESS {
+		return false, fmt.Errorf("unable to list GPU instances: %v", ret)
+	}
+	for _, gi := range gis {
+		giInfo, ret := gi.GetInfo()
+		if ret != nvml.SUCCESS || giInfo.Id != giID {
+			continue
+		}
+		ciProfileInfo, ret := gi.GetComputeInstanceProfileInfo(profile.CIProfileID, profile.CIEngProfileID)
+		if ret != nvml.SUCCESS {
+			return false, fmt.Errorf("unable to get compute instance profile: %v", ret)
+		}
+		cis, ret := gi.GetComputeInstances(&ciProfileInfo)
+		if ret != nvml.SUCCESS {
+			return false, fmt.Errorf("unable to list compute instances: %v", ret)
+		}
+		for _, ci := range cis {
+			ciInfo, ret := ci.GetInfo()
+			if ret == nvml.SUCCESS && ciInfo.Id == ciID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
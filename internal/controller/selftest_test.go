@@ -0,0 +1,89 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+)
+
+// newSelfTestServer returns a fake dgxa100 whose first GPU has MIG enabled and supports lookups by instance id.
+func newSelfTestServer() (*dgxa100.Server, *dgxa100.Device) {
+	server := dgxa100.New()
+	device := server.Devices[0].(*dgxa100.Device)
+	device.MigMode = nvml.DEVICE_MIG_ENABLE
+	device.GetEccModeFunc = func() (nvml.EnableState, nvml.EnableState, nvml.Return) {
+		return nvml.FEATURE_DISABLED, nvml.FEATURE_DISABLED, nvml.SUCCESS
+	}
+	device.GetGpuInstanceByIdFunc = func(id int) (nvml.GpuInstance, nvml.Return) {
+		for gi := range device.GpuInstances {
+			if int(gi.Info.Id) == id {
+				gi.GetComputeInstanceByIdFunc = func(ciID int) (nvml.ComputeInstance, nvml.Return) {
+					for ci := range gi.ComputeInstances {
+						if int(ci.Info.Id) == ciID {
+							return ci, nvml.SUCCESS
+						}
+					}
+					return nil, nvml.ERROR_NOT_FOUND
+				}
+				return gi, nvml.SUCCESS
+			}
+		}
+		return nil, nvml.ERROR_NOT_FOUND
+	}
+	return server, device
+}
+
+func TestRunSelfTestCreatesVerifiesAndDestroysSlice(t *testing.T) {
+	server, device := newSelfTestServer()
+
+	result, err := RunSelfTest(server, SelfTestOptions{DeviceIndex: 0})
+
+	assert.NoError(t, err)
+	assert.Equal(t, device.UUID, result.GPUUUID)
+	assert.Equal(t, "1g.5gb", result.Profile)
+	assert.Empty(t, device.GpuInstances)
+}
+
+func TestRunSelfTestUsesRequestedProfile(t *testing.T) {
+	server, device := newSelfTestServer()
+
+	result, err := RunSelfTest(server, SelfTestOptions{DeviceIndex: 0, Profile: "3g.20gb"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "3g.20gb", result.Profile)
+	assert.Empty(t, device.GpuInstances)
+}
+
+func TestRunSelfTestRejectsUnknownProfile(t *testing.T) {
+	server, _ := newSelfTestServer()
+
+	_, err := RunSelfTest(server, SelfTestOptions{DeviceIndex: 0, Profile: "9g.99gb"})
+
+	assert.Error(t, err)
+}
+
+func TestRunSelfTestRequiresMigMode(t *testing.T) {
+	server := dgxa100.New()
+
+	_, err := RunSelfTest(server, SelfTestOptions{DeviceIndex: 0})
+
+	assert.Error(t, err)
+}
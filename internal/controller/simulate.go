@@ -0,0 +1,80 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// SimulatedPlacement is where the slice of a requested profile would be carved. Placed is false, and the rest
+// unset, when the slice would not fit.
+type SimulatedPlacement struct {
+	// Profile is the profile as requested, any slice or an amount of GPU memory included.
+	Profile string
+	// PlacedProfile is the profile of the slice that would be carved, the one chosen for any slice or memory.
+	PlacedProfile string
+	GPUUUID       string
+	Start         uint32
+	Size          uint32
+	Placed        bool
+}
+
+// PlacementSimulation is the outcome of SimulatePlacements.
+type PlacementSimulation struct {
+	// Fits is true when every requested slice would be placed.
+	Fits bool
+	// Placements holds the placement of every requested profile, in the order of the requests.
+	Placements []SimulatedPlacement
+}
+
+// SimulatePlacements tells whether slices of the requested profiles, requested one after the other by pods of
+// the namespace, would all fit on the node of the instaslice and where the controller would place them. Every
+// slice is placed like the controller places the one of a pod, on a copy of the instaslice holding the slices
+// placed before it, so the instaslice is left untouched. Requests that do not fit are reported and the
+// following ones still simulated, for capacity planners and schedulers doing what-if analysis.
+func (r *InstasliceReconciler) SimulatePlacements(instaslice *inferencev1alpha1.Instaslice, namespace string, profiles []string) PlacementSimulation {
+	simulated := instaslice.DeepCopy()
+	if simulated.Spec.Allocations == nil {
+		simulated.Spec.Allocations = make(map[string]inferencev1alpha1.AllocationDetails)
+	}
+	simulation := PlacementSimulation{Fits: true, Placements: make([]SimulatedPlacement, 0, len(profiles))}
+	for i, profile := range profiles {
+		name := fmt.Sprintf("simulated-%d", i)
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID(name)}}
+		placement := SimulatedPlacement{Profile: profile}
+		allocation, err := r.findDeviceForASlice(simulated, profile, &FirstFitPolicy{}, pod)
+		if err != nil {
+			simulation.Fits = false
+			simulation.Placements = append(simulation.Placements, placement)
+			continue
+		}
+		simulated.Spec.Allocations[allocation.PodUUID] = *allocation
+		placement.PlacedProfile = allocation.Profile
+		placement.GPUUUID = allocation.GPUUUID
+		placement.Start = allocation.Start
+		placement.Size = allocation.Size
+		placement.Placed = true
+		simulation.Placements = append(simulation.Placements, placement)
+	}
+	return simulation
+}
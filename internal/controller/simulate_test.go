@@ -0,0 +1,55 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestSimulatePlacementsOnPartiallyFullNode(t *testing.T) {
+	r := &InstasliceReconciler{}
+	instaslice := newAllowedProfilesTestInstaslice()
+	instaslice.Spec.AllowedProfiles = nil
+	// the first half of GPU-1 is taken by a 3g.20gb slice
+	instaslice.Status.Prepared = map[string]inferencev1alpha1.PreparedDetails{
+		"MIG-1": {Profile: "3g.20gb", Parent: "GPU-1", PodUUID: "pod-uid-1", Start: 0, Size: 4},
+	}
+	before := instaslice.DeepCopy()
+
+	simulation := r.SimulatePlacements(instaslice, "default", []string{"3g.20gb", "7g.40gb"})
+	assert.True(t, simulation.Fits)
+	require.Len(t, simulation.Placements, 2)
+	assert.Equal(t, SimulatedPlacement{Profile: "3g.20gb", PlacedProfile: "3g.20gb", GPUUUID: "GPU-1", Start: 4, Size: 4, Placed: true}, simulation.Placements[0])
+	assert.Equal(t, SimulatedPlacement{Profile: "7g.40gb", PlacedProfile: "7g.40gb", GPUUUID: "GPU-2", Start: 0, Size: 8, Placed: true}, simulation.Placements[1])
+
+	// the second 3g.20gb goes to GPU-2, leaving no room for the 7g.40gb
+	simulation = r.SimulatePlacements(instaslice, "default", []string{"3g.20gb", "3g.20gb", "7g.40gb", "3g.20gb"})
+	assert.False(t, simulation.Fits)
+	require.Len(t, simulation.Placements, 4)
+	assert.Equal(t, "GPU-1", simulation.Placements[0].GPUUUID)
+	assert.Equal(t, "GPU-2", simulation.Placements[1].GPUUUID)
+	assert.Equal(t, SimulatedPlacement{Profile: "7g.40gb"}, simulation.Placements[2])
+	assert.Equal(t, SimulatedPlacement{Profile: "3g.20gb", PlacedProfile: "3g.20gb", GPUUUID: "GPU-2", Start: 4, Size: 4, Placed: true},
+		simulation.Placements[3], "the requests after one that does not fit are still simulated")
+
+	assert.Equal(t, before, instaslice, "the simulation leaves the instaslice untouched")
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// createGpuInstance creates a GPU instance of the given profile at the requested placement.
+func createGpuInstance(device nvml.Device, giProfileID int, placement nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+	giProfileInfo, ret := device.GetGpuInstanceProfileInfo(giProfileID)
+	if ret != nvml.SUCCESS {
+		return nil, ret
+	}
+	return device.CreateGpuInstanceWithPlacement(&giProfileInfo, &placement)
+}
+
+// createComputeInstance creates a compute instance of the given profile inside a GPU instance.
+func createComputeInstance(gi nvml.GpuInstance, ciProfileID int, ciEngProfileID int) (nvml.ComputeInstance, nvml.Return) {
+	ciProfileInfo, ret := gi.GetComputeInstanceProfileInfo(ciProfileID, ciEngProfileID)
+	if ret != nvml.SUCCESS {
+		return nil, ret
+	}
+	return gi.CreateComputeInstance(&ciProfileInfo)
+}
+
+// destroySlice destroys the compute instances and then the GPU instance backing a slice.
+// A compute instance destroyed by an earlier attempt is skipped so that its GPU instance still goes, and a GPU
+// instance already gone counts as destroyed, tearing the same slice down twice succeeds.
+func destroySlice(device nvml.Device, giID int, ciIDs ...int) nvml.Return {
+	gi, ret := device.GetGpuInstanceById(giID)
+	if ret == nvml.ERROR_NOT_FOUND {
+		return nvml.SUCCESS
+	}
+	if ret != nvml.SUCCESS {
+		return ret
+	}
+	for _, ciID := range ciIDs {
+		ci, ret := gi.GetComputeInstanceById(ciID)
+		if ret == nvml.SUCCESS {
+			if ret := ci.Destroy(); ret != nvml.SUCCESS && ret != nvml.ERROR_NOT_FOUND {
+				return ret
+			}
+		} else if ret != nvml.ERROR_NOT_FOUND {
+			return ret
+		}
+	}
+	if ret := gi.Destroy(); ret != nvml.SUCCESS && ret != nvml.ERROR_NOT_FOUND {
+		return ret
+	}
+	return nvml.SUCCESS
+}
+
+// gpuInstanceRef identifies a GPU instance by the GPU it is on and its id.
+type gpuInstanceRef struct {
+	parent string
+	gi     uint32
+}
+
+// sliceTeardown is a GPU instance to destroy along with its compute instances.
+type sliceTeardown struct {
+	gpuInstanceRef
+	cis []int
+}
+
+// orderSliceTeardowns returns the GPU instances to destroy sorted by GPU and id, each with its compute instances
+// sorted by id. Destroying them in this order, all the compute instances of a GPU instance before it, tears the
+// slices of a pod down the same way whatever the order of its prepared entries.
+func orderSliceTeardowns(computeInstances map[gpuInstanceRef][]int) []sliceTeardown {
+	teardowns := make([]sliceTeardown, 0, len(computeInstances))
+	for ref, ciIDs := range computeInstances {
+		ciIDs = append([]int(nil), ciIDs...)
+		sort.Ints(ciIDs)
+		teardowns = append(teardowns, sliceTeardown{gpuInstanceRef: ref, cis: ciIDs})
+	}
+	sort.Slice(teardowns, func(i, j int) bool {
+		if teardowns[i].parent != teardowns[j].parent {
+			return teardowns[i].parent < teardowns[j].parent
+		}
+		return teardowns[i].gi < teardowns[j].gi
+	})
+	return teardowns
+}
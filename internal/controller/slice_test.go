@@ -0,0 +1,126 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// recordDestroys makes the slices of the device log their destruction, GPU instances refusing to go while they
+// still hold compute instances like the driver does.
+func recordDestroys(device *dgxa100.Device) *[]string {
+	var destroyed []string
+	for gi := range device.GpuInstances {
+		gi := gi
+		destroyGI := gi.DestroyFunc
+		gi.DestroyFunc = func() nvml.Return {
+			if len(gi.ComputeInstances) > 0 {
+				return nvml.ERROR_IN_USE
+			}
+			destroyed = append(destroyed, fmt.Sprintf("gi-%d", gi.Info.Id))
+			return destroyGI()
+		}
+		for ci := range gi.ComputeInstances {
+			ci := ci
+			destroyCI := ci.DestroyFunc
+			ci.DestroyFunc = func() nvml.Return {
+				destroyed = append(destroyed, fmt.Sprintf("ci-%d-%d", gi.Info.Id, ci.Info.Id))
+				return destroyCI()
+			}
+		}
+	}
+	return &destroyed
+}
+
+func TestSlicesOfAPodAreTornDownInOrder(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	require.Len(t, instaslice.Status.Prepared, 2)
+	require.Len(t, device.GpuInstances, 2)
+	// the pod owns both slices, each a GPU instance with a compute instance
+	giIDs := make([]uint32, 0, 2)
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		prepared.PodUUID = "pod-uid-0"
+		instaslice.Status.Prepared[migUUID] = prepared
+		giIDs = append(giIDs, prepared.Giinfoid)
+	}
+	require.NotEqual(t, giIDs[0], giIDs[1])
+	first, second := min(giIDs[0], giIDs[1]), max(giIDs[0], giIDs[1])
+	destroyed := recordDestroys(device)
+
+	_, err = reconciler.cleanUpCiAndGi(ctx, "pod-uid-0", instaslice)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		fmt.Sprintf("ci-%d-0", first), fmt.Sprintf("gi-%d", first),
+		fmt.Sprintf("ci-%d-0", second), fmt.Sprintf("gi-%d", second),
+	}, *destroyed)
+	assert.Empty(t, device.GpuInstances)
+}
+
+func TestOrderSliceTeardowns(t *testing.T) {
+	teardowns := orderSliceTeardowns(map[gpuInstanceRef][]int{
+		{parent: "GPU-2", gi: 1}: {0},
+		{parent: "GPU-1", gi: 7}: {2, 0, 1},
+		{parent: "GPU-1", gi: 3}: {0},
+	})
+	assert.Equal(t, []sliceTeardown{
+		{gpuInstanceRef: gpuInstanceRef{parent: "GPU-1", gi: 3}, cis: []int{0}},
+		{gpuInstanceRef: gpuInstanceRef{parent: "GPU-1", gi: 7}, cis: []int{0, 1, 2}},
+		{gpuInstanceRef: gpuInstanceRef{parent: "GPU-2", gi: 1}, cis: []int{0}},
+	}, teardowns)
+}
+
+func TestDestroyingASliceTwiceSucceeds(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	require.Len(t, instaslice.Status.Prepared, 1)
+	var prepared inferencev1alpha1.PreparedDetails
+	for _, details := range instaslice.Status.Prepared {
+		prepared = details
+	}
+
+	assert.Equal(t, nvml.SUCCESS, destroySlice(device, int(prepared.Giinfoid), int(prepared.Ciinfoid)))
+	assert.Empty(t, device.GpuInstances)
+	// the slice is already gone, e.g. destroyed by an earlier attempt that failed to record it
+	assert.Equal(t, nvml.SUCCESS, destroySlice(device, int(prepared.Giinfoid), int(prepared.Ciinfoid)))
+
+	// the cleanup of the pod completes with its slice gone, and again once there is nothing left to clean up
+	require.NoError(t, reconciler.cleanUp(ctx, "pod-uid-0"))
+	require.NoError(t, reconciler.cleanUp(ctx, "pod-uid-0"))
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Empty(t, instaslice.Status.Prepared)
+	assert.Empty(t, instaslice.Spec.Allocations)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import "sync"
+
+// sliceCache holds, keyed by pod name, the slices carved by the daemonset until the allocation they were carved for
+// is recorded created, and, keyed by MIG UUID, the slices of completed pods retained for the next pod. Reconciles
+// and the GPUs of a batch carved at once use it concurrently, one mutex guards both maps.
+type sliceCache struct {
+	mu       sync.Mutex
+	prepared map[string]preparedMig
+	retained map[string]preparedMig
+}
+
+// cached returns the slice cached for the pod.
+func (c *sliceCache) cached(podName string) (preparedMig, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slice, exists := c.prepared[podName]
+	return slice, exists
+}
+
+// cache caches the slice carved for the pod.
+func (c *sliceCache) cache(podName string, slice preparedMig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prepared == nil {
+		c.prepared = make(map[string]preparedMig)
+	}
+	c.prepared[podName] = slice
+}
+
+// forget drops the slice cached for the pod.
+func (c *sliceCache) forget(podName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.prepared, podName)
+}
+
+// retainedSlice returns the retained slice of the MIG device.
+func (c *sliceCache) retainedSlice(migUUID string) (preparedMig, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slice, exists := c.retained[migUUID]
+	return slice, exists
+}
+
+// retain records the slice of the MIG device as retained.
+func (c *sliceCache) retain(migUUID string, slice preparedMig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.retained == nil {
+		c.retained = make(map[string]preparedMig)
+	}
+	c.retained[migUUID] = slice
+}
+
+// release drops the retained slice of the MIG device.
+func (c *sliceCache) release(migUUID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.retained, migUUID)
+}
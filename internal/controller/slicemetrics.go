@@ -0,0 +1,97 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// DefaultSliceMetricsInterval is how often the usage of the slices of the node is sampled by default.
+const DefaultSliceMetricsInterval = 30 * time.Second
+
+// runSliceMetrics periodically samples the usage of the slices of the node until the context is cancelled.
+func (r *InstaSliceDaemonsetReconciler) runSliceMetrics(ctx context.Context, nodeName string) {
+	ticker := time.NewTicker(r.SliceMetricsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.nvmlNotReady.Load() {
+				continue
+			}
+			if err := r.observeSliceUsage(ctx, r.handler().nvml, nodeName); err != nil {
+				log.FromContext(ctx).Error(err, "unable to sample the usage of the slices")
+			}
+		}
+	}
+}
+
+// observeSliceUsage publishes the utilization, memory usage and, where the driver supports it, the power draw
+// of every prepared MIG device of the node, labeled by the pod it is prepared for. Gauges of slices gone since
+// the last sample are dropped.
+func (r *InstaSliceDaemonsetReconciler) observeSliceUsage(ctx context.Context, nvmllib nvml.Interface, nodeName string) error {
+	var instaslice inferencev1alpha1.Instaslice
+	typeNamespacedName := types.NamespacedName{
+		Name:      nodeName,
+		Namespace: r.instasliceNamespace(),
+	}
+	if err := r.Get(ctx, typeNamespacedName, &instaslice); err != nil {
+		return err
+	}
+	for _, gauge := range []interface{ Reset() }{sliceGPUUtilization, sliceMemoryUsed, sliceMemoryTotal, slicePowerUsage} {
+		gauge.Reset()
+	}
+	for migUUID, prepared := range instaslice.Status.Prepared {
+		// slices of pods gone or not yet known are not attributed to anyone
+		allocation, exists := instaslice.Spec.Allocations[prepared.PodUUID]
+		if !exists {
+			continue
+		}
+		device, ret := nvmllib.DeviceGetHandleByUUID(migUUID)
+		if ret != nvml.SUCCESS {
+			log.FromContext(ctx).Info("unable to get MIG device, skipping its usage", "migUUID", migUUID, "error", ret)
+			continue
+		}
+		labels := []string{migUUID, allocation.PodName, allocation.Namespace}
+		if utilization, ret := device.GetUtilizationRates(); ret == nvml.SUCCESS {
+			sliceGPUUtilization.WithLabelValues(labels...).Set(float64(utilization.Gpu))
+		} else if ret != nvml.ERROR_NOT_SUPPORTED {
+			log.FromContext(ctx).Info("unable to get MIG device utilization", "migUUID", migUUID, "error", ret)
+		}
+		if memory, ret := device.GetMemoryInfo(); ret == nvml.SUCCESS {
+			sliceMemoryUsed.WithLabelValues(labels...).Set(float64(memory.Used))
+			sliceMemoryTotal.WithLabelValues(labels...).Set(float64(memory.Total))
+		} else if ret != nvml.ERROR_NOT_SUPPORTED {
+			log.FromContext(ctx).Info("unable to get MIG device memory", "migUUID", migUUID, "error", ret)
+		}
+		if milliwatts, ret := device.GetPowerUsage(); ret == nvml.SUCCESS {
+			slicePowerUsage.WithLabelValues(labels...).Set(float64(milliwatts) / 1000)
+		} else if ret != nvml.ERROR_NOT_SUPPORTED {
+			log.FromContext(ctx).Info("unable to get MIG device power usage", "migUUID", migUUID, "error", ret)
+		}
+	}
+	return nil
+}
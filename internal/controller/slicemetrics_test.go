@@ -0,0 +1,84 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// collectedSeries returns the number of series a collector currently exposes.
+func collectedSeries(collector prometheus.Collector) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	count := 0
+	for range ch {
+		count++
+	}
+	return count
+}
+
+func TestObserveSliceUsagePublishesPreparedSlices(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	require.Len(t, instaslice.Status.Prepared, 1)
+	var migUUID string
+	for uuid := range instaslice.Status.Prepared {
+		migUUID = uuid
+	}
+
+	// the pod keeps its slice busy
+	server := reconciler.handler().nvml.(*dgxa100.Server)
+	getHandleByUUID := server.DeviceGetHandleByUUIDFunc
+	server.DeviceGetHandleByUUIDFunc = func(uuid string) (nvml.Device, nvml.Return) {
+		device, ret := getHandleByUUID(uuid)
+		if mig, ok := device.(*mock.Device); ok {
+			mig.GetUtilizationRatesFunc = func() (nvml.Utilization, nvml.Return) {
+				return nvml.Utilization{Gpu: 42}, nvml.SUCCESS
+			}
+		}
+		return device, ret
+	}
+	// series of slices gone since the last sample are dropped
+	sliceGPUUtilization.WithLabelValues("MIG-gone", "pod-gone", "default").Set(1)
+
+	require.NoError(t, reconciler.observeSliceUsage(ctx, server, "node-1"))
+	assert.Equal(t, 42.0, gaugeValue(t, sliceGPUUtilization.WithLabelValues(migUUID, "pod-0", "default")))
+	assert.Equal(t, 0.0, gaugeValue(t, sliceMemoryUsed.WithLabelValues(migUUID, "pod-0", "default")))
+	assert.Equal(t, float64(4864*1024*1024), gaugeValue(t, sliceMemoryTotal.WithLabelValues(migUUID, "pod-0", "default")))
+	assert.Equal(t, 1, collectedSeries(sliceGPUUtilization))
+	// the fake, like A100s, does not report the power of a slice
+	assert.Zero(t, collectedSeries(slicePowerUsage))
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// DefaultOperatorVersion is the version recorded on the slices the daemonset carves when OperatorVersion is unset.
+const DefaultOperatorVersion = "v1alpha1"
+
+// operatorVersion returns the version recorded on the slices the daemonset carves.
+func (r *InstaSliceDaemonsetReconciler) operatorVersion() string {
+	if r.OperatorVersion == "" {
+		return DefaultOperatorVersion
+	}
+	return r.OperatorVersion
+}
+
+// carvedByOtherVersion reports whether the slice was carved by another version of the operator. Slices found on
+// the GPUs record no version and are not.
+func (r *InstaSliceDaemonsetReconciler) carvedByOtherVersion(prepared inferencev1alpha1.PreparedDetails) bool {
+	return prepared.CarvedBy != "" && prepared.CarvedBy != r.operatorVersion()
+}
+
+// otherVersionSlices returns the number of slices of the GPU carved by other versions of the operator. Another
+// version owns them during a mixed-version rollout, they are neither destroyed nor carved over.
+func (r *InstaSliceDaemonsetReconciler) otherVersionSlices(instaslice *inferencev1alpha1.Instaslice, gpuUUID string) int {
+	count := 0
+	for _, prepared := range instaslice.Status.Prepared {
+		if prepared.Parent == gpuUUID && r.carvedByOtherVersion(prepared) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestSlicesOfOtherVersionAreRespected(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t)
+	ctx := context.Background()
+	instaslice := setDesiredLayout(t, reconciler, fakeClient, device, "1g.5gb", "1g.5gb", "1g.5gb")
+	require.Len(t, instaslice.Status.Prepared, 3)
+	for _, prepared := range instaslice.Status.Prepared {
+		assert.Equal(t, DefaultOperatorVersion, prepared.CarvedBy)
+	}
+
+	// the next version of the operator takes over the node while the slices of the previous one are still there
+	reconciler.OperatorVersion = "v1alpha2"
+	instaslice = setDesiredLayout(t, reconciler, fakeClient, device, "7g.40gb")
+	assert.Equal(t, []string{"1g.5gb", "1g.5gb", "1g.5gb"}, currentLayout(instaslice, device.UUID))
+	assert.Len(t, device.GpuInstances, 3)
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionLayoutPending)
+	require.NotNil(t, condition)
+	assert.Contains(t, condition.Message, "waiting for 3 slices carved by other versions of the operator")
+
+	// discovering the slices again does not turn them into slices found on the GPU
+	_, err := reconciler.discoverMigEnabledGpuWithSlices(ctx)
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, instaslice))
+	require.Len(t, instaslice.Status.Prepared, 3)
+	for _, prepared := range instaslice.Status.Prepared {
+		assert.Equal(t, DefaultOperatorVersion, prepared.CarvedBy)
+		assert.Equal(t, "1g.5gb", prepared.Profile)
+	}
+}
+
+func TestDiscoveryKeepsTheProfileOfSlicesCarvedByAnOperator(t *testing.T) {
+	existing := &inferencev1alpha1.Instaslice{Status: inferencev1alpha1.InstasliceStatus{
+		Prepared: map[string]inferencev1alpha1.PreparedDetails{
+			"MIG-1": {Profile: "1g.6gb", Parent: "GPU-1", Size: 1, CarvedBy: "v1alpha2"},
+		},
+	}}
+	discovered := &inferencev1alpha1.Instaslice{Status: inferencev1alpha1.InstasliceStatus{
+		Prepared: map[string]inferencev1alpha1.PreparedDetails{
+			"MIG-1": {Profile: ForeignSliceProfile, Parent: "GPU-1", Size: 1, Giinfoid: 3},
+			"MIG-2": {Profile: ForeignSliceProfile, Parent: "GPU-1", Start: 1, Size: 1},
+		},
+	}}
+
+	mergeDiscovered(existing, discovered)
+	assert.Equal(t, inferencev1alpha1.PreparedDetails{Profile: "1g.6gb", Parent: "GPU-1", Size: 1, Giinfoid: 3, CarvedBy: "v1alpha2"},
+		existing.Status.Prepared["MIG-1"])
+	// a slice carved out of band is still foreign
+	assert.Equal(t, ForeignSliceProfile, existing.Status.Prepared["MIG-2"].Profile)
+	assert.Empty(t, existing.Status.Prepared["MIG-2"].CarvedBy)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// SnapshotAllocationsBucket holds the allocations of the node as JSON, keyed like in the instaslice.
+	SnapshotAllocationsBucket = "allocations"
+	// SnapshotPreparedBucket holds the prepared slices of the node as JSON, keyed by MIG UUID.
+	SnapshotPreparedBucket = "prepared"
+	// SnapshotCapacityBucket holds, per profile, how many slices of it alone the node can still carve.
+	SnapshotCapacityBucket = "capacity"
+	// SnapshotNodeBucket holds the name of the node and the time the snapshot was taken.
+	SnapshotNodeBucket = "node"
+)
+
+// a reconcile does not wait long for an engineer holding the database open, the next one takes the snapshot
+const snapshotLockTimeout = time.Second
+
+// snapshotState writes the state of the instaslice of the node to the database at SnapshotPath, if set.
+// The snapshot is only meant for offline debugging, failing to take it does not fail the reconcile.
+func (r *InstaSliceDaemonsetReconciler) snapshotState(ctx context.Context, nsName types.NamespacedName) {
+	if r.SnapshotPath == "" {
+		return
+	}
+	var instaslice inferencev1alpha1.Instaslice
+	if err := r.Get(ctx, nsName, &instaslice); err != nil {
+		log.FromContext(ctx).Error(err, "unable to read the instaslice to snapshot")
+		return
+	}
+	if err := writeSnapshot(r.SnapshotPath, &instaslice, now().Time); err != nil {
+		log.FromContext(ctx).Error(err, "unable to snapshot the instaslice", "path", r.SnapshotPath)
+	}
+}
+
+// writeSnapshot replaces the content of the database at path with the allocations, prepared slices and free
+// capacity of the instaslice.
+func writeSnapshot(path string, instaslice *inferencev1alpha1.Instaslice, takenAt time.Time) error {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: snapshotLockTimeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		allocations, err := recreateBucket(tx, SnapshotAllocationsBucket)
+		if err != nil {
+			return err
+		}
+		for key, allocation := range instaslice.Spec.Allocations {
+			if err := putJSON(allocations, key, allocation); err != nil {
+				return err
+			}
+		}
+		prepared, err := recreateBucket(tx, SnapshotPreparedBucket)
+		if err != nil {
+			return err
+		}
+		for migUUID, details := range instaslice.Status.Prepared {
+			if err := putJSON(prepared, migUUID, details); err != nil {
+				return err
+			}
+		}
+		capacity, err := recreateBucket(tx, SnapshotCapacityBucket)
+		if err != nil {
+			return err
+		}
+		for profile, free := range freeSlicesPerProfile(instaslice) {
+			if err := capacity.Put([]byte(profile), []byte(strconv.Itoa(free))); err != nil {
+				return err
+			}
+		}
+		node, err := recreateBucket(tx, SnapshotNodeBucket)
+		if err != nil {
+			return err
+		}
+		if err := node.Put([]byte("name"), []byte(instaslice.Name)); err != nil {
+			return err
+		}
+		return node.Put([]byte("takenAt"), []byte(takenAt.UTC().Format(time.RFC3339)))
+	})
+}
+
+// recreateBucket returns the bucket emptied of what an earlier snapshot left in it.
+func recreateBucket(tx *bolt.Tx, name string) (*bolt.Bucket, error) {
+	if err := tx.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+		return nil, err
+	}
+	return tx.CreateBucket([]byte(name))
+}
+
+func putJSON(bucket *bolt.Bucket, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), data)
+}
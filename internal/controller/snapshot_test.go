@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestReconcileWritesSnapshot(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0, 1)
+	ctx := context.Background()
+	reconciler.SnapshotPath = filepath.Join(t.TempDir(), "instaslice.db")
+
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	require.Len(t, instaslice.Spec.Allocations, 2)
+
+	db, err := bolt.Open(reconciler.SnapshotPath, 0o600, &bolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		allocations := tx.Bucket([]byte(SnapshotAllocationsBucket))
+		require.NotNil(t, allocations)
+		assert.Equal(t, len(instaslice.Spec.Allocations), allocations.Stats().KeyN)
+		for key, allocation := range instaslice.Spec.Allocations {
+			var stored inferencev1alpha1.AllocationDetails
+			require.NoError(t, json.Unmarshal(allocations.Get([]byte(key)), &stored))
+			assert.Equal(t, allocation, stored)
+		}
+		prepared := tx.Bucket([]byte(SnapshotPreparedBucket))
+		require.NotNil(t, prepared)
+		assert.Equal(t, len(instaslice.Status.Prepared), prepared.Stats().KeyN)
+		capacity := tx.Bucket([]byte(SnapshotCapacityBucket))
+		require.NotNil(t, capacity)
+		assert.NotNil(t, capacity.Get([]byte("1g.5gb")))
+		assert.Equal(t, "node-1", string(tx.Bucket([]byte(SnapshotNodeBucket)).Get([]byte("name"))))
+		return nil
+	}))
+}
+
+func TestWriteSnapshotDropsRowsOfEarlierSnapshots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instaslice.db")
+	instaslice := &inferencev1alpha1.Instaslice{
+		Spec: inferencev1alpha1.InstasliceSpec{
+			Allocations: map[string]inferencev1alpha1.AllocationDetails{
+				"pod-uid-0": {PodUUID: "pod-uid-0"},
+				"pod-uid-1": {PodUUID: "pod-uid-1"},
+			},
+		},
+	}
+	require.NoError(t, writeSnapshot(path, instaslice, now().Time))
+	delete(instaslice.Spec.Allocations, "pod-uid-0")
+	require.NoError(t, writeSnapshot(path, instaslice, now().Time))
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		allocations := tx.Bucket([]byte(SnapshotAllocationsBucket))
+		assert.Nil(t, allocations.Get([]byte("pod-uid-0")))
+		assert.NotNil(t, allocations.Get([]byte("pod-uid-1")))
+		return nil
+	}))
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// DefaultStuckAllocationThreshold is how long an allocation stays creating or deleting before it counts as stuck.
+const DefaultStuckAllocationThreshold = 10 * time.Minute
+
+// allocationTransition is the transitional status an allocation was first seen in, and when.
+type allocationTransition struct {
+	status inferencev1alpha1.AllocationStatus
+	since  time.Time
+}
+
+// inTransition reports whether the status is one an allocation is only meant to pass through.
+func inTransition(status inferencev1alpha1.AllocationStatus) bool {
+	return status == inferencev1alpha1.AllocationStatusCreating || status == inferencev1alpha1.AllocationStatusDeleting
+}
+
+// observeStuckAllocations publishes how many allocations of the instaslice have been creating or deleting for
+// at least StuckAllocationThreshold. Allocations do not record when their status changed, the time is counted
+// from the first reconcile that saw them in their status since the daemonset started.
+func (r *InstaSliceDaemonsetReconciler) observeStuckAllocations(nodeName string, instaslice *inferencev1alpha1.Instaslice) {
+	current := now().Time
+	r.transitionsMu.Lock()
+	defer r.transitionsMu.Unlock()
+	seen := make(map[string]allocationTransition)
+	stuck := 0
+	for key, allocation := range instaslice.Spec.Allocations {
+		if !inTransition(allocation.Allocationstatus) {
+			continue
+		}
+		transition, exists := r.transitions[key]
+		if !exists || transition.status != allocation.Allocationstatus {
+			transition = allocationTransition{status: allocation.Allocationstatus, since: current}
+		}
+		seen[key] = transition
+		if current.Sub(transition.since) >= r.StuckAllocationThreshold {
+			stuck++
+		}
+	}
+	r.transitions = seen
+	stuckAllocations.WithLabelValues(nodeName).Set(float64(stuck))
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestAllocationStuckPastThresholdIsCounted(t *testing.T) {
+	defer func() { now = metav1.Now }()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() metav1.Time { return metav1.NewTime(start) }
+	r := &InstaSliceDaemonsetReconciler{StuckAllocationThreshold: 5 * time.Minute}
+	instaslice := &inferencev1alpha1.Instaslice{Spec: inferencev1alpha1.InstasliceSpec{
+		Allocations: map[string]inferencev1alpha1.AllocationDetails{
+			"pod-uid-0": {PodUUID: "pod-uid-0", Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+			"pod-uid-1": {PodUUID: "pod-uid-1", Allocationstatus: inferencev1alpha1.AllocationStatusCreated},
+		},
+	}}
+	gauge := stuckAllocations.WithLabelValues("node-stuck")
+
+	r.observeStuckAllocations("node-stuck", instaslice)
+	assert.Equal(t, 0.0, gaugeValue(t, gauge))
+
+	now = func() metav1.Time { return metav1.NewTime(start.Add(4 * time.Minute)) }
+	r.observeStuckAllocations("node-stuck", instaslice)
+	assert.Equal(t, 0.0, gaugeValue(t, gauge))
+
+	now = func() metav1.Time { return metav1.NewTime(start.Add(5 * time.Minute)) }
+	r.observeStuckAllocations("node-stuck", instaslice)
+	assert.Equal(t, 1.0, gaugeValue(t, gauge))
+
+	// moving on to another transitional status starts the count again
+	deleting := instaslice.Spec.Allocations["pod-uid-0"]
+	deleting.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+	instaslice.Spec.Allocations["pod-uid-0"] = deleting
+	r.observeStuckAllocations("node-stuck", instaslice)
+	assert.Equal(t, 0.0, gaugeValue(t, gauge))
+
+	now = func() metav1.Time { return metav1.NewTime(start.Add(11 * time.Minute)) }
+	r.observeStuckAllocations("node-stuck", instaslice)
+	assert.Equal(t, 1.0, gaugeValue(t, gauge))
+
+	delete(instaslice.Spec.Allocations, "pod-uid-0")
+	r.observeStuckAllocations("node-stuck", instaslice)
+	assert.Equal(t, 0.0, gaugeValue(t, gauge))
+}
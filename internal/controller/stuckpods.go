@@ -0,0 +1,186 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// EventReasonStuckPodSliceReclaimed is the reason of the event emitted on a pod whose slice is reclaimed after it
+// could not start on it.
+const EventReasonStuckPodSliceReclaimed = "StuckPodSliceReclaimed"
+
+// stuckContainerReasons are the waiting reasons of containers that cannot start, e.g. for want of their device.
+var stuckContainerReasons = map[string]bool{
+	"ContainerCreating":          true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// podStuckSince returns since when the pod has been unable to start on its node, and whether it is. The pod is
+// timed from when it was scheduled, or created when that is unknown.
+func podStuckSince(pod *v1.Pod) (time.Time, bool) {
+	if pod.Status.Phase != v1.PodPending {
+		return time.Time{}, false
+	}
+	stuck := false
+	for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if status.State.Waiting != nil && stuckContainerReasons[status.State.Waiting.Reason] {
+			stuck = true
+			break
+		}
+	}
+	if !stuck {
+		return time.Time{}, false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionTrue && !condition.LastTransitionTime.IsZero() {
+			return condition.LastTransitionTime.Time, true
+		}
+	}
+	return pod.CreationTimestamp.Time, true
+}
+
+// stuckPod returns the pod of the realized allocation when it has been unable to start for StuckPodThreshold.
+func (r *InstaSliceDaemonsetReconciler) stuckPod(ctx context.Context, allocation inferencev1alpha1.AllocationDetails) (*v1.Pod, error) {
+	var pod v1.Pod
+	err := r.Get(ctx, types.NamespacedName{Name: allocation.PodName, Namespace: allocation.Namespace}, &pod)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if string(pod.UID) != allocation.PodUUID {
+		return nil, nil
+	}
+	since, stuck := podStuckSince(&pod)
+	if !stuck || now().Time.Sub(since) < r.StuckPodThreshold {
+		return nil, nil
+	}
+	return &pod, nil
+}
+
+// reclaimSlicesOfStuckPods takes corrective action on the realized slices of the node whose pod has been unable to
+// start for StuckPodThreshold, e.g. because its MIG UUID is no longer valid or its ConfigMap is missing. The MIG
+// devices of the pod are verified and its ConfigMap created again first; when a device is gone, or the pod is
+// still stuck StuckPodThreshold after that, the allocation moves to deleting for the slice to be reclaimed. It
+// reports whether any was.
+func (r *InstaSliceDaemonsetReconciler) reclaimSlicesOfStuckPods(ctx context.Context, nodeName string, instaslice *inferencev1alpha1.Instaslice) (bool, error) {
+	r.stuckPodsMu.Lock()
+	defer r.stuckPodsMu.Unlock()
+	if r.stuckPodsVerified == nil {
+		r.stuckPodsVerified = make(map[string]time.Time)
+	}
+	for podUUID := range r.stuckPodsVerified {
+		if _, exists := instaslice.Spec.Allocations[podUUID]; !exists {
+			delete(r.stuckPodsVerified, podUUID)
+		}
+	}
+
+	migUUIDs := allocationMigUUIDs(instaslice)
+	var stuck []string
+	for podUUID, allocation := range instaslice.Spec.Allocations {
+		if !settledAllocation(allocation) || podUUID != allocation.PodUUID || isPoolAllocation(allocation) {
+			continue
+		}
+		pod, err := r.stuckPod(ctx, allocation)
+		if err != nil {
+			return false, err
+		}
+		if pod == nil {
+			delete(r.stuckPodsVerified, podUUID)
+			continue
+		}
+		verifiedAt, verified := r.stuckPodsVerified[podUUID]
+		if verified {
+			// the slice was found sound, the pod gets as long again to start before it is given up on
+			if now().Time.Sub(verifiedAt) >= r.StuckPodThreshold {
+				stuck = append(stuck, podUUID)
+			}
+			continue
+		}
+		sound, err := r.recreateConfigMap(ctx, migUUIDs[podUUID], allocation)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "unable to verify the slice of the stuck pod, keeping it for now", "pod", allocation.PodName)
+			continue
+		}
+		if !sound {
+			stuck = append(stuck, podUUID)
+			continue
+		}
+		log.FromContext(ctx).Info("slice of the stuck pod verified, waiting for it to start", "pod", allocation.PodName, "migUUIDs", migUUIDs[podUUID])
+		r.stuckPodsVerified[podUUID] = now().Time
+	}
+	if len(stuck) == 0 {
+		return false, nil
+	}
+	sort.Strings(stuck)
+	var reclaimed []inferencev1alpha1.AllocationDetails
+	_, err := r.updateInstaslice(ctx, nodeName, func(latest *inferencev1alpha1.Instaslice) error {
+		reclaimed = reclaimed[:0]
+		for _, podUUID := range stuck {
+			// the allocation may have moved on meanwhile, e.g. the pod was deleted
+			allocation, exists := latest.Spec.Allocations[podUUID]
+			if !exists || !settledAllocation(allocation) {
+				continue
+			}
+			allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+			latest.Spec.Allocations[podUUID] = allocation
+			reclaimed = append(reclaimed, allocation)
+		}
+		if len(reclaimed) == 0 {
+			return errInstasliceUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, allocation := range reclaimed {
+		log.FromContext(ctx).Info("pod could not start on its slice, reclaiming slice of ", "pod", allocation.PodName)
+		delete(r.stuckPodsVerified, allocation.PodUUID)
+		r.recordStuckPodSliceReclaimed(allocation)
+	}
+	return len(reclaimed) > 0, nil
+}
+
+// recordStuckPodSliceReclaimed emits a warning event on the pod whose slice is reclaimed after it could not start.
+func (r *InstaSliceDaemonsetReconciler) recordStuckPodSliceReclaimed(allocation inferencev1alpha1.AllocationDetails) {
+	if r.Recorder == nil {
+		return
+	}
+	pod := &v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Name:       allocation.PodName,
+		Namespace:  allocation.Namespace,
+		UID:        types.UID(allocation.PodUUID),
+	}
+	r.Recorder.Eventf(pod, v1.EventTypeWarning, EventReasonStuckPodSliceReclaimed,
+		"Pod could not start on slice %s on GPU %s for %s, reclaiming it", allocation.Profile, allocation.GPUUUID, r.StuckPodThreshold)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// createStuckTestPod creates pod-0, scheduled at scheduledAt and stuck in ContainerCreating since.
+func createStuckTestPod(t *testing.T, fakeClient client.Client, scheduledAt time.Time) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default", UID: "pod-uid-0"},
+		Status: v1.PodStatus{
+			Phase: v1.PodPending,
+			Conditions: []v1.PodCondition{
+				{Type: v1.PodScheduled, Status: v1.ConditionTrue, LastTransitionTime: metav1.NewTime(scheduledAt)},
+			},
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "main", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}},
+			},
+		},
+	}
+	require.NoError(t, fakeClient.Create(context.Background(), pod))
+}
+
+func TestStuckPodGetsItsConfigMapBackThenItsSliceReclaimed(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.StuckPodThreshold = 10 * time.Minute
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	defer func() { now = metav1.Now }()
+	now = func() metav1.Time { return metav1.NewTime(start) }
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	migUUID, _ := preparedOfPod(*latestTestInstaslice(t, fakeClient), "pod-uid-0")
+	require.NotEmpty(t, migUUID)
+
+	// the ConfigMap of the pod went missing, its containers cannot be created
+	createStuckTestPod(t, fakeClient, start)
+	configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default"}}
+	require.NoError(t, fakeClient.Delete(ctx, configMap))
+
+	now = func() metav1.Time { return metav1.NewTime(start.Add(5 * time.Minute)) }
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-0", Namespace: "default"}, configMap),
+		"a missing ConfigMap of a live pod is created again without waiting for the pod to be stuck")
+	require.NoError(t, fakeClient.Delete(ctx, configMap))
+
+	now = func() metav1.Time { return metav1.NewTime(start.Add(10 * time.Minute)) }
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "pod-0", Namespace: "default"}, configMap))
+	assert.Equal(t, migUUID, configMap.Data["NVIDIA_VISIBLE_DEVICES"])
+	assert.Equal(t, inferencev1alpha1.AllocationStatusCreated, latestTestInstaslice(t, fakeClient).Spec.Allocations["pod-uid-0"].Allocationstatus,
+		"the MIG device of the pod is still there, the pod gets another chance to start")
+
+	// still stuck after the slice was verified, the slice is given back
+	now = func() metav1.Time { return metav1.NewTime(start.Add(20 * time.Minute)) }
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, latestTestInstaslice(t, fakeClient).Spec.Allocations["pod-uid-0"].Allocationstatus)
+}
+
+func TestStuckPodWithInvalidMigUUIDHasItsSliceReclaimed(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.StuckPodThreshold = 10 * time.Minute
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	defer func() { now = metav1.Now }()
+	now = func() metav1.Time { return metav1.NewTime(start) }
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	// the instaslice names a MIG device the GPU does not have
+	instaslice := latestTestInstaslice(t, fakeClient)
+	migUUID, prepared := preparedOfPod(*instaslice, "pod-uid-0")
+	delete(instaslice.Status.Prepared, migUUID)
+	instaslice.Status.Prepared["MIG-gone"] = prepared
+	require.NoError(t, fakeClient.Status().Update(ctx, instaslice))
+	createStuckTestPod(t, fakeClient, start)
+
+	now = func() metav1.Time { return metav1.NewTime(start.Add(10 * time.Minute)) }
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, inferencev1alpha1.AllocationStatusDeleting, latestTestInstaslice(t, fakeClient).Spec.Allocations["pod-uid-0"].Allocationstatus)
+}
+
+func TestRunningPodKeepsItsSlice(t *testing.T) {
+	pod := &v1.Pod{Status: v1.PodStatus{Phase: v1.PodRunning}}
+	_, stuck := podStuckSince(pod)
+	assert.False(t, stuck)
+
+	pod.Status.Phase = v1.PodPending
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{
+		{Name: "main", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}},
+	}
+	_, stuck = podStuckSince(pod)
+	assert.False(t, stuck, "pods waiting on their image are not stuck on their slice")
+}
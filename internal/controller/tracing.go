@@ -0,0 +1,80 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	v1 "k8s.io/api/core/v1"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// TraceParentAnnotation holds the W3C traceparent of the trace a pod is scheduled in, the spans of the slice of
+	// the pod join that trace.
+	TraceParentAnnotation = "org.instaslice/traceparent"
+	// TracerName is the name of the tracer of the spans of the slices.
+	TracerName = "codeflare.dev/instaslice"
+	// SpanSliceCreation is the name of the span of the carving of a slice.
+	SpanSliceCreation = "instaslice.slice.create"
+	// SpanSliceDeletion is the name of the span of the destruction of the slices of a pod.
+	SpanSliceDeletion = "instaslice.slice.delete"
+)
+
+// recordTraceParent records on the allocation the trace the pod is scheduled in, if any.
+func recordTraceParent(allocDetails *inferencev1alpha1.AllocationDetails, pod *v1.Pod) {
+	allocDetails.TraceParent = pod.Annotations[TraceParentAnnotation]
+}
+
+// tracer returns the tracer of the spans of the slices, one recording nothing when no provider was set.
+func (r *InstaSliceDaemonsetReconciler) tracer() trace.Tracer {
+	if r.TracerProvider == nil {
+		return noop.NewTracerProvider().Tracer(TracerName)
+	}
+	return r.TracerProvider.Tracer(TracerName)
+}
+
+// startSliceSpan starts a span on the slice of the allocation, child of the trace recorded on the allocation if any.
+func (r *InstaSliceDaemonsetReconciler) startSliceSpan(ctx context.Context, name string, allocation inferencev1alpha1.AllocationDetails) (context.Context, trace.Span) {
+	if allocation.TraceParent != "" {
+		ctx = propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": allocation.TraceParent})
+	}
+	return r.tracer().Start(ctx, name, trace.WithAttributes(
+		attribute.String("instaslice.pod.namespace", allocation.Namespace),
+		attribute.String("instaslice.pod.name", allocation.PodName),
+		attribute.String("instaslice.pod.uid", allocation.PodUUID),
+		attribute.String("instaslice.profile", allocation.Profile),
+		attribute.String("instaslice.gpu.uuid", allocation.GPUUUID),
+		attribute.Int("instaslice.placement.start", int(allocation.Start)),
+		attribute.Int("instaslice.placement.size", int(allocation.Size)),
+	))
+}
+
+// endSliceSpan ends the span, marking it failed with the error if any.
+func endSliceSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// newTracedTestReconciler returns a fake GPU reconciler recording its spans, the allocation of pod-uid-0 being
+// part of the trace of testTraceParent.
+func newTracedTestReconciler(t *testing.T) (*InstaSliceDaemonsetReconciler, client.Client, *tracetest.SpanRecorder) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	recorder := tracetest.NewSpanRecorder()
+	reconciler.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	instaslice := latestTestInstaslice(t, fakeClient)
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.TraceParent = testTraceParent
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(context.Background(), instaslice))
+	return reconciler, fakeClient, recorder
+}
+
+// endedSpan returns the ended span of the given name, failing the test when there is none.
+func endedSpan(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	require.Failf(t, "span not recorded", "no ended span %s", name)
+	return nil
+}
+
+func TestSliceCreationIsTraced(t *testing.T) {
+	reconciler, fakeClient, recorder := newTracedTestReconciler(t)
+	_, err := reconciler.Reconcile(context.Background(), ctrl.Request{})
+	require.NoError(t, err)
+	instaslice := latestTestInstaslice(t, fakeClient)
+	migUUID, _ := preparedOfPod(*instaslice, "pod-uid-0")
+	require.NotEmpty(t, migUUID)
+
+	span := endedSpan(t, recorder, SpanSliceCreation)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+	attributes := attribute.NewSet(span.Attributes()...)
+	profile, _ := attributes.Value("instaslice.profile")
+	assert.Equal(t, "1g.5gb", profile.AsString())
+	gpuUUID, _ := attributes.Value("instaslice.gpu.uuid")
+	assert.Equal(t, instaslice.Spec.Allocations["pod-uid-0"].GPUUUID, gpuUUID.AsString())
+	start, _ := attributes.Value("instaslice.placement.start")
+	assert.Equal(t, int64(0), start.AsInt64())
+	migUUIDs, _ := attributes.Value("instaslice.mig.uuids")
+	assert.Equal(t, []string{migUUID}, migUUIDs.AsStringSlice())
+}
+
+func TestSliceDeletionIsTraced(t *testing.T) {
+	reconciler, fakeClient, recorder := newTracedTestReconciler(t)
+	ctx := context.Background()
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	instaslice := latestTestInstaslice(t, fakeClient)
+	allocation := instaslice.Spec.Allocations["pod-uid-0"]
+	allocation.Allocationstatus = inferencev1alpha1.AllocationStatusDeleting
+	instaslice.Spec.Allocations["pod-uid-0"] = allocation
+	require.NoError(t, fakeClient.Update(ctx, instaslice))
+
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+
+	span := endedSpan(t, recorder, SpanSliceDeletion)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+}
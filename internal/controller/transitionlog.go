@@ -0,0 +1,85 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// TransitionCreated is logged when the slice of an allocation got carved.
+	TransitionCreated = "created"
+	// TransitionFailed is logged when an allocation was given up.
+	TransitionFailed = "failed"
+	// TransitionDeleted is logged when an allocation was removed from the instaslice.
+	TransitionDeleted = "deleted"
+)
+
+// AllocationTransition is the JSON line written to stdout for every lifecycle transition of an allocation.
+type AllocationTransition struct {
+	Time           time.Time `json:"time"`
+	Node           string    `json:"node"`
+	Transition     string    `json:"transition"`
+	PreviousStatus string    `json:"previousStatus,omitempty"`
+	// Allocation is the allocation after the transition, as it was last seen for a deleted one.
+	Allocation inferencev1alpha1.AllocationDetails `json:"allocation"`
+}
+
+// logAllocationTransitions writes a JSON line to stdout for every allocation of the instaslice that was created,
+// failed or deleted since the last reconcile. Allocations found by the first reconcile after the daemonset
+// started are taken as they are, nothing is logged for them.
+func (r *InstaSliceDaemonsetReconciler) logAllocationTransitions(nodeName string, instaslice *inferencev1alpha1.Instaslice) {
+	r.lastAllocationsMu.Lock()
+	defer r.lastAllocationsMu.Unlock()
+	current := now().Time
+	var transitions []AllocationTransition
+	if r.lastAllocations != nil {
+		for key, allocation := range instaslice.Spec.Allocations {
+			previous, existed := r.lastAllocations[key]
+			if existed && previous.Allocationstatus == allocation.Allocationstatus {
+				continue
+			}
+			if allocation.Allocationstatus != inferencev1alpha1.AllocationStatusCreated && allocation.Allocationstatus != inferencev1alpha1.AllocationStatusFailed {
+				continue
+			}
+			transitions = append(transitions, AllocationTransition{Time: current, Node: nodeName, Transition: string(allocation.Allocationstatus),
+				PreviousStatus: string(previous.Allocationstatus), Allocation: allocation})
+		}
+		for key, previous := range r.lastAllocations {
+			if _, exists := instaslice.Spec.Allocations[key]; !exists {
+				transitions = append(transitions, AllocationTransition{Time: current, Node: nodeName, Transition: TransitionDeleted,
+					PreviousStatus: string(previous.Allocationstatus), Allocation: previous})
+			}
+		}
+	}
+	r.lastAllocations = make(map[string]inferencev1alpha1.AllocationDetails, len(instaslice.Spec.Allocations))
+	for key, allocation := range instaslice.Spec.Allocations {
+		r.lastAllocations[key] = allocation
+	}
+	sort.Slice(transitions, func(i, j int) bool {
+		return transitions[i].Allocation.PodUUID < transitions[j].Allocation.PodUUID
+	})
+	encoder := json.NewEncoder(os.Stdout)
+	for _, transition := range transitions {
+		_ = encoder.Encode(transition)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// captureStdout returns what f wrote to stdout.
+func captureStdout(t *testing.T, f func()) []byte {
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+	f()
+	require.NoError(t, writer.Close())
+	out, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return out
+}
+
+// transitionRecords parses the JSON lines written for allocation transitions.
+func transitionRecords(t *testing.T, out []byte) []AllocationTransition {
+	var records []AllocationTransition
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		var record AllocationTransition
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record), "line %q", scanner.Text())
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAllocationTransitionsAreLoggedAsJSON(t *testing.T) {
+	reconciler, fakeClient, _, _ := newFakeGPUTestReconciler(t, 0)
+	reconciler.LogAllocationTransitions = true
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	// the first reconcile carves the slice, the next one sees it created
+	out := captureStdout(t, func() {
+		for i := 0; i < 2; i++ {
+			_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+			require.NoError(t, err)
+		}
+	})
+	records := transitionRecords(t, out)
+	require.Len(t, records, 1)
+	assert.Equal(t, TransitionCreated, records[0].Transition)
+	assert.Equal(t, "creating", records[0].PreviousStatus)
+	assert.Equal(t, "node-1", records[0].Node)
+	assert.Equal(t, "pod-uid-0", records[0].Allocation.PodUUID)
+	assert.Equal(t, "pod-0", records[0].Allocation.PodName)
+	assert.Equal(t, "1g.5gb", records[0].Allocation.Profile)
+	assert.False(t, records[0].Time.IsZero())
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	delete(instaslice.Spec.Allocations, "pod-uid-0")
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	out = captureStdout(t, func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{})
+		require.NoError(t, err)
+	})
+	records = transitionRecords(t, out)
+	require.Len(t, records, 1)
+	assert.Equal(t, TransitionDeleted, records[0].Transition)
+	assert.Equal(t, "created", records[0].PreviousStatus)
+	assert.Equal(t, "pod-uid-0", records[0].Allocation.PodUUID)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// Reasons recorded in status.unschedulableOnNode for a pod no GPU of the node can host.
+	ReasonNoFreePlacement    = "NoFreePlacement"
+	ReasonProfileUnsupported = "ProfileUnsupported"
+	ReasonGPUsCordoned       = "GPUsCordoned"
+	ReasonProfileNotAllowed  = "ProfileNotAllowed"
+)
+
+// unschedulableReason tells why no GPU of the node can host a slice of the profile: the GPUs do not support it,
+// none of them allows it, only cordoned GPUs have room for it, or none has a free placement left. A pod asking
+// for any slice is turned down for the reason of the smallest profile of the node.
+func unschedulableReason(instaslice *inferencev1alpha1.Instaslice, profileName string) string {
+	profileName = nodeProfileFor(instaslice, profileName)
+	var placements []inferencev1alpha1.Placement
+	supported := false
+	for _, mig := range instaslice.Spec.Migplacement {
+		if mig.Profile == profileName {
+			placements, supported = mig.Placements, true
+			break
+		}
+	}
+	if !supported {
+		return ReasonProfileUnsupported
+	}
+	if !profileOffered(instaslice, profileName) {
+		return ReasonProfileNotAllowed
+	}
+	for gpuUUID := range instaslice.Spec.MigGPUUUID {
+		if gpuCordoned(instaslice, gpuUUID) && gpuAllowsProfile(instaslice, gpuUUID, profileName) && len(freePlacementsFor(placements, occupiedIndexes(instaslice, gpuUUID))) > 0 {
+			return ReasonGPUsCordoned
+		}
+	}
+	return ReasonNoFreePlacement
+}
+
+// markUnschedulableOnNode records in the status of the instaslice why the pod cannot be placed on the node.
+// The time it was first found unschedulable is kept while the reason stays the same.
+func (r *InstasliceReconciler) markUnschedulableOnNode(ctx context.Context, instasliceName string, pod *v1.Pod, profileName string, reason string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var latest inferencev1alpha1.Instaslice
+		if err := r.Get(ctx, types.NamespacedName{Name: instasliceName, Namespace: r.instasliceNamespace()}, &latest); err != nil {
+			return err
+		}
+		current, exists := latest.Status.UnschedulableOnNode[string(pod.UID)]
+		if exists && current.Reason == reason && current.Profile == profileName {
+			return nil
+		}
+		if latest.Status.UnschedulableOnNode == nil {
+			latest.Status.UnschedulableOnNode = make(map[string]inferencev1alpha1.UnschedulablePod)
+		}
+		latest.Status.UnschedulableOnNode[string(pod.UID)] = inferencev1alpha1.UnschedulablePod{
+			PodName:   pod.Name,
+			Namespace: pod.Namespace,
+			Profile:   profileName,
+			Reason:    reason,
+			Since:     now(),
+		}
+		log.FromContext(ctx).Info("pod cannot be placed on node", "pod", pod.Name, "node", instasliceName, "reason", reason)
+		return r.Status().Update(ctx, &latest)
+	})
+}
+
+// clearUnschedulableOnNode drops what was recorded about the pod from the instaslices, once it is placed or gone.
+func (r *InstasliceReconciler) clearUnschedulableOnNode(ctx context.Context, instaslices []inferencev1alpha1.Instaslice, podUUID string) error {
+	for _, instaslice := range instaslices {
+		if _, exists := instaslice.Status.UnschedulableOnNode[podUUID]; !exists {
+			continue
+		}
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			var latest inferencev1alpha1.Instaslice
+			if err := r.Get(ctx, types.NamespacedName{Name: instaslice.Name, Namespace: r.instasliceNamespace()}, &latest); err != nil {
+				return err
+			}
+			if _, exists := latest.Status.UnschedulableOnNode[podUUID]; !exists {
+				return nil
+			}
+			delete(latest.Status.UnschedulableOnNode, podUUID)
+			return r.Status().Update(ctx, &latest)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestPodThatFitsNowhereIsMarkedUnschedulableOnNode(t *testing.T) {
+	// every slot of the GPU is taken and the slice holding the last one cannot be preempted
+	reconciler, fakeClient, _ := newPreemptTestReconciler(t, newPreemptTestInstaslice(false), newPreemptTestPod(100))
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "pod-high", Namespace: "default"}}
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	first := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	defer func() { now = metav1.Now }()
+	now = func() metav1.Time { return first }
+
+	_, err := reconciler.Reconcile(ctx, req)
+	require.NoError(t, err)
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.NotContains(t, instaslice.Spec.Allocations, "pod-uid-high")
+	require.Contains(t, instaslice.Status.UnschedulableOnNode, "pod-uid-high")
+	unschedulable := instaslice.Status.UnschedulableOnNode["pod-uid-high"]
+	assert.Equal(t, ReasonNoFreePlacement, unschedulable.Reason)
+	assert.Equal(t, "pod-high", unschedulable.PodName)
+	assert.Equal(t, "1g.5gb", unschedulable.Profile)
+	assert.True(t, first.Equal(&unschedulable.Since))
+
+	// retrying keeps the time the pod was first turned down
+	now = func() metav1.Time { return metav1.NewTime(first.Add(time.Minute)) }
+	_, err = reconciler.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	unschedulable = instaslice.Status.UnschedulableOnNode["pod-uid-high"]
+	assert.True(t, first.Equal(&unschedulable.Since))
+
+	// once a slot frees up the pod is placed and the record goes away
+	delete(instaslice.Status.Prepared, "MIG-7")
+	require.NoError(t, fakeClient.Status().Update(ctx, &instaslice))
+	delete(instaslice.Spec.Allocations, "pod-uid-low")
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+	_, err = reconciler.Reconcile(ctx, req)
+	require.NoError(t, err)
+	var latest inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &latest))
+	assert.Contains(t, latest.Spec.Allocations, "pod-uid-high")
+	assert.Empty(t, latest.Status.UnschedulableOnNode)
+}
+
+func TestUnschedulableReason(t *testing.T) {
+	full := newPreemptTestInstaslice(false)
+	assert.Equal(t, ReasonNoFreePlacement, unschedulableReason(full, "1g.5gb"))
+	assert.Equal(t, ReasonProfileUnsupported, unschedulableReason(full, "7g.40gb"))
+
+	cordoned := newPlacementTestInstaslice()
+	cordoned.Spec.CordonedGPUs = []string{"GPU-1"}
+	assert.Equal(t, ReasonGPUsCordoned, unschedulableReason(cordoned, "1g.5gb"))
+	// a cordoned GPU without room is not what keeps the pod out
+	full.Spec.CordonedGPUs = []string{"GPU-1"}
+	assert.Equal(t, ReasonNoFreePlacement, unschedulableReason(full, "1g.5gb"))
+}
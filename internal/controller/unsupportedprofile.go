@@ -0,0 +1,124 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// EventReasonProfileUnsupported is the reason of the event emitted on a pod whose allocation asks for a profile
+// the GPUs of the node no longer support.
+const EventReasonProfileUnsupported = "ProfileUnsupported"
+
+// unsupportedProfileAllocations returns in order the keys of the allocations waiting for a slice of a profile
+// missing from the Migplacement of the node, e.g. after a driver upgrade changed the profiles discovery found.
+// Profiles are compared by name regardless of the order of their attributes.
+func unsupportedProfileAllocations(instaslice *inferencev1alpha1.Instaslice) []string {
+	supported := make(map[string]bool, len(instaslice.Spec.Migplacement))
+	for _, mig := range instaslice.Spec.Migplacement {
+		supported[CanonicalProfileName(mig.Profile)] = true
+	}
+	var unsupported []string
+	for key, allocation := range instaslice.Spec.Allocations {
+		if key == allocation.PodUUID && allocation.Allocationstatus == inferencev1alpha1.AllocationStatusCreating && !supported[CanonicalProfileName(allocation.Profile)] && !isPoolAllocation(allocation) {
+			unsupported = append(unsupported, key)
+		}
+	}
+	sort.Strings(unsupported)
+	return unsupported
+}
+
+// failUnsupportedProfileAllocations marks the allocations of unsupported profiles failed and records why in
+// status.unschedulableOnNode, carving their slices would only fail in NVML on every reconcile. The pods stay
+// gated until they are deleted. It reports whether any allocation was marked.
+func (r *InstaSliceDaemonsetReconciler) failUnsupportedProfileAllocations(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) (bool, error) {
+	// Migplacement is incomplete until discovery went through, and empty when it found no GPU to support anything
+	if instaslice.Status.Processed != "true" || len(instaslice.Spec.Migplacement) == 0 || len(unsupportedProfileAllocations(instaslice)) == 0 {
+		return false, nil
+	}
+	var failed []inferencev1alpha1.AllocationDetails
+	if _, err := r.updateInstaslice(ctx, instaslice.Name, func(latest *inferencev1alpha1.Instaslice) error {
+		failed = nil
+		for _, key := range unsupportedProfileAllocations(latest) {
+			allocation := latest.Spec.Allocations[key]
+			allocation.Allocationstatus = inferencev1alpha1.AllocationStatusFailed
+			latest.Spec.Allocations[key] = allocation
+			failed = append(failed, allocation)
+		}
+		if len(failed) == 0 {
+			return errInstasliceUnchanged
+		}
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	if len(failed) == 0 {
+		return false, nil
+	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var latest inferencev1alpha1.Instaslice
+		if err := r.Get(ctx, types.NamespacedName{Name: instaslice.Name, Namespace: r.instasliceNamespace()}, &latest); err != nil {
+			return err
+		}
+		if latest.Status.UnschedulableOnNode == nil {
+			latest.Status.UnschedulableOnNode = make(map[string]inferencev1alpha1.UnschedulablePod)
+		}
+		for _, allocation := range failed {
+			latest.Status.UnschedulableOnNode[allocation.PodUUID] = inferencev1alpha1.UnschedulablePod{
+				PodName:   allocation.PodName,
+				Namespace: allocation.Namespace,
+				Profile:   allocation.Profile,
+				Reason:    ReasonProfileUnsupported,
+				Since:     now(),
+			}
+		}
+		return r.Status().Update(ctx, &latest)
+	})
+	if err != nil {
+		return true, err
+	}
+	for _, allocation := range failed {
+		r.recordProfileUnsupported(ctx, allocation)
+	}
+	return true, nil
+}
+
+// recordProfileUnsupported reports the failed allocation in the logs and, when a recorder is set, in an event on the pod.
+func (r *InstaSliceDaemonsetReconciler) recordProfileUnsupported(ctx context.Context, allocation inferencev1alpha1.AllocationDetails) {
+	log.FromContext(ctx).Info("allocation asks for a profile the GPUs of the node no longer support", "pod", allocation.PodName,
+		"namespace", allocation.Namespace, "profile", allocation.Profile)
+	if r.Recorder == nil {
+		return
+	}
+	pod := &v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Name:       allocation.PodName,
+		Namespace:  allocation.Namespace,
+		UID:        types.UID(allocation.PodUUID),
+	}
+	r.Recorder.Eventf(pod, v1.EventTypeWarning, EventReasonProfileUnsupported,
+		"The GPUs of the node no longer support profile %s, no slice will be carved for the pod", allocation.Profile)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+func TestReconcileFailsAllocationsOfRemovedProfile(t *testing.T) {
+	reconciler, fakeClient, device, _ := newFakeGPUTestReconciler(t, 0)
+	recorder := record.NewFakeRecorder(1)
+	reconciler.Recorder = recorder
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+
+	// re-discovery after a driver upgrade no longer finds the profile of the pending allocation
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	var migPlacement []inferencev1alpha1.Mig
+	for _, mig := range instaslice.Spec.Migplacement {
+		if mig.Profile != "1g.5gb" {
+			migPlacement = append(migPlacement, mig)
+		}
+	}
+	instaslice.Spec.Migplacement = migPlacement
+	require.NoError(t, fakeClient.Update(ctx, &instaslice))
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.True(t, result.Requeue)
+
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusFailed, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+	unschedulable, exists := instaslice.Status.UnschedulableOnNode["pod-uid-0"]
+	require.True(t, exists)
+	assert.Equal(t, ReasonProfileUnsupported, unschedulable.Reason)
+	assert.Equal(t, "1g.5gb", unschedulable.Profile)
+	assert.Equal(t, "pod-0", unschedulable.PodName)
+	assert.Contains(t, <-recorder.Events, EventReasonProfileUnsupported)
+
+	// the failed allocation is left alone instead of being carved again and again
+	result, err = reconciler.Reconcile(ctx, ctrl.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, reconcileHeartbeatInterval, result.RequeueAfter)
+	assert.Empty(t, device.GpuInstances)
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, inferencev1alpha1.AllocationStatusFailed, instaslice.Spec.Allocations["pod-uid-0"].Allocationstatus)
+}
+
+func TestUnsupportedProfileAllocations(t *testing.T) {
+	instaslice := &inferencev1alpha1.Instaslice{
+		Spec: inferencev1alpha1.InstasliceSpec{
+			Migplacement: []inferencev1alpha1.Mig{{Profile: "1g.5gb"}, {Profile: "1g.5gb+me,eng1"}},
+			Allocations: map[string]inferencev1alpha1.AllocationDetails{
+				"pod-b":   {PodUUID: "pod-b", Profile: "2g.10gb", Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+				"pod-a":   {PodUUID: "pod-a", Profile: "3g.20gb", Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+				"pod-c":   {PodUUID: "pod-c", Profile: "1g.5gb", Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+				"pod-d":   {PodUUID: "pod-d", Profile: "2g.10gb", Allocationstatus: inferencev1alpha1.AllocationStatusCreated},
+				"other-e": {PodUUID: "pod-e", Profile: "2g.10gb", Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+				// attributes in another order name the same profile, dropping one names another
+				"pod-f": {PodUUID: "pod-f", Profile: "1g.5gb+eng1,me", Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+				"pod-g": {PodUUID: "pod-g", Profile: "1g.5gb+me", Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+			},
+		},
+	}
+	assert.Equal(t, []string{"pod-a", "pod-b", "pod-g"}, unsupportedProfileAllocations(instaslice))
+}
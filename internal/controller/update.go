@@ -0,0 +1,79 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+// errInstasliceUnchanged is returned by a mutation passed to updateInstaslice when there is nothing to write.
+var errInstasliceUnchanged = errors.New("instaslice unchanged")
+
+// updateInstaslice applies mutate to a freshly fetched copy of the instaslice and writes it back. The update
+// relies on the resourceVersion of that copy, when another writer got in first the instaslice is fetched again
+// and mutate reapplied, so mutate must only depend on the object it is given. The instaslice as last written
+// is returned, or as last fetched when mutate reports errInstasliceUnchanged. Prepared entries changed by mutate
+// are written through the status subresource, ahead of the rest of the instaslice when it changed too.
+func (r *InstaSliceDaemonsetReconciler) updateInstaslice(ctx context.Context, name string, mutate func(*inferencev1alpha1.Instaslice) error) (*inferencev1alpha1.Instaslice, error) {
+	typeNamespacedName := types.NamespacedName{
+		Name:      name,
+		Namespace: r.instasliceNamespace(),
+	}
+	var latest *inferencev1alpha1.Instaslice
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest = &inferencev1alpha1.Instaslice{}
+		if err := r.Get(ctx, typeNamespacedName, latest); err != nil {
+			return err
+		}
+		fetched := latest.DeepCopy()
+		if err := mutate(latest); err != nil {
+			return err
+		}
+		if equality.Semantic.DeepEqual(fetched.Status.Prepared, latest.Status.Prepared) {
+			return r.Update(ctx, latest)
+		}
+		if equality.Semantic.DeepEqual(fetched.Spec, latest.Spec) && equality.Semantic.DeepEqual(fetched.ObjectMeta, latest.ObjectMeta) {
+			return r.Status().Update(ctx, latest)
+		}
+		return r.writeInstaslice(ctx, latest)
+	})
+	if errors.Is(err, errInstasliceUnchanged) {
+		return latest, nil
+	}
+	return latest, err
+}
+
+// writeInstaslice writes the status of the instaslice, which holds the prepared entries, through the status
+// subresource and then the rest of it. The prepared entries go first so that no allocation is marked created
+// before the slice it holds is recorded. The instaslice is left as last written.
+func (r *InstaSliceDaemonsetReconciler) writeInstaslice(ctx context.Context, instaslice *inferencev1alpha1.Instaslice) error {
+	written := instaslice.DeepCopy()
+	// the update of the status hands back the spec stored before it
+	if err := r.Status().Update(ctx, instaslice); err != nil {
+		return err
+	}
+	written.ResourceVersion = instaslice.ResourceVersion
+	written.Status = instaslice.Status
+	*instaslice = *written
+	return r.Update(ctx, instaslice)
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newOverlappingUpdateReconciler returns a reconciler whose first instaslice update races with concurrent,
+// which is applied to the latest instaslice right before it, so that the update fails with a conflict.
+func newOverlappingUpdateReconciler(t *testing.T, instaslice *inferencev1alpha1.Instaslice, concurrent func(*inferencev1alpha1.Instaslice)) (*InstaSliceDaemonsetReconciler, client.Client, *int) {
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	updates := 0
+	// the concurrent writer writes the status, prepared entries included, and the rest of the instaslice
+	overlap := func(ctx context.Context, c client.WithWatch, obj client.Object) {
+		if _, ok := obj.(*inferencev1alpha1.Instaslice); !ok {
+			return
+		}
+		updates++
+		if updates == 1 {
+			var latest inferencev1alpha1.Instaslice
+			require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(obj), &latest))
+			concurrent(&latest)
+			written := latest.DeepCopy()
+			require.NoError(t, c.Status().Update(ctx, &latest))
+			written.ResourceVersion = latest.ResourceVersion
+			require.NoError(t, c.Update(ctx, written))
+		}
+	}
+	fakeClient := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(instaslice).
+		WithStatusSubresource(&inferencev1alpha1.Instaslice{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				overlap(ctx, c, obj)
+				return c.Update(ctx, obj, opts...)
+			},
+			SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+				overlap(ctx, c.(client.WithWatch), obj)
+				return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+			},
+		}).Build()
+	return &InstaSliceDaemonsetReconciler{Client: fakeClient, Scheme: s}, fakeClient, &updates
+}
+
+func overlappingUpdateInstaslice() *inferencev1alpha1.Instaslice {
+	return &inferencev1alpha1.Instaslice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"},
+		Spec: inferencev1alpha1.InstasliceSpec{
+			Allocations: map[string]inferencev1alpha1.AllocationDetails{
+				"pod-uid-a": {PodUUID: "pod-uid-a", PodName: "pod-a", Namespace: "default", Profile: "1g.5gb", Start: 0, Size: 1, Allocationstatus: inferencev1alpha1.AllocationStatusCreating},
+			},
+		},
+	}
+}
+
+func TestCreatePreparedEntrySurvivesOverlappingUpdate(t *testing.T) {
+	reconciler, fakeClient, updates := newOverlappingUpdateReconciler(t, overlappingUpdateInstaslice(), func(latest *inferencev1alpha1.Instaslice) {
+		latest.Spec.Allocations["pod-uid-b"] = inferencev1alpha1.AllocationDetails{PodUUID: "pod-uid-b", PodName: "pod-b", Namespace: "default", Profile: "1g.5gb", Start: 1, Size: 1, Allocationstatus: inferencev1alpha1.AllocationStatusCreating}
+	})
+	ctx := context.Background()
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+
+	require.NoError(t, reconciler.createPreparedEntry(ctx, "1g.5gb", "pod-uid-a", "GPU-0", 1, 0, &instaslice, "MIG-a"))
+
+	// the conflicting update was retried on top of the concurrent one
+	assert.Equal(t, 2, *updates)
+	var latest inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &latest))
+	assert.Contains(t, latest.Spec.Allocations, "pod-uid-b")
+	require.Contains(t, latest.Status.Prepared, "MIG-a")
+	assert.Equal(t, "pod-uid-a", latest.Status.Prepared["MIG-a"].PodUUID)
+	// the caller is handed the instaslice as written
+	assert.Equal(t, latest.ResourceVersion, instaslice.ResourceVersion)
+	assert.Contains(t, instaslice.Spec.Allocations, "pod-uid-b")
+}
+
+func TestUpdateInstasliceDeletionSurvivesOverlappingUpdate(t *testing.T) {
+	reconciler, fakeClient, updates := newOverlappingUpdateReconciler(t, overlappingUpdateInstaslice(), func(latest *inferencev1alpha1.Instaslice) {
+		latest.Status.Prepared = map[string]inferencev1alpha1.PreparedDetails{
+			"MIG-b": {Profile: "1g.5gb", Start: 1, Size: 1, Parent: "GPU-0", PodUUID: "pod-uid-b", Giinfoid: 2},
+		}
+	})
+	ctx := context.Background()
+
+	_, err := reconciler.updateInstaslice(ctx, "node-1", func(latest *inferencev1alpha1.Instaslice) error {
+		delete(latest.Spec.Allocations, "pod-uid-a")
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, *updates)
+	var latest inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &latest))
+	assert.NotContains(t, latest.Spec.Allocations, "pod-uid-a")
+	assert.Contains(t, latest.Status.Prepared, "MIG-b")
+}
+
+func TestUpdateInstasliceSkipsUnchanged(t *testing.T) {
+	reconciler, _, updates := newOverlappingUpdateReconciler(t, overlappingUpdateInstaslice(), func(*inferencev1alpha1.Instaslice) {})
+
+	latest, err := reconciler.updateInstaslice(context.Background(), "node-1", func(*inferencev1alpha1.Instaslice) error {
+		return errInstasliceUnchanged
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, *updates)
+	assert.Contains(t, latest.Spec.Allocations, "pod-uid-a")
+}
+
+func TestPreparedEntriesAreWrittenToStatus(t *testing.T) {
+	reconciler, fakeClient, _ := newOverlappingUpdateReconciler(t, overlappingUpdateInstaslice(), func(*inferencev1alpha1.Instaslice) {})
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+
+	require.NoError(t, reconciler.createPreparedEntry(ctx, "1g.5gb", "pod-uid-a", "GPU-0", 1, 0, &instaslice, "MIG-a"))
+
+	var latest inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &latest))
+	require.Contains(t, latest.Status.Prepared, "MIG-a")
+	assert.Equal(t, "pod-uid-a", latest.Status.Prepared["MIG-a"].PodUUID)
+
+	// a user writing the spec alone, e.g. with kubectl apply, leaves the prepared entries to the daemonset
+	edited := latest.DeepCopy()
+	edited.Status = inferencev1alpha1.InstasliceStatus{}
+	edited.Spec.ReservedSlicesPerGPU = 1
+	require.NoError(t, fakeClient.Update(ctx, edited))
+	require.NoError(t, fakeClient.Get(ctx, nsName, &latest))
+	assert.Equal(t, 1, latest.Spec.ReservedSlicesPerGPU)
+	assert.Contains(t, latest.Status.Prepared, "MIG-a")
+
+	// and the daemonset writing prepared entries leaves the spec of the user as it is
+	require.NoError(t, reconciler.createPreparedEntry(ctx, "1g.5gb", "pod-uid-a", "GPU-0", 2, 0, &instaslice, "MIG-b"))
+	require.NoError(t, fakeClient.Get(ctx, nsName, &latest))
+	assert.Equal(t, 1, latest.Spec.ReservedSlicesPerGPU)
+	assert.Contains(t, latest.Status.Prepared, "MIG-a")
+	assert.Contains(t, latest.Status.Prepared, "MIG-b")
+}
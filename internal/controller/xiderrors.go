@@ -0,0 +1,181 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+const (
+	// ReasonXidErrors is the degraded reason used when GPUs of the node reported more Xid errors than allowed.
+	ReasonXidErrors = "XidErrors"
+	// ReasonXidErrorsBelowThreshold clears the degraded condition set for ReasonXidErrors.
+	ReasonXidErrorsBelowThreshold = "XidErrorsBelowThreshold"
+)
+
+// runXidPolling periodically collects the Xid errors NVML reported for the GPUs of the node until the context is
+// cancelled.
+func (r *InstaSliceDaemonsetReconciler) runXidPolling(ctx context.Context, nodeName string) {
+	ticker := time.NewTicker(r.XidPollInterval)
+	defer ticker.Stop()
+	defer r.releaseXidEvents(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.nvmlNotReady.Load() {
+				continue
+			}
+			if err := r.pollXidErrors(ctx, r.handler().nvml, nodeName); err != nil {
+				log.FromContext(ctx).Error(err, "unable to collect the Xid errors of the GPUs")
+			}
+		}
+	}
+}
+
+// pollXidErrors counts the Xid errors reported since the last poll, publishes the counts per GPU and records them
+// in the status of the instaslice. NVML only reports the errors of GPUs registered for them, so the event set is
+// kept between polls, and created again once it fails.
+func (r *InstaSliceDaemonsetReconciler) pollXidErrors(ctx context.Context, nvmllib nvml.Interface, nodeName string) error {
+	if r.xidEvents == nil {
+		if err := r.registerXidEvents(ctx, nvmllib); err != nil {
+			return err
+		}
+	}
+	if r.xidErrors == nil {
+		r.xidErrors = make(map[string]int)
+	}
+	for {
+		data, ret := r.xidEvents.Wait(0)
+		if ret == nvml.ERROR_TIMEOUT {
+			break
+		}
+		if ret != nvml.SUCCESS {
+			r.releaseXidEvents(ctx)
+			return fmt.Errorf("unable to wait for Xid errors: %v", ret)
+		}
+		if data.EventType&nvml.EventTypeXidCriticalError == 0 || data.Device == nil {
+			continue
+		}
+		gpuUUID, ret := data.Device.GetUUID()
+		if ret != nvml.SUCCESS {
+			log.FromContext(ctx).Info("unable to get the GPU of an Xid error, not counting it", "xid", data.EventData, "error", ret)
+			continue
+		}
+		log.FromContext(ctx).Info("GPU reported an Xid error", "gpu", gpuUUID, "xid", data.EventData)
+		r.xidErrors[gpuUUID]++
+		gpuXidErrors.WithLabelValues(gpuUUID).Inc()
+	}
+	return r.recordXidErrors(ctx, nodeName)
+}
+
+// registerXidEvents registers every GPU that supports it for Xid critical errors.
+func (r *InstaSliceDaemonsetReconciler) registerXidEvents(ctx context.Context, nvmllib nvml.Interface) error {
+	set, ret := nvmllib.EventSetCreate()
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("unable to create an NVML event set: %v", ret)
+	}
+	count, ret := nvmllib.DeviceGetCount()
+	if ret != nvml.SUCCESS {
+		_ = set.Free()
+		return fmt.Errorf("unable to get device count: %v", ret)
+	}
+	for i := 0; i < count; i++ {
+		device, ret := nvmllib.DeviceGetHandleByIndex(i)
+		if ret != nvml.SUCCESS {
+			log.FromContext(ctx).Info("unable to get device, not collecting its Xid errors", "index", i, "error", ret)
+			continue
+		}
+		// the Xid errors of GPUs left to other systems are theirs to handle
+		if uuid, _ := device.GetUUID(); !r.managesGPU(i, uuid) {
+			continue
+		}
+		if ret := device.RegisterEvents(nvml.EventTypeXidCriticalError, set); ret != nvml.SUCCESS {
+			log.FromContext(ctx).Info("unable to register device for Xid errors, not collecting them", "index", i, "error", ret)
+		}
+	}
+	r.xidEvents = set
+	return nil
+}
+
+// releaseXidEvents frees the event set.
+func (r *InstaSliceDaemonsetReconciler) releaseXidEvents(ctx context.Context) {
+	if r.xidEvents == nil {
+		return
+	}
+	if ret := r.xidEvents.Free(); ret != nvml.SUCCESS {
+		log.FromContext(ctx).Error(ret, "unable to free the NVML event set")
+	}
+	r.xidEvents = nil
+}
+
+// recordXidErrors stores the Xid error counts in the status of the instaslice and, when XidErrorThreshold is set,
+// marks it degraded while a GPU reached the threshold, clearing the condition it set once none does.
+func (r *InstaSliceDaemonsetReconciler) recordXidErrors(ctx context.Context, nodeName string) error {
+	threshold := r.settings().xidErrorThreshold
+	var failing []string
+	if threshold > 0 {
+		for gpuUUID, count := range r.xidErrors {
+			if count >= threshold {
+				failing = append(failing, fmt.Sprintf("%s (%d)", gpuUUID, count))
+			}
+		}
+		sort.Strings(failing)
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var latest inferencev1alpha1.Instaslice
+		if err := r.Get(ctx, types.NamespacedName{Name: nodeName, Namespace: r.instasliceNamespace()}, &latest); err != nil {
+			return err
+		}
+		latest.Status.XidErrors = make(map[string]int, len(r.xidErrors))
+		for gpuUUID, count := range r.xidErrors {
+			latest.Status.XidErrors[gpuUUID] = count
+		}
+		latest.Status.AffectedPods = affectedPods(&latest, failingGPUs(latest.Status.XidErrors, threshold))
+		condition := meta.FindStatusCondition(latest.Status.Conditions, ConditionDegraded)
+		if len(failing) > 0 {
+			meta.SetStatusCondition(&latest.Status.Conditions, metav1.Condition{
+				Type:    ConditionDegraded,
+				Status:  metav1.ConditionTrue,
+				Reason:  ReasonXidErrors,
+				Message: fmt.Sprintf("GPUs reported at least %d Xid errors, consider cordoning them: %s", threshold, strings.Join(failing, ", ")),
+			})
+		} else if condition != nil && condition.Reason == ReasonXidErrors {
+			meta.SetStatusCondition(&latest.Status.Conditions, metav1.Condition{
+				Type:    ConditionDegraded,
+				Status:  metav1.ConditionFalse,
+				Reason:  ReasonXidErrorsBelowThreshold,
+				Message: "no GPU reported as many Xid errors as allowed",
+			})
+		}
+		return r.Status().Update(ctx, &latest)
+	})
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock/dgxa100"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	runtimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	inferencev1alpha1 "codeflare.dev/instaslice/api/v1alpha1"
+)
+
+// newXidTestReconciler returns a reconciler of two fake GPUs whose Xid errors are the events queued in the
+// returned channel.
+func newXidTestReconciler(t *testing.T, threshold int) (*InstaSliceDaemonsetReconciler, *runtimefake.ClientBuilder, []*dgxa100.Device, chan nvml.EventData) {
+	nvmllib := newFakeGPUs(2)
+	server := nvmllib.(*dgxa100.Server)
+	events := make(chan nvml.EventData, 16)
+	server.EventSetCreateFunc = func() (nvml.EventSet, nvml.Return) {
+		return &mock.EventSet{
+			WaitFunc: func(uint32) (nvml.EventData, nvml.Return) {
+				select {
+				case data := <-events:
+					return data, nvml.SUCCESS
+				default:
+					return nvml.EventData{}, nvml.ERROR_TIMEOUT
+				}
+			},
+			FreeFunc: func() nvml.Return { return nvml.SUCCESS },
+		}, nvml.SUCCESS
+	}
+	var devices []*dgxa100.Device
+	for _, handle := range server.Devices[:2] {
+		device := handle.(*dgxa100.Device)
+		device.RegisterEventsFunc = func(uint64, nvml.EventSet) nvml.Return { return nvml.SUCCESS }
+		devices = append(devices, device)
+	}
+	s := scheme.Scheme
+	_ = inferencev1alpha1.AddToScheme(s)
+	instaslice := &inferencev1alpha1.Instaslice{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "default"}}
+	builder := runtimefake.NewClientBuilder().WithScheme(s).WithObjects(instaslice).WithStatusSubresource(instaslice)
+	reconciler := &InstaSliceDaemonsetReconciler{Scheme: s, nvmlHandler: newDeviceHandler(nvmllib), XidErrorThreshold: threshold}
+	return reconciler, builder, devices, events
+}
+
+func TestElevatedXidErrorsMarkTheInstasliceDegraded(t *testing.T) {
+	reconciler, builder, devices, events := newXidTestReconciler(t, 3)
+	fakeClient := builder.Build()
+	reconciler.Client = fakeClient
+	ctx := context.Background()
+	nsName := types.NamespacedName{Name: "node-1", Namespace: "default"}
+	before := counterValue(t, gpuXidErrors.WithLabelValues(devices[0].UUID))
+
+	events <- nvml.EventData{Device: devices[0], EventType: nvml.EventTypeXidCriticalError, EventData: 79}
+	events <- nvml.EventData{Device: devices[1], EventType: nvml.EventTypeXidCriticalError, EventData: 13}
+	require.NoError(t, reconciler.pollXidErrors(ctx, reconciler.handler().nvml, "node-1"))
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, map[string]int{devices[0].UUID: 1, devices[1].UUID: 1}, instaslice.Status.XidErrors)
+	assert.Nil(t, meta.FindStatusCondition(instaslice.Status.Conditions, ConditionDegraded))
+
+	// the counts add up across polls, the event set registered on the first one is kept
+	for i := 0; i < 2; i++ {
+		events <- nvml.EventData{Device: devices[0], EventType: nvml.EventTypeXidCriticalError, EventData: 79}
+	}
+	require.NoError(t, reconciler.pollXidErrors(ctx, reconciler.handler().nvml, "node-1"))
+
+	require.NoError(t, fakeClient.Get(ctx, nsName, &instaslice))
+	assert.Equal(t, map[string]int{devices[0].UUID: 3, devices[1].UUID: 1}, instaslice.Status.XidErrors)
+	assert.Equal(t, before+3, counterValue(t, gpuXidErrors.WithLabelValues(devices[0].UUID)))
+	condition := meta.FindStatusCondition(instaslice.Status.Conditions, ConditionDegraded)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, ReasonXidErrors, condition.Reason)
+	assert.Contains(t, condition.Message, devices[0].UUID)
+	assert.NotContains(t, condition.Message, devices[1].UUID)
+}
+
+func TestXidErrorsDoNotDegradeWithoutThreshold(t *testing.T) {
+	reconciler, builder, devices, events := newXidTestReconciler(t, 0)
+	fakeClient := builder.Build()
+	reconciler.Client = fakeClient
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		events <- nvml.EventData{Device: devices[0], EventType: nvml.EventTypeXidCriticalError, EventData: 48}
+	}
+	require.NoError(t, reconciler.pollXidErrors(ctx, reconciler.handler().nvml, "node-1"))
+
+	var instaslice inferencev1alpha1.Instaslice
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Name: "node-1", Namespace: "default"}, &instaslice))
+	assert.Equal(t, 5, instaslice.Status.XidErrors[devices[0].UUID])
+	assert.Nil(t, meta.FindStatusCondition(instaslice.Status.Conditions, ConditionDegraded))
+}
@@ -45,6 +45,9 @@ var _ = Describe("controller", Ordered, func() {
 		output, err := cmd.CombinedOutput()
 		Expect(err).NotTo(HaveOccurred(), fmt.Sprintf("Failed to create Kind cluster: %s", output))
 
+		By("installing cert-manager for the webhook serving certificates")
+		Expect(utils.InstallCertManager()).To(Succeed())
+
 		By("creating manager namespace")
 		cmdNamespace := exec.Command("kubectl", "create", "ns", namespace)
 		outputNs, err := cmdNamespace.CombinedOutput()